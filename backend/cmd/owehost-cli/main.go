@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/iSundram/OweHost/internal/git"
 	"github.com/iSundram/OweHost/internal/storage/recovery"
 )
 
@@ -30,6 +31,10 @@ func main() {
 		cmdVerify(os.Args[2:])
 	case "cleanup":
 		cmdCleanup(os.Args[2:])
+	case "git-shell":
+		cmdGitShell(os.Args[2:])
+	case "git-deploy":
+		cmdGitDeploy(os.Args[2:])
 	case "help", "-h", "--help":
 		printUsage()
 	default:
@@ -53,6 +58,10 @@ Commands:
   cleanup   Clean up stale configurations
   help      Show this help message
 
+Internal commands:
+  git-shell   Serve a git request for an SSH deploy key
+  git-deploy  Deploy a pushed revision (run by the post-receive hook)
+
 Use "owehost-cli <command> -h" for more information about a command.`)
 }
 
@@ -324,3 +333,43 @@ func cmdCleanup(args []string) {
 		}
 	}
 }
+
+// cmdGitShell is the forced command for SSH deploy keys
+func cmdGitShell(args []string) {
+	fs := flag.NewFlagSet("git-shell", flag.ExitOnError)
+	repoPath := fs.String("repo", "", "Repository the key grants access to")
+	readOnly := fs.Bool("read-only", false, "Deny pushes")
+	fs.Parse(args)
+
+	if *repoPath == "" {
+		fmt.Fprintln(os.Stderr, "Error: -repo is required")
+		os.Exit(1)
+	}
+
+	if err := git.ServeSSH(*repoPath, *readOnly, os.Getenv("SSH_ORIGINAL_COMMAND")); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// cmdGitDeploy deploys a revision of a bare repository into a new release
+func cmdGitDeploy(args []string) {
+	fs := flag.NewFlagSet("git-deploy", flag.ExitOnError)
+	repoPath := fs.String("repo", "", "Bare repository path")
+	deployPath := fs.String("path", "", "Deploy path")
+	rev := fs.String("rev", "", "Revision to deploy")
+	fs.Parse(args)
+
+	if *repoPath == "" || *deployPath == "" || *rev == "" {
+		fmt.Fprintln(os.Stderr, "Error: -repo, -path and -rev are required")
+		os.Exit(1)
+	}
+
+	release, err := git.DeployRelease(*repoPath, *deployPath, *rev, os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Deploy failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Deployed %s (%s) to %s\n", release.Commit[:12], release.Message, *deployPath)
+}
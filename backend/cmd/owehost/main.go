@@ -20,6 +20,7 @@ import (
 	"github.com/iSundram/OweHost/internal/auth"
 	"github.com/iSundram/OweHost/internal/authorization"
 	"github.com/iSundram/OweHost/internal/backup"
+	"github.com/iSundram/OweHost/internal/bruteforce"
 	"github.com/iSundram/OweHost/internal/cluster"
 	"github.com/iSundram/OweHost/internal/cron"
 	"github.com/iSundram/OweHost/internal/database"
@@ -46,12 +47,14 @@ import (
 	"github.com/iSundram/OweHost/internal/ssh"
 	"github.com/iSundram/OweHost/internal/ssl"
 	"github.com/iSundram/OweHost/internal/stats"
+	"github.com/iSundram/OweHost/internal/tenancy"
 	"github.com/iSundram/OweHost/internal/twofactor"
 	"github.com/iSundram/OweHost/internal/user"
 	"github.com/iSundram/OweHost/internal/webserver"
 	"github.com/iSundram/OweHost/internal/websocket"
 	"github.com/iSundram/OweHost/pkg/config"
 	pkgdb "github.com/iSundram/OweHost/pkg/database"
+	"github.com/iSundram/OweHost/pkg/models"
 )
 
 // Server represents the OweHost API server
@@ -80,6 +83,7 @@ type Server struct {
 	backupService        *backup.Service
 	sslService           *ssl.Service
 	firewallService      *firewall.Service
+	wafWatcher           *firewall.WAFWatcher
 	cronService          *cron.Service
 	appinstallerService  *appinstaller.Service
 	pluginService        *plugin.Service
@@ -97,7 +101,13 @@ type Server struct {
 	sshService       *ssh.Service
 	gitService       *git.Service
 	statsService     *stats.Service
+	statsIngester    *stats.Ingester
+	bandwidthMonitor *accountsvc.BandwidthMonitor
 	twoFactorService *twofactor.Service
+	loginGuard       *bruteforce.Guard
+	authLogWatcher   *bruteforce.AuthLogWatcher
+	oidcProvider     *auth.OIDCProvider
+	tenancyGuard     *tenancy.Guard
 	auditService     *audit.Service
 	metricsService   *metrics.Metrics
 	wsHub            *websocket.Hub
@@ -145,22 +155,26 @@ func (s *Server) initServices() {
 
 	s.loggingService = logging.NewService()
 	s.authService = auth.NewService(s.config)
+	s.oidcProvider = auth.NewOIDCProvider(s.config.Auth.OIDC)
 	s.authorizationService = authorization.NewService()
 	s.userService = user.NewService(s.config, userRepo)
 	s.accountService = accountsvc.NewService()
 	s.packageService = packages.NewService()
 	s.featureService = feature.NewService()
 	s.resellerService = reseller.NewService()
+	s.accountService.SetResellers(s.resellerService, s.packageService)
+	s.accountService.SetUsers(s.userService)
 	s.resourceService = resource.NewService()
 	s.domainService = domain.NewService()
 	s.dnsService = dns.NewService()
 	s.webserverService = webserver.NewService()
-	s.runtimeService = runtime.NewService()
+	s.runtimeService = runtime.NewService(s.domainService)
 	s.databaseService = database.NewService()
 	s.filesystemService = filesystem.NewService()
 	s.backupService = backup.NewService()
 	s.sslService = ssl.NewService()
 	s.firewallService = firewall.NewService()
+	s.wafWatcher = firewall.NewWAFWatcher(s.firewallService)
 	s.cronService = cron.NewService()
 	s.appinstallerService = appinstaller.NewService()
 	s.pluginService = plugin.NewService()
@@ -186,9 +200,17 @@ func (s *Server) initServices() {
 	// Initialize new enhanced services
 	s.ftpService = ftp.NewService()
 	s.sshService = ssh.NewService()
-	s.gitService = git.NewService()
-	s.statsService = stats.NewService()
+	s.gitService = git.NewService(s.config, s.runtimeService)
+	s.statsService = stats.NewService(s.domainService)
+	s.statsIngester = stats.NewIngester(s.statsService)
+	s.bandwidthMonitor = accountsvc.NewBandwidthMonitor(s.accountService, s.statsService, s.notificationService)
 	s.twoFactorService = twofactor.NewService()
+	s.twoFactorService.ConfigureWebAuthn(s.config.Auth.WebAuthnRPID, "OweHost", s.config.Auth.WebAuthnOrigins)
+	s.loginGuard = bruteforce.NewGuard(s.config.Auth.BruteForce, s.firewallService, s.twoFactorService)
+	s.authLogWatcher = bruteforce.NewAuthLogWatcher(s.loginGuard)
+	s.ftpService.SetLoginGuard(s.loginGuard)
+	s.sshService.SetLoginGuard(s.loginGuard)
+	s.tenancyGuard = tenancy.NewGuard(s.userService, s.resellerService, s.accountService)
 	s.auditService = audit.NewService()
 	s.metricsService = metrics.NewMetrics()
 	s.wsHub = websocket.NewHub()
@@ -200,23 +222,28 @@ func (s *Server) setupAPIRoutes() http.Handler {
 	mux := http.NewServeMux()
 
 	// Create handlers
-	authHandler := v1.NewAuthHandler(s.authService, s.userService)
-	userHandler := v1.NewUserHandler(s.userService)
-	resellerHandler := v1.NewResellerHandler(s.resellerService, s.userService)
-	domainHandler := v1.NewDomainHandler(s.domainService, s.userService)
-	databaseHandler := v1.NewDatabaseHandler(s.databaseService, s.userService)
+	authHandler := v1.NewAuthHandler(s.authService, s.userService, s.twoFactorService, s.loginGuard)
+	if s.oidcProvider.AdminSSOOnly() {
+		authHandler.RequireSSO(models.UserRoleAdmin)
+	}
+	ssoHandler := v1.NewSSOHandler(s.oidcProvider, s.authService, s.userService, s.authorizationService, s.twoFactorService)
+	accountAuthHandler := v1.NewAccountAuthHandler(s.accountService, s.authService, s.userService, s.twoFactorService, s.loginGuard)
+	userHandler := v1.NewUserHandler(s.userService, s.tenancyGuard)
+	resellerHandler := v1.NewResellerHandler(s.resellerService, s.userService, s.accountService, s.tenancyGuard)
+	domainHandler := v1.NewDomainHandler(s.domainService, s.userService, s.tenancyGuard)
+	databaseHandler := v1.NewDatabaseHandler(s.databaseService, s.userService, s.tenancyGuard)
 	healthHandler := v1.NewHealthHandler(s.loggingService)
 	installationHandler := v1.NewInstallationHandler(s.installationService)
 	adminHandler := v1.NewAdminHandler(s.userService, s.resellerService, s.domainService, s.databaseService, s.oscontrolService)
-	dnsHandler := v1.NewDNSHandler(s.dnsService, s.domainService)
-	accountHandler := v1.NewAccountHandler(s.accountService, s.userService, s.domainService, s.dnsService)
-	packageHandler := v1.NewPackageHandler(s.packageService)
+	dnsHandler := v1.NewDNSHandler(s.dnsService, s.domainService, s.tenancyGuard)
+	accountHandler := v1.NewAccountHandler(s.accountService, s.userService, s.domainService, s.dnsService, s.tenancyGuard)
+	packageHandler := v1.NewPackageHandler(s.packageService, s.tenancyGuard)
 	featureHandler := v1.NewFeatureHandler(s.featureService)
 
 	// New handlers
-	sslHandler := v1.NewSSLHandler(s.sslService, s.userService)
-	backupHandler := v1.NewBackupHandler(s.backupService, s.userService)
-	cronHandler := v1.NewCronHandler(s.cronService, s.userService)
+	sslHandler := v1.NewSSLHandler(s.sslService, s.userService, s.domainService, s.tenancyGuard)
+	backupHandler := v1.NewBackupHandler(s.backupService, s.userService, s.tenancyGuard)
+	cronHandler := v1.NewCronHandler(s.cronService, s.userService, s.tenancyGuard)
 	filesystemHandler := v1.NewFileSystemHandler(s.filesystemService, s.userService)
 	appinstallerHandler := v1.NewAppInstallerHandler(s.appinstallerService, s.userService)
 	webserverHandler := v1.NewWebServerHandler(s.webserverService, s.userService)
@@ -230,28 +257,35 @@ func (s *Server) setupAPIRoutes() http.Handler {
 	sshHandler := v1.NewSSHHandler(s.sshService, s.userService)
 	twoFactorHandler := v1.NewTwoFactorHandler(s.twoFactorService, s.userService)
 	auditHandler := v1.NewAuditHandler(s.auditService, s.userService)
+	apiKeyHandler := v1.NewAPIKeyHandler(s.authService, s.userService)
+	sessionHandler := v1.NewSessionHandler(s.authService, s.userService)
+	impersonationHandler := v1.NewImpersonationHandler(s.authService, s.userService, s.accountService, s.tenancyGuard)
+	lockoutHandler := v1.NewLockoutHandler(s.loginGuard, s.userService)
+	authorizationHandler := v1.NewAuthorizationHandler(s.authorizationService)
 
 	// Missing handlers that need routes registered
-	statsHandler := v1.NewStatsHandler(s.statsService)
-	gitHandler := v1.NewGitHandler(s.gitService)
+	statsHandler := v1.NewStatsHandler(s.statsService, s.domainService, s.tenancyGuard)
+	gitHandler := v1.NewGitHandler(s.gitService, s.tenancyGuard)
 	clusterHandler := v1.NewClusterHandler(s.clusterService)
-	runtimeHandler := v1.NewRuntimeHandler(s.runtimeService)
+	runtimeHandler := v1.NewRuntimeHandler(s.runtimeService, s.tenancyGuard)
 	loggingHandler := v1.NewLoggingHandler(s.loggingService)
 	pluginHandler := v1.NewPluginHandler(s.pluginService)
 
-	// Helper wrappers
+	// Helper wrappers. Authenticated calls are checked against the
+	// authorization policy rules before any role check.
+	policy := middleware.PolicyMiddleware(s.authorizationService, s.userService)
 	authWrap := func(handler http.HandlerFunc) http.Handler {
-		return middleware.AuthMiddleware(s.authService)(http.HandlerFunc(handler))
+		return middleware.AuthMiddleware(s.authService)(policy(http.HandlerFunc(handler)))
 	}
 	adminWrap := func(handler http.HandlerFunc) http.Handler {
-		return middleware.AuthMiddleware(s.authService)(
+		return middleware.AuthMiddleware(s.authService)(policy(
 			middleware.AdminOnlyMiddleware(s.userService)(http.HandlerFunc(handler)),
-		)
+		))
 	}
 	adminOrResellerWrap := func(handler http.HandlerFunc) http.Handler {
-		return middleware.AuthMiddleware(s.authService)(
+		return middleware.AuthMiddleware(s.authService)(policy(
 			middleware.AdminOrResellerMiddleware(s.userService)(http.HandlerFunc(handler)),
-		)
+		))
 	}
 
 	// Installation endpoints (no auth required)
@@ -262,11 +296,34 @@ func (s *Server) setupAPIRoutes() http.Handler {
 	mux.HandleFunc("/health", healthHandler.Health)
 	mux.HandleFunc("/ready", healthHandler.Ready)
 	mux.Handle("/metrics", s.metricsService.Handler())
+	mux.HandleFunc("/.well-known/jwks.json", authHandler.JWKS)
 
 	// Auth endpoints (no auth required)
 	mux.HandleFunc("/api/v1/auth/login", authHandler.Login)
+	mux.HandleFunc("/api/v1/auth/login/verify", authHandler.VerifyLogin)
+	mux.HandleFunc("/api/v1/auth/login/setup", authHandler.SetupLogin)
+	mux.HandleFunc("/api/v1/auth/login/webauthn/begin", authHandler.BeginWebAuthnLogin)
+	mux.HandleFunc("/api/v1/auth/login/webauthn/finish", authHandler.FinishWebAuthnLogin)
+	mux.HandleFunc("/api/v1/auth/passkey/begin", authHandler.BeginPasskeyLogin)
+	mux.HandleFunc("/api/v1/auth/passkey/finish", authHandler.FinishPasskeyLogin)
+	mux.HandleFunc("/api/v1/auth/sso/login", ssoHandler.Login)
+	mux.HandleFunc("/api/v1/auth/sso/callback", ssoHandler.Callback)
 	mux.HandleFunc("/api/v1/auth/refresh", authHandler.Refresh)
 	mux.HandleFunc("/api/v1/auth/logout", authHandler.Logout)
+	mux.Handle("/api/v1/auth/keys/rotate", adminWrap(authHandler.RotateSigningKey))
+	mux.HandleFunc("/api/v1/auth/account/login", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			accountAuthHandler.Login(w, r)
+		} else {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/api/v1/auth/account/login/verify", accountAuthHandler.VerifyLogin)
+	mux.HandleFunc("/api/v1/auth/account/login/setup", accountAuthHandler.SetupLogin)
+	mux.HandleFunc("/api/v1/auth/account/login/webauthn/begin", accountAuthHandler.BeginWebAuthnLogin)
+	mux.HandleFunc("/api/v1/auth/account/login/webauthn/finish", accountAuthHandler.FinishWebAuthnLogin)
+	mux.HandleFunc("/api/v1/auth/account/passkey/begin", accountAuthHandler.BeginPasskeyLogin)
+	mux.HandleFunc("/api/v1/auth/account/passkey/finish", accountAuthHandler.FinishPasskeyLogin)
 
 	// User endpoints (protected)
 	mux.Handle("/api/v1/users", authWrap(func(w http.ResponseWriter, r *http.Request) {
@@ -293,6 +350,10 @@ func (s *Server) setupAPIRoutes() http.Handler {
 				userHandler.Terminate(w, r)
 				return
 			}
+			if action == "sessions" {
+				sessionHandler.UserSessions(w, r)
+				return
+			}
 		}
 
 		switch r.Method {
@@ -326,6 +387,73 @@ func (s *Server) setupAPIRoutes() http.Handler {
 			domainHandler.Validate(w, r)
 			return
 		}
+		// e.g. /api/v1/domains/{id}/releases[/settings|/rollback]
+		if len(parts) >= 6 && parts[5] == "releases" {
+			switch {
+			case len(parts) == 6 && r.Method == http.MethodGet:
+				runtimeHandler.ListSiteReleases(w, r)
+			case len(parts) == 6 && r.Method == http.MethodPost:
+				runtimeHandler.DeploySiteArchive(w, r)
+			case len(parts) == 7 && parts[6] == "settings" && r.Method == http.MethodPut:
+				runtimeHandler.ConfigureSiteReleases(w, r)
+			case len(parts) == 7 && parts[6] == "rollback" && r.Method == http.MethodPost:
+				runtimeHandler.RollbackSiteRelease(w, r)
+			default:
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			}
+			return
+		}
+		// e.g. /api/v1/domains/{id}/protected-paths[/users] or /api/v1/domains/{id}/access-rules
+		if len(parts) >= 6 && (parts[5] == "protected-paths" || parts[5] == "access-rules") {
+			users := len(parts) == 7 && parts[5] == "protected-paths" && parts[6] == "users"
+			switch {
+			case len(parts) == 6 && parts[5] == "protected-paths" && r.Method == http.MethodGet:
+				runtimeHandler.ListSiteProtectedPaths(w, r)
+			case len(parts) == 6 && parts[5] == "protected-paths" && r.Method == http.MethodPut:
+				runtimeHandler.ProtectSitePath(w, r)
+			case len(parts) == 6 && parts[5] == "protected-paths" && r.Method == http.MethodDelete:
+				runtimeHandler.UnprotectSitePath(w, r)
+			case users && r.Method == http.MethodPut:
+				runtimeHandler.SetSiteAuthUser(w, r)
+			case users && r.Method == http.MethodDelete:
+				runtimeHandler.DeleteSiteAuthUser(w, r)
+			case len(parts) == 6 && parts[5] == "access-rules" && r.Method == http.MethodGet:
+				runtimeHandler.GetSiteAccessRules(w, r)
+			case len(parts) == 6 && parts[5] == "access-rules" && r.Method == http.MethodPut:
+				runtimeHandler.SetSiteAccessRules(w, r)
+			default:
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			}
+			return
+		}
+		// e.g. /api/v1/domains/{id}/waf[/events|/exclusions]
+		if len(parts) >= 6 && parts[5] == "waf" {
+			switch {
+			case len(parts) == 6 && r.Method == http.MethodPut:
+				runtimeHandler.ConfigureSiteWAF(w, r)
+			case len(parts) == 7 && parts[6] == "events" && r.Method == http.MethodGet:
+				runtimeHandler.ListSiteWAFEvents(w, r)
+			case len(parts) == 7 && parts[6] == "exclusions" && r.Method == http.MethodPost:
+				runtimeHandler.ExcludeSiteWAFRule(w, r)
+			default:
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			}
+			return
+		}
+		// e.g. /api/v1/domains/{id}/cache[/purge] or /api/v1/domains/{id}/rate-limits
+		if len(parts) >= 6 && (parts[5] == "cache" || parts[5] == "rate-limits") {
+			switch {
+			case len(parts) == 6 && parts[5] == "cache" && r.Method == http.MethodPut:
+				runtimeHandler.ConfigureSiteCache(w, r)
+			case len(parts) == 7 && parts[5] == "cache" && parts[6] == "purge" && r.Method == http.MethodPost:
+				runtimeHandler.PurgeSiteCache(w, r)
+			case len(parts) == 6 && parts[5] == "rate-limits" && r.Method == http.MethodPut:
+				runtimeHandler.SetSiteRateLimits(w, r)
+			default:
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			}
+			return
+		}
 		if len(parts) >= 6 && parts[len(parts)-1] == "subdomains" {
 			if r.Method == http.MethodPost {
 				domainHandler.CreateSubdomain(w, r)
@@ -436,6 +564,18 @@ func (s *Server) setupAPIRoutes() http.Handler {
 			accountHandler.UpdateStatus(w, r)
 			return
 		}
+		if len(parts) == 6 && parts[5] == "bandwidth" {
+			accountHandler.Bandwidth(w, r)
+			return
+		}
+		if len(parts) == 6 && parts[5] == "plan" {
+			accountHandler.ChangePlan(w, r)
+			return
+		}
+		if len(parts) == 6 && parts[5] == "limits" {
+			accountHandler.UpdateLimits(w, r)
+			return
+		}
 		http.Error(w, "Not found", http.StatusNotFound)
 	}))
 
@@ -457,6 +597,28 @@ func (s *Server) setupAPIRoutes() http.Handler {
 		}
 	}))
 
+	// Reseller package endpoints (protected - admin and reseller access)
+	mux.Handle("/api/v1/reseller/packages", adminOrResellerWrap(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			packageHandler.ListResellerPackages(w, r)
+		case http.MethodPost:
+			packageHandler.CreateResellerPackage(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+	mux.Handle("/api/v1/reseller/packages/", adminOrResellerWrap(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			packageHandler.GetResellerPackage(w, r)
+		case http.MethodDelete:
+			packageHandler.DeleteResellerPackage(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+
 	// Feature manager endpoints (admin only)
 	mux.Handle("/api/v1/features", adminWrap(func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
@@ -556,6 +718,10 @@ func (s *Server) setupAPIRoutes() http.Handler {
 	mux.Handle("/api/v1/admin/services", adminWrap(adminHandler.GetServiceStatus))
 	mux.Handle("/api/v1/resellers/me", authWrap(resellerHandler.GetByUserID))
 	mux.Handle("/api/v1/resellers/", adminOrResellerWrap(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(strings.TrimSuffix(r.URL.Path, "/"), "/usage") {
+			resellerHandler.Usage(w, r)
+			return
+		}
 		switch r.Method {
 		case http.MethodGet:
 			resellerHandler.Get(w, r)
@@ -986,6 +1152,51 @@ func (s *Server) setupAPIRoutes() http.Handler {
 	}))
 	mux.Handle("/api/v1/firewall/blocked-ips", adminWrap(firewallHandler.GetBlockedIPs))
 
+	// Login lockout endpoints (admin only)
+	mux.Handle("/api/v1/security/lockouts", adminWrap(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			lockoutHandler.List(w, r)
+		} else {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+	mux.Handle("/api/v1/security/lockouts/unlock", adminWrap(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			lockoutHandler.Unlock(w, r)
+		} else {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+
+	// Authorization policy endpoints (admin only)
+	mux.Handle("/api/v1/authorization/policies", adminWrap(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			authorizationHandler.ListPolicies(w, r)
+		case http.MethodPost:
+			authorizationHandler.CreatePolicy(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+	mux.Handle("/api/v1/authorization/policies/", adminWrap(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPatch:
+			authorizationHandler.UpdatePolicy(w, r)
+		case http.MethodDelete:
+			authorizationHandler.DeletePolicy(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+	mux.Handle("/api/v1/authorization/check", adminWrap(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			authorizationHandler.Check(w, r)
+		} else {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+
 	// Resource endpoints
 	mux.Handle("/api/v1/resources/usage", authWrap(resourceHandler.GetUsage))
 	mux.Handle("/api/v1/resources/limits", authWrap(func(w http.ResponseWriter, r *http.Request) {
@@ -1150,6 +1361,94 @@ func (s *Server) setupAPIRoutes() http.Handler {
 		}
 	}))
 	mux.Handle("/api/v1/2fa/login-attempts", authWrap(twoFactorHandler.GetLoginAttempts))
+	mux.Handle("/api/v1/2fa/devices", authWrap(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			twoFactorHandler.ForgetDevices(w, r)
+		} else {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+	mux.Handle("/api/v1/2fa/webauthn/register/begin", authWrap(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			twoFactorHandler.BeginWebAuthnRegistration(w, r)
+		} else {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+	mux.Handle("/api/v1/2fa/webauthn/register/finish", authWrap(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			twoFactorHandler.FinishWebAuthnRegistration(w, r)
+		} else {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+	mux.Handle("/api/v1/2fa/webauthn/credentials", authWrap(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			twoFactorHandler.ListWebAuthnCredentials(w, r)
+		} else {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+	mux.Handle("/api/v1/2fa/webauthn/credentials/", authWrap(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPatch:
+			twoFactorHandler.RenameWebAuthnCredential(w, r)
+		case http.MethodDelete:
+			twoFactorHandler.DeleteWebAuthnCredential(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+	mux.Handle("/api/v1/2fa/policy", adminWrap(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			twoFactorHandler.GetPolicy(w, r)
+		case http.MethodPut:
+			twoFactorHandler.SetPolicy(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+
+	// API key endpoints
+	mux.Handle("/api/v1/api-keys", authWrap(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			apiKeyHandler.List(w, r)
+		case http.MethodPost:
+			apiKeyHandler.Create(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+	mux.Handle("/api/v1/api-keys/", authWrap(apiKeyHandler.Revoke))
+
+	// Session endpoints
+	mux.Handle("/api/v1/sessions", authWrap(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			sessionHandler.List(w, r)
+		case http.MethodDelete:
+			sessionHandler.RevokeOthers(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+	mux.Handle("/api/v1/sessions/", authWrap(sessionHandler.Revoke))
+
+	// Impersonation endpoints
+	mux.Handle("/api/v1/impersonate", authWrap(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			impersonationHandler.Status(w, r)
+		case http.MethodPost:
+			impersonationHandler.Start(w, r)
+		case http.MethodDelete:
+			impersonationHandler.End(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
 
 	// Audit Log endpoints (admin only)
 	mux.Handle("/api/v1/audit/logs", adminWrap(auditHandler.ListLogs))
@@ -1171,6 +1470,7 @@ func (s *Server) setupAPIRoutes() http.Handler {
 	mux.Handle("/api/v1/stats/errors/", authWrap(statsHandler.GetErrorLogs))
 	mux.Handle("/api/v1/stats/access/", authWrap(statsHandler.GetAccessLogs))
 	mux.Handle("/api/v1/stats/domain/", authWrap(statsHandler.GetDomainSummary))
+	mux.Handle("/api/v1/stats/traffic/", authWrap(statsHandler.GetTrafficBreakdown))
 	mux.Handle("/api/v1/stats/resources", authWrap(statsHandler.GetResourceStats))
 	mux.Handle("/api/v1/stats/user/summary", authWrap(statsHandler.GetUserSummary))
 	mux.Handle("/api/v1/stats/user/", adminWrap(statsHandler.GetUserResourceStats))
@@ -1206,6 +1506,11 @@ func (s *Server) setupAPIRoutes() http.Handler {
 					gitHandler.Deploy(w, r)
 					return
 				}
+			case "deployments":
+				if r.Method == http.MethodGet {
+					gitHandler.ListDeployments(w, r)
+					return
+				}
 			case "branches":
 				if r.Method == http.MethodGet {
 					gitHandler.GetBranches(w, r)
@@ -1262,6 +1567,12 @@ func (s *Server) setupAPIRoutes() http.Handler {
 		}
 	}))
 
+	// Git provider push webhooks (verified by webhook secret, not panel auth)
+	mux.HandleFunc(git.WebhookPathPrefix, gitHandler.ReceiveWebhook)
+
+	// Git smart HTTP (clone/fetch/push), authenticated with account credentials
+	mux.HandleFunc(git.HTTPPathPrefix, gitHandler.SmartHTTP)
+
 	// Cluster endpoints (admin only)
 	mux.Handle("/api/v1/cluster/nodes", adminWrap(func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
@@ -1422,9 +1733,21 @@ func (s *Server) setupAPIRoutes() http.Handler {
 	}))
 	mux.Handle("/api/v1/runtime/python/apps/", authWrap(func(w http.ResponseWriter, r *http.Request) {
 		parts := strings.Split(strings.TrimSuffix(r.URL.Path, "/"), "/")
-		if len(parts) >= 8 && parts[len(parts)-1] == "virtualenv" && r.Method == http.MethodPost {
-			runtimeHandler.ProvisionVirtualenv(w, r)
-			return
+		if len(parts) >= 8 && r.Method == http.MethodPost {
+			switch parts[len(parts)-1] {
+			case "virtualenv":
+				runtimeHandler.ProvisionVirtualenv(w, r)
+				return
+			case "start":
+				runtimeHandler.StartPythonApp(w, r)
+				return
+			case "stop":
+				runtimeHandler.StopPythonApp(w, r)
+				return
+			case "restart":
+				runtimeHandler.RestartPythonApp(w, r)
+				return
+			}
 		}
 		switch r.Method {
 		case http.MethodGet:
@@ -1570,6 +1893,7 @@ func (s *Server) setupAPIRoutes() http.Handler {
 	handler = middleware.LoggingMiddleware(s.loggingService)(handler)
 	handler = metrics.MetricsMiddleware(s.metricsService)(handler)
 	handler = middleware.UserRateLimitMiddleware(s.rateLimiter)(handler)
+	handler = middleware.ClientIPMiddleware(s.config.Server.TrustedProxies)(handler)
 	handler = middleware.CORSMiddleware(handler)
 	handler = middleware.ContentTypeMiddleware(handler)
 	handler = middleware.RecoveryMiddleware(s.loggingService)(handler)
@@ -1588,6 +1912,31 @@ func (s *Server) Start() error {
 		WriteTimeout: s.config.Server.WriteTimeout,
 	}
 
+	// Bring supervised Node.js/Python apps back after a panel restart
+	if err := s.runtimeService.Reconcile(); err != nil {
+		s.loggingService.Warn("runtime", fmt.Sprintf("App reconcile incomplete: %v", err))
+	}
+
+	// Reload git repositories, deploy keys and webhooks
+	if err := s.gitService.Load(); err != nil {
+		s.loggingService.Warn("git", fmt.Sprintf("Git state reload incomplete: %v", err))
+	}
+
+	// Report web application firewall matches as intrusion events
+	s.wafWatcher.Start()
+
+	// Ban addresses that keep failing to log in, to the panel or to the
+	// FTP and SSH servers
+	s.loginGuard.Start()
+	s.authLogWatcher.Start()
+
+	// Build traffic statistics from the sites' access logs and the FTP and
+	// SFTP transfer logs
+	s.statsIngester.Start()
+
+	// Hold accounts to their monthly bandwidth
+	s.bandwidthMonitor.Start()
+
 	s.loggingService.Info("server", fmt.Sprintf("Starting OweHost API server on %s:%d", s.config.Server.Host, s.config.Server.Port))
 	s.loggingService.Info("server", "User Panel frontend should run on port 2083")
 	s.loggingService.Info("server", "Admin Panel frontend should run on port 2087")
@@ -1599,6 +1948,12 @@ func (s *Server) Start() error {
 func (s *Server) Shutdown(ctx context.Context) error {
 	s.loggingService.Info("server", "Shutting down server...")
 
+	s.wafWatcher.Stop()
+	s.authLogWatcher.Stop()
+	s.loginGuard.Stop()
+	s.statsIngester.Stop()
+	s.bandwidthMonitor.Stop()
+
 	var err error
 	if s.api != nil {
 		err = s.api.Shutdown(ctx)
@@ -1641,10 +1996,14 @@ func (s *Server) setupAdminPanelRoutes() http.Handler {
 	mux := http.NewServeMux()
 
 	// Create handlers
-	authHandler := v1.NewAuthHandler(s.authService, s.userService)
-	userHandler := v1.NewUserHandler(s.userService)
-	domainHandler := v1.NewDomainHandler(s.domainService, s.userService)
-	databaseHandler := v1.NewDatabaseHandler(s.databaseService, s.userService)
+	authHandler := v1.NewAuthHandler(s.authService, s.userService, s.twoFactorService, s.loginGuard)
+	if s.oidcProvider.AdminSSOOnly() {
+		authHandler.RequireSSO(models.UserRoleAdmin)
+	}
+	ssoHandler := v1.NewSSOHandler(s.oidcProvider, s.authService, s.userService, s.authorizationService, s.twoFactorService)
+	userHandler := v1.NewUserHandler(s.userService, s.tenancyGuard)
+	domainHandler := v1.NewDomainHandler(s.domainService, s.userService, s.tenancyGuard)
+	databaseHandler := v1.NewDatabaseHandler(s.databaseService, s.userService, s.tenancyGuard)
 	healthHandler := v1.NewHealthHandler(s.loggingService)
 	installationHandler := v1.NewInstallationHandler(s.installationService)
 
@@ -1654,9 +2013,18 @@ func (s *Server) setupAdminPanelRoutes() http.Handler {
 
 	// Health endpoints
 	mux.HandleFunc("/health", healthHandler.Health)
+	mux.HandleFunc("/.well-known/jwks.json", authHandler.JWKS)
 
 	// Auth endpoints
 	mux.HandleFunc("/api/v1/auth/login", authHandler.Login)
+	mux.HandleFunc("/api/v1/auth/login/verify", authHandler.VerifyLogin)
+	mux.HandleFunc("/api/v1/auth/login/setup", authHandler.SetupLogin)
+	mux.HandleFunc("/api/v1/auth/login/webauthn/begin", authHandler.BeginWebAuthnLogin)
+	mux.HandleFunc("/api/v1/auth/login/webauthn/finish", authHandler.FinishWebAuthnLogin)
+	mux.HandleFunc("/api/v1/auth/passkey/begin", authHandler.BeginPasskeyLogin)
+	mux.HandleFunc("/api/v1/auth/passkey/finish", authHandler.FinishPasskeyLogin)
+	mux.HandleFunc("/api/v1/auth/sso/login", ssoHandler.Login)
+	mux.HandleFunc("/api/v1/auth/sso/callback", ssoHandler.Callback)
 	mux.HandleFunc("/api/v1/auth/refresh", authHandler.Refresh)
 	mux.HandleFunc("/api/v1/auth/logout", authHandler.Logout)
 
@@ -1696,6 +2064,7 @@ func (s *Server) setupAdminPanelRoutes() http.Handler {
 	var handler http.Handler = mux
 	handler = middleware.RequestIDMiddleware(handler)
 	handler = middleware.LoggingMiddleware(s.loggingService)(handler)
+	handler = middleware.ClientIPMiddleware(s.config.Server.TrustedProxies)(handler)
 	handler = middleware.CORSMiddleware(handler)
 	handler = middleware.ContentTypeMiddleware(handler)
 	handler = middleware.RecoveryMiddleware(s.loggingService)(handler)
@@ -0,0 +1,323 @@
+// Package accountsvc provides account management using filesystem-based storage
+package accountsvc
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/iSundram/OweHost/internal/notification"
+	"github.com/iSundram/OweHost/internal/stats"
+	"github.com/iSundram/OweHost/internal/storage/account"
+	"github.com/iSundram/OweHost/internal/storage/events"
+	"github.com/iSundram/OweHost/pkg/models"
+)
+
+// bandwidthInterval is how often usage is compared with the limits
+const bandwidthInterval = 5 * time.Minute
+
+// bandwidthWarnings are the usage levels, in percent of the monthly
+// limit, that are warned about once per billing period
+var bandwidthWarnings = []int{80, 95}
+
+// BandwidthUsage is an account's bandwidth in its current billing period
+type BandwidthUsage struct {
+	*account.BandwidthPeriod
+	LimitGB     int    `json:"limit_gb"` // -1 for unlimited
+	Percent     int    `json:"percent"`
+	BillingDay  int    `json:"billing_day"`
+	Action      string `json:"action"`
+	Restriction string `json:"restriction,omitempty"` // Over-quota action in force
+}
+
+// billingPeriod returns the billing period now falls in. Periods start at
+// midnight UTC on the billing day, by default the day the account was
+// created.
+func billingPeriod(identity *account.AccountIdentity, limits *account.ResourceLimits, now time.Time) (time.Time, time.Time) {
+	day := limits.BillingDay
+	if day == 0 {
+		day = 1
+		if created, err := time.Parse(time.RFC3339, identity.CreatedAt); err == nil {
+			day = created.UTC().Day()
+		}
+		if day > 28 {
+			day = 28
+		}
+	}
+
+	now = now.UTC()
+	start := time.Date(now.Year(), now.Month(), day, 0, 0, 0, 0, time.UTC)
+	if start.After(now) {
+		start = start.AddDate(0, -1, 0)
+	}
+	return start, start.AddDate(0, 1, 0)
+}
+
+// bandwidthAction returns what happens when an account exceeds its limit
+func bandwidthAction(limits *account.ResourceLimits) string {
+	if limits.BandwidthAction == "" {
+		return account.BandwidthActionAlert
+	}
+	return limits.BandwidthAction
+}
+
+// GetBandwidth returns an account's bandwidth in its current billing period
+func (s *Service) GetBandwidth(ctx context.Context, accountID int) (*BandwidthUsage, error) {
+	identity, err := s.accountState.ReadIdentity(accountID)
+	if err != nil {
+		return nil, err
+	}
+	limits, err := s.accountState.ReadLimits(accountID)
+	if err != nil {
+		return nil, err
+	}
+	status, err := s.accountState.ReadStatus(accountID)
+	if err != nil {
+		return nil, err
+	}
+	period, err := s.accountState.ReadBandwidth(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	start, end := billingPeriod(identity, limits, time.Now())
+	if period == nil || period.Start != start.Format(time.RFC3339) {
+		// Not measured in this period yet
+		period = &account.BandwidthPeriod{Start: start.Format(time.RFC3339), End: end.Format(time.RFC3339)}
+	}
+
+	usage := &BandwidthUsage{
+		BandwidthPeriod: period,
+		LimitGB:         limits.Bandwidth,
+		BillingDay:      limits.BillingDay,
+		Action:          bandwidthAction(limits),
+		Restriction:     status.BandwidthRestriction,
+	}
+	if limits.Bandwidth > 0 {
+		usage.Percent = int(period.TotalBytes() * 100 / (int64(limits.Bandwidth) << 30))
+	}
+	return usage, nil
+}
+
+// ConfigureBandwidth sets an account's billing day and over-quota action.
+// An account already over its limit gets the new action right away.
+func (s *Service) ConfigureBandwidth(ctx context.Context, accountID, billingDay int, action, actor, actorType string) error {
+	limits, err := s.accountState.ReadLimits(accountID)
+	if err != nil {
+		return err
+	}
+	limits.BillingDay = billingDay
+	limits.BandwidthAction = action
+	if err := account.ValidateLimits(limits); err != nil {
+		return err
+	}
+	if err := s.accountState.WriteLimits(accountID, limits); err != nil {
+		return err
+	}
+
+	s.events.EmitSuccess(events.EventAccountUpdate, events.EmitOptions{
+		AccountID: accountID,
+		Actor:     actor,
+		ActorType: actorType,
+		Data: map[string]interface{}{
+			"action":           "configure_bandwidth",
+			"billing_day":      billingDay,
+			"bandwidth_action": action,
+		},
+	})
+
+	period, err := s.accountState.ReadBandwidth(accountID)
+	if err != nil {
+		return err
+	}
+	return s.reconcileBandwidth(accountID, period != nil && period.Exceeded, limits)
+}
+
+// reconcileBandwidth puts the restriction an account's usage calls for in
+// force, or lifts it
+func (s *Service) reconcileBandwidth(accountID int, exceeded bool, limits *account.ResourceLimits) error {
+	status, err := s.accountState.ReadStatus(accountID)
+	if err != nil {
+		return err
+	}
+
+	want := ""
+	if action := bandwidthAction(limits); exceeded && action != account.BandwidthActionAlert {
+		want = action
+	}
+	if status.BandwidthRestriction == want {
+		return nil
+	}
+
+	opts := events.EmitOptions{
+		AccountID: accountID,
+		Actor:     "system",
+		ActorType: "system",
+		Data:      map[string]interface{}{"action": want},
+	}
+	if want == "" {
+		if err := s.accountApply.LiftBandwidthRestriction(accountID); err != nil {
+			s.events.EmitFailed(events.EventBandwidthRestore, err.Error(), opts)
+			return err
+		}
+		s.events.EmitSuccess(events.EventBandwidthRestore, opts)
+		return nil
+	}
+	if err := s.accountApply.RestrictBandwidth(accountID, want); err != nil {
+		s.events.EmitFailed(events.EventBandwidthRestrict, err.Error(), opts)
+		return err
+	}
+	s.events.EmitSuccess(events.EventBandwidthRestrict, opts)
+	return nil
+}
+
+// trafficMeter measures what accounts sent to clients
+type trafficMeter interface {
+	AccountBytesOut(accountID int, start, end time.Time) (*stats.AccountBytes, error)
+}
+
+// BandwidthMonitor measures each account's traffic against its monthly
+// limit. It warns as usage passes 80 and 95 percent and applies the
+// account's over-quota action once the limit is reached; a new billing
+// period or a raised limit lifts it again.
+type BandwidthMonitor struct {
+	accounts *Service
+	stats    trafficMeter
+	notify   *notification.Service
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// NewBandwidthMonitor creates a monitor of the accounts' bandwidth
+func NewBandwidthMonitor(accounts *Service, stats *stats.Service, notify *notification.Service) *BandwidthMonitor {
+	return &BandwidthMonitor{
+		accounts: accounts,
+		stats:    stats,
+		notify:   notify,
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// Start checks the accounts in the background
+func (m *BandwidthMonitor) Start() {
+	go m.run()
+}
+
+// Stop stops checking the accounts
+func (m *BandwidthMonitor) Stop() {
+	m.stopOnce.Do(func() { close(m.stopCh) })
+}
+
+func (m *BandwidthMonitor) run() {
+	ticker := time.NewTicker(bandwidthInterval)
+	defer ticker.Stop()
+
+	for {
+		m.Check()
+		select {
+		case <-m.stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check measures every account once
+func (m *BandwidthMonitor) Check() {
+	ids, err := m.accounts.accountState.ListAccounts()
+	if err != nil {
+		return
+	}
+	for _, id := range ids {
+		if err := m.checkAccount(id, time.Now()); err != nil {
+			fmt.Printf("warning: failed to check bandwidth of account %d: %v\n", id, err)
+		}
+	}
+}
+
+// checkAccount updates an account's bandwidth.json and acts on it. The
+// period is saved before any restriction is applied, so a web server
+// refusing the change is retried on the next check without warning twice.
+func (m *BandwidthMonitor) checkAccount(accountID int, now time.Time) error {
+	state := m.accounts.accountState
+	identity, err := state.ReadIdentity(accountID)
+	if err != nil {
+		return err
+	}
+	if identity.State == account.StateTerminated {
+		return nil
+	}
+	limits, err := state.ReadLimits(accountID)
+	if err != nil {
+		return err
+	}
+	period, err := state.ReadBandwidth(accountID)
+	if err != nil {
+		return err
+	}
+
+	start, end := billingPeriod(identity, limits, now)
+	if period == nil || period.Start != start.Format(time.RFC3339) {
+		period = &account.BandwidthPeriod{Start: start.Format(time.RFC3339), End: end.Format(time.RFC3339)}
+	}
+	used, err := m.stats.AccountBytesOut(accountID, start, now)
+	if err != nil {
+		return err
+	}
+	period.HTTPBytes, period.FTPBytes, period.SFTPBytes = used.HTTP, used.FTP, used.SFTP
+
+	// A limit of 0 was never set; like -1 it doesn't limit anything
+	exceeded, level := false, 0
+	if limits.Bandwidth > 0 {
+		limit := int64(limits.Bandwidth) << 30
+		exceeded = period.TotalBytes() >= limit
+		percent := int(period.TotalBytes() * 100 / limit)
+		for _, w := range bandwidthWarnings {
+			if percent >= w {
+				level = w
+			}
+		}
+	}
+
+	data := map[string]interface{}{
+		"account_id":   accountID,
+		"account_name": identity.Name,
+		"used_bytes":   period.TotalBytes(),
+		"limit_gb":     limits.Bandwidth,
+		"period_start": period.Start,
+		"period_end":   period.End,
+	}
+	// Going straight over the limit only sends the exceeded notice
+	if level > period.Warned && !exceeded {
+		data["percent"] = level
+		m.publish(events.EventBandwidthWarning, identity, data)
+	}
+	if exceeded && !period.Exceeded {
+		data["action"] = bandwidthAction(limits)
+		m.publish(events.EventBandwidthExceeded, identity, data)
+	}
+	// Falling below a level again, after the limit was raised, rearms it
+	period.Warned = level
+	period.Exceeded = exceeded
+	period.UpdatedAt = now.Format(time.RFC3339)
+
+	if err := state.WriteBandwidth(accountID, period); err != nil {
+		return err
+	}
+	return m.accounts.reconcileBandwidth(accountID, exceeded, limits)
+}
+
+// publish records a bandwidth event and sends it to the webhooks
+// subscribed to it
+func (m *BandwidthMonitor) publish(eventType events.EventType, identity *account.AccountIdentity, data map[string]interface{}) {
+	m.accounts.events.EmitSuccess(eventType, events.EmitOptions{
+		AccountID: identity.ID,
+		Actor:     "system",
+		ActorType: "system",
+		Data:      data,
+	})
+	if m.notify != nil {
+		m.notify.Publish(models.EventType(eventType), "bandwidth", identity.Name, data)
+	}
+}
@@ -0,0 +1,251 @@
+package accountsvc
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/iSundram/OweHost/internal/stats"
+	"github.com/iSundram/OweHost/internal/storage/account"
+	"github.com/iSundram/OweHost/internal/storage/events"
+)
+
+const gb = int64(1) << 30
+
+// fakeMeter reports the same traffic for any period
+type fakeMeter struct {
+	used  stats.AccountBytes
+	start time.Time // Start of the last period measured
+}
+
+func (m *fakeMeter) AccountBytesOut(accountID int, start, end time.Time) (*stats.AccountBytes, error) {
+	m.start = start
+	used := m.used
+	return &used, nil
+}
+
+// fakeEnforcer records the restriction the web server was told to apply
+type fakeEnforcer struct {
+	action string
+	err    error
+}
+
+func (e *fakeEnforcer) EnforceBandwidth(accountID int, action string) error {
+	if e.err != nil {
+		return e.err
+	}
+	e.action = action
+	return nil
+}
+
+// bandwidthFixture is a monitor of account 1, alice, with a 10 GB limit
+type bandwidthFixture struct {
+	monitor  *BandwidthMonitor
+	state    *account.StateManager
+	meter    *fakeMeter
+	enforcer *fakeEnforcer
+	events   *events.Store
+}
+
+func newBandwidthFixture(t *testing.T, action string) *bandwidthFixture {
+	t.Helper()
+	dir := t.TempDir()
+	f := &bandwidthFixture{
+		state:    account.NewStateManagerWithPath(filepath.Join(dir, "accounts")),
+		meter:    &fakeMeter{},
+		enforcer: &fakeEnforcer{},
+		events:   events.NewStoreWithPath(filepath.Join(dir, "events"), filepath.Join(dir, "alerts")),
+	}
+	apply := account.NewApplierWithState(f.state)
+	apply.SetBandwidthEnforcer(f.enforcer)
+	s := &Service{
+		accountState: f.state,
+		accountApply: apply,
+		events:       events.NewEmitterWithStore(f.events, "test"),
+	}
+	f.monitor = &BandwidthMonitor{accounts: s, stats: f.meter}
+
+	if err := f.state.CreateAccountStructure(1); err != nil {
+		t.Fatal(err)
+	}
+	identity := &account.AccountIdentity{ID: 1, Name: "alice", State: account.StateActive, CreatedAt: "2026-01-31T10:00:00Z"}
+	if err := f.state.WriteIdentity(1, identity); err != nil {
+		t.Fatal(err)
+	}
+	f.setLimits(t, 10, action)
+	return f
+}
+
+func (f *bandwidthFixture) setLimits(t *testing.T, limitGB int, action string) {
+	t.Helper()
+	limits := &account.ResourceLimits{Bandwidth: limitGB, BillingDay: 15, BandwidthAction: action}
+	if err := f.state.WriteLimits(1, limits); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// count returns how many events of a type were recorded
+func (f *bandwidthFixture) count(t *testing.T, eventType events.EventType) int {
+	t.Helper()
+	recorded, err := f.events.Query(events.EventFilters{Type: &eventType})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(recorded)
+}
+
+func TestBillingPeriod(t *testing.T) {
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name       string
+		createdAt  string
+		billingDay int
+		now        time.Time
+		start      time.Time
+	}{
+		{"after the billing day", "", 15, date(2026, 3, 20), date(2026, 3, 15)},
+		{"before the billing day", "", 15, date(2026, 3, 10), date(2026, 2, 15)},
+		{"on the billing day", "", 15, date(2026, 3, 15), date(2026, 3, 15)},
+		{"just before midnight", "", 15, date(2026, 3, 15).Add(-time.Second), date(2026, 2, 15)},
+		{"across the year", "", 28, date(2026, 1, 5), date(2025, 12, 28)},
+		{"local time past midnight UTC", "", 15, time.Date(2026, 3, 15, 1, 0, 0, 0, time.FixedZone("UTC+5", 5*3600)), date(2026, 2, 15)},
+		{"creation day", "2025-11-09T16:20:00Z", 0, date(2026, 3, 20), date(2026, 3, 9)},
+		{"creation day in UTC", "2025-11-09T22:00:00-05:00", 0, date(2026, 3, 20), date(2026, 3, 10)},
+		{"created past the 28th", "2026-01-31T10:00:00Z", 0, date(2026, 2, 27), date(2026, 1, 28)},
+		{"created past the 28th, February", "2026-01-31T10:00:00Z", 0, date(2026, 2, 28), date(2026, 2, 28)},
+		{"created on the 29th", "2024-02-29T10:00:00Z", 0, date(2026, 3, 1), date(2026, 2, 28)},
+		{"creation day unknown", "not a time", 0, date(2026, 3, 20), date(2026, 3, 1)},
+	}
+	for _, tt := range tests {
+		identity := &account.AccountIdentity{CreatedAt: tt.createdAt}
+		limits := &account.ResourceLimits{BillingDay: tt.billingDay}
+		start, end := billingPeriod(identity, limits, tt.now)
+		if !start.Equal(tt.start) || !end.Equal(tt.start.AddDate(0, 1, 0)) {
+			t.Errorf("%s: expected %v to %v, got %v to %v", tt.name, tt.start, tt.start.AddDate(0, 1, 0), start, end)
+		}
+	}
+}
+
+func TestCheckAccount(t *testing.T) {
+	f := newBandwidthFixture(t, account.BandwidthActionThrottle)
+	march := time.Date(2026, 3, 20, 12, 0, 0, 0, time.UTC)
+	april := time.Date(2026, 4, 20, 12, 0, 0, 0, time.UTC)
+
+	steps := []struct {
+		name        string
+		used        int64
+		limitGB     int
+		now         time.Time
+		warnings    int // Warnings sent so far
+		exceeded    int // Exceeded notices sent so far
+		warned      int
+		restriction string
+	}{
+		{"under 80%", 7 * gb, 10, march, 0, 0, 0, ""},
+		{"past 80%", 8 * gb, 10, march, 1, 0, 80, ""},
+		{"still past 80%", 85 * gb / 10, 10, march, 1, 0, 80, ""},
+		{"past 95%", 96 * gb / 10, 10, march, 2, 0, 95, ""},
+		{"limit reached", 10 * gb, 10, march, 2, 1, 95, account.BandwidthActionThrottle},
+		{"still over", 11 * gb, 10, march, 2, 1, 95, account.BandwidthActionThrottle},
+		{"limit raised", 11 * gb, 20, march, 2, 1, 0, ""},
+		{"80% again", 165 * gb / 10, 20, march, 3, 1, 80, ""},
+		{"over again", 20 * gb, 20, march, 3, 2, 95, account.BandwidthActionThrottle},
+		{"new billing period", 1 * gb, 20, april, 3, 2, 0, ""},
+		{"straight over", 25 * gb, 20, april, 3, 3, 95, account.BandwidthActionThrottle},
+		{"unlimited", 25 * gb, -1, april, 3, 3, 0, ""},
+	}
+	for _, step := range steps {
+		f.setLimits(t, step.limitGB, account.BandwidthActionThrottle)
+		f.meter.used = stats.AccountBytes{HTTP: step.used / 2, FTP: step.used / 4, SFTP: step.used - step.used/2 - step.used/4}
+		if err := f.monitor.checkAccount(1, step.now); err != nil {
+			t.Fatalf("%s: check failed: %v", step.name, err)
+		}
+
+		period, err := f.state.ReadBandwidth(1)
+		if err != nil || period == nil {
+			t.Fatalf("%s: expected the period saved, got %v", step.name, err)
+		}
+		if period.TotalBytes() != step.used || period.Warned != step.warned {
+			t.Errorf("%s: expected %d bytes warned at %d, got %+v", step.name, step.used, step.warned, period)
+		}
+		if got := f.count(t, events.EventBandwidthWarning); got != step.warnings {
+			t.Errorf("%s: expected %d warnings, got %d", step.name, step.warnings, got)
+		}
+		if got := f.count(t, events.EventBandwidthExceeded); got != step.exceeded {
+			t.Errorf("%s: expected %d exceeded notices, got %d", step.name, step.exceeded, got)
+		}
+		status, err := f.state.ReadStatus(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.BandwidthRestriction != step.restriction || f.enforcer.action != step.restriction {
+			t.Errorf("%s: expected restriction %q, got %q (web server %q)", step.name, step.restriction, status.BandwidthRestriction, f.enforcer.action)
+		}
+	}
+
+	if want := time.Date(2026, 4, 15, 0, 0, 0, 0, time.UTC); !f.meter.start.Equal(want) {
+		t.Errorf("Expected traffic measured from %v, got %v", want, f.meter.start)
+	}
+	if got := f.count(t, events.EventBandwidthRestore); got != 3 {
+		t.Errorf("Expected 3 restrictions lifted, got %d", got)
+	}
+}
+
+func TestCheckAccount_Actions(t *testing.T) {
+	now := time.Date(2026, 3, 20, 12, 0, 0, 0, time.UTC)
+
+	// An alert is only a notice
+	f := newBandwidthFixture(t, account.BandwidthActionAlert)
+	f.meter.used = stats.AccountBytes{HTTP: 12 * gb}
+	if err := f.monitor.checkAccount(1, now); err != nil {
+		t.Fatal(err)
+	}
+	status, _ := f.state.ReadStatus(1)
+	if f.count(t, events.EventBandwidthExceeded) != 1 || status.BandwidthRestriction != "" {
+		t.Errorf("Expected a notice and no restriction, got restriction %q", status.BandwidthRestriction)
+	}
+
+	// A web server refusing the restriction is retried without a second
+	// notice
+	f = newBandwidthFixture(t, account.BandwidthActionSuspend)
+	f.meter.used = stats.AccountBytes{HTTP: 12 * gb}
+	f.enforcer.err = errors.New("nginx -t failed")
+	if err := f.monitor.checkAccount(1, now); err == nil {
+		t.Error("Expected the enforcer's error")
+	}
+	if period, _ := f.state.ReadBandwidth(1); period == nil || !period.Exceeded {
+		t.Errorf("Expected the period saved before restricting, got %+v", period)
+	}
+	f.enforcer.err = nil
+	if err := f.monitor.checkAccount(1, now); err != nil {
+		t.Fatal(err)
+	}
+	status, _ = f.state.ReadStatus(1)
+	if status.BandwidthRestriction != account.BandwidthActionSuspend {
+		t.Errorf("Expected the restriction applied on the next check, got %q", status.BandwidthRestriction)
+	}
+	if got := f.count(t, events.EventBandwidthExceeded); got != 1 {
+		t.Errorf("Expected one exceeded notice, got %d", got)
+	}
+	if got := f.count(t, events.EventBandwidthRestrict); got != 2 {
+		t.Errorf("Expected the failed and the applied restriction recorded, got %d", got)
+	}
+
+	// Terminated accounts are left alone
+	identity, _ := f.state.ReadIdentity(1)
+	identity.State = account.StateTerminated
+	if err := f.state.WriteIdentity(1, identity); err != nil {
+		t.Fatal(err)
+	}
+	f.meter.used = stats.AccountBytes{}
+	if err := f.monitor.checkAccount(1, now); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := f.state.ReadStatus(1); status.BandwidthRestriction != account.BandwidthActionSuspend {
+		t.Errorf("Expected a terminated account not checked, got restriction %q", status.BandwidthRestriction)
+	}
+}
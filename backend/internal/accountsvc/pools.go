@@ -0,0 +1,194 @@
+package accountsvc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/iSundram/OweHost/internal/packages"
+	"github.com/iSundram/OweHost/internal/reseller"
+	"github.com/iSundram/OweHost/internal/storage/account"
+	"github.com/iSundram/OweHost/internal/tenancy"
+	"github.com/iSundram/OweHost/pkg/models"
+)
+
+// packageKey is the metadata entry naming an account's reseller package
+const packageKey = "package"
+
+// SetResellers has accounts owned by resellers allocated from the
+// resellers' resource pools, and lets them be put on their resellers'
+// packages
+func (s *Service) SetResellers(resellers *reseller.Service, pkgs *packages.Service) {
+	s.resellers = resellers
+	s.packages = pkgs
+}
+
+// resolvePlan works out the plan and limits an account owned by owner gets
+// on plan, which names a built-in package or one of the owning reseller's.
+// It also returns the reseller package's name, if plan names one.
+func (s *Service) resolvePlan(owner, plan string) (string, account.ResourceLimits, string, error) {
+	if account.IsValidPlan(plan) {
+		return plan, account.GetPlanLimits(plan), "", nil
+	}
+	if userID, ok := tenancy.ResellerUserID(owner); ok && s.packages != nil {
+		if pkg, err := s.packages.GetResellerPackage(userID, plan); err == nil {
+			return pkg.BasePlan, pkg.Limits, pkg.Name, nil
+		}
+	}
+	return "", account.ResourceLimits{}, "", fmt.Errorf("invalid plan: %s", plan)
+}
+
+// checkPools returns reseller.ErrPoolExceeded when giving limits to
+// account accountID, owned by owner, would take the owning reseller or one
+// above it beyond its pool. Callers hold s.mu.
+func (s *Service) checkPools(ctx context.Context, owner string, accountID int, limits *account.ResourceLimits) error {
+	if s.resellers == nil {
+		return nil
+	}
+	userID, ok := tenancy.ResellerUserID(owner)
+	if !ok {
+		return nil
+	}
+	chain := s.resellers.Ancestry(userID)
+	if len(chain) == 0 {
+		return nil
+	}
+
+	accounts, err := s.List(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list accounts: %w", err)
+	}
+	for _, res := range chain {
+		allocated := allocationOf(limits)
+		for _, acct := range s.poolAccounts(res, accounts) {
+			if acct.Identity.ID != accountID {
+				allocated = reseller.AddAllocation(allocated, allocationOf(acct.Limits))
+			}
+		}
+		if err := reseller.CheckAllocation(res.ResourcePool, allocated); err != nil {
+			return fmt.Errorf("reseller %s: %w", res.Name, err)
+		}
+	}
+	return nil
+}
+
+// poolAccounts returns the accounts allocated from a reseller's pool:
+// those of the reseller and of the resellers below it, short of
+// terminated ones
+func (s *Service) poolAccounts(res *models.Reseller, accounts []account.Account) []account.Account {
+	owners := make(map[string]bool)
+	for _, userID := range s.resellers.SubtreeUserIDs(res.ID) {
+		owners[tenancy.ResellerOwner(userID)] = true
+	}
+
+	var pooled []account.Account
+	for _, acct := range accounts {
+		if acct.Identity == nil || acct.Limits == nil || acct.Identity.State == account.StateTerminated {
+			continue
+		}
+		if owners[acct.Identity.Owner] {
+			pooled = append(pooled, acct)
+		}
+	}
+	return pooled
+}
+
+// ResellerUsage reports how much of a reseller's pool is allocated, and
+// how much the accounts allocated from it use
+func (s *Service) ResellerUsage(ctx context.Context, resellerID string) (*models.ResellerUsageReport, error) {
+	if s.resellers == nil {
+		return nil, errors.New("reseller pools are not configured")
+	}
+	res, err := s.resellers.Get(resellerID)
+	if err != nil {
+		return nil, err
+	}
+	accounts, err := s.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &models.ResellerUsageReport{
+		ResellerID:  res.ID,
+		UserID:      res.UserID,
+		Name:        res.Name,
+		Pool:        res.ResourcePool,
+		Allocatable: reseller.Allocatable(res.ResourcePool),
+		Accounts:    make([]models.ResellerAccountUsage, 0),
+		GeneratedAt: time.Now(),
+	}
+	for _, acct := range s.poolAccounts(res, accounts) {
+		line := models.ResellerAccountUsage{
+			AccountID: acct.Identity.ID,
+			Name:      acct.Identity.Name,
+			Owner:     acct.Identity.Owner,
+			Plan:      acct.Identity.Plan,
+			Allocated: allocationOf(acct.Limits),
+			Used:      s.usageOf(&acct),
+		}
+		if acct.Metadata != nil {
+			line.Package = acct.Metadata.Custom[packageKey]
+		}
+		report.Allocated = reseller.AddAllocation(report.Allocated, line.Allocated)
+		report.Used = reseller.AddAllocation(report.Used, line.Used)
+		report.Accounts = append(report.Accounts, line)
+	}
+	return report, nil
+}
+
+// allocationOf returns what an account's limits take from a pool
+func allocationOf(limits *account.ResourceLimits) models.ResourceAllocation {
+	bandwidthMB := int64(-1)
+	if limits.Bandwidth >= 0 {
+		bandwidthMB = int64(limits.Bandwidth) * 1024
+	}
+	return models.ResourceAllocation{
+		Accounts:    1,
+		DiskMB:      int64(limits.DiskMB),
+		BandwidthMB: bandwidthMB,
+		Domains:     limits.Domains,
+		Databases:   limits.Databases,
+	}
+}
+
+// usageOf returns what an account uses of what it was allocated, with
+// bandwidth over its current billing period
+func (s *Service) usageOf(acct *account.Account) models.ResourceAllocation {
+	used := models.ResourceAllocation{Accounts: 1}
+	if acct.Usage != nil {
+		used.DiskMB = int64(acct.Usage.DiskUsedMB)
+		used.BandwidthMB = int64(acct.Usage.BandwidthUsed) * 1024
+		used.Domains = acct.Usage.DomainCount
+		used.Databases = acct.Usage.DatabaseCount
+	}
+	if period, err := s.accountState.ReadBandwidth(acct.Identity.ID); err == nil && period != nil {
+		used.BandwidthMB = period.TotalBytes() / (1 << 20)
+	}
+	return used
+}
+
+// recordPackage notes in an account's metadata the reseller package it is
+// on, or that it is on none
+func (s *Service) recordPackage(accountID int, pkgName string) error {
+	metadata, err := s.accountState.ReadMetadata(accountID)
+	if err != nil {
+		if pkgName == "" {
+			return nil
+		}
+		return err
+	}
+	if metadata.Custom[packageKey] == pkgName {
+		return nil
+	}
+	if pkgName == "" {
+		delete(metadata.Custom, packageKey)
+	} else {
+		if metadata.Custom == nil {
+			metadata.Custom = make(map[string]string)
+		}
+		metadata.Custom[packageKey] = pkgName
+	}
+	metadata.UpdatedAt = time.Now().Format(time.RFC3339)
+	return s.accountState.WriteMetadata(accountID, metadata)
+}
@@ -3,15 +3,28 @@ package accountsvc
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
 
+	"github.com/iSundram/OweHost/internal/packages"
+	"github.com/iSundram/OweHost/internal/reseller"
 	"github.com/iSundram/OweHost/internal/storage/account"
 	"github.com/iSundram/OweHost/internal/storage/events"
 	"github.com/iSundram/OweHost/internal/storage/web"
+	"github.com/iSundram/OweHost/pkg/models"
 )
 
+// ErrNameTaken is returned creating an account named after an existing
+// panel user, which would otherwise be taken for the account's own user
+var ErrNameTaken = errors.New("a panel user with this name already exists")
+
+// PanelUsers looks up panel users by name
+type PanelUsers interface {
+	GetByUsername(username string) (*models.User, error)
+}
+
 // Service provides account management functionality using filesystem storage
 type Service struct {
 	accountState *account.StateManager
@@ -19,18 +32,29 @@ type Service struct {
 	webState     *web.StateManager
 	webApply     *web.Applier
 	events       *events.Emitter
+	resellers    *reseller.Service
+	packages     *packages.Service
+	users        PanelUsers
 	mu           sync.RWMutex
 }
 
 // NewService creates a new account service
 func NewService() *Service {
-	return &Service{
+	s := &Service{
 		accountState: account.NewStateManager(),
 		accountApply: account.NewApplier(),
 		webState:     web.NewStateManager(),
 		webApply:     web.NewApplier(),
 		events:       events.NewEmitter(),
 	}
+	s.accountApply.SetBandwidthEnforcer(s.webApply)
+	return s
+}
+
+// SetUsers has account names checked against the panel's users, so an
+// account cannot be named after one
+func (s *Service) SetUsers(users PanelUsers) {
+	s.users = users
 }
 
 // CreateRequest represents a request to create an account
@@ -38,7 +62,7 @@ type CreateRequest struct {
 	Username string `json:"username"`
 	Email    string `json:"email"`
 	Password string `json:"password"`
-	Plan     string `json:"plan"`
+	Plan     string `json:"plan"`  // A built-in package, or one of the owning reseller's
 	Owner    string `json:"owner"` // Parent owner (admin, reseller-X)
 }
 
@@ -49,11 +73,23 @@ type CreateResponse struct {
 	Limits    *account.ResourceLimits  `json:"limits"`
 }
 
-// Create creates a new account
+// Create creates a new account. An account owned by a reseller must fit in
+// the reseller's pool.
 func (s *Service) Create(ctx context.Context, req *CreateRequest, actor, actorType, actorIP string) (*CreateResponse, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if s.users != nil {
+		if _, err := s.users.GetByUsername(req.Username); err == nil {
+			return nil, ErrNameTaken
+		}
+	}
+
+	plan, limits, pkgName, err := s.resolvePlan(req.Owner, req.Plan)
+	if err != nil {
+		return nil, fmt.Errorf("validation failed: %w", err)
+	}
+
 	// Get next account ID
 	accountID, err := s.accountState.GetNextAccountID()
 	if err != nil {
@@ -67,7 +103,7 @@ func (s *Service) Create(ctx context.Context, req *CreateRequest, actor, actorTy
 		UID:       10000 + accountID,
 		GID:       10000 + accountID,
 		Owner:     req.Owner,
-		Plan:      req.Plan,
+		Plan:      plan,
 		Node:      "node-1", // Default node, would be selected by scheduler
 		CreatedAt: time.Now().Format(time.RFC3339),
 		State:     account.StateActive,
@@ -78,14 +114,18 @@ func (s *Service) Create(ctx context.Context, req *CreateRequest, actor, actorTy
 		return nil, fmt.Errorf("validation failed: %w", err)
 	}
 
-	// Get plan limits
-	limits := account.GetPlanLimits(req.Plan)
+	if err := s.checkPools(ctx, req.Owner, accountID, &limits); err != nil {
+		return nil, err
+	}
 
 	// Create metadata
 	metadata := &account.AccountMetadata{
 		Email:     req.Email,
 		UpdatedAt: time.Now().Format(time.RFC3339),
 	}
+	if pkgName != "" {
+		metadata.Custom = map[string]string{packageKey: pkgName}
+	}
 
 	// Apply to filesystem (source of truth)
 	config := &account.ApplyConfig{
@@ -192,12 +232,24 @@ func (s *Service) Delete(ctx context.Context, accountID int, actor, actorType st
 	return nil
 }
 
-// UpdateLimits updates account resource limits
+// UpdateLimits updates account resource limits. An account owned by a
+// reseller must still fit in the reseller's pool.
 func (s *Service) UpdateLimits(ctx context.Context, accountID int, limits *account.ResourceLimits, actor, actorType string) error {
 	if err := account.ValidateLimits(limits); err != nil {
 		return err
 	}
 
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	identity, err := s.accountState.ReadIdentity(accountID)
+	if err != nil {
+		return err
+	}
+	if err := s.checkPools(ctx, identity.Owner, accountID, limits); err != nil {
+		return err
+	}
+
 	if err := s.accountState.WriteLimits(accountID, limits); err != nil {
 		return err
 	}
@@ -214,11 +266,12 @@ func (s *Service) UpdateLimits(ctx context.Context, accountID int, limits *accou
 	return nil
 }
 
-// ChangePlan changes an account's plan
+// ChangePlan changes an account's plan, to a built-in package or one of
+// the owning reseller's. An account owned by a reseller must still fit in
+// the reseller's pool.
 func (s *Service) ChangePlan(ctx context.Context, accountID int, newPlan, actor, actorType string) error {
-	if !account.IsValidPlan(newPlan) {
-		return fmt.Errorf("invalid plan: %s", newPlan)
-	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
 	// Read current identity
 	identity, err := s.accountState.ReadIdentity(accountID)
@@ -226,19 +279,33 @@ func (s *Service) ChangePlan(ctx context.Context, accountID int, newPlan, actor,
 		return err
 	}
 
+	plan, limits, pkgName, err := s.resolvePlan(identity.Owner, newPlan)
+	if err != nil {
+		return err
+	}
+	if err := s.checkPools(ctx, identity.Owner, accountID, &limits); err != nil {
+		return err
+	}
+
 	oldPlan := identity.Plan
-	identity.Plan = newPlan
+	identity.Plan = plan
 
 	// Write updated identity
 	if err := s.accountState.WriteIdentity(accountID, identity); err != nil {
 		return err
 	}
 
-	// Update limits based on new plan
-	limits := account.GetPlanLimits(newPlan)
+	// Update limits based on new plan. Billing settings aren't part of a plan.
+	if current, err := s.accountState.ReadLimits(accountID); err == nil {
+		limits.BillingDay = current.BillingDay
+		limits.BandwidthAction = current.BandwidthAction
+	}
 	if err := s.accountState.WriteLimits(accountID, &limits); err != nil {
 		return err
 	}
+	if err := s.recordPackage(accountID, pkgName); err != nil {
+		return err
+	}
 
 	s.events.EmitSuccess(events.EventAccountUpdate, events.EmitOptions{
 		AccountID: accountID,
@@ -293,10 +360,14 @@ func (s *Service) ListDomains(ctx context.Context, accountID int) ([]web.SiteDes
 func (s *Service) GetUsage(ctx context.Context, accountID int) (*account.AccountUsage, error) {
 	sites, _ := s.webState.ListSites(accountID)
 
-	return &account.AccountUsage{
+	usage := &account.AccountUsage{
 		DomainCount: len(sites),
 		LastUpdated: time.Now(),
-	}, nil
+	}
+	if period, _ := s.accountState.ReadBandwidth(accountID); period != nil {
+		usage.BandwidthUsed = int(period.TotalBytes() >> 30)
+	}
+	return usage, nil
 }
 
 // Exists checks if an account exists
@@ -0,0 +1,30 @@
+package accountsvc
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/iSundram/OweHost/pkg/models"
+)
+
+// panelUsers is an in-memory set of panel users, by name
+type panelUsers map[string]*models.User
+
+func (p panelUsers) GetByUsername(username string) (*models.User, error) {
+	if u, ok := p[username]; ok {
+		return u, nil
+	}
+	return nil, errors.New("user not found")
+}
+
+func TestCreate_NameTaken(t *testing.T) {
+	s := &Service{users: panelUsers{
+		"root": {ID: "usr-admin", Username: "root", Role: models.UserRoleAdmin},
+	}}
+
+	_, err := s.Create(context.Background(), &CreateRequest{Username: "root", Owner: "reseller:usr-resa"}, "reseller-a", "reseller", "192.0.2.1")
+	if !errors.Is(err, ErrNameTaken) {
+		t.Errorf("Expected ErrNameTaken, got %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+// Package middleware provides HTTP middleware for OweHost API
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ContextKeyClientIP is the context key for the client's address
+const ContextKeyClientIP ContextKey = "client_ip"
+
+// ClientIPMiddleware works out the address each request comes from. Proxy
+// headers are only believed when the connection comes from one of the
+// trusted proxies; anyone else could send them with any address.
+func ClientIPMiddleware(trustedProxies []string) func(http.Handler) http.Handler {
+	var trusted []netip.Prefix
+	for _, proxy := range trustedProxies {
+		prefix, err := parsePrefix(proxy)
+		if err != nil {
+			fmt.Printf("warning: ignoring trusted proxy %q: %v\n", proxy, err)
+			continue
+		}
+		trusted = append(trusted, prefix)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), ContextKeyClientIP, clientIP(r, trusted))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// clientIP returns the address of a request's client. X-Forwarded-For is
+// read from the right, past the trusted proxies, since only the entries
+// they appended can be relied on.
+func clientIP(r *http.Request, trusted []netip.Prefix) string {
+	remote := remoteIP(r)
+	if !isTrusted(remote, trusted) {
+		return remote
+	}
+
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if _, err := netip.ParseAddr(hop); err != nil {
+				break
+			}
+			if !isTrusted(hop, trusted) {
+				return hop
+			}
+		}
+	}
+	if xri := strings.TrimSpace(r.Header.Get("X-Real-IP")); xri != "" {
+		if _, err := netip.ParseAddr(xri); err == nil {
+			return xri
+		}
+	}
+	return remote
+}
+
+// remoteIP returns the address of the connection's peer
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func isTrusted(ip string, trusted []netip.Prefix) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePrefix parses a CIDR or a single address
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// GetClientIP gets the client's address from context, as worked out by
+// ClientIPMiddleware
+func GetClientIP(ctx context.Context) string {
+	if ip, ok := ctx.Value(ContextKeyClientIP).(string); ok {
+		return ip
+	}
+	return ""
+}
@@ -0,0 +1,86 @@
+// Package middleware provides HTTP middleware for OweHost API
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/iSundram/OweHost/internal/auth"
+	"github.com/iSundram/OweHost/pkg/utils"
+)
+
+// ImpersonatedByHeader names the impersonator on every response to an
+// impersonation token, so the frontend can keep its banner up
+const ImpersonatedByHeader = "X-Impersonated-By"
+
+// serveImpersonated serves a request made with an impersonation token.
+// Requests that would change how the user signs in, or hand the
+// impersonator credentials outliving the impersonation, are refused; every
+// request is recorded under both identities.
+func serveImpersonated(authService *auth.Service, claims *auth.Claims, ip string, next http.Handler, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(ImpersonatedByHeader, claims.Impersonator.Username)
+	wrapped := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+
+	if impersonationBlocked(r) {
+		utils.WriteError(wrapped, http.StatusForbidden, utils.ErrCodeForbidden, "Not allowed while impersonating a user")
+	} else {
+		ctx := context.WithValue(r.Context(), ContextKeyImpersonator, claims.Impersonator)
+		next.ServeHTTP(wrapped, r.WithContext(ctx))
+	}
+
+	authService.RecordImpersonatedAction(claims, r.Method, r.URL.Path, wrapped.statusCode, ip, GetRequestID(r.Context()))
+}
+
+// impersonationBlocked reports whether a request is off limits to an
+// impersonator: password changes, 2FA and security key changes, API keys,
+// revoking the user's sessions, updating a user, whose email a password
+// reset would go to, and impersonating yet another user. So are SSH keys
+// and access, git deploy keys and webhooks, FTP accounts and database
+// users, which would all keep working once the impersonation is over.
+func impersonationBlocked(r *http.Request) bool {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+		return false
+	}
+
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	for _, segment := range strings.Split(path, "/") {
+		if strings.Contains(strings.ToLower(segment), "password") {
+			return true
+		}
+	}
+	switch {
+	case path == "/api/v1/2fa" || strings.HasPrefix(path, "/api/v1/2fa/"):
+		return true
+	case path == "/api/v1/api-keys" || strings.HasPrefix(path, "/api/v1/api-keys/"):
+		return true
+	case path == "/api/v1/sessions" || strings.HasPrefix(path, "/api/v1/sessions/"):
+		return true
+	case strings.HasPrefix(path, "/api/v1/users/") && (r.Method == http.MethodPut || r.Method == http.MethodPatch):
+		return true
+	case strings.HasPrefix(path, "/api/v1/ssh/keys") || strings.HasPrefix(path, "/api/v1/ssh/access"):
+		return true
+	case strings.HasPrefix(path, "/api/v1/git/repositories/") &&
+		(strings.HasSuffix(path, "/deploy-keys") || strings.HasSuffix(path, "/webhooks")):
+		return true
+	case path == "/api/v1/ftp/accounts" && r.Method == http.MethodPost:
+		return true
+	case strings.HasPrefix(path, "/api/v1/ftp/accounts/") && (r.Method == http.MethodPut || r.Method == http.MethodPatch):
+		return true
+	case strings.HasPrefix(path, "/api/v1/databases/") && strings.HasSuffix(path, "/users"):
+		return true
+	case path == "/api/v1/impersonate":
+		// Ending the impersonation is always allowed
+		return r.Method != http.MethodDelete
+	}
+	return false
+}
+
+// GetImpersonator gets the admin or reseller behind a request's
+// impersonation token, or nil when the user is acting for themselves
+func GetImpersonator(ctx context.Context) *auth.Impersonator {
+	if impersonator, ok := ctx.Value(ContextKeyImpersonator).(*auth.Impersonator); ok {
+		return impersonator
+	}
+	return nil
+}
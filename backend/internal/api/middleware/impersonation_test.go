@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestImpersonationBlocked(t *testing.T) {
+	tests := []struct {
+		method, path string
+		blocked      bool
+	}{
+		{http.MethodGet, "/api/v1/users/usr-1", false},
+		{http.MethodPut, "/api/v1/users/usr-1", true},
+		{http.MethodPatch, "/api/v1/users/usr-1/", true},
+		{http.MethodPost, "/api/v1/users/usr-1/suspend", false},
+		{http.MethodPost, "/api/v1/auth/change-password", true},
+		{http.MethodPost, "/api/v1/users/usr-1/Password", true},
+		{http.MethodPost, "/api/v1/auth/forgot-password", true},
+		{http.MethodPost, "/api/v1/2fa/disable", true},
+		{http.MethodPost, "/api/v1/2fa", true},
+		{http.MethodGet, "/api/v1/2fa/status", false},
+		{http.MethodPost, "/api/v1/2factor", false},
+		{http.MethodPost, "/api/v1/api-keys", true},
+		{http.MethodDelete, "/api/v1/api-keys/key-1", true},
+		{http.MethodDelete, "/api/v1/sessions/sess-1", true},
+		{http.MethodDelete, "/api/v1/sessions", true},
+		{http.MethodGet, "/api/v1/sessions", false},
+		{http.MethodPost, "/api/v1/impersonate", true},
+		{http.MethodDelete, "/api/v1/impersonate", false},
+		{http.MethodDelete, "/api/v1/impersonate/", false},
+		{http.MethodPost, "/api/v1/ssh/keys", true},
+		{http.MethodPost, "/api/v1/ssh/keys/generate", true},
+		{http.MethodDelete, "/api/v1/ssh/keys/key-1", true},
+		{http.MethodPost, "/api/v1/ssh/access/enable", true},
+		{http.MethodGet, "/api/v1/ssh/keys", false},
+		{http.MethodPost, "/api/v1/git/repositories/repo-1/deploy-keys", true},
+		{http.MethodPost, "/api/v1/git/repositories/repo-1/webhooks/", true},
+		{http.MethodGet, "/api/v1/git/repositories/repo-1/deploy-keys", false},
+		{http.MethodPost, "/api/v1/git/repositories/repo-1/deploy", false},
+		{http.MethodPost, "/api/v1/ftp/accounts", true},
+		{http.MethodPut, "/api/v1/ftp/accounts/ftp-1", true},
+		{http.MethodPatch, "/api/v1/ftp/accounts/ftp-1", true},
+		{http.MethodPost, "/api/v1/ftp/accounts/ftp-1/suspend", false},
+		{http.MethodDelete, "/api/v1/ftp/accounts/ftp-1", false},
+		{http.MethodPost, "/api/v1/databases/db-1/users", true},
+		{http.MethodGet, "/api/v1/databases/db-1/users", false},
+		{http.MethodPost, "/api/v1/databases/db-1/backups", false},
+		{http.MethodPost, "/api/v1/domains", false},
+		{http.MethodDelete, "/api/v1/databases/db-1", false},
+		{http.MethodOptions, "/api/v1/api-keys", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		if got := impersonationBlocked(r); got != tt.blocked {
+			t.Errorf("%s %s: expected blocked=%v, got %v", tt.method, tt.path, tt.blocked, got)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"strings"
 	"time"
@@ -24,6 +25,15 @@ const (
 	ContextKeyRequestID ContextKey = "request_id"
 	// ContextKeyUserRole is the context key for user role
 	ContextKeyUserRole ContextKey = "user_role"
+	// ContextKeyAPIKeyID is the context key for the API key a request
+	// authenticated with
+	ContextKeyAPIKeyID ContextKey = "api_key_id"
+	// ContextKeySessionID is the context key for the session a request's
+	// access token belongs to
+	ContextKeySessionID ContextKey = "session_id"
+	// ContextKeyImpersonator is the context key for the admin or reseller
+	// behind an impersonation token
+	ContextKeyImpersonator ContextKey = "impersonator"
 )
 
 // AuthMiddleware provides authentication middleware
@@ -36,27 +46,40 @@ func AuthMiddleware(authService *auth.Service) func(http.Handler) http.Handler {
 				return
 			}
 
-			var userID, tenantID string
+			var userID, tenantID, apiKeyID, sessionID string
+			var claims *auth.Claims
+			ip := GetClientIP(r.Context())
+			if ip == "" {
+				ip = remoteIP(r)
+			}
 
 			// Check for Bearer token
 			if strings.HasPrefix(authHeader, "Bearer ") {
 				token := strings.TrimPrefix(authHeader, "Bearer ")
-				claims, err := authService.ValidateToken(token)
+				var err error
+				claims, err = authService.ValidateToken(token)
 				if err != nil {
 					utils.WriteError(w, http.StatusUnauthorized, utils.ErrCodeUnauthorized, "Invalid token")
 					return
 				}
 				userID = claims.UserID
 				tenantID = claims.TenantID
+				sessionID = claims.SessionID
+				authService.TouchSession(sessionID, ip)
 			} else if strings.HasPrefix(authHeader, "ApiKey ") {
-				// Check for API key
+				// Check for API key, its scopes and where it is used from
 				apiKey := strings.TrimPrefix(authHeader, "ApiKey ")
-				key, err := authService.ValidateAPIKey(apiKey)
-				if err != nil {
+				key, err := authService.AuthorizeAPIKey(apiKey, auth.ScopeFor(r.Method, r.URL.Path), ip)
+				switch {
+				case errors.Is(err, auth.ErrAPIKeyScope), errors.Is(err, auth.ErrAPIKeyAddress):
+					utils.WriteError(w, http.StatusForbidden, utils.ErrCodeForbidden, err.Error())
+					return
+				case err != nil:
 					utils.WriteError(w, http.StatusUnauthorized, utils.ErrCodeUnauthorized, "Invalid API key")
 					return
 				}
 				userID = key.UserID
+				apiKeyID = key.ID
 			} else {
 				utils.WriteError(w, http.StatusUnauthorized, utils.ErrCodeUnauthorized, "Invalid authorization format")
 				return
@@ -64,6 +87,16 @@ func AuthMiddleware(authService *auth.Service) func(http.Handler) http.Handler {
 
 			ctx := context.WithValue(r.Context(), ContextKeyUserID, userID)
 			ctx = context.WithValue(ctx, ContextKeyTenantID, tenantID)
+			if apiKeyID != "" {
+				ctx = context.WithValue(ctx, ContextKeyAPIKeyID, apiKeyID)
+			}
+			if sessionID != "" {
+				ctx = context.WithValue(ctx, ContextKeySessionID, sessionID)
+			}
+			if claims != nil && claims.Impersonator != nil {
+				serveImpersonated(authService, claims, ip, next, w, r.WithContext(ctx))
+				return
+			}
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
@@ -101,7 +134,7 @@ func LoggingMiddleware(logService *logging.Service) func(http.Handler) http.Hand
 				"path":        r.URL.Path,
 				"status":      wrapped.statusCode,
 				"duration_ms": duration.Milliseconds(),
-				"ip":          r.RemoteAddr,
+				"ip":          getClientIP(r),
 				"user_agent":  r.UserAgent(),
 			}
 
@@ -122,6 +155,7 @@ func CORSMiddleware(next http.Handler) http.Handler {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Request-ID")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, "+ImpersonatedByHeader)
 		w.Header().Set("Access-Control-Max-Age", "86400")
 
 		if r.Method == "OPTIONS" {
@@ -194,6 +228,24 @@ func GetTenantID(ctx context.Context) string {
 	return ""
 }
 
+// GetAPIKeyID gets the ID of the API key a request authenticated with, or
+// "" for a session token
+func GetAPIKeyID(ctx context.Context) string {
+	if keyID, ok := ctx.Value(ContextKeyAPIKeyID).(string); ok {
+		return keyID
+	}
+	return ""
+}
+
+// GetSessionID gets the ID of the session a request's access token
+// belongs to, or "" for an API key
+func GetSessionID(ctx context.Context) string {
+	if sessionID, ok := ctx.Value(ContextKeySessionID).(string); ok {
+		return sessionID
+	}
+	return ""
+}
+
 // GetRequestID gets request ID from context
 func GetRequestID(ctx context.Context) string {
 	if requestID, ok := ctx.Value(ContextKeyRequestID).(string); ok {
@@ -0,0 +1,81 @@
+// Package middleware provides HTTP middleware for OweHost API
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/iSundram/OweHost/internal/auth"
+	"github.com/iSundram/OweHost/internal/authorization"
+	"github.com/iSundram/OweHost/internal/user"
+	"github.com/iSundram/OweHost/pkg/models"
+	"github.com/iSundram/OweHost/pkg/utils"
+)
+
+// policyExemptPrefix is left out of policy checks, so a policy locking
+// admins out can always be fixed
+const policyExemptPrefix = "/api/v1/authorization/"
+
+// policyActions maps request methods to the actions policies name
+var policyActions = map[string]string{
+	http.MethodGet:    "read",
+	http.MethodHead:   "read",
+	http.MethodPost:   "create",
+	http.MethodPut:    "update",
+	http.MethodPatch:  "update",
+	http.MethodDelete: "delete",
+}
+
+// PolicyMiddleware checks authenticated requests against the authorization
+// policy rules. Only a rule can refuse a request here: when none applies,
+// the route's own role checks decide as before.
+//
+// The resource is the API key scope resource of the path (domain, database,
+// ...), and conditions can test ip, method, path, role, user_id, tenant_id,
+// api_key and impersonated.
+func PolicyMiddleware(authzService *authorization.Service, userService *user.Service) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !authzService.HasPolicyRules() || strings.HasPrefix(r.URL.Path, policyExemptPrefix) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			userID := GetUserID(r.Context())
+			role := models.UserRoleAccount
+			if u, err := userService.Get(userID); err == nil {
+				role = u.Role
+			}
+			ip := GetClientIP(r.Context())
+			if ip == "" {
+				ip = remoteIP(r)
+			}
+			resource, _, _ := strings.Cut(auth.ScopeFor(r.Method, r.URL.Path), ":")
+			action, ok := policyActions[r.Method]
+			if !ok {
+				action = strings.ToLower(r.Method)
+			}
+
+			resp := authzService.EvaluatePolicy(&models.AuthorizationCheckRequest{
+				UserID:   userID,
+				Resource: resource,
+				Action:   action,
+				Context: map[string]interface{}{
+					"ip":           ip,
+					"method":       r.Method,
+					"path":         r.URL.Path,
+					"role":         string(role),
+					"user_id":      userID,
+					"tenant_id":    GetTenantID(r.Context()),
+					"api_key":      GetAPIKeyID(r.Context()) != "",
+					"impersonated": GetImpersonator(r.Context()) != nil,
+				},
+			})
+			if resp.DecidedBy == authorization.DecidedByPolicy && !resp.Allowed {
+				utils.WriteError(w, http.StatusForbidden, utils.ErrCodeForbidden, resp.Reason)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -197,31 +197,11 @@ func UserRateLimitMiddleware(url *UserRateLimiter) func(http.Handler) http.Handl
 	}
 }
 
-// getClientIP extracts the client IP from the request
+// getClientIP extracts the client IP from the request. Proxy headers are
+// only honoured through ClientIPMiddleware, which knows the trusted proxies.
 func getClientIP(r *http.Request) string {
-	// Check X-Forwarded-For header
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		// Take the first IP
-		for i := 0; i < len(xff); i++ {
-			if xff[i] == ',' {
-				return xff[:i]
-			}
-		}
-		return xff
-	}
-
-	// Check X-Real-IP header
-	if xri := r.Header.Get("X-Real-IP"); xri != "" {
-		return xri
-	}
-
-	// Fall back to RemoteAddr
-	ip := r.RemoteAddr
-	// Remove port if present
-	for i := len(ip) - 1; i >= 0; i-- {
-		if ip[i] == ':' {
-			return ip[:i]
-		}
+	if ip := GetClientIP(r.Context()); ip != "" {
+		return ip
 	}
-	return ip
+	return remoteIP(r)
 }
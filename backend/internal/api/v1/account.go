@@ -2,6 +2,7 @@ package v1
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -10,6 +11,9 @@ import (
 	"github.com/iSundram/OweHost/internal/api/middleware"
 	"github.com/iSundram/OweHost/internal/dns"
 	"github.com/iSundram/OweHost/internal/domain"
+	"github.com/iSundram/OweHost/internal/reseller"
+	"github.com/iSundram/OweHost/internal/storage/account"
+	"github.com/iSundram/OweHost/internal/tenancy"
 	"github.com/iSundram/OweHost/internal/user"
 	"github.com/iSundram/OweHost/pkg/models"
 	"github.com/iSundram/OweHost/pkg/utils"
@@ -22,15 +26,17 @@ type AccountHandler struct {
 	userService    *user.Service
 	domainService  *domain.Service
 	dnsService     *dns.Service
+	guard          *tenancy.Guard
 }
 
 // NewAccountHandler creates a new AccountHandler.
-func NewAccountHandler(accountSvc *accountsvc.Service, userSvc *user.Service, domainSvc *domain.Service, dnsSvc *dns.Service) *AccountHandler {
+func NewAccountHandler(accountSvc *accountsvc.Service, userSvc *user.Service, domainSvc *domain.Service, dnsSvc *dns.Service, guard *tenancy.Guard) *AccountHandler {
 	return &AccountHandler{
 		accountService: accountSvc,
 		userService:    userSvc,
 		domainService:  domainSvc,
 		dnsService:     dnsSvc,
+		guard:          guard,
 	}
 }
 
@@ -38,7 +44,7 @@ type accountCreateRequest struct {
 	Username string `json:"username"`
 	Email    string `json:"email"`
 	Password string `json:"password"`
-	Plan     string `json:"plan"`     // Plan: starter, standard, premium, enterprise
+	Plan     string `json:"plan"`     // Plan: starter, standard, premium, enterprise, or one of the owning reseller's packages
 	Owner    string `json:"owner"`    // Owner: admin, reseller-X, partner-X
 	Domain   string `json:"domain,omitempty"`
 }
@@ -77,7 +83,7 @@ func (h *AccountHandler) Create(w http.ResponseWriter, r *http.Request) {
 				if user.Role == models.UserRoleAdmin {
 					req.Owner = "admin"
 				} else if user.Role == models.UserRoleReseller {
-					req.Owner = "reseller-" + userID
+					req.Owner = tenancy.ResellerOwner(userID)
 				} else {
 					req.Owner = "admin" // Default to admin
 				}
@@ -89,6 +95,11 @@ func (h *AccountHandler) Create(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if !callerScope(r, h.guard).CanParent(req.Owner) {
+		writeCrossTenant(w)
+		return
+	}
+
 	// Get actor information from request context
 	actorID := middleware.GetUserID(r.Context())
 	actorRole := middleware.GetUserRole(r.Context())
@@ -124,7 +135,7 @@ func (h *AccountHandler) Create(w http.ResponseWriter, r *http.Request) {
 		Owner:    req.Owner,
 	}, actor, actorType, actorIP)
 	if err != nil {
-		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
+		writeAllocationError(w, err)
 		return
 	}
 
@@ -167,7 +178,7 @@ func (h *AccountHandler) Create(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// List returns all accounts from filesystem storage.
+// List returns the accounts from filesystem storage the caller can reach.
 func (h *AccountHandler) List(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
@@ -180,8 +191,16 @@ func (h *AccountHandler) List(w http.ResponseWriter, r *http.Request) {
 		utils.WriteError(w, http.StatusInternalServerError, utils.ErrCodeInternalError, err.Error())
 		return
 	}
+
+	scope := callerScope(r, h.guard)
+	visible := make([]account.Account, 0, len(accounts))
+	for _, acct := range accounts {
+		if scope.OwnsAccount(acct.Identity) {
+			visible = append(visible, acct)
+		}
+	}
 	
-	utils.WriteSuccess(w, accounts)
+	utils.WriteSuccess(w, visible)
 }
 
 // UpdateStatus updates status (suspend/unsuspend/terminate) using filesystem storage.
@@ -206,6 +225,9 @@ func (h *AccountHandler) UpdateStatus(w http.ResponseWriter, r *http.Request) {
 	}
 	
 	action := parts[len(parts)-1]
+	if !h.authorizeAccount(w, r, accountID) {
+		return
+	}
 
 	// Get actor information
 	actorID := middleware.GetUserID(r.Context())
@@ -252,3 +274,193 @@ func (h *AccountHandler) UpdateStatus(w http.ResponseWriter, r *http.Request) {
 
 	utils.WriteSuccess(w, account)
 }
+
+type accountBandwidthRequest struct {
+	BillingDay *int    `json:"billing_day"` // 1-28, 0 for the day the account was created
+	Action     *string `json:"action"`      // alert, throttle or suspend
+}
+
+// Bandwidth shows (GET) or configures (PUT) an account's monthly bandwidth:
+// usage in the current billing period, the billing day and what happens
+// over the limit.
+func (h *AccountHandler) Bandwidth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPut {
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+		return
+	}
+
+	parts := strings.Split(strings.TrimSuffix(r.URL.Path, "/"), "/")
+	var accountID int
+	if _, err := fmt.Sscanf(parts[len(parts)-2], "%d", &accountID); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid account ID format")
+		return
+	}
+	if !h.authorizeAccount(w, r, accountID) {
+		return
+	}
+
+	ctx := r.Context()
+	if r.Method == http.MethodPut {
+		var req accountBandwidthRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid request body")
+			return
+		}
+		current, err := h.accountService.GetBandwidth(ctx, accountID)
+		if err != nil {
+			utils.WriteError(w, http.StatusNotFound, utils.ErrCodeNotFound, "Account not found")
+			return
+		}
+		billingDay, action := current.BillingDay, current.Action
+		if req.BillingDay != nil {
+			billingDay = *req.BillingDay
+		}
+		if req.Action != nil {
+			action = *req.Action
+		}
+
+		actor, actorType := h.requestActor(r)
+		if err := h.accountService.ConfigureBandwidth(ctx, accountID, billingDay, action, actor, actorType); err != nil {
+			utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
+			return
+		}
+	}
+
+	usage, err := h.accountService.GetBandwidth(ctx, accountID)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, utils.ErrCodeNotFound, "Account not found")
+		return
+	}
+	utils.WriteSuccess(w, usage)
+}
+
+type accountPlanRequest struct {
+	Plan string `json:"plan"`
+}
+
+// ChangePlan moves an account to another plan or reseller package
+// (PUT /api/v1/accounts/{id}/plan)
+func (h *AccountHandler) ChangePlan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+		return
+	}
+
+	accountID, ok := h.accountIDFromPath(w, r)
+	if !ok || !h.authorizeAccount(w, r, accountID) {
+		return
+	}
+
+	var req accountPlanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Plan == "" {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Plan is required")
+		return
+	}
+
+	ctx := r.Context()
+	actor, actorType := h.requestActor(r)
+	if err := h.accountService.ChangePlan(ctx, accountID, req.Plan, actor, actorType); err != nil {
+		writeAllocationError(w, err)
+		return
+	}
+
+	acct, err := h.accountService.Get(ctx, accountID)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, utils.ErrCodeNotFound, "Account not found")
+		return
+	}
+	utils.WriteSuccess(w, acct)
+}
+
+// UpdateLimits sets the resources allocated to an account
+// (PUT /api/v1/accounts/{id}/limits)
+func (h *AccountHandler) UpdateLimits(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+		return
+	}
+
+	accountID, ok := h.accountIDFromPath(w, r)
+	if !ok || !h.authorizeAccount(w, r, accountID) {
+		return
+	}
+
+	ctx := r.Context()
+	acct, err := h.accountService.Get(ctx, accountID)
+	if err != nil || acct.Limits == nil {
+		utils.WriteError(w, http.StatusNotFound, utils.ErrCodeNotFound, "Account not found")
+		return
+	}
+	// Fields left out of the request keep their current value
+	limits := *acct.Limits
+	if err := json.NewDecoder(r.Body).Decode(&limits); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid request body")
+		return
+	}
+
+	actor, actorType := h.requestActor(r)
+	if err := h.accountService.UpdateLimits(ctx, accountID, &limits, actor, actorType); err != nil {
+		writeAllocationError(w, err)
+		return
+	}
+	utils.WriteSuccess(w, limits)
+}
+
+// accountIDFromPath reads the account ID from /api/v1/accounts/{id}/...
+func (h *AccountHandler) accountIDFromPath(w http.ResponseWriter, r *http.Request) (int, bool) {
+	parts := strings.Split(strings.TrimSuffix(r.URL.Path, "/"), "/")
+	var accountID int
+	if len(parts) < 2 {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Account ID required")
+		return 0, false
+	}
+	if _, err := fmt.Sscanf(parts[len(parts)-2], "%d", &accountID); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid account ID format")
+		return 0, false
+	}
+	return accountID, true
+}
+
+// writeAllocationError refuses an account change, with a conflict when it
+// doesn't fit in the reseller's pool or its name is taken
+func writeAllocationError(w http.ResponseWriter, err error) {
+	if errors.Is(err, reseller.ErrPoolExceeded) || errors.Is(err, accountsvc.ErrNameTaken) {
+		utils.WriteError(w, http.StatusConflict, utils.ErrCodeConflict, err.Error())
+		return
+	}
+	utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
+}
+
+// authorizeAccount checks the caller can reach an account, refusing the
+// request when it is missing or out of reach
+func (h *AccountHandler) authorizeAccount(w http.ResponseWriter, r *http.Request, accountID int) bool {
+	acct, err := h.accountService.Get(r.Context(), accountID)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, utils.ErrCodeNotFound, "Account not found")
+		return false
+	}
+	if !callerScope(r, h.guard).OwnsAccount(acct.Identity) {
+		writeCrossTenant(w)
+		return false
+	}
+	return true
+}
+
+// requestActor returns who makes a request, for account events
+func (h *AccountHandler) requestActor(r *http.Request) (string, string) {
+	actorType := "user"
+	switch middleware.GetUserRole(r.Context()) {
+	case models.UserRoleAdmin:
+		actorType = "admin"
+	case models.UserRoleReseller:
+		actorType = "reseller"
+	}
+
+	actor := "system"
+	if actorID := middleware.GetUserID(r.Context()); actorID != "" {
+		if user, err := h.userService.Get(actorID); err == nil {
+			actor = user.Username
+		}
+	}
+	return actor, actorType
+}
@@ -7,6 +7,8 @@ import (
 	"github.com/iSundram/OweHost/internal/accountsvc"
 	"github.com/iSundram/OweHost/internal/api/middleware"
 	"github.com/iSundram/OweHost/internal/auth"
+	"github.com/iSundram/OweHost/internal/bruteforce"
+	"github.com/iSundram/OweHost/internal/twofactor"
 	"github.com/iSundram/OweHost/internal/user"
 	"github.com/iSundram/OweHost/pkg/models"
 	"github.com/iSundram/OweHost/pkg/utils"
@@ -17,21 +19,30 @@ type AccountAuthHandler struct {
 	accountService *accountsvc.Service
 	authService    *auth.Service
 	userService    *user.Service
+	mfa            *mfaLogin
 }
 
 // NewAccountAuthHandler creates a new account auth handler
-func NewAccountAuthHandler(accountService *accountsvc.Service, authService *auth.Service, userService *user.Service) *AccountAuthHandler {
+func NewAccountAuthHandler(accountService *accountsvc.Service, authService *auth.Service, userService *user.Service, tfService *twofactor.Service, guard *bruteforce.Guard) *AccountAuthHandler {
 	return &AccountAuthHandler{
 		accountService: accountService,
 		authService:    authService,
 		userService:    userService,
+		mfa: &mfaLogin{
+			authService: authService,
+			tfService:   tfService,
+			audience:    auth.MFAAudienceAccount,
+			guard:       guard,
+			surface:     bruteforce.SurfaceAccount,
+		},
 	}
 }
 
 // LoginRequest represents account login request
 type LoginRequest struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username    string `json:"username"`
+	Password    string `json:"password"`
+	DeviceToken string `json:"device_token,omitempty"`
 }
 
 // Login handles account login
@@ -47,9 +58,15 @@ func (h *AccountAuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !h.mfa.allowed(w, r, req.Username, loginStepPassword) {
+		return
+	}
+
 	// Authenticate account
 	identity, err := h.accountService.Authenticate(r.Context(), req.Username, req.Password)
 	if err != nil {
+		h.mfa.record(r, "", req.Username, loginStepPassword, false, false, "invalid_credentials")
+		h.mfa.fail(r, req.Username, "invalid_credentials")
 		utils.WriteError(w, http.StatusUnauthorized, utils.ErrCodeUnauthorized, "Invalid credentials")
 		return
 	}
@@ -59,14 +76,13 @@ func (h *AccountAuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		ID:       identity.Name,
 		Username: identity.Name,
 		Email:    "", // Would need to get from metadata
-		Role:     "account",
+		Role:     models.UserRoleAccount,
 		TenantID: identity.Owner,
 	}
 
-	// Generate tokens
-	tokens, err := h.authService.GenerateTokens(user)
-	if err != nil {
-		utils.WriteError(w, http.StatusInternalServerError, utils.ErrCodeInternalError, "Failed to generate tokens")
+	// Generate tokens, unless a second factor is due first
+	tokens, ok := h.mfa.begin(w, r, user, req.DeviceToken)
+	if !ok {
 		return
 	}
 
@@ -79,6 +95,79 @@ func (h *AccountAuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// VerifyLogin completes an account login with the second factor
+func (h *AccountAuthHandler) VerifyLogin(w http.ResponseWriter, r *http.Request) {
+	ticket, resp, ok := h.mfa.verify(w, r)
+	if !ok {
+		return
+	}
+
+	h.writeLogin(w, r, ticket.User.Username, resp)
+}
+
+// writeLogin answers a completed login like Login does
+func (h *AccountAuthHandler) writeLogin(w http.ResponseWriter, r *http.Request, username string, resp *mfaLoginResponse) {
+	result := map[string]interface{}{
+		"message":  "Login successful",
+		"username": username,
+		"tokens":   resp.LoginResponse,
+	}
+	if acct, err := h.accountService.GetByUsername(r.Context(), username); err == nil {
+		result["account_id"] = acct.Identity.ID
+		result["plan"] = acct.Identity.Plan
+	}
+	if resp.DeviceToken != "" {
+		result["device_token"] = resp.DeviceToken
+		result["device_expires_at"] = resp.DeviceExpiresAt
+	}
+	utils.WriteJSON(w, http.StatusOK, result)
+}
+
+// SetupLogin sets up the second factor of an account that must have one
+// before its login can complete
+func (h *AccountAuthHandler) SetupLogin(w http.ResponseWriter, r *http.Request) {
+	h.mfa.setup(w, r)
+}
+
+// BeginWebAuthnLogin challenges the security keys of an account's login
+func (h *AccountAuthHandler) BeginWebAuthnLogin(w http.ResponseWriter, r *http.Request) {
+	h.mfa.webauthnBegin(w, r)
+}
+
+// FinishWebAuthnLogin completes an account login with a security key
+func (h *AccountAuthHandler) FinishWebAuthnLogin(w http.ResponseWriter, r *http.Request) {
+	ticket, resp, ok := h.mfa.webauthnFinish(w, r)
+	if !ok {
+		return
+	}
+	h.writeLogin(w, r, ticket.User.Username, resp)
+}
+
+// BeginPasskeyLogin starts an account login with a passkey
+func (h *AccountAuthHandler) BeginPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	h.mfa.passkeyBegin(w, r)
+}
+
+// FinishPasskeyLogin completes an account's passkey login
+func (h *AccountAuthHandler) FinishPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	u, tokens, ok := h.mfa.passkeyFinish(w, r, func(userID string) (*models.User, error) {
+		acct, err := h.accountService.GetByUsername(r.Context(), userID)
+		if err != nil {
+			return nil, err
+		}
+		return &models.User{
+			ID:       acct.Identity.Name,
+			Username: acct.Identity.Name,
+			Role:     models.UserRoleAccount,
+			TenantID: acct.Identity.Owner,
+		}, nil
+	})
+	if !ok {
+		return
+	}
+	h.writeLogin(w, r, u.Username, &mfaLoginResponse{LoginResponse: tokens})
+}
+
 // ChangePassword handles password change
 func (h *AccountAuthHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
 	// Get authenticated user ID from context
@@ -0,0 +1,155 @@
+// Package v1 provides API key handlers for OweHost
+package v1
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/iSundram/OweHost/internal/api/middleware"
+	"github.com/iSundram/OweHost/internal/auth"
+	"github.com/iSundram/OweHost/internal/user"
+	"github.com/iSundram/OweHost/pkg/models"
+	"github.com/iSundram/OweHost/pkg/utils"
+)
+
+// APIKeyHandler handles API key requests
+type APIKeyHandler struct {
+	authService *auth.Service
+	userService *user.Service
+}
+
+// NewAPIKeyHandler creates a new API key handler
+func NewAPIKeyHandler(authService *auth.Service, userService *user.Service) *APIKeyHandler {
+	return &APIKeyHandler{
+		authService: authService,
+		userService: userService,
+	}
+}
+
+// createAPIKeyRequest is the body of a key creation
+type createAPIKeyRequest struct {
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	IPBindings []string   `json:"ip_bindings,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+}
+
+// sessionOnly refuses requests made with an API key. Keys are managed
+// from a login, so a leaked key can't mint or hide others.
+func (h *APIKeyHandler) sessionOnly(w http.ResponseWriter, r *http.Request) bool {
+	if middleware.GetAPIKeyID(r.Context()) != "" {
+		utils.WriteError(w, http.StatusForbidden, utils.ErrCodeForbidden, "API keys cannot manage API keys")
+		return false
+	}
+	return true
+}
+
+// List lists the caller's API keys. Admins may pass user_id for another
+// user's.
+func (h *APIKeyHandler) List(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+		return
+	}
+	if !h.sessionOnly(w, r) {
+		return
+	}
+
+	userID := middleware.GetUserID(r.Context())
+	if other := r.URL.Query().Get("user_id"); other != "" && other != userID {
+		if !h.isAdmin(userID) {
+			utils.WriteError(w, http.StatusForbidden, utils.ErrCodeForbidden, "Insufficient permissions")
+			return
+		}
+		userID = other
+	}
+
+	utils.WriteSuccess(w, h.authService.ListAPIKeys(userID))
+}
+
+// Create creates an API key for the caller. The key is only shown in this
+// response.
+func (h *APIKeyHandler) Create(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+		return
+	}
+	if !h.sessionOnly(w, r) {
+		return
+	}
+
+	var req createAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid request body")
+		return
+	}
+	if req.Name == "" {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Name is required")
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Expiry must be in the future")
+		return
+	}
+
+	userID := middleware.GetUserID(r.Context())
+	apiKey, key, err := h.authService.CreateAPIKey(userID, req.Name, req.Scopes, req.ExpiresAt, req.IPBindings, middleware.GetClientIP(r.Context()))
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidScope) || errors.Is(err, auth.ErrInvalidIPBinding) {
+			utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeValidation, err.Error())
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, utils.ErrCodeInternalError, err.Error())
+		return
+	}
+
+	utils.WriteCreated(w, map[string]interface{}{
+		"api_key": apiKey,
+		"key":     key,
+	})
+}
+
+// Revoke revokes one of the caller's API keys, or anyone's for an admin
+func (h *APIKeyHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+		return
+	}
+	if !h.sessionOnly(w, r) {
+		return
+	}
+
+	id := extractIDFromPath(r.URL.Path, "api-keys")
+	if id == "" {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "API key ID required")
+		return
+	}
+
+	userID := middleware.GetUserID(r.Context())
+	ownerID := userID
+	if h.isAdmin(userID) {
+		ownerID = ""
+	}
+
+	err := h.authService.RevokeAPIKey(id, ownerID, userID, middleware.GetClientIP(r.Context()))
+	switch {
+	case errors.Is(err, auth.ErrAPIKeyNotFound):
+		utils.WriteError(w, http.StatusNotFound, utils.ErrCodeNotFound, err.Error())
+		return
+	case errors.Is(err, auth.ErrAPIKeyRevoked):
+		utils.WriteError(w, http.StatusConflict, utils.ErrCodeConflict, err.Error())
+		return
+	case err != nil:
+		utils.WriteError(w, http.StatusInternalServerError, utils.ErrCodeInternalError, err.Error())
+		return
+	}
+
+	utils.WriteSuccess(w, map[string]string{"message": "API key revoked"})
+}
+
+func (h *APIKeyHandler) isAdmin(userID string) bool {
+	u, err := h.userService.Get(userID)
+	return err == nil && u.Role == models.UserRoleAdmin
+}
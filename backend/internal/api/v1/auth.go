@@ -3,11 +3,17 @@ package v1
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/iSundram/OweHost/internal/api/middleware"
 	"github.com/iSundram/OweHost/internal/auth"
+	"github.com/iSundram/OweHost/internal/bruteforce"
+	"github.com/iSundram/OweHost/internal/tenancy"
+	"github.com/iSundram/OweHost/internal/twofactor"
 	"github.com/iSundram/OweHost/internal/user"
 	"github.com/iSundram/OweHost/pkg/models"
 	"github.com/iSundram/OweHost/pkg/utils"
@@ -17,13 +23,36 @@ import (
 type AuthHandler struct {
 	authService *auth.Service
 	userService *user.Service
+	mfa         *mfaLogin
+	ssoOnly     map[models.UserRole]bool
 }
 
 // NewAuthHandler creates a new auth handler
-func NewAuthHandler(authSvc *auth.Service, userSvc *user.Service) *AuthHandler {
+func NewAuthHandler(authSvc *auth.Service, userSvc *user.Service, tfSvc *twofactor.Service, guard *bruteforce.Guard) *AuthHandler {
 	return &AuthHandler{
 		authService: authSvc,
 		userService: userSvc,
+		mfa: &mfaLogin{
+			authService: authSvc,
+			tfService:   tfSvc,
+			audience:    auth.MFAAudiencePanel,
+			guard:       guard,
+			surface:     bruteforce.SurfacePanel,
+			lock: func(username string, until time.Time) {
+				if err := userSvc.Lock(username, until); err != nil {
+					fmt.Printf("warning: failed to save lockout of %s: %v\n", username, err)
+				}
+			},
+		},
+	}
+}
+
+// RequireSSO makes users of the given roles log in through SSO; their
+// passwords and passkeys no longer get them in
+func (h *AuthHandler) RequireSSO(roles ...models.UserRole) {
+	h.ssoOnly = make(map[models.UserRole]bool, len(roles))
+	for _, role := range roles {
+		h.ssoOnly[role] = true
 	}
 }
 
@@ -40,24 +69,91 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := h.userService.ValidateCredentials(req.Username, req.Password)
+	if !h.mfa.allowed(w, r, req.Username, loginStepPassword) {
+		return
+	}
+
+	u, err := h.userService.ValidateCredentials(req.Username, req.Password)
+	if errors.Is(err, user.ErrAccountLocked) {
+		h.mfa.record(r, "", req.Username, loginStepPassword, false, false, "locked")
+		writeLoginBlocked(w, err)
+		return
+	}
 	if err != nil {
+		h.mfa.record(r, "", req.Username, loginStepPassword, false, false, "invalid_credentials")
+		h.mfa.fail(r, req.Username, "invalid_credentials")
 		utils.WriteError(w, http.StatusUnauthorized, utils.ErrCodeUnauthorized, "Invalid credentials")
 		return
 	}
 
+	if h.ssoOnly[u.Role] {
+		h.mfa.record(r, u.ID, u.Username, loginStepPassword, false, false, "sso_required")
+		utils.WriteError(w, http.StatusForbidden, utils.ErrCodeForbidden, "This account must sign in with single sign-on")
+		return
+	}
+
 	// Register user with auth service
-	h.authService.RegisterUser(user)
+	h.authService.RegisterUser(u)
 
-	tokens, err := h.authService.GenerateTokens(user)
-	if err != nil {
-		utils.WriteError(w, http.StatusInternalServerError, utils.ErrCodeInternalError, "Failed to generate tokens")
+	tokens, ok := h.mfa.begin(w, r, u, req.DeviceToken)
+	if !ok {
 		return
 	}
 
 	utils.WriteSuccess(w, tokens)
 }
 
+// VerifyLogin completes a login with the second factor
+func (h *AuthHandler) VerifyLogin(w http.ResponseWriter, r *http.Request) {
+	if _, resp, ok := h.mfa.verify(w, r); ok {
+		utils.WriteSuccess(w, resp)
+	}
+}
+
+// SetupLogin sets up the second factor of a user who must have one
+// before their login can complete
+func (h *AuthHandler) SetupLogin(w http.ResponseWriter, r *http.Request) {
+	h.mfa.setup(w, r)
+}
+
+// BeginWebAuthnLogin challenges the security keys of a login's user
+func (h *AuthHandler) BeginWebAuthnLogin(w http.ResponseWriter, r *http.Request) {
+	h.mfa.webauthnBegin(w, r)
+}
+
+// FinishWebAuthnLogin completes a login with a security key
+func (h *AuthHandler) FinishWebAuthnLogin(w http.ResponseWriter, r *http.Request) {
+	if _, resp, ok := h.mfa.webauthnFinish(w, r); ok {
+		utils.WriteSuccess(w, resp)
+	}
+}
+
+// BeginPasskeyLogin starts a login with a passkey instead of a password
+func (h *AuthHandler) BeginPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	h.mfa.passkeyBegin(w, r)
+}
+
+// FinishPasskeyLogin completes a passkey login
+func (h *AuthHandler) FinishPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	_, tokens, ok := h.mfa.passkeyFinish(w, r, func(userID string) (*models.User, error) {
+		user, err := h.userService.Get(userID)
+		if err != nil {
+			return nil, err
+		}
+		if user.Status != models.UserStatusActive {
+			return nil, errors.New("account is not active")
+		}
+		if h.ssoOnly[user.Role] {
+			return nil, errors.New("sso_required")
+		}
+		h.authService.RegisterUser(user)
+		return user, nil
+	})
+	if ok {
+		utils.WriteSuccess(w, tokens)
+	}
+}
+
 // Refresh handles token refresh
 func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -71,7 +167,7 @@ func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tokens, err := h.authService.RefreshTokens(req.RefreshToken)
+	tokens, err := h.authService.RefreshTokens(req.RefreshToken, loginIP(r), r.UserAgent())
 	if err != nil {
 		utils.WriteError(w, http.StatusUnauthorized, utils.ErrCodeUnauthorized, err.Error())
 		return
@@ -101,15 +197,54 @@ func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
 	utils.WriteSuccess(w, map[string]string{"message": "Logged out successfully"})
 }
 
+// JWKS publishes the public keys access tokens are signed with
+func (h *AuthHandler) JWKS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+		return
+	}
+
+	set, err := h.authService.JWKS()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, utils.ErrCodeInternalError, err.Error())
+		return
+	}
+
+	// Verifiers refetch when they meet a new kid, so a short cache is fine
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	utils.WriteJSON(w, http.StatusOK, set)
+}
+
+// RotateSigningKey replaces the access token signing key ahead of schedule
+func (h *AuthHandler) RotateSigningKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+		return
+	}
+
+	kid, err := h.authService.RotateSigningKey(middleware.GetUserID(r.Context()), middleware.GetClientIP(r.Context()))
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, utils.ErrCodeInternalError, err.Error())
+		return
+	}
+
+	utils.WriteSuccess(w, map[string]string{
+		"message": "Signing key rotated",
+		"kid":     kid,
+	})
+}
+
 // UserHandler handles user endpoints
 type UserHandler struct {
 	userService *user.Service
+	tenancy     *tenancy.Guard
 }
 
 // NewUserHandler creates a new user handler
-func NewUserHandler(userSvc *user.Service) *UserHandler {
+func NewUserHandler(userSvc *user.Service, guard *tenancy.Guard) *UserHandler {
 	return &UserHandler{
 		userService: userSvc,
+		tenancy:     guard,
 	}
 }
 
@@ -165,6 +300,9 @@ func (h *UserHandler) Get(w http.ResponseWriter, r *http.Request) {
 		utils.WriteError(w, http.StatusNotFound, utils.ErrCodeNotFound, err.Error())
 		return
 	}
+	if !authorizeOwner(w, r, h.tenancy, user.ID) {
+		return
+	}
 
 	utils.WriteSuccess(w, user)
 }
@@ -182,7 +320,13 @@ func (h *UserHandler) List(w http.ResponseWriter, r *http.Request) {
 		tenantPtr = &tenantID
 	}
 
-	users := h.userService.List(tenantPtr)
+	scope := callerScope(r, h.tenancy)
+	users := make([]*models.User, 0)
+	for _, u := range h.userService.List(tenantPtr) {
+		if u != nil && scope.Owns(u.ID) {
+			users = append(users, u)
+		}
+	}
 	utils.WriteSuccess(w, users)
 }
 
@@ -199,12 +343,19 @@ func (h *UserHandler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	userID := parts[len(parts)-1]
+	if !authorizeOwner(w, r, h.tenancy, userID) {
+		return
+	}
 
 	var req models.UserUpdateRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid request body")
 		return
 	}
+	if !h.canChangeRoleAndStatus(r, userID, &req) {
+		utils.WriteError(w, http.StatusForbidden, utils.ErrCodeForbidden, "Not allowed to change role or status")
+		return
+	}
 
 	user, err := h.userService.Update(userID, &req)
 	if err != nil {
@@ -215,6 +366,37 @@ func (h *UserHandler) Update(w http.ResponseWriter, r *http.Request) {
 	utils.WriteSuccess(w, user)
 }
 
+// canChangeRoleAndStatus checks the caller may make the role and status
+// changes of an update. Admins may make any; resellers may only move the
+// users below them between the roles below their own, and nobody else may
+// make any.
+func (h *UserHandler) canChangeRoleAndStatus(r *http.Request, userID string, req *models.UserUpdateRequest) bool {
+	if req.Role == nil && req.Status == nil {
+		return true
+	}
+	switch middleware.GetUserRole(r.Context()) {
+	case models.UserRoleAdmin:
+		return true
+	case models.UserRoleReseller:
+	default:
+		return false
+	}
+
+	if req.Status != nil || userID == middleware.GetUserID(r.Context()) {
+		return false
+	}
+	target, err := h.userService.Get(userID)
+	if err != nil {
+		return false
+	}
+	return belowReseller(target.Role) && belowReseller(*req.Role)
+}
+
+// belowReseller reports whether role ranks below a reseller
+func belowReseller(role models.UserRole) bool {
+	return role == models.UserRoleUser || role == models.UserRoleAccount
+}
+
 // Delete handles deleting a user
 func (h *UserHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
@@ -228,6 +410,9 @@ func (h *UserHandler) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	userID := parts[len(parts)-1]
+	if !authorizeOwner(w, r, h.tenancy, userID) {
+		return
+	}
 
 	if err := h.userService.Delete(userID); err != nil {
 		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
@@ -272,6 +457,9 @@ func (h *UserHandler) Suspend(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	userID := parts[len(parts)-2]
+	if !authorizeOwner(w, r, h.tenancy, userID) {
+		return
+	}
 
 	if err := h.userService.Suspend(userID); err != nil {
 		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
@@ -295,6 +483,9 @@ func (h *UserHandler) Terminate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	userID := parts[len(parts)-2]
+	if !authorizeOwner(w, r, h.tenancy, userID) {
+		return
+	}
 
 	if err := h.userService.Terminate(userID); err != nil {
 		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
@@ -0,0 +1,121 @@
+// Package v1 provides authorization policy handlers for OweHost
+package v1
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/iSundram/OweHost/internal/authorization"
+	"github.com/iSundram/OweHost/pkg/models"
+	"github.com/iSundram/OweHost/pkg/utils"
+)
+
+// AuthorizationHandler lets admins manage the policy rules API calls are
+// checked against, and see how a request would be decided
+type AuthorizationHandler struct {
+	authzService *authorization.Service
+}
+
+// NewAuthorizationHandler creates a new authorization handler
+func NewAuthorizationHandler(authzService *authorization.Service) *AuthorizationHandler {
+	return &AuthorizationHandler{authzService: authzService}
+}
+
+// ListPolicies lists the policy rules in the order they are tried
+// (GET /api/v1/authorization/policies)
+func (h *AuthorizationHandler) ListPolicies(w http.ResponseWriter, r *http.Request) {
+	utils.WriteSuccess(w, h.authzService.ListPolicyRules())
+}
+
+// CreatePolicy creates a policy rule (POST /api/v1/authorization/policies)
+func (h *AuthorizationHandler) CreatePolicy(w http.ResponseWriter, r *http.Request) {
+	var req models.PolicyRuleCreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid request body")
+		return
+	}
+	if req.Name == "" {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeValidation, "Name is required")
+		return
+	}
+
+	rule, err := h.authzService.CreatePolicyRule(&req)
+	switch {
+	case errors.Is(err, authorization.ErrInvalidPolicyRule):
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeValidation, err.Error())
+		return
+	case err != nil:
+		utils.WriteError(w, http.StatusInternalServerError, utils.ErrCodeInternalError, err.Error())
+		return
+	}
+
+	utils.WriteCreated(w, rule)
+}
+
+// updatePolicyRequest turns a policy rule on or off
+type updatePolicyRequest struct {
+	Enabled *bool `json:"enabled"`
+}
+
+// UpdatePolicy enables or disables a policy rule
+// (PATCH /api/v1/authorization/policies/{id})
+func (h *AuthorizationHandler) UpdatePolicy(w http.ResponseWriter, r *http.Request) {
+	id := extractIDFromPath(r.URL.Path, "authorization/policies")
+	if id == "" {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Policy rule ID required")
+		return
+	}
+
+	var req updatePolicyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid request body")
+		return
+	}
+	if req.Enabled == nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeValidation, "enabled is required")
+		return
+	}
+
+	h.writePolicyResult(w, id, h.authzService.SetPolicyRuleEnabled(id, *req.Enabled))
+}
+
+// DeletePolicy deletes a policy rule
+// (DELETE /api/v1/authorization/policies/{id})
+func (h *AuthorizationHandler) DeletePolicy(w http.ResponseWriter, r *http.Request) {
+	id := extractIDFromPath(r.URL.Path, "authorization/policies")
+	if id == "" {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Policy rule ID required")
+		return
+	}
+
+	h.writePolicyResult(w, id, h.authzService.DeletePolicyRule(id))
+}
+
+func (h *AuthorizationHandler) writePolicyResult(w http.ResponseWriter, id string, err error) {
+	switch {
+	case errors.Is(err, authorization.ErrPolicyRuleNotFound):
+		utils.WriteError(w, http.StatusNotFound, utils.ErrCodeNotFound, err.Error())
+	case err != nil:
+		utils.WriteError(w, http.StatusInternalServerError, utils.ErrCodeInternalError, err.Error())
+	default:
+		utils.WriteSuccess(w, map[string]string{"id": id})
+	}
+}
+
+// Check decides a request as the API would and explains which rule
+// decided it (POST /api/v1/authorization/check)
+func (h *AuthorizationHandler) Check(w http.ResponseWriter, r *http.Request) {
+	var req models.AuthorizationCheckRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid request body")
+		return
+	}
+	if req.UserID == "" || req.Resource == "" || req.Action == "" {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeValidation, "user_id, resource and action are required")
+		return
+	}
+	req.Explain = true
+
+	utils.WriteSuccess(w, h.authzService.EvaluatePolicy(&req))
+}
@@ -5,7 +5,9 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/iSundram/OweHost/internal/api/middleware"
 	"github.com/iSundram/OweHost/internal/backup"
+	"github.com/iSundram/OweHost/internal/tenancy"
 	"github.com/iSundram/OweHost/internal/user"
 	"github.com/iSundram/OweHost/pkg/models"
 )
@@ -13,20 +15,26 @@ import (
 type BackupHandler struct {
 	backupService *backup.Service
 	userService   *user.Service
+	tenancy       *tenancy.Guard
 }
 
-func NewBackupHandler(backupService *backup.Service, userService *user.Service) *BackupHandler {
+func NewBackupHandler(backupService *backup.Service, userService *user.Service, guard *tenancy.Guard) *BackupHandler {
 	return &BackupHandler{
 		backupService: backupService,
 		userService:   userService,
+		tenancy:       guard,
 	}
 }
 
-// ListBackups lists all backups for the authenticated user
+// ListBackups lists the backups the authenticated user can reach
 func (h *BackupHandler) ListBackups(w http.ResponseWriter, r *http.Request) {
-	userID := r.Context().Value("user_id").(string)
-
-	backups := h.backupService.ListByUser(userID)
+	scope := callerScope(r, h.tenancy)
+	backups := make([]*models.Backup, 0)
+	for _, b := range h.backupService.ListAll() {
+		if scope.Owns(b.UserID) {
+			backups = append(backups, b)
+		}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(backups)
@@ -34,7 +42,6 @@ func (h *BackupHandler) ListBackups(w http.ResponseWriter, r *http.Request) {
 
 // GetBackup retrieves a specific backup
 func (h *BackupHandler) GetBackup(w http.ResponseWriter, r *http.Request) {
-	userID := r.Context().Value("user_id").(string)
 	parts := strings.Split(strings.TrimSuffix(r.URL.Path, "/"), "/")
 	if len(parts) < 5 {
 		http.Error(w, "Invalid backup ID", http.StatusBadRequest)
@@ -48,13 +55,8 @@ func (h *BackupHandler) GetBackup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Authorization check
-	if backup.UserID != userID {
-		role := r.Context().Value("role").(string)
-		if role != "admin" {
-			http.Error(w, "Unauthorized", http.StatusForbidden)
-			return
-		}
+	if !authorizeOwner(w, r, h.tenancy, backup.UserID) {
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -63,7 +65,7 @@ func (h *BackupHandler) GetBackup(w http.ResponseWriter, r *http.Request) {
 
 // CreateBackup creates a new backup
 func (h *BackupHandler) CreateBackup(w http.ResponseWriter, r *http.Request) {
-	userID := r.Context().Value("user_id").(string)
+	userID := middleware.GetUserID(r.Context())
 
 	var req models.BackupCreateRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -84,7 +86,6 @@ func (h *BackupHandler) CreateBackup(w http.ResponseWriter, r *http.Request) {
 
 // DeleteBackup deletes a backup
 func (h *BackupHandler) DeleteBackup(w http.ResponseWriter, r *http.Request) {
-	userID := r.Context().Value("user_id").(string)
 	parts := strings.Split(strings.TrimSuffix(r.URL.Path, "/"), "/")
 	if len(parts) < 5 {
 		http.Error(w, "Invalid backup ID", http.StatusBadRequest)
@@ -98,13 +99,8 @@ func (h *BackupHandler) DeleteBackup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Authorization check
-	if backup.UserID != userID {
-		role := r.Context().Value("role").(string)
-		if role != "admin" {
-			http.Error(w, "Unauthorized", http.StatusForbidden)
-			return
-		}
+	if !authorizeOwner(w, r, h.tenancy, backup.UserID) {
+		return
 	}
 
 	if err := h.backupService.Delete(backupID); err != nil {
@@ -117,7 +113,6 @@ func (h *BackupHandler) DeleteBackup(w http.ResponseWriter, r *http.Request) {
 
 // RestoreBackup restores a backup
 func (h *BackupHandler) RestoreBackup(w http.ResponseWriter, r *http.Request) {
-	userID := r.Context().Value("user_id").(string)
 	parts := strings.Split(strings.TrimSuffix(r.URL.Path, "/"), "/")
 	if len(parts) < 6 {
 		http.Error(w, "Invalid backup ID", http.StatusBadRequest)
@@ -131,13 +126,8 @@ func (h *BackupHandler) RestoreBackup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Authorization check
-	if backup.UserID != userID {
-		role := r.Context().Value("role").(string)
-		if role != "admin" {
-			http.Error(w, "Unauthorized", http.StatusForbidden)
-			return
-		}
+	if !authorizeOwner(w, r, h.tenancy, backup.UserID) {
+		return
 	}
 
 	type RestoreReq struct {
@@ -146,7 +136,8 @@ func (h *BackupHandler) RestoreBackup(w http.ResponseWriter, r *http.Request) {
 	var restoreReq RestoreReq
 	json.NewDecoder(r.Body).Decode(&restoreReq)
 
-	restoreStatus, err := h.backupService.Restore(userID, &models.RestoreRequest{
+	// The restore runs as the backup's owner, whoever asked for it
+	restoreStatus, err := h.backupService.Restore(backup.UserID, &models.RestoreRequest{
 		BackupID: backupID,
 	})
 	if err != nil {
@@ -160,7 +151,6 @@ func (h *BackupHandler) RestoreBackup(w http.ResponseWriter, r *http.Request) {
 
 // DownloadBackup provides a download link for a backup
 func (h *BackupHandler) DownloadBackup(w http.ResponseWriter, r *http.Request) {
-	userID := r.Context().Value("user_id").(string)
 	parts := strings.Split(strings.TrimSuffix(r.URL.Path, "/"), "/")
 	if len(parts) < 6 {
 		http.Error(w, "Invalid backup ID", http.StatusBadRequest)
@@ -174,13 +164,8 @@ func (h *BackupHandler) DownloadBackup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Authorization check
-	if backup.UserID != userID {
-		role := r.Context().Value("role").(string)
-		if role != "admin" {
-			http.Error(w, "Unauthorized", http.StatusForbidden)
-			return
-		}
+	if !authorizeOwner(w, r, h.tenancy, backup.UserID) {
+		return
 	}
 
 	// Return the storage path as download URL placeholder
@@ -191,11 +176,15 @@ func (h *BackupHandler) DownloadBackup(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// GetBackupSchedule retrieves the backup schedule for a user
+// GetBackupSchedule retrieves the backup schedules the user can reach
 func (h *BackupHandler) GetBackupSchedule(w http.ResponseWriter, r *http.Request) {
-	userID := r.Context().Value("user_id").(string)
-
-	schedules := h.backupService.ListSchedules(userID)
+	scope := callerScope(r, h.tenancy)
+	schedules := make([]*models.BackupSchedule, 0)
+	for _, sched := range h.backupService.ListAllSchedules() {
+		if scope.Owns(sched.UserID) {
+			schedules = append(schedules, sched)
+		}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(schedules)
@@ -213,7 +202,16 @@ func (h *BackupHandler) UpdateBackupSchedule(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	err := h.backupService.UpdateSchedule(req.ScheduleID, req.Enabled)
+	schedule, err := h.backupService.GetSchedule(req.ScheduleID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !authorizeOwner(w, r, h.tenancy, schedule.UserID) {
+		return
+	}
+
+	err = h.backupService.UpdateSchedule(req.ScheduleID, req.Enabled)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -225,7 +223,6 @@ func (h *BackupHandler) UpdateBackupSchedule(w http.ResponseWriter, r *http.Requ
 
 // GetRestoreStatus retrieves the status of a restore operation
 func (h *BackupHandler) GetRestoreStatus(w http.ResponseWriter, r *http.Request) {
-	userID := r.Context().Value("user_id").(string)
 	parts := strings.Split(strings.TrimSuffix(r.URL.Path, "/"), "/")
 	if len(parts) < 6 {
 		http.Error(w, "Invalid restore ID", http.StatusBadRequest)
@@ -246,12 +243,8 @@ func (h *BackupHandler) GetRestoreStatus(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if backup.UserID != userID {
-		role := r.Context().Value("role").(string)
-		if role != "admin" {
-			http.Error(w, "Unauthorized", http.StatusForbidden)
-			return
-		}
+	if !authorizeOwner(w, r, h.tenancy, backup.UserID) {
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -5,7 +5,9 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/iSundram/OweHost/internal/api/middleware"
 	"github.com/iSundram/OweHost/internal/cron"
+	"github.com/iSundram/OweHost/internal/tenancy"
 	"github.com/iSundram/OweHost/internal/user"
 	"github.com/iSundram/OweHost/pkg/models"
 )
@@ -13,20 +15,26 @@ import (
 type CronHandler struct {
 	cronService *cron.Service
 	userService *user.Service
+	tenancy     *tenancy.Guard
 }
 
-func NewCronHandler(cronService *cron.Service, userService *user.Service) *CronHandler {
+func NewCronHandler(cronService *cron.Service, userService *user.Service, guard *tenancy.Guard) *CronHandler {
 	return &CronHandler{
 		cronService: cronService,
 		userService: userService,
+		tenancy:     guard,
 	}
 }
 
-// ListCronJobs lists all cron jobs for the authenticated user
+// ListCronJobs lists the cron jobs the authenticated user can reach
 func (h *CronHandler) ListCronJobs(w http.ResponseWriter, r *http.Request) {
-	userID := r.Context().Value("user_id").(string)
-
-	jobs := h.cronService.ListByUser(userID)
+	scope := callerScope(r, h.tenancy)
+	jobs := make([]*models.CronJob, 0)
+	for _, job := range h.cronService.ListAll() {
+		if scope.Owns(job.UserID) {
+			jobs = append(jobs, job)
+		}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(jobs)
@@ -34,7 +42,6 @@ func (h *CronHandler) ListCronJobs(w http.ResponseWriter, r *http.Request) {
 
 // GetCronJob retrieves a specific cron job
 func (h *CronHandler) GetCronJob(w http.ResponseWriter, r *http.Request) {
-	userID := r.Context().Value("user_id").(string)
 	parts := strings.Split(strings.TrimSuffix(r.URL.Path, "/"), "/")
 	if len(parts) < 6 {
 		http.Error(w, "Invalid cron job ID", http.StatusBadRequest)
@@ -48,13 +55,8 @@ func (h *CronHandler) GetCronJob(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Authorization check
-	if job.UserID != userID {
-		role := r.Context().Value("role").(string)
-		if role != "admin" {
-			http.Error(w, "Unauthorized", http.StatusForbidden)
-			return
-		}
+	if !authorizeOwner(w, r, h.tenancy, job.UserID) {
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -63,7 +65,7 @@ func (h *CronHandler) GetCronJob(w http.ResponseWriter, r *http.Request) {
 
 // CreateCronJob creates a new cron job
 func (h *CronHandler) CreateCronJob(w http.ResponseWriter, r *http.Request) {
-	userID := r.Context().Value("user_id").(string)
+	userID := middleware.GetUserID(r.Context())
 
 	var req models.CronJobCreateRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -84,7 +86,6 @@ func (h *CronHandler) CreateCronJob(w http.ResponseWriter, r *http.Request) {
 
 // UpdateCronJob updates a cron job
 func (h *CronHandler) UpdateCronJob(w http.ResponseWriter, r *http.Request) {
-	userID := r.Context().Value("user_id").(string)
 	parts := strings.Split(strings.TrimSuffix(r.URL.Path, "/"), "/")
 	if len(parts) < 6 {
 		http.Error(w, "Invalid cron job ID", http.StatusBadRequest)
@@ -98,13 +99,8 @@ func (h *CronHandler) UpdateCronJob(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Authorization check
-	if job.UserID != userID {
-		role := r.Context().Value("role").(string)
-		if role != "admin" {
-			http.Error(w, "Unauthorized", http.StatusForbidden)
-			return
-		}
+	if !authorizeOwner(w, r, h.tenancy, job.UserID) {
+		return
 	}
 
 	var req models.CronJobUpdateRequest
@@ -125,7 +121,6 @@ func (h *CronHandler) UpdateCronJob(w http.ResponseWriter, r *http.Request) {
 
 // DeleteCronJob deletes a cron job
 func (h *CronHandler) DeleteCronJob(w http.ResponseWriter, r *http.Request) {
-	userID := r.Context().Value("user_id").(string)
 	parts := strings.Split(strings.TrimSuffix(r.URL.Path, "/"), "/")
 	if len(parts) < 6 {
 		http.Error(w, "Invalid cron job ID", http.StatusBadRequest)
@@ -139,13 +134,8 @@ func (h *CronHandler) DeleteCronJob(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Authorization check
-	if job.UserID != userID {
-		role := r.Context().Value("role").(string)
-		if role != "admin" {
-			http.Error(w, "Unauthorized", http.StatusForbidden)
-			return
-		}
+	if !authorizeOwner(w, r, h.tenancy, job.UserID) {
+		return
 	}
 
 	if err := h.cronService.Delete(jobID); err != nil {
@@ -158,7 +148,6 @@ func (h *CronHandler) DeleteCronJob(w http.ResponseWriter, r *http.Request) {
 
 // EnableCronJob enables a cron job
 func (h *CronHandler) EnableCronJob(w http.ResponseWriter, r *http.Request) {
-	userID := r.Context().Value("user_id").(string)
 	parts := strings.Split(strings.TrimSuffix(r.URL.Path, "/"), "/")
 	if len(parts) < 7 {
 		http.Error(w, "Invalid cron job ID", http.StatusBadRequest)
@@ -172,13 +161,8 @@ func (h *CronHandler) EnableCronJob(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Authorization check
-	if job.UserID != userID {
-		role := r.Context().Value("role").(string)
-		if role != "admin" {
-			http.Error(w, "Unauthorized", http.StatusForbidden)
-			return
-		}
+	if !authorizeOwner(w, r, h.tenancy, job.UserID) {
+		return
 	}
 
 	if err := h.cronService.Resume(jobID); err != nil {
@@ -192,7 +176,6 @@ func (h *CronHandler) EnableCronJob(w http.ResponseWriter, r *http.Request) {
 
 // DisableCronJob disables a cron job
 func (h *CronHandler) DisableCronJob(w http.ResponseWriter, r *http.Request) {
-	userID := r.Context().Value("user_id").(string)
 	parts := strings.Split(strings.TrimSuffix(r.URL.Path, "/"), "/")
 	if len(parts) < 7 {
 		http.Error(w, "Invalid cron job ID", http.StatusBadRequest)
@@ -206,13 +189,8 @@ func (h *CronHandler) DisableCronJob(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Authorization check
-	if job.UserID != userID {
-		role := r.Context().Value("role").(string)
-		if role != "admin" {
-			http.Error(w, "Unauthorized", http.StatusForbidden)
-			return
-		}
+	if !authorizeOwner(w, r, h.tenancy, job.UserID) {
+		return
 	}
 
 	if err := h.cronService.Pause(jobID); err != nil {
@@ -226,7 +204,6 @@ func (h *CronHandler) DisableCronJob(w http.ResponseWriter, r *http.Request) {
 
 // GetCronJobExecutions retrieves execution history for a cron job
 func (h *CronHandler) GetCronJobExecutions(w http.ResponseWriter, r *http.Request) {
-	userID := r.Context().Value("user_id").(string)
 	parts := strings.Split(strings.TrimSuffix(r.URL.Path, "/"), "/")
 	if len(parts) < 7 {
 		http.Error(w, "Invalid cron job ID", http.StatusBadRequest)
@@ -240,13 +217,8 @@ func (h *CronHandler) GetCronJobExecutions(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Authorization check
-	if job.UserID != userID {
-		role := r.Context().Value("role").(string)
-		if role != "admin" {
-			http.Error(w, "Unauthorized", http.StatusForbidden)
-			return
-		}
+	if !authorizeOwner(w, r, h.tenancy, job.UserID) {
+		return
 	}
 
 	executions := h.cronService.GetExecutions(jobID, 50)
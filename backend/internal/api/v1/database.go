@@ -7,6 +7,7 @@ import (
 
 	"github.com/iSundram/OweHost/internal/api/middleware"
 	"github.com/iSundram/OweHost/internal/database"
+	"github.com/iSundram/OweHost/internal/tenancy"
 	"github.com/iSundram/OweHost/internal/user"
 	"github.com/iSundram/OweHost/pkg/models"
 	"github.com/iSundram/OweHost/pkg/utils"
@@ -16,13 +17,15 @@ import (
 type DatabaseHandler struct {
 	databaseService *database.Service
 	userService     *user.Service
+	tenancy         *tenancy.Guard
 }
 
 // NewDatabaseHandler creates a new database handler
-func NewDatabaseHandler(dbSvc *database.Service, userSvc *user.Service) *DatabaseHandler {
+func NewDatabaseHandler(dbSvc *database.Service, userSvc *user.Service, guard *tenancy.Guard) *DatabaseHandler {
 	return &DatabaseHandler{
 		databaseService: dbSvc,
 		userService:     userSvc,
+		tenancy:         guard,
 	}
 }
 
@@ -64,9 +67,8 @@ func (h *DatabaseHandler) Get(w http.ResponseWriter, r *http.Request) {
 	}
 	dbID := parts[len(parts)-1]
 
-	db, err := h.databaseService.Get(dbID)
-	if err != nil {
-		utils.WriteError(w, http.StatusNotFound, utils.ErrCodeNotFound, err.Error())
+	db, ok := h.authorizeDatabase(w, r, dbID)
+	if !ok {
 		return
 	}
 
@@ -80,21 +82,14 @@ func (h *DatabaseHandler) List(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userID := middleware.GetUserID(r.Context())
-	currentUser, err := h.userService.Get(userID)
-	if err != nil {
-		utils.WriteError(w, http.StatusUnauthorized, utils.ErrCodeUnauthorized, "User not found")
-		return
-	}
-
-	var databases []*models.Database
-
-	// Admin sees all databases
-	if currentUser.Role == models.UserRoleAdmin {
-		databases = h.databaseService.ListAll()
-	} else {
-		// Regular users and resellers see their own databases
-		databases = h.databaseService.ListByUser(userID)
+	// Admins see every database, resellers those of their tenants, and
+	// everyone else their own
+	scope := callerScope(r, h.tenancy)
+	databases := make([]*models.Database, 0)
+	for _, db := range h.databaseService.ListAll() {
+		if scope.Owns(db.UserID) {
+			databases = append(databases, db)
+		}
 	}
 
 	utils.WriteSuccess(w, databases)
@@ -113,6 +108,9 @@ func (h *DatabaseHandler) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	dbID := parts[len(parts)-1]
+	if _, ok := h.authorizeDatabase(w, r, dbID); !ok {
+		return
+	}
 
 	if err := h.databaseService.Delete(dbID); err != nil {
 		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
@@ -135,6 +133,9 @@ func (h *DatabaseHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	dbID := parts[len(parts)-2]
+	if _, ok := h.authorizeDatabase(w, r, dbID); !ok {
+		return
+	}
 
 	var req models.DatabaseUserCreateRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -164,6 +165,9 @@ func (h *DatabaseHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	dbID := parts[len(parts)-2]
+	if _, ok := h.authorizeDatabase(w, r, dbID); !ok {
+		return
+	}
 
 	users := h.databaseService.ListUsers(dbID)
 	utils.WriteSuccess(w, users)
@@ -183,6 +187,15 @@ func (h *DatabaseHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	}
 	userID := parts[len(parts)-1]
 
+	dbUser, err := h.databaseService.GetUser(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, utils.ErrCodeNotFound, err.Error())
+		return
+	}
+	if _, ok := h.authorizeDatabase(w, r, dbUser.DatabaseID); !ok {
+		return
+	}
+
 	if err := h.databaseService.DeleteUser(userID); err != nil {
 		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
 		return
@@ -204,6 +217,9 @@ func (h *DatabaseHandler) CreateBackup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	dbID := parts[len(parts)-2]
+	if _, ok := h.authorizeDatabase(w, r, dbID); !ok {
+		return
+	}
 
 	backup, err := h.databaseService.CreateBackup(dbID)
 	if err != nil {
@@ -227,6 +243,9 @@ func (h *DatabaseHandler) ListBackups(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	dbID := parts[len(parts)-2]
+	if _, ok := h.authorizeDatabase(w, r, dbID); !ok {
+		return
+	}
 
 	backups := h.databaseService.ListBackups(dbID)
 	utils.WriteSuccess(w, backups)
@@ -246,6 +265,15 @@ func (h *DatabaseHandler) RestoreBackup(w http.ResponseWriter, r *http.Request)
 	}
 	backupID := parts[len(parts)-2]
 
+	backup, err := h.databaseService.GetBackup(backupID)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, utils.ErrCodeNotFound, err.Error())
+		return
+	}
+	if _, ok := h.authorizeDatabase(w, r, backup.DatabaseID); !ok {
+		return
+	}
+
 	if err := h.databaseService.RestoreBackup(backupID); err != nil {
 		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
 		return
@@ -253,3 +281,16 @@ func (h *DatabaseHandler) RestoreBackup(w http.ResponseWriter, r *http.Request)
 
 	utils.WriteSuccess(w, map[string]string{"message": "Restore initiated"})
 }
+
+// authorizeDatabase looks up a database the caller must be able to reach
+func (h *DatabaseHandler) authorizeDatabase(w http.ResponseWriter, r *http.Request, dbID string) (*models.Database, bool) {
+	db, err := h.databaseService.Get(dbID)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, utils.ErrCodeNotFound, err.Error())
+		return nil, false
+	}
+	if !authorizeOwner(w, r, h.tenancy, db.UserID) {
+		return nil, false
+	}
+	return db, true
+}
@@ -5,9 +5,9 @@ import (
 	"net/http"
 	"strings"
 
-	"github.com/iSundram/OweHost/internal/api/middleware"
 	"github.com/iSundram/OweHost/internal/dns"
 	"github.com/iSundram/OweHost/internal/domain"
+	"github.com/iSundram/OweHost/internal/tenancy"
 	"github.com/iSundram/OweHost/pkg/models"
 	"github.com/iSundram/OweHost/pkg/utils"
 )
@@ -16,13 +16,15 @@ import (
 type DNSHandler struct {
 	dnsService    *dns.Service
 	domainService *domain.Service
+	tenancy       *tenancy.Guard
 }
 
 // NewDNSHandler creates a DNS handler.
-func NewDNSHandler(dnsSvc *dns.Service, domainSvc *domain.Service) *DNSHandler {
+func NewDNSHandler(dnsSvc *dns.Service, domainSvc *domain.Service, guard *tenancy.Guard) *DNSHandler {
 	return &DNSHandler{
 		dnsService:    dnsSvc,
 		domainService: domainSvc,
+		tenancy:       guard,
 	}
 }
 
@@ -47,11 +49,7 @@ func (h *DNSHandler) CreateZone(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// ownership check
-	userID := middleware.GetUserID(r.Context())
-	dom, err := h.domainService.Get(req.DomainID)
-	if err != nil || dom.UserID != userID {
-		utils.WriteError(w, http.StatusForbidden, utils.ErrCodeForbidden, "Access denied")
+	if _, ok := authorizeDomain(w, r, h.tenancy, h.domainService, req.DomainID); !ok {
 		return
 	}
 
@@ -64,28 +62,25 @@ func (h *DNSHandler) CreateZone(w http.ResponseWriter, r *http.Request) {
 	utils.WriteCreated(w, zone)
 }
 
-// ListZones lists zones for the current user (or all for admin).
+// ListZones lists the zones of the domains the caller can reach.
 func (h *DNSHandler) ListZones(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
 		return
 	}
 
-	userID := middleware.GetUserID(r.Context())
-	if userID == "" {
-		utils.WriteError(w, http.StatusUnauthorized, utils.ErrCodeUnauthorized, "Not authenticated")
-		return
-	}
-
-	// Admin: return all zones; others get zones for their domains
-	allZones := h.dnsService.ListAllZones()
+	scope := callerScope(r, h.tenancy)
 	filtered := make([]*models.DNSZone, 0)
-	for _, z := range allZones {
+	for _, z := range h.dnsService.ListAllZones() {
+		if scope.All() {
+			filtered = append(filtered, z)
+			continue
+		}
 		dom, err := h.domainService.Get(z.DomainID)
 		if err != nil {
 			continue
 		}
-		if dom.UserID == userID || dom.UserID == "" {
+		if scope.Owns(dom.UserID) {
 			filtered = append(filtered, z)
 		}
 	}
@@ -106,9 +101,8 @@ func (h *DNSHandler) GetZone(w http.ResponseWriter, r *http.Request) {
 	}
 	zoneID := parts[len(parts)-1]
 
-	zone, err := h.dnsService.GetZone(zoneID)
-	if err != nil {
-		utils.WriteError(w, http.StatusNotFound, utils.ErrCodeNotFound, err.Error())
+	zone, ok := h.authorizeZone(w, r, zoneID)
+	if !ok {
 		return
 	}
 
@@ -128,6 +122,9 @@ func (h *DNSHandler) DeleteZone(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	zoneID := parts[len(parts)-1]
+	if _, ok := h.authorizeZone(w, r, zoneID); !ok {
+		return
+	}
 
 	if err := h.dnsService.DeleteZone(zoneID); err != nil {
 		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
@@ -150,6 +147,9 @@ func (h *DNSHandler) CreateRecord(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	zoneID := parts[len(parts)-2]
+	if _, ok := h.authorizeZone(w, r, zoneID); !ok {
+		return
+	}
 
 	var req models.DNSRecordCreateRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -179,6 +179,9 @@ func (h *DNSHandler) ListRecords(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	zoneID := parts[len(parts)-2]
+	if _, ok := h.authorizeZone(w, r, zoneID); !ok {
+		return
+	}
 
 	records := h.dnsService.ListRecords(zoneID)
 	utils.WriteSuccess(w, records)
@@ -197,6 +200,9 @@ func (h *DNSHandler) UpdateRecord(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	recordID := parts[len(parts)-1]
+	if !h.authorizeRecord(w, r, recordID) {
+		return
+	}
 
 	var req models.DNSRecordCreateRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -226,6 +232,9 @@ func (h *DNSHandler) DeleteRecord(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	recordID := parts[len(parts)-1]
+	if !h.authorizeRecord(w, r, recordID) {
+		return
+	}
 
 	if err := h.dnsService.DeleteRecord(recordID); err != nil {
 		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
@@ -248,6 +257,9 @@ func (h *DNSHandler) EnableDNSSEC(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	zoneID := parts[len(parts)-2]
+	if _, ok := h.authorizeZone(w, r, zoneID); !ok {
+		return
+	}
 
 	key, err := h.dnsService.EnableDNSSEC(zoneID)
 	if err != nil {
@@ -271,6 +283,9 @@ func (h *DNSHandler) SyncZone(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	zoneID := parts[len(parts)-2]
+	if _, ok := h.authorizeZone(w, r, zoneID); !ok {
+		return
+	}
 
 	var req struct {
 		Provider string `json:"provider"`
@@ -291,3 +306,28 @@ func (h *DNSHandler) SyncZone(w http.ResponseWriter, r *http.Request) {
 
 	utils.WriteSuccess(w, state)
 }
+
+// authorizeZone looks up a zone whose domain the caller must be able to
+// reach
+func (h *DNSHandler) authorizeZone(w http.ResponseWriter, r *http.Request, zoneID string) (*models.DNSZone, bool) {
+	zone, err := h.dnsService.GetZone(zoneID)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, utils.ErrCodeNotFound, err.Error())
+		return nil, false
+	}
+	if _, ok := authorizeDomain(w, r, h.tenancy, h.domainService, zone.DomainID); !ok {
+		return nil, false
+	}
+	return zone, true
+}
+
+// authorizeRecord checks the caller can reach a record's zone
+func (h *DNSHandler) authorizeRecord(w http.ResponseWriter, r *http.Request, recordID string) bool {
+	record, err := h.dnsService.GetRecord(recordID)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, utils.ErrCodeNotFound, err.Error())
+		return false
+	}
+	_, ok := h.authorizeZone(w, r, record.ZoneID)
+	return ok
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/iSundram/OweHost/internal/api/middleware"
 	"github.com/iSundram/OweHost/internal/domain"
+	"github.com/iSundram/OweHost/internal/tenancy"
 	"github.com/iSundram/OweHost/internal/user"
 	"github.com/iSundram/OweHost/pkg/models"
 	"github.com/iSundram/OweHost/pkg/utils"
@@ -16,13 +17,15 @@ import (
 type DomainHandler struct {
 	domainService *domain.Service
 	userService   *user.Service
+	tenancy       *tenancy.Guard
 }
 
 // NewDomainHandler creates a new domain handler
-func NewDomainHandler(domainSvc *domain.Service, userSvc *user.Service) *DomainHandler {
+func NewDomainHandler(domainSvc *domain.Service, userSvc *user.Service, guard *tenancy.Guard) *DomainHandler {
 	return &DomainHandler{
 		domainService: domainSvc,
 		userService:   userSvc,
+		tenancy:       guard,
 	}
 }
 
@@ -69,16 +72,8 @@ func (h *DomainHandler) Get(w http.ResponseWriter, r *http.Request) {
 	}
 	domainID := parts[len(parts)-1]
 
-	domain, err := h.domainService.Get(domainID)
-	if err != nil {
-		utils.WriteError(w, http.StatusNotFound, utils.ErrCodeNotFound, err.Error())
-		return
-	}
-
-	// Check ownership
-	userID := middleware.GetUserID(r.Context())
-	if !h.domainService.CheckOwnership(userID, domainID) {
-		utils.WriteError(w, http.StatusForbidden, utils.ErrCodeForbidden, "Access denied")
+	domain, ok := authorizeDomain(w, r, h.tenancy, h.domainService, domainID)
+	if !ok {
 		return
 	}
 
@@ -92,21 +87,14 @@ func (h *DomainHandler) List(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userID := middleware.GetUserID(r.Context())
-	currentUser, err := h.userService.Get(userID)
-	if err != nil {
-		utils.WriteError(w, http.StatusUnauthorized, utils.ErrCodeUnauthorized, "User not found")
-		return
-	}
-
-	var domains []*models.Domain
-
-	// Admin sees all domains
-	if currentUser.Role == models.UserRoleAdmin {
-		domains = h.domainService.ListAll()
-	} else {
-		// Regular users and resellers see their own domains
-		domains = h.domainService.ListByUser(userID)
+	// Admins see every domain, resellers those of their tenants, and
+	// everyone else their own
+	scope := callerScope(r, h.tenancy)
+	domains := make([]*models.Domain, 0)
+	for _, d := range h.domainService.ListAll() {
+		if scope.Owns(d.UserID) {
+			domains = append(domains, d)
+		}
 	}
 
 	utils.WriteSuccess(w, domains)
@@ -126,9 +114,7 @@ func (h *DomainHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	}
 	domainID := parts[len(parts)-1]
 
-	userID := middleware.GetUserID(r.Context())
-	if !h.domainService.CheckOwnership(userID, domainID) {
-		utils.WriteError(w, http.StatusForbidden, utils.ErrCodeForbidden, "Access denied")
+	if _, ok := authorizeDomain(w, r, h.tenancy, h.domainService, domainID); !ok {
 		return
 	}
 
@@ -153,6 +139,9 @@ func (h *DomainHandler) Validate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	domainID := parts[len(parts)-2]
+	if _, ok := authorizeDomain(w, r, h.tenancy, h.domainService, domainID); !ok {
+		return
+	}
 
 	var req struct {
 		ValidationKey string `json:"validation_key"`
@@ -184,9 +173,7 @@ func (h *DomainHandler) CreateSubdomain(w http.ResponseWriter, r *http.Request)
 	}
 	domainID := parts[len(parts)-2]
 
-	userID := middleware.GetUserID(r.Context())
-	if !h.domainService.CheckOwnership(userID, domainID) {
-		utils.WriteError(w, http.StatusForbidden, utils.ErrCodeForbidden, "Access denied")
+	if _, ok := authorizeDomain(w, r, h.tenancy, h.domainService, domainID); !ok {
 		return
 	}
 
@@ -219,9 +206,7 @@ func (h *DomainHandler) ListSubdomains(w http.ResponseWriter, r *http.Request) {
 	}
 	domainID := parts[len(parts)-2]
 
-	userID := middleware.GetUserID(r.Context())
-	if !h.domainService.CheckOwnership(userID, domainID) {
-		utils.WriteError(w, http.StatusForbidden, utils.ErrCodeForbidden, "Access denied")
+	if _, ok := authorizeDomain(w, r, h.tenancy, h.domainService, domainID); !ok {
 		return
 	}
 
@@ -243,6 +228,15 @@ func (h *DomainHandler) DeleteSubdomain(w http.ResponseWriter, r *http.Request)
 	}
 	subdomainID := parts[len(parts)-1]
 
+	subdomain, err := h.domainService.GetSubdomain(subdomainID)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, utils.ErrCodeNotFound, err.Error())
+		return
+	}
+	if _, ok := authorizeDomain(w, r, h.tenancy, h.domainService, subdomain.DomainID); !ok {
+		return
+	}
+
 	if err := h.domainService.DeleteSubdomain(subdomainID); err != nil {
 		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
 		return
@@ -265,9 +259,7 @@ func (h *DomainHandler) CreateRedirect(w http.ResponseWriter, r *http.Request) {
 	}
 	domainID := parts[len(parts)-2]
 
-	userID := middleware.GetUserID(r.Context())
-	if !h.domainService.CheckOwnership(userID, domainID) {
-		utils.WriteError(w, http.StatusForbidden, utils.ErrCodeForbidden, "Access denied")
+	if _, ok := authorizeDomain(w, r, h.tenancy, h.domainService, domainID); !ok {
 		return
 	}
 
@@ -300,9 +292,7 @@ func (h *DomainHandler) ListRedirects(w http.ResponseWriter, r *http.Request) {
 	}
 	domainID := parts[len(parts)-2]
 
-	userID := middleware.GetUserID(r.Context())
-	if !h.domainService.CheckOwnership(userID, domainID) {
-		utils.WriteError(w, http.StatusForbidden, utils.ErrCodeForbidden, "Access denied")
+	if _, ok := authorizeDomain(w, r, h.tenancy, h.domainService, domainID); !ok {
 		return
 	}
 
@@ -323,6 +313,9 @@ func (h *DomainHandler) UpdateRedirect(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	redirectID := parts[len(parts)-1]
+	if !h.authorizeRedirect(w, r, redirectID) {
+		return
+	}
 
 	var req models.DomainRedirectCreateRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -352,6 +345,9 @@ func (h *DomainHandler) DeleteRedirect(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	redirectID := parts[len(parts)-1]
+	if !h.authorizeRedirect(w, r, redirectID) {
+		return
+	}
 
 	if err := h.domainService.DeleteRedirect(redirectID); err != nil {
 		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
@@ -374,6 +370,9 @@ func (h *DomainHandler) ToggleRedirect(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	redirectID := parts[len(parts)-2]
+	if !h.authorizeRedirect(w, r, redirectID) {
+		return
+	}
 
 	var req struct {
 		Enabled bool `json:"enabled"`
@@ -405,9 +404,7 @@ func (h *DomainHandler) CreateErrorPage(w http.ResponseWriter, r *http.Request)
 	}
 	domainID := parts[len(parts)-2]
 
-	userID := middleware.GetUserID(r.Context())
-	if !h.domainService.CheckOwnership(userID, domainID) {
-		utils.WriteError(w, http.StatusForbidden, utils.ErrCodeForbidden, "Access denied")
+	if _, ok := authorizeDomain(w, r, h.tenancy, h.domainService, domainID); !ok {
 		return
 	}
 
@@ -440,9 +437,7 @@ func (h *DomainHandler) ListErrorPages(w http.ResponseWriter, r *http.Request) {
 	}
 	domainID := parts[len(parts)-2]
 
-	userID := middleware.GetUserID(r.Context())
-	if !h.domainService.CheckOwnership(userID, domainID) {
-		utils.WriteError(w, http.StatusForbidden, utils.ErrCodeForbidden, "Access denied")
+	if _, ok := authorizeDomain(w, r, h.tenancy, h.domainService, domainID); !ok {
 		return
 	}
 
@@ -463,6 +458,9 @@ func (h *DomainHandler) UpdateErrorPage(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	errorPageID := parts[len(parts)-1]
+	if !h.authorizeErrorPage(w, r, errorPageID) {
+		return
+	}
 
 	var req models.DomainErrorPageCreateRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -492,6 +490,9 @@ func (h *DomainHandler) DeleteErrorPage(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	errorPageID := parts[len(parts)-1]
+	if !h.authorizeErrorPage(w, r, errorPageID) {
+		return
+	}
 
 	if err := h.domainService.DeleteErrorPage(errorPageID); err != nil {
 		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
@@ -515,9 +516,7 @@ func (h *DomainHandler) GetSettings(w http.ResponseWriter, r *http.Request) {
 	}
 	domainID := parts[len(parts)-2]
 
-	userID := middleware.GetUserID(r.Context())
-	if !h.domainService.CheckOwnership(userID, domainID) {
-		utils.WriteError(w, http.StatusForbidden, utils.ErrCodeForbidden, "Access denied")
+	if _, ok := authorizeDomain(w, r, h.tenancy, h.domainService, domainID); !ok {
 		return
 	}
 
@@ -544,9 +543,7 @@ func (h *DomainHandler) UpdateSettings(w http.ResponseWriter, r *http.Request) {
 	}
 	domainID := parts[len(parts)-2]
 
-	userID := middleware.GetUserID(r.Context())
-	if !h.domainService.CheckOwnership(userID, domainID) {
-		utils.WriteError(w, http.StatusForbidden, utils.ErrCodeForbidden, "Access denied")
+	if _, ok := authorizeDomain(w, r, h.tenancy, h.domainService, domainID); !ok {
 		return
 	}
 
@@ -587,6 +584,14 @@ func (h *DomainHandler) TransferDomain(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Both the domain and whoever it goes to must be the caller's tenants
+	if _, ok := authorizeDomain(w, r, h.tenancy, h.domainService, domainID); !ok {
+		return
+	}
+	if !authorizeOwner(w, r, h.tenancy, req.NewUserID) {
+		return
+	}
+
 	if err := h.domainService.TransferDomain(domainID, req.NewUserID); err != nil {
 		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
 		return
@@ -594,3 +599,25 @@ func (h *DomainHandler) TransferDomain(w http.ResponseWriter, r *http.Request) {
 
 	utils.WriteSuccess(w, map[string]string{"message": "Domain transferred"})
 }
+
+// authorizeRedirect checks the caller can reach a redirect's domain
+func (h *DomainHandler) authorizeRedirect(w http.ResponseWriter, r *http.Request, redirectID string) bool {
+	redirect, err := h.domainService.GetRedirect(redirectID)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, utils.ErrCodeNotFound, err.Error())
+		return false
+	}
+	_, ok := authorizeDomain(w, r, h.tenancy, h.domainService, redirect.DomainID)
+	return ok
+}
+
+// authorizeErrorPage checks the caller can reach an error page's domain
+func (h *DomainHandler) authorizeErrorPage(w http.ResponseWriter, r *http.Request, errorPageID string) bool {
+	errorPage, err := h.domainService.GetErrorPage(errorPageID)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, utils.ErrCodeNotFound, err.Error())
+		return false
+	}
+	_, ok := authorizeDomain(w, r, h.tenancy, h.domainService, errorPage.DomainID)
+	return ok
+}
@@ -2,12 +2,15 @@ package v1
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 	"strconv"
 	"strings"
 
 	"github.com/iSundram/OweHost/internal/api/middleware"
 	"github.com/iSundram/OweHost/internal/git"
+	"github.com/iSundram/OweHost/internal/tenancy"
 	"github.com/iSundram/OweHost/pkg/models"
 	"github.com/iSundram/OweHost/pkg/utils"
 )
@@ -15,12 +18,14 @@ import (
 // GitHandler handles Git repository endpoints
 type GitHandler struct {
 	gitService *git.Service
+	tenancy    *tenancy.Guard
 }
 
 // NewGitHandler creates a new git handler
-func NewGitHandler(gitSvc *git.Service) *GitHandler {
+func NewGitHandler(gitSvc *git.Service, guard *tenancy.Guard) *GitHandler {
 	return &GitHandler{
 		gitService: gitSvc,
+		tenancy:    guard,
 	}
 }
 
@@ -62,9 +67,8 @@ func (h *GitHandler) Get(w http.ResponseWriter, r *http.Request) {
 	}
 	repoID := parts[len(parts)-1]
 
-	repo, err := h.gitService.Get(repoID)
-	if err != nil {
-		utils.WriteError(w, http.StatusNotFound, utils.ErrCodeNotFound, err.Error())
+	repo, ok := h.authorizeRepository(w, r, repoID)
+	if !ok {
 		return
 	}
 
@@ -81,9 +85,14 @@ func (h *GitHandler) List(w http.ResponseWriter, r *http.Request) {
 	userID := middleware.GetUserID(r.Context())
 	domainID := r.URL.Query().Get("domain_id")
 
-	var repos []*models.GitRepository
+	repos := make([]*models.GitRepository, 0)
 	if domainID != "" {
-		repos = h.gitService.ListByDomain(domainID)
+		scope := callerScope(r, h.tenancy)
+		for _, repo := range h.gitService.ListByDomain(domainID) {
+			if scope.Owns(repo.UserID) {
+				repos = append(repos, repo)
+			}
+		}
 	} else {
 		repos = h.gitService.ListByUser(userID)
 	}
@@ -105,6 +114,10 @@ func (h *GitHandler) Update(w http.ResponseWriter, r *http.Request) {
 	}
 	repoID := parts[len(parts)-1]
 
+	if _, ok := h.authorizeRepository(w, r, repoID); !ok {
+		return
+	}
+
 	var req models.GitRepositoryUpdateRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid request body")
@@ -134,6 +147,10 @@ func (h *GitHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	}
 	repoID := parts[len(parts)-1]
 
+	if _, ok := h.authorizeRepository(w, r, repoID); !ok {
+		return
+	}
+
 	if err := h.gitService.Delete(repoID); err != nil {
 		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
 		return
@@ -180,6 +197,10 @@ func (h *GitHandler) Pull(w http.ResponseWriter, r *http.Request) {
 	}
 	repoID := parts[len(parts)-2]
 
+	if _, ok := h.authorizeRepository(w, r, repoID); !ok {
+		return
+	}
+
 	var req struct {
 		Branch string `json:"branch"`
 	}
@@ -207,6 +228,10 @@ func (h *GitHandler) Deploy(w http.ResponseWriter, r *http.Request) {
 	}
 	repoID := parts[len(parts)-2]
 
+	if _, ok := h.authorizeRepository(w, r, repoID); !ok {
+		return
+	}
+
 	deployment, err := h.gitService.Deploy(repoID)
 	if err != nil {
 		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
@@ -216,6 +241,33 @@ func (h *GitHandler) Deploy(w http.ResponseWriter, r *http.Request) {
 	utils.WriteSuccess(w, deployment)
 }
 
+// ListDeployments handles listing a repository's deployment history
+func (h *GitHandler) ListDeployments(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+		return
+	}
+
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) < 5 {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Repository ID required")
+		return
+	}
+	repoID := parts[len(parts)-2]
+
+	if _, ok := h.authorizeRepository(w, r, repoID); !ok {
+		return
+	}
+
+	deployments, err := h.gitService.ListDeployments(repoID)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, utils.ErrCodeNotFound, err.Error())
+		return
+	}
+
+	utils.WriteSuccess(w, deployments)
+}
+
 // GetBranches handles getting branches
 func (h *GitHandler) GetBranches(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -230,6 +282,10 @@ func (h *GitHandler) GetBranches(w http.ResponseWriter, r *http.Request) {
 	}
 	repoID := parts[len(parts)-2]
 
+	if _, ok := h.authorizeRepository(w, r, repoID); !ok {
+		return
+	}
+
 	branches, err := h.gitService.GetBranches(repoID)
 	if err != nil {
 		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
@@ -253,6 +309,10 @@ func (h *GitHandler) GetCommits(w http.ResponseWriter, r *http.Request) {
 	}
 	repoID := parts[len(parts)-2]
 
+	if _, ok := h.authorizeRepository(w, r, repoID); !ok {
+		return
+	}
+
 	branch := r.URL.Query().Get("branch")
 	limitStr := r.URL.Query().Get("limit")
 	limit := 10
@@ -285,6 +345,10 @@ func (h *GitHandler) AddDeployKey(w http.ResponseWriter, r *http.Request) {
 	}
 	repoID := parts[len(parts)-2]
 
+	if _, ok := h.authorizeRepository(w, r, repoID); !ok {
+		return
+	}
+
 	var req models.DeployKeyCreateRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid request body")
@@ -314,6 +378,10 @@ func (h *GitHandler) ListDeployKeys(w http.ResponseWriter, r *http.Request) {
 	}
 	repoID := parts[len(parts)-2]
 
+	if _, ok := h.authorizeRepository(w, r, repoID); !ok {
+		return
+	}
+
 	keys := h.gitService.ListDeployKeys(repoID)
 	utils.WriteSuccess(w, keys)
 }
@@ -332,6 +400,15 @@ func (h *GitHandler) RemoveDeployKey(w http.ResponseWriter, r *http.Request) {
 	}
 	keyID := parts[len(parts)-1]
 
+	key, err := h.gitService.GetDeployKey(keyID)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, utils.ErrCodeNotFound, err.Error())
+		return
+	}
+	if _, ok := h.authorizeRepository(w, r, key.RepositoryID); !ok {
+		return
+	}
+
 	if err := h.gitService.RemoveDeployKey(keyID); err != nil {
 		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
 		return
@@ -354,6 +431,10 @@ func (h *GitHandler) AddWebhook(w http.ResponseWriter, r *http.Request) {
 	}
 	repoID := parts[len(parts)-2]
 
+	if _, ok := h.authorizeRepository(w, r, repoID); !ok {
+		return
+	}
+
 	var req models.GitWebhookCreateRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid request body")
@@ -383,6 +464,10 @@ func (h *GitHandler) ListWebhooks(w http.ResponseWriter, r *http.Request) {
 	}
 	repoID := parts[len(parts)-2]
 
+	if _, ok := h.authorizeRepository(w, r, repoID); !ok {
+		return
+	}
+
 	webhooks := h.gitService.ListWebhooks(repoID)
 	utils.WriteSuccess(w, webhooks)
 }
@@ -401,6 +486,15 @@ func (h *GitHandler) RemoveWebhook(w http.ResponseWriter, r *http.Request) {
 	}
 	webhookID := parts[len(parts)-1]
 
+	webhook, err := h.gitService.GetWebhook(webhookID)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, utils.ErrCodeNotFound, err.Error())
+		return
+	}
+	if _, ok := h.authorizeRepository(w, r, webhook.RepositoryID); !ok {
+		return
+	}
+
 	if err := h.gitService.RemoveWebhook(webhookID); err != nil {
 		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
 		return
@@ -408,3 +502,90 @@ func (h *GitHandler) RemoveWebhook(w http.ResponseWriter, r *http.Request) {
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// ReceiveWebhook handles push deliveries from GitHub, GitLab and Gitea. It is
+// not behind panel auth; deliveries are verified with the webhook secret.
+func (h *GitHandler) ReceiveWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+		return
+	}
+
+	webhookID := strings.TrimPrefix(r.URL.Path, git.WebhookPathPrefix)
+	if webhookID == "" || strings.Contains(webhookID, "/") {
+		utils.WriteError(w, http.StatusNotFound, utils.ErrCodeNotFound, "Webhook not found")
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 5<<20))
+	if err != nil {
+		utils.WriteError(w, http.StatusRequestEntityTooLarge, utils.ErrCodeBadRequest, "Payload too large")
+		return
+	}
+
+	deployment, err := h.gitService.ReceiveWebhook(webhookID, r.Header, body)
+	switch {
+	case errors.Is(err, git.ErrWebhookNotFound):
+		utils.WriteError(w, http.StatusNotFound, utils.ErrCodeNotFound, "Webhook not found")
+	case errors.Is(err, git.ErrWebhookSignature):
+		utils.WriteError(w, http.StatusUnauthorized, utils.ErrCodeUnauthorized, err.Error())
+	case err != nil:
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
+	case deployment == nil:
+		utils.WriteSuccess(w, map[string]string{"status": "ignored"})
+	default:
+		utils.WriteJSON(w, http.StatusAccepted, utils.APIResponse{Success: true, Data: deployment})
+	}
+}
+
+// SmartHTTP serves repositories over the git smart HTTP protocol at
+// /git/<account>/<repo>.git using the account's panel credentials
+func (h *GitHandler) SmartHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, git.HTTPPathPrefix), "/", 3)
+	if len(parts) < 2 || !strings.HasSuffix(parts[1], ".git") {
+		http.NotFound(w, r)
+		return
+	}
+	accountName, repoName := parts[0], strings.TrimSuffix(parts[1], ".git")
+
+	username, password, ok := r.BasicAuth()
+	if ok {
+		identity, err := h.gitService.Authenticate(username, password)
+		ok = err == nil && identity.Name == accountName
+	}
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="OweHost Git"`)
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	repo, err := h.gitService.GetByName(accountName, repoName)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	backend, err := h.gitService.HTTPBackend(repo)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// git-http-backend sets its own content type
+	w.Header().Del("Content-Type")
+	backend.ServeHTTP(w, r)
+}
+
+// authorizeRepository looks up a repository the caller must be able to
+// reach, refusing the request when it is missing or out of reach
+func (h *GitHandler) authorizeRepository(w http.ResponseWriter, r *http.Request, repoID string) (*models.GitRepository, bool) {
+	repo, err := h.gitService.Get(repoID)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, utils.ErrCodeNotFound, err.Error())
+		return nil, false
+	}
+	if !authorizeOwner(w, r, h.tenancy, repo.UserID) {
+		return nil, false
+	}
+	return repo, true
+}
@@ -0,0 +1,147 @@
+// Package v1 provides impersonation handlers for OweHost
+package v1
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/iSundram/OweHost/internal/accountsvc"
+	"github.com/iSundram/OweHost/internal/api/middleware"
+	"github.com/iSundram/OweHost/internal/auth"
+	"github.com/iSundram/OweHost/internal/tenancy"
+	"github.com/iSundram/OweHost/internal/user"
+	"github.com/iSundram/OweHost/pkg/models"
+	"github.com/iSundram/OweHost/pkg/utils"
+)
+
+// ImpersonationHandler lets admins, and resellers for what they own, act
+// as a user to see what the user sees
+type ImpersonationHandler struct {
+	authService    *auth.Service
+	userService    *user.Service
+	accountService *accountsvc.Service
+	guard          *tenancy.Guard
+}
+
+// NewImpersonationHandler creates a new impersonation handler
+func NewImpersonationHandler(authService *auth.Service, userService *user.Service, accountService *accountsvc.Service, guard *tenancy.Guard) *ImpersonationHandler {
+	return &ImpersonationHandler{
+		authService:    authService,
+		userService:    userService,
+		accountService: accountService,
+		guard:          guard,
+	}
+}
+
+// impersonateRequest names the panel user or hosting account to act as
+type impersonateRequest struct {
+	UserID  string `json:"user_id,omitempty"`
+	Account string `json:"account,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// Start issues a token acting as a user (POST /api/v1/impersonate)
+func (h *ImpersonationHandler) Start(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+		return
+	}
+
+	actor, err := h.userService.Get(middleware.GetUserID(r.Context()))
+	if err != nil || (actor.Role != models.UserRoleAdmin && actor.Role != models.UserRoleReseller) {
+		utils.WriteError(w, http.StatusForbidden, utils.ErrCodeForbidden, "Insufficient permissions")
+		return
+	}
+
+	var req impersonateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid request body")
+		return
+	}
+	if (req.UserID == "") == (req.Account == "") {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeValidation, "Exactly one of user_id and account is required")
+		return
+	}
+
+	target, err := h.resolveTarget(r, &req)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, utils.ErrCodeNotFound, err.Error())
+		return
+	}
+	switch {
+	case target.ID == actor.ID:
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Cannot impersonate yourself")
+		return
+	case target.Role == models.UserRoleAdmin:
+		utils.WriteError(w, http.StatusForbidden, utils.ErrCodeForbidden, "Admins cannot be impersonated")
+		return
+	case !h.guard.Scope(r.Context(), actor.ID).Owns(target.ID):
+		utils.WriteError(w, http.StatusForbidden, utils.ErrCodeForbidden, "User is not owned by this reseller")
+		return
+	}
+
+	token, err := h.authService.Impersonate(&auth.Impersonator{
+		UserID:   actor.ID,
+		Username: actor.Username,
+		Role:     actor.Role,
+	}, target, strings.TrimSpace(req.Reason), middleware.GetClientIP(r.Context()), r.UserAgent())
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, utils.ErrCodeInternalError, err.Error())
+		return
+	}
+
+	utils.WriteCreated(w, token)
+}
+
+// Status tells the frontend whether to show the impersonation banner
+// (GET /api/v1/impersonate)
+func (h *ImpersonationHandler) Status(w http.ResponseWriter, r *http.Request) {
+	impersonator := middleware.GetImpersonator(r.Context())
+	utils.WriteSuccess(w, map[string]interface{}{
+		"impersonating": impersonator != nil,
+		"impersonator":  impersonator,
+		"user_id":       middleware.GetUserID(r.Context()),
+	})
+}
+
+// End ends the impersonation the request's token belongs to
+// (DELETE /api/v1/impersonate)
+func (h *ImpersonationHandler) End(w http.ResponseWriter, r *http.Request) {
+	err := h.authService.EndImpersonation(middleware.GetSessionID(r.Context()),
+		middleware.GetImpersonator(r.Context()), middleware.GetClientIP(r.Context()))
+	switch {
+	case errors.Is(err, auth.ErrNotImpersonating):
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
+		return
+	case err != nil:
+		utils.WriteError(w, http.StatusInternalServerError, utils.ErrCodeInternalError, err.Error())
+		return
+	}
+
+	utils.WriteSuccess(w, map[string]string{"message": "Impersonation ended"})
+}
+
+// resolveTarget finds the user to act as. An account acts as itself, as it
+// does when it logs in.
+func (h *ImpersonationHandler) resolveTarget(r *http.Request, req *impersonateRequest) (*models.User, error) {
+	if req.Account != "" {
+		acct, err := h.accountService.GetByUsername(r.Context(), req.Account)
+		if err != nil {
+			return nil, errors.New("account not found")
+		}
+		return &models.User{
+			ID:       acct.Identity.Name,
+			Username: acct.Identity.Name,
+			Role:     models.UserRoleAccount,
+			TenantID: acct.Identity.Owner,
+		}, nil
+	}
+
+	target, err := h.userService.Get(req.UserID)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+	return target, nil
+}
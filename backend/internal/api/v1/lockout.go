@@ -0,0 +1,83 @@
+// Package v1 provides login lockout handlers for OweHost
+package v1
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/iSundram/OweHost/internal/api/middleware"
+	"github.com/iSundram/OweHost/internal/bruteforce"
+	"github.com/iSundram/OweHost/internal/user"
+	"github.com/iSundram/OweHost/pkg/utils"
+)
+
+// LockoutHandler lets admins see and lift the lockouts and bans put in
+// after failed logins
+type LockoutHandler struct {
+	guard       *bruteforce.Guard
+	userService *user.Service
+}
+
+// NewLockoutHandler creates a new lockout handler
+func NewLockoutHandler(guard *bruteforce.Guard, userService *user.Service) *LockoutHandler {
+	return &LockoutHandler{
+		guard:       guard,
+		userService: userService,
+	}
+}
+
+// unlockRequest names the username or address to unlock
+type unlockRequest struct {
+	Username string `json:"username,omitempty"`
+	IP       string `json:"ip,omitempty"`
+}
+
+// List lists the usernames and addresses held back
+// (GET /api/v1/security/lockouts)
+func (h *LockoutHandler) List(w http.ResponseWriter, r *http.Request) {
+	utils.WriteSuccess(w, h.guard.Locks())
+}
+
+// Unlock lifts the lockout of a username on every login surface, or the
+// lockout and firewall ban of an address
+// (POST /api/v1/security/lockouts/unlock)
+func (h *LockoutHandler) Unlock(w http.ResponseWriter, r *http.Request) {
+	var req unlockRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid request body")
+		return
+	}
+	req.Username = strings.TrimSpace(req.Username)
+	req.IP = strings.TrimSpace(req.IP)
+	if (req.Username == "") == (req.IP == "") {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeValidation, "Exactly one of username and ip is required")
+		return
+	}
+
+	actor := middleware.GetUserID(r.Context())
+	actorIP := middleware.GetClientIP(r.Context())
+
+	if req.Username != "" {
+		found := h.guard.UnlockUser(req.Username, actor, actorIP)
+		// The saved lock of a panel user outlives restarts of the guard
+		if err := h.userService.Unlock(req.Username); err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, utils.ErrCodeInternalError, err.Error())
+			return
+		}
+		utils.WriteSuccess(w, map[string]interface{}{"username": req.Username, "was_locked": found})
+		return
+	}
+
+	if net.ParseIP(req.IP) == nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeValidation, "Invalid IP address")
+		return
+	}
+	found, err := h.guard.UnlockIP(req.IP, actor, actorIP)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, utils.ErrCodeInternalError, err.Error())
+		return
+	}
+	utils.WriteSuccess(w, map[string]interface{}{"ip": req.IP, "was_locked": found})
+}
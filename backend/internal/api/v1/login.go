@@ -0,0 +1,392 @@
+// Package v1 provides the two-step login shared by panel users and accounts
+package v1
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/iSundram/OweHost/internal/api/middleware"
+	"github.com/iSundram/OweHost/internal/auth"
+	"github.com/iSundram/OweHost/internal/bruteforce"
+	"github.com/iSundram/OweHost/internal/twofactor"
+	"github.com/iSundram/OweHost/pkg/models"
+	"github.com/iSundram/OweHost/pkg/utils"
+)
+
+// Steps of a login, as recorded in its attempts
+const (
+	loginStepPassword = "password"
+	loginStep2FA      = "2fa"
+	loginStepWebAuthn = "webauthn"
+	loginStepPasskey  = "passkey"
+	loginStepDevice   = "remembered_device"
+)
+
+// mfaLogin runs the second factor of a login. A password that checks out
+// only earns an MFA ticket when the user has 2FA enabled, or must have it;
+// the ticket and a code or security key then earn the tokens. A passkey
+// skips the password and ticket altogether. Failed passwords and codes
+// count towards the guard's limits on the login's surface.
+type mfaLogin struct {
+	authService *auth.Service
+	tfService   *twofactor.Service
+	audience    string
+	guard       *bruteforce.Guard
+	surface     bruteforce.Surface
+	// lock, if set, saves a username's lockout where it outlives a restart
+	lock func(username string, until time.Time)
+}
+
+// mfaLoginResponse is the tokens of a completed login, with the token of
+// the device if the user asked for it to be remembered
+type mfaLoginResponse struct {
+	*models.LoginResponse
+	DeviceToken     string     `json:"device_token,omitempty"`
+	DeviceExpiresAt *time.Time `json:"device_expires_at,omitempty"`
+}
+
+// webauthnLoginRequest answers the WebAuthn challenge of a ticket, or of a
+// passkey login's session
+type webauthnLoginRequest struct {
+	Token          string                        `json:"token,omitempty"`
+	Session        string                        `json:"session,omitempty"`
+	Credential     twofactor.PublicKeyCredential `json:"credential"`
+	RememberDevice bool                          `json:"remember_device,omitempty"`
+}
+
+// passkeyLoginResponse starts a passkey login
+type passkeyLoginResponse struct {
+	Session string                              `json:"session"`
+	Options *twofactor.CredentialRequestOptions `json:"options"`
+}
+
+// record records one step of a login
+func (l *mfaLogin) record(r *http.Request, userID, username, step string, success, twoFactorUsed bool, failReason string) {
+	l.tfService.RecordLoginAttempt(&models.LoginAttempt{
+		UserID:        userID,
+		Username:      username,
+		IPAddress:     loginIP(r),
+		UserAgent:     r.UserAgent(),
+		Success:       success,
+		FailReason:    failReason,
+		TwoFactorUsed: twoFactorUsed,
+		Step:          step,
+	})
+}
+
+// allowed reports whether the guard lets a step of a login for username
+// be tried now; if not, it has answered already
+func (l *mfaLogin) allowed(w http.ResponseWriter, r *http.Request, username, step string) bool {
+	err := l.guard.Check(l.surface, username, loginIP(r))
+	if err == nil {
+		return true
+	}
+	l.record(r, "", username, step, false, step != loginStepPassword, "throttled")
+	writeLoginBlocked(w, err)
+	return false
+}
+
+// fail counts a failed password or code for username
+func (l *mfaLogin) fail(r *http.Request, username, reason string) {
+	until := l.guard.Fail(l.surface, username, loginIP(r), reason)
+	if !until.IsZero() && l.lock != nil {
+		l.lock(username, until)
+	}
+}
+
+// writeLoginBlocked answers a login held back for too many failures
+func writeLoginBlocked(w http.ResponseWriter, err error) {
+	var blocked *bruteforce.BlockedError
+	if errors.As(err, &blocked) {
+		if wait := blocked.RetryAfter(); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())))
+		}
+	}
+	utils.WriteError(w, http.StatusTooManyRequests, utils.ErrCodeRateLimited, "Too many failed login attempts, try again later")
+}
+
+// begin carries on a login whose password checked out. It returns the
+// tokens when no second factor is due; otherwise it answers with a
+// challenge itself and returns false.
+func (l *mfaLogin) begin(w http.ResponseWriter, r *http.Request, u *models.User, deviceToken string) (*models.LoginResponse, bool) {
+	enabled := l.tfService.HasSecondFactor(u.ID)
+	required := l.tfService.IsRequired(u.Role, u.TenantID)
+
+	if !enabled && !required {
+		l.record(r, u.ID, u.Username, loginStepPassword, true, false, "")
+		return l.tokens(w, r, u)
+	}
+	if enabled && l.tfService.IsDeviceRemembered(u.ID, deviceToken) {
+		l.record(r, u.ID, u.Username, loginStepDevice, true, true, "")
+		return l.tokens(w, r, u)
+	}
+
+	ticket, expiresAt, err := l.authService.IssueMFATicket(u, l.audience, !enabled)
+	if err != nil {
+		utils.WriteError(w, http.StatusServiceUnavailable, utils.ErrCodeInternalError, err.Error())
+		return nil, false
+	}
+	l.record(r, u.ID, u.Username, loginStepPassword, true, false, "")
+	utils.WriteSuccess(w, &models.TwoFactorChallenge{
+		MFARequired:   true,
+		SetupRequired: !enabled,
+		Methods:       l.tfService.Methods(u.ID),
+		Ticket:        ticket,
+		ExpiresAt:     expiresAt,
+	})
+	return nil, false
+}
+
+func (l *mfaLogin) tokens(w http.ResponseWriter, r *http.Request, u *models.User) (*models.LoginResponse, bool) {
+	tokens, err := l.authService.GenerateTokens(u, loginIP(r), r.UserAgent())
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, utils.ErrCodeInternalError, "Failed to generate tokens")
+		return nil, false
+	}
+	// SSO logins check no password here, and so have no guard
+	if l.guard != nil {
+		l.guard.Succeed(l.surface, u.Username, loginIP(r))
+	}
+	return tokens, true
+}
+
+// setup starts enrolling a user whose ticket says 2FA is enforced but not
+// set up; the code of the new authenticator then completes the login
+func (l *mfaLogin) setup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+		return
+	}
+
+	var req models.TwoFactorLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid request body")
+		return
+	}
+
+	ticket, err := l.authService.GetMFATicket(req.Token, l.audience)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, utils.ErrCodeUnauthorized, err.Error())
+		return
+	}
+	if !ticket.Enroll {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Two-factor authentication is already set up")
+		return
+	}
+
+	setup, err := l.tfService.SetupTOTP(ticket.User.ID, ticket.User.Username)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, setup)
+}
+
+// verify checks the code given for a ticket. On success it returns the
+// ticket and the login's tokens; on failure it has answered already.
+func (l *mfaLogin) verify(w http.ResponseWriter, r *http.Request) (*auth.MFATicket, *mfaLoginResponse, bool) {
+	if r.Method != http.MethodPost {
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+		return nil, nil, false
+	}
+
+	var req models.TwoFactorLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid request body")
+		return nil, nil, false
+	}
+	if req.Token == "" || req.Code == "" {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Token and code are required")
+		return nil, nil, false
+	}
+
+	ticket, err := l.authService.GetMFATicket(req.Token, l.audience)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, utils.ErrCodeUnauthorized, err.Error())
+		return nil, nil, false
+	}
+	u := ticket.User
+	if !l.allowed(w, r, u.Username, loginStep2FA) {
+		return nil, nil, false
+	}
+
+	if ticket.Enroll {
+		err = l.tfService.VerifySetup(u.ID, req.Code)
+	} else {
+		_, err = l.tfService.VerifyCode(u.ID, req.Code)
+	}
+	if err != nil {
+		l.record(r, u.ID, u.Username, loginStep2FA, false, true, err.Error())
+		l.fail(r, u.Username, "invalid_2fa_code")
+		if !l.authService.FailMFATicket(req.Token) {
+			utils.WriteError(w, http.StatusUnauthorized, utils.ErrCodeUnauthorized, "Too many invalid codes, log in again")
+			return nil, nil, false
+		}
+		utils.WriteError(w, http.StatusUnauthorized, utils.ErrCodeUnauthorized, "Invalid 2FA code")
+		return nil, nil, false
+	}
+
+	return l.complete(w, r, req.Token, loginStep2FA, req.RememberDevice)
+}
+
+// complete ends a login whose second factor checked out
+func (l *mfaLogin) complete(w http.ResponseWriter, r *http.Request, token, step string, rememberDevice bool) (*auth.MFATicket, *mfaLoginResponse, bool) {
+	ticket, err := l.authService.RedeemMFATicket(token, l.audience)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, utils.ErrCodeUnauthorized, err.Error())
+		return nil, nil, false
+	}
+	u := ticket.User
+	tokens, ok := l.tokens(w, r, u)
+	if !ok {
+		return nil, nil, false
+	}
+	l.record(r, u.ID, u.Username, step, true, true, "")
+
+	resp := &mfaLoginResponse{LoginResponse: tokens}
+	if rememberDevice {
+		// Failing to remember the device doesn't fail the login; the next
+		// one just asks for a code again
+		if token, expiresAt, err := l.tfService.RememberDevice(u.ID, r.UserAgent()); err == nil {
+			resp.DeviceToken = token
+			resp.DeviceExpiresAt = &expiresAt
+		}
+	}
+	return ticket, resp, true
+}
+
+// webauthnBegin challenges the security keys of a ticket's user
+func (l *mfaLogin) webauthnBegin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+		return
+	}
+
+	var req models.TwoFactorLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid request body")
+		return
+	}
+
+	ticket, err := l.authService.GetMFATicket(req.Token, l.audience)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, utils.ErrCodeUnauthorized, err.Error())
+		return
+	}
+
+	options, err := l.tfService.BeginAssertion(ticket.User.ID)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, options)
+}
+
+// webauthnFinish checks a security key's answer for a ticket, like verify
+// does a code
+func (l *mfaLogin) webauthnFinish(w http.ResponseWriter, r *http.Request) (*auth.MFATicket, *mfaLoginResponse, bool) {
+	if r.Method != http.MethodPost {
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+		return nil, nil, false
+	}
+
+	var req webauthnLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid request body")
+		return nil, nil, false
+	}
+
+	ticket, err := l.authService.GetMFATicket(req.Token, l.audience)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, utils.ErrCodeUnauthorized, err.Error())
+		return nil, nil, false
+	}
+	u := ticket.User
+	if !l.allowed(w, r, u.Username, loginStepWebAuthn) {
+		return nil, nil, false
+	}
+
+	if err := l.tfService.FinishAssertion(u.ID, &req.Credential); err != nil {
+		l.record(r, u.ID, u.Username, loginStepWebAuthn, false, true, err.Error())
+		l.fail(r, u.Username, "invalid_security_key")
+		if !l.authService.FailMFATicket(req.Token) {
+			utils.WriteError(w, http.StatusUnauthorized, utils.ErrCodeUnauthorized, "Too many failed attempts, log in again")
+			return nil, nil, false
+		}
+		utils.WriteError(w, http.StatusUnauthorized, utils.ErrCodeUnauthorized, err.Error())
+		return nil, nil, false
+	}
+	return l.complete(w, r, req.Token, loginStepWebAuthn, req.RememberDevice)
+}
+
+// passkeyBegin starts a login with a passkey
+func (l *mfaLogin) passkeyBegin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+		return
+	}
+
+	session, options, err := l.tfService.BeginPasskeyLogin()
+	if err != nil {
+		utils.WriteError(w, http.StatusServiceUnavailable, utils.ErrCodeInternalError, err.Error())
+		return
+	}
+	utils.WriteSuccess(w, &passkeyLoginResponse{Session: session, Options: options})
+}
+
+// passkeyFinish checks a passkey's answer and issues the tokens of its
+// owner, whom resolve looks up among the users of this login. The passkey
+// is both factors, so there is no ticket. Until the passkey names its
+// user, failures count against the address only.
+func (l *mfaLogin) passkeyFinish(w http.ResponseWriter, r *http.Request, resolve func(userID string) (*models.User, error)) (*models.User, *models.LoginResponse, bool) {
+	if r.Method != http.MethodPost {
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+		return nil, nil, false
+	}
+	if !l.allowed(w, r, "", loginStepPasskey) {
+		return nil, nil, false
+	}
+
+	var req webauthnLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid request body")
+		return nil, nil, false
+	}
+
+	userID, err := l.tfService.FinishPasskeyLogin(req.Session, &req.Credential)
+	if err != nil {
+		l.record(r, userID, "", loginStepPasskey, false, true, err.Error())
+		l.fail(r, "", "invalid_passkey")
+		utils.WriteError(w, http.StatusUnauthorized, utils.ErrCodeUnauthorized, "Invalid passkey")
+		return nil, nil, false
+	}
+	u, err := resolve(userID)
+	if err != nil {
+		l.record(r, userID, "", loginStepPasskey, false, true, err.Error())
+		l.fail(r, "", "invalid_passkey")
+		utils.WriteError(w, http.StatusUnauthorized, utils.ErrCodeUnauthorized, "Invalid passkey")
+		return nil, nil, false
+	}
+	// A locked out user stays locked out, passkey or not
+	if !l.allowed(w, r, u.Username, loginStepPasskey) {
+		return nil, nil, false
+	}
+
+	tokens, ok := l.tokens(w, r, u)
+	if !ok {
+		return nil, nil, false
+	}
+	l.record(r, u.ID, u.Username, loginStepPasskey, true, true, "")
+	return u, tokens, true
+}
+
+// loginIP is the address a login comes from
+func loginIP(r *http.Request) string {
+	if ip := middleware.GetClientIP(r.Context()); ip != "" {
+		return ip
+	}
+	return r.RemoteAddr
+}
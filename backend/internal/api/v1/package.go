@@ -1,22 +1,28 @@
 package v1
 
 import (
+	"encoding/json"
+	"errors"
 	"net/http"
 	"strings"
 
+	"github.com/iSundram/OweHost/internal/api/middleware"
 	"github.com/iSundram/OweHost/internal/packages"
+	"github.com/iSundram/OweHost/internal/tenancy"
 	"github.com/iSundram/OweHost/pkg/utils"
 )
 
 // PackageHandler handles package/plan management
 type PackageHandler struct {
 	packageService *packages.Service
+	tenancy        *tenancy.Guard
 }
 
 // NewPackageHandler creates a new package handler
-func NewPackageHandler(packageSvc *packages.Service) *PackageHandler {
+func NewPackageHandler(packageSvc *packages.Service, guard *tenancy.Guard) *PackageHandler {
 	return &PackageHandler{
 		packageService: packageSvc,
+		tenancy:        guard,
 	}
 }
 
@@ -59,3 +65,94 @@ func (h *PackageHandler) Get(w http.ResponseWriter, r *http.Request) {
 
 	utils.WriteSuccess(w, pkg)
 }
+
+// ListResellerPackages lists a reseller's packages
+// (GET /api/v1/reseller/packages[?reseller=<user ID>])
+func (h *PackageHandler) ListResellerPackages(w http.ResponseWriter, r *http.Request) {
+	resellerUserID, ok := h.packageReseller(w, r)
+	if !ok {
+		return
+	}
+	utils.WriteSuccess(w, h.packageService.ListResellerPackages(resellerUserID))
+}
+
+// CreateResellerPackage creates a package for a reseller's accounts
+// (POST /api/v1/reseller/packages[?reseller=<user ID>])
+func (h *PackageHandler) CreateResellerPackage(w http.ResponseWriter, r *http.Request) {
+	resellerUserID, ok := h.packageReseller(w, r)
+	if !ok {
+		return
+	}
+
+	var req packages.ResellerPackageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid request body")
+		return
+	}
+
+	pkg, err := h.packageService.CreateResellerPackage(resellerUserID, &req)
+	if err != nil {
+		writePackageError(w, err)
+		return
+	}
+	utils.WriteCreated(w, pkg)
+}
+
+// GetResellerPackage returns one of a reseller's packages
+// (GET /api/v1/reseller/packages/{name}[?reseller=<user ID>])
+func (h *PackageHandler) GetResellerPackage(w http.ResponseWriter, r *http.Request) {
+	resellerUserID, ok := h.packageReseller(w, r)
+	if !ok {
+		return
+	}
+
+	pkg, err := h.packageService.GetResellerPackage(resellerUserID, extractIDFromPath(r.URL.Path, "reseller/packages"))
+	if err != nil {
+		writePackageError(w, err)
+		return
+	}
+	utils.WriteSuccess(w, pkg)
+}
+
+// DeleteResellerPackage deletes one of a reseller's packages
+// (DELETE /api/v1/reseller/packages/{name}[?reseller=<user ID>])
+func (h *PackageHandler) DeleteResellerPackage(w http.ResponseWriter, r *http.Request) {
+	resellerUserID, ok := h.packageReseller(w, r)
+	if !ok {
+		return
+	}
+
+	name := extractIDFromPath(r.URL.Path, "reseller/packages")
+	if err := h.packageService.DeleteResellerPackage(resellerUserID, name); err != nil {
+		writePackageError(w, err)
+		return
+	}
+	utils.WriteSuccess(w, map[string]string{"name": name})
+}
+
+// packageReseller works out whose packages a request is about: the
+// reseller named by the query, or else the caller. Admins and resellers
+// above it manage a reseller's packages too.
+func (h *PackageHandler) packageReseller(w http.ResponseWriter, r *http.Request) (string, bool) {
+	resellerUserID := r.URL.Query().Get("reseller")
+	if resellerUserID == "" {
+		resellerUserID = middleware.GetUserID(r.Context())
+	}
+	if !authorizeOwner(w, r, h.tenancy, resellerUserID) {
+		return "", false
+	}
+	return resellerUserID, true
+}
+
+func writePackageError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, packages.ErrPackageNotFound):
+		utils.WriteError(w, http.StatusNotFound, utils.ErrCodeNotFound, err.Error())
+	case errors.Is(err, packages.ErrPackageExists):
+		utils.WriteError(w, http.StatusConflict, utils.ErrCodeConflict, err.Error())
+	case errors.Is(err, packages.ErrInvalidPackage):
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeValidation, err.Error())
+	default:
+		utils.WriteError(w, http.StatusInternalServerError, utils.ErrCodeInternalError, err.Error())
+	}
+}
@@ -5,8 +5,10 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/iSundram/OweHost/internal/accountsvc"
 	"github.com/iSundram/OweHost/internal/api/middleware"
 	"github.com/iSundram/OweHost/internal/reseller"
+	"github.com/iSundram/OweHost/internal/tenancy"
 	"github.com/iSundram/OweHost/internal/user"
 	"github.com/iSundram/OweHost/pkg/models"
 	"github.com/iSundram/OweHost/pkg/utils"
@@ -16,13 +18,17 @@ import (
 type ResellerHandler struct {
 	resellerService *reseller.Service
 	userService     *user.Service
+	accountService  *accountsvc.Service
+	tenancy         *tenancy.Guard
 }
 
 // NewResellerHandler creates a new reseller handler
-func NewResellerHandler(resellerSvc *reseller.Service, userSvc *user.Service) *ResellerHandler {
+func NewResellerHandler(resellerSvc *reseller.Service, userSvc *user.Service, accountSvc *accountsvc.Service, guard *tenancy.Guard) *ResellerHandler {
 	return &ResellerHandler{
 		resellerService: resellerSvc,
 		userService:     userSvc,
+		accountService:  accountSvc,
+		tenancy:         guard,
 	}
 }
 
@@ -118,6 +124,10 @@ func (h *ResellerHandler) Update(w http.ResponseWriter, r *http.Request) {
 		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid request body")
 		return
 	}
+	if req.ResourcePool != nil && !h.canSetPool(r, resellerID) {
+		utils.WriteError(w, http.StatusForbidden, utils.ErrCodeForbidden, "Only admins and parent resellers can change a resource pool")
+		return
+	}
 
 	reseller, err := h.resellerService.Update(resellerID, &req)
 	if err != nil {
@@ -187,3 +197,55 @@ func (h *ResellerHandler) GetByUserID(w http.ResponseWriter, r *http.Request) {
 
 	utils.WriteSuccess(w, reseller)
 }
+
+// Usage reports how much of a reseller's resource pool is allocated and
+// used (GET /api/v1/resellers/{id}/usage)
+func (h *ResellerHandler) Usage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+		return
+	}
+
+	parts := strings.Split(strings.TrimSuffix(r.URL.Path, "/"), "/")
+	if len(parts) < 6 {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Reseller ID required")
+		return
+	}
+	resellerID := parts[len(parts)-2]
+
+	res, err := h.resellerService.Get(resellerID)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, utils.ErrCodeNotFound, err.Error())
+		return
+	}
+	if !authorizeOwner(w, r, h.tenancy, res.UserID) {
+		return
+	}
+
+	report, err := h.accountService.ResellerUsage(r.Context(), resellerID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, utils.ErrCodeInternalError, err.Error())
+		return
+	}
+	utils.WriteSuccess(w, report)
+}
+
+// canSetPool reports whether the caller may change a reseller's pool:
+// admins may, and so may the resellers above it, but not the reseller
+// itself
+func (h *ResellerHandler) canSetPool(r *http.Request, resellerID string) bool {
+	scope := callerScope(r, h.tenancy)
+	if scope.All() {
+		return true
+	}
+	res, err := h.resellerService.Get(resellerID)
+	if err != nil {
+		return false
+	}
+	for i, parent := range h.resellerService.Ancestry(res.UserID) {
+		if i > 0 && parent.UserID == scope.UserID {
+			return true
+		}
+	}
+	return false
+}
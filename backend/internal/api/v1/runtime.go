@@ -2,11 +2,17 @@ package v1
 
 import (
 	"encoding/json"
+	"io"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/iSundram/OweHost/internal/api/middleware"
 	"github.com/iSundram/OweHost/internal/runtime"
+	"github.com/iSundram/OweHost/internal/storage/web"
+	"github.com/iSundram/OweHost/internal/tenancy"
 	"github.com/iSundram/OweHost/pkg/models"
 	"github.com/iSundram/OweHost/pkg/utils"
 )
@@ -14,12 +20,14 @@ import (
 // RuntimeHandler handles runtime endpoints
 type RuntimeHandler struct {
 	runtimeService *runtime.Service
+	tenancy        *tenancy.Guard
 }
 
 // NewRuntimeHandler creates a new runtime handler
-func NewRuntimeHandler(runtimeSvc *runtime.Service) *RuntimeHandler {
+func NewRuntimeHandler(runtimeSvc *runtime.Service, guard *tenancy.Guard) *RuntimeHandler {
 	return &RuntimeHandler{
 		runtimeService: runtimeSvc,
+		tenancy:        guard,
 	}
 }
 
@@ -97,9 +105,8 @@ func (h *RuntimeHandler) GetPHPPool(w http.ResponseWriter, r *http.Request) {
 	}
 	poolID := parts[len(parts)-1]
 
-	pool, err := h.runtimeService.GetPHPPool(poolID)
-	if err != nil {
-		utils.WriteError(w, http.StatusNotFound, utils.ErrCodeNotFound, err.Error())
+	pool, ok := h.authorizePHPPool(w, r, poolID)
+	if !ok {
 		return
 	}
 
@@ -132,6 +139,10 @@ func (h *RuntimeHandler) UpdatePHPPool(w http.ResponseWriter, r *http.Request) {
 	}
 	poolID := parts[len(parts)-1]
 
+	if _, ok := h.authorizePHPPool(w, r, poolID); !ok {
+		return
+	}
+
 	var req models.PHPPoolCreateRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid request body")
@@ -161,6 +172,10 @@ func (h *RuntimeHandler) DeletePHPPool(w http.ResponseWriter, r *http.Request) {
 	}
 	poolID := parts[len(parts)-1]
 
+	if _, ok := h.authorizePHPPool(w, r, poolID); !ok {
+		return
+	}
+
 	if err := h.runtimeService.DeletePHPPool(poolID); err != nil {
 		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
 		return
@@ -183,6 +198,10 @@ func (h *RuntimeHandler) EnablePHPExtension(w http.ResponseWriter, r *http.Reque
 	}
 	poolID := parts[len(parts)-2]
 
+	if _, ok := h.authorizePHPPool(w, r, poolID); !ok {
+		return
+	}
+
 	var req struct {
 		Extension string `json:"extension"`
 	}
@@ -214,6 +233,10 @@ func (h *RuntimeHandler) DisablePHPExtension(w http.ResponseWriter, r *http.Requ
 	poolID := parts[len(parts)-3]
 	extension := parts[len(parts)-1]
 
+	if _, ok := h.authorizePHPPool(w, r, poolID); !ok {
+		return
+	}
+
 	if err := h.runtimeService.DisablePHPExtension(poolID, extension); err != nil {
 		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
 		return
@@ -260,9 +283,8 @@ func (h *RuntimeHandler) GetNodeJSApp(w http.ResponseWriter, r *http.Request) {
 	}
 	appID := parts[len(parts)-1]
 
-	app, err := h.runtimeService.GetNodeJSApp(appID)
-	if err != nil {
-		utils.WriteError(w, http.StatusNotFound, utils.ErrCodeNotFound, err.Error())
+	app, ok := h.authorizeNodeJSApp(w, r, appID)
+	if !ok {
 		return
 	}
 
@@ -283,6 +305,10 @@ func (h *RuntimeHandler) StartNodeJSApp(w http.ResponseWriter, r *http.Request)
 	}
 	appID := parts[len(parts)-2]
 
+	if _, ok := h.authorizeNodeJSApp(w, r, appID); !ok {
+		return
+	}
+
 	if err := h.runtimeService.StartNodeJSApp(appID); err != nil {
 		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
 		return
@@ -306,6 +332,10 @@ func (h *RuntimeHandler) StopNodeJSApp(w http.ResponseWriter, r *http.Request) {
 	}
 	appID := parts[len(parts)-2]
 
+	if _, ok := h.authorizeNodeJSApp(w, r, appID); !ok {
+		return
+	}
+
 	if err := h.runtimeService.StopNodeJSApp(appID); err != nil {
 		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
 		return
@@ -329,9 +359,12 @@ func (h *RuntimeHandler) RestartNodeJSApp(w http.ResponseWriter, r *http.Request
 	}
 	appID := parts[len(parts)-2]
 
-	// Restart = stop + start
-	_ = h.runtimeService.StopNodeJSApp(appID)
-	if err := h.runtimeService.StartNodeJSApp(appID); err != nil {
+	if _, ok := h.authorizeNodeJSApp(w, r, appID); !ok {
+		return
+	}
+
+	// Reload starts the new process before stopping the old one
+	if err := h.runtimeService.ReloadApp(appID); err != nil {
 		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
 		return
 	}
@@ -354,6 +387,10 @@ func (h *RuntimeHandler) DeleteNodeJSApp(w http.ResponseWriter, r *http.Request)
 	}
 	appID := parts[len(parts)-1]
 
+	if _, ok := h.authorizeNodeJSApp(w, r, appID); !ok {
+		return
+	}
+
 	if err := h.runtimeService.DeleteNodeJSApp(appID); err != nil {
 		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
 		return
@@ -400,9 +437,8 @@ func (h *RuntimeHandler) GetPythonApp(w http.ResponseWriter, r *http.Request) {
 	}
 	appID := parts[len(parts)-1]
 
-	app, err := h.runtimeService.GetPythonApp(appID)
-	if err != nil {
-		utils.WriteError(w, http.StatusNotFound, utils.ErrCodeNotFound, err.Error())
+	app, ok := h.authorizePythonApp(w, r, appID)
+	if !ok {
 		return
 	}
 
@@ -423,6 +459,10 @@ func (h *RuntimeHandler) ProvisionVirtualenv(w http.ResponseWriter, r *http.Requ
 	}
 	appID := parts[len(parts)-2]
 
+	if _, ok := h.authorizePythonApp(w, r, appID); !ok {
+		return
+	}
+
 	if err := h.runtimeService.ProvisionVirtualenv(appID); err != nil {
 		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
 		return
@@ -431,6 +471,88 @@ func (h *RuntimeHandler) ProvisionVirtualenv(w http.ResponseWriter, r *http.Requ
 	utils.WriteSuccess(w, map[string]string{"message": "Virtualenv provisioned"})
 }
 
+// StartPythonApp handles starting a Python app
+func (h *RuntimeHandler) StartPythonApp(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+		return
+	}
+
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) < 5 {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "App ID required")
+		return
+	}
+	appID := parts[len(parts)-2]
+
+	if _, ok := h.authorizePythonApp(w, r, appID); !ok {
+		return
+	}
+
+	if err := h.runtimeService.StartPythonApp(appID); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
+		return
+	}
+
+	app, _ := h.runtimeService.GetPythonApp(appID)
+	utils.WriteSuccess(w, app)
+}
+
+// StopPythonApp handles stopping a Python app
+func (h *RuntimeHandler) StopPythonApp(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+		return
+	}
+
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) < 5 {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "App ID required")
+		return
+	}
+	appID := parts[len(parts)-2]
+
+	if _, ok := h.authorizePythonApp(w, r, appID); !ok {
+		return
+	}
+
+	if err := h.runtimeService.StopPythonApp(appID); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
+		return
+	}
+
+	app, _ := h.runtimeService.GetPythonApp(appID)
+	utils.WriteSuccess(w, app)
+}
+
+// RestartPythonApp handles restarting a Python app
+func (h *RuntimeHandler) RestartPythonApp(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+		return
+	}
+
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) < 5 {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "App ID required")
+		return
+	}
+	appID := parts[len(parts)-2]
+
+	if _, ok := h.authorizePythonApp(w, r, appID); !ok {
+		return
+	}
+
+	// Reload starts the new process before stopping the old one
+	if err := h.runtimeService.ReloadApp(appID); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
+		return
+	}
+
+	app, _ := h.runtimeService.GetPythonApp(appID)
+	utils.WriteSuccess(w, app)
+}
+
 // DeletePythonApp handles deleting a Python app
 func (h *RuntimeHandler) DeletePythonApp(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
@@ -445,6 +567,10 @@ func (h *RuntimeHandler) DeletePythonApp(w http.ResponseWriter, r *http.Request)
 	}
 	appID := parts[len(parts)-1]
 
+	if _, ok := h.authorizePythonApp(w, r, appID); !ok {
+		return
+	}
+
 	if err := h.runtimeService.DeletePythonApp(appID); err != nil {
 		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
 		return
@@ -452,3 +578,478 @@ func (h *RuntimeHandler) DeletePythonApp(w http.ResponseWriter, r *http.Request)
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// maxReleaseUpload caps uploaded release archives
+const maxReleaseUpload = 512 << 20
+
+// releaseDomainID extracts the domain from site routes such as
+// /api/v1/domains/{id}/releases[/...] and /api/v1/domains/{id}/cache
+func releaseDomainID(r *http.Request) string {
+	parts := strings.Split(strings.TrimSuffix(r.URL.Path, "/"), "/")
+	if len(parts) < 6 {
+		return ""
+	}
+	return parts[4]
+}
+
+// ListSiteReleases handles listing the releases of a site
+func (h *RuntimeHandler) ListSiteReleases(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+		return
+	}
+
+	userID := middleware.GetUserID(r.Context())
+
+	releases, err := h.runtimeService.ListSiteReleases(userID, releaseDomainID(r))
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
+		return
+	}
+
+	utils.WriteSuccess(w, releases)
+}
+
+// ConfigureSiteReleases handles enabling or disabling release-based deploys
+func (h *RuntimeHandler) ConfigureSiteReleases(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+		return
+	}
+
+	userID := middleware.GetUserID(r.Context())
+
+	var req models.SiteReleaseSettingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid request body")
+		return
+	}
+
+	site, err := h.runtimeService.ConfigureSiteReleases(userID, releaseDomainID(r), &req)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
+		return
+	}
+
+	utils.WriteSuccess(w, site)
+}
+
+// DeploySiteArchive handles publishing an uploaded archive as a new release
+func (h *RuntimeHandler) DeploySiteArchive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+		return
+	}
+
+	userID := middleware.GetUserID(r.Context())
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxReleaseUpload)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid or oversized upload")
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "No file provided")
+		return
+	}
+	defer file.Close()
+
+	// Archives are read with random access (zip), so spool to disk
+	tmp, err := os.CreateTemp("", "owehost-release-*")
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, utils.ErrCodeInternalError, "Failed to store upload")
+		return
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, file)
+	tmp.Close()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, utils.ErrCodeInternalError, "Failed to store upload")
+		return
+	}
+
+	release, err := h.runtimeService.DeploySiteArchive(userID, releaseDomainID(r), filepath.Base(header.Filename), tmp.Name())
+	if err != nil && release == nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, utils.ErrCodeInternalError, err.Error())
+		return
+	}
+
+	utils.WriteCreated(w, release)
+}
+
+// RollbackSiteRelease handles switching a site back to an earlier release
+func (h *RuntimeHandler) RollbackSiteRelease(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+		return
+	}
+
+	userID := middleware.GetUserID(r.Context())
+
+	// The body is optional; without one the previous release is restored
+	var req models.SiteRollbackRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	release, err := h.runtimeService.RollbackSite(userID, releaseDomainID(r), req.ReleaseID)
+	if err != nil && release == nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, utils.ErrCodeInternalError, err.Error())
+		return
+	}
+
+	utils.WriteSuccess(w, release)
+}
+
+// ConfigureSiteCache handles turning a site's page cache on or off
+func (h *RuntimeHandler) ConfigureSiteCache(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+		return
+	}
+
+	userID := middleware.GetUserID(r.Context())
+
+	var req models.SiteCacheSettingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid request body")
+		return
+	}
+
+	site, err := h.runtimeService.ConfigureSiteCache(userID, releaseDomainID(r), &req)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
+		return
+	}
+
+	utils.WriteSuccess(w, site)
+}
+
+// PurgeSiteCache handles dropping a site's cached pages
+func (h *RuntimeHandler) PurgeSiteCache(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+		return
+	}
+
+	userID := middleware.GetUserID(r.Context())
+
+	// The body is optional; without one the whole site is purged
+	var req models.SiteCachePurgeRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	purged, err := h.runtimeService.PurgeSiteCache(userID, releaseDomainID(r), req.Path)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
+		return
+	}
+
+	utils.WriteSuccess(w, map[string]int{"purged": purged})
+}
+
+// SetSiteRateLimits handles replacing a site's rate limits
+func (h *RuntimeHandler) SetSiteRateLimits(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+		return
+	}
+
+	userID := middleware.GetUserID(r.Context())
+
+	var req models.SiteRateLimitsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid request body")
+		return
+	}
+
+	site, err := h.runtimeService.SetSiteRateLimits(userID, releaseDomainID(r), &req)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
+		return
+	}
+
+	utils.WriteSuccess(w, site)
+}
+
+// ListSiteProtectedPaths handles listing a site's password-protected directories
+func (h *RuntimeHandler) ListSiteProtectedPaths(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+		return
+	}
+
+	userID := middleware.GetUserID(r.Context())
+
+	paths, err := h.runtimeService.ListSiteProtectedPaths(userID, releaseDomainID(r))
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
+		return
+	}
+
+	utils.WriteSuccess(w, paths)
+}
+
+// ProtectSitePath handles password-protecting a directory of a site
+func (h *RuntimeHandler) ProtectSitePath(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+		return
+	}
+
+	userID := middleware.GetUserID(r.Context())
+
+	var req models.SiteProtectedPathRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid request body")
+		return
+	}
+
+	site, err := h.runtimeService.ProtectSitePath(userID, releaseDomainID(r), &req)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
+		return
+	}
+
+	utils.WriteSuccess(w, site)
+}
+
+// UnprotectSitePath handles removing the protection of a directory
+// given as ?path=
+func (h *RuntimeHandler) UnprotectSitePath(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+		return
+	}
+
+	userID := middleware.GetUserID(r.Context())
+
+	if err := h.runtimeService.UnprotectSitePath(userID, releaseDomainID(r), r.URL.Query().Get("path")); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
+		return
+	}
+
+	utils.WriteSuccess(w, map[string]string{"message": "Protection removed"})
+}
+
+// SetSiteAuthUser handles adding a user to a protected directory or
+// changing its password
+func (h *RuntimeHandler) SetSiteAuthUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+		return
+	}
+
+	userID := middleware.GetUserID(r.Context())
+
+	var req models.SiteAuthUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := h.runtimeService.SetSiteAuthUser(userID, releaseDomainID(r), &req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
+		return
+	}
+
+	utils.WriteSuccess(w, map[string]string{"message": "User saved"})
+}
+
+// DeleteSiteAuthUser handles removing a user, given as ?path=&username=,
+// from a protected directory
+func (h *RuntimeHandler) DeleteSiteAuthUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+		return
+	}
+
+	userID := middleware.GetUserID(r.Context())
+	query := r.URL.Query()
+
+	if err := h.runtimeService.DeleteSiteAuthUser(userID, releaseDomainID(r), query.Get("path"), query.Get("username")); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
+		return
+	}
+
+	utils.WriteSuccess(w, map[string]string{"message": "User removed"})
+}
+
+// GetSiteAccessRules handles listing a site's IP allow/deny rules
+func (h *RuntimeHandler) GetSiteAccessRules(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+		return
+	}
+
+	userID := middleware.GetUserID(r.Context())
+
+	site, err := h.runtimeService.GetSite(userID, releaseDomainID(r))
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
+		return
+	}
+
+	rules := site.AccessRules
+	if rules == nil {
+		rules = []web.AccessRule{}
+	}
+	utils.WriteSuccess(w, rules)
+}
+
+// SetSiteAccessRules handles replacing a site's IP allow/deny rules
+func (h *RuntimeHandler) SetSiteAccessRules(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+		return
+	}
+
+	userID := middleware.GetUserID(r.Context())
+
+	var req models.SiteAccessRulesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid request body")
+		return
+	}
+
+	site, err := h.runtimeService.SetSiteAccessRules(userID, releaseDomainID(r), &req)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
+		return
+	}
+
+	utils.WriteSuccess(w, site)
+}
+
+// ConfigureSiteWAF handles turning a site's web application firewall on or off
+func (h *RuntimeHandler) ConfigureSiteWAF(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+		return
+	}
+
+	userID := middleware.GetUserID(r.Context())
+
+	var req models.SiteWAFRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid request body")
+		return
+	}
+
+	site, err := h.runtimeService.ConfigureSiteWAF(userID, releaseDomainID(r), &req)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
+		return
+	}
+
+	utils.WriteSuccess(w, site)
+}
+
+// ExcludeSiteWAFRule handles switching off a firewall rule for a site
+func (h *RuntimeHandler) ExcludeSiteWAFRule(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+		return
+	}
+
+	userID := middleware.GetUserID(r.Context())
+
+	var req models.SiteWAFExclusionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid request body")
+		return
+	}
+
+	site, err := h.runtimeService.ExcludeSiteWAFRule(userID, releaseDomainID(r), req.RuleID)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
+		return
+	}
+
+	utils.WriteSuccess(w, site)
+}
+
+// ListSiteWAFEvents handles listing the requests a site's firewall matched
+func (h *RuntimeHandler) ListSiteWAFEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+		return
+	}
+
+	userID := middleware.GetUserID(r.Context())
+
+	limit := 50
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 500 {
+			limit = parsed
+		}
+	}
+
+	events, err := h.runtimeService.ListSiteWAFEvents(userID, releaseDomainID(r), limit)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
+		return
+	}
+
+	utils.WriteSuccess(w, events)
+}
+
+// authorizePHPPool looks up a PHP pool the caller must be able to reach,
+// refusing the request when it is missing or out of reach
+func (h *RuntimeHandler) authorizePHPPool(w http.ResponseWriter, r *http.Request, poolID string) (*models.PHPPool, bool) {
+	pool, err := h.runtimeService.GetPHPPool(poolID)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, utils.ErrCodeNotFound, err.Error())
+		return nil, false
+	}
+	if !authorizeOwner(w, r, h.tenancy, pool.UserID) {
+		return nil, false
+	}
+	return pool, true
+}
+
+// authorizeNodeJSApp looks up a Node.js app the caller must be able to
+// reach, refusing the request when it is missing or out of reach
+func (h *RuntimeHandler) authorizeNodeJSApp(w http.ResponseWriter, r *http.Request, appID string) (*models.NodeJSApp, bool) {
+	app, err := h.runtimeService.GetNodeJSApp(appID)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, utils.ErrCodeNotFound, err.Error())
+		return nil, false
+	}
+	if !authorizeOwner(w, r, h.tenancy, app.UserID) {
+		return nil, false
+	}
+	return app, true
+}
+
+// authorizePythonApp looks up a Python app the caller must be able to
+// reach, refusing the request when it is missing or out of reach
+func (h *RuntimeHandler) authorizePythonApp(w http.ResponseWriter, r *http.Request, appID string) (*models.PythonApp, bool) {
+	app, err := h.runtimeService.GetPythonApp(appID)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, utils.ErrCodeNotFound, err.Error())
+		return nil, false
+	}
+	if !authorizeOwner(w, r, h.tenancy, app.UserID) {
+		return nil, false
+	}
+	return app, true
+}
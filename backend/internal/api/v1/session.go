@@ -0,0 +1,136 @@
+// Package v1 provides session handlers for OweHost
+package v1
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/iSundram/OweHost/internal/api/middleware"
+	"github.com/iSundram/OweHost/internal/auth"
+	"github.com/iSundram/OweHost/internal/user"
+	"github.com/iSundram/OweHost/pkg/models"
+	"github.com/iSundram/OweHost/pkg/utils"
+)
+
+// SessionHandler handles session requests
+type SessionHandler struct {
+	authService *auth.Service
+	userService *user.Service
+}
+
+// NewSessionHandler creates a new session handler
+func NewSessionHandler(authService *auth.Service, userService *user.Service) *SessionHandler {
+	return &SessionHandler{
+		authService: authService,
+		userService: userService,
+	}
+}
+
+// sessionResponse is a session as its user sees it
+type sessionResponse struct {
+	*models.Session
+	Current bool `json:"current"`
+}
+
+// List lists the caller's live sessions, marking the one the request
+// came with
+func (h *SessionHandler) List(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+		return
+	}
+
+	current := middleware.GetSessionID(r.Context())
+	sessions := h.authService.ListSessions(middleware.GetUserID(r.Context()))
+	resp := make([]sessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		resp = append(resp, sessionResponse{Session: session, Current: session.ID == current})
+	}
+
+	utils.WriteSuccess(w, resp)
+}
+
+// RevokeOthers logs the caller out everywhere but here
+func (h *SessionHandler) RevokeOthers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+		return
+	}
+
+	userID := middleware.GetUserID(r.Context())
+	revoked := h.authService.RevokeUserSessions(userID, middleware.GetSessionID(r.Context()),
+		auth.SessionRevokedByUser, userID, middleware.GetClientIP(r.Context()))
+
+	utils.WriteSuccess(w, map[string]interface{}{
+		"message": "Other sessions revoked",
+		"revoked": revoked,
+	})
+}
+
+// Revoke ends one of the caller's sessions, or anyone's for an admin
+func (h *SessionHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+		return
+	}
+
+	id := extractIDFromPath(r.URL.Path, "sessions")
+	if id == "" {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Session ID required")
+		return
+	}
+
+	userID := middleware.GetUserID(r.Context())
+	ip := middleware.GetClientIP(r.Context())
+	err := h.authService.RevokeSession(id, userID, auth.SessionRevokedByUser, userID, ip)
+	if errors.Is(err, auth.ErrSessionNotFound) && h.isAdmin(userID) {
+		err = h.authService.RevokeSession(id, "", auth.SessionRevokedAdmin, userID, ip)
+	}
+	switch {
+	case errors.Is(err, auth.ErrSessionNotFound):
+		utils.WriteError(w, http.StatusNotFound, utils.ErrCodeNotFound, err.Error())
+		return
+	case errors.Is(err, auth.ErrSessionRevoked):
+		utils.WriteError(w, http.StatusConflict, utils.ErrCodeConflict, err.Error())
+		return
+	case err != nil:
+		utils.WriteError(w, http.StatusInternalServerError, utils.ErrCodeInternalError, err.Error())
+		return
+	}
+
+	utils.WriteSuccess(w, map[string]string{"message": "Session revoked"})
+}
+
+// UserSessions lets an admin list a user's sessions (GET) or log the user
+// out everywhere (DELETE), at /api/v1/users/{id}/sessions
+func (h *SessionHandler) UserSessions(w http.ResponseWriter, r *http.Request) {
+	actor := middleware.GetUserID(r.Context())
+	if !h.isAdmin(actor) {
+		utils.WriteError(w, http.StatusForbidden, utils.ErrCodeForbidden, "Insufficient permissions")
+		return
+	}
+
+	userID := extractIDFromPath(r.URL.Path, "users")
+	if userID == "" {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "User ID required")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		utils.WriteSuccess(w, h.authService.ListSessions(userID))
+	case http.MethodDelete:
+		revoked := h.authService.RevokeUserSessions(userID, "", auth.SessionRevokedAdmin, actor, middleware.GetClientIP(r.Context()))
+		utils.WriteSuccess(w, map[string]interface{}{
+			"message": "User logged out everywhere",
+			"revoked": revoked,
+		})
+	default:
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+	}
+}
+
+func (h *SessionHandler) isAdmin(userID string) bool {
+	u, err := h.userService.Get(userID)
+	return err == nil && u.Role == models.UserRoleAdmin
+}
@@ -5,27 +5,44 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/iSundram/OweHost/internal/api/middleware"
+	"github.com/iSundram/OweHost/internal/domain"
 	"github.com/iSundram/OweHost/internal/ssl"
+	"github.com/iSundram/OweHost/internal/tenancy"
 	"github.com/iSundram/OweHost/internal/user"
 	"github.com/iSundram/OweHost/pkg/models"
 )
 
 type SSLHandler struct {
-	sslService  *ssl.Service
-	userService *user.Service
+	sslService    *ssl.Service
+	userService   *user.Service
+	domainService *domain.Service
+	tenancy       *tenancy.Guard
 }
 
-func NewSSLHandler(sslService *ssl.Service, userService *user.Service) *SSLHandler {
+func NewSSLHandler(sslService *ssl.Service, userService *user.Service, domainService *domain.Service, guard *tenancy.Guard) *SSLHandler {
 	return &SSLHandler{
-		sslService:  sslService,
-		userService: userService,
+		sslService:    sslService,
+		userService:   userService,
+		domainService: domainService,
+		tenancy:       guard,
 	}
 }
 
-// ListCertificates lists all SSL certificates
+// ListCertificates lists the SSL certificates of the domains the caller
+// can reach
 func (h *SSLHandler) ListCertificates(w http.ResponseWriter, r *http.Request) {
-	// Check expiring certificates (within 30 days)
-	certificates := h.sslService.CheckExpiring(30)
+	scope := callerScope(r, h.tenancy)
+	certificates := make([]*models.Certificate, 0)
+	for _, cert := range h.sslService.ListAll() {
+		if scope.All() {
+			certificates = append(certificates, cert)
+			continue
+		}
+		if dom, err := h.domainService.Get(cert.DomainID); err == nil && scope.Owns(dom.UserID) {
+			certificates = append(certificates, cert)
+		}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(certificates)
@@ -40,9 +57,8 @@ func (h *SSLHandler) GetCertificate(w http.ResponseWriter, r *http.Request) {
 	}
 	certID := parts[5]
 
-	certificate, err := h.sslService.Get(certID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+	certificate, ok := h.authorizeCertificate(w, r, certID)
+	if !ok {
 		return
 	}
 
@@ -60,6 +76,9 @@ func (h *SSLHandler) CreateCertificate(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
+	if _, ok := authorizeDomain(w, r, h.tenancy, h.domainService, req.DomainID); !ok {
+		return
+	}
 
 	certificate, err := h.sslService.GenerateSelfSigned(req.DomainID, req.CommonName)
 	if err != nil {
@@ -81,9 +100,7 @@ func (h *SSLHandler) DeleteCertificate(w http.ResponseWriter, r *http.Request) {
 	}
 	certID := parts[5]
 
-	_, err := h.sslService.Get(certID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+	if _, ok := h.authorizeCertificate(w, r, certID); !ok {
 		return
 	}
 
@@ -103,6 +120,9 @@ func (h *SSLHandler) RenewCertificate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	certID := parts[5]
+	if _, ok := h.authorizeCertificate(w, r, certID); !ok {
+		return
+	}
 
 	// Enable auto-renew
 	if err := h.sslService.EnableAutoRenew(certID); err != nil {
@@ -118,7 +138,7 @@ func (h *SSLHandler) RenewCertificate(w http.ResponseWriter, r *http.Request) {
 
 // RequestLetsEncrypt requests a Let's Encrypt certificate
 func (h *SSLHandler) RequestLetsEncrypt(w http.ResponseWriter, r *http.Request) {
-	userID := r.Context().Value("user_id").(string)
+	userID := middleware.GetUserID(r.Context())
 
 	var req struct {
 		DomainID string   `json:"domain_id"`
@@ -128,6 +148,9 @@ func (h *SSLHandler) RequestLetsEncrypt(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
+	if _, ok := authorizeDomain(w, r, h.tenancy, h.domainService, req.DomainID); !ok {
+		return
+	}
 
 	certificate, err := h.sslService.RequestLetsEncrypt(userID, req)
 	if err != nil {
@@ -147,6 +170,9 @@ func (h *SSLHandler) InstallCertificate(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
+	if _, ok := authorizeDomain(w, r, h.tenancy, h.domainService, req.DomainID); !ok {
+		return
+	}
 
 	certificate, err := h.sslService.UploadCertificate(&req)
 	if err != nil {
@@ -166,6 +192,9 @@ func (h *SSLHandler) GenerateCSR(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
+	if _, ok := authorizeDomain(w, r, h.tenancy, h.domainService, req.DomainID); !ok {
+		return
+	}
 
 	csr, err := h.sslService.GenerateCSR(&req)
 	if err != nil {
@@ -207,6 +236,9 @@ func (h *SSLHandler) IssueLetsEncrypt(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
+	if _, ok := authorizeDomain(w, r, h.tenancy, h.domainService, req.DomainID); !ok {
+		return
+	}
 
 	if req.ChallengeType == "" {
 		req.ChallengeType = "http-01"
@@ -269,6 +301,9 @@ func (h *SSLHandler) GetSSLSettings(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	domainID := parts[len(parts)-2]
+	if _, ok := authorizeDomain(w, r, h.tenancy, h.domainService, domainID); !ok {
+		return
+	}
 
 	settings := h.sslService.GetSSLSettings(domainID)
 
@@ -284,6 +319,9 @@ func (h *SSLHandler) UpdateSSLSettings(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	domainID := parts[len(parts)-2]
+	if _, ok := authorizeDomain(w, r, h.tenancy, h.domainService, domainID); !ok {
+		return
+	}
 
 	var req models.SSLSettingsUpdateRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -307,3 +345,20 @@ func (h *SSLHandler) ListAllCertificates(w http.ResponseWriter, r *http.Request)
 		"count":        len(certificates),
 	})
 }
+
+// authorizeCertificate looks up a certificate whose domain the caller
+// must be able to reach
+func (h *SSLHandler) authorizeCertificate(w http.ResponseWriter, r *http.Request, certID string) (*models.Certificate, bool) {
+	cert, err := h.sslService.Get(certID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil, false
+	}
+	if callerScope(r, h.tenancy).All() {
+		return cert, true
+	}
+	if _, ok := authorizeDomain(w, r, h.tenancy, h.domainService, cert.DomainID); !ok {
+		return nil, false
+	}
+	return cert, true
+}
@@ -0,0 +1,223 @@
+// Package v1 provides single sign-on handlers for OweHost
+package v1
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/iSundram/OweHost/internal/auth"
+	"github.com/iSundram/OweHost/internal/authorization"
+	"github.com/iSundram/OweHost/internal/twofactor"
+	"github.com/iSundram/OweHost/internal/user"
+	"github.com/iSundram/OweHost/pkg/models"
+	"github.com/iSundram/OweHost/pkg/utils"
+)
+
+const loginStepSSO = "sso"
+
+// errSSOUserNotFound is an IdP identity with no panel user to log in as
+var errSSOUserNotFound = errors.New("no user matches this identity")
+
+// SSOHandler logs panel users in through the OpenID Connect IdP. The IdP
+// runs its own second factor, so an SSO login skips the panel's.
+type SSOHandler struct {
+	provider     *auth.OIDCProvider
+	authService  *auth.Service
+	userService  *user.Service
+	authzService *authorization.Service
+	mfa          *mfaLogin
+}
+
+// NewSSOHandler creates a new SSO handler
+func NewSSOHandler(provider *auth.OIDCProvider, authSvc *auth.Service, userSvc *user.Service, authzSvc *authorization.Service, tfSvc *twofactor.Service) *SSOHandler {
+	return &SSOHandler{
+		provider:     provider,
+		authService:  authSvc,
+		userService:  userSvc,
+		authzService: authzSvc,
+		mfa:          &mfaLogin{authService: authSvc, tfService: tfSvc, audience: auth.MFAAudiencePanel},
+	}
+}
+
+// ssoCallbackRequest is what the IdP sent the browser back with
+type ssoCallbackRequest struct {
+	Code             string `json:"code"`
+	State            string `json:"state"`
+	Error            string `json:"error,omitempty"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// Login starts an SSO login and returns the IdP URL to send the browser to
+func (h *SSOHandler) Login(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+		return
+	}
+
+	authURL, err := h.provider.AuthURL(r.Context())
+	if err != nil {
+		if errors.Is(err, auth.ErrOIDCDisabled) {
+			utils.WriteError(w, http.StatusNotFound, utils.ErrCodeNotFound, err.Error())
+			return
+		}
+		utils.WriteError(w, http.StatusBadGateway, utils.ErrCodeInternalError, "Identity provider unavailable")
+		return
+	}
+
+	utils.WriteSuccess(w, map[string]string{"authorization_url": authURL})
+}
+
+// Callback completes an SSO login with the code the IdP sent back, as
+// query parameters (GET) or a JSON body (POST), and issues the tokens
+func (h *SSOHandler) Callback(w http.ResponseWriter, r *http.Request) {
+	var req ssoCallbackRequest
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		req = ssoCallbackRequest{
+			Code:             q.Get("code"),
+			State:            q.Get("state"),
+			Error:            q.Get("error"),
+			ErrorDescription: q.Get("error_description"),
+		}
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid request body")
+			return
+		}
+	default:
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+		return
+	}
+
+	if req.Error != "" {
+		h.mfa.record(r, "", "", loginStepSSO, false, false, req.Error)
+		utils.WriteError(w, http.StatusUnauthorized, utils.ErrCodeUnauthorized, "Identity provider refused the login: "+req.Error)
+		return
+	}
+
+	identity, err := h.provider.Exchange(r.Context(), req.State, req.Code)
+	if err != nil {
+		h.mfa.record(r, "", "", loginStepSSO, false, false, err.Error())
+		if errors.Is(err, auth.ErrOIDCDisabled) {
+			utils.WriteError(w, http.StatusNotFound, utils.ErrCodeNotFound, err.Error())
+			return
+		}
+		utils.WriteError(w, http.StatusUnauthorized, utils.ErrCodeUnauthorized, "SSO login failed")
+		return
+	}
+
+	u, err := h.provision(identity)
+	if err != nil {
+		h.mfa.record(r, "", identity.Email, loginStepSSO, false, false, err.Error())
+		switch {
+		case errors.Is(err, auth.ErrOIDCNoRole), errors.Is(err, errSSOUserNotFound):
+			utils.WriteError(w, http.StatusForbidden, utils.ErrCodeForbidden, err.Error())
+		default:
+			utils.WriteError(w, http.StatusInternalServerError, utils.ErrCodeInternalError, err.Error())
+		}
+		return
+	}
+	if u.Status != models.UserStatusActive {
+		h.mfa.record(r, u.ID, u.Username, loginStepSSO, false, false, "account_not_active")
+		utils.WriteError(w, http.StatusForbidden, utils.ErrCodeForbidden, "Account is not active")
+		return
+	}
+
+	h.authService.RegisterUser(u)
+	tokens, ok := h.mfa.tokens(w, r, u)
+	if !ok {
+		return
+	}
+	h.mfa.record(r, u.ID, u.Username, loginStepSSO, true, false, "")
+
+	utils.WriteSuccess(w, tokens)
+}
+
+// provision finds the panel user of an IdP identity by verified email,
+// creating it on first login when provisioning is on, and brings its role
+// in line with the IdP's groups
+func (h *SSOHandler) provision(identity *auth.OIDCIdentity) (*models.User, error) {
+	role, err := h.provider.Role(identity)
+	if err != nil {
+		return nil, err
+	}
+	if identity.Email == "" || !identity.EmailVerified {
+		return nil, errors.New("identity provider did not vouch for an email address")
+	}
+
+	u, err := h.userService.GetByEmail(identity.Email)
+	switch {
+	case err != nil && !h.provider.AutoProvision():
+		return nil, errSSOUserNotFound
+	case err != nil:
+		// The random password is never handed out; the user logs in
+		// through the IdP
+		password, err := utils.GenerateRefreshToken()
+		if err != nil {
+			return nil, err
+		}
+		u, err = h.userService.Create(&models.UserCreateRequest{
+			Username: h.username(identity),
+			Email:    identity.Email,
+			Password: password,
+			Role:     role,
+		})
+		if err != nil {
+			return nil, err
+		}
+	case u.Role != role:
+		if u, err = h.userService.Update(u.ID, &models.UserUpdateRequest{Role: &role}); err != nil {
+			return nil, err
+		}
+	}
+
+	assigned := make(map[string]bool)
+	for _, r := range h.authzService.GetUserRoles(u.ID) {
+		assigned[r.Name] = true
+	}
+	for _, name := range h.provider.AuthorizationRoles(identity) {
+		if assigned[name] {
+			continue
+		}
+		authzRole, err := h.authzService.GetRoleByName(name)
+		if err != nil {
+			continue
+		}
+		if _, err := h.authzService.AssignRole(u.ID, authzRole.ID, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return u, nil
+}
+
+// username picks a free username for a new SSO user, from the IdP's
+// preferred username or else the email's local part
+func (h *SSOHandler) username(identity *auth.OIDCIdentity) string {
+	base := identity.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(identity.Email, "@")
+	}
+	var b strings.Builder
+	for _, c := range strings.ToLower(base) {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '_' || c == '-' {
+			b.WriteRune(c)
+		}
+	}
+	name := b.String()
+	if len(name) > 24 {
+		name = name[:24]
+	}
+	if len(name) < 3 || name[0] < 'a' || name[0] > 'z' {
+		name = "sso-" + name
+	}
+
+	if _, err := h.userService.GetByUsername(name); err != nil {
+		return name
+	}
+	suffix, _ := utils.GenerateSecureToken(3)
+	return name + "-" + suffix
+}
@@ -7,19 +7,25 @@ import (
 	"time"
 
 	"github.com/iSundram/OweHost/internal/api/middleware"
+	"github.com/iSundram/OweHost/internal/domain"
 	"github.com/iSundram/OweHost/internal/stats"
+	"github.com/iSundram/OweHost/internal/tenancy"
 	"github.com/iSundram/OweHost/pkg/utils"
 )
 
 // StatsHandler handles statistics endpoints
 type StatsHandler struct {
-	statsService *stats.Service
+	statsService  *stats.Service
+	domainService *domain.Service
+	tenancy       *tenancy.Guard
 }
 
 // NewStatsHandler creates a new stats handler
-func NewStatsHandler(statsSvc *stats.Service) *StatsHandler {
+func NewStatsHandler(statsSvc *stats.Service, domainSvc *domain.Service, guard *tenancy.Guard) *StatsHandler {
 	return &StatsHandler{
-		statsService: statsSvc,
+		statsService:  statsSvc,
+		domainService: domainSvc,
+		tenancy:       guard,
 	}
 }
 
@@ -36,6 +42,9 @@ func (h *StatsHandler) GetBandwidthStats(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	domainID := parts[len(parts)-2]
+	if !h.authorizeDomain(w, r, domainID) {
+		return
+	}
 
 	// Parse date range from query params
 	startDate, endDate := parseDateRange(r)
@@ -57,6 +66,9 @@ func (h *StatsHandler) GetVisitorStats(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	domainID := parts[len(parts)-2]
+	if !h.authorizeDomain(w, r, domainID) {
+		return
+	}
 
 	startDate, endDate := parseDateRange(r)
 
@@ -77,6 +89,9 @@ func (h *StatsHandler) GetErrorLogs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	domainID := parts[len(parts)-2]
+	if !h.authorizeDomain(w, r, domainID) {
+		return
+	}
 
 	limit := parseLimit(r, 100)
 
@@ -100,6 +115,9 @@ func (h *StatsHandler) GetAccessLogs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	domainID := parts[len(parts)-2]
+	if !h.authorizeDomain(w, r, domainID) {
+		return
+	}
 
 	limit := parseLimit(r, 100)
 
@@ -123,11 +141,39 @@ func (h *StatsHandler) GetDomainSummary(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	domainID := parts[len(parts)-2]
+	if !h.authorizeDomain(w, r, domainID) {
+		return
+	}
 
 	summary := h.statsService.GetDomainSummary(domainID)
 	utils.WriteSuccess(w, summary)
 }
 
+// GetTrafficBreakdown handles getting a domain's top pages, referrers,
+// status codes, browsers and operating systems
+func (h *StatsHandler) GetTrafficBreakdown(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.WriteError(w, http.StatusMethodNotAllowed, utils.ErrCodeBadRequest, "Method not allowed")
+		return
+	}
+
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) < 5 {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Domain ID required")
+		return
+	}
+	domainID := parts[len(parts)-2]
+	if !h.authorizeDomain(w, r, domainID) {
+		return
+	}
+
+	startDate, endDate := parseDateRange(r)
+	limit := parseLimit(r, 20)
+
+	breakdown := h.statsService.GetTrafficBreakdown(domainID, startDate, endDate, limit)
+	utils.WriteSuccess(w, breakdown)
+}
+
 // GetResourceStats handles getting resource statistics for the current user
 func (h *StatsHandler) GetResourceStats(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -180,6 +226,23 @@ func (h *StatsHandler) GetUserResourceStats(w http.ResponseWriter, r *http.Reque
 	utils.WriteSuccess(w, stats)
 }
 
+// authorizeDomain checks the caller can reach the domain whose stats are
+// asked for. Stats may be asked for by domain name as well as by ID.
+func (h *StatsHandler) authorizeDomain(w http.ResponseWriter, r *http.Request, domainID string) bool {
+	dom, err := h.domainService.Get(domainID)
+	if err != nil {
+		dom, err = h.domainService.GetByName(domainID)
+	}
+	if err != nil {
+		if callerScope(r, h.tenancy).All() {
+			return true
+		}
+		utils.WriteError(w, http.StatusNotFound, utils.ErrCodeNotFound, "domain not found")
+		return false
+	}
+	return authorizeOwner(w, r, h.tenancy, dom.UserID)
+}
+
 // Helper functions
 
 func parseDateRange(r *http.Request) (time.Time, time.Time) {
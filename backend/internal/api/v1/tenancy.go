@@ -0,0 +1,48 @@
+// Package v1 provides tenancy checks shared by the v1 handlers
+package v1
+
+import (
+	"net/http"
+
+	"github.com/iSundram/OweHost/internal/api/middleware"
+	"github.com/iSundram/OweHost/internal/domain"
+	"github.com/iSundram/OweHost/internal/tenancy"
+	"github.com/iSundram/OweHost/pkg/models"
+	"github.com/iSundram/OweHost/pkg/utils"
+)
+
+// callerScope works out what the caller of a request can reach
+func callerScope(r *http.Request, guard *tenancy.Guard) *tenancy.Scope {
+	return guard.Scope(r.Context(), middleware.GetUserID(r.Context()))
+}
+
+// writeCrossTenant refuses a request for another tenant's resource. Every
+// handler refuses the same way, so the answer tells nothing about whose
+// resource it is.
+func writeCrossTenant(w http.ResponseWriter) {
+	utils.WriteError(w, http.StatusForbidden, utils.ErrCodeForbidden, "Access denied")
+}
+
+// authorizeOwner checks the caller can reach a resource owned by ownerID,
+// refusing the request when it can't
+func authorizeOwner(w http.ResponseWriter, r *http.Request, guard *tenancy.Guard, ownerID string) bool {
+	if !callerScope(r, guard).Owns(ownerID) {
+		writeCrossTenant(w)
+		return false
+	}
+	return true
+}
+
+// authorizeDomain looks up a domain the caller must be able to reach,
+// refusing the request when it is missing or out of reach
+func authorizeDomain(w http.ResponseWriter, r *http.Request, guard *tenancy.Guard, domainService *domain.Service, domainID string) (*models.Domain, bool) {
+	dom, err := domainService.Get(domainID)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, utils.ErrCodeNotFound, err.Error())
+		return nil, false
+	}
+	if !authorizeOwner(w, r, guard, dom.UserID) {
+		return nil, false
+	}
+	return dom, true
+}
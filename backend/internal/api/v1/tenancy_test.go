@@ -0,0 +1,455 @@
+package v1
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/iSundram/OweHost/internal/api/middleware"
+	"github.com/iSundram/OweHost/internal/backup"
+	"github.com/iSundram/OweHost/internal/cron"
+	"github.com/iSundram/OweHost/internal/database"
+	"github.com/iSundram/OweHost/internal/dns"
+	"github.com/iSundram/OweHost/internal/domain"
+	"github.com/iSundram/OweHost/internal/reseller"
+	"github.com/iSundram/OweHost/internal/ssl"
+	"github.com/iSundram/OweHost/internal/stats"
+	"github.com/iSundram/OweHost/internal/storage/account"
+	"github.com/iSundram/OweHost/internal/tenancy"
+	"github.com/iSundram/OweHost/pkg/models"
+)
+
+// fakeDirectory is an in-memory user directory
+type fakeDirectory map[string]*models.User
+
+func (d fakeDirectory) Get(id string) (*models.User, error) {
+	if u, ok := d[id]; ok {
+		return u, nil
+	}
+	return nil, errors.New("user not found")
+}
+
+func (d fakeDirectory) GetByUsername(username string) (*models.User, error) {
+	for _, u := range d {
+		if u.Username == username {
+			return u, nil
+		}
+	}
+	return nil, errors.New("user not found")
+}
+
+// fakeAccounts is an in-memory list of hosting accounts
+type fakeAccounts []account.Account
+
+func (a fakeAccounts) List(ctx context.Context) ([]account.Account, error) {
+	return a, nil
+}
+
+// tenantResources are the resources created for one tenant
+type tenantResources struct {
+	domain, zone, record, db, backup, cronJob, cert string
+}
+
+// tenancyFixture is a small hosting tree:
+//
+//	admin
+//	reseller-a  owns accounts alice, root and reseller-b
+//	  reseller-sub (below reseller-a)  owns account carol
+//	reseller-b  owns accounts bob and dave
+//
+// alice, carol and bob have panel users of the same name; dave only logs
+// in as the account, so its resources are recorded under its name. The
+// root and reseller-b accounts share their names with the admin and
+// another reseller, whose panel users are not theirs.
+type tenancyFixture struct {
+	guard     *tenancy.Guard
+	domains   *domain.Service
+	dns       *dns.Service
+	databases *database.Service
+	backups   *backup.Service
+	crons     *cron.Service
+	ssl       *ssl.Service
+	stats     *stats.Service
+	res       map[string]tenantResources
+}
+
+func newTenancyFixture(t *testing.T) *tenancyFixture {
+	t.Helper()
+
+	users := fakeDirectory{
+		"usr-admin":  {ID: "usr-admin", Username: "root", Role: models.UserRoleAdmin},
+		"usr-resa":   {ID: "usr-resa", Username: "reseller-a", Role: models.UserRoleReseller},
+		"usr-ressub": {ID: "usr-ressub", Username: "reseller-sub", Role: models.UserRoleReseller},
+		"usr-resb":   {ID: "usr-resb", Username: "reseller-b", Role: models.UserRoleReseller},
+		"usr-alice":  {ID: "usr-alice", Username: "alice", Role: models.UserRoleUser},
+		"usr-carol":  {ID: "usr-carol", Username: "carol", Role: models.UserRoleUser},
+		"usr-bob":    {ID: "usr-bob", Username: "bob", Role: models.UserRoleUser},
+	}
+
+	resellers := reseller.NewService()
+	resA, err := resellers.Create(&models.ResellerCreateRequest{UserID: "usr-resa", Name: "Reseller A"})
+	if err != nil {
+		t.Fatalf("Failed to create reseller: %v", err)
+	}
+	if _, err := resellers.Create(&models.ResellerCreateRequest{UserID: "usr-ressub", ParentResellerID: &resA.ID, Name: "Reseller Sub"}); err != nil {
+		t.Fatalf("Failed to create sub-reseller: %v", err)
+	}
+	if _, err := resellers.Create(&models.ResellerCreateRequest{UserID: "usr-resb", Name: "Reseller B"}); err != nil {
+		t.Fatalf("Failed to create reseller: %v", err)
+	}
+
+	accounts := fakeAccounts{
+		{Identity: &account.AccountIdentity{ID: 1, Name: "alice", Owner: tenancy.ResellerOwner("usr-resa")}},
+		{Identity: &account.AccountIdentity{ID: 2, Name: "carol", Owner: tenancy.ResellerOwner("usr-ressub")}},
+		{Identity: &account.AccountIdentity{ID: 3, Name: "bob", Owner: tenancy.ResellerOwner("usr-resb")}},
+		{Identity: &account.AccountIdentity{ID: 4, Name: "dave", Owner: tenancy.ResellerOwner("usr-resb")}},
+		{Identity: &account.AccountIdentity{ID: 5, Name: "root", Owner: tenancy.ResellerOwner("usr-resa")}},
+		{Identity: &account.AccountIdentity{ID: 6, Name: "reseller-b", Owner: tenancy.ResellerOwner("usr-resa")}},
+	}
+
+	f := &tenancyFixture{
+		guard:     tenancy.NewGuard(users, resellers, accounts),
+		domains:   domain.NewService(),
+		dns:       dns.NewService(),
+		databases: database.NewService(),
+		backups:   backup.NewService(),
+		crons:     cron.NewService(),
+		ssl:       ssl.NewService(),
+		res:       make(map[string]tenantResources),
+	}
+	f.stats = stats.NewService(f.domains)
+
+	for _, owner := range []string{"usr-alice", "usr-carol", "usr-bob", "dave"} {
+		f.res[owner] = f.createResources(t, owner)
+	}
+	return f
+}
+
+// createResources gives owner one of each resource the handlers guard
+func (f *tenancyFixture) createResources(t *testing.T, owner string) tenantResources {
+	t.Helper()
+	var res tenantResources
+
+	dom, err := f.domains.Create(owner, &models.DomainCreateRequest{
+		Name: strings.TrimPrefix(owner, "usr-") + ".example.com",
+		Type: models.DomainTypePrimary,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create domain: %v", err)
+	}
+	res.domain = dom.ID
+
+	zone, err := f.dns.CreateZone(dom.ID, dom.Name)
+	if err != nil {
+		t.Fatalf("Failed to create zone: %v", err)
+	}
+	res.zone = zone.ID
+	record, err := f.dns.CreateRecord(zone.ID, &models.DNSRecordCreateRequest{
+		Name:    "www",
+		Type:    models.DNSRecordTypeA,
+		Content: "192.0.2.10",
+		TTL:     3600,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create record: %v", err)
+	}
+	res.record = record.ID
+
+	db, err := f.databases.Create(owner, &models.DatabaseCreateRequest{Name: "app", Type: models.DatabaseTypeMySQL})
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	res.db = db.ID
+
+	bkp, err := f.backups.Create(owner, &models.BackupCreateRequest{Type: models.BackupTypeFull, IncludeFiles: true})
+	if err != nil {
+		t.Fatalf("Failed to create backup: %v", err)
+	}
+	res.backup = bkp.ID
+
+	job, err := f.crons.Create(owner, &models.CronJobCreateRequest{
+		Name:           "cleanup",
+		Command:        "/bin/true",
+		CronExpression: "0 * * * *",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create cron job: %v", err)
+	}
+	res.cronJob = job.ID
+
+	cert, err := f.ssl.GenerateSelfSigned(dom.ID, dom.Name)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	res.cert = cert.ID
+
+	return res
+}
+
+// serve runs a handler as the user with ID userID
+func serve(handler http.HandlerFunc, userID, method, path string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req = req.WithContext(context.WithValue(req.Context(), middleware.ContextKeyUserID, userID))
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+// resourceCase is one guarded handler, and how to reach a tenant's
+// resource through it
+type resourceCase struct {
+	name    string
+	handler http.HandlerFunc
+	method  string
+	path    func(res tenantResources) string
+}
+
+func (f *tenancyFixture) resourceCases() []resourceCase {
+	domainHandler := NewDomainHandler(f.domains, nil, f.guard)
+	dnsHandler := NewDNSHandler(f.dns, f.domains, f.guard)
+	databaseHandler := NewDatabaseHandler(f.databases, nil, f.guard)
+	backupHandler := NewBackupHandler(f.backups, nil, f.guard)
+	cronHandler := NewCronHandler(f.crons, nil, f.guard)
+	sslHandler := NewSSLHandler(f.ssl, nil, f.domains, f.guard)
+	statsHandler := NewStatsHandler(f.stats, f.domains, f.guard)
+
+	return []resourceCase{
+		{"domain", domainHandler.Get, http.MethodGet, func(res tenantResources) string {
+			return "/api/v1/domains/" + res.domain
+		}},
+		{"subdomains", domainHandler.ListSubdomains, http.MethodGet, func(res tenantResources) string {
+			return "/api/v1/domains/" + res.domain + "/subdomains"
+		}},
+		{"domain settings", domainHandler.GetSettings, http.MethodGet, func(res tenantResources) string {
+			return "/api/v1/domains/" + res.domain + "/settings"
+		}},
+		{"dns zone", dnsHandler.GetZone, http.MethodGet, func(res tenantResources) string {
+			return "/api/v1/dns/zones/" + res.zone
+		}},
+		{"dns records", dnsHandler.ListRecords, http.MethodGet, func(res tenantResources) string {
+			return "/api/v1/dns/zones/" + res.zone + "/records"
+		}},
+		{"database", databaseHandler.Get, http.MethodGet, func(res tenantResources) string {
+			return "/api/v1/databases/" + res.db
+		}},
+		{"database users", databaseHandler.ListUsers, http.MethodGet, func(res tenantResources) string {
+			return "/api/v1/databases/" + res.db + "/users"
+		}},
+		{"backup", backupHandler.GetBackup, http.MethodGet, func(res tenantResources) string {
+			return "/api/v1/backups/" + res.backup
+		}},
+		{"cron job", cronHandler.GetCronJob, http.MethodGet, func(res tenantResources) string {
+			return "/api/v1/cron/jobs/" + res.cronJob
+		}},
+		{"cron executions", cronHandler.GetCronJobExecutions, http.MethodGet, func(res tenantResources) string {
+			return "/api/v1/cron/jobs/" + res.cronJob + "/executions"
+		}},
+		{"ssl certificate", sslHandler.GetCertificate, http.MethodGet, func(res tenantResources) string {
+			return "/api/v1/ssl/certificates/" + res.cert
+		}},
+		{"ssl settings", sslHandler.GetSSLSettings, http.MethodGet, func(res tenantResources) string {
+			return "/api/v1/ssl/" + res.domain + "/settings"
+		}},
+		{"domain stats", statsHandler.GetDomainSummary, http.MethodGet, func(res tenantResources) string {
+			return "/api/v1/stats/domain/" + res.domain + "/summary"
+		}},
+	}
+}
+
+// access is whether a caller should reach a tenant's resources
+type access struct {
+	caller, owner string
+	allowed       bool
+}
+
+var tenancyAccess = []access{
+	{"usr-admin", "usr-alice", true},
+	{"usr-admin", "dave", true},
+	{"usr-resa", "usr-alice", true},
+	{"usr-resa", "usr-carol", true},
+	{"usr-resa", "usr-bob", false},
+	{"usr-resa", "dave", false},
+	{"usr-ressub", "usr-carol", true},
+	{"usr-ressub", "usr-alice", false},
+	{"usr-resb", "usr-bob", true},
+	{"usr-resb", "dave", true},
+	{"usr-resb", "usr-alice", false},
+	{"usr-alice", "usr-alice", true},
+	{"usr-alice", "usr-carol", false},
+	{"alice", "usr-alice", true},
+	{"alice", "usr-bob", false},
+	{"dave", "dave", true},
+	{"dave", "usr-bob", false},
+	{"usr-bob", "dave", false},
+}
+
+func TestTenancy_ResourceAccess(t *testing.T) {
+	f := newTenancyFixture(t)
+
+	for _, tc := range f.resourceCases() {
+		for _, a := range tenancyAccess {
+			rec := serve(tc.handler, a.caller, tc.method, tc.path(f.res[a.owner]))
+			switch {
+			case a.allowed && rec.Code != http.StatusOK:
+				t.Errorf("%s: %s reaching %s: expected 200, got %d: %s", tc.name, a.caller, a.owner, rec.Code, rec.Body.String())
+			case !a.allowed && rec.Code != http.StatusForbidden:
+				t.Errorf("%s: %s reaching %s: expected 403, got %d", tc.name, a.caller, a.owner, rec.Code)
+			}
+		}
+	}
+}
+
+func TestTenancy_CrossTenantWritesRefused(t *testing.T) {
+	f := newTenancyFixture(t)
+	bob := f.res["usr-bob"]
+
+	domainHandler := NewDomainHandler(f.domains, nil, f.guard)
+	dnsHandler := NewDNSHandler(f.dns, f.domains, f.guard)
+	databaseHandler := NewDatabaseHandler(f.databases, nil, f.guard)
+	backupHandler := NewBackupHandler(f.backups, nil, f.guard)
+	cronHandler := NewCronHandler(f.crons, nil, f.guard)
+	sslHandler := NewSSLHandler(f.ssl, nil, f.domains, f.guard)
+
+	writes := []resourceCase{
+		{"delete domain", domainHandler.Delete, http.MethodDelete, func(res tenantResources) string {
+			return "/api/v1/domains/" + res.domain
+		}},
+		{"delete zone", dnsHandler.DeleteZone, http.MethodDelete, func(res tenantResources) string {
+			return "/api/v1/dns/zones/" + res.zone
+		}},
+		{"delete record", dnsHandler.DeleteRecord, http.MethodDelete, func(res tenantResources) string {
+			return "/api/v1/dns/records/" + res.record
+		}},
+		{"delete database", databaseHandler.Delete, http.MethodDelete, func(res tenantResources) string {
+			return "/api/v1/databases/" + res.db
+		}},
+		{"delete backup", backupHandler.DeleteBackup, http.MethodDelete, func(res tenantResources) string {
+			return "/api/v1/backups/" + res.backup
+		}},
+		{"delete cron job", cronHandler.DeleteCronJob, http.MethodDelete, func(res tenantResources) string {
+			return "/api/v1/cron/jobs/" + res.cronJob
+		}},
+		{"delete certificate", sslHandler.DeleteCertificate, http.MethodDelete, func(res tenantResources) string {
+			return "/api/v1/ssl/certificates/" + res.cert
+		}},
+	}
+	for _, tc := range writes {
+		rec := serve(tc.handler, "usr-resa", tc.method, tc.path(bob))
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("%s: expected 403, got %d", tc.name, rec.Code)
+		}
+	}
+
+	if _, err := f.domains.Get(bob.domain); err != nil {
+		t.Errorf("Domain was deleted across tenants: %v", err)
+	}
+	if _, err := f.dns.GetZone(bob.zone); err != nil {
+		t.Errorf("Zone was deleted across tenants: %v", err)
+	}
+	if _, err := f.dns.GetRecord(bob.record); err != nil {
+		t.Errorf("Record was deleted across tenants: %v", err)
+	}
+	if _, err := f.databases.Get(bob.db); err != nil {
+		t.Errorf("Database was deleted across tenants: %v", err)
+	}
+	if _, err := f.backups.Get(bob.backup); err != nil {
+		t.Errorf("Backup was deleted across tenants: %v", err)
+	}
+	if _, err := f.crons.Get(bob.cronJob); err != nil {
+		t.Errorf("Cron job was deleted across tenants: %v", err)
+	}
+	if _, err := f.ssl.Get(bob.cert); err != nil {
+		t.Errorf("Certificate was deleted across tenants: %v", err)
+	}
+}
+
+func TestTenancy_ListsScoped(t *testing.T) {
+	f := newTenancyFixture(t)
+
+	domainHandler := NewDomainHandler(f.domains, nil, f.guard)
+	dnsHandler := NewDNSHandler(f.dns, f.domains, f.guard)
+	databaseHandler := NewDatabaseHandler(f.databases, nil, f.guard)
+	backupHandler := NewBackupHandler(f.backups, nil, f.guard)
+	cronHandler := NewCronHandler(f.crons, nil, f.guard)
+	sslHandler := NewSSLHandler(f.ssl, nil, f.domains, f.guard)
+
+	lists := []struct {
+		name    string
+		handler http.HandlerFunc
+		path    string
+		id      func(res tenantResources) string
+	}{
+		{"domains", domainHandler.List, "/api/v1/domains", func(res tenantResources) string { return res.domain }},
+		{"dns zones", dnsHandler.ListZones, "/api/v1/dns/zones", func(res tenantResources) string { return res.zone }},
+		{"databases", databaseHandler.List, "/api/v1/databases", func(res tenantResources) string { return res.db }},
+		{"backups", backupHandler.ListBackups, "/api/v1/backups", func(res tenantResources) string { return res.backup }},
+		{"cron jobs", cronHandler.ListCronJobs, "/api/v1/cron/jobs", func(res tenantResources) string { return res.cronJob }},
+		{"ssl certificates", sslHandler.ListCertificates, "/api/v1/ssl/certificates", func(res tenantResources) string { return res.cert }},
+	}
+
+	for _, tc := range lists {
+		for _, a := range tenancyAccess {
+			rec := serve(tc.handler, a.caller, http.MethodGet, tc.path)
+			if rec.Code != http.StatusOK {
+				t.Errorf("%s: %s listing: expected 200, got %d", tc.name, a.caller, rec.Code)
+				continue
+			}
+			listed := strings.Contains(rec.Body.String(), tc.id(f.res[a.owner]))
+			if listed != a.allowed {
+				t.Errorf("%s: %s listing %s: expected listed=%v", tc.name, a.caller, a.owner, a.allowed)
+			}
+		}
+	}
+}
+
+func TestTenancy_ScopeParents(t *testing.T) {
+	f := newTenancyFixture(t)
+	ctx := context.Background()
+
+	scope := f.guard.Scope(ctx, "usr-resa")
+	if !scope.CanParent(tenancy.ResellerOwner("usr-resa")) || !scope.CanParent(tenancy.ResellerOwner("usr-ressub")) {
+		t.Error("Expected reseller to put accounts under itself and resellers below it")
+	}
+	if scope.CanParent(tenancy.ResellerOwner("usr-resb")) || scope.CanParent("admin") {
+		t.Error("Expected reseller not to put accounts under other owners")
+	}
+	if !f.guard.Scope(ctx, "usr-admin").CanParent("admin") {
+		t.Error("Expected admin to put accounts under admin")
+	}
+
+	if err := f.guard.Check(ctx, "usr-ressub", "usr-alice"); !errors.Is(err, tenancy.ErrCrossTenant) {
+		t.Errorf("Expected ErrCrossTenant for a reseller above the caller, got %v", err)
+	}
+	if err := f.guard.Check(ctx, "", "usr-alice"); !errors.Is(err, tenancy.ErrCrossTenant) {
+		t.Errorf("Expected ErrCrossTenant for an anonymous caller, got %v", err)
+	}
+}
+
+func TestTenancy_AccountNamedAfterPrivilegedUser(t *testing.T) {
+	f := newTenancyFixture(t)
+	ctx := context.Background()
+
+	// The user handlers guard a panel user by its ID
+	manageUser := func(w http.ResponseWriter, r *http.Request) {
+		if authorizeOwner(w, r, f.guard, strings.TrimPrefix(r.URL.Path, "/api/v1/users/")) {
+			w.WriteHeader(http.StatusOK)
+		}
+	}
+
+	for _, target := range []string{"usr-admin", "usr-resb"} {
+		if err := f.guard.Check(ctx, "usr-resa", target); !errors.Is(err, tenancy.ErrCrossTenant) {
+			t.Errorf("%s: expected ErrCrossTenant through a same-named account, got %v", target, err)
+		}
+		if rec := serve(manageUser, "usr-resa", http.MethodDelete, "/api/v1/users/"+target); rec.Code != http.StatusForbidden {
+			t.Errorf("%s: expected 403 managing through a same-named account, got %d", target, rec.Code)
+		}
+	}
+
+	scope := f.guard.Scope(ctx, "usr-resa")
+	if !scope.Owns("root") || !scope.Owns("reseller-b") || !scope.Owns("usr-alice") {
+		t.Error("Expected reseller to keep its accounts and their own users")
+	}
+}
@@ -3,6 +3,7 @@ package v1
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/iSundram/OweHost/internal/api/middleware"
@@ -33,7 +34,10 @@ func (h *TwoFactorHandler) GetStatus(w http.ResponseWriter, r *http.Request) {
 	enabled := h.tfService.IsEnabled(userID)
 	
 	response := map[string]interface{}{
-		"enabled": enabled,
+		"enabled":              enabled,
+		"required":             h.isRequired(r),
+		"methods":              h.tfService.Methods(userID),
+		"webauthn_credentials": len(h.tfService.ListCredentials(userID)),
 	}
 
 	if enabled {
@@ -112,6 +116,12 @@ func (h *TwoFactorHandler) Disable(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Security keys still count as the second factor without TOTP
+	if h.isRequired(r) && len(h.tfService.ListCredentials(userID)) == 0 {
+		utils.WriteError(w, http.StatusForbidden, utils.ErrCodeForbidden, "Two-factor authentication is required for your account")
+		return
+	}
+
 	if err := h.tfService.Disable(userID, req.Code); err != nil {
 		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
 		return
@@ -185,3 +195,53 @@ func (h *TwoFactorHandler) GetLoginAttempts(w http.ResponseWriter, r *http.Reque
 	attempts := h.tfService.GetLoginAttempts(userID, 20)
 	utils.WriteJSON(w, http.StatusOK, attempts)
 }
+
+// ForgetDevices makes every remembered device of the current user ask for
+// a code again
+func (h *TwoFactorHandler) ForgetDevices(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r.Context())
+
+	if err := h.tfService.ForgetDevices(userID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, utils.ErrCodeInternalError, err.Error())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Remembered devices forgotten",
+	})
+}
+
+// GetPolicy returns who must use 2FA
+func (h *TwoFactorHandler) GetPolicy(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSON(w, http.StatusOK, h.tfService.GetPolicy())
+}
+
+// SetPolicy sets who must use 2FA, by role and by owner
+func (h *TwoFactorHandler) SetPolicy(w http.ResponseWriter, r *http.Request) {
+	var policy models.TwoFactorPolicy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := h.tfService.SetPolicy(policy); err != nil {
+		if errors.Is(err, twofactor.ErrInvalidPolicy) {
+			utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, utils.ErrCodeInternalError, err.Error())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, h.tfService.GetPolicy())
+}
+
+// isRequired reports whether the policy makes the current user use 2FA.
+// Accounts aren't panel users; their token carries their owner.
+func (h *TwoFactorHandler) isRequired(r *http.Request) bool {
+	u, err := h.userService.Get(middleware.GetUserID(r.Context()))
+	if err != nil {
+		return h.tfService.IsRequired(models.UserRoleAccount, middleware.GetTenantID(r.Context()))
+	}
+	return h.tfService.IsRequired(u.Role, u.TenantID)
+}
@@ -0,0 +1,129 @@
+// Package v1 provides security key API handlers for OweHost
+package v1
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/iSundram/OweHost/internal/api/middleware"
+	"github.com/iSundram/OweHost/internal/twofactor"
+	"github.com/iSundram/OweHost/pkg/utils"
+)
+
+// registerCredentialRequest finishes registering a security key
+type registerCredentialRequest struct {
+	Name       string                        `json:"name"`
+	Credential twofactor.PublicKeyCredential `json:"credential"`
+}
+
+// BeginWebAuthnRegistration starts adding a security key or passkey for
+// the current user
+func (h *TwoFactorHandler) BeginWebAuthnRegistration(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r.Context())
+
+	// Accounts aren't panel users; their ID is their name
+	username := userID
+	if u, err := h.userService.Get(userID); err == nil {
+		username = u.Username
+	}
+
+	options, err := h.tfService.BeginRegistration(userID, username)
+	if err != nil {
+		utils.WriteError(w, http.StatusServiceUnavailable, utils.ErrCodeInternalError, err.Error())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, options)
+}
+
+// FinishWebAuthnRegistration adds the security key that answered
+// BeginWebAuthnRegistration
+func (h *TwoFactorHandler) FinishWebAuthnRegistration(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r.Context())
+
+	var req registerCredentialRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid request body")
+		return
+	}
+
+	credential, err := h.tfService.FinishRegistration(userID, strings.TrimSpace(req.Name), &req.Credential)
+	if err != nil {
+		switch {
+		case errors.Is(err, twofactor.ErrCredentialExists):
+			utils.WriteError(w, http.StatusConflict, utils.ErrCodeConflict, err.Error())
+		case errors.Is(err, twofactor.ErrWebAuthnCeremony), errors.Is(err, twofactor.ErrWebAuthnResponse),
+			errors.Is(err, twofactor.ErrAttestationFormat), errors.Is(err, twofactor.ErrUnsupportedAlgorithm):
+			utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
+		default:
+			utils.WriteError(w, http.StatusInternalServerError, utils.ErrCodeInternalError, err.Error())
+		}
+		return
+	}
+
+	utils.WriteCreated(w, credential)
+}
+
+// ListWebAuthnCredentials lists the current user's security keys
+func (h *TwoFactorHandler) ListWebAuthnCredentials(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSON(w, http.StatusOK, h.tfService.ListCredentials(middleware.GetUserID(r.Context())))
+}
+
+// RenameWebAuthnCredential renames one of the current user's security keys
+func (h *TwoFactorHandler) RenameWebAuthnCredential(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r.Context())
+	id := extractIDFromPath(r.URL.Path, "/api/v1/2fa/webauthn/credentials/")
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid request body")
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		utils.WriteError(w, http.StatusBadRequest, utils.ErrCodeValidation, "Name is required")
+		return
+	}
+
+	if err := h.tfService.RenameCredential(userID, id, name); err != nil {
+		if errors.Is(err, twofactor.ErrCredentialNotFound) {
+			utils.WriteError(w, http.StatusNotFound, utils.ErrCodeNotFound, err.Error())
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, utils.ErrCodeInternalError, err.Error())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Security key renamed",
+	})
+}
+
+// DeleteWebAuthnCredential removes one of the current user's security
+// keys. The last second factor of a user who must have one stays.
+func (h *TwoFactorHandler) DeleteWebAuthnCredential(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r.Context())
+	id := extractIDFromPath(r.URL.Path, "/api/v1/2fa/webauthn/credentials/")
+
+	if !h.tfService.IsEnabled(userID) && len(h.tfService.ListCredentials(userID)) == 1 && h.isRequired(r) {
+		utils.WriteError(w, http.StatusForbidden, utils.ErrCodeForbidden, "Two-factor authentication is required for your account")
+		return
+	}
+
+	if err := h.tfService.DeleteCredential(userID, id); err != nil {
+		if errors.Is(err, twofactor.ErrCredentialNotFound) {
+			utils.WriteError(w, http.StatusNotFound, utils.ErrCodeNotFound, err.Error())
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, utils.ErrCodeInternalError, err.Error())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Security key removed",
+	})
+}
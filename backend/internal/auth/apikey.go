@@ -0,0 +1,333 @@
+// Package auth provides authentication services for OweHost
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/iSundram/OweHost/internal/storage/events"
+	"github.com/iSundram/OweHost/pkg/models"
+)
+
+// apiKeysPath holds the API keys, by hash, so they survive a restart
+const apiKeysPath = "/var/lib/owehost/auth/api_keys.json"
+
+// lastUsedInterval is how stale a key's saved last use may get. Every
+// request updates it in memory; writing each one out would cost a file
+// write per API call.
+const lastUsedInterval = time.Minute
+
+// API key errors
+var (
+	ErrAPIKeyNotFound   = errors.New("API key not found")
+	ErrAPIKeyRevoked    = errors.New("API key revoked")
+	ErrAPIKeyExpired    = errors.New("API key expired")
+	ErrAPIKeyScope      = errors.New("API key lacks the scope this endpoint requires")
+	ErrAPIKeyAddress    = errors.New("API key is not allowed from this address")
+	ErrInvalidScope     = errors.New("invalid scope")
+	ErrInvalidIPBinding = errors.New("invalid IP binding")
+)
+
+// scopeResources maps the first path segment after /api/v1/ to the
+// resource named in scopes. Related endpoints share one resource.
+var scopeResources = map[string]string{
+	"2fa":              "twofactor",
+	"accounts":         "account",
+	"admin":            "admin",
+	"api-keys":         "apikey",
+	"apps":             "apps",
+	"audit":            "audit",
+	"backups":          "backup",
+	"cluster":          "cluster",
+	"cron":             "cron",
+	"database-backups": "database",
+	"database-users":   "database",
+	"databases":        "database",
+	"dns":              "dns",
+	"domains":          "domain",
+	"features":         "feature",
+	"files":            "files",
+	"firewall":         "firewall",
+	"ftp":              "ftp",
+	"git":              "git",
+	"logging":          "logging",
+	"notifications":    "notification",
+	"packages":         "package",
+	"plugins":          "plugin",
+	"provisioning":     "provisioning",
+	"resellers":        "reseller",
+	"resources":        "resource",
+	"runtime":          "runtime",
+	"runtimes":         "runtime",
+	"sessions":         "session",
+	"ssh":              "ssh",
+	"ssl":              "ssl",
+	"stats":            "stats",
+	"subdomains":       "domain",
+	"users":            "user",
+	"webserver":        "webserver",
+}
+
+// ScopeFor returns the scope a request needs, such as "dns:write" or
+// "backup:read". Reading is GET and HEAD; every other method writes.
+func ScopeFor(method, path string) string {
+	segment := strings.TrimPrefix(path, "/api/v1/")
+	if i := strings.IndexByte(segment, '/'); i >= 0 {
+		segment = segment[:i]
+	}
+	resource, ok := scopeResources[segment]
+	if !ok {
+		resource = segment
+	}
+
+	access := "write"
+	if method == http.MethodGet || method == http.MethodHead {
+		access = "read"
+	}
+	return resource + ":" + access
+}
+
+// ValidateScope checks a scope is "*" or "<resource>:<access>", where the
+// resource may be "*" and access is read, write or "*"
+func ValidateScope(scope string) error {
+	if scope == "*" {
+		return nil
+	}
+	resource, access, ok := strings.Cut(scope, ":")
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrInvalidScope, scope)
+	}
+	if access != "read" && access != "write" && access != "*" {
+		return fmt.Errorf("%w: %q", ErrInvalidScope, scope)
+	}
+	if resource == "*" {
+		return nil
+	}
+	for _, r := range scopeResources {
+		if r == resource {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %q", ErrInvalidScope, scope)
+}
+
+// scopeAllows reports whether scopes grant required. Write access to a
+// resource includes reading it.
+func scopeAllows(scopes []string, required string) bool {
+	resource, access, _ := strings.Cut(required, ":")
+	for _, scope := range scopes {
+		if scope == "*" {
+			return true
+		}
+		r, a, _ := strings.Cut(scope, ":")
+		if r != "*" && r != resource {
+			continue
+		}
+		if a == "*" || a == access || (a == "write" && access == "read") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseIPBinding parses an address or CIDR a key is bound to
+func parseIPBinding(binding string) (netip.Prefix, error) {
+	if strings.Contains(binding, "/") {
+		prefix, err := netip.ParsePrefix(binding)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("%w: %q", ErrInvalidIPBinding, binding)
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(binding)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("%w: %q", ErrInvalidIPBinding, binding)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// ipAllowed reports whether ip falls in one of bindings. A key without
+// bindings may be used from anywhere.
+func ipAllowed(bindings []string, ip string) bool {
+	if len(bindings) == 0 {
+		return true
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, binding := range bindings {
+		if prefix, err := parseIPBinding(binding); err == nil && prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthorizeAPIKey checks a key may make a request needing scope from ip,
+// and records its use
+func (s *Service) AuthorizeAPIKey(key, scope, ip string) (*models.APIKey, error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	apiKey, err := s.validAPIKeyLocked(key)
+	if err != nil {
+		return nil, err
+	}
+	if !ipAllowed(apiKey.IPBindings, ip) {
+		return nil, ErrAPIKeyAddress
+	}
+	if !scopeAllows(apiKey.Scopes, scope) {
+		return nil, ErrAPIKeyScope
+	}
+
+	stale := apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= lastUsedInterval
+	apiKey.LastUsedAt = &now
+	apiKey.LastUsedIP = ip
+	if stale {
+		if err := s.saveAPIKeysLocked(); err != nil {
+			fmt.Printf("warning: failed to save API keys: %v\n", err)
+		}
+	}
+
+	return copyAPIKey(apiKey), nil
+}
+
+// copyAPIKey copies a key for use outside s.mu
+func copyAPIKey(apiKey *models.APIKey) *models.APIKey {
+	copied := *apiKey
+	copied.Scopes = append([]string(nil), apiKey.Scopes...)
+	copied.IPBindings = append([]string(nil), apiKey.IPBindings...)
+	return &copied
+}
+
+// ListAPIKeys returns a user's keys, revoked ones included, oldest first
+func (s *Service) ListAPIKeys(userID string) []*models.APIKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]*models.APIKey, 0)
+	for _, apiKey := range s.apiKeys {
+		if apiKey.UserID == userID {
+			keys = append(keys, copyAPIKey(apiKey))
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys
+}
+
+// RevokeAPIKey revokes a key for good. ownerID limits it to that user's
+// keys; "" revokes anyone's.
+func (s *Service) RevokeAPIKey(id, ownerID, actor, actorIP string) error {
+	s.mu.Lock()
+	var apiKey *models.APIKey
+	for _, k := range s.apiKeys {
+		if k.ID == id && (ownerID == "" || k.UserID == ownerID) {
+			apiKey = k
+			break
+		}
+	}
+	if apiKey == nil {
+		s.mu.Unlock()
+		return ErrAPIKeyNotFound
+	}
+	if apiKey.RevokedAt != nil {
+		s.mu.Unlock()
+		return ErrAPIKeyRevoked
+	}
+
+	now := time.Now()
+	apiKey.RevokedAt = &now
+	err := s.saveAPIKeysLocked()
+	if err != nil {
+		apiKey.RevokedAt = nil
+	}
+	data := apiKeyEventData(apiKey)
+	s.mu.Unlock()
+
+	opts := events.EmitOptions{Actor: actor, ActorType: "user", ActorIP: actorIP, Data: data}
+	if err != nil {
+		s.events.EmitFailed(events.EventAPIKeyRevoke, err.Error(), opts)
+		return err
+	}
+	s.events.EmitSuccess(events.EventAPIKeyRevoke, opts)
+	return nil
+}
+
+// apiKeyEventData describes a key in its events; the key itself never
+// appears
+func apiKeyEventData(apiKey *models.APIKey) map[string]interface{} {
+	return map[string]interface{}{
+		"key_id":      apiKey.ID,
+		"user_id":     apiKey.UserID,
+		"name":        apiKey.Name,
+		"prefix":      apiKey.Prefix,
+		"scopes":      apiKey.Scopes,
+		"ip_bindings": apiKey.IPBindings,
+	}
+}
+
+// storedAPIKey is a key as saved. The hash is kept out of API responses
+// but is what requests are checked against.
+type storedAPIKey struct {
+	*models.APIKey
+	Hash string `json:"key_hash"`
+}
+
+// loadAPIKeys reads the saved keys. Having none saved yet is not an error.
+func (s *Service) loadAPIKeys() error {
+	data, err := os.ReadFile(s.apiKeysPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var stored []storedAPIKey
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+	for _, k := range stored {
+		if k.APIKey == nil || k.Hash == "" {
+			continue
+		}
+		k.KeyHash = k.Hash
+		s.apiKeys[k.Hash] = k.APIKey
+	}
+	return nil
+}
+
+// saveAPIKeysLocked writes every key out; s.mu must be held. The file only
+// holds hashes, but they identify the keys, so only root may read it.
+func (s *Service) saveAPIKeysLocked() error {
+	stored := make([]storedAPIKey, 0, len(s.apiKeys))
+	for hash, apiKey := range s.apiKeys {
+		stored = append(stored, storedAPIKey{APIKey: apiKey, Hash: hash})
+	}
+	sort.Slice(stored, func(i, j int) bool { return stored[i].CreatedAt.Before(stored[j].CreatedAt) })
+
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.apiKeysPath), 0700); err != nil {
+		return err
+	}
+	tmp := s.apiKeysPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.apiKeysPath)
+}
@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/iSundram/OweHost/pkg/models"
+)
+
+func TestScopeFor(t *testing.T) {
+	tests := []struct {
+		method, path, want string
+	}{
+		{http.MethodGet, "/api/v1/dns/zones/z1", "dns:read"},
+		{http.MethodHead, "/api/v1/domains", "domain:read"},
+		{http.MethodPost, "/api/v1/subdomains", "domain:write"},
+		{http.MethodDelete, "/api/v1/database-users/u1", "database:write"},
+		{http.MethodPatch, "/api/v1/2fa/disable", "twofactor:write"},
+		{http.MethodGet, "/api/v1/api-keys", "apikey:read"},
+		{http.MethodGet, "/api/v1/unmapped/x", "unmapped:read"},
+	}
+	for _, tt := range tests {
+		if got := ScopeFor(tt.method, tt.path); got != tt.want {
+			t.Errorf("%s %s: expected %s, got %s", tt.method, tt.path, tt.want, got)
+		}
+	}
+}
+
+func TestValidateScope(t *testing.T) {
+	tests := []struct {
+		scope string
+		valid bool
+	}{
+		{"*", true},
+		{"dns:read", true},
+		{"backup:write", true},
+		{"database:*", true},
+		{"*:read", true},
+		{"dns", false},
+		{"dns:delete", false},
+		{"databases:read", false},
+		{"unmapped:read", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		err := ValidateScope(tt.scope)
+		if (err == nil) != tt.valid {
+			t.Errorf("%q: expected valid=%v, got %v", tt.scope, tt.valid, err)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidScope) {
+			t.Errorf("%q: expected ErrInvalidScope, got %v", tt.scope, err)
+		}
+	}
+}
+
+func TestScopeAllows(t *testing.T) {
+	tests := []struct {
+		name     string
+		scopes   []string
+		required string
+		want     bool
+	}{
+		{"exact", []string{"dns:read"}, "dns:read", true},
+		{"write includes read", []string{"dns:write"}, "dns:read", true},
+		{"read excludes write", []string{"dns:read"}, "dns:write", false},
+		{"other resource", []string{"dns:write"}, "domain:read", false},
+		{"any access", []string{"backup:*"}, "backup:write", true},
+		{"any resource", []string{"*:read"}, "ssl:read", true},
+		{"any resource read only", []string{"*:read"}, "ssl:write", false},
+		{"everything", []string{"*"}, "admin:write", true},
+		{"one of several", []string{"dns:read", "cron:write"}, "cron:write", true},
+		{"none", nil, "dns:read", false},
+		{"resource prefix", []string{"dns:write"}, "dnssec:read", false},
+	}
+	for _, tt := range tests {
+		if got := scopeAllows(tt.scopes, tt.required); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestParseIPBinding(t *testing.T) {
+	tests := []struct {
+		binding string
+		want    string
+		valid   bool
+	}{
+		{"192.0.2.10", "192.0.2.10/32", true},
+		{"192.0.2.10/24", "192.0.2.0/24", true},
+		{"::ffff:192.0.2.10", "192.0.2.10/32", true},
+		{"2001:db8::1", "2001:db8::1/128", true},
+		{"2001:db8::/32", "2001:db8::/32", true},
+		{"192.0.2.0/33", "", false},
+		{"example.com", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		prefix, err := parseIPBinding(tt.binding)
+		if (err == nil) != tt.valid {
+			t.Errorf("%q: expected valid=%v, got %v", tt.binding, tt.valid, err)
+			continue
+		}
+		if err != nil {
+			if !errors.Is(err, ErrInvalidIPBinding) {
+				t.Errorf("%q: expected ErrInvalidIPBinding, got %v", tt.binding, err)
+			}
+			continue
+		}
+		if prefix.String() != tt.want {
+			t.Errorf("%q: expected %s, got %s", tt.binding, tt.want, prefix)
+		}
+	}
+}
+
+func TestIPAllowed(t *testing.T) {
+	bindings := []string{"192.0.2.0/24", "198.51.100.7", "2001:db8::/48", "not-an-address"}
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"192.0.2.200", true},
+		{"192.0.3.1", false},
+		{"198.51.100.7", true},
+		{"198.51.100.8", false},
+		{"::ffff:198.51.100.7", true},
+		{"2001:db8:0:ffff::1", true},
+		{"2001:db8:1::1", false},
+		{"", false},
+		{"garbage", false},
+	}
+	for _, tt := range tests {
+		if got := ipAllowed(bindings, tt.ip); got != tt.want {
+			t.Errorf("%q: expected %v, got %v", tt.ip, tt.want, got)
+		}
+	}
+
+	if !ipAllowed(nil, "203.0.113.1") {
+		t.Error("Expected a key without bindings to be allowed from anywhere")
+	}
+}
+
+func TestCopyAPIKey(t *testing.T) {
+	key := &models.APIKey{Scopes: []string{"dns:read"}, IPBindings: []string{"192.0.2.0/24"}}
+	copied := copyAPIKey(key)
+	copied.Scopes[0] = "*"
+	copied.IPBindings[0] = "0.0.0.0/0"
+	if key.Scopes[0] != "dns:read" || key.IPBindings[0] != "192.0.2.0/24" {
+		t.Errorf("Expected the stored key to be unchanged, got %v %v", key.Scopes, key.IPBindings)
+	}
+}
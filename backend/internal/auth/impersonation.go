@@ -0,0 +1,159 @@
+// Package auth provides authentication services for OweHost
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/iSundram/OweHost/internal/storage/events"
+	"github.com/iSundram/OweHost/pkg/models"
+	"github.com/iSundram/OweHost/pkg/utils"
+)
+
+// ErrNotImpersonating is ending an impersonation with a token that isn't one
+var ErrNotImpersonating = errors.New("not an impersonation session")
+
+// Impersonator is the admin or reseller behind an impersonation token,
+// carried in its "act" claim
+type Impersonator struct {
+	UserID   string          `json:"sub"`
+	Username string          `json:"username"`
+	Role     models.UserRole `json:"role"`
+}
+
+// ImpersonationToken lets an admin or reseller act as a user until it
+// expires. There is no refresh token; a new one must be asked for.
+type ImpersonationToken struct {
+	AccessToken   string        `json:"access_token"`
+	TokenType     string        `json:"token_type"`
+	ExpiresIn     int           `json:"expires_in"`
+	ExpiresAt     time.Time     `json:"expires_at"`
+	SessionID     string        `json:"session_id"`
+	Impersonating bool          `json:"impersonating"`
+	Impersonator  *Impersonator `json:"impersonator"`
+	UserID        string        `json:"user_id"`
+	Username      string        `json:"username"`
+	Role          string        `json:"role"`
+}
+
+// Impersonate issues a token acting as target on behalf of actor. Its
+// session is the target's, marked with the actor, and can be revoked like
+// any other; it doesn't count towards the target's session limit.
+func (s *Service) Impersonate(actor *Impersonator, target *models.User, reason, ip, userAgent string) (*ImpersonationToken, error) {
+	now := time.Now()
+	ttl := s.config.Auth.ImpersonationTTL
+	copied := *target
+	session := &storedSession{
+		Session: &models.Session{
+			ID:             utils.GenerateID("sess"),
+			UserID:         target.ID,
+			IPAddress:      ip,
+			UserAgent:      userAgent,
+			CreatedAt:      now,
+			ExpiresAt:      now.Add(ttl),
+			LastAccessedAt: now,
+			ImpersonatorID: actor.UserID,
+		},
+		User: &copied,
+	}
+
+	accessToken, err := s.signAccessToken(&Claims{
+		UserID:       target.ID,
+		TenantID:     target.TenantID,
+		SessionID:    session.ID,
+		Impersonator: actor,
+	}, session.ExpiresAt)
+	opts := events.EmitOptions{
+		Actor:     actor.UserID,
+		ActorType: string(actor.Role),
+		ActorIP:   ip,
+		Data: map[string]interface{}{
+			"actor_username": actor.Username,
+			"user_id":        target.ID,
+			"username":       target.Username,
+			"session_id":     session.ID,
+			"reason":         reason,
+			"expires_at":     session.ExpiresAt,
+		},
+	}
+	if err != nil {
+		s.events.EmitFailed(events.EventImpersonationStart, err.Error(), opts)
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.pruneSessionsLocked(now)
+	s.sessions[session.ID] = session
+	s.saveSessionsOrWarn()
+	s.mu.Unlock()
+	s.events.EmitSuccess(events.EventImpersonationStart, opts)
+
+	return &ImpersonationToken{
+		AccessToken:   accessToken,
+		TokenType:     "Bearer",
+		ExpiresIn:     int(ttl.Seconds()),
+		ExpiresAt:     session.ExpiresAt,
+		SessionID:     session.ID,
+		Impersonating: true,
+		Impersonator:  actor,
+		UserID:        target.ID,
+		Username:      target.Username,
+		Role:          string(target.Role),
+	}, nil
+}
+
+// EndImpersonation revokes an impersonation session before it expires
+func (s *Service) EndImpersonation(sessionID string, actor *Impersonator, actorIP string) error {
+	s.mu.RLock()
+	session, exists := s.sessions[sessionID]
+	var userID string
+	if exists && actor != nil && session.ImpersonatorID == actor.UserID {
+		userID = session.UserID
+	}
+	s.mu.RUnlock()
+
+	if userID == "" {
+		return ErrNotImpersonating
+	}
+	if err := s.RevokeSession(sessionID, userID, SessionRevokedEnded, actor.UserID, actorIP); err != nil {
+		return err
+	}
+	s.events.EmitSuccess(events.EventImpersonationEnd, events.EmitOptions{
+		Actor:     actor.UserID,
+		ActorType: string(actor.Role),
+		ActorIP:   actorIP,
+		Data: map[string]interface{}{
+			"actor_username": actor.Username,
+			"user_id":        userID,
+			"session_id":     sessionID,
+		},
+	})
+	return nil
+}
+
+// RecordImpersonatedAction records a request made with an impersonation
+// token, under both the impersonator and the user acted as
+func (s *Service) RecordImpersonatedAction(claims *Claims, method, path string, status int, ip, requestID string) {
+	if claims.Impersonator == nil {
+		return
+	}
+	opts := events.EmitOptions{
+		Actor:     claims.Impersonator.UserID,
+		ActorType: string(claims.Impersonator.Role),
+		ActorIP:   ip,
+		RequestID: requestID,
+		Data: map[string]interface{}{
+			"actor_username": claims.Impersonator.Username,
+			"user_id":        claims.UserID,
+			"session_id":     claims.SessionID,
+			"method":         method,
+			"path":           path,
+			"status":         status,
+		},
+	}
+	if status >= 400 {
+		s.events.EmitFailed(events.EventImpersonationAction, "request failed", opts)
+		return
+	}
+	s.events.EmitSuccess(events.EventImpersonationAction, opts)
+}
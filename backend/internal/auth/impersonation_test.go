@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/iSundram/OweHost/pkg/models"
+)
+
+func TestImpersonate(t *testing.T) {
+	s := newTestService(t, t.TempDir())
+	admin := &Impersonator{UserID: "usr-admin", Username: "admin", Role: models.UserRoleAdmin}
+	other := &Impersonator{UserID: "usr-other", Username: "other", Role: models.UserRoleAdmin}
+
+	// Fill the user's session limit; impersonating must not evict any
+	var logins []*models.LoginResponse
+	for i := 0; i < s.config.Auth.MaxConcurrentSessions; i++ {
+		login, err := s.GenerateTokens(testUser, "192.0.2.1", "test")
+		if err != nil {
+			t.Fatalf("Failed to log in: %v", err)
+		}
+		logins = append(logins, login)
+	}
+
+	token, err := s.Impersonate(admin, testUser, "ticket 42", "192.0.2.9", "test")
+	if err != nil {
+		t.Fatalf("Failed to impersonate: %v", err)
+	}
+	claims, err := s.ValidateToken(token.AccessToken)
+	if err != nil {
+		t.Fatalf("Failed to validate the impersonation token: %v", err)
+	}
+	if claims.UserID != testUser.ID || claims.Impersonator == nil || claims.Impersonator.UserID != admin.UserID {
+		t.Errorf("Expected a token acting as %s for %s, got %+v", testUser.ID, admin.UserID, claims)
+	}
+	var ownSession string
+	for i, login := range logins {
+		own, err := s.ValidateToken(login.AccessToken)
+		if err != nil {
+			t.Errorf("Session %d: expected the user's own sessions to be kept, got %v", i, err)
+			continue
+		}
+		ownSession = own.SessionID
+	}
+
+	tests := []struct {
+		name      string
+		sessionID string
+		actor     *Impersonator
+		want      error
+	}{
+		{"another admin", token.SessionID, other, ErrNotImpersonating},
+		{"no actor", token.SessionID, nil, ErrNotImpersonating},
+		{"the user's own session", ownSession, admin, ErrNotImpersonating},
+		{"unknown session", "sess-unknown", admin, ErrNotImpersonating},
+		{"the impersonator", token.SessionID, admin, nil},
+		{"ended twice", token.SessionID, admin, ErrSessionRevoked},
+	}
+	for _, tt := range tests {
+		if err := s.EndImpersonation(tt.sessionID, tt.actor, "192.0.2.9"); !errors.Is(err, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+	if _, err := s.ValidateToken(token.AccessToken); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("Expected the ended impersonation token to fail, got %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+// Package auth provides authentication services for OweHost
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/iSundram/OweHost/pkg/models"
+	"github.com/iSundram/OweHost/pkg/utils"
+)
+
+// An MFA ticket stands for a login whose password checked out but whose
+// second factor hasn't been given yet. It is short-lived and dies after a
+// few wrong codes, so it can't be used to guess codes at leisure.
+const (
+	mfaTicketTTL      = 5 * time.Minute
+	maxMFAAttempts    = 5
+	maxPendingTickets = 10000
+)
+
+// Logins a ticket can complete; a ticket from one can't finish the other
+const (
+	MFAAudiencePanel   = "panel"
+	MFAAudienceAccount = "account"
+)
+
+// ErrInvalidMFATicket is returned for an unknown, expired or used ticket
+var ErrInvalidMFATicket = errors.New("invalid or expired MFA ticket")
+
+// MFATicket is a login waiting for its second factor
+type MFATicket struct {
+	User      *models.User
+	Audience  string
+	Enroll    bool // 2FA is enforced but the user has yet to set it up
+	ExpiresAt time.Time
+	attempts  int
+}
+
+// IssueMFATicket starts the second step of a login
+func (s *Service) IssueMFATicket(user *models.User, audience string, enroll bool) (string, time.Time, error) {
+	token, err := utils.GenerateRefreshToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	now := time.Now()
+	ticket := &MFATicket{User: user, Audience: audience, Enroll: enroll, ExpiresAt: now.Add(mfaTicketTTL)}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, t := range s.mfaTickets {
+		if now.After(t.ExpiresAt) {
+			delete(s.mfaTickets, hash)
+		}
+	}
+	if len(s.mfaTickets) >= maxPendingTickets {
+		return "", time.Time{}, errors.New("too many pending logins")
+	}
+	s.mfaTickets[utils.HashAPIKey(token)] = ticket
+	return token, ticket.ExpiresAt, nil
+}
+
+// GetMFATicket returns a live ticket of audience without using it up
+func (s *Service) GetMFATicket(token, audience string) (*MFATicket, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ticket, exists := s.mfaTickets[utils.HashAPIKey(token)]
+	if !exists || ticket.Audience != audience || time.Now().After(ticket.ExpiresAt) {
+		return nil, ErrInvalidMFATicket
+	}
+	copied := *ticket
+	return &copied, nil
+}
+
+// FailMFATicket counts a wrong code against a ticket and reports whether
+// the ticket is still good
+func (s *Service) FailMFATicket(token string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash := utils.HashAPIKey(token)
+	ticket, exists := s.mfaTickets[hash]
+	if !exists {
+		return false
+	}
+	ticket.attempts++
+	if ticket.attempts >= maxMFAAttempts {
+		delete(s.mfaTickets, hash)
+		return false
+	}
+	return true
+}
+
+// RedeemMFATicket uses a ticket up. Only one of several requests racing
+// with the same ticket gets it.
+func (s *Service) RedeemMFATicket(token, audience string) (*MFATicket, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash := utils.HashAPIKey(token)
+	ticket, exists := s.mfaTickets[hash]
+	if !exists || ticket.Audience != audience || time.Now().After(ticket.ExpiresAt) {
+		return nil, ErrInvalidMFATicket
+	}
+	delete(s.mfaTickets, hash)
+	return ticket, nil
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iSundram/OweHost/pkg/utils"
+)
+
+func TestMFATicket_AttemptLimit(t *testing.T) {
+	s := newTestService(t, t.TempDir())
+
+	token, _, err := s.IssueMFATicket(testUser, MFAAudiencePanel, false)
+	if err != nil {
+		t.Fatalf("Failed to issue ticket: %v", err)
+	}
+
+	for i := 1; i < maxMFAAttempts; i++ {
+		if !s.FailMFATicket(token) {
+			t.Fatalf("Expected the ticket to survive wrong code %d", i)
+		}
+		if _, err := s.GetMFATicket(token, MFAAudiencePanel); err != nil {
+			t.Fatalf("Expected the ticket to be live after wrong code %d, got %v", i, err)
+		}
+	}
+	if s.FailMFATicket(token) {
+		t.Fatalf("Expected wrong code %d to use the ticket up", maxMFAAttempts)
+	}
+	if _, err := s.RedeemMFATicket(token, MFAAudiencePanel); !errors.Is(err, ErrInvalidMFATicket) {
+		t.Errorf("Expected a used-up ticket to be refused, got %v", err)
+	}
+	if s.FailMFATicket(token) {
+		t.Error("Expected an unknown ticket not to be good")
+	}
+}
+
+func TestMFATicket_Redeem(t *testing.T) {
+	s := newTestService(t, t.TempDir())
+
+	token, _, err := s.IssueMFATicket(testUser, MFAAudiencePanel, false)
+	if err != nil {
+		t.Fatalf("Failed to issue ticket: %v", err)
+	}
+	expired, _, err := s.IssueMFATicket(testUser, MFAAudiencePanel, false)
+	if err != nil {
+		t.Fatalf("Failed to issue ticket: %v", err)
+	}
+	s.mfaTickets[utils.HashAPIKey(expired)].ExpiresAt = time.Now().Add(-time.Second)
+
+	tests := []struct {
+		name     string
+		token    string
+		audience string
+	}{
+		{"other audience", token, MFAAudienceAccount},
+		{"expired", expired, MFAAudiencePanel},
+		{"unknown", "not-a-ticket", MFAAudiencePanel},
+	}
+	for _, tt := range tests {
+		if _, err := s.GetMFATicket(tt.token, tt.audience); !errors.Is(err, ErrInvalidMFATicket) {
+			t.Errorf("%s: expected GetMFATicket to refuse, got %v", tt.name, err)
+		}
+		if _, err := s.RedeemMFATicket(tt.token, tt.audience); !errors.Is(err, ErrInvalidMFATicket) {
+			t.Errorf("%s: expected RedeemMFATicket to refuse, got %v", tt.name, err)
+		}
+	}
+
+	// Of several requests racing with one ticket, only one gets it
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	redeemed := 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ticket, err := s.RedeemMFATicket(token, MFAAudiencePanel); err == nil && ticket.User.ID == testUser.ID {
+				mu.Lock()
+				redeemed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if redeemed != 1 {
+		t.Errorf("Expected the ticket to be redeemed once, got %d", redeemed)
+	}
+}
@@ -0,0 +1,498 @@
+// Package auth provides authentication services for OweHost
+package auth
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/iSundram/OweHost/pkg/config"
+	"github.com/iSundram/OweHost/pkg/models"
+	"github.com/iSundram/OweHost/pkg/utils"
+)
+
+// An SSO login must come back from the IdP within oidcLoginTTL. The IdP's
+// metadata and keys are cached; a token signed with a key not seen yet
+// refetches the keys, but no more often than jwksRefetchInterval.
+const (
+	oidcLoginTTL        = 10 * time.Minute
+	maxPendingOIDC      = 10000
+	oidcDiscoveryTTL    = time.Hour
+	jwksCacheTTL        = time.Hour
+	jwksRefetchInterval = time.Minute
+	oidcHTTPTimeout     = 10 * time.Second
+	maxOIDCResponseSize = 1 << 20
+)
+
+// SSO errors
+var (
+	ErrOIDCDisabled     = errors.New("single sign-on is not configured")
+	ErrInvalidOIDCState = errors.New("invalid or expired SSO login")
+	ErrOIDCToken        = errors.New("invalid ID token")
+	ErrOIDCNoRole       = errors.New("no panel role is granted to this identity")
+)
+
+// roleRank orders panel roles by privilege
+var roleRank = map[models.UserRole]int{
+	models.UserRoleUser:     1,
+	models.UserRoleReseller: 2,
+	models.UserRoleAdmin:    3,
+}
+
+// OIDCIdentity is who the IdP says logged in
+type OIDCIdentity struct {
+	Subject           string   `json:"sub"`
+	Email             string   `json:"email"`
+	EmailVerified     bool     `json:"email_verified"`
+	Name              string   `json:"name,omitempty"`
+	PreferredUsername string   `json:"preferred_username,omitempty"`
+	Groups            []string `json:"groups,omitempty"`
+}
+
+// OIDCProvider logs panel users in through an OpenID Connect IdP with the
+// authorization code flow and PKCE
+type OIDCProvider struct {
+	cfg           config.OIDCConfig
+	client        *http.Client
+	discovery     *oidcDiscovery
+	discoveredAt  time.Time
+	keys          map[string]interface{}
+	keysFetchedAt time.Time
+	pending       map[string]*oidcLogin
+	mu            sync.Mutex
+}
+
+// oidcDiscovery is the part of the IdP's metadata the login uses
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcLogin is a login sent off to the IdP, keyed by its state
+type oidcLogin struct {
+	verifier  string
+	nonce     string
+	expiresAt time.Time
+}
+
+// jwk is a key of the IdP's key set
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// NewOIDCProvider creates a provider for the configured IdP
+func NewOIDCProvider(cfg config.OIDCConfig) *OIDCProvider {
+	return &OIDCProvider{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: oidcHTTPTimeout},
+		keys:    make(map[string]interface{}),
+		pending: make(map[string]*oidcLogin),
+	}
+}
+
+// Enabled reports whether an IdP is configured
+func (p *OIDCProvider) Enabled() bool {
+	return p.cfg.Issuer != "" && p.cfg.ClientID != ""
+}
+
+// AdminSSOOnly reports whether admins must log in through the IdP
+func (p *OIDCProvider) AdminSSOOnly() bool {
+	return p.Enabled() && p.cfg.AdminSSOOnly
+}
+
+// AutoProvision reports whether unknown users are created at first login
+func (p *OIDCProvider) AutoProvision() bool {
+	return p.cfg.AutoProvision
+}
+
+// AuthURL starts a login and returns the IdP URL to send the browser to
+func (p *OIDCProvider) AuthURL(ctx context.Context) (string, error) {
+	if !p.Enabled() {
+		return "", ErrOIDCDisabled
+	}
+	d, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var secrets [3]string
+	for i := range secrets {
+		if secrets[i], err = utils.GenerateRefreshToken(); err != nil {
+			return "", err
+		}
+	}
+	state, nonce, verifier := secrets[0], secrets[1], secrets[2]
+	challenge := sha256.Sum256([]byte(verifier))
+
+	now := time.Now()
+	p.mu.Lock()
+	for s, l := range p.pending {
+		if now.After(l.expiresAt) {
+			delete(p.pending, s)
+		}
+	}
+	if len(p.pending) >= maxPendingOIDC {
+		p.mu.Unlock()
+		return "", errors.New("too many pending SSO logins")
+	}
+	p.pending[state] = &oidcLogin{verifier: verifier, nonce: nonce, expiresAt: now.Add(oidcLoginTTL)}
+	p.mu.Unlock()
+
+	u, err := url.Parse(d.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.cfg.ClientID)
+	q.Set("redirect_uri", p.cfg.RedirectURL)
+	q.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Exchange completes the login the IdP sent back with state and code, and
+// returns the identity its ID token vouches for
+func (p *OIDCProvider) Exchange(ctx context.Context, state, code string) (*OIDCIdentity, error) {
+	if !p.Enabled() {
+		return nil, ErrOIDCDisabled
+	}
+	p.mu.Lock()
+	login, exists := p.pending[state]
+	delete(p.pending, state)
+	p.mu.Unlock()
+	if !exists || time.Now().After(login.expiresAt) || code == "" {
+		return nil, ErrInvalidOIDCState
+	}
+
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"client_id":     {p.cfg.ClientID},
+		"code_verifier": {login.verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	var tokens struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	status, err := p.doJSON(req, &tokens)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK || tokens.IDToken == "" {
+		return nil, fmt.Errorf("token exchange failed: %s %s", tokens.Error, tokens.ErrorDescription)
+	}
+
+	return p.verifyIDToken(ctx, d, tokens.IDToken, login.nonce)
+}
+
+// Role maps an identity's groups to a panel role. A user in several
+// mapped groups gets the most privileged role.
+func (p *OIDCProvider) Role(identity *OIDCIdentity) (models.UserRole, error) {
+	var best models.UserRole
+	for _, group := range identity.Groups {
+		if role := models.UserRole(p.cfg.GroupRoles[group]); roleRank[role] > roleRank[best] {
+			best = role
+		}
+	}
+	if best == "" {
+		best = models.UserRole(p.cfg.DefaultRole)
+	}
+	if roleRank[best] == 0 {
+		return "", ErrOIDCNoRole
+	}
+	return best, nil
+}
+
+// AuthorizationRoles returns the names of the authorization roles an
+// identity's groups map to
+func (p *OIDCProvider) AuthorizationRoles(identity *OIDCIdentity) []string {
+	seen := make(map[string]bool)
+	names := make([]string, 0)
+	for _, group := range identity.Groups {
+		if name, ok := p.cfg.GroupAuthzRoles[group]; ok && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// verifyIDToken checks an ID token came from the IdP, for us, for this
+// login
+func (p *OIDCProvider) verifyIDToken(ctx context.Context, d *oidcDiscovery, raw, nonce string) (*OIDCIdentity, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, d, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "ES256", "ES384", "ES512", "EdDSA"}),
+		jwt.WithIssuer(d.Issuer),
+		jwt.WithAudience(p.cfg.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOIDCToken, err)
+	}
+
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrOIDCToken)
+	}
+	if azp, ok := claims["azp"].(string); ok && azp != p.cfg.ClientID {
+		return nil, fmt.Errorf("%w: issued to another client", ErrOIDCToken)
+	}
+
+	identity := &OIDCIdentity{}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.Name, _ = claims["name"].(string)
+	identity.PreferredUsername, _ = claims["preferred_username"].(string)
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = verified
+	case string:
+		identity.EmailVerified = verified == "true"
+	}
+	switch groups := claims[p.cfg.GroupsClaim].(type) {
+	case string:
+		identity.Groups = []string{groups}
+	case []interface{}:
+		for _, g := range groups {
+			if group, ok := g.(string); ok {
+				identity.Groups = append(identity.Groups, group)
+			}
+		}
+	}
+	if identity.Subject == "" {
+		return nil, fmt.Errorf("%w: no subject", ErrOIDCToken)
+	}
+	return identity, nil
+}
+
+// discover returns the IdP's metadata, fetching it when the cached copy is
+// missing or old
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	if p.discovery != nil && time.Since(p.discoveredAt) < oidcDiscoveryTTL {
+		d := *p.discovery
+		p.mu.Unlock()
+		return &d, nil
+	}
+	p.mu.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(p.cfg.Issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var d oidcDiscovery
+	status, err := p.doJSON(req, &d)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("OIDC discovery failed: status %d", status)
+	}
+	// The metadata must be the issuer's own
+	if d.Issuer != p.cfg.Issuer {
+		return nil, fmt.Errorf("OIDC discovery returned issuer %q, expected %q", d.Issuer, p.cfg.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, errors.New("OIDC discovery is missing endpoints")
+	}
+
+	p.mu.Lock()
+	p.discovery = &d
+	p.discoveredAt = time.Now()
+	p.mu.Unlock()
+	return &d, nil
+}
+
+// key returns the IdP's signing key kid. Keys are refetched when the cache
+// is old, or when kid is new to it, which is how the IdP rotates keys.
+func (p *OIDCProvider) key(ctx context.Context, d *oidcDiscovery, kid string) (interface{}, error) {
+	p.mu.Lock()
+	key, found := p.lookupKeyLocked(kid)
+	age := time.Since(p.keysFetchedAt)
+	p.mu.Unlock()
+
+	if found && age < jwksCacheTTL {
+		return key, nil
+	}
+	if !found && age < jwksRefetchInterval {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	keys, err := p.fetchKeys(ctx, d.JWKSURI)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+	if key, found = p.lookupKeyLocked(kid); !found {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// lookupKeyLocked finds a cached key. A token without a key ID can only
+// use a key set of one.
+func (p *OIDCProvider) lookupKeyLocked(kid string) (interface{}, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, found := p.keys[kid]
+	return key, found
+}
+
+// fetchKeys fetches the IdP's signing keys. Keys it can't use are skipped.
+func (p *OIDCProvider) fetchKeys(ctx context.Context, jwksURI string) (map[string]interface{}, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, jwksURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	status, err := p.doJSON(req, &set)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("fetching OIDC keys failed: status %d", status)
+	}
+
+	keys := make(map[string]interface{})
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if key, err := k.publicKey(); err == nil {
+			keys[k.Kid] = key
+		}
+	}
+	return keys, nil
+}
+
+// doJSON sends a request to the IdP and decodes its JSON answer, whatever
+// the status
+func (p *OIDCProvider) doJSON(req *http.Request, v interface{}) (int, error) {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxOIDCResponseSize))
+	if err != nil {
+		return 0, err
+	}
+	if err := json.Unmarshal(body, v); err != nil && resp.StatusCode == http.StatusOK {
+		return 0, err
+	}
+	return resp.StatusCode, nil
+}
+
+// publicKey decodes an RSA, EC or Ed25519 key
+func (k jwk) publicKey() (interface{}, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if key.N.BitLen() < 2048 || key.E < 3 {
+			return nil, errors.New("weak RSA key")
+		}
+		return key, nil
+	case "EC":
+		var curve elliptic.Curve
+		var check ecdh.Curve
+		switch k.Crv {
+		case "P-256":
+			curve, check = elliptic.P256(), ecdh.P256()
+		case "P-384":
+			curve, check = elliptic.P384(), ecdh.P384()
+		case "P-521":
+			curve, check = elliptic.P521(), ecdh.P521()
+		default:
+			return nil, errors.New("unsupported curve")
+		}
+		x, errX := decode(k.X)
+		y, errY := decode(k.Y)
+		size := (curve.Params().BitSize + 7) / 8
+		if errX != nil || errY != nil || len(x) != size || len(y) != size {
+			return nil, errors.New("invalid EC key")
+		}
+		if _, err := check.NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		x, err := decode(k.X)
+		if k.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid OKP key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, errors.New("unsupported key type")
+}
@@ -0,0 +1,292 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/iSundram/OweHost/pkg/config"
+	"github.com/iSundram/OweHost/pkg/models"
+)
+
+// mockIdP is a minimal OpenID Connect provider: discovery, a key set, and
+// a token endpoint that checks PKCE and signs ID tokens
+type mockIdP struct {
+	srv       *httptest.Server
+	clientID  string
+	mu        sync.Mutex
+	keys      map[string]*rsa.PrivateKey
+	published map[string]bool
+	signWith  string
+	codes     map[string]mockGrant
+	claims    jwt.MapClaims
+}
+
+type mockGrant struct {
+	nonce     string
+	challenge string
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+	idp := &mockIdP{
+		clientID:  "panel",
+		keys:      make(map[string]*rsa.PrivateKey),
+		published: make(map[string]bool),
+		codes:     make(map[string]mockGrant),
+	}
+	idp.addKey(t, "k1", true)
+	idp.signWith = "k1"
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.srv.URL,
+			"authorization_endpoint": idp.srv.URL + "/authorize",
+			"token_endpoint":         idp.srv.URL + "/token",
+			"jwks_uri":               idp.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+		keys := make([]map[string]string, 0)
+		for kid, key := range idp.keys {
+			if !idp.published[kid] {
+				continue
+			}
+			keys = append(keys, map[string]string{
+				"kty": "RSA",
+				"kid": kid,
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	})
+	mux.HandleFunc("/token", idp.token)
+	idp.srv = httptest.NewServer(mux)
+	t.Cleanup(idp.srv.Close)
+
+	idp.claims = jwt.MapClaims{
+		"iss":                idp.srv.URL,
+		"aud":                idp.clientID,
+		"sub":                "idp-user-1",
+		"email":              "jane@example.com",
+		"email_verified":     true,
+		"preferred_username": "jane",
+		"groups":             []string{"staff", "panel-admins"},
+	}
+	return idp
+}
+
+func (idp *mockIdP) addKey(t *testing.T, kid string, publish bool) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp.mu.Lock()
+	idp.keys[kid] = key
+	idp.published[kid] = publish
+	idp.mu.Unlock()
+}
+
+func (idp *mockIdP) token(w http.ResponseWriter, r *http.Request) {
+	id, secret, ok := r.BasicAuth()
+	if !ok || id != idp.clientID || secret != "s3cret" || r.FormValue("client_id") != idp.clientID {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	grant, exists := idp.codes[r.FormValue("code")]
+	delete(idp.codes, r.FormValue("code"))
+	verifier := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if !exists || base64.RawURLEncoding.EncodeToString(verifier[:]) != grant.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	claims := jwt.MapClaims{
+		"nonce": grant.nonce,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(5 * time.Minute).Unix(),
+	}
+	for k, v := range idp.claims {
+		claims[k] = v
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = idp.signWith
+	signed, err := token.SignedString(idp.keys[idp.signWith])
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": signed, "token_type": "Bearer"})
+}
+
+// login plays the browser: it follows the authorization URL, has the IdP
+// grant a code, and hands the code back to the provider
+func (idp *mockIdP) login(t *testing.T, p *OIDCProvider) (*OIDCIdentity, error) {
+	t.Helper()
+	authURL, err := p.AuthURL(context.Background())
+	if err != nil {
+		t.Fatalf("AuthURL: %v", err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("client_id") != idp.clientID {
+		t.Fatalf("unexpected authorization request %s", authURL)
+	}
+
+	code := "code-" + q.Get("state")[:8]
+	idp.mu.Lock()
+	idp.codes[code] = mockGrant{nonce: q.Get("nonce"), challenge: q.Get("code_challenge")}
+	idp.mu.Unlock()
+	return p.Exchange(context.Background(), q.Get("state"), code)
+}
+
+func (idp *mockIdP) provider() *OIDCProvider {
+	return NewOIDCProvider(config.OIDCConfig{
+		Issuer:          idp.srv.URL,
+		ClientID:        idp.clientID,
+		ClientSecret:    "s3cret",
+		RedirectURL:     "https://panel.example.com/sso/callback",
+		Scopes:          []string{"openid", "email", "profile"},
+		GroupsClaim:     "groups",
+		GroupRoles:      map[string]string{"staff": "user", "panel-admins": "admin"},
+		GroupAuthzRoles: map[string]string{"panel-admins": "admin"},
+	})
+}
+
+func TestOIDCProvider_Login(t *testing.T) {
+	idp := newMockIdP(t)
+	p := idp.provider()
+
+	identity, err := idp.login(t, p)
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	if identity.Subject != "idp-user-1" || identity.Email != "jane@example.com" || !identity.EmailVerified {
+		t.Errorf("unexpected identity %+v", identity)
+	}
+
+	role, err := p.Role(identity)
+	if err != nil || role != models.UserRoleAdmin {
+		t.Errorf("expected admin role, got %q (%v)", role, err)
+	}
+	if roles := p.AuthorizationRoles(identity); len(roles) != 1 || roles[0] != "admin" {
+		t.Errorf("expected admin authorization role, got %v", roles)
+	}
+
+	if _, err := p.Role(&OIDCIdentity{Subject: "x", Groups: []string{"guests"}}); !errors.Is(err, ErrOIDCNoRole) {
+		t.Errorf("expected ErrOIDCNoRole for unmapped groups, got %v", err)
+	}
+}
+
+func TestOIDCProvider_StateIsSingleUse(t *testing.T) {
+	idp := newMockIdP(t)
+	p := idp.provider()
+
+	authURL, _ := p.AuthURL(context.Background())
+	u, _ := url.Parse(authURL)
+	state := u.Query().Get("state")
+	idp.codes["one"] = mockGrant{nonce: u.Query().Get("nonce"), challenge: u.Query().Get("code_challenge")}
+
+	if _, err := p.Exchange(context.Background(), state, "one"); err != nil {
+		t.Fatalf("first exchange failed: %v", err)
+	}
+	if _, err := p.Exchange(context.Background(), state, "one"); !errors.Is(err, ErrInvalidOIDCState) {
+		t.Errorf("expected ErrInvalidOIDCState on replay, got %v", err)
+	}
+	if _, err := p.Exchange(context.Background(), "made-up", "one"); !errors.Is(err, ErrInvalidOIDCState) {
+		t.Errorf("expected ErrInvalidOIDCState for unknown state, got %v", err)
+	}
+}
+
+func TestOIDCProvider_PKCEMismatch(t *testing.T) {
+	idp := newMockIdP(t)
+	p := idp.provider()
+
+	authURL, _ := p.AuthURL(context.Background())
+	u, _ := url.Parse(authURL)
+	// A code granted to another login's challenge
+	idp.codes["stolen"] = mockGrant{nonce: u.Query().Get("nonce"), challenge: "not-this-verifier"}
+
+	if _, err := p.Exchange(context.Background(), u.Query().Get("state"), "stolen"); err == nil {
+		t.Error("expected the exchange to fail without the matching verifier")
+	}
+}
+
+func TestOIDCProvider_RejectsBadIDTokens(t *testing.T) {
+	tests := []struct {
+		name  string
+		tweak func(idp *mockIdP)
+	}{
+		{"wrong audience", func(idp *mockIdP) { idp.claims["aud"] = "someone-else" }},
+		{"wrong issuer", func(idp *mockIdP) { idp.claims["iss"] = "https://evil.example.com" }},
+		{"expired", func(idp *mockIdP) { idp.claims["exp"] = time.Now().Add(-time.Hour).Unix() }},
+		{"wrong nonce", func(idp *mockIdP) { idp.claims["nonce"] = "replayed" }},
+		{"other client's token", func(idp *mockIdP) {
+			idp.claims["aud"] = []string{idp.clientID, "other"}
+			idp.claims["azp"] = "other"
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp := newMockIdP(t)
+			tt.tweak(idp)
+			if _, err := idp.login(t, idp.provider()); !errors.Is(err, ErrOIDCToken) {
+				t.Errorf("expected ErrOIDCToken, got %v", err)
+			}
+		})
+	}
+}
+
+func TestOIDCProvider_KeyRotation(t *testing.T) {
+	idp := newMockIdP(t)
+	p := idp.provider()
+	if _, err := idp.login(t, p); err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+
+	// A key that was never published is refused
+	idp.addKey(t, "rogue", false)
+	idp.signWith = "rogue"
+	if _, err := idp.login(t, p); !errors.Is(err, ErrOIDCToken) {
+		t.Fatalf("expected ErrOIDCToken for an unpublished key, got %v", err)
+	}
+
+	// A new key is picked up once the keys may be refetched
+	idp.addKey(t, "k2", true)
+	idp.signWith = "k2"
+	if _, err := idp.login(t, p); !errors.Is(err, ErrOIDCToken) {
+		t.Fatalf("expected the refetch to be rate limited, got %v", err)
+	}
+	p.mu.Lock()
+	p.keysFetchedAt = p.keysFetchedAt.Add(-2 * jwksRefetchInterval)
+	p.mu.Unlock()
+	if _, err := idp.login(t, p); err != nil {
+		t.Fatalf("login with rotated key failed: %v", err)
+	}
+}
@@ -3,10 +3,12 @@ package auth
 
 import (
 	"errors"
+	"fmt"
 	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/iSundram/OweHost/internal/storage/events"
 	"github.com/iSundram/OweHost/pkg/config"
 	"github.com/iSundram/OweHost/pkg/models"
 	"github.com/iSundram/OweHost/pkg/utils"
@@ -14,69 +16,79 @@ import (
 
 // Service provides authentication functionality
 type Service struct {
-	config   *config.Config
-	users    map[string]*models.User
-	sessions map[string]*models.Session
-	apiKeys  map[string]*models.APIKey
-	mu       sync.RWMutex
+	config          *config.Config
+	users           map[string]*models.User
+	sessions        map[string]*storedSession
+	refreshTokens   map[string]string // refresh token hash -> session ID
+	sessionsPath    string
+	sessionsDue     bool          // a save of session uses is scheduled
+	signingKeys     []*signingKey // oldest first; the last one signs
+	signingKeysPath string
+	apiKeys         map[string]*models.APIKey
+	apiKeysPath     string
+	mfaTickets      map[string]*MFATicket
+	events          *events.Emitter
+	mu              sync.RWMutex
 }
 
 // Claims represents JWT claims
 type Claims struct {
-	UserID   string `json:"user_id"`
-	TenantID string `json:"tenant_id"`
+	UserID    string `json:"user_id"`
+	TenantID  string `json:"tenant_id"`
+	SessionID string `json:"sid"`
+	// Impersonator is who is really behind an impersonation token
+	Impersonator *Impersonator `json:"act,omitempty"`
 	jwt.RegisteredClaims
 }
 
 // NewService creates a new auth service
 func NewService(cfg *config.Config) *Service {
-	return &Service{
-		config:   cfg,
-		users:    make(map[string]*models.User),
-		sessions: make(map[string]*models.Session),
-		apiKeys:  make(map[string]*models.APIKey),
+	s := &Service{
+		config:          cfg,
+		users:           make(map[string]*models.User),
+		sessions:        make(map[string]*storedSession),
+		refreshTokens:   make(map[string]string),
+		sessionsPath:    sessionsPath,
+		signingKeysPath: signingKeysPath,
+		apiKeys:         make(map[string]*models.APIKey),
+		apiKeysPath:     apiKeysPath,
+		mfaTickets:      make(map[string]*MFATicket),
+		events:          events.NewEmitter(),
 	}
+	if err := s.loadAPIKeys(); err != nil {
+		fmt.Printf("warning: failed to load API keys: %v\n", err)
+	}
+	if err := s.loadSigningKeys(); err != nil {
+		fmt.Printf("warning: failed to load signing keys: %v\n", err)
+	}
+	if err := s.loadSessions(); err != nil {
+		fmt.Printf("warning: failed to load sessions: %v\n", err)
+	}
+	return s
 }
 
-// GenerateTokens generates access and refresh tokens for a user
-func (s *Service) GenerateTokens(user *models.User) (*models.LoginResponse, error) {
-	// Create access token
-	claims := &Claims{
-		UserID:   user.ID,
-		TenantID: user.TenantID,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(s.config.Auth.JWTExpiry)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			Issuer:    "owehost",
-		},
-	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	accessToken, err := token.SignedString([]byte(s.config.Auth.JWTSecret))
+// GenerateTokens starts a session for a user logging in from ip and
+// returns its tokens
+func (s *Service) GenerateTokens(user *models.User, ip, userAgent string) (*models.LoginResponse, error) {
+	session, refreshToken, err := s.startSession(user, ip, userAgent)
 	if err != nil {
 		return nil, err
 	}
+	return s.issueTokens(user, session.ID, refreshToken)
+}
 
-	// Generate refresh token
-	refreshToken, err := utils.GenerateRefreshToken()
+// issueTokens signs an access token for a session and pairs it with the
+// session's refresh token
+func (s *Service) issueTokens(user *models.User, sessionID, refreshToken string) (*models.LoginResponse, error) {
+	accessToken, err := s.signAccessToken(&Claims{
+		UserID:    user.ID,
+		TenantID:  user.TenantID,
+		SessionID: sessionID,
+	}, time.Now().Add(s.config.Auth.JWTExpiry))
 	if err != nil {
 		return nil, err
 	}
 
-	// Store session
-	session := &models.Session{
-		ID:             utils.GenerateID("sess"),
-		UserID:         user.ID,
-		RefreshToken:   refreshToken,
-		CreatedAt:      time.Now(),
-		ExpiresAt:      time.Now().Add(s.config.Auth.RefreshTokenExpiry),
-		LastAccessedAt: time.Now(),
-	}
-
-	s.mu.Lock()
-	s.sessions[refreshToken] = session
-	s.mu.Unlock()
-
 	return &models.LoginResponse{
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
@@ -85,76 +97,100 @@ func (s *Service) GenerateTokens(user *models.User) (*models.LoginResponse, erro
 	}, nil
 }
 
-// ValidateToken validates a JWT token and returns the claims
+// signAccessToken signs claims, valid until expiresAt, with the current
+// signing key
+func (s *Service) signAccessToken(claims *Claims, expiresAt time.Time) (string, error) {
+	key, err := s.currentSigningKey()
+	if err != nil {
+		return "", err
+	}
+
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		Issuer:    s.config.Auth.JWTIssuer,
+		Audience:  jwt.ClaimStrings{s.config.Auth.JWTAudience},
+	}
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.Algorithm), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.signer)
+}
+
+// ValidateToken validates a JWT token and returns the claims. The token
+// must be ours, meant for us, and signed with a key still in the key set;
+// its session must still be live, so revoking a session cuts its access
+// token off too.
 func (s *Service) ValidateToken(tokenString string) (*Claims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("unexpected signing method")
-		}
-		return []byte(s.config.Auth.JWTSecret), nil
-	})
+		kid, _ := token.Header["kid"].(string)
+		return s.verificationKey(kid, token.Method.Alg())
+	},
+		jwt.WithValidMethods([]string{SigningAlgorithmES256, SigningAlgorithmEdDSA}),
+		jwt.WithIssuer(s.config.Auth.JWTIssuer),
+		jwt.WithAudience(s.config.Auth.JWTAudience),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(tokenLeeway),
+	)
 
 	if err != nil {
 		return nil, err
 	}
 
-	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
-		return claims, nil
+	claims, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token")
 	}
-
-	return nil, errors.New("invalid token")
-}
-
-// RefreshTokens refreshes tokens using a refresh token
-func (s *Service) RefreshTokens(refreshToken string) (*models.LoginResponse, error) {
-	s.mu.RLock()
-	session, exists := s.sessions[refreshToken]
-	s.mu.RUnlock()
-
-	if !exists {
-		return nil, errors.New("invalid refresh token")
+	if err := s.checkSession(claims.SessionID); err != nil {
+		return nil, err
 	}
+	return claims, nil
+}
 
-	if time.Now().After(session.ExpiresAt) {
-		s.mu.Lock()
-		delete(s.sessions, refreshToken)
-		s.mu.Unlock()
-		return nil, errors.New("refresh token expired")
+// RefreshTokens refreshes tokens using a refresh token. The refresh token
+// is used up; the response carries its replacement.
+func (s *Service) RefreshTokens(refreshToken, ip, userAgent string) (*models.LoginResponse, error) {
+	user, sessionID, newToken, err := s.rotateSession(refreshToken, ip, userAgent)
+	if err != nil {
+		return nil, err
 	}
+	return s.issueTokens(user, sessionID, newToken)
+}
 
-	// Get user
+// InvalidateSession ends the session a refresh token belongs to
+func (s *Service) InvalidateSession(refreshToken string) error {
+	hash := utils.HashAPIKey(refreshToken)
 	s.mu.RLock()
-	user, exists := s.users[session.UserID]
+	session := s.sessions[s.refreshTokens[hash]]
+	current := session != nil && session.RefreshHash == hash
+	var id, userID string
+	if current {
+		id, userID = session.ID, session.UserID
+	}
 	s.mu.RUnlock()
 
-	if !exists {
-		return nil, errors.New("user not found")
+	if !current {
+		return ErrSessionNotFound
 	}
-
-	// Invalidate old refresh token
-	s.mu.Lock()
-	delete(s.sessions, refreshToken)
-	s.mu.Unlock()
-
-	// Generate new tokens
-	return s.GenerateTokens(user)
+	return s.RevokeSession(id, userID, SessionRevokedLogout, userID, "")
 }
 
-// InvalidateSession invalidates a session
-func (s *Service) InvalidateSession(refreshToken string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	if _, exists := s.sessions[refreshToken]; !exists {
-		return errors.New("session not found")
+// CreateAPIKey creates a new API key. The key is returned once; only its
+// hash is kept.
+func (s *Service) CreateAPIKey(userID, name string, scopes []string, expiresAt *time.Time, ipBindings []string, actorIP string) (*models.APIKey, string, error) {
+	if len(scopes) == 0 {
+		return nil, "", fmt.Errorf("%w: at least one scope is required", ErrInvalidScope)
+	}
+	for _, scope := range scopes {
+		if err := ValidateScope(scope); err != nil {
+			return nil, "", err
+		}
+	}
+	for _, binding := range ipBindings {
+		if _, err := parseIPBinding(binding); err != nil {
+			return nil, "", err
+		}
 	}
 
-	delete(s.sessions, refreshToken)
-	return nil
-}
-
-// CreateAPIKey creates a new API key
-func (s *Service) CreateAPIKey(userID, name string, scopes []string, expiresAt *time.Time, ipBindings []string) (*models.APIKey, string, error) {
 	key, hash := utils.GenerateAPIKey(s.config.Auth.APIKeyPrefix)
 
 	apiKey := &models.APIKey{
@@ -171,25 +207,48 @@ func (s *Service) CreateAPIKey(userID, name string, scopes []string, expiresAt *
 
 	s.mu.Lock()
 	s.apiKeys[hash] = apiKey
+	err := s.saveAPIKeysLocked()
+	if err != nil {
+		delete(s.apiKeys, hash)
+	}
 	s.mu.Unlock()
 
-	return apiKey, key, nil
+	opts := events.EmitOptions{Actor: userID, ActorType: "user", ActorIP: actorIP, Data: apiKeyEventData(apiKey)}
+	if err != nil {
+		s.events.EmitFailed(events.EventAPIKeyCreate, err.Error(), opts)
+		return nil, "", err
+	}
+	s.events.EmitSuccess(events.EventAPIKeyCreate, opts)
+
+	return copyAPIKey(apiKey), key, nil
 }
 
 // ValidateAPIKey validates an API key
 func (s *Service) ValidateAPIKey(key string) (*models.APIKey, error) {
-	hash := utils.HashAPIKey(key)
-
 	s.mu.RLock()
-	apiKey, exists := s.apiKeys[hash]
-	s.mu.RUnlock()
+	defer s.mu.RUnlock()
 
+	apiKey, err := s.validAPIKeyLocked(key)
+	if err != nil {
+		return nil, err
+	}
+	return copyAPIKey(apiKey), nil
+}
+
+// validAPIKeyLocked looks up a key that is neither revoked nor expired.
+// Callers hold s.mu.
+func (s *Service) validAPIKeyLocked(key string) (*models.APIKey, error) {
+	apiKey, exists := s.apiKeys[utils.HashAPIKey(key)]
 	if !exists {
 		return nil, errors.New("invalid API key")
 	}
 
+	if apiKey.RevokedAt != nil {
+		return nil, ErrAPIKeyRevoked
+	}
+
 	if apiKey.ExpiresAt != nil && time.Now().After(*apiKey.ExpiresAt) {
-		return nil, errors.New("API key expired")
+		return nil, ErrAPIKeyExpired
 	}
 
 	return apiKey, nil
@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/iSundram/OweHost/internal/storage/events"
+	"github.com/iSundram/OweHost/pkg/config"
+	"github.com/iSundram/OweHost/pkg/models"
+)
+
+// newTestService returns a service that keeps its state in dir
+func newTestService(t *testing.T, dir string) *Service {
+	t.Helper()
+	s := &Service{
+		config: &config.Config{Auth: config.AuthConfig{
+			JWTAlgorithm:          SigningAlgorithmES256,
+			JWTKeyRotation:        24 * time.Hour,
+			JWTIssuer:             "owehost",
+			JWTAudience:           "owehost-api",
+			JWTExpiry:             15 * time.Minute,
+			RefreshTokenExpiry:    7 * 24 * time.Hour,
+			MaxConcurrentSessions: 3,
+			ImpersonationTTL:      time.Hour,
+		}},
+		users:           make(map[string]*models.User),
+		sessions:        make(map[string]*storedSession),
+		refreshTokens:   make(map[string]string),
+		sessionsPath:    filepath.Join(dir, "sessions.json"),
+		signingKeysPath: filepath.Join(dir, "signing_keys.json"),
+		apiKeys:         make(map[string]*models.APIKey),
+		apiKeysPath:     filepath.Join(dir, "api_keys.json"),
+		mfaTickets:      make(map[string]*MFATicket),
+		events:          events.NewEmitterWithStore(events.NewStoreWithPath(filepath.Join(dir, "events"), filepath.Join(dir, "alerts")), "test"),
+	}
+	if err := s.loadSigningKeys(); err != nil {
+		t.Fatalf("Failed to load signing keys: %v", err)
+	}
+	if err := s.loadSessions(); err != nil {
+		t.Fatalf("Failed to load sessions: %v", err)
+	}
+	return s
+}
+
+var testUser = &models.User{ID: "usr-alice", Username: "alice", Role: models.UserRoleUser}
@@ -0,0 +1,392 @@
+// Package auth provides authentication services for OweHost
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/iSundram/OweHost/internal/storage/events"
+	"github.com/iSundram/OweHost/pkg/models"
+	"github.com/iSundram/OweHost/pkg/utils"
+)
+
+// sessionsPath holds the sessions, so a restart doesn't log everyone out.
+// Each refresh swaps a session's refresh token for a new one; the tokens
+// swapped out are remembered, so one that turns up again gives a stolen
+// token away.
+const (
+	sessionsPath     = "/var/lib/owehost/auth/sessions.json"
+	maxRotatedTokens = 64
+)
+
+// Reasons a session ends before it expires
+const (
+	SessionRevokedLogout = "logout"
+	SessionRevokedByUser = "revoked_by_user"
+	SessionRevokedAdmin  = "revoked_by_admin"
+	SessionRevokedLimit  = "session_limit"
+	SessionRevokedReuse  = "refresh_token_reuse"
+	SessionRevokedEnded  = "impersonation_ended"
+)
+
+// Session errors
+var (
+	ErrSessionNotFound     = errors.New("session not found")
+	ErrSessionRevoked      = errors.New("session revoked")
+	ErrSessionExpired      = errors.New("session expired")
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token already used; the session has been revoked")
+)
+
+// storedSession is a session with what it takes to refresh it: the hash
+// of its refresh token, the hashes of the tokens it replaced, and the user
+// it was issued to
+type storedSession struct {
+	*models.Session
+	RefreshHash   string       `json:"refresh_hash"`
+	RotatedHashes []string     `json:"rotated_hashes,omitempty"`
+	User          *models.User `json:"user"`
+}
+
+func (s *storedSession) active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+// startSession opens a session for a user logging in. A user already at
+// the session limit loses their least recently used session.
+func (s *Service) startSession(user *models.User, ip, userAgent string) (*storedSession, string, error) {
+	refreshToken, err := utils.GenerateRefreshToken()
+	if err != nil {
+		return nil, "", err
+	}
+	now := time.Now()
+	copied := *user
+	session := &storedSession{
+		Session: &models.Session{
+			ID:             utils.GenerateID("sess"),
+			UserID:         user.ID,
+			IPAddress:      ip,
+			UserAgent:      userAgent,
+			CreatedAt:      now,
+			ExpiresAt:      now.Add(s.config.Auth.RefreshTokenExpiry),
+			LastAccessedAt: now,
+		},
+		RefreshHash: utils.HashAPIKey(refreshToken),
+		User:        &copied,
+	}
+
+	s.mu.Lock()
+	s.pruneSessionsLocked(now)
+	var evicted []models.Session
+	if limit := s.config.Auth.MaxConcurrentSessions; limit > 0 {
+		// Someone acting as the user doesn't use up their sessions
+		active := make([]*storedSession, 0)
+		for _, session := range s.activeSessionsLocked(user.ID, now) {
+			if session.ImpersonatorID == "" {
+				active = append(active, session)
+			}
+		}
+		sort.Slice(active, func(i, j int) bool { return active[i].LastAccessedAt.Before(active[j].LastAccessedAt) })
+		for len(active) >= limit {
+			s.revokeLocked(active[0], SessionRevokedLimit, now)
+			evicted = append(evicted, *active[0].Session)
+			active = active[1:]
+		}
+	}
+	s.sessions[session.ID] = session
+	s.refreshTokens[session.RefreshHash] = session.ID
+	s.saveSessionsOrWarn()
+	s.mu.Unlock()
+
+	for i := range evicted {
+		s.emitSessionRevoke(&evicted[i], SessionRevokedLimit, "system", "")
+	}
+	return session, refreshToken, nil
+}
+
+// rotateSession swaps a refresh token for a new one. A token that was
+// already swapped out revokes its session: either it or its replacement
+// is in the wrong hands.
+func (s *Service) rotateSession(refreshToken, ip, userAgent string) (*models.User, string, string, error) {
+	newToken, err := utils.GenerateRefreshToken()
+	if err != nil {
+		return nil, "", "", err
+	}
+	hash := utils.HashAPIKey(refreshToken)
+	now := time.Now()
+
+	s.mu.Lock()
+	session := s.sessions[s.refreshTokens[hash]]
+	switch {
+	case session == nil:
+		s.mu.Unlock()
+		return nil, "", "", ErrInvalidRefreshToken
+	case session.RevokedAt != nil:
+		s.mu.Unlock()
+		return nil, "", "", ErrSessionRevoked
+	case !now.Before(session.ExpiresAt):
+		s.mu.Unlock()
+		return nil, "", "", ErrSessionExpired
+	case session.RefreshHash != hash:
+		s.revokeLocked(session, SessionRevokedReuse, now)
+		s.saveSessionsOrWarn()
+		revoked := *session.Session
+		s.mu.Unlock()
+		s.emitSessionRevoke(&revoked, SessionRevokedReuse, "system", ip)
+		return nil, "", "", ErrRefreshTokenReused
+	}
+
+	session.RotatedHashes = append(session.RotatedHashes, hash)
+	if len(session.RotatedHashes) > maxRotatedTokens {
+		delete(s.refreshTokens, session.RotatedHashes[0])
+		session.RotatedHashes = session.RotatedHashes[1:]
+	}
+	session.RefreshHash = utils.HashAPIKey(newToken)
+	s.refreshTokens[session.RefreshHash] = session.ID
+	session.ExpiresAt = now.Add(s.config.Auth.RefreshTokenExpiry)
+	session.LastAccessedAt = now
+	session.IPAddress = ip
+	if userAgent != "" {
+		session.UserAgent = userAgent
+	}
+	// The user may have changed since login
+	if user, exists := s.users[session.UserID]; exists {
+		copied := *user
+		session.User = &copied
+	}
+	s.saveSessionsOrWarn()
+	user := *session.User
+	s.mu.Unlock()
+
+	return &user, session.ID, newToken, nil
+}
+
+// checkSession reports why the session of an access token is no longer
+// good, if it isn't
+func (s *Service) checkSession(id string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, exists := s.sessions[id]
+	switch {
+	case !exists:
+		return ErrSessionNotFound
+	case session.RevokedAt != nil:
+		return ErrSessionRevoked
+	case !time.Now().Before(session.ExpiresAt):
+		return ErrSessionExpired
+	}
+	return nil
+}
+
+// TouchSession notes that a session was just used, and from where. Uses
+// are kept in memory and saved in the background, so the saved time may
+// lag by up to lastUsedInterval; requests never wait on the disk.
+func (s *Service) TouchSession(id, ip string) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, exists := s.sessions[id]
+	if !exists || !session.active(now) {
+		return
+	}
+	session.LastAccessedAt = now
+	if ip != "" {
+		session.IPAddress = ip
+	}
+	s.scheduleSessionsSaveLocked()
+}
+
+// scheduleSessionsSaveLocked saves the sessions lastUsedInterval from now,
+// unless a save is already scheduled. Callers hold s.mu.
+func (s *Service) scheduleSessionsSaveLocked() {
+	if s.sessionsDue {
+		return
+	}
+	s.sessionsDue = true
+	time.AfterFunc(lastUsedInterval, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.sessionsDue = false
+		s.saveSessionsOrWarn()
+	})
+}
+
+// ListSessions returns a user's live sessions, most recently used first
+func (s *Service) ListSessions(userID string) []*models.Session {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	active := s.activeSessionsLocked(userID, time.Now())
+	sort.Slice(active, func(i, j int) bool { return active[i].LastAccessedAt.After(active[j].LastAccessedAt) })
+	sessions := make([]*models.Session, 0, len(active))
+	for _, session := range active {
+		copied := *session.Session
+		sessions = append(sessions, &copied)
+	}
+	return sessions
+}
+
+// RevokeSession ends a session. ownerID limits it to that user's
+// sessions; empty means anyone's.
+func (s *Service) RevokeSession(id, ownerID, reason, actor, actorIP string) error {
+	now := time.Now()
+	s.mu.Lock()
+	session, exists := s.sessions[id]
+	if !exists || (ownerID != "" && session.UserID != ownerID) || !now.Before(session.ExpiresAt) {
+		s.mu.Unlock()
+		return ErrSessionNotFound
+	}
+	if session.RevokedAt != nil {
+		s.mu.Unlock()
+		return ErrSessionRevoked
+	}
+	s.revokeLocked(session, reason, now)
+	s.saveSessionsOrWarn()
+	revoked := *session.Session
+	s.mu.Unlock()
+
+	s.emitSessionRevoke(&revoked, reason, actor, actorIP)
+	return nil
+}
+
+// RevokeUserSessions ends every live session of a user but exceptID, and
+// returns how many it ended
+func (s *Service) RevokeUserSessions(userID, exceptID, reason, actor, actorIP string) int {
+	now := time.Now()
+	s.mu.Lock()
+	var revoked []models.Session
+	for _, session := range s.activeSessionsLocked(userID, now) {
+		if session.ID != exceptID {
+			s.revokeLocked(session, reason, now)
+			revoked = append(revoked, *session.Session)
+		}
+	}
+	if len(revoked) > 0 {
+		s.saveSessionsOrWarn()
+	}
+	s.mu.Unlock()
+
+	for i := range revoked {
+		s.emitSessionRevoke(&revoked[i], reason, actor, actorIP)
+	}
+	return len(revoked)
+}
+
+func (s *Service) activeSessionsLocked(userID string, now time.Time) []*storedSession {
+	active := make([]*storedSession, 0)
+	for _, session := range s.sessions {
+		if session.UserID == userID && session.active(now) {
+			active = append(active, session)
+		}
+	}
+	return active
+}
+
+// revokeLocked ends a session. It is kept until it would have expired, so
+// its refresh tokens are still recognised.
+func (s *Service) revokeLocked(session *storedSession, reason string, now time.Time) {
+	session.RevokedAt = &now
+	session.RevokeReason = reason
+}
+
+// pruneSessionsLocked forgets expired sessions and their tokens
+func (s *Service) pruneSessionsLocked(now time.Time) {
+	for id, session := range s.sessions {
+		if now.Before(session.ExpiresAt) {
+			continue
+		}
+		delete(s.refreshTokens, session.RefreshHash)
+		for _, hash := range session.RotatedHashes {
+			delete(s.refreshTokens, hash)
+		}
+		delete(s.sessions, id)
+	}
+}
+
+func (s *Service) emitSessionRevoke(session *models.Session, reason, actor, actorIP string) {
+	actorType := "user"
+	if actor == "system" {
+		actorType = "system"
+	}
+	s.events.EmitSuccess(events.EventSessionRevoke, events.EmitOptions{
+		Actor:     actor,
+		ActorType: actorType,
+		ActorIP:   actorIP,
+		Data: map[string]interface{}{
+			"session_id": session.ID,
+			"user_id":    session.UserID,
+			"reason":     reason,
+			"ip_address": session.IPAddress,
+			"user_agent": session.UserAgent,
+		},
+	})
+}
+
+// loadSessions reads the saved sessions. Having none saved yet is not an
+// error.
+func (s *Service) loadSessions() error {
+	data, err := os.ReadFile(s.sessionsPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var stored []*storedSession
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, session := range stored {
+		if session.Session == nil || session.User == nil || !now.Before(session.ExpiresAt) {
+			continue
+		}
+		s.sessions[session.ID] = session
+		if session.RefreshHash != "" {
+			s.refreshTokens[session.RefreshHash] = session.ID
+		}
+		for _, hash := range session.RotatedHashes {
+			s.refreshTokens[hash] = session.ID
+		}
+	}
+	return nil
+}
+
+// saveSessionsLocked writes every session out; s.mu must be held. Refresh
+// token hashes are in it, so only root may read it.
+func (s *Service) saveSessionsLocked() error {
+	stored := make([]*storedSession, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		stored = append(stored, session)
+	}
+	sort.Slice(stored, func(i, j int) bool { return stored[i].CreatedAt.Before(stored[j].CreatedAt) })
+
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.sessionsPath), 0700); err != nil {
+		return err
+	}
+	tmp := s.sessionsPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.sessionsPath)
+}
+
+// saveSessionsOrWarn saves where the session has already changed; failing
+// to save only means a restart forgets the change
+func (s *Service) saveSessionsOrWarn() {
+	if err := s.saveSessionsLocked(); err != nil {
+		fmt.Printf("warning: failed to save sessions: %v\n", err)
+	}
+}
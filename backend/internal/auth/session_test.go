@@ -0,0 +1,225 @@
+package auth
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/iSundram/OweHost/pkg/models"
+	"github.com/iSundram/OweHost/pkg/utils"
+)
+
+func TestRefreshTokens_RotateAndReuse(t *testing.T) {
+	s := newTestService(t, t.TempDir())
+
+	login, err := s.GenerateTokens(testUser, "192.0.2.1", "test")
+	if err != nil {
+		t.Fatalf("Failed to log in: %v", err)
+	}
+	first, err := s.RefreshTokens(login.RefreshToken, "192.0.2.1", "test")
+	if err != nil {
+		t.Fatalf("Failed to refresh: %v", err)
+	}
+	if first.RefreshToken == login.RefreshToken {
+		t.Fatal("Expected the refresh token to be replaced")
+	}
+	second, err := s.RefreshTokens(first.RefreshToken, "192.0.2.1", "test")
+	if err != nil {
+		t.Fatalf("Failed to refresh again: %v", err)
+	}
+
+	// The login token was already swapped out: someone else has a copy
+	if _, err := s.RefreshTokens(login.RefreshToken, "203.0.113.9", "stolen"); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("Expected ErrRefreshTokenReused, got %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"latest token", second.RefreshToken, ErrSessionRevoked},
+		{"reused token again", login.RefreshToken, ErrSessionRevoked},
+		{"unknown token", "not-a-token", ErrInvalidRefreshToken},
+	}
+	for _, tt := range tests {
+		if _, err := s.RefreshTokens(tt.token, "192.0.2.1", "test"); !errors.Is(err, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+	if _, err := s.ValidateToken(second.AccessToken); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("Expected the access token of a revoked session to fail, got %v", err)
+	}
+}
+
+func TestRefreshTokens_ReuseDetectedAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestService(t, dir)
+
+	login, err := s.GenerateTokens(testUser, "192.0.2.1", "test")
+	if err != nil {
+		t.Fatalf("Failed to log in: %v", err)
+	}
+	refreshed, err := s.RefreshTokens(login.RefreshToken, "192.0.2.1", "test")
+	if err != nil {
+		t.Fatalf("Failed to refresh: %v", err)
+	}
+
+	restarted := newTestService(t, dir)
+	if _, err := restarted.ValidateToken(refreshed.AccessToken); err != nil {
+		t.Errorf("Expected access tokens to survive a restart, got %v", err)
+	}
+	if _, err := restarted.RefreshTokens(login.RefreshToken, "192.0.2.1", "test"); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Errorf("Expected ErrRefreshTokenReused after a restart, got %v", err)
+	}
+}
+
+func TestRefreshTokens_Expired(t *testing.T) {
+	s := newTestService(t, t.TempDir())
+
+	login, err := s.GenerateTokens(testUser, "192.0.2.1", "test")
+	if err != nil {
+		t.Fatalf("Failed to log in: %v", err)
+	}
+	for _, session := range s.sessions {
+		session.ExpiresAt = time.Now().Add(-time.Second)
+	}
+	if _, err := s.RefreshTokens(login.RefreshToken, "192.0.2.1", "test"); !errors.Is(err, ErrSessionExpired) {
+		t.Errorf("Expected ErrSessionExpired, got %v", err)
+	}
+}
+
+func TestInvalidateSession_OnlyCurrentToken(t *testing.T) {
+	s := newTestService(t, t.TempDir())
+
+	login, err := s.GenerateTokens(testUser, "192.0.2.1", "test")
+	if err != nil {
+		t.Fatalf("Failed to log in: %v", err)
+	}
+	refreshed, err := s.RefreshTokens(login.RefreshToken, "192.0.2.1", "test")
+	if err != nil {
+		t.Fatalf("Failed to refresh: %v", err)
+	}
+
+	if err := s.InvalidateSession(login.RefreshToken); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected a used-up token not to log out, got %v", err)
+	}
+	if err := s.InvalidateSession(refreshed.RefreshToken); err != nil {
+		t.Fatalf("Failed to log out: %v", err)
+	}
+	if _, err := s.RefreshTokens(refreshed.RefreshToken, "192.0.2.1", "test"); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("Expected ErrSessionRevoked after logout, got %v", err)
+	}
+}
+
+func TestStartSession_EvictsLeastRecentlyUsed(t *testing.T) {
+	s := newTestService(t, t.TempDir())
+
+	var logins []*models.LoginResponse
+	for i := 0; i < 3; i++ {
+		login, err := s.GenerateTokens(testUser, "192.0.2.1", "test")
+		if err != nil {
+			t.Fatalf("Failed to log in: %v", err)
+		}
+		logins = append(logins, login)
+		time.Sleep(time.Millisecond)
+	}
+
+	// Using the oldest session leaves the second least recently used
+	s.mu.RLock()
+	sessionID := s.refreshTokens[utils.HashAPIKey(logins[0].RefreshToken)]
+	s.mu.RUnlock()
+	s.TouchSession(sessionID, "192.0.2.1")
+
+	login, err := s.GenerateTokens(testUser, "192.0.2.1", "test")
+	if err != nil {
+		t.Fatalf("Failed to log in: %v", err)
+	}
+	logins = append(logins, login)
+
+	if active := s.ListSessions(testUser.ID); len(active) != 3 {
+		t.Errorf("Expected 3 active sessions, got %d", len(active))
+	}
+	for i, want := range []error{nil, ErrSessionRevoked, nil, nil} {
+		if _, err := s.ValidateToken(logins[i].AccessToken); !errors.Is(err, want) {
+			t.Errorf("Session %d: expected %v, got %v", i, want, err)
+		}
+	}
+}
+
+func TestTouchSession_SavesInBackground(t *testing.T) {
+	s := newTestService(t, t.TempDir())
+
+	login, err := s.GenerateTokens(testUser, "192.0.2.1", "test")
+	if err != nil {
+		t.Fatalf("Failed to log in: %v", err)
+	}
+	claims, err := s.ValidateToken(login.AccessToken)
+	if err != nil {
+		t.Fatalf("Failed to validate token: %v", err)
+	}
+	saved, err := os.ReadFile(s.sessionsPath)
+	if err != nil {
+		t.Fatalf("Expected the login to be saved: %v", err)
+	}
+
+	s.TouchSession(claims.SessionID, "198.51.100.4")
+	s.TouchSession(claims.SessionID, "198.51.100.4")
+
+	if data, _ := os.ReadFile(s.sessionsPath); !bytes.Equal(data, saved) {
+		t.Error("Expected session uses not to be written on every request")
+	}
+	s.mu.RLock()
+	due := s.sessionsDue
+	s.mu.RUnlock()
+	if !due {
+		t.Error("Expected a background save to be scheduled")
+	}
+
+	sessions := s.ListSessions(testUser.ID)
+	if len(sessions) != 1 || sessions[0].IPAddress != "198.51.100.4" {
+		t.Errorf("Expected the session's last address to be updated, got %+v", sessions)
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	s := newTestService(t, t.TempDir())
+	bob := &models.User{ID: "usr-bob", Username: "bob", Role: models.UserRoleUser}
+
+	var aliceSessions []string
+	for i := 0; i < 3; i++ {
+		login, err := s.GenerateTokens(testUser, "192.0.2.1", "test")
+		if err != nil {
+			t.Fatalf("Failed to log in: %v", err)
+		}
+		claims, _ := s.ValidateToken(login.AccessToken)
+		aliceSessions = append(aliceSessions, claims.SessionID)
+	}
+	bobLogin, err := s.GenerateTokens(bob, "192.0.2.2", "test")
+	if err != nil {
+		t.Fatalf("Failed to log in: %v", err)
+	}
+
+	if err := s.RevokeSession(aliceSessions[0], bob.ID, SessionRevokedByUser, bob.ID, ""); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected another user's session to be out of reach, got %v", err)
+	}
+	if err := s.RevokeSession(aliceSessions[0], testUser.ID, SessionRevokedByUser, testUser.ID, ""); err != nil {
+		t.Fatalf("Failed to revoke: %v", err)
+	}
+	if err := s.RevokeSession(aliceSessions[0], testUser.ID, SessionRevokedByUser, testUser.ID, ""); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("Expected ErrSessionRevoked revoking twice, got %v", err)
+	}
+
+	// Logging out everywhere else keeps the caller's own session
+	if n := s.RevokeUserSessions(testUser.ID, aliceSessions[1], SessionRevokedByUser, testUser.ID, ""); n != 1 {
+		t.Errorf("Expected 1 more session revoked, got %d", n)
+	}
+	if active := s.ListSessions(testUser.ID); len(active) != 1 || active[0].ID != aliceSessions[1] {
+		t.Errorf("Expected only session %s left, got %+v", aliceSessions[1], active)
+	}
+	if _, err := s.ValidateToken(bobLogin.AccessToken); err != nil {
+		t.Errorf("Expected other users' sessions to be untouched, got %v", err)
+	}
+}
@@ -0,0 +1,293 @@
+// Package auth provides authentication services for OweHost
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/iSundram/OweHost/internal/storage/events"
+	"github.com/iSundram/OweHost/pkg/utils"
+)
+
+// signingKeysPath holds the keys access tokens are signed with. The newest
+// key signs; a key it replaced is kept until the last token signed with it
+// has expired, so rotating logs nobody out.
+const (
+	signingKeysPath = "/var/lib/owehost/auth/signing_keys.json"
+	tokenLeeway     = time.Minute
+)
+
+// Signing algorithms
+const (
+	SigningAlgorithmES256 = "ES256"
+	SigningAlgorithmEdDSA = "EdDSA"
+)
+
+// Signing key errors
+var (
+	ErrUnknownSigningKey           = errors.New("unknown signing key")
+	ErrUnsupportedSigningAlgorithm = errors.New("unsupported signing algorithm")
+)
+
+// signingKey is a key access tokens are signed with
+type signingKey struct {
+	ID         string     `json:"kid"`
+	Algorithm  string     `json:"alg"`
+	PrivateKey []byte     `json:"private_key"` // PKCS #8
+	CreatedAt  time.Time  `json:"created_at"`
+	RetiredAt  *time.Time `json:"retired_at,omitempty"`
+	signer     crypto.Signer
+}
+
+// JSONWebKey is the public half of a signing key, as published in the
+// key set
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	Y         string `json:"y,omitempty"`
+}
+
+// JSONWebKeySet is the set of keys tokens may be verified with
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// newSigningKey generates a key for algorithm
+func newSigningKey(algorithm string, now time.Time) (*signingKey, error) {
+	var signer crypto.Signer
+	var err error
+	switch algorithm {
+	case SigningAlgorithmES256:
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case SigningAlgorithmEdDSA:
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedSigningAlgorithm, algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return nil, err
+	}
+	kid, err := utils.GenerateSecureToken(8)
+	if err != nil {
+		return nil, err
+	}
+	return &signingKey{
+		ID:         kid,
+		Algorithm:  algorithm,
+		PrivateKey: der,
+		CreatedAt:  now,
+		signer:     signer,
+	}, nil
+}
+
+// verifiesUntil is when the last token a key signed expires, access and
+// impersonation tokens alike
+func (s *Service) verifiesUntil(key *signingKey) time.Time {
+	if key.RetiredAt == nil {
+		return time.Time{}
+	}
+	ttl := s.config.Auth.JWTExpiry
+	if s.config.Auth.ImpersonationTTL > ttl {
+		ttl = s.config.Auth.ImpersonationTTL
+	}
+	return key.RetiredAt.Add(ttl + tokenLeeway)
+}
+
+// currentSigningKey returns the key to sign with, replacing it first when
+// it is due for rotation or was made for another algorithm
+func (s *Service) currentSigningKey() (*signingKey, error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pruneSigningKeysLocked(now)
+	if n := len(s.signingKeys); n > 0 {
+		current := s.signingKeys[n-1]
+		due := s.config.Auth.JWTKeyRotation > 0 && now.Sub(current.CreatedAt) >= s.config.Auth.JWTKeyRotation
+		if current.RetiredAt == nil && current.Algorithm == s.config.Auth.JWTAlgorithm && !due {
+			return current, nil
+		}
+	}
+	return s.rotateSigningKeyLocked(now)
+}
+
+// RotateSigningKey replaces the signing key now, ahead of schedule, and
+// returns the new key's ID. Tokens signed with the old key stay good
+// until they expire.
+func (s *Service) RotateSigningKey(actor, actorIP string) (string, error) {
+	s.mu.Lock()
+	key, err := s.rotateSigningKeyLocked(time.Now())
+	s.mu.Unlock()
+
+	opts := events.EmitOptions{Actor: actor, ActorType: "user", ActorIP: actorIP}
+	if err != nil {
+		s.events.EmitFailed(events.EventSigningKeyRotate, err.Error(), opts)
+		return "", err
+	}
+	opts.Data = map[string]interface{}{"kid": key.ID, "alg": key.Algorithm}
+	s.events.EmitSuccess(events.EventSigningKeyRotate, opts)
+	return key.ID, nil
+}
+
+// rotateSigningKeyLocked retires the signing key and starts a new one
+func (s *Service) rotateSigningKeyLocked(now time.Time) (*signingKey, error) {
+	key, err := newSigningKey(s.config.Auth.JWTAlgorithm, now)
+	if err != nil {
+		return nil, err
+	}
+	for _, old := range s.signingKeys {
+		if old.RetiredAt == nil {
+			old.RetiredAt = &now
+		}
+	}
+	s.signingKeys = append(s.signingKeys, key)
+	// Unsaved, the key is lost at restart and its tokens with it; their
+	// sessions carry on with a refresh
+	if err := s.saveSigningKeysLocked(); err != nil {
+		fmt.Printf("warning: failed to save signing keys: %v\n", err)
+	}
+	return key, nil
+}
+
+// verificationKey returns the public key kid names, for tokens signed
+// with algorithm
+func (s *Service) verificationKey(kid, algorithm string) (crypto.PublicKey, error) {
+	now := time.Now()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, key := range s.signingKeys {
+		if key.ID != kid {
+			continue
+		}
+		if key.Algorithm != algorithm {
+			return nil, ErrUnsupportedSigningAlgorithm
+		}
+		if key.RetiredAt != nil && now.After(s.verifiesUntil(key)) {
+			break
+		}
+		return key.signer.Public(), nil
+	}
+	return nil, ErrUnknownSigningKey
+}
+
+// JWKS returns the public keys tokens may currently be verified with, so
+// other nodes and plugins can check tokens without a shared secret
+func (s *Service) JWKS() (*JSONWebKeySet, error) {
+	// Publish a key before the first token needs one
+	if _, err := s.currentSigningKey(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	set := &JSONWebKeySet{Keys: make([]JSONWebKey, 0, len(s.signingKeys))}
+	for _, key := range s.signingKeys {
+		if key.RetiredAt != nil && now.After(s.verifiesUntil(key)) {
+			continue
+		}
+		jwk := JSONWebKey{KeyID: key.ID, Use: "sig", Algorithm: key.Algorithm}
+		switch pub := key.signer.Public().(type) {
+		case *ecdsa.PublicKey:
+			ecdhKey, err := pub.ECDH()
+			if err != nil {
+				return nil, err
+			}
+			// Uncompressed point: 0x04 || X || Y
+			point := ecdhKey.Bytes()
+			size := (len(point) - 1) / 2
+			jwk.KeyType, jwk.Curve = "EC", pub.Curve.Params().Name
+			jwk.X = base64.RawURLEncoding.EncodeToString(point[1 : 1+size])
+			jwk.Y = base64.RawURLEncoding.EncodeToString(point[1+size:])
+		case ed25519.PublicKey:
+			jwk.KeyType, jwk.Curve = "OKP", "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set, nil
+}
+
+// pruneSigningKeysLocked forgets retired keys whose tokens have all
+// expired
+func (s *Service) pruneSigningKeysLocked(now time.Time) {
+	kept := s.signingKeys[:0]
+	for _, key := range s.signingKeys {
+		if key.RetiredAt == nil || !now.After(s.verifiesUntil(key)) {
+			kept = append(kept, key)
+		}
+	}
+	s.signingKeys = kept
+}
+
+// loadSigningKeys reads the saved signing keys. Having none saved yet is
+// not an error; the first token makes one.
+func (s *Service) loadSigningKeys() error {
+	data, err := os.ReadFile(s.signingKeysPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var stored []*signingKey
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+	for _, key := range stored {
+		parsed, err := x509.ParsePKCS8PrivateKey(key.PrivateKey)
+		if err != nil {
+			return fmt.Errorf("signing key %s: %w", key.ID, err)
+		}
+		signer, ok := parsed.(crypto.Signer)
+		if !ok {
+			return fmt.Errorf("signing key %s: %w", key.ID, ErrUnsupportedSigningAlgorithm)
+		}
+		key.signer = signer
+		s.signingKeys = append(s.signingKeys, key)
+	}
+	s.pruneSigningKeysLocked(time.Now())
+	return nil
+}
+
+// saveSigningKeysLocked writes the signing keys out; s.mu must be held.
+// They are private keys, so only root may read them.
+func (s *Service) saveSigningKeysLocked() error {
+	data, err := json.MarshalIndent(s.signingKeys, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.signingKeysPath), 0700); err != nil {
+		return err
+	}
+	tmp := s.signingKeysPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.signingKeysPath)
+}
@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestVerifiesUntil(t *testing.T) {
+	s := newTestService(t, t.TempDir())
+	retired := time.Date(2026, 3, 14, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		jwtExpiry     time.Duration
+		impersonation time.Duration
+		want          time.Time
+	}{
+		{"impersonation outlives access", 15 * time.Minute, time.Hour, retired.Add(time.Hour + tokenLeeway)},
+		{"access outlives impersonation", 2 * time.Hour, time.Hour, retired.Add(2*time.Hour + tokenLeeway)},
+		{"no impersonation", 15 * time.Minute, 0, retired.Add(15*time.Minute + tokenLeeway)},
+	}
+	for _, tt := range tests {
+		s.config.Auth.JWTExpiry = tt.jwtExpiry
+		s.config.Auth.ImpersonationTTL = tt.impersonation
+		if got := s.verifiesUntil(&signingKey{RetiredAt: &retired}); !got.Equal(tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+
+	if got := s.verifiesUntil(&signingKey{}); !got.IsZero() {
+		t.Errorf("Expected a live key to have no end, got %v", got)
+	}
+}
+
+func TestCurrentSigningKey_Rotation(t *testing.T) {
+	s := newTestService(t, t.TempDir())
+
+	first, err := s.currentSigningKey()
+	if err != nil {
+		t.Fatalf("Failed to make a signing key: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		setup   func()
+		rotates bool
+	}{
+		{"not due", func() {}, false},
+		{"due", func() { s.signingKeys[len(s.signingKeys)-1].CreatedAt = time.Now().Add(-25 * time.Hour) }, true},
+		{"algorithm changed", func() { s.config.Auth.JWTAlgorithm = SigningAlgorithmEdDSA }, true},
+		{"rotation off", func() {
+			s.config.Auth.JWTKeyRotation = 0
+			s.signingKeys[len(s.signingKeys)-1].CreatedAt = time.Now().Add(-365 * 24 * time.Hour)
+		}, false},
+	}
+	current := first
+	for _, tt := range tests {
+		tt.setup()
+		key, err := s.currentSigningKey()
+		if err != nil {
+			t.Fatalf("%s: failed to get the signing key: %v", tt.name, err)
+		}
+		if rotated := key.ID != current.ID; rotated != tt.rotates {
+			t.Errorf("%s: expected rotated=%v, got %v", tt.name, tt.rotates, rotated)
+		}
+		if tt.rotates && current.RetiredAt == nil {
+			t.Errorf("%s: expected the replaced key to be retired", tt.name)
+		}
+		current = key
+	}
+	if current.Algorithm != SigningAlgorithmEdDSA {
+		t.Errorf("Expected the key to follow the configured algorithm, got %s", current.Algorithm)
+	}
+}
+
+func TestRotateSigningKey_RetirementWindow(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestService(t, dir)
+
+	login, err := s.GenerateTokens(testUser, "192.0.2.1", "test")
+	if err != nil {
+		t.Fatalf("Failed to log in: %v", err)
+	}
+	oldKID := s.signingKeys[0].ID
+	newKID, err := s.RotateSigningKey("usr-admin", "192.0.2.9")
+	if err != nil {
+		t.Fatalf("Failed to rotate: %v", err)
+	}
+	if newKID == oldKID {
+		t.Fatal("Expected a new key")
+	}
+
+	// Tokens signed before the rotation stay good until they expire
+	if _, err := s.ValidateToken(login.AccessToken); err != nil {
+		t.Errorf("Expected a token signed with the retired key to validate, got %v", err)
+	}
+	if got := jwksKeyIDs(t, s); len(got) != 2 || got[0] != oldKID || got[1] != newKID {
+		t.Errorf("Expected the key set to publish both keys, got %v", got)
+	}
+
+	// The retired key outlives its last token across a restart
+	restarted := newTestService(t, dir)
+	if _, err := restarted.ValidateToken(login.AccessToken); err != nil {
+		t.Errorf("Expected the retired key to be kept at restart, got %v", err)
+	}
+
+	// Once its last token has expired, the key is gone
+	past := time.Now().Add(-s.config.Auth.ImpersonationTTL - tokenLeeway - time.Second)
+	s.signingKeys[0].RetiredAt = &past
+	if _, err := s.ValidateToken(login.AccessToken); !errors.Is(err, ErrUnknownSigningKey) {
+		t.Errorf("Expected ErrUnknownSigningKey past the retirement window, got %v", err)
+	}
+	if got := jwksKeyIDs(t, s); len(got) != 1 || got[0] != newKID {
+		t.Errorf("Expected only the current key published, got %v", got)
+	}
+	if len(s.signingKeys) != 1 {
+		t.Errorf("Expected the expired key to be pruned, got %d keys", len(s.signingKeys))
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	tests := []struct {
+		algorithm, keyType, curve string
+		hasY                      bool
+	}{
+		{SigningAlgorithmES256, "EC", "P-256", true},
+		{SigningAlgorithmEdDSA, "OKP", "Ed25519", false},
+	}
+	for _, tt := range tests {
+		s := newTestService(t, t.TempDir())
+		s.config.Auth.JWTAlgorithm = tt.algorithm
+
+		set, err := s.JWKS()
+		if err != nil {
+			t.Fatalf("%s: failed to build the key set: %v", tt.algorithm, err)
+		}
+		if len(set.Keys) != 1 {
+			t.Fatalf("%s: expected a key published before the first token, got %d", tt.algorithm, len(set.Keys))
+		}
+		key := set.Keys[0]
+		if key.KeyType != tt.keyType || key.Curve != tt.curve || key.Algorithm != tt.algorithm || key.Use != "sig" {
+			t.Errorf("%s: unexpected key %+v", tt.algorithm, key)
+		}
+		if key.X == "" || (key.Y != "") != tt.hasY {
+			t.Errorf("%s: unexpected coordinates %+v", tt.algorithm, key)
+		}
+	}
+}
+
+func TestVerificationKey_AlgorithmMismatch(t *testing.T) {
+	s := newTestService(t, t.TempDir())
+	key, err := s.currentSigningKey()
+	if err != nil {
+		t.Fatalf("Failed to make a signing key: %v", err)
+	}
+
+	if _, err := s.verificationKey(key.ID, SigningAlgorithmEdDSA); !errors.Is(err, ErrUnsupportedSigningAlgorithm) {
+		t.Errorf("Expected ErrUnsupportedSigningAlgorithm, got %v", err)
+	}
+	if _, err := s.verificationKey("unknown", SigningAlgorithmES256); !errors.Is(err, ErrUnknownSigningKey) {
+		t.Errorf("Expected ErrUnknownSigningKey, got %v", err)
+	}
+}
+
+func jwksKeyIDs(t *testing.T, s *Service) []string {
+	t.Helper()
+	set, err := s.JWKS()
+	if err != nil {
+		t.Fatalf("Failed to build the key set: %v", err)
+	}
+	var ids []string
+	for _, key := range set.Keys {
+		ids = append(ids, key.KeyID)
+	}
+	return ids
+}
@@ -0,0 +1,321 @@
+// Package authorization provides RBAC and policy-based authorization for OweHost
+package authorization
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iSundram/OweHost/pkg/models"
+)
+
+var (
+	// ErrInvalidPolicyRule is a policy rule that can't be evaluated
+	ErrInvalidPolicyRule = errors.New("invalid policy rule")
+	// ErrPolicyRuleNotFound is returned for an unknown policy rule ID
+	ErrPolicyRuleNotFound = errors.New("policy rule not found")
+)
+
+// operator tests a context value against an operand
+type operator struct {
+	test     func(actual, operand interface{}) bool
+	validate func(operand interface{}) error
+}
+
+// Condition operators. A condition is either a plain value, which the
+// context value must equal, or an object of operators that must all hold,
+// such as {"cidr": ["10.0.0.0/8"]} or {"gte": 9, "lt": 17}. A condition on
+// a value the request doesn't carry never holds.
+var operators = map[string]operator{
+	"eq":       {test: looseEqual, validate: anyOperand},
+	"ne":       {test: func(a, o interface{}) bool { return !looseEqual(a, o) }, validate: anyOperand},
+	"in":       {test: in, validate: listOperand},
+	"not_in":   {test: func(a, o interface{}) bool { return !in(a, o) }, validate: listOperand},
+	"cidr":     {test: inCIDR, validate: cidrOperand},
+	"not_cidr": {test: func(a, o interface{}) bool { return !inCIDR(a, o) }, validate: cidrOperand},
+	"prefix":   {test: hasPrefix, validate: stringsOperand},
+	"gt":       {test: compare(func(a, o float64) bool { return a > o }), validate: numberOperand},
+	"gte":      {test: compare(func(a, o float64) bool { return a >= o }), validate: numberOperand},
+	"lt":       {test: compare(func(a, o float64) bool { return a < o }), validate: numberOperand},
+	"lte":      {test: compare(func(a, o float64) bool { return a <= o }), validate: numberOperand},
+}
+
+// conditionHolds reports whether a context value meets a condition
+func conditionHolds(actual interface{}, present bool, expected interface{}) bool {
+	if !present {
+		return false
+	}
+	ops, ok := expected.(map[string]interface{})
+	if !ok {
+		return looseEqual(actual, expected)
+	}
+	for name, operand := range ops {
+		op, known := operators[name]
+		if !known || !op.test(actual, operand) {
+			return false
+		}
+	}
+	return true
+}
+
+// validateConditions checks every condition of a rule can be evaluated
+func validateConditions(conditions map[string]interface{}) error {
+	for key, expected := range conditions {
+		ops, ok := expected.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if len(ops) == 0 {
+			return fmt.Errorf("%w: condition %q has no operators", ErrInvalidPolicyRule, key)
+		}
+		for name, operand := range ops {
+			op, known := operators[name]
+			if !known {
+				return fmt.Errorf("%w: condition %q: unknown operator %q", ErrInvalidPolicyRule, key, name)
+			}
+			if err := op.validate(operand); err != nil {
+				return fmt.Errorf("%w: condition %q: %s: %v", ErrInvalidPolicyRule, key, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// looseEqual compares values as numbers when both are, and as text
+// otherwise, so a JSON 8080 equals an int 8080
+func looseEqual(actual, expected interface{}) bool {
+	a, aok := toNumber(actual)
+	e, eok := toNumber(expected)
+	if aok && eok {
+		return a == e
+	}
+	return fmt.Sprint(actual) == fmt.Sprint(expected)
+}
+
+func in(actual, operand interface{}) bool {
+	for _, item := range toList(operand) {
+		if looseEqual(actual, item) {
+			return true
+		}
+	}
+	return false
+}
+
+func inCIDR(actual, operand interface{}) bool {
+	ip := net.ParseIP(fmt.Sprint(actual))
+	if ip == nil {
+		return false
+	}
+	for _, item := range toList(operand) {
+		if _, network, err := net.ParseCIDR(fmt.Sprint(item)); err == nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasPrefix(actual, operand interface{}) bool {
+	value := fmt.Sprint(actual)
+	for _, item := range toList(operand) {
+		if strings.HasPrefix(value, fmt.Sprint(item)) {
+			return true
+		}
+	}
+	return false
+}
+
+func compare(cmp func(actual, operand float64) bool) func(actual, operand interface{}) bool {
+	return func(actual, operand interface{}) bool {
+		a, aok := toNumber(actual)
+		o, ook := toNumber(operand)
+		return aok && ook && cmp(a, o)
+	}
+}
+
+func anyOperand(operand interface{}) error {
+	if operand == nil {
+		return errors.New("operand is required")
+	}
+	return nil
+}
+
+func listOperand(operand interface{}) error {
+	if _, ok := operand.([]interface{}); !ok {
+		if _, ok := operand.([]string); !ok {
+			return errors.New("operand must be a list")
+		}
+	}
+	return nil
+}
+
+func stringsOperand(operand interface{}) error {
+	if _, ok := operand.(string); ok {
+		return nil
+	}
+	if err := listOperand(operand); err != nil {
+		return errors.New("operand must be a string or a list of strings")
+	}
+	return nil
+}
+
+func cidrOperand(operand interface{}) error {
+	if err := stringsOperand(operand); err != nil {
+		return err
+	}
+	for _, item := range toList(operand) {
+		if _, _, err := net.ParseCIDR(fmt.Sprint(item)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func numberOperand(operand interface{}) error {
+	if _, ok := toNumber(operand); !ok {
+		return errors.New("operand must be a number")
+	}
+	return nil
+}
+
+// toList returns a list operand's items; a single value is a list of one
+func toList(operand interface{}) []interface{} {
+	switch v := operand.(type) {
+	case []interface{}:
+		return v
+	case []string:
+		items := make([]interface{}, len(v))
+		for i, s := range v {
+			items[i] = s
+		}
+		return items
+	default:
+		return []interface{}{v}
+	}
+}
+
+func toNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// weekdays maps the names days may be given by
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "sunday": time.Sunday,
+	"mon": time.Monday, "monday": time.Monday,
+	"tue": time.Tuesday, "tuesday": time.Tuesday,
+	"wed": time.Wednesday, "wednesday": time.Wednesday,
+	"thu": time.Thursday, "thursday": time.Thursday,
+	"fri": time.Friday, "friday": time.Friday,
+	"sat": time.Saturday, "saturday": time.Saturday,
+}
+
+// timeWindow is a parsed time restriction
+type timeWindow struct {
+	days       map[time.Weekday]bool // all days if empty
+	start, end int                   // minutes into the day; equal for all day
+	loc        *time.Location
+}
+
+// parseTimeRestriction checks a time restriction and parses it
+func parseTimeRestriction(tr *models.TimeRestriction) (*timeWindow, error) {
+	w := &timeWindow{days: make(map[time.Weekday]bool), loc: time.UTC}
+	if tr.Timezone != "" {
+		loc, err := time.LoadLocation(tr.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("%w: timezone %q", ErrInvalidPolicyRule, tr.Timezone)
+		}
+		w.loc = loc
+	}
+	for _, day := range tr.Days {
+		weekday, ok := weekdays[strings.ToLower(strings.TrimSpace(day))]
+		if !ok {
+			return nil, fmt.Errorf("%w: day %q", ErrInvalidPolicyRule, day)
+		}
+		w.days[weekday] = true
+	}
+
+	if (tr.StartTime == "") != (tr.EndTime == "") {
+		return nil, fmt.Errorf("%w: start_time and end_time go together", ErrInvalidPolicyRule)
+	}
+	if tr.StartTime != "" {
+		var err error
+		if w.start, err = minuteOfDay(tr.StartTime); err != nil {
+			return nil, err
+		}
+		if w.end, err = minuteOfDay(tr.EndTime); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+func minuteOfDay(clock string) (int, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, fmt.Errorf("%w: time %q is not HH:MM", ErrInvalidPolicyRule, clock)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// contains reports whether now falls in the window. Past midnight, an
+// overnight window belongs to the day it started on.
+func (w *timeWindow) contains(now time.Time) bool {
+	local := now.In(w.loc)
+	minute := local.Hour()*60 + local.Minute()
+	day := local.Weekday()
+
+	switch {
+	case w.start == w.end:
+	case w.start < w.end:
+		if minute < w.start || minute >= w.end {
+			return false
+		}
+	case minute >= w.start:
+	case minute < w.end:
+		day = (day + 6) % 7
+	default:
+		return false
+	}
+	return len(w.days) == 0 || w.days[day]
+}
+
+// sortPolicyRules puts rules in the order they are tried: the highest
+// priority first, deny before allow at the same priority, then oldest
+// first
+func sortPolicyRules(rules []*models.PolicyRule) {
+	sort.SliceStable(rules, func(i, j int) bool {
+		a, b := rules[i], rules[j]
+		if a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+		if (a.Effect == models.PolicyEffectDeny) != (b.Effect == models.PolicyEffectDeny) {
+			return a.Effect == models.PolicyEffectDeny
+		}
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ID < b.ID
+	})
+}
@@ -0,0 +1,180 @@
+package authorization
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/iSundram/OweHost/pkg/models"
+)
+
+// condition decodes a condition the way it arrives in a request body
+func condition(t *testing.T, data string) interface{} {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal([]byte(data), &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestConditionHolds(t *testing.T) {
+	tests := []struct {
+		name      string
+		condition string
+		actual    interface{}
+		want      bool
+	}{
+		{"plain string", `"admin"`, "admin", true},
+		{"plain string differs", `"admin"`, "user", false},
+		{"plain number", `8080`, 8080, true},
+		{"number as text", `8080`, "8080", true},
+		{"eq", `{"eq": "GET"}`, "GET", true},
+		{"ne", `{"ne": "GET"}`, "POST", true},
+		{"ne same", `{"ne": "GET"}`, "GET", false},
+		{"in", `{"in": ["GET", "HEAD"]}`, "HEAD", true},
+		{"in missing", `{"in": ["GET", "HEAD"]}`, "POST", false},
+		{"in numbers", `{"in": [1, 2, 3]}`, int64(2), true},
+		{"not_in", `{"not_in": ["DELETE"]}`, "GET", true},
+		{"not_in listed", `{"not_in": ["DELETE"]}`, "DELETE", false},
+		{"cidr", `{"cidr": ["10.0.0.0/8", "192.0.2.0/24"]}`, "192.0.2.7", true},
+		{"cidr single", `{"cidr": "2001:db8::/32"}`, "2001:db8::1", true},
+		{"cidr outside", `{"cidr": ["10.0.0.0/8"]}`, "192.0.2.7", false},
+		{"cidr not an address", `{"cidr": ["10.0.0.0/8"]}`, "example.com", false},
+		{"not_cidr", `{"not_cidr": ["10.0.0.0/8"]}`, "192.0.2.7", true},
+		{"not_cidr inside", `{"not_cidr": ["10.0.0.0/8"]}`, "10.1.2.3", false},
+		{"prefix", `{"prefix": "/api/v1/dns"}`, "/api/v1/dns/zones", true},
+		{"prefix list", `{"prefix": ["/a", "/b"]}`, "/b/c", true},
+		{"prefix none", `{"prefix": ["/a", "/b"]}`, "/c", false},
+		{"range", `{"gte": 9, "lt": 17}`, 9, true},
+		{"range end", `{"gte": 9, "lt": 17}`, 17, false},
+		{"range before", `{"gte": 9, "lt": 17}`, 8.5, false},
+		{"gt", `{"gt": 100}`, "101", true},
+		{"lte", `{"lte": 100}`, json.Number("100"), true},
+		{"number against text", `{"gt": 1}`, "many", false},
+		{"unknown operator", `{"like": "a%"}`, "abc", false},
+	}
+	for _, tt := range tests {
+		if got := conditionHolds(tt.actual, true, condition(t, tt.condition)); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+
+	if conditionHolds(nil, false, condition(t, `{"not_in": ["DELETE"]}`)) {
+		t.Error("Expected a condition on a missing value never to hold")
+	}
+}
+
+func TestValidateConditions(t *testing.T) {
+	tests := []struct {
+		name       string
+		conditions string
+		valid      bool
+	}{
+		{"none", `{}`, true},
+		{"plain values", `{"method": "GET", "port": 22}`, true},
+		{"operators", `{"ip": {"cidr": ["10.0.0.0/8"]}, "hour": {"gte": 9, "lt": 17}}`, true},
+		{"no operators", `{"ip": {}}`, false},
+		{"unknown operator", `{"ip": {"like": "10.%"}}`, false},
+		{"bad cidr", `{"ip": {"cidr": ["10.0.0.0/33"]}}`, false},
+		{"in needs a list", `{"method": {"in": "GET"}}`, false},
+		{"gt needs a number", `{"hour": {"gt": "nine"}}`, false},
+		{"prefix needs text", `{"path": {"prefix": 1}}`, false},
+		{"eq needs a value", `{"method": {"eq": null}}`, false},
+	}
+	for _, tt := range tests {
+		var conditions map[string]interface{}
+		if err := json.Unmarshal([]byte(tt.conditions), &conditions); err != nil {
+			t.Fatal(err)
+		}
+		err := validateConditions(conditions)
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: expected valid=%v, got %v", tt.name, tt.valid, err)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidPolicyRule) {
+			t.Errorf("%s: expected ErrInvalidPolicyRule, got %v", tt.name, err)
+		}
+	}
+}
+
+func TestParseTimeRestriction(t *testing.T) {
+	tests := []struct {
+		name  string
+		tr    models.TimeRestriction
+		valid bool
+	}{
+		{"empty", models.TimeRestriction{}, true},
+		{"days only", models.TimeRestriction{Days: []string{"Mon", "friday"}}, true},
+		{"hours", models.TimeRestriction{StartTime: "09:00", EndTime: "17:30", Timezone: "Europe/Berlin"}, true},
+		{"unknown day", models.TimeRestriction{Days: []string{"someday"}}, false},
+		{"unknown timezone", models.TimeRestriction{Timezone: "Mars/Olympus"}, false},
+		{"start without end", models.TimeRestriction{StartTime: "09:00"}, false},
+		{"not a time", models.TimeRestriction{StartTime: "9am", EndTime: "5pm"}, false},
+		{"out of range", models.TimeRestriction{StartTime: "09:00", EndTime: "24:30"}, false},
+	}
+	for _, tt := range tests {
+		_, err := parseTimeRestriction(&tt.tr)
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: expected valid=%v, got %v", tt.name, tt.valid, err)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidPolicyRule) {
+			t.Errorf("%s: expected ErrInvalidPolicyRule, got %v", tt.name, err)
+		}
+	}
+}
+
+func TestTimeWindowContains(t *testing.T) {
+	// 16 March 2026 is a Monday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 3, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name string
+		tr   models.TimeRestriction
+		now  time.Time
+		want bool
+	}{
+		{"office hours", models.TimeRestriction{StartTime: "09:00", EndTime: "17:00"}, at(16, 9, 0), true},
+		{"office hours end", models.TimeRestriction{StartTime: "09:00", EndTime: "17:00"}, at(16, 17, 0), false},
+		{"office hours early", models.TimeRestriction{StartTime: "09:00", EndTime: "17:00"}, at(16, 8, 59), false},
+		{"weekday", models.TimeRestriction{Days: []string{"mon"}}, at(16, 23, 59), true},
+		{"other day", models.TimeRestriction{Days: []string{"mon"}}, at(17, 0, 0), false},
+		{"timezone", models.TimeRestriction{StartTime: "09:00", EndTime: "17:00", Timezone: "Asia/Tokyo"}, at(16, 1, 0), true},
+		{"timezone shifts the day", models.TimeRestriction{Days: []string{"tue"}, Timezone: "Asia/Tokyo"}, at(16, 16, 0), true},
+		{"overnight before midnight", models.TimeRestriction{StartTime: "22:00", EndTime: "06:00", Days: []string{"fri"}}, at(20, 23, 0), true},
+		{"overnight after midnight", models.TimeRestriction{StartTime: "22:00", EndTime: "06:00", Days: []string{"fri"}}, at(21, 5, 59), true},
+		{"overnight ended", models.TimeRestriction{StartTime: "22:00", EndTime: "06:00", Days: []string{"fri"}}, at(21, 6, 0), false},
+		{"overnight from the day before", models.TimeRestriction{StartTime: "22:00", EndTime: "06:00", Days: []string{"fri"}}, at(20, 5, 0), false},
+		{"overnight gap", models.TimeRestriction{StartTime: "22:00", EndTime: "06:00"}, at(16, 12, 0), false},
+	}
+	for _, tt := range tests {
+		w, err := parseTimeRestriction(&tt.tr)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := w.contains(tt.now); got != tt.want {
+			t.Errorf("%s: expected %v at %v, got %v", tt.name, tt.want, tt.now, got)
+		}
+	}
+}
+
+func TestSortPolicyRules(t *testing.T) {
+	created := time.Date(2026, 3, 14, 9, 0, 0, 0, time.UTC)
+	rules := []*models.PolicyRule{
+		{ID: "low-allow", Effect: models.PolicyEffectAllow, Priority: 1, CreatedAt: created},
+		{ID: "high-allow-new", Effect: models.PolicyEffectAllow, Priority: 10, CreatedAt: created.Add(time.Hour)},
+		{ID: "high-allow-old", Effect: models.PolicyEffectAllow, Priority: 10, CreatedAt: created},
+		{ID: "high-deny", Effect: models.PolicyEffectDeny, Priority: 10, CreatedAt: created.Add(2 * time.Hour)},
+		{ID: "low-deny", Effect: models.PolicyEffectDeny, Priority: 1, CreatedAt: created},
+	}
+	sortPolicyRules(rules)
+
+	want := []string{"high-deny", "high-allow-old", "high-allow-new", "low-deny", "low-allow"}
+	for i, rule := range rules {
+		if rule.ID != want[i] {
+			t.Errorf("Position %d: expected %s, got %s", i, want[i], rule.ID)
+		}
+	}
+}
@@ -2,7 +2,12 @@
 package authorization
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 
@@ -10,12 +15,24 @@ import (
 	"github.com/iSundram/OweHost/pkg/utils"
 )
 
+// policyRulesPath holds the policy rules. They gate API calls, so they
+// must outlive restarts.
+const policyRulesPath = "/var/lib/owehost/auth/policies.json"
+
+// Who decided an authorization check
+const (
+	DecidedByPolicy = "policy"
+	DecidedByRBAC   = "rbac"
+)
+
 // Service provides authorization functionality
 type Service struct {
 	roles           map[string]*models.Role
 	permissions     map[string]*models.Permission
 	roleAssignments map[string][]*models.RoleAssignment // userID -> assignments
 	policyRules     map[string]*models.PolicyRule
+	timeWindows     map[string]*timeWindow // policy rule ID -> parsed time restriction
+	policiesPath    string
 	mu              sync.RWMutex
 }
 
@@ -26,8 +43,13 @@ func NewService() *Service {
 		permissions:     make(map[string]*models.Permission),
 		roleAssignments: make(map[string][]*models.RoleAssignment),
 		policyRules:     make(map[string]*models.PolicyRule),
+		timeWindows:     make(map[string]*timeWindow),
+		policiesPath:    policyRulesPath,
 	}
 	svc.initDefaultRoles()
+	if err := svc.loadPolicyRules(); err != nil {
+		fmt.Printf("warning: failed to load policy rules: %v\n", err)
+	}
 	return svc
 }
 
@@ -233,6 +255,23 @@ func (s *Service) CheckPermission(userID, resource, action string) bool {
 
 // CreatePolicyRule creates a new policy rule
 func (s *Service) CreatePolicyRule(req *models.PolicyRuleCreateRequest) (*models.PolicyRule, error) {
+	if req.Effect != models.PolicyEffectAllow && req.Effect != models.PolicyEffectDeny {
+		return nil, fmt.Errorf("%w: effect must be allow or deny", ErrInvalidPolicyRule)
+	}
+	if req.Resource == "" || req.Action == "" {
+		return nil, fmt.Errorf("%w: resource and action are required", ErrInvalidPolicyRule)
+	}
+	if err := validateConditions(req.Conditions); err != nil {
+		return nil, err
+	}
+	var window *timeWindow
+	if req.TimeRestriction != nil {
+		var err error
+		if window, err = parseTimeRestriction(req.TimeRestriction); err != nil {
+			return nil, err
+		}
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -252,65 +291,213 @@ func (s *Service) CreatePolicyRule(req *models.PolicyRuleCreateRequest) (*models
 	}
 
 	s.policyRules[rule.ID] = rule
+	if err := s.savePolicyRulesLocked(); err != nil {
+		delete(s.policyRules, rule.ID)
+		return nil, err
+	}
+	if window != nil {
+		s.timeWindows[rule.ID] = window
+	}
 	return rule, nil
 }
 
-// EvaluatePolicy evaluates policy rules for an authorization request
+// ListPolicyRules lists the policy rules in the order they are tried
+func (s *Service) ListPolicyRules() []*models.PolicyRule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rules := make([]*models.PolicyRule, 0, len(s.policyRules))
+	for _, rule := range s.policyRules {
+		rules = append(rules, rule)
+	}
+	sortPolicyRules(rules)
+	return rules
+}
+
+// SetPolicyRuleEnabled turns a policy rule on or off
+func (s *Service) SetPolicyRuleEnabled(id string, enabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rule, exists := s.policyRules[id]
+	if !exists {
+		return ErrPolicyRuleNotFound
+	}
+	rule.Enabled = enabled
+	rule.UpdatedAt = time.Now()
+	return s.savePolicyRulesLocked()
+}
+
+// DeletePolicyRule deletes a policy rule
+func (s *Service) DeletePolicyRule(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.policyRules[id]; !exists {
+		return ErrPolicyRuleNotFound
+	}
+	delete(s.policyRules, id)
+	delete(s.timeWindows, id)
+	return s.savePolicyRulesLocked()
+}
+
+// HasPolicyRules reports whether any policy rule is enabled, so callers
+// can skip building checks nothing would look at
+func (s *Service) HasPolicyRules() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, rule := range s.policyRules {
+		if rule.Enabled {
+			return true
+		}
+	}
+	return false
+}
+
+// EvaluatePolicy decides an authorization request. Policy rules are tried
+// in order of precedence (see sortPolicyRules) and the first that applies
+// decides, allowing or denying; when none applies, the user's roles do.
 func (s *Service) EvaluatePolicy(req *models.AuthorizationCheckRequest) *models.AuthorizationCheckResponse {
-	// First check RBAC
-	if s.CheckPermission(req.UserID, req.Resource, req.Action) {
-		// Then check policy rules
-		s.mu.RLock()
-		defer s.mu.RUnlock()
-
-		for _, rule := range s.policyRules {
-			if !rule.Enabled {
-				continue
-			}
-			if rule.Resource == req.Resource && rule.Action == req.Action {
-				if s.evaluateConditions(rule, req.Context) {
-					if rule.Effect == "deny" {
-						return &models.AuthorizationCheckResponse{
-							Allowed: false,
-							Reason:  "Denied by policy: " + rule.Name,
-						}
-					}
-				}
-			}
+	now := time.Now()
+	resp := &models.AuthorizationCheckResponse{}
+
+	s.mu.RLock()
+	rules := make([]*models.PolicyRule, 0, len(s.policyRules))
+	for _, rule := range s.policyRules {
+		rules = append(rules, rule)
+	}
+	sortPolicyRules(rules)
+	decided := false
+	for _, rule := range rules {
+		applies, reason := s.ruleAppliesLocked(rule, req, now)
+		if req.Explain {
+			resp.Trace = append(resp.Trace, models.PolicyRuleTrace{
+				RuleID:   rule.ID,
+				Name:     rule.Name,
+				Effect:   rule.Effect,
+				Priority: rule.Priority,
+				Matched:  applies,
+				Reason:   reason,
+			})
+		}
+		if !applies {
+			continue
 		}
 
-		return &models.AuthorizationCheckResponse{
-			Allowed: true,
-			Reason:  "Allowed by RBAC",
+		decided = true
+		resp.DecidedBy = DecidedByPolicy
+		resp.RuleID = rule.ID
+		resp.Allowed = rule.Effect == models.PolicyEffectAllow
+		if resp.Allowed {
+			resp.Reason = "Allowed by policy: " + rule.Name
+		} else {
+			resp.Reason = "Denied by policy: " + rule.Name
 		}
+		break
+	}
+	s.mu.RUnlock()
+	if decided {
+		return resp
 	}
 
-	return &models.AuthorizationCheckResponse{
-		Allowed: false,
-		Reason:  "Permission denied",
+	resp.DecidedBy = DecidedByRBAC
+	resp.Allowed = s.CheckPermission(req.UserID, req.Resource, req.Action)
+	if resp.Allowed {
+		resp.Reason = "Allowed by RBAC"
+	} else {
+		resp.Reason = "Permission denied"
 	}
+	return resp
 }
 
-// evaluateConditions evaluates policy conditions
-func (s *Service) evaluateConditions(rule *models.PolicyRule, context map[string]interface{}) bool {
-	// Check time restrictions
-	if rule.TimeRestriction != nil {
-		// Time-based evaluation would go here
-		// For now, return true (no time restriction applied)
+// ruleAppliesLocked reports whether a rule applies to a request, and why
+// not when it doesn't; s.mu must be held
+func (s *Service) ruleAppliesLocked(rule *models.PolicyRule, req *models.AuthorizationCheckRequest, now time.Time) (bool, string) {
+	switch {
+	case !rule.Enabled:
+		return false, "disabled"
+	case rule.Resource != "*" && rule.Resource != req.Resource:
+		return false, "resource does not match"
+	case rule.Action != "*" && rule.Action != req.Action:
+		return false, "action does not match"
 	}
 
-	// Evaluate other conditions
-	for key, expected := range rule.Conditions {
-		if actual, exists := context[key]; exists {
-			if actual != expected {
-				return false
+	if window := s.timeWindows[rule.ID]; window != nil && !window.contains(now) {
+		return false, "outside time restriction"
+	}
+
+	keys := make([]string, 0, len(rule.Conditions))
+	for key := range rule.Conditions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		actual, present := req.Context[key]
+		if !conditionHolds(actual, present, rule.Conditions[key]) {
+			return false, fmt.Sprintf("condition %q not met", key)
+		}
+	}
+	return true, "matched"
+}
+
+// loadPolicyRules reads the saved policy rules. Having none saved yet is
+// not an error. A rule that can't be evaluated is skipped, so it doesn't
+// take the rules after it down with it.
+func (s *Service) loadPolicyRules() error {
+	data, err := os.ReadFile(s.policiesPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var rules []*models.PolicyRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		if rule == nil {
+			continue
+		}
+		if err := validateConditions(rule.Conditions); err != nil {
+			fmt.Printf("warning: skipping policy rule %s: %v\n", rule.ID, err)
+			continue
+		}
+		if rule.TimeRestriction != nil {
+			window, err := parseTimeRestriction(rule.TimeRestriction)
+			if err != nil {
+				fmt.Printf("warning: skipping policy rule %s: %v\n", rule.ID, err)
+				continue
 			}
-		} else {
-			return false
+			s.timeWindows[rule.ID] = window
 		}
+		s.policyRules[rule.ID] = rule
+	}
+	return nil
+}
+
+// savePolicyRulesLocked writes the policy rules out; s.mu must be held
+func (s *Service) savePolicyRulesLocked() error {
+	rules := make([]*models.PolicyRule, 0, len(s.policyRules))
+	for _, rule := range s.policyRules {
+		rules = append(rules, rule)
 	}
+	sortPolicyRules(rules)
 
-	return true
+	data, err := json.MarshalIndent(rules, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.policiesPath), 0700); err != nil {
+		return err
+	}
+	tmp := s.policiesPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.policiesPath)
 }
 
 // CheckOwnership checks if a user owns a resource
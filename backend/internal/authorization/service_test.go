@@ -0,0 +1,236 @@
+package authorization
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/iSundram/OweHost/pkg/models"
+)
+
+// newTestService returns a service that keeps its policy rules in dir
+func newTestService(t *testing.T, dir string) *Service {
+	t.Helper()
+	s := &Service{
+		roles:           make(map[string]*models.Role),
+		permissions:     make(map[string]*models.Permission),
+		roleAssignments: make(map[string][]*models.RoleAssignment),
+		policyRules:     make(map[string]*models.PolicyRule),
+		timeWindows:     make(map[string]*timeWindow),
+		policiesPath:    filepath.Join(dir, "policies.json"),
+	}
+	s.initDefaultRoles()
+	if err := s.loadPolicyRules(); err != nil {
+		t.Fatalf("Failed to load policy rules: %v", err)
+	}
+	return s
+}
+
+func createRule(t *testing.T, s *Service, req models.PolicyRuleCreateRequest) *models.PolicyRule {
+	t.Helper()
+	rule, err := s.CreatePolicyRule(&req)
+	if err != nil {
+		t.Fatalf("Failed to create %s: %v", req.Name, err)
+	}
+	return rule
+}
+
+func TestCreatePolicyRule_Invalid(t *testing.T) {
+	s := newTestService(t, t.TempDir())
+
+	tests := []struct {
+		name string
+		req  models.PolicyRuleCreateRequest
+	}{
+		{"effect", models.PolicyRuleCreateRequest{Name: "x", Resource: "dns", Action: "write", Effect: "maybe"}},
+		{"resource", models.PolicyRuleCreateRequest{Name: "x", Action: "write", Effect: models.PolicyEffectDeny}},
+		{"condition", models.PolicyRuleCreateRequest{Name: "x", Resource: "dns", Action: "write", Effect: models.PolicyEffectDeny,
+			Conditions: map[string]interface{}{"ip": map[string]interface{}{"cidr": "not-a-network"}}}},
+		{"time restriction", models.PolicyRuleCreateRequest{Name: "x", Resource: "dns", Action: "write", Effect: models.PolicyEffectDeny,
+			TimeRestriction: &models.TimeRestriction{Days: []string{"caturday"}}}},
+	}
+	for _, tt := range tests {
+		if _, err := s.CreatePolicyRule(&tt.req); !errors.Is(err, ErrInvalidPolicyRule) {
+			t.Errorf("%s: expected ErrInvalidPolicyRule, got %v", tt.name, err)
+		}
+	}
+	if s.HasPolicyRules() {
+		t.Error("Expected no invalid rule to be kept")
+	}
+}
+
+func TestEvaluatePolicy_Precedence(t *testing.T) {
+	s := newTestService(t, t.TempDir())
+	userRole, err := s.GetRoleByName("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AssignRole("usr-alice", userRole.ID, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	office := []interface{}{"192.0.2.0/24"}
+	allowOffice := createRule(t, s, models.PolicyRuleCreateRequest{
+		Name: "office may delete", Resource: "domains", Action: "delete", Effect: models.PolicyEffectAllow, Priority: 10,
+		Conditions: map[string]interface{}{"ip": map[string]interface{}{"cidr": office}},
+	})
+	denyAll := createRule(t, s, models.PolicyRuleCreateRequest{
+		Name: "no deleting", Resource: "*", Action: "delete", Effect: models.PolicyEffectDeny, Priority: 5,
+	})
+	denyOfficeContractors := createRule(t, s, models.PolicyRuleCreateRequest{
+		Name: "no contractors", Resource: "domains", Action: "*", Effect: models.PolicyEffectDeny, Priority: 10,
+		Conditions: map[string]interface{}{"role": "contractor"},
+	})
+
+	tests := []struct {
+		name      string
+		resource  string
+		action    string
+		context   map[string]interface{}
+		allowed   bool
+		decidedBy string
+		ruleID    string
+	}{
+		{"allow beats lower deny", "domains", "delete", map[string]interface{}{"ip": "192.0.2.7"}, true, DecidedByPolicy, allowOffice.ID},
+		{"deny first at the same priority", "domains", "delete", map[string]interface{}{"ip": "192.0.2.7", "role": "contractor"}, false, DecidedByPolicy, denyOfficeContractors.ID},
+		{"falls through to lower deny", "domains", "delete", map[string]interface{}{"ip": "198.51.100.1"}, false, DecidedByPolicy, denyAll.ID},
+		{"missing context value", "domains", "delete", nil, false, DecidedByPolicy, denyAll.ID},
+		{"wildcard resource", "databases", "delete", nil, false, DecidedByPolicy, denyAll.ID},
+		{"no rule, role allows", "domains", "create", nil, true, DecidedByRBAC, ""},
+		{"no rule, role denies", "users", "create", nil, false, DecidedByRBAC, ""},
+	}
+	for _, tt := range tests {
+		resp := s.EvaluatePolicy(&models.AuthorizationCheckRequest{
+			UserID: "usr-alice", Resource: tt.resource, Action: tt.action, Context: tt.context,
+		})
+		if resp.Allowed != tt.allowed || resp.DecidedBy != tt.decidedBy || resp.RuleID != tt.ruleID {
+			t.Errorf("%s: expected allowed=%v by %s %s, got %+v", tt.name, tt.allowed, tt.decidedBy, tt.ruleID, resp)
+		}
+		if resp.Trace != nil {
+			t.Errorf("%s: expected no trace unless asked, got %+v", tt.name, resp.Trace)
+		}
+	}
+
+	// Disabled rules are skipped
+	if err := s.SetPolicyRuleEnabled(denyAll.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	resp := s.EvaluatePolicy(&models.AuthorizationCheckRequest{UserID: "usr-alice", Resource: "databases", Action: "delete"})
+	if resp.DecidedBy != DecidedByRBAC {
+		t.Errorf("Expected a disabled rule not to decide, got %+v", resp)
+	}
+	if err := s.SetPolicyRuleEnabled("policy-unknown", true); !errors.Is(err, ErrPolicyRuleNotFound) {
+		t.Errorf("Expected ErrPolicyRuleNotFound, got %v", err)
+	}
+}
+
+func TestEvaluatePolicy_Explain(t *testing.T) {
+	s := newTestService(t, t.TempDir())
+
+	createRule(t, s, models.PolicyRuleCreateRequest{
+		Name: "other resource", Resource: "dns", Action: "*", Effect: models.PolicyEffectDeny, Priority: 30,
+	})
+	createRule(t, s, models.PolicyRuleCreateRequest{
+		Name: "contractors", Resource: "*", Action: "*", Effect: models.PolicyEffectDeny, Priority: 20,
+		Conditions: map[string]interface{}{"role": "contractor"},
+	})
+	disabled := createRule(t, s, models.PolicyRuleCreateRequest{
+		Name: "disabled", Resource: "*", Action: "*", Effect: models.PolicyEffectDeny, Priority: 15,
+	})
+	if err := s.SetPolicyRuleEnabled(disabled.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	createRule(t, s, models.PolicyRuleCreateRequest{
+		Name: "reads", Resource: "*", Action: "read", Effect: models.PolicyEffectAllow, Priority: 10,
+	})
+	createRule(t, s, models.PolicyRuleCreateRequest{
+		Name: "never reached", Resource: "*", Action: "*", Effect: models.PolicyEffectDeny, Priority: 1,
+	})
+
+	resp := s.EvaluatePolicy(&models.AuthorizationCheckRequest{
+		UserID: "usr-alice", Resource: "domains", Action: "read", Explain: true,
+		Context: map[string]interface{}{"role": "staff"},
+	})
+	if !resp.Allowed {
+		t.Fatalf("Expected the read to be allowed, got %+v", resp)
+	}
+
+	want := []struct {
+		name    string
+		matched bool
+		reason  string
+	}{
+		{"other resource", false, "resource does not match"},
+		{"contractors", false, `condition "role" not met`},
+		{"disabled", false, "disabled"},
+		{"reads", true, "matched"},
+	}
+	if len(resp.Trace) != len(want) {
+		t.Fatalf("Expected the trace to stop at the deciding rule, got %+v", resp.Trace)
+	}
+	for i, w := range want {
+		got := resp.Trace[i]
+		if got.Name != w.name || got.Matched != w.matched || got.Reason != w.reason {
+			t.Errorf("Trace %d: expected %s matched=%v (%s), got %+v", i, w.name, w.matched, w.reason, got)
+		}
+	}
+}
+
+func TestPolicyRules_Persist(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestService(t, dir)
+
+	night := createRule(t, s, models.PolicyRuleCreateRequest{
+		Name: "no changes at night", Resource: "*", Action: "write", Effect: models.PolicyEffectDeny, Priority: 5,
+		TimeRestriction: &models.TimeRestriction{StartTime: "00:00", EndTime: "00:00"},
+	})
+	gone := createRule(t, s, models.PolicyRuleCreateRequest{
+		Name: "deleted", Resource: "*", Action: "*", Effect: models.PolicyEffectDeny, Priority: 50,
+	})
+	if err := s.DeletePolicyRule(gone.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeletePolicyRule(gone.ID); !errors.Is(err, ErrPolicyRuleNotFound) {
+		t.Errorf("Expected ErrPolicyRuleNotFound deleting twice, got %v", err)
+	}
+
+	restarted := newTestService(t, dir)
+	rules := restarted.ListPolicyRules()
+	if len(rules) != 1 || rules[0].ID != night.ID {
+		t.Fatalf("Expected only %s after a restart, got %+v", night.ID, rules)
+	}
+	if restarted.timeWindows[night.ID] == nil {
+		t.Error("Expected the time restriction to be parsed at load")
+	}
+	resp := restarted.EvaluatePolicy(&models.AuthorizationCheckRequest{UserID: "usr-alice", Resource: "dns", Action: "write"})
+	if resp.Allowed || resp.RuleID != night.ID {
+		t.Errorf("Expected the loaded rule to decide, got %+v", resp)
+	}
+}
+
+func TestLoadPolicyRules_SkipsInvalid(t *testing.T) {
+	dir := t.TempDir()
+	stored := `[
+  {"id": "policy-first", "name": "first", "resource": "*", "action": "read", "effect": "allow", "priority": 30, "enabled": true},
+  {"id": "policy-bad-time", "name": "bad time", "resource": "*", "action": "*", "effect": "allow", "priority": 20, "enabled": true,
+   "time_restriction": {"days": ["caturday"]}},
+  {"id": "policy-bad-condition", "name": "bad condition", "resource": "*", "action": "*", "effect": "allow", "priority": 20, "enabled": true,
+   "conditions": {"ip": {"like": "10.%"}}},
+  null,
+  {"id": "policy-last", "name": "no deleting", "resource": "*", "action": "delete", "effect": "deny", "priority": 10, "enabled": true}
+]`
+	if err := os.WriteFile(filepath.Join(dir, "policies.json"), []byte(stored), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestService(t, dir)
+	rules := s.ListPolicyRules()
+	if len(rules) != 2 || rules[0].ID != "policy-first" || rules[1].ID != "policy-last" {
+		t.Fatalf("Expected the valid rules on both sides of the bad ones, got %+v", rules)
+	}
+	resp := s.EvaluatePolicy(&models.AuthorizationCheckRequest{UserID: "usr-alice", Resource: "dns", Action: "delete"})
+	if resp.Allowed || resp.RuleID != "policy-last" {
+		t.Errorf("Expected the deny rule after the bad ones to apply, got %+v", resp)
+	}
+}
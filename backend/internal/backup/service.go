@@ -98,6 +98,18 @@ func (s *Service) ListByUser(userID string) []*models.Backup {
 	return s.byUser[userID]
 }
 
+// ListAll lists every user's backups
+func (s *Service) ListAll() []*models.Backup {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	backups := make([]*models.Backup, 0, len(s.backups))
+	for _, backup := range s.backups {
+		backups = append(backups, backup)
+	}
+	return backups
+}
+
 // Delete deletes a backup
 func (s *Service) Delete(id string) error {
 	s.mu.Lock()
@@ -181,6 +193,18 @@ func (s *Service) ListSchedules(userID string) []*models.BackupSchedule {
 	return schedules
 }
 
+// ListAllSchedules lists every user's schedules
+func (s *Service) ListAllSchedules() []*models.BackupSchedule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	schedules := make([]*models.BackupSchedule, 0, len(s.schedules))
+	for _, sched := range s.schedules {
+		schedules = append(schedules, sched)
+	}
+	return schedules
+}
+
 // UpdateSchedule updates a backup schedule
 func (s *Service) UpdateSchedule(id string, enabled bool) error {
 	s.mu.Lock()
@@ -0,0 +1,565 @@
+// Package bruteforce provides login throttling and lockouts for OweHost
+package bruteforce
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iSundram/OweHost/internal/firewall"
+	"github.com/iSundram/OweHost/internal/storage/events"
+	"github.com/iSundram/OweHost/pkg/config"
+	"github.com/iSundram/OweHost/pkg/models"
+)
+
+// sweepInterval is how often expired bans are lifted and stale failures
+// forgotten
+const sweepInterval = time.Minute
+
+// maxDelayShift bounds the doubling of the delay before it is capped
+const maxDelayShift = 20
+
+// Surface is a way of logging in. Usernames are counted per surface, as a
+// panel user and an FTP account of the same name are different logins;
+// addresses are counted across all of them.
+type Surface string
+
+// Login surfaces
+const (
+	SurfacePanel   Surface = "panel"
+	SurfaceAccount Surface = "account"
+	SurfaceFTP     Surface = "ftp"
+	SurfaceSFTP    Surface = "sftp"
+)
+
+// Reasons a login is held back
+var (
+	ErrThrottled  = errors.New("too many failed logins, try again later")
+	ErrUserLocked = errors.New("too many failed logins for this user")
+	ErrIPLocked   = errors.New("too many failed logins from this address")
+	ErrIPBanned   = errors.New("address banned after repeated failed logins")
+)
+
+// BlockedError is a login held back until a time
+type BlockedError struct {
+	Err   error
+	Until time.Time
+}
+
+func (e *BlockedError) Error() string { return e.Err.Error() }
+
+func (e *BlockedError) Unwrap() error { return e.Err }
+
+// RetryAfter is how long until the login may be tried again, rounded up to
+// the second; zero when that isn't known
+func (e *BlockedError) RetryAfter() time.Duration {
+	if e.Until.IsZero() {
+		return 0
+	}
+	wait := time.Until(e.Until).Truncate(time.Second) + time.Second
+	if wait < time.Second {
+		return time.Second
+	}
+	return wait
+}
+
+// AttemptCounter counts failed logins from an address that were recorded
+// elsewhere, such as wrong second-factor codes
+type AttemptCounter interface {
+	GetFailedAttempts(ip string, duration time.Duration) int
+}
+
+// Lock is a username or address being held back, as listed to admins
+type Lock struct {
+	Kind           string     `json:"kind"` // user or ip
+	Surface        Surface    `json:"surface,omitempty"`
+	Subject        string     `json:"subject"`
+	Failures       int        `json:"failures"`
+	LastFailure    time.Time  `json:"last_failure"`
+	LockedUntil    *time.Time `json:"locked_until,omitempty"`
+	BannedUntil    *time.Time `json:"banned_until,omitempty"`
+	FirewallRuleID string     `json:"firewall_rule_id,omitempty"`
+}
+
+// record is the recent failures of one username or address
+type record struct {
+	failures    []time.Time
+	lockedUntil time.Time
+	// others holds the addresses a username failed from, or the usernames
+	// an address failed against, with when they last did
+	others    map[string]time.Time
+	alertedAt time.Time
+	// clearedAt is when an admin last unlocked an address; failures
+	// counted elsewhere before then are ignored
+	clearedAt time.Time
+}
+
+// ban is the firewall rule keeping an address out
+type ban struct {
+	ruleID string
+	until  time.Time
+}
+
+// alert is a security alert raised by a failure, sent once the guard's
+// lock is released
+type alert struct {
+	kind, severity, description string
+	data                        map[string]interface{}
+}
+
+// Guard counts failed logins on every surface and holds back the usernames
+// and addresses they come from: first by making each attempt wait longer,
+// then by locking them out for a while, and at last by banning the address
+// in the firewall. State is kept in memory; panel user lockouts are also
+// saved on the user, by the caller.
+type Guard struct {
+	cfg      config.BruteForceConfig
+	events   *events.Emitter
+	firewall *firewall.Service
+	attempts AttemptCounter
+	trusted  []*net.IPNet
+	users    map[string]*record // surface/username
+	ips      map[string]*record
+	bans     map[string]*ban
+	mu       sync.Mutex
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// NewGuard creates a guard banning through fw. attempts, if not nil, adds
+// the failures it has counted to each address's own.
+func NewGuard(cfg config.BruteForceConfig, fw *firewall.Service, attempts AttemptCounter) *Guard {
+	g := &Guard{
+		cfg:      cfg,
+		events:   events.NewEmitter(),
+		firewall: fw,
+		attempts: attempts,
+		users:    make(map[string]*record),
+		ips:      make(map[string]*record),
+		bans:     make(map[string]*ban),
+		stopCh:   make(chan struct{}),
+	}
+	for _, entry := range cfg.TrustedIPs {
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			g.trusted = append(g.trusted, network)
+		} else {
+			fmt.Printf("warning: ignoring trusted address %q: %v\n", entry, err)
+		}
+	}
+	return g
+}
+
+// Start lifts expired bans in the background
+func (g *Guard) Start() {
+	go g.run()
+}
+
+// Stop stops lifting bans. Bans in place stay until lifted by hand.
+func (g *Guard) Stop() {
+	g.stopOnce.Do(func() { close(g.stopCh) })
+}
+
+func (g *Guard) run() {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-g.stopCh:
+			return
+		case <-ticker.C:
+			g.sweep(time.Now())
+		}
+	}
+}
+
+// Check reports whether a login may be tried now. It returns a
+// *BlockedError when the login must wait, or is locked out or banned.
+func (g *Guard) Check(surface Surface, username, ip string) error {
+	now := time.Now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	user := g.users[userKey(surface, username)]
+	var addr *record
+	if !g.isTrusted(ip) {
+		if b := g.bans[ip]; b != nil && now.Before(b.until) {
+			return &BlockedError{Err: ErrIPBanned, Until: b.until}
+		}
+		addr = g.ips[ip]
+		if addr != nil && now.Before(addr.lockedUntil) {
+			return &BlockedError{Err: ErrIPLocked, Until: addr.lockedUntil}
+		}
+	}
+	if user != nil && now.Before(user.lockedUntil) {
+		return &BlockedError{Err: ErrUserLocked, Until: user.lockedUntil}
+	}
+
+	var until time.Time
+	for _, rec := range []*record{user, addr} {
+		if rec == nil {
+			continue
+		}
+		if t := g.delayUntil(rec, now); t.After(until) {
+			until = t
+		}
+	}
+	if now.Before(until) {
+		return &BlockedError{Err: ErrThrottled, Until: until}
+	}
+	return nil
+}
+
+// Fail counts a failed login. It returns when the username is locked out
+// until if this failure locked it, and the zero time otherwise. Without a
+// username, as when a passkey fails before naming its user, only the
+// address is counted.
+func (g *Guard) Fail(surface Surface, username, ip, reason string) time.Time {
+	now := time.Now()
+	trusted := g.isTrusted(ip)
+	counted := 0
+	if g.attempts != nil && !trusted {
+		counted = g.attempts.GetFailedAttempts(ip, g.cfg.Window)
+	}
+
+	g.mu.Lock()
+	var alerts []alert
+	var locked time.Time
+	if username != "" {
+		user := g.record(g.users, userKey(surface, username))
+		user.fail(now, g.cfg.Window, ip)
+		if g.cfg.UserLockThreshold > 0 && len(user.failures) >= g.cfg.UserLockThreshold && !now.Before(user.lockedUntil) {
+			user.lockedUntil = now.Add(g.cfg.UserLockDuration)
+			locked = user.lockedUntil
+		}
+		if g.cfg.DistributedIPs > 0 && len(user.others) >= g.cfg.DistributedIPs && user.alertDue(now, g.cfg.Window) {
+			alerts = append(alerts, alert{
+				kind:        "distributed_brute_force",
+				severity:    "medium",
+				description: fmt.Sprintf("Failed %s logins for %s from %d addresses", surface, username, len(user.others)),
+				data:        map[string]interface{}{"surface": surface, "username": username, "addresses": keys(user.others)},
+			})
+		}
+	}
+
+	var banned *ban
+	if !trusted {
+		addr := g.record(g.ips, ip)
+		addr.fail(now, g.cfg.Window, string(surface)+"/"+username)
+		n := len(addr.failures)
+		if !addr.clearedAt.IsZero() && now.Sub(addr.clearedAt) < g.cfg.Window {
+			counted = 0
+		}
+		if counted > n {
+			n = counted
+		}
+		if g.cfg.IPLockThreshold > 0 && n >= g.cfg.IPLockThreshold && !now.Before(addr.lockedUntil) {
+			addr.lockedUntil = now.Add(g.cfg.IPLockDuration)
+		}
+		if g.cfg.IPBanThreshold > 0 && n >= g.cfg.IPBanThreshold && g.firewall != nil && g.bans[ip] == nil {
+			banned = g.banLocked(ip, n, now)
+		}
+		if g.cfg.StuffingUsernames > 0 && len(addr.others) >= g.cfg.StuffingUsernames && addr.alertDue(now, g.cfg.Window) {
+			alerts = append(alerts, alert{
+				kind:        "credential_stuffing",
+				severity:    "high",
+				description: fmt.Sprintf("Failed logins from %s against %d usernames", ip, len(addr.others)),
+				data:        map[string]interface{}{"ip": ip, "usernames": keys(addr.others)},
+			})
+		}
+	}
+	g.mu.Unlock()
+
+	data := map[string]interface{}{
+		"username": username,
+		"reason":   reason,
+		"surface":  surface,
+	}
+	if !locked.IsZero() {
+		data["locked_until"] = locked
+	}
+	g.events.EmitFailed(events.EventLoginFailed, reason, events.EmitOptions{
+		Actor:     username,
+		ActorType: "user",
+		ActorIP:   ip,
+		Data:      data,
+	})
+	if banned != nil {
+		alerts = append(alerts, alert{
+			kind:        "brute_force_ban",
+			severity:    "high",
+			description: fmt.Sprintf("Banned %s after repeated failed logins", ip),
+			data:        map[string]interface{}{"ip": ip, "firewall_rule_id": banned.ruleID, "until": banned.until},
+		})
+	}
+	for _, a := range alerts {
+		if err := g.events.CreateSecurityAlert(a.kind, a.severity, a.description, 0, a.data); err != nil {
+			fmt.Printf("warning: failed to save security alert: %v\n", err)
+		}
+	}
+	return locked
+}
+
+// Succeed forgets a username's failures once it has logged in. The
+// address's are kept, so one good login doesn't wipe a run of guesses at
+// other usernames.
+func (g *Guard) Succeed(surface Surface, username, ip string) {
+	g.mu.Lock()
+	delete(g.users, userKey(surface, username))
+	g.mu.Unlock()
+}
+
+// UnlockUser lifts a username's lockout on every surface and forgets its
+// failures. It reports whether there was anything to lift.
+func (g *Guard) UnlockUser(username, actor, actorIP string) bool {
+	username = strings.ToLower(username)
+	g.mu.Lock()
+	found := false
+	for key := range g.users {
+		if _, name, _ := strings.Cut(key, "/"); name == username {
+			delete(g.users, key)
+			found = true
+		}
+	}
+	g.mu.Unlock()
+
+	g.events.EmitSuccess(events.EventLoginUnlock, events.EmitOptions{
+		Actor:     actor,
+		ActorType: "user",
+		ActorIP:   actorIP,
+		Data:      map[string]interface{}{"username": username},
+	})
+	return found
+}
+
+// UnlockIP lifts an address's lockout and firewall ban and forgets its
+// failures. It reports whether there was anything to lift.
+func (g *Guard) UnlockIP(ip, actor, actorIP string) (bool, error) {
+	g.mu.Lock()
+	found := false
+	if addr := g.ips[ip]; addr != nil {
+		*addr = record{clearedAt: time.Now(), others: make(map[string]time.Time)}
+		found = true
+	}
+	b := g.bans[ip]
+	delete(g.bans, ip)
+	g.mu.Unlock()
+
+	opts := events.EmitOptions{
+		Actor:     actor,
+		ActorType: "user",
+		ActorIP:   actorIP,
+		Data:      map[string]interface{}{"ip": ip},
+	}
+	if b != nil {
+		found = true
+		if err := g.firewall.DeleteRule(b.ruleID); err != nil && err.Error() != "rule not found" {
+			g.events.EmitFailed(events.EventLoginUnlock, err.Error(), opts)
+			return found, err
+		}
+	}
+	g.events.EmitSuccess(events.EventLoginUnlock, opts)
+	return found, nil
+}
+
+// Locks lists the usernames and addresses currently held back: waiting
+// between attempts, locked out or banned
+func (g *Guard) Locks() []Lock {
+	now := time.Now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	locks := make([]Lock, 0)
+	for key, rec := range g.users {
+		surface, username, _ := strings.Cut(key, "/")
+		if lock, ok := g.lock(rec, now); ok {
+			lock.Kind, lock.Surface, lock.Subject = "user", Surface(surface), username
+			locks = append(locks, lock)
+		}
+	}
+	for ip, rec := range g.ips {
+		lock, ok := g.lock(rec, now)
+		if b := g.bans[ip]; b != nil {
+			until := b.until
+			lock.BannedUntil, lock.FirewallRuleID, ok = &until, b.ruleID, true
+		}
+		if ok {
+			lock.Kind, lock.Subject = "ip", ip
+			locks = append(locks, lock)
+		}
+	}
+	sort.Slice(locks, func(i, j int) bool {
+		if locks[i].Kind != locks[j].Kind {
+			return locks[i].Kind < locks[j].Kind
+		}
+		return locks[i].Subject < locks[j].Subject
+	})
+	return locks
+}
+
+// lock describes a record, reporting whether it is held back at all
+func (g *Guard) lock(rec *record, now time.Time) (Lock, bool) {
+	rec.prune(now, g.cfg.Window)
+	lock := Lock{Failures: len(rec.failures)}
+	if n := len(rec.failures); n > 0 {
+		lock.LastFailure = rec.failures[n-1]
+	}
+	held := g.cfg.DelayAfter > 0 && lock.Failures >= g.cfg.DelayAfter
+	if now.Before(rec.lockedUntil) {
+		until := rec.lockedUntil
+		lock.LockedUntil = &until
+		held = true
+	}
+	return lock, held
+}
+
+// banLocked puts in a firewall rule denying ip; g.mu must be held
+func (g *Guard) banLocked(ip string, failures int, now time.Time) *ban {
+	rule, err := g.firewall.CreateRule(nil, &models.FirewallRuleCreateRequest{
+		ChainName:   "INPUT",
+		Priority:    1,
+		Action:      models.FirewallActionDeny,
+		Protocol:    models.FirewallProtocolAny,
+		SourceIP:    ip,
+		Description: fmt.Sprintf("Brute force: %d failed logins", failures),
+	})
+	if err != nil {
+		fmt.Printf("warning: failed to ban %s: %v\n", ip, err)
+		return nil
+	}
+	b := &ban{ruleID: rule.ID, until: now.Add(g.cfg.IPBanDuration)}
+	g.bans[ip] = b
+	g.firewall.EmitIntrusionEvent("brute_force", "high", ip, "", rule.Description, "")
+	return b
+}
+
+// sweep lifts the bans that have run out and forgets records with nothing
+// left to hold back
+func (g *Guard) sweep(now time.Time) {
+	g.mu.Lock()
+	var expired []string
+	for ip, b := range g.bans {
+		if !now.Before(b.until) {
+			expired = append(expired, b.ruleID)
+			delete(g.bans, ip)
+		}
+	}
+	for _, records := range []map[string]*record{g.users, g.ips} {
+		for key, rec := range records {
+			rec.prune(now, g.cfg.Window)
+			if len(rec.failures) == 0 && !now.Before(rec.lockedUntil) && now.Sub(rec.clearedAt) >= g.cfg.Window {
+				delete(records, key)
+			}
+		}
+	}
+	g.mu.Unlock()
+
+	for _, id := range expired {
+		if err := g.firewall.DeleteRule(id); err != nil && err.Error() != "rule not found" {
+			fmt.Printf("warning: failed to lift ban %s: %v\n", id, err)
+		}
+	}
+}
+
+// delayUntil is when a record's next attempt may be made: the last
+// failure plus a delay doubling with each failure past DelayAfter
+func (g *Guard) delayUntil(rec *record, now time.Time) time.Time {
+	rec.prune(now, g.cfg.Window)
+	n := len(rec.failures)
+	if g.cfg.DelayAfter <= 0 || n < g.cfg.DelayAfter {
+		return time.Time{}
+	}
+	shift := n - g.cfg.DelayAfter
+	if shift > maxDelayShift {
+		shift = maxDelayShift
+	}
+	delay := g.cfg.BaseDelay << shift
+	if g.cfg.MaxDelay > 0 && delay > g.cfg.MaxDelay {
+		delay = g.cfg.MaxDelay
+	}
+	return rec.failures[n-1].Add(delay)
+}
+
+// isTrusted reports whether ip is never locked or banned
+func (g *Guard) isTrusted(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range g.trusted {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// record returns the record under key, making it if need be
+func (g *Guard) record(records map[string]*record, key string) *record {
+	rec := records[key]
+	if rec == nil {
+		rec = &record{others: make(map[string]time.Time)}
+		records[key] = rec
+	}
+	return rec
+}
+
+// fail adds a failure involving other
+func (r *record) fail(now time.Time, window time.Duration, other string) {
+	r.prune(now, window)
+	r.failures = append(r.failures, now)
+	if other != "" {
+		r.others[other] = now
+	}
+}
+
+// prune forgets failures older than window
+func (r *record) prune(now time.Time, window time.Duration) {
+	cutoff := now.Add(-window)
+	i := 0
+	for i < len(r.failures) && !r.failures[i].After(cutoff) {
+		i++
+	}
+	r.failures = r.failures[i:]
+	for other, at := range r.others {
+		if !at.After(cutoff) {
+			delete(r.others, other)
+		}
+	}
+}
+
+// alertDue reports whether an alert may be raised for the record, at most
+// once a window, and notes that it was
+func (r *record) alertDue(now time.Time, window time.Duration) bool {
+	if !r.alertedAt.IsZero() && now.Sub(r.alertedAt) < window {
+		return false
+	}
+	r.alertedAt = now
+	return true
+}
+
+// userKey is the key of a username's record on a surface. Case is folded
+// so a username can't dodge its count by changing case.
+func userKey(surface Surface, username string) string {
+	return string(surface) + "/" + strings.ToLower(username)
+}
+
+func keys(m map[string]time.Time) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
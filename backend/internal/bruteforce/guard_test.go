@@ -0,0 +1,316 @@
+package bruteforce
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/iSundram/OweHost/internal/firewall"
+	"github.com/iSundram/OweHost/internal/storage/events"
+	"github.com/iSundram/OweHost/pkg/config"
+	"github.com/iSundram/OweHost/pkg/models"
+)
+
+// newTestGuard returns a guard that keeps its events and alerts in a
+// temporary directory. trusted are CIDRs.
+func newTestGuard(t *testing.T, cfg config.BruteForceConfig, fw *firewall.Service, trusted ...string) *Guard {
+	t.Helper()
+	dir := t.TempDir()
+	g := &Guard{
+		cfg:      cfg,
+		events:   events.NewEmitterWithStore(events.NewStoreWithPath(filepath.Join(dir, "events"), filepath.Join(dir, "alerts")), "test"),
+		firewall: fw,
+		users:    make(map[string]*record),
+		ips:      make(map[string]*record),
+		bans:     make(map[string]*ban),
+		stopCh:   make(chan struct{}),
+	}
+	for _, cidr := range trusted {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		g.trusted = append(g.trusted, network)
+	}
+	return g
+}
+
+func TestGuard_FailWithoutUsername(t *testing.T) {
+	g := newTestGuard(t, config.BruteForceConfig{
+		Window:            time.Hour,
+		UserLockThreshold: 2,
+		UserLockDuration:  time.Hour,
+		IPLockThreshold:   3,
+		IPLockDuration:    time.Hour,
+	}, nil)
+
+	// Failed passkeys name no user; they count against the address only
+	for i := 0; i < 2; i++ {
+		if locked := g.Fail(SurfacePanel, "", "192.0.2.1", "invalid_passkey"); !locked.IsZero() {
+			t.Fatalf("Expected no user to be locked, got %v", locked)
+		}
+	}
+	if len(g.users) != 0 {
+		t.Errorf("Expected no user records, got %v", g.users)
+	}
+	if err := g.Check(SurfacePanel, "alice", "192.0.2.2"); err != nil {
+		t.Errorf("Expected logins from other addresses to go ahead, got %v", err)
+	}
+
+	g.Fail(SurfacePanel, "", "192.0.2.1", "invalid_passkey")
+	if err := g.Check(SurfacePanel, "", "192.0.2.1"); !errors.Is(err, ErrIPLocked) {
+		t.Errorf("Expected the address to be locked out, got %v", err)
+	}
+}
+
+func TestDelayUntil(t *testing.T) {
+	g := newTestGuard(t, config.BruteForceConfig{
+		Window:     time.Hour,
+		DelayAfter: 2,
+		BaseDelay:  time.Second,
+		MaxDelay:   4 * time.Second,
+	}, nil)
+	now := time.Now()
+
+	tests := []struct {
+		failures int
+		want     time.Duration // after the last failure; 0 for none
+	}{
+		{0, 0},
+		{1, 0},
+		{2, time.Second},
+		{3, 2 * time.Second},
+		{4, 4 * time.Second},
+		{5, 4 * time.Second},
+		{100, 4 * time.Second},
+	}
+	for _, tt := range tests {
+		rec := &record{others: make(map[string]time.Time)}
+		for i := 0; i < tt.failures; i++ {
+			rec.failures = append(rec.failures, now)
+		}
+		got := g.delayUntil(rec, now)
+		if tt.want == 0 {
+			if !got.IsZero() {
+				t.Errorf("%d failures: expected no delay, got %v", tt.failures, got.Sub(now))
+			}
+			continue
+		}
+		if got.Sub(now) != tt.want {
+			t.Errorf("%d failures: expected %v, got %v", tt.failures, tt.want, got.Sub(now))
+		}
+	}
+
+	// Failures that have left the window no longer count
+	rec := &record{failures: []time.Time{now.Add(-2 * time.Hour), now.Add(-2 * time.Hour), now}, others: make(map[string]time.Time)}
+	if got := g.delayUntil(rec, now); !got.IsZero() {
+		t.Errorf("Expected old failures to be forgotten, got a delay of %v", got.Sub(now))
+	}
+}
+
+func TestGuard_Throttle(t *testing.T) {
+	g := newTestGuard(t, config.BruteForceConfig{
+		Window:     time.Hour,
+		DelayAfter: 2,
+		BaseDelay:  time.Minute,
+		MaxDelay:   time.Hour,
+	}, nil)
+
+	g.Fail(SurfacePanel, "alice", "192.0.2.1", "invalid_password")
+	if err := g.Check(SurfacePanel, "alice", "192.0.2.1"); err != nil {
+		t.Fatalf("Expected one failure not to be throttled, got %v", err)
+	}
+	g.Fail(SurfacePanel, "alice", "192.0.2.1", "invalid_password")
+
+	tests := []struct {
+		name     string
+		surface  Surface
+		username string
+		ip       string
+		want     error
+	}{
+		{"same user and address", SurfacePanel, "alice", "192.0.2.1", ErrThrottled},
+		{"username case", SurfacePanel, "ALICE", "192.0.2.2", ErrThrottled},
+		{"same address", SurfaceFTP, "bob", "192.0.2.1", ErrThrottled},
+		{"other surface", SurfaceFTP, "alice", "192.0.2.2", nil},
+		{"other user and address", SurfacePanel, "bob", "192.0.2.2", nil},
+	}
+	for _, tt := range tests {
+		err := g.Check(tt.surface, tt.username, tt.ip)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, err)
+			continue
+		}
+		var blocked *BlockedError
+		if errors.As(err, &blocked) && (blocked.RetryAfter() <= 0 || blocked.RetryAfter() > time.Minute+time.Second) {
+			t.Errorf("%s: expected to retry within a minute, got %v", tt.name, blocked.RetryAfter())
+		}
+	}
+}
+
+func TestGuard_LockAndBan(t *testing.T) {
+	fw := firewall.NewService()
+	g := newTestGuard(t, config.BruteForceConfig{
+		Window:            time.Hour,
+		UserLockThreshold: 3,
+		UserLockDuration:  time.Hour,
+		IPLockThreshold:   5,
+		IPLockDuration:    time.Hour,
+		IPBanThreshold:    6,
+		IPBanDuration:     time.Hour,
+	}, fw)
+	const ip = "192.0.2.1"
+
+	steps := []struct {
+		username   string
+		userLocked bool
+		check      string
+		want       error
+	}{
+		{"alice", false, "alice", nil},
+		{"alice", false, "alice", nil},
+		{"alice", true, "alice", ErrUserLocked},
+		{"bob", false, "bob", nil},
+		{"carol", false, "dave", ErrIPLocked},
+		{"dave", false, "dave", ErrIPBanned},
+	}
+	for i, step := range steps {
+		locked := g.Fail(SurfacePanel, step.username, ip, "invalid_password")
+		if !locked.IsZero() != step.userLocked {
+			t.Errorf("Failure %d: expected user locked=%v, got %v", i+1, step.userLocked, locked)
+		}
+		if err := g.Check(SurfacePanel, step.check, ip); !errors.Is(err, step.want) {
+			t.Errorf("Failure %d: expected %v for %s, got %v", i+1, step.want, step.check, err)
+		}
+	}
+
+	rules := fw.ListRules("INPUT")
+	if len(rules) != 1 || rules[0].SourceIP != ip || rules[0].Action != models.FirewallActionDeny {
+		t.Fatalf("Expected a deny rule for %s, got %+v", ip, rules)
+	}
+	if err := g.Check(SurfacePanel, "alice", "192.0.2.2"); !errors.Is(err, ErrUserLocked) {
+		t.Errorf("Expected alice to stay locked from other addresses, got %v", err)
+	}
+
+	locks := g.Locks()
+	if len(locks) != 2 || locks[0].Kind != "ip" || locks[0].BannedUntil == nil || locks[0].FirewallRuleID != rules[0].ID ||
+		locks[1].Kind != "user" || locks[1].Subject != "alice" || locks[1].LockedUntil == nil {
+		t.Errorf("Unexpected locks %+v", locks)
+	}
+
+	if found, err := g.UnlockIP(ip, "usr-admin", "192.0.2.9"); !found || err != nil {
+		t.Errorf("Expected the address to be unlocked, got %v %v", found, err)
+	}
+	if rules := fw.ListRules("INPUT"); len(rules) != 0 {
+		t.Errorf("Expected the ban's rule to be deleted, got %+v", rules)
+	}
+	if err := g.Check(SurfacePanel, "carol", ip); err != nil {
+		t.Errorf("Expected the address to be let back in, got %v", err)
+	}
+	if !g.UnlockUser("ALICE", "usr-admin", "192.0.2.9") {
+		t.Error("Expected alice to be unlocked")
+	}
+	if err := g.Check(SurfacePanel, "alice", ip); err != nil {
+		t.Errorf("Expected alice to be let back in, got %v", err)
+	}
+	if g.UnlockUser("alice", "usr-admin", "192.0.2.9") {
+		t.Error("Expected nothing left to unlock")
+	}
+}
+
+func TestGuard_Sweep(t *testing.T) {
+	fw := firewall.NewService()
+	g := newTestGuard(t, config.BruteForceConfig{
+		Window:         time.Minute,
+		IPBanThreshold: 1,
+		IPBanDuration:  time.Hour,
+	}, fw)
+
+	g.Fail(SurfaceSFTP, "root", "192.0.2.1", "invalid_password")
+	g.sweep(time.Now())
+	if len(fw.ListRules("INPUT")) != 1 || len(g.ips) != 1 {
+		t.Fatal("Expected the ban and its record to be kept while they last")
+	}
+
+	g.sweep(time.Now().Add(2 * time.Hour))
+	if len(fw.ListRules("INPUT")) != 0 || len(g.bans) != 0 {
+		t.Error("Expected the expired ban to be lifted")
+	}
+	if len(g.ips) != 0 || len(g.users) != 0 {
+		t.Error("Expected stale records to be forgotten")
+	}
+}
+
+func TestGuard_Trusted(t *testing.T) {
+	fw := firewall.NewService()
+	g := newTestGuard(t, config.BruteForceConfig{
+		Window:            time.Hour,
+		UserLockThreshold: 3,
+		UserLockDuration:  time.Hour,
+		IPLockThreshold:   1,
+		IPLockDuration:    time.Hour,
+		IPBanThreshold:    1,
+		IPBanDuration:     time.Hour,
+	}, fw, "10.0.0.0/8", "2001:db8::/32")
+
+	for _, ip := range []string{"10.1.2.3", "2001:db8::1"} {
+		for i := 0; i < 3; i++ {
+			g.Fail(SurfacePanel, "alice", ip, "invalid_password")
+		}
+		if err := g.Check(SurfacePanel, "bob", ip); err != nil {
+			t.Errorf("%s: expected a trusted address never to be locked, got %v", ip, err)
+		}
+	}
+	if len(fw.ListRules("INPUT")) != 0 || len(g.ips) != 0 {
+		t.Error("Expected trusted addresses not to be counted")
+	}
+	// Their usernames still are
+	if err := g.Check(SurfacePanel, "alice", "10.1.2.3"); !errors.Is(err, ErrUserLocked) {
+		t.Errorf("Expected alice to be locked, got %v", err)
+	}
+	if err := g.Check(SurfacePanel, "bob", "192.0.2.1"); err != nil {
+		t.Errorf("Expected untrusted addresses with no failures to go ahead, got %v", err)
+	}
+}
+
+// countedAttempts is failures counted elsewhere, such as wrong 2FA codes
+type countedAttempts int
+
+func (c countedAttempts) GetFailedAttempts(ip string, duration time.Duration) int { return int(c) }
+
+func TestGuard_SucceedAndCountedAttempts(t *testing.T) {
+	g := newTestGuard(t, config.BruteForceConfig{
+		Window:            time.Hour,
+		UserLockThreshold: 2,
+		UserLockDuration:  time.Hour,
+		IPLockThreshold:   5,
+		IPLockDuration:    time.Hour,
+	}, nil)
+
+	g.Fail(SurfacePanel, "alice", "192.0.2.1", "invalid_password")
+	g.Succeed(SurfacePanel, "Alice", "192.0.2.1")
+	if len(g.users) != 0 {
+		t.Errorf("Expected a login to clear the user's failures, got %v", g.users)
+	}
+	if len(g.ips) != 1 || len(g.ips["192.0.2.1"].failures) != 1 {
+		t.Error("Expected the address's failures to be kept")
+	}
+
+	// Failures counted elsewhere lock the address once they reach the limit
+	g.attempts = countedAttempts(5)
+	g.Fail(SurfacePanel, "bob", "192.0.2.1", "invalid_password")
+	if err := g.Check(SurfacePanel, "carol", "192.0.2.1"); !errors.Is(err, ErrIPLocked) {
+		t.Fatalf("Expected counted failures to lock the address, got %v", err)
+	}
+
+	// Unlocking by hand ignores what was counted elsewhere before it
+	if _, err := g.UnlockIP("192.0.2.1", "usr-admin", "192.0.2.9"); err != nil {
+		t.Fatal(err)
+	}
+	g.Fail(SurfacePanel, "dave", "192.0.2.1", "invalid_password")
+	if err := g.Check(SurfacePanel, "carol", "192.0.2.1"); err != nil {
+		t.Errorf("Expected the unlocked address to start over, got %v", err)
+	}
+}
@@ -0,0 +1,191 @@
+// Package bruteforce provides login throttling and lockouts for OweHost
+package bruteforce
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// authLogScanInterval is how often the logs are checked for new failures
+const authLogScanInterval = 5 * time.Second
+
+// maxAuthLogLine bounds an unterminated line kept between scans
+const maxAuthLogLine = 64 << 10
+
+// Logs failed FTP and SFTP logins are read from. sshd logs to auth.log or
+// secure depending on the distribution; pure-ftpd logs through syslog, and
+// vsftpd to its own log. Whichever files exist are followed.
+var authLogPaths = []string{
+	"/var/log/auth.log",
+	"/var/log/secure",
+	"/var/log/syslog",
+	"/var/log/messages",
+	"/var/log/vsftpd.log",
+}
+
+// authFailure matches one server's log line for a failed login
+type authFailure struct {
+	surface Surface
+	re      *regexp.Regexp
+}
+
+// Failed logins, by server. sshd serves SFTP and shells alike; both count
+// as SFTP logins.
+var authFailures = []authFailure{
+	// Failed password for invalid user bob from 192.0.2.1 port 4242 ssh2
+	{SurfaceSFTP, regexp.MustCompile(`sshd\[\d+\]: Failed \S+ for (?:invalid user )?(?P<user>\S*) from (?P<ip>\S+) port `)},
+	// pure-ftpd: (?@192.0.2.1) [WARNING] Authentication failed for user [bob]
+	{SurfaceFTP, regexp.MustCompile(`pure-ftpd: \(\S*@(?P<ip>\S+)\) \[WARNING\] Authentication failed for user \[(?P<user>[^\]]*)\]`)},
+	// [bob] FAIL LOGIN: Client "::ffff:192.0.2.1"
+	{SurfaceFTP, regexp.MustCompile(`\[(?P<user>[^\]]*)\] FAIL LOGIN: Client "(?:::ffff:)?(?P<ip>[^"]+)"`)},
+}
+
+// AuthLogWatcher follows the FTP and SSH servers' logs and counts the
+// failed logins in them. The servers check passwords themselves, so the
+// guard can't hold a login back before it is tried; its firewall bans are
+// what keep an address out.
+type AuthLogWatcher struct {
+	guard    *Guard
+	paths    []string
+	files    map[string]*authLogFile
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// authLogFile is a log being followed. It stays open, so lines written
+// just before logrotate moves it are still read.
+type authLogFile struct {
+	f       *os.File
+	offset  int64
+	partial []byte
+}
+
+// NewAuthLogWatcher creates a watcher feeding guard
+func NewAuthLogWatcher(guard *Guard) *AuthLogWatcher {
+	return &AuthLogWatcher{
+		guard:  guard,
+		paths:  authLogPaths,
+		files:  make(map[string]*authLogFile),
+		stopCh: make(chan struct{}),
+	}
+}
+
+// Start follows the logs in the background, from their current end:
+// failures logged before the panel started have been counted already, or
+// are too old to matter.
+func (w *AuthLogWatcher) Start() {
+	w.scan(true)
+	go w.run()
+}
+
+// Stop stops following the logs
+func (w *AuthLogWatcher) Stop() {
+	w.stopOnce.Do(func() { close(w.stopCh) })
+}
+
+func (w *AuthLogWatcher) run() {
+	ticker := time.NewTicker(authLogScanInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stopCh:
+			for path, file := range w.files {
+				file.f.Close()
+				delete(w.files, path)
+			}
+			return
+		case <-ticker.C:
+			w.scan(false)
+		}
+	}
+}
+
+// scan reads what was appended to each log since the last scan. A log
+// replaced by rotation is read to its end before the new file is opened;
+// one truncated in place is read again from the start.
+func (w *AuthLogWatcher) scan(initial bool) {
+	for _, path := range w.paths {
+		file := w.files[path]
+		info, err := os.Stat(path)
+		if err != nil {
+			if file != nil {
+				w.read(file)
+				file.f.Close()
+				delete(w.files, path)
+			}
+			continue
+		}
+
+		if file != nil {
+			current, err := file.f.Stat()
+			if err == nil && os.SameFile(current, info) {
+				if info.Size() < file.offset {
+					file.offset, file.partial = 0, nil
+				}
+				w.read(file)
+				continue
+			}
+			w.read(file)
+			file.f.Close()
+			delete(w.files, path)
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		file = &authLogFile{f: f}
+		if initial {
+			file.offset = info.Size()
+		}
+		w.files[path] = file
+		w.read(file)
+	}
+}
+
+// read counts the failures in the complete lines appended to a log
+func (w *AuthLogWatcher) read(file *authLogFile) {
+	data, err := io.ReadAll(io.NewSectionReader(file.f, file.offset, 1<<62))
+	if err != nil || len(data) == 0 {
+		return
+	}
+	file.offset += int64(len(data))
+
+	data = append(file.partial, data...)
+	end := bytes.LastIndexByte(data, '\n')
+	if end < 0 {
+		file.partial = data
+		if len(file.partial) > maxAuthLogLine {
+			file.partial = nil
+		}
+		return
+	}
+	file.partial = append([]byte(nil), data[end+1:]...)
+
+	for _, line := range bytes.Split(data[:end], []byte("\n")) {
+		w.line(string(line))
+	}
+}
+
+// line counts a log line if it records a failed login
+func (w *AuthLogWatcher) line(line string) {
+	for _, failure := range authFailures {
+		m := failure.re.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		username := m[failure.re.SubexpIndex("user")]
+		ip := strings.TrimSpace(m[failure.re.SubexpIndex("ip")])
+		if ip == "" {
+			return
+		}
+		w.guard.Fail(failure.surface, username, ip, "invalid_credentials")
+		return
+	}
+}
@@ -80,6 +80,18 @@ func (s *Service) ListByUser(userID string) []*models.CronJob {
 	return s.byUser[userID]
 }
 
+// ListAll lists every user's cron jobs
+func (s *Service) ListAll() []*models.CronJob {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	jobs := make([]*models.CronJob, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, job)
+	}
+	return jobs
+}
+
 // Update updates a cron job
 func (s *Service) Update(id string, req *models.CronJobUpdateRequest) (*models.CronJob, error) {
 	s.mu.Lock()
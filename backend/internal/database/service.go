@@ -304,6 +304,18 @@ func (s *Service) DeleteAllByUser(userID string) error {
 	return nil
 }
 
+// GetBackup gets a database backup by ID
+func (s *Service) GetBackup(id string) (*models.DatabaseBackup, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	backup, exists := s.backups[id]
+	if !exists {
+		return nil, errors.New("backup not found")
+	}
+	return backup, nil
+}
+
 // ListBackups lists backups for a database
 func (s *Service) ListBackups(dbID string) []*models.DatabaseBackup {
 	s.mu.RLock()
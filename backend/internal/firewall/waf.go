@@ -0,0 +1,183 @@
+// Package firewall provides firewall and security services for OweHost
+package firewall
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iSundram/OweHost/internal/storage/web"
+)
+
+// wafScanInterval is how often audit logs are checked for new entries
+const wafScanInterval = 5 * time.Second
+
+// maxWAFEntry bounds an unterminated line kept between scans
+const maxWAFEntry = 1 << 20
+
+// WAFWatcher follows the sites' ModSecurity audit logs and records each new
+// entry as an intrusion event
+type WAFWatcher struct {
+	firewall *Service
+	dir      string
+	files    map[string]*wafLogFile
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// wafLogFile is an audit log being followed. The file stays open, so
+// entries written just before logrotate moves it are still read.
+type wafLogFile struct {
+	f       *os.File
+	offset  int64
+	partial []byte
+}
+
+// NewWAFWatcher creates a watcher feeding firewall
+func NewWAFWatcher(firewall *Service) *WAFWatcher {
+	return &WAFWatcher{
+		firewall: firewall,
+		dir:      web.WAFLogDir,
+		files:    make(map[string]*wafLogFile),
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// Start follows the audit logs in the background. Entries already in the
+// logs were reported before the panel restarted, so reading starts at
+// their current end.
+func (w *WAFWatcher) Start() {
+	w.scan(true)
+	go w.run()
+}
+
+// Stop stops following the logs
+func (w *WAFWatcher) Stop() {
+	w.stopOnce.Do(func() { close(w.stopCh) })
+}
+
+func (w *WAFWatcher) run() {
+	ticker := time.NewTicker(wafScanInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stopCh:
+			for path, file := range w.files {
+				file.f.Close()
+				delete(w.files, path)
+			}
+			return
+		case <-ticker.C:
+			w.scan(false)
+		}
+	}
+}
+
+// scan reads what was appended to each log since the last scan. A log
+// replaced by rotation is read to its end before the new file is opened;
+// one truncated in place is read again from the start.
+func (w *WAFWatcher) scan(initial bool) {
+	paths, _ := filepath.Glob(filepath.Join(w.dir, "*.log"))
+	seen := make(map[string]bool, len(paths))
+
+	for _, path := range paths {
+		seen[path] = true
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+
+		file := w.files[path]
+		if file != nil {
+			current, err := file.f.Stat()
+			if err == nil && os.SameFile(current, info) {
+				if info.Size() < file.offset {
+					file.offset, file.partial = 0, nil
+				}
+				w.read(path, file)
+				continue
+			}
+			w.read(path, file)
+			file.f.Close()
+			delete(w.files, path)
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		file = &wafLogFile{f: f}
+		if initial {
+			file.offset = info.Size()
+		}
+		w.files[path] = file
+		w.read(path, file)
+	}
+
+	// Logs of deleted sites
+	for path, file := range w.files {
+		if !seen[path] {
+			w.read(path, file)
+			file.f.Close()
+			delete(w.files, path)
+		}
+	}
+}
+
+// read emits the complete entries appended to a log
+func (w *WAFWatcher) read(path string, file *wafLogFile) {
+	data, err := io.ReadAll(io.NewSectionReader(file.f, file.offset, 1<<62))
+	if err != nil || len(data) == 0 {
+		return
+	}
+	file.offset += int64(len(data))
+
+	data = append(file.partial, data...)
+	end := bytes.LastIndexByte(data, '\n')
+	if end < 0 {
+		file.partial = data
+		if len(file.partial) > maxWAFEntry {
+			file.partial = nil
+		}
+		return
+	}
+	file.partial = append([]byte(nil), data[end+1:]...)
+
+	site := strings.TrimSuffix(filepath.Base(path), ".log")
+	for _, line := range bytes.Split(data[:end], []byte("\n")) {
+		event, err := web.ParseWAFEntry(line)
+		if err != nil {
+			continue
+		}
+		w.emit(site, event, string(line))
+	}
+}
+
+// emit records a ModSecurity entry as an intrusion event
+func (w *WAFWatcher) emit(site string, event *web.WAFEvent, raw string) {
+	eventType, action := "waf_detect", "detected"
+	if event.Blocked {
+		eventType, action = "waf_block", "blocked"
+	}
+	host := event.Host
+	if host == "" {
+		host = site
+	}
+
+	rules := make([]string, 0, len(event.Rules))
+	for _, r := range event.Rules {
+		rules = append(rules, fmt.Sprintf("%d %s", r.ID, r.Message))
+	}
+	description := fmt.Sprintf("Web application firewall %s %s %s on %s", action, event.Method, event.URI, host)
+	if len(rules) > 0 {
+		description += ": " + strings.Join(rules, "; ")
+	}
+
+	w.firewall.EmitIntrusionEvent(eventType, event.Severity, event.ClientIP, event.ServerIP, description, raw)
+}
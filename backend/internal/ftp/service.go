@@ -6,6 +6,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/iSundram/OweHost/internal/bruteforce"
 	"github.com/iSundram/OweHost/pkg/models"
 	"github.com/iSundram/OweHost/pkg/utils"
 )
@@ -17,6 +18,7 @@ type Service struct {
 	byUsername map[string]*models.FTPAccount
 	sessions   map[string]*models.FTPSession
 	config     *models.FTPConfig
+	guard      *bruteforce.Guard
 	mu         sync.RWMutex
 }
 
@@ -221,8 +223,31 @@ func (s *Service) Unsuspend(id string) error {
 	return nil
 }
 
+// SetLoginGuard has logins checked against guard's limits on failures
+func (s *Service) SetLoginGuard(guard *bruteforce.Guard) {
+	s.guard = guard
+}
+
 // ValidateCredentials validates FTP login credentials
 func (s *Service) ValidateCredentials(username, password, remoteIP string) (*models.FTPAccount, error) {
+	if s.guard == nil {
+		return s.checkCredentials(username, password, remoteIP)
+	}
+	if err := s.guard.Check(bruteforce.SurfaceFTP, username, remoteIP); err != nil {
+		return nil, err
+	}
+	account, err := s.checkCredentials(username, password, remoteIP)
+	if err != nil {
+		s.guard.Fail(bruteforce.SurfaceFTP, username, remoteIP, err.Error())
+		return nil, err
+	}
+	s.guard.Succeed(bruteforce.SurfaceFTP, username, remoteIP)
+	return account, nil
+}
+
+// checkCredentials checks a login against the account's password and
+// address whitelist
+func (s *Service) checkCredentials(username, password, remoteIP string) (*models.FTPAccount, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
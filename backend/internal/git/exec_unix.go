@@ -0,0 +1,32 @@
+//go:build !windows
+
+package git
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// runAs makes cmd execute as the given account user when the panel runs as root
+func runAs(cmd *exec.Cmd, uid, gid int) {
+	if os.Geteuid() != 0 || uid <= 0 {
+		return
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+}
+
+// killProcessGroup makes cancelling cmd kill everything it spawned, so a
+// timed-out build does not leave npm or composer children running
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build windows
+
+package git
+
+import "os/exec"
+
+// runAs is a no-op on platforms without POSIX credentials
+func runAs(cmd *exec.Cmd, uid, gid int) {}
+
+// killProcessGroup relies on the default cancellation, which kills cmd only
+func killProcessGroup(cmd *exec.Cmd) {}
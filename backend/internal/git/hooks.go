@@ -0,0 +1,97 @@
+// Package git provides Git version control management for OweHost
+package git
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iSundram/OweHost/pkg/models"
+)
+
+// postReceiveHook deploys DefaultBranch when auto-deploy is enabled. The deploy
+// settings live in the repository config so the hook works for SSH and HTTP
+// pushes alike, without calling back into the panel.
+const postReceiveHook = `#!/bin/sh
+# Generated by OweHost - changes will be overwritten
+
+auto=$(git config --bool --get owehost.autodeploy)
+branch=$(git config --get owehost.deploybranch)
+target=$(git config --get owehost.deploypath)
+
+[ "$auto" = "true" ] && [ -n "$branch" ] && [ -n "$target" ] || exit 0
+
+while read oldrev newrev refname; do
+    if [ "$refname" = "refs/heads/$branch" ] && [ "$newrev" != "%s" ]; then
+        exec %s git-deploy -repo "$(pwd)" -path "$target" -rev "$newrev"
+    fi
+done
+`
+
+// installHooks writes the OweHost hooks into a bare repository
+func installHooks(o *owner, repoPath string) error {
+	hooksDir := filepath.Join(repoPath, "hooks")
+	if err := ensureDir(o, hooksDir); err != nil {
+		return fmt.Errorf("failed to create hooks directory: %w", err)
+	}
+
+	path := filepath.Join(hooksDir, "post-receive")
+	script := fmt.Sprintf(postReceiveHook, zeroSHA, CLIPath)
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		return fmt.Errorf("failed to write post-receive hook: %w", err)
+	}
+	return chown(o, path)
+}
+
+// writeDeployConfig stores the deploy settings read by the post-receive hook
+func writeDeployConfig(o *owner, repo *models.GitRepository) error {
+	deployPath := ""
+	if repo.DeployPath != nil {
+		deployPath = *repo.DeployPath
+	}
+
+	settings := [][2]string{
+		{"owehost.autodeploy", strconv.FormatBool(repo.AutoDeploy)},
+		{"owehost.deploybranch", repo.DefaultBranch},
+		{"owehost.deploypath", deployPath},
+		{"owehost.buildtimeout", strconv.Itoa(repo.BuildTimeout)},
+	}
+
+	for _, kv := range settings {
+		if _, err := runGit(o, repo.Path, "config", kv[0], kv[1]); err != nil {
+			return err
+		}
+	}
+
+	// Build commands are multi-valued; unset fails harmlessly when none exist
+	runGit(o, repo.Path, "config", "--unset-all", "owehost.buildcommand")
+	for _, command := range repo.BuildCommands {
+		if _, err := runGit(o, repo.Path, "config", "--add", "owehost.buildcommand", command); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readBuildConfig loads the build commands and timeout written by writeDeployConfig
+func readBuildConfig(o *owner, repoPath string) ([]string, time.Duration) {
+	commands := make([]string, 0)
+	if out, err := runGit(o, repoPath, "config", "--get-all", "owehost.buildcommand"); err == nil {
+		for _, line := range strings.Split(out, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				commands = append(commands, line)
+			}
+		}
+	}
+
+	var timeout time.Duration
+	if out, err := runGit(o, repoPath, "config", "--get", "owehost.buildtimeout"); err == nil {
+		if seconds, err := strconv.Atoi(strings.TrimSpace(out)); err == nil {
+			timeout = time.Duration(seconds) * time.Second
+		}
+	}
+	return commands, timeout
+}
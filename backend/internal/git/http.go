@@ -0,0 +1,59 @@
+// Package git provides Git version control management for OweHost
+package git
+
+import (
+	"errors"
+	"net/http"
+	"net/http/cgi"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/iSundram/OweHost/pkg/models"
+)
+
+// HTTPPathPrefix is where repositories are served over git smart HTTP,
+// e.g. /git/<account>/<repo>.git
+const HTTPPathPrefix = "/git/"
+
+// HTTPBackend returns a handler serving the git smart HTTP protocol for repo
+// through git-http-backend. The caller is responsible for authentication.
+func (s *Service) HTTPBackend(repo *models.GitRepository) (http.Handler, error) {
+	o, err := s.resolveOwner(repo.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		return nil, errors.New("git is not installed")
+	}
+
+	handler := &cgi.Handler{
+		Path: gitPath,
+		Args: []string{"http-backend"},
+		Root: HTTPPathPrefix + o.Name,
+		Dir:  filepath.Dir(repo.Path),
+		Env: []string{
+			"GIT_PROJECT_ROOT=" + filepath.Dir(repo.Path),
+			"GIT_HTTP_EXPORT_ALL=1",
+			"REMOTE_USER=" + o.Name,
+			"HOME=" + o.homePath(),
+		},
+		InheritEnv: []string{"PATH"},
+	}
+
+	// Run the backend as the account user so pushed objects and hooks
+	// get the same ownership as SSH pushes. The account controls the hooks
+	// and config of its repositories, so the backend never runs as root.
+	if os.Geteuid() == 0 {
+		runuser, err := exec.LookPath("runuser")
+		if err != nil {
+			return nil, errors.New("runuser is required to serve git over HTTP")
+		}
+		handler.Path = runuser
+		handler.Args = []string{"-u", o.Name, "--", gitPath, "http-backend"}
+	}
+
+	return handler, nil
+}
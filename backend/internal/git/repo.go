@@ -0,0 +1,452 @@
+// Package git provides Git version control management for OweHost
+package git
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iSundram/OweHost/internal/storage/account"
+	"github.com/iSundram/OweHost/pkg/models"
+)
+
+const (
+	// CLIPath is the owehost-cli binary invoked from hooks and SSH forced commands
+	CLIPath = account.OweHostBasePath + "/bin/owehost-cli"
+	// KeepReleases is the number of release directories kept per deploy path
+	KeepReleases = 5
+	// DefaultBuildTimeout bounds build commands when a repository sets none
+	DefaultBuildTimeout = 10 * time.Minute
+	// zeroSHA is the object name git uses for created or deleted refs
+	zeroSHA = "0000000000000000000000000000000000000000"
+)
+
+// owner describes the hosting account a repository belongs to
+type owner struct {
+	Name        string
+	UID         int
+	GID         int
+	AccountPath string
+}
+
+// homePath returns the account user's home directory
+func (o *owner) homePath() string {
+	return filepath.Join(o.AccountPath, "home")
+}
+
+// Release describes a revision checked out into a release directory
+type Release struct {
+	Path        string
+	Commit      string
+	Author      string
+	AuthorEmail string
+	Message     string
+}
+
+// deployOptions controls how a release is built and activated
+type deployOptions struct {
+	Keep          int
+	BuildCommands []string
+	BuildTimeout  time.Duration
+	// Log receives progress and build output
+	Log io.Writer
+}
+
+// commandEnv is the environment git and build commands run with. For the
+// panel it is built from scratch, as the panel's own environment holds
+// secrets; without an owner the command runs from owehost-cli as the
+// account user, whose environment it keeps.
+func commandEnv(o *owner, extra ...string) []string {
+	if o == nil {
+		return append(os.Environ(), extra...)
+	}
+	env := []string{
+		"PATH=/usr/local/bin:/usr/bin:/bin",
+		"HOME=" + o.homePath(),
+		"USER=" + o.Name,
+		"LANG=C.UTF-8",
+	}
+	return append(env, extra...)
+}
+
+// gitCommand builds a git command that runs as the repository owner
+func gitCommand(o *owner, dir string, args ...string) *exec.Cmd {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = commandEnv(o, "GIT_TERMINAL_PROMPT=0")
+	if o != nil {
+		runAs(cmd, o.UID, o.GID)
+	}
+	return cmd
+}
+
+// runGit runs git and returns its standard output
+func runGit(o *owner, dir string, args ...string) (string, error) {
+	cmd := gitCommand(o, dir, args...)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return "", fmt.Errorf("git %s failed: %s", args[0], msg)
+	}
+
+	return stdout.String(), nil
+}
+
+// ensureDir creates dir and hands it to the owner
+func ensureDir(o *owner, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return chown(o, dir)
+}
+
+// chown gives path to the owner when running as root
+func chown(o *owner, path string) error {
+	if o == nil || os.Geteuid() != 0 {
+		return nil
+	}
+	return os.Lchown(path, o.UID, o.GID)
+}
+
+// listBranches lists local branches of a bare repository
+func listBranches(o *owner, repoPath string) ([]string, error) {
+	out, err := runGit(o, repoPath, "for-each-ref", "--format=%(refname:short)", "refs/heads/")
+	if err != nil {
+		return nil, err
+	}
+
+	branches := make([]string, 0)
+	for _, line := range strings.Split(out, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			branches = append(branches, line)
+		}
+	}
+	return branches, nil
+}
+
+// listCommits lists the most recent commits reachable from rev
+func listCommits(o *owner, repoPath, rev string, limit int) ([]models.GitCommit, error) {
+	commits := make([]models.GitCommit, 0)
+
+	// An empty repository or unknown branch has no history to show
+	if _, err := runGit(o, repoPath, "rev-parse", "--verify", "--quiet", rev+"^{commit}"); err != nil {
+		return commits, nil
+	}
+
+	args := []string{"log", "--format=%H%x1f%h%x1f%an%x1f%ae%x1f%aI%x1f%s"}
+	if limit > 0 {
+		args = append(args, "-n", strconv.Itoa(limit))
+	}
+	args = append(args, rev, "--")
+
+	out, err := runGit(o, repoPath, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(line, "\x1f")
+		if len(fields) != 6 {
+			continue
+		}
+		date, _ := time.Parse(time.RFC3339, fields[4])
+		commits = append(commits, models.GitCommit{
+			Hash:        fields[0],
+			ShortHash:   fields[1],
+			Author:      fields[2],
+			AuthorEmail: fields[3],
+			Date:        date,
+			Message:     fields[5],
+		})
+	}
+
+	return commits, nil
+}
+
+// DeployRelease exports rev from the bare repository at repoPath into a new
+// release directory, runs the repository's build commands and atomically
+// repoints deployPath at it. It is used by the post-receive hook, which
+// already runs as the account user.
+func DeployRelease(repoPath, deployPath, rev string, log io.Writer) (*Release, error) {
+	commands, timeout := readBuildConfig(nil, repoPath)
+	return deployRelease(nil, repoPath, deployPath, rev, deployOptions{
+		Keep:          KeepReleases,
+		BuildCommands: commands,
+		BuildTimeout:  timeout,
+		Log:           log,
+	})
+}
+
+// deployRelease checks out rev into <parent>/.releases/<name>/<timestamp>-<sha>,
+// builds it and swaps the deployPath symlink to it with a rename
+func deployRelease(o *owner, repoPath, deployPath, rev string, opts deployOptions) (*Release, error) {
+	if opts.Log == nil {
+		opts.Log = io.Discard
+	}
+
+	// The releases directory belongs to the account, which could point
+	// its entries anywhere with symlinks. Root never creates, swaps or
+	// removes paths there; the deploy runs as the account user instead.
+	if o != nil && os.Geteuid() == 0 {
+		return deployReleaseAs(o, repoPath, deployPath, rev, opts)
+	}
+
+	release, err := resolveRelease(o, repoPath, rev)
+	if err != nil {
+		return nil, err
+	}
+	sha := release.Commit
+
+	releasesDir := releasesPath(deployPath)
+	if err := ensureDir(o, filepath.Dir(releasesDir)); err != nil {
+		return nil, fmt.Errorf("failed to create releases directory: %w", err)
+	}
+	if err := ensureDir(o, releasesDir); err != nil {
+		return nil, fmt.Errorf("failed to create releases directory: %w", err)
+	}
+
+	// Release directories must be fresh: a failed deploy removes its directory,
+	// which must never be the one currently being served
+	stamp := time.Now().UTC().Format("20060102150405.000000")
+	release.Path = filepath.Join(releasesDir, stamp+"-"+sha[:12])
+	if err := os.Mkdir(release.Path, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create release directory: %w", err)
+	}
+	chown(o, release.Path)
+
+	fmt.Fprintf(opts.Log, "Checking out %s into %s\n", sha[:12], release.Path)
+	if err := exportTree(o, repoPath, sha, release.Path); err != nil {
+		os.RemoveAll(release.Path)
+		return nil, err
+	}
+
+	if err := runBuild(o, release.Path, opts); err != nil {
+		os.RemoveAll(release.Path)
+		return nil, err
+	}
+
+	// A deploy path that is still a plain directory becomes the first release
+	if info, err := os.Lstat(deployPath); err == nil && info.Mode()&os.ModeSymlink == 0 {
+		if !info.IsDir() {
+			os.RemoveAll(release.Path)
+			return nil, fmt.Errorf("deploy path %s is not a directory", deployPath)
+		}
+		if err := os.Rename(deployPath, filepath.Join(releasesDir, stamp+"-initial")); err != nil {
+			os.RemoveAll(release.Path)
+			return nil, fmt.Errorf("failed to move existing deploy path aside: %w", err)
+		}
+	}
+
+	if err := swapSymlink(o, release.Path, deployPath); err != nil {
+		os.RemoveAll(release.Path)
+		return nil, err
+	}
+	fmt.Fprintf(opts.Log, "Activated release %s\n", filepath.Base(release.Path))
+
+	pruneReleases(releasesDir, release.Path, opts.Keep)
+	return release, nil
+}
+
+// deployReleaseAs runs the deploy through owehost-cli as the account user,
+// the same way the post-receive hook does. The build settings it reads are
+// those writeDeployConfig stored in the repository.
+func deployReleaseAs(o *owner, repoPath, deployPath, rev string, opts deployOptions) (*Release, error) {
+	release, err := resolveRelease(o, repoPath, rev)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(CLIPath); err != nil {
+		return nil, errors.New("owehost-cli is not installed")
+	}
+
+	timeout := opts.BuildTimeout
+	if timeout <= 0 {
+		timeout = DefaultBuildTimeout
+	}
+	// Checking out and swapping the release take a little longer than the build
+	ctx, cancel := context.WithTimeout(context.Background(), timeout+time.Minute)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, CLIPath, "git-deploy", "-repo", repoPath, "-path", deployPath, "-rev", release.Commit)
+	cmd.Dir = repoPath
+	cmd.Env = commandEnv(o)
+	cmd.Stdout = opts.Log
+	cmd.Stderr = opts.Log
+	cmd.WaitDelay = 5 * time.Second
+	runAs(cmd, o.UID, o.GID)
+	killProcessGroup(cmd)
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("deploy timed out after %s", timeout)
+		}
+		return nil, fmt.Errorf("deploy failed: %w", err)
+	}
+	return release, nil
+}
+
+// resolveRelease resolves rev to a commit and reads its author and subject
+func resolveRelease(o *owner, repoPath, rev string) (*Release, error) {
+	out, err := runGit(o, repoPath, "rev-parse", "--verify", rev+"^{commit}")
+	if err != nil {
+		return nil, fmt.Errorf("unknown revision %s", rev)
+	}
+
+	release := &Release{Commit: strings.TrimSpace(out)}
+	if out, err := runGit(o, repoPath, "log", "-1", "--format=%an%x1f%ae%x1f%s", release.Commit); err == nil {
+		if fields := strings.SplitN(strings.TrimSpace(out), "\x1f", 3); len(fields) == 3 {
+			release.Author, release.AuthorEmail, release.Message = fields[0], fields[1], fields[2]
+		}
+	}
+	return release, nil
+}
+
+// runBuild runs the build commands in dir as the owner, sharing one timeout
+func runBuild(o *owner, dir string, opts deployOptions) error {
+	if len(opts.BuildCommands) == 0 {
+		return nil
+	}
+
+	timeout := opts.BuildTimeout
+	if timeout <= 0 {
+		timeout = DefaultBuildTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for _, command := range opts.BuildCommands {
+		fmt.Fprintf(opts.Log, "$ %s\n", command)
+
+		cmd := exec.CommandContext(ctx, "/bin/sh", "-c", command)
+		cmd.Dir = dir
+		cmd.Env = commandEnv(o, "CI=true")
+		cmd.Stdout = opts.Log
+		cmd.Stderr = opts.Log
+		cmd.WaitDelay = 5 * time.Second
+		if o != nil {
+			runAs(cmd, o.UID, o.GID)
+		}
+		killProcessGroup(cmd)
+
+		if err := cmd.Run(); err != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				return fmt.Errorf("build timed out after %s", timeout)
+			}
+			return fmt.Errorf("build command %q failed: %w", command, err)
+		}
+	}
+
+	return nil
+}
+
+// releasesPath returns the directory holding releases for deployPath
+func releasesPath(deployPath string) string {
+	return filepath.Join(filepath.Dir(deployPath), ".releases", filepath.Base(deployPath))
+}
+
+// exportTree writes the tree of sha into dir using git archive | tar
+func exportTree(o *owner, repoPath, sha, dir string) error {
+	archive := gitCommand(o, repoPath, "archive", "--format=tar", sha)
+	extract := exec.Command("tar", "-x", "-C", dir)
+	if o != nil {
+		runAs(extract, o.UID, o.GID)
+	}
+
+	pipe, err := archive.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	extract.Stdin = pipe
+
+	var stderr bytes.Buffer
+	archive.Stderr = &stderr
+	extract.Stderr = &stderr
+
+	if err := extract.Start(); err != nil {
+		return fmt.Errorf("failed to start tar: %w", err)
+	}
+	if err := archive.Run(); err != nil {
+		extract.Wait()
+		return fmt.Errorf("git archive failed: %s", strings.TrimSpace(stderr.String()))
+	}
+	if err := extract.Wait(); err != nil {
+		return fmt.Errorf("failed to extract release: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
+
+// swapSymlink atomically points link at target
+func swapSymlink(o *owner, target, link string) error {
+	tmp := link + ".owehost-tmp"
+	os.Remove(tmp)
+
+	if err := os.Symlink(target, tmp); err != nil {
+		return fmt.Errorf("failed to create release symlink: %w", err)
+	}
+	chown(o, tmp)
+
+	if err := os.Rename(tmp, link); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to activate release: %w", err)
+	}
+	return nil
+}
+
+// pruneReleases removes all but the newest keep releases, never touching current
+func pruneReleases(releasesDir, current string, keep int) {
+	entries, err := os.ReadDir(releasesDir)
+	if err != nil {
+		return
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+
+	for i, name := range names {
+		path := filepath.Join(releasesDir, name)
+		if i < keep || path == current {
+			continue
+		}
+		os.RemoveAll(path)
+	}
+}
+
+// resolveDeployPath makes deployPath absolute and confines it to the account
+func resolveDeployPath(o *owner, deployPath string) (string, error) {
+	if deployPath == "" {
+		return "", errors.New("deploy path cannot be empty")
+	}
+
+	path := deployPath
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(o.AccountPath, path)
+	}
+	path = filepath.Clean(path)
+
+	if !strings.HasPrefix(path, o.AccountPath+string(filepath.Separator)) {
+		return "", errors.New("deploy path must be inside the account directory")
+	}
+	return path, nil
+}
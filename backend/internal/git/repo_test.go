@@ -0,0 +1,166 @@
+package git
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCommandEnv(t *testing.T) {
+	t.Setenv("OWEHOST_TEST_SECRET", "leaked")
+
+	o := &owner{Name: "alice", AccountPath: "/srv/accounts/a-1001"}
+	env := commandEnv(o, "CI=true")
+	want := []string{
+		"PATH=/usr/local/bin:/usr/bin:/bin",
+		"HOME=/srv/accounts/a-1001/home",
+		"USER=alice",
+		"LANG=C.UTF-8",
+		"CI=true",
+	}
+	if strings.Join(env, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected %v, got %v", want, env)
+	}
+
+	// owehost-cli already runs as the account, so it keeps its environment
+	inherited := strings.Join(commandEnv(nil, "GIT_TERMINAL_PROMPT=0"), "\n")
+	if !strings.Contains(inherited, "OWEHOST_TEST_SECRET=leaked") || !strings.HasSuffix(inherited, "GIT_TERMINAL_PROMPT=0") {
+		t.Errorf("Expected the CLI environment to be inherited, got %q", inherited)
+	}
+}
+
+func TestResolveDeployPath(t *testing.T) {
+	o := &owner{AccountPath: "/srv/accounts/a-1001"}
+
+	tests := []struct {
+		path string
+		want string
+		ok   bool
+	}{
+		{"public_html", "/srv/accounts/a-1001/public_html", true},
+		{"sites/app/", "/srv/accounts/a-1001/sites/app", true},
+		{"/srv/accounts/a-1001/public_html", "/srv/accounts/a-1001/public_html", true},
+		{"", "", false},
+		{".", "", false},
+		{"../a-1002/public_html", "", false},
+		{"public_html/../../a-1002", "", false},
+		{"/srv/accounts/a-10012/public_html", "", false},
+		{"/etc", "", false},
+	}
+	for _, tt := range tests {
+		got, err := resolveDeployPath(o, tt.path)
+		if (err == nil) != tt.ok {
+			t.Errorf("%q: expected ok=%v, got %v", tt.path, tt.ok, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q: expected %q, got %q", tt.path, tt.want, got)
+		}
+	}
+}
+
+// newBareRepo creates a bare repository with one commit on main and
+// returns its path
+func newBareRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := t.TempDir()
+	work := filepath.Join(dir, "work")
+	bare := filepath.Join(dir, "repo.git")
+	steps := [][]string{
+		{"init", "-q", "-b", "main", work},
+		{"-C", work, "-c", "user.name=Test", "-c", "user.email=test@example.com", "commit", "-q", "--allow-empty", "-m", "Initial commit"},
+		{"clone", "-q", "--bare", work, bare},
+	}
+	for _, args := range steps {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %s failed: %v: %s", args[0], err, out)
+		}
+	}
+	return bare
+}
+
+func TestDeployRelease_SwapsAndPrunes(t *testing.T) {
+	repo := newBareRepo(t)
+	deployPath := filepath.Join(t.TempDir(), "public_html")
+
+	// An existing directory is kept as the first release
+	if err := os.Mkdir(deployPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var last string
+	for i := 0; i < 4; i++ {
+		release, err := deployRelease(nil, repo, deployPath, "main", deployOptions{Keep: 2})
+		if err != nil {
+			t.Fatalf("Deploy %d failed: %v", i, err)
+		}
+		last = release.Path
+	}
+
+	target, err := os.Readlink(deployPath)
+	if err != nil {
+		t.Fatalf("Expected deploy path to be a symlink: %v", err)
+	}
+	if target != last {
+		t.Errorf("Expected deploy path to point at %s, got %s", last, target)
+	}
+
+	entries, err := os.ReadDir(releasesPath(deployPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("Expected 2 releases kept, got %d", len(entries))
+	}
+}
+
+func TestDeployRelease_FailedBuildKeepsCurrent(t *testing.T) {
+	repo := newBareRepo(t)
+	deployPath := filepath.Join(t.TempDir(), "public_html")
+
+	good, err := deployRelease(nil, repo, deployPath, "main", deployOptions{Keep: 5})
+	if err != nil {
+		t.Fatalf("Deploy failed: %v", err)
+	}
+
+	if _, err := deployRelease(nil, repo, deployPath, "main", deployOptions{Keep: 5, BuildCommands: []string{"exit 3"}}); err == nil {
+		t.Fatal("Expected the failing build to fail the deploy")
+	}
+
+	if target, _ := os.Readlink(deployPath); target != good.Path {
+		t.Errorf("Expected deploy path to stay on %s, got %s", good.Path, target)
+	}
+	entries, _ := os.ReadDir(releasesPath(deployPath))
+	if len(entries) != 1 {
+		t.Errorf("Expected the failed release to be removed, got %d releases", len(entries))
+	}
+}
+
+func TestRunBuild_Environment(t *testing.T) {
+	t.Setenv("OWEHOST_TEST_SECRET", "leaked")
+
+	dir := t.TempDir()
+	o := &owner{Name: "alice", UID: os.Getuid(), GID: os.Getgid(), AccountPath: dir}
+	err := runBuild(o, dir, deployOptions{BuildCommands: []string{"env > build.env"}, Log: os.Stderr})
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "build.env"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := string(data)
+	if strings.Contains(env, "OWEHOST_TEST_SECRET") {
+		t.Error("Expected the panel environment not to reach build commands")
+	}
+	if !strings.Contains(env, "CI=true") || !strings.Contains(env, "USER=alice") {
+		t.Errorf("Expected CI and USER to be set, got %q", env)
+	}
+}
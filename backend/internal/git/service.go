@@ -2,68 +2,215 @@
 package git
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/iSundram/OweHost/internal/runtime"
+	"github.com/iSundram/OweHost/internal/storage/account"
+	"github.com/iSundram/OweHost/pkg/config"
 	"github.com/iSundram/OweHost/pkg/models"
 	"github.com/iSundram/OweHost/pkg/utils"
 )
 
+const (
+	// maxDeploymentHistory is the number of deployments remembered per repository
+	maxDeploymentHistory = 50
+	// maxDeploymentLog caps the log kept for each deployment
+	maxDeploymentLog = 64 * 1024
+)
+
+// Repository names become directory names, so keep them conservative
+var validRepoName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]{0,99}$`)
+
 // Service provides Git repository management functionality
 type Service struct {
+	accounts     *account.StateManager
+	runtime      *runtime.Service
+	hostname     string
 	repositories map[string]*models.GitRepository
 	deployKeys   map[string]*models.DeployKey
 	webhooks     map[string]*models.GitWebhook
+	deployments  map[string][]*models.DeploymentInfo
+	deployLocks  map[string]*sync.Mutex
 	byUser       map[string][]*models.GitRepository
 	byDomain     map[string][]*models.GitRepository
 	mu           sync.RWMutex
 }
 
 // NewService creates a new git service
-func NewService() *Service {
+func NewService(cfg *config.Config, runtimeSvc *runtime.Service) *Service {
 	return &Service{
+		accounts:     account.NewStateManager(),
+		runtime:      runtimeSvc,
+		hostname:     cfg.Server.PublicHostname,
 		repositories: make(map[string]*models.GitRepository),
 		deployKeys:   make(map[string]*models.DeployKey),
 		webhooks:     make(map[string]*models.GitWebhook),
+		deployments:  make(map[string][]*models.DeploymentInfo),
+		deployLocks:  make(map[string]*sync.Mutex),
 		byUser:       make(map[string][]*models.GitRepository),
 		byDomain:     make(map[string][]*models.GitRepository),
 	}
 }
 
-// CreateRepository creates a new Git repository
-func (s *Service) CreateRepository(userID string, req *models.GitRepositoryCreateRequest) (*models.GitRepository, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// resolveOwner maps a panel user to the hosting account that owns its repositories
+func (s *Service) resolveOwner(userID string) (*owner, error) {
+	identity, err := s.accounts.FindByName(userID)
+	if err != nil {
+		return nil, errors.New("no hosting account found for user")
+	}
+
+	return &owner{
+		Name:        identity.Name,
+		UID:         identity.UID,
+		GID:         identity.GID,
+		AccountPath: s.accounts.AccountPath(identity.ID),
+	}, nil
+}
+
+// newRepository prepares a repository record and its on-disk location
+func (s *Service) newRepository(o *owner, userID, name string, domainID *string, autoDeploy bool, deployPath *string) (*models.GitRepository, error) {
+	if !validRepoName.MatchString(name) || strings.HasSuffix(name, ".git") {
+		return nil, errors.New("invalid repository name")
+	}
+
+	for _, existing := range s.byUser[userID] {
+		if existing.Name == name {
+			return nil, errors.New("repository already exists")
+		}
+	}
+
+	if deployPath != nil {
+		resolved, err := resolveDeployPath(o, *deployPath)
+		if err != nil {
+			return nil, err
+		}
+		deployPath = &resolved
+	}
 
-	repoPath := "/home/" + userID + "/repositories/" + req.Name + ".git"
+	repoPath := filepath.Join(o.AccountPath, "repositories", name+".git")
+	if _, err := os.Stat(repoPath); err == nil {
+		return nil, errors.New("repository already exists")
+	}
 
-	repo := &models.GitRepository{
+	return &models.GitRepository{
 		ID:            utils.GenerateID("repo"),
 		UserID:        userID,
-		DomainID:      req.DomainID,
-		Name:          req.Name,
-		Description:   req.Description,
+		DomainID:      domainID,
+		Name:          name,
 		Path:          repoPath,
-		CloneURL:      "git@server:" + userID + "/" + req.Name + ".git",
-		HTTPCloneURL:  "https://server/" + userID + "/" + req.Name + ".git",
+		CloneURL:      fmt.Sprintf("ssh://%s@%s%s", o.Name, s.hostname, repoPath),
+		HTTPCloneURL:  fmt.Sprintf("https://%s%s%s/%s.git", s.hostname, HTTPPathPrefix, o.Name, name),
 		DefaultBranch: "main",
 		IsPrivate:     true,
-		AutoDeploy:    req.AutoDeploy,
-		DeployPath:    req.DeployPath,
+		AutoDeploy:    autoDeploy,
+		DeployPath:    deployPath,
 		CreatedAt:     time.Now(),
 		UpdatedAt:     time.Now(),
+	}, nil
+}
+
+// finishRepository installs hooks and deploy settings, then registers the repository
+func (s *Service) finishRepository(o *owner, repo *models.GitRepository) error {
+	if err := installHooks(o, repo.Path); err != nil {
+		os.RemoveAll(repo.Path)
+		return err
+	}
+	if err := writeDeployConfig(o, repo); err != nil {
+		os.RemoveAll(repo.Path)
+		return err
+	}
+
+	s.register(repo)
+	if err := s.persist(repo.UserID); err != nil {
+		s.unregister(repo)
+		os.RemoveAll(repo.Path)
+		return err
+	}
+	return nil
+}
+
+// validateBuild checks build commands before they are stored in the git config
+func validateBuild(commands []string, timeout int) error {
+	if len(commands) > 10 {
+		return errors.New("at most 10 build commands are allowed")
+	}
+	for _, command := range commands {
+		if strings.TrimSpace(command) == "" || strings.ContainsAny(command, "\r\n") {
+			return errors.New("build commands must be single, non-empty lines")
+		}
+	}
+	if timeout < 0 || timeout > 3600 {
+		return errors.New("build timeout must be between 0 and 3600 seconds")
+	}
+	return nil
+}
+
+// CreateRepository creates a new bare Git repository in the account tree
+func (s *Service) CreateRepository(userID string, req *models.GitRepositoryCreateRequest) (*models.GitRepository, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, err := s.resolveOwner(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateBuild(req.BuildCommands, req.BuildTimeout); err != nil {
+		return nil, err
+	}
+
+	repo, err := s.newRepository(o, userID, req.Name, req.DomainID, req.AutoDeploy, req.DeployPath)
+	if err != nil {
+		return nil, err
+	}
+	repo.Description = req.Description
+	repo.BuildCommands = req.BuildCommands
+	repo.BuildTimeout = req.BuildTimeout
+
+	if err := ensureDir(o, filepath.Dir(repo.Path)); err != nil {
+		return nil, fmt.Errorf("failed to create repositories directory: %w", err)
+	}
+	if _, err := runGit(o, filepath.Dir(repo.Path), "init", "--bare", "--initial-branch="+repo.DefaultBranch, repo.Path); err != nil {
+		return nil, err
+	}
+	if _, err := runGit(o, repo.Path, "config", "receive.denyNonFastForwards", "false"); err != nil {
+		os.RemoveAll(repo.Path)
+		return nil, err
 	}
 
-	s.repositories[repo.ID] = repo
-	s.byUser[userID] = append(s.byUser[userID], repo)
-	if req.DomainID != nil {
-		s.byDomain[*req.DomainID] = append(s.byDomain[*req.DomainID], repo)
+	if err := s.finishRepository(o, repo); err != nil {
+		return nil, err
 	}
 
 	return repo, nil
 }
 
+// GetByName gets a repository by its owning account name and repository name
+func (s *Service) GetByName(userID, name string) (*models.GitRepository, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, repo := range s.byUser[userID] {
+		if repo.Name == name {
+			return repo, nil
+		}
+	}
+	return nil, errors.New("repository not found")
+}
+
+// Authenticate verifies hosting account credentials for git over HTTP
+func (s *Service) Authenticate(username, password string) (*account.AccountIdentity, error) {
+	return s.accounts.AuthenticateAccount(username, password)
+}
+
 // Get gets a repository by ID
 func (s *Service) Get(id string) (*models.GitRepository, error) {
 	s.mu.RLock()
@@ -102,20 +249,55 @@ func (s *Service) Update(id string, req *models.GitRepositoryUpdateRequest) (*mo
 		return nil, errors.New("repository not found")
 	}
 
+	o, err := s.resolveOwner(repo.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.DeployPath != nil {
+		resolved, err := resolveDeployPath(o, *req.DeployPath)
+		if err != nil {
+			return nil, err
+		}
+		repo.DeployPath = &resolved
+	}
+	if req.BuildCommands != nil || req.BuildTimeout != nil {
+		commands, timeout := repo.BuildCommands, repo.BuildTimeout
+		if req.BuildCommands != nil {
+			commands = req.BuildCommands
+		}
+		if req.BuildTimeout != nil {
+			timeout = *req.BuildTimeout
+		}
+		if err := validateBuild(commands, timeout); err != nil {
+			return nil, err
+		}
+		repo.BuildCommands, repo.BuildTimeout = commands, timeout
+	}
+	if req.DefaultBranch != nil {
+		if _, err := runGit(o, repo.Path, "check-ref-format", "--branch", *req.DefaultBranch); err != nil {
+			return nil, errors.New("invalid branch name")
+		}
+		repo.DefaultBranch = *req.DefaultBranch
+		if _, err := runGit(o, repo.Path, "symbolic-ref", "HEAD", "refs/heads/"+repo.DefaultBranch); err != nil {
+			return nil, err
+		}
+	}
 	if req.Description != nil {
 		repo.Description = *req.Description
 	}
 	if req.AutoDeploy != nil {
 		repo.AutoDeploy = *req.AutoDeploy
 	}
-	if req.DeployPath != nil {
-		repo.DeployPath = req.DeployPath
-	}
-	if req.DefaultBranch != nil {
-		repo.DefaultBranch = *req.DefaultBranch
+
+	if err := writeDeployConfig(o, repo); err != nil {
+		return nil, err
 	}
 
 	repo.UpdatedAt = time.Now()
+	if err := s.persist(repo.UserID); err != nil {
+		return nil, err
+	}
 	return repo, nil
 }
 
@@ -129,40 +311,18 @@ func (s *Service) Delete(id string) error {
 		return errors.New("repository not found")
 	}
 
-	// Remove from user's repos
-	userRepos := s.byUser[repo.UserID]
-	for i, r := range userRepos {
-		if r.ID == id {
-			s.byUser[repo.UserID] = append(userRepos[:i], userRepos[i+1:]...)
-			break
-		}
-	}
-
-	// Remove from domain's repos
-	if repo.DomainID != nil {
-		domainRepos := s.byDomain[*repo.DomainID]
-		for i, r := range domainRepos {
-			if r.ID == id {
-				s.byDomain[*repo.DomainID] = append(domainRepos[:i], domainRepos[i+1:]...)
-				break
-			}
-		}
-	}
+	s.unregister(repo)
 
-	// Remove deploy keys and webhooks
-	for keyID, key := range s.deployKeys {
-		if key.RepositoryID == id {
-			delete(s.deployKeys, keyID)
+	if o, err := s.resolveOwner(repo.UserID); err == nil {
+		if err := s.syncAuthorizedKeys(o, repo.UserID); err != nil {
+			return err
 		}
 	}
-	for whID, wh := range s.webhooks {
-		if wh.RepositoryID == id {
-			delete(s.webhooks, whID)
-		}
+	if err := s.persist(repo.UserID); err != nil {
+		return err
 	}
 
-	delete(s.repositories, id)
-	return nil
+	return os.RemoveAll(repo.Path)
 }
 
 // Clone clones a remote repository
@@ -170,39 +330,73 @@ func (s *Service) Clone(userID string, req *models.GitCloneRequest) (*models.Git
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	repoPath := "/home/" + userID + "/repositories/" + req.Name + ".git"
+	if err := validateRemoteURL(req.RemoteURL); err != nil {
+		return nil, err
+	}
 
-	repo := &models.GitRepository{
-		ID:            utils.GenerateID("repo"),
-		UserID:        userID,
-		DomainID:      req.DomainID,
-		Name:          req.Name,
-		Description:   "Cloned from " + req.RemoteURL,
-		Path:          repoPath,
-		RemoteURL:     &req.RemoteURL,
-		CloneURL:      "git@server:" + userID + "/" + req.Name + ".git",
-		HTTPCloneURL:  "https://server/" + userID + "/" + req.Name + ".git",
-		DefaultBranch: req.Branch,
-		IsPrivate:     true,
-		AutoDeploy:    req.AutoDeploy,
-		DeployPath:    req.DeployPath,
-		CreatedAt:     time.Now(),
-		UpdatedAt:     time.Now(),
+	o, err := s.resolveOwner(userID)
+	if err != nil {
+		return nil, err
 	}
 
-	if repo.DefaultBranch == "" {
-		repo.DefaultBranch = "main"
+	if err := validateBuild(req.BuildCommands, req.BuildTimeout); err != nil {
+		return nil, err
 	}
 
-	s.repositories[repo.ID] = repo
-	s.byUser[userID] = append(s.byUser[userID], repo)
-	if req.DomainID != nil {
-		s.byDomain[*req.DomainID] = append(s.byDomain[*req.DomainID], repo)
+	repo, err := s.newRepository(o, userID, req.Name, req.DomainID, req.AutoDeploy, req.DeployPath)
+	if err != nil {
+		return nil, err
+	}
+	repo.Description = "Cloned from " + req.RemoteURL
+	repo.RemoteURL = &req.RemoteURL
+	repo.BuildCommands = req.BuildCommands
+	repo.BuildTimeout = req.BuildTimeout
+
+	if err := ensureDir(o, filepath.Dir(repo.Path)); err != nil {
+		return nil, fmt.Errorf("failed to create repositories directory: %w", err)
+	}
+
+	args := []string{"clone", "--bare"}
+	if req.Branch != "" {
+		args = append(args, "--branch", req.Branch)
+	}
+	args = append(args, "--", req.RemoteURL, repo.Path)
+	if _, err := runGit(o, filepath.Dir(repo.Path), args...); err != nil {
+		os.RemoveAll(repo.Path)
+		return nil, err
+	}
+
+	// Bare clones have no fetch refspec; track remote branches one-to-one
+	if _, err := runGit(o, repo.Path, "config", "remote.origin.fetch", "+refs/heads/*:refs/heads/*"); err != nil {
+		os.RemoveAll(repo.Path)
+		return nil, err
+	}
+
+	if out, err := runGit(o, repo.Path, "symbolic-ref", "--short", "HEAD"); err == nil {
+		repo.DefaultBranch = strings.TrimSpace(out)
+	}
+
+	if err := s.finishRepository(o, repo); err != nil {
+		return nil, err
 	}
 
 	return repo, nil
 }
 
+// validateRemoteURL only allows network transports for remote repositories
+func validateRemoteURL(remoteURL string) error {
+	switch {
+	case strings.HasPrefix(remoteURL, "https://"),
+		strings.HasPrefix(remoteURL, "http://"),
+		strings.HasPrefix(remoteURL, "ssh://"),
+		strings.HasPrefix(remoteURL, "git://"):
+		return nil
+	case strings.HasPrefix(remoteURL, "git@") && strings.Contains(remoteURL, ":"):
+		return nil
+	}
+	return errors.New("remote URL must use https, ssh or git transport")
+}
+
 // Pull pulls latest changes from remote
 func (s *Service) Pull(id, branch string) error {
 	s.mu.Lock()
@@ -217,41 +411,256 @@ func (s *Service) Pull(id, branch string) error {
 		return errors.New("no remote configured")
 	}
 
+	o, err := s.resolveOwner(repo.UserID)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"fetch", "--prune", "origin"}
+	if branch != "" {
+		if _, err := runGit(o, repo.Path, "check-ref-format", "--branch", branch); err != nil {
+			return errors.New("invalid branch name")
+		}
+		args = []string{"fetch", "origin", "+refs/heads/" + branch + ":refs/heads/" + branch}
+	}
+	if _, err := runGit(o, repo.Path, args...); err != nil {
+		return err
+	}
+
 	now := time.Now()
 	repo.LastPullAt = &now
 	repo.UpdatedAt = now
 
-	return nil
+	return s.persist(repo.UserID)
 }
 
-// Deploy deploys the repository to the deploy path
+// Deploy deploys the head of the default branch to the deploy path
 func (s *Service) Deploy(id string) (*models.DeploymentInfo, error) {
 	s.mu.Lock()
-	defer s.mu.Unlock()
-
 	repo, exists := s.repositories[id]
 	if !exists {
+		s.mu.Unlock()
+		return nil, errors.New("repository not found")
+	}
+	job, err := s.newDeployJob(repo, repo.DefaultBranch, "manual", false)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	s.runDeployJob(job)
+	if job.deployment.Status == models.DeploymentFailed {
+		return job.deployment, errors.New(job.deployment.Message)
+	}
+	return job.deployment, nil
+}
+
+// ListDeployments lists the most recent deployments of a repository, newest first
+func (s *Service) ListDeployments(repoID string) ([]*models.DeploymentInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, exists := s.repositories[repoID]; !exists {
 		return nil, errors.New("repository not found")
 	}
 
-	if repo.DeployPath == nil {
+	history := s.deployments[repoID]
+	deployments := make([]*models.DeploymentInfo, 0, len(history))
+	for i := len(history) - 1; i >= 0; i-- {
+		d := *history[i]
+		deployments = append(deployments, &d)
+	}
+	return deployments, nil
+}
+
+// deployJob is a snapshot of everything a deployment needs, so the build can
+// run without holding the service lock
+type deployJob struct {
+	owner      *owner
+	userID     string
+	site       string
+	repoID     string
+	repoPath   string
+	deployPath string
+	branch     string
+	fetch      bool
+	opts       deployOptions
+	deployment *models.DeploymentInfo
+}
+
+// newDeployJob records a pending deployment of branch. Callers hold s.mu.
+func (s *Service) newDeployJob(repo *models.GitRepository, branch, trigger string, fetch bool) (*deployJob, error) {
+	// Repositories of a release-based site deploy through the site's
+	// releases instead of their own deploy path
+	site := ""
+	if repo.DomainID != nil && s.runtime != nil && s.runtime.SiteReleasesEnabled(repo.UserID, *repo.DomainID) {
+		site = *repo.DomainID
+	}
+	if repo.DeployPath == nil && site == "" {
 		return nil, errors.New("no deploy path configured")
 	}
+	if fetch && repo.RemoteURL == nil {
+		return nil, errors.New("no remote configured")
+	}
 
-	now := time.Now()
-	repo.LastDeployAt = &now
-	repo.UpdatedAt = now
+	o, err := s.resolveOwner(repo.UserID)
+	if err != nil {
+		return nil, err
+	}
 
 	deployment := &models.DeploymentInfo{
 		ID:           utils.GenerateID("deploy"),
-		RepositoryID: id,
-		Branch:       repo.DefaultBranch,
-		Commit:       "abc123",
-		Status:       "success",
-		DeployedAt:   now,
+		RepositoryID: repo.ID,
+		Branch:       branch,
+		Status:       models.DeploymentPending,
+		Trigger:      trigger,
+		StartedAt:    time.Now(),
+	}
+
+	history := append(s.deployments[repo.ID], deployment)
+	if len(history) > maxDeploymentHistory {
+		history = history[len(history)-maxDeploymentHistory:]
+	}
+	s.deployments[repo.ID] = history
+
+	if s.deployLocks[repo.ID] == nil {
+		s.deployLocks[repo.ID] = &sync.Mutex{}
+	}
+
+	deployPath := ""
+	if repo.DeployPath != nil {
+		deployPath = *repo.DeployPath
+	}
+
+	return &deployJob{
+		owner:      o,
+		userID:     repo.UserID,
+		site:       site,
+		repoID:     repo.ID,
+		repoPath:   repo.Path,
+		deployPath: deployPath,
+		branch:     branch,
+		fetch:      fetch,
+		opts: deployOptions{
+			Keep:          KeepReleases,
+			BuildCommands: append([]string(nil), repo.BuildCommands...),
+			BuildTimeout:  time.Duration(repo.BuildTimeout) * time.Second,
+		},
+		deployment: deployment,
+	}, nil
+}
+
+// runDeployJob fetches (when requested), builds and activates a release.
+// Deployments of the same repository run one at a time.
+func (s *Service) runDeployJob(job *deployJob) {
+	s.mu.RLock()
+	lock := s.deployLocks[job.repoID]
+	s.mu.RUnlock()
+	if lock != nil {
+		lock.Lock()
+		defer lock.Unlock()
+	}
+
+	s.setDeploymentStatus(job.deployment, models.DeploymentRunning, "")
+
+	var log bytes.Buffer
+	job.opts.Log = &log
+
+	var release *Release
+	err := func() error {
+		if job.fetch {
+			fmt.Fprintf(&log, "Fetching %s from origin\n", job.branch)
+			refspec := "+refs/heads/" + job.branch + ":refs/heads/" + job.branch
+			if _, err := runGit(job.owner, job.repoPath, "fetch", "origin", refspec); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		if job.site != "" {
+			release, err = s.deploySiteRelease(job, "refs/heads/"+job.branch)
+		} else {
+			release, err = deployRelease(job.owner, job.repoPath, job.deployPath, "refs/heads/"+job.branch, job.opts)
+		}
+		return err
+	}()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d := job.deployment
+	d.Log = tailLog(log.String())
+	if err != nil {
+		fmt.Fprintf(&log, "Deployment failed: %v\n", err)
+		d.Log = tailLog(log.String())
+		d.Status = models.DeploymentFailed
+		d.Message = err.Error()
+		s.persist(job.userID)
+		return
+	}
+
+	now := time.Now()
+	d.Status = models.DeploymentSuccess
+	d.Commit = release.Commit
+	d.Author = release.Author
+	d.AuthorEmail = release.AuthorEmail
+	d.Message = release.Message
+	d.DeployedAt = &now
+
+	if repo, exists := s.repositories[job.repoID]; exists {
+		repo.LastDeployAt = &now
+		repo.UpdatedAt = now
+		if job.fetch {
+			repo.LastPullAt = &now
+		}
+	}
+	s.persist(job.userID)
+}
+
+// deploySiteRelease checks out and builds rev as a new release of the
+// repository's site, which then takes care of shared paths, pruning and
+// reloading the site's runtime. Pushes still deploy through the post-receive
+// hook, which runs as the account and cannot reload services; only
+// panel-run deploys (manual and webhook) go through site releases.
+func (s *Service) deploySiteRelease(job *deployJob, rev string) (*Release, error) {
+	release, err := resolveRelease(job.owner, job.repoPath, rev)
+	if err != nil {
+		return nil, err
+	}
+
+	siteRelease, err := s.runtime.DeploySite(job.userID, job.site, "git", release.Commit, func(dir string) error {
+		fmt.Fprintf(job.opts.Log, "Checking out %s into %s\n", release.Commit[:12], dir)
+		if err := exportTree(job.owner, job.repoPath, release.Commit, dir); err != nil {
+			return err
+		}
+		return runBuild(job.owner, dir, job.opts)
+	})
+	if siteRelease == nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(job.opts.Log, "Activated site release %s\n", siteRelease.ID)
+	if err != nil {
+		return nil, err
 	}
+	return release, nil
+}
 
-	return deployment, nil
+// setDeploymentStatus updates a deployment under the service lock
+func (s *Service) setDeploymentStatus(d *models.DeploymentInfo, status, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d.Status = status
+	d.Message = message
+}
+
+// tailLog keeps the end of a deployment log, where failures are reported
+func tailLog(log string) string {
+	if len(log) <= maxDeploymentLog {
+		return log
+	}
+	return "...\n" + log[len(log)-maxDeploymentLog:]
 }
 
 // AddDeployKey adds a deploy key to a repository
@@ -259,20 +668,45 @@ func (s *Service) AddDeployKey(repoID string, req *models.DeployKeyCreateRequest
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if _, exists := s.repositories[repoID]; !exists {
+	repo, exists := s.repositories[repoID]
+	if !exists {
 		return nil, errors.New("repository not found")
 	}
 
+	_, _, fingerprint, err := parsePublicKey(req.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	for _, existing := range s.deployKeys {
+		if existing.Fingerprint == fingerprint {
+			return nil, errors.New("deploy key is already in use")
+		}
+	}
+
+	o, err := s.resolveOwner(repo.UserID)
+	if err != nil {
+		return nil, err
+	}
+
 	key := &models.DeployKey{
 		ID:           utils.GenerateID("key"),
 		RepositoryID: repoID,
 		Title:        req.Title,
-		PublicKey:    req.PublicKey,
+		PublicKey:    strings.TrimSpace(req.PublicKey),
+		Fingerprint:  fingerprint,
 		ReadOnly:     req.ReadOnly,
 		CreatedAt:    time.Now(),
 	}
 
 	s.deployKeys[key.ID] = key
+	if err := s.syncAuthorizedKeys(o, repo.UserID); err != nil {
+		delete(s.deployKeys, key.ID)
+		return nil, err
+	}
+	if err := s.persist(repo.UserID); err != nil {
+		return nil, err
+	}
+
 	return key, nil
 }
 
@@ -290,41 +724,124 @@ func (s *Service) ListDeployKeys(repoID string) []*models.DeployKey {
 	return keys
 }
 
+// GetDeployKey gets a deploy key by ID
+func (s *Service) GetDeployKey(keyID string) (*models.DeployKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key, exists := s.deployKeys[keyID]
+	if !exists {
+		return nil, errors.New("deploy key not found")
+	}
+	return key, nil
+}
+
 // RemoveDeployKey removes a deploy key
 func (s *Service) RemoveDeployKey(keyID string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if _, exists := s.deployKeys[keyID]; !exists {
+	key, exists := s.deployKeys[keyID]
+	if !exists {
 		return errors.New("deploy key not found")
 	}
 
 	delete(s.deployKeys, keyID)
+
+	if repo, exists := s.repositories[key.RepositoryID]; exists {
+		o, err := s.resolveOwner(repo.UserID)
+		if err != nil {
+			return err
+		}
+		if err := s.syncAuthorizedKeys(o, repo.UserID); err != nil {
+			return err
+		}
+		return s.persist(repo.UserID)
+	}
 	return nil
 }
 
-// AddWebhook adds a webhook to a repository
-func (s *Service) AddWebhook(repoID string, req *models.GitWebhookCreateRequest) (*models.GitWebhook, error) {
+// syncAuthorizedKeys rewrites the deploy keys of all repositories owned by userID
+func (s *Service) syncAuthorizedKeys(o *owner, userID string) error {
+	lines := make([]string, 0)
+	for _, key := range s.deployKeys {
+		repo, exists := s.repositories[key.RepositoryID]
+		if !exists || repo.UserID != userID {
+			continue
+		}
+		line, err := authorizedKeyLine(key, repo.Path)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, line)
+	}
+	return writeAuthorizedKeys(o, lines)
+}
+
+// AddWebhook adds an inbound push webhook to a repository. The returned
+// secret must be configured at the provider; it is not shown again.
+func (s *Service) AddWebhook(repoID string, req *models.GitWebhookCreateRequest) (*models.GitWebhookCreateResponse, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if _, exists := s.repositories[repoID]; !exists {
+	repo, exists := s.repositories[repoID]
+	if !exists {
 		return nil, errors.New("repository not found")
 	}
+	if repo.RemoteURL == nil {
+		return nil, errors.New("webhooks require a repository cloned from a remote")
+	}
 
+	switch req.Provider {
+	case models.GitProviderGitHub, models.GitProviderGitLab, models.GitProviderGitea:
+	default:
+		return nil, errors.New("provider must be github, gitlab or gitea")
+	}
+
+	if req.Branch != "" {
+		o, err := s.resolveOwner(repo.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := runGit(o, repo.Path, "check-ref-format", "--branch", req.Branch); err != nil {
+			return nil, errors.New("invalid branch name")
+		}
+	}
+
+	secret := req.Secret
+	if secret == "" {
+		generated, err := utils.GenerateSecureToken(32)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate webhook secret: %w", err)
+		}
+		secret = generated
+	}
+
+	events := req.Events
+	if len(events) == 0 {
+		events = []string{"push"}
+	}
+
+	id := utils.GenerateID("ghook")
 	webhook := &models.GitWebhook{
-		ID:           utils.GenerateID("ghook"),
+		ID:           id,
 		RepositoryID: repoID,
-		URL:          req.URL,
-		Events:       req.Events,
-		Secret:       req.Secret,
+		Provider:     req.Provider,
+		URL:          fmt.Sprintf("https://%s%s%s", s.hostname, WebhookPathPrefix, id),
+		Branch:       req.Branch,
+		Events:       events,
+		Secret:       secret,
 		Enabled:      true,
 		CreatedAt:    time.Now(),
 		UpdatedAt:    time.Now(),
 	}
 
 	s.webhooks[webhook.ID] = webhook
-	return webhook, nil
+	if err := s.persist(repo.UserID); err != nil {
+		delete(s.webhooks, webhook.ID)
+		return nil, err
+	}
+	return &models.GitWebhookCreateResponse{GitWebhook: webhook, Secret: secret}, nil
 }
 
 // ListWebhooks lists webhooks for a repository
@@ -341,16 +858,32 @@ func (s *Service) ListWebhooks(repoID string) []*models.GitWebhook {
 	return webhooks
 }
 
+// GetWebhook gets a webhook by ID
+func (s *Service) GetWebhook(webhookID string) (*models.GitWebhook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	webhook, exists := s.webhooks[webhookID]
+	if !exists {
+		return nil, errors.New("webhook not found")
+	}
+	return webhook, nil
+}
+
 // RemoveWebhook removes a webhook
 func (s *Service) RemoveWebhook(webhookID string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if _, exists := s.webhooks[webhookID]; !exists {
+	webhook, exists := s.webhooks[webhookID]
+	if !exists {
 		return errors.New("webhook not found")
 	}
 
 	delete(s.webhooks, webhookID)
+	if repo, exists := s.repositories[webhook.RepositoryID]; exists {
+		return s.persist(repo.UserID)
+	}
 	return nil
 }
 
@@ -359,12 +892,17 @@ func (s *Service) GetBranches(id string) ([]string, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	_, exists := s.repositories[id]
+	repo, exists := s.repositories[id]
 	if !exists {
 		return nil, errors.New("repository not found")
 	}
 
-	return []string{"main", "develop", "feature/example"}, nil
+	o, err := s.resolveOwner(repo.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	return listBranches(o, repo.Path)
 }
 
 // GetCommits gets recent commits for a repository
@@ -372,33 +910,22 @@ func (s *Service) GetCommits(id string, branch string, limit int) ([]models.GitC
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	_, exists := s.repositories[id]
+	repo, exists := s.repositories[id]
 	if !exists {
 		return nil, errors.New("repository not found")
 	}
 
-	commits := []models.GitCommit{
-		{
-			Hash:        "abc123def456",
-			ShortHash:   "abc123d",
-			Message:     "Initial commit",
-			Author:      "developer",
-			AuthorEmail: "dev@example.com",
-			Date:        time.Now().Add(-24 * time.Hour),
-		},
-		{
-			Hash:        "def456ghi789",
-			ShortHash:   "def456g",
-			Message:     "Add new feature",
-			Author:      "developer",
-			AuthorEmail: "dev@example.com",
-			Date:        time.Now().Add(-12 * time.Hour),
-		},
+	o, err := s.resolveOwner(repo.UserID)
+	if err != nil {
+		return nil, err
 	}
 
-	if limit > 0 && limit < len(commits) {
-		commits = commits[:limit]
+	if branch == "" {
+		branch = repo.DefaultBranch
+	}
+	if _, err := runGit(o, repo.Path, "check-ref-format", "--branch", branch); err != nil {
+		return nil, errors.New("invalid branch name")
 	}
 
-	return commits, nil
+	return listCommits(o, repo.Path, "refs/heads/"+branch, limit)
 }
@@ -0,0 +1,164 @@
+// Package git provides Git version control management for OweHost
+package git
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/iSundram/OweHost/pkg/models"
+)
+
+const (
+	authorizedKeysBegin = "# BEGIN OweHost deploy keys"
+	authorizedKeysEnd   = "# END OweHost deploy keys"
+)
+
+// Supported deploy key algorithms
+var validKeyTypes = map[string]bool{
+	"ssh-ed25519":                        true,
+	"ssh-rsa":                            true,
+	"ecdsa-sha2-nistp256":                true,
+	"ecdsa-sha2-nistp384":                true,
+	"ecdsa-sha2-nistp521":                true,
+	"sk-ssh-ed25519@openssh.com":         true,
+	"sk-ecdsa-sha2-nistp256@openssh.com": true,
+}
+
+// parsePublicKey validates an OpenSSH public key and returns its type, base64
+// blob and SHA256 fingerprint
+func parsePublicKey(publicKey string) (keyType, blob, fingerprint string, err error) {
+	fields := strings.Fields(publicKey)
+	if len(fields) < 2 {
+		return "", "", "", errors.New("invalid public key format")
+	}
+
+	keyType, blob = fields[0], fields[1]
+	if !validKeyTypes[keyType] {
+		return "", "", "", fmt.Errorf("unsupported key type: %s", keyType)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(blob)
+	if err != nil {
+		return "", "", "", errors.New("invalid public key encoding")
+	}
+
+	// The blob starts with the length-prefixed key type, which must match
+	if len(raw) < 4 {
+		return "", "", "", errors.New("invalid public key data")
+	}
+	n := binary.BigEndian.Uint32(raw[:4])
+	if uint32(len(raw)-4) < n || string(raw[4:4+n]) != keyType {
+		return "", "", "", errors.New("public key type does not match key data")
+	}
+
+	sum := sha256.Sum256(raw)
+	fingerprint = "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+	return keyType, blob, fingerprint, nil
+}
+
+// authorizedKeyLine renders a deploy key restricted to a single repository
+func authorizedKeyLine(key *models.DeployKey, repoPath string) (string, error) {
+	keyType, blob, _, err := parsePublicKey(key.PublicKey)
+	if err != nil {
+		return "", err
+	}
+
+	command := CLIPath + " git-shell -repo " + repoPath
+	if key.ReadOnly {
+		command += " -read-only"
+	}
+
+	return fmt.Sprintf(`command="%s",no-port-forwarding,no-X11-forwarding,no-agent-forwarding,no-pty %s %s owehost-deploy-key:%s`,
+		command, keyType, blob, key.ID), nil
+}
+
+// writeAuthorizedKeys replaces the OweHost block in the account's
+// authorized_keys, leaving keys the user manages themselves untouched
+func writeAuthorizedKeys(o *owner, lines []string) error {
+	sshDir := filepath.Join(o.homePath(), ".ssh")
+	if err := os.MkdirAll(sshDir, 0700); err != nil {
+		return fmt.Errorf("failed to create .ssh directory: %w", err)
+	}
+	chown(o, sshDir)
+
+	path := filepath.Join(sshDir, "authorized_keys")
+	existing, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read authorized_keys: %w", err)
+	}
+
+	var buf bytes.Buffer
+	inBlock := false
+	for _, line := range strings.Split(string(existing), "\n") {
+		switch {
+		case line == authorizedKeysBegin:
+			inBlock = true
+		case line == authorizedKeysEnd:
+			inBlock = false
+		case !inBlock && line != "":
+			buf.WriteString(line + "\n")
+		}
+	}
+
+	if len(lines) > 0 {
+		buf.WriteString(authorizedKeysBegin + "\n")
+		for _, line := range lines {
+			buf.WriteString(line + "\n")
+		}
+		buf.WriteString(authorizedKeysEnd + "\n")
+	}
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, buf.Bytes(), 0600); err != nil {
+		return fmt.Errorf("failed to write authorized_keys: %w", err)
+	}
+	chown(o, tmpPath)
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write authorized_keys: %w", err)
+	}
+	return nil
+}
+
+// ServeSSH runs the git command requested over SSH (SSH_ORIGINAL_COMMAND)
+// against repoPath only. It is the forced command of every deploy key.
+func ServeSSH(repoPath string, readOnly bool, original string) error {
+	fields := strings.Fields(original)
+	if len(fields) == 3 && fields[0] == "git" {
+		fields = []string{"git-" + fields[1], fields[2]}
+	}
+	if len(fields) != 2 {
+		return errors.New("interactive shell access is not allowed")
+	}
+
+	service := fields[0]
+	switch service {
+	case "git-upload-pack", "git-upload-archive":
+	case "git-receive-pack":
+		if readOnly {
+			return errors.New("this deploy key has read-only access")
+		}
+	default:
+		return fmt.Errorf("command not allowed: %s", service)
+	}
+
+	requested := strings.Trim(fields[1], `'"`)
+	if strings.TrimSuffix(filepath.Base(requested), ".git") != strings.TrimSuffix(filepath.Base(repoPath), ".git") {
+		return errors.New("this deploy key does not grant access to the requested repository")
+	}
+
+	cmd := exec.Command(service, repoPath)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,183 @@
+// Package git provides Git version control management for OweHost
+package git
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/iSundram/OweHost/pkg/models"
+)
+
+// storedState is what an account's git state file holds
+type storedState struct {
+	Repositories []*models.GitRepository             `json:"repositories"`
+	DeployKeys   []*models.DeployKey                 `json:"deploy_keys"`
+	Webhooks     []storedWebhook                     `json:"webhooks"`
+	Deployments  map[string][]*models.DeploymentInfo `json:"deployments"`
+}
+
+// storedWebhook keeps a webhook's secret, which the API never shows
+type storedWebhook struct {
+	Webhook *models.GitWebhook `json:"webhook"`
+	Secret  string             `json:"secret"`
+}
+
+// statePath is where an account's git state lives. It sits in the
+// root-owned runtime directory, out of reach of the account user, as it
+// holds webhook secrets.
+func statePath(accountPath string) string {
+	return filepath.Join(accountPath, "runtime", "git.json")
+}
+
+// register indexes a repository. Callers hold s.mu.
+func (s *Service) register(repo *models.GitRepository) {
+	s.repositories[repo.ID] = repo
+	s.byUser[repo.UserID] = append(s.byUser[repo.UserID], repo)
+	if repo.DomainID != nil {
+		s.byDomain[*repo.DomainID] = append(s.byDomain[*repo.DomainID], repo)
+	}
+}
+
+// unregister forgets a repository along with its deploy keys, webhooks and
+// deployments. Callers hold s.mu.
+func (s *Service) unregister(repo *models.GitRepository) {
+	userRepos := s.byUser[repo.UserID]
+	for i, r := range userRepos {
+		if r.ID == repo.ID {
+			s.byUser[repo.UserID] = append(userRepos[:i], userRepos[i+1:]...)
+			break
+		}
+	}
+
+	if repo.DomainID != nil {
+		domainRepos := s.byDomain[*repo.DomainID]
+		for i, r := range domainRepos {
+			if r.ID == repo.ID {
+				s.byDomain[*repo.DomainID] = append(domainRepos[:i], domainRepos[i+1:]...)
+				break
+			}
+		}
+	}
+
+	for keyID, key := range s.deployKeys {
+		if key.RepositoryID == repo.ID {
+			delete(s.deployKeys, keyID)
+		}
+	}
+	for whID, wh := range s.webhooks {
+		if wh.RepositoryID == repo.ID {
+			delete(s.webhooks, whID)
+		}
+	}
+
+	delete(s.repositories, repo.ID)
+	delete(s.deployments, repo.ID)
+	delete(s.deployLocks, repo.ID)
+}
+
+// persist saves the repositories, deploy keys, webhooks and deployments of
+// the account userID maps to. Callers hold s.mu.
+func (s *Service) persist(userID string) error {
+	o, err := s.resolveOwner(userID)
+	if err != nil {
+		return err
+	}
+
+	state := storedState{
+		Repositories: make([]*models.GitRepository, 0),
+		DeployKeys:   make([]*models.DeployKey, 0),
+		Webhooks:     make([]storedWebhook, 0),
+		Deployments:  make(map[string][]*models.DeploymentInfo),
+	}
+	for _, repo := range s.byUser[userID] {
+		state.Repositories = append(state.Repositories, repo)
+		if history := s.deployments[repo.ID]; len(history) > 0 {
+			state.Deployments[repo.ID] = history
+		}
+	}
+	for _, key := range s.deployKeys {
+		if repo, exists := s.repositories[key.RepositoryID]; exists && repo.UserID == userID {
+			state.DeployKeys = append(state.DeployKeys, key)
+		}
+	}
+	for _, wh := range s.webhooks {
+		if repo, exists := s.repositories[wh.RepositoryID]; exists && repo.UserID == userID {
+			state.Webhooks = append(state.Webhooks, storedWebhook{Webhook: wh, Secret: wh.Secret})
+		}
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := statePath(o.AccountPath)
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return fmt.Errorf("failed to create runtime directory: %w", err)
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write git state: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write git state: %w", err)
+	}
+	return nil
+}
+
+// Load restores the git state saved for every account. Deployments that a
+// panel restart cut short are marked failed.
+func (s *Service) Load() error {
+	accountIDs, err := s.accounts.ListAccounts()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, accountID := range accountIDs {
+		data, err := os.ReadFile(statePath(s.accounts.AccountPath(accountID)))
+		if err != nil {
+			continue
+		}
+		var state storedState
+		if err := json.Unmarshal(data, &state); err != nil {
+			continue
+		}
+
+		for _, repo := range state.Repositories {
+			if _, exists := s.repositories[repo.ID]; exists {
+				continue
+			}
+			s.register(repo)
+			for _, d := range state.Deployments[repo.ID] {
+				if d.Status == models.DeploymentPending || d.Status == models.DeploymentRunning {
+					d.Status = models.DeploymentFailed
+					d.Message = "interrupted by a panel restart"
+				}
+			}
+			if history := state.Deployments[repo.ID]; len(history) > 0 {
+				s.deployments[repo.ID] = history
+			}
+		}
+		for _, key := range state.DeployKeys {
+			if _, exists := s.repositories[key.RepositoryID]; exists {
+				s.deployKeys[key.ID] = key
+			}
+		}
+		for _, stored := range state.Webhooks {
+			if stored.Webhook == nil {
+				continue
+			}
+			if _, exists := s.repositories[stored.Webhook.RepositoryID]; exists {
+				stored.Webhook.Secret = stored.Secret
+				s.webhooks[stored.Webhook.ID] = stored.Webhook
+			}
+		}
+	}
+	return nil
+}
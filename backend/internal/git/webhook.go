@@ -0,0 +1,153 @@
+// Package git provides Git version control management for OweHost
+package git
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/iSundram/OweHost/pkg/models"
+)
+
+// WebhookPathPrefix is where Git providers deliver push events, followed by the webhook ID
+const WebhookPathPrefix = "/api/v1/git/hooks/"
+
+// Webhook delivery errors
+var (
+	ErrWebhookNotFound  = errors.New("webhook not found")
+	ErrWebhookSignature = errors.New("invalid webhook signature")
+	ErrWebhookPayload   = errors.New("invalid webhook payload")
+)
+
+// pushPayload holds the fields GitHub, GitLab and Gitea push events share
+type pushPayload struct {
+	Ref   string `json:"ref"`
+	After string `json:"after"`
+}
+
+// webhookEvent returns the event name a provider sent, normalised to "push" or "ping"
+func webhookEvent(provider string, header http.Header) string {
+	switch provider {
+	case models.GitProviderGitHub:
+		return header.Get("X-GitHub-Event")
+	case models.GitProviderGitea:
+		return header.Get("X-Gitea-Event")
+	case models.GitProviderGitLab:
+		if header.Get("X-Gitlab-Event") == "Push Hook" {
+			return "push"
+		}
+		return header.Get("X-Gitlab-Event")
+	}
+	return ""
+}
+
+// verifyWebhook checks the provider's signature or token against the webhook secret
+func verifyWebhook(provider, secret string, header http.Header, body []byte) error {
+	switch provider {
+	case models.GitProviderGitHub:
+		return verifyHMAC(secret, strings.TrimPrefix(header.Get("X-Hub-Signature-256"), "sha256="), body)
+	case models.GitProviderGitea:
+		return verifyHMAC(secret, header.Get("X-Gitea-Signature"), body)
+	case models.GitProviderGitLab:
+		// GitLab sends the secret token itself rather than a signature
+		if subtle.ConstantTimeCompare([]byte(header.Get("X-Gitlab-Token")), []byte(secret)) == 1 {
+			return nil
+		}
+	}
+	return ErrWebhookSignature
+}
+
+// verifyHMAC compares a hex HMAC-SHA256 of body in constant time
+func verifyHMAC(secret, signature string, body []byte) error {
+	got, err := hex.DecodeString(signature)
+	if err != nil || len(got) == 0 {
+		return ErrWebhookSignature
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return ErrWebhookSignature
+	}
+	return nil
+}
+
+// ReceiveWebhook verifies a provider delivery and, for pushes to the watched
+// branch, starts a fetch and deploy in the background. A nil deployment with a
+// nil error means the delivery was valid but needed no action.
+func (s *Service) ReceiveWebhook(webhookID string, header http.Header, body []byte) (*models.DeploymentInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	webhook, exists := s.webhooks[webhookID]
+	if !exists {
+		return nil, ErrWebhookNotFound
+	}
+	if err := verifyWebhook(webhook.Provider, webhook.Secret, header, body); err != nil {
+		return nil, err
+	}
+
+	repo, exists := s.repositories[webhook.RepositoryID]
+	if !exists {
+		return nil, ErrWebhookNotFound
+	}
+
+	now := time.Now()
+	webhook.LastDeliveryAt = &now
+	if err := s.persist(repo.UserID); err != nil {
+		return nil, err
+	}
+
+	event := webhookEvent(webhook.Provider, header)
+	if !webhook.Enabled || event != "push" || !subscribed(webhook, event) {
+		return nil, nil
+	}
+
+	var payload pushPayload
+	if err := json.Unmarshal(body, &payload); err != nil || payload.Ref == "" {
+		return nil, ErrWebhookPayload
+	}
+
+	branch := webhook.Branch
+	if branch == "" {
+		branch = repo.DefaultBranch
+	}
+
+	// Pushes to other branches and branch deletions are acknowledged and ignored
+	if payload.Ref != "refs/heads/"+branch || payload.After == zeroSHA {
+		return nil, nil
+	}
+	if !repo.AutoDeploy {
+		return nil, nil
+	}
+
+	job, err := s.newDeployJob(repo, branch, "webhook:"+webhook.Provider, true)
+	if err != nil {
+		return nil, err
+	}
+
+	go s.runDeployJob(job)
+
+	d := *job.deployment
+	return &d, nil
+}
+
+// subscribed reports whether a webhook was set up for event. Webhooks
+// saved without events get pushes, as new ones do by default.
+func subscribed(webhook *models.GitWebhook, event string) bool {
+	if len(webhook.Events) == 0 {
+		return event == "push"
+	}
+	for _, e := range webhook.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
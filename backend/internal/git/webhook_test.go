@@ -0,0 +1,202 @@
+package git
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/iSundram/OweHost/internal/storage/account"
+	"github.com/iSundram/OweHost/pkg/config"
+	"github.com/iSundram/OweHost/pkg/models"
+)
+
+const testSecret = "s3cret"
+
+// sign returns the hex HMAC-SHA256 of body under secret
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// headers builds a header set from name/value pairs
+func headers(pairs ...string) http.Header {
+	h := make(http.Header)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		h.Set(pairs[i], pairs[i+1])
+	}
+	return h
+}
+
+func TestVerifyWebhook(t *testing.T) {
+	body := []byte(`{"ref":"refs/heads/main"}`)
+	good := sign(testSecret, body)
+
+	tests := []struct {
+		name     string
+		provider string
+		header   http.Header
+		valid    bool
+	}{
+		{"github signature", models.GitProviderGitHub, headers("X-Hub-Signature-256", "sha256="+good), true},
+		{"github wrong secret", models.GitProviderGitHub, headers("X-Hub-Signature-256", "sha256="+sign("other", body)), false},
+		{"github missing signature", models.GitProviderGitHub, headers(), false},
+		{"github not hex", models.GitProviderGitHub, headers("X-Hub-Signature-256", "sha256=zz"), false},
+		{"github gitea header", models.GitProviderGitHub, headers("X-Gitea-Signature", good), false},
+		{"gitea signature", models.GitProviderGitea, headers("X-Gitea-Signature", good), true},
+		{"gitea truncated", models.GitProviderGitea, headers("X-Gitea-Signature", good[:32]), false},
+		{"gitlab token", models.GitProviderGitLab, headers("X-Gitlab-Token", testSecret), true},
+		{"gitlab wrong token", models.GitProviderGitLab, headers("X-Gitlab-Token", "s3cre"), false},
+		{"gitlab signature instead of token", models.GitProviderGitLab, headers("X-Gitlab-Token", good), false},
+		{"unknown provider", "bitbucket", headers("X-Hub-Signature-256", "sha256="+good), false},
+	}
+	for _, tt := range tests {
+		err := verifyWebhook(tt.provider, testSecret, tt.header, body)
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: expected valid=%v, got %v", tt.name, tt.valid, err)
+		}
+		if err != nil && !errors.Is(err, ErrWebhookSignature) {
+			t.Errorf("%s: expected ErrWebhookSignature, got %v", tt.name, err)
+		}
+	}
+}
+
+func TestVerifyWebhook_BodyTampered(t *testing.T) {
+	signature := sign(testSecret, []byte(`{"ref":"refs/heads/main"}`))
+	err := verifyWebhook(models.GitProviderGitHub, testSecret, headers("X-Hub-Signature-256", "sha256="+signature), []byte(`{"ref":"refs/heads/evil"}`))
+	if !errors.Is(err, ErrWebhookSignature) {
+		t.Errorf("Expected ErrWebhookSignature for a tampered body, got %v", err)
+	}
+}
+
+func TestWebhookEvent(t *testing.T) {
+	tests := []struct {
+		provider string
+		header   http.Header
+		want     string
+	}{
+		{models.GitProviderGitHub, headers("X-GitHub-Event", "push"), "push"},
+		{models.GitProviderGitHub, headers("X-GitHub-Event", "ping"), "ping"},
+		{models.GitProviderGitea, headers("X-Gitea-Event", "push"), "push"},
+		{models.GitProviderGitLab, headers("X-Gitlab-Event", "Push Hook"), "push"},
+		{models.GitProviderGitLab, headers("X-Gitlab-Event", "Tag Push Hook"), "Tag Push Hook"},
+		{models.GitProviderGitLab, headers("X-GitHub-Event", "push"), ""},
+	}
+	for _, tt := range tests {
+		if got := webhookEvent(tt.provider, tt.header); got != tt.want {
+			t.Errorf("%s %v: expected %q, got %q", tt.provider, tt.header, tt.want, got)
+		}
+	}
+}
+
+// newWebhookService returns a service whose state is saved under a
+// temporary account named alice, with repository repo-1 registered
+func newWebhookService(t *testing.T) (*Service, *account.StateManager) {
+	t.Helper()
+	accounts := account.NewStateManagerWithPath(t.TempDir())
+	if err := accounts.CreateAccountStructure(1001); err != nil {
+		t.Fatal(err)
+	}
+	if err := accounts.WriteIdentity(1001, &account.AccountIdentity{ID: 1001, Name: "alice", UID: os.Getuid(), GID: os.Getgid()}); err != nil {
+		t.Fatal(err)
+	}
+
+	svc := NewService(&config.Config{}, nil)
+	svc.accounts = accounts
+	svc.register(&models.GitRepository{ID: "repo-1", UserID: "alice", DefaultBranch: "main", AutoDeploy: true})
+	return svc, accounts
+}
+
+// delivery returns a signed GitHub delivery of event
+func delivery(event, body string) (http.Header, []byte) {
+	return headers("X-GitHub-Event", event, "X-Hub-Signature-256", "sha256="+sign(testSecret, []byte(body))), []byte(body)
+}
+
+func TestReceiveWebhook_NoDeploy(t *testing.T) {
+	svc, _ := newWebhookService(t)
+	svc.webhooks["wh-1"] = &models.GitWebhook{ID: "wh-1", RepositoryID: "repo-1", Provider: models.GitProviderGitHub, Events: []string{"push"}, Enabled: true, Secret: testSecret}
+	svc.webhooks["wh-off"] = &models.GitWebhook{ID: "wh-off", RepositoryID: "repo-1", Provider: models.GitProviderGitHub, Events: []string{"push"}, Secret: testSecret}
+	svc.webhooks["wh-release"] = &models.GitWebhook{ID: "wh-release", RepositoryID: "repo-1", Provider: models.GitProviderGitHub, Events: []string{"release"}, Enabled: true, Secret: testSecret}
+
+	tests := []struct {
+		name    string
+		webhook string
+		event   string
+		body    string
+		err     error
+	}{
+		{"unknown webhook", "wh-missing", "push", `{"ref":"refs/heads/main"}`, ErrWebhookNotFound},
+		{"ping", "wh-1", "ping", `{"zen":"hi"}`, nil},
+		{"disabled", "wh-off", "push", `{"ref":"refs/heads/main","after":"abc"}`, nil},
+		{"not subscribed", "wh-release", "push", `{"ref":"refs/heads/main","after":"abc"}`, nil},
+		{"other branch", "wh-1", "push", `{"ref":"refs/heads/dev","after":"abc"}`, nil},
+		{"branch deleted", "wh-1", "push", `{"ref":"refs/heads/main","after":"` + zeroSHA + `"}`, nil},
+		{"no ref", "wh-1", "push", `{"after":"abc"}`, ErrWebhookPayload},
+		{"not json", "wh-1", "push", `ref=main`, ErrWebhookPayload},
+	}
+	for _, tt := range tests {
+		header, body := delivery(tt.event, tt.body)
+		d, err := svc.ReceiveWebhook(tt.webhook, header, body)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.err, err)
+		}
+		if d != nil {
+			t.Errorf("%s: expected no deployment, got %+v", tt.name, d)
+		}
+	}
+
+	header, body := delivery("push", `{"ref":"refs/heads/main"}`)
+	header.Set("X-Hub-Signature-256", "sha256="+sign("other", body))
+	if _, err := svc.ReceiveWebhook("wh-1", header, body); !errors.Is(err, ErrWebhookSignature) {
+		t.Errorf("Expected ErrWebhookSignature for a forged delivery, got %v", err)
+	}
+	if len(svc.deployments["repo-1"]) != 0 {
+		t.Errorf("Expected no deployments, got %d", len(svc.deployments["repo-1"]))
+	}
+}
+
+func TestReceiveWebhook_SavesDelivery(t *testing.T) {
+	svc, accounts := newWebhookService(t)
+	svc.webhooks["wh-1"] = &models.GitWebhook{ID: "wh-1", RepositoryID: "repo-1", Provider: models.GitProviderGitHub, Events: []string{"push"}, Enabled: true, Secret: testSecret}
+
+	header, body := delivery("ping", `{"zen":"hi"}`)
+	if _, err := svc.ReceiveWebhook("wh-1", header, body); err != nil {
+		t.Fatalf("Delivery failed: %v", err)
+	}
+
+	restarted := NewService(&config.Config{}, nil)
+	restarted.accounts = accounts
+	if err := restarted.Load(); err != nil {
+		t.Fatalf("Failed to load: %v", err)
+	}
+	webhook, exists := restarted.webhooks["wh-1"]
+	if !exists {
+		t.Fatal("Expected the webhook to be loaded")
+	}
+	if webhook.LastDeliveryAt == nil || !webhook.LastDeliveryAt.Equal(*svc.webhooks["wh-1"].LastDeliveryAt) {
+		t.Errorf("Expected the last delivery to survive a restart, got %v", webhook.LastDeliveryAt)
+	}
+}
+
+func TestSubscribed(t *testing.T) {
+	tests := []struct {
+		events []string
+		event  string
+		want   bool
+	}{
+		{[]string{"push"}, "push", true},
+		{[]string{"push", "ping"}, "ping", true},
+		{[]string{"release"}, "push", false},
+		{nil, "push", true},
+		{nil, "ping", false},
+	}
+	for _, tt := range tests {
+		if got := subscribed(&models.GitWebhook{Events: tt.events}, tt.event); got != tt.want {
+			t.Errorf("%v %s: expected %v, got %v", tt.events, tt.event, tt.want, got)
+		}
+	}
+}
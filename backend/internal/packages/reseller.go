@@ -0,0 +1,138 @@
+package packages
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/iSundram/OweHost/internal/storage/account"
+)
+
+var (
+	// ErrPackageNotFound is returned for a reseller package that doesn't exist
+	ErrPackageNotFound = errors.New("package not found")
+	// ErrPackageExists is returned when a reseller already has a package of
+	// the name
+	ErrPackageExists = errors.New("package already exists")
+	// ErrInvalidPackage is returned for a reseller package that can't be
+	// built
+	ErrInvalidPackage = errors.New("invalid package")
+)
+
+var validPackageName = regexp.MustCompile(`^[a-z][a-z0-9-]{1,31}$`)
+
+// ResellerPackage is a package a reseller offers its accounts, built on
+// one of the built-in packages. Accounts on it take its limits and keep
+// the base package as their plan.
+type ResellerPackage struct {
+	Package
+	Owner     string    `json:"owner"` // Reseller user ID
+	BasePlan  string    `json:"base_plan"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ResellerPackageRequest represents a request to create a reseller package
+type ResellerPackageRequest struct {
+	Name        string                  `json:"name"`
+	DisplayName string                  `json:"display_name"`
+	Description string                  `json:"description"`
+	BasePlan    string                  `json:"base_plan"`
+	Limits      *account.ResourceLimits `json:"limits,omitempty"`   // The base package's when empty
+	Features    map[string]bool         `json:"features,omitempty"` // The base package's when empty
+	Price       *PackagePrice           `json:"price,omitempty"`
+}
+
+// CreateResellerPackage creates a package for the reseller with user ID
+// resellerUserID
+func (s *Service) CreateResellerPackage(resellerUserID string, req *ResellerPackageRequest) (*ResellerPackage, error) {
+	if !validPackageName.MatchString(req.Name) {
+		return nil, fmt.Errorf("%w: name must be 2-32 lowercase letters, digits or dashes", ErrInvalidPackage)
+	}
+	if account.IsValidPlan(req.Name) {
+		return nil, fmt.Errorf("%w: %s is a built-in package", ErrInvalidPackage, req.Name)
+	}
+	base, err := s.Get(req.BasePlan)
+	if err != nil {
+		return nil, fmt.Errorf("%w: unknown base plan %q", ErrInvalidPackage, req.BasePlan)
+	}
+
+	pkg := &ResellerPackage{
+		Package: Package{
+			Name:        req.Name,
+			DisplayName: req.DisplayName,
+			Description: req.Description,
+			Limits:      base.Limits,
+			Features:    base.Features,
+			Price:       req.Price,
+		},
+		Owner:     resellerUserID,
+		BasePlan:  base.Name,
+		CreatedAt: time.Now(),
+	}
+	if pkg.DisplayName == "" {
+		pkg.DisplayName = req.Name
+	}
+	if req.Limits != nil {
+		pkg.Limits = *req.Limits
+		if err := account.ValidateLimits(&pkg.Limits); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPackage, err)
+		}
+	}
+	if len(req.Features) > 0 {
+		pkg.Features = req.Features
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	owned := s.resellerPackages[resellerUserID]
+	if owned == nil {
+		owned = make(map[string]*ResellerPackage)
+		s.resellerPackages[resellerUserID] = owned
+	}
+	if _, exists := owned[pkg.Name]; exists {
+		return nil, ErrPackageExists
+	}
+	owned[pkg.Name] = pkg
+	return pkg, nil
+}
+
+// GetResellerPackage returns one of a reseller's packages
+func (s *Service) GetResellerPackage(resellerUserID, name string) (*ResellerPackage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pkg, exists := s.resellerPackages[resellerUserID][name]
+	if !exists {
+		return nil, ErrPackageNotFound
+	}
+	return pkg, nil
+}
+
+// ListResellerPackages returns a reseller's packages by name
+func (s *Service) ListResellerPackages(resellerUserID string) []*ResellerPackage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pkgs := make([]*ResellerPackage, 0, len(s.resellerPackages[resellerUserID]))
+	for _, pkg := range s.resellerPackages[resellerUserID] {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].Name < pkgs[j].Name })
+	return pkgs
+}
+
+// DeleteResellerPackage deletes one of a reseller's packages. Accounts on
+// it keep the limits they were given.
+func (s *Service) DeleteResellerPackage(resellerUserID, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.resellerPackages[resellerUserID][name]; !exists {
+		return ErrPackageNotFound
+	}
+	delete(s.resellerPackages[resellerUserID], name)
+	return nil
+}
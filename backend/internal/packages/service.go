@@ -3,18 +3,24 @@ package packages
 
 import (
 	"fmt"
+	"sync"
 
 	"github.com/iSundram/OweHost/internal/storage/account"
 )
 
-// Service provides package/plan management functionality
+// Service provides package/plan management functionality. The built-in
+// packages are the account plans; resellers define their own on top.
 type Service struct {
-	// No dependencies needed - uses account package constants
+	// resellerPackages are keyed by reseller user ID, then package name
+	resellerPackages map[string]map[string]*ResellerPackage
+	mu               sync.RWMutex
 }
 
 // NewService creates a new package service
 func NewService() *Service {
-	return &Service{}
+	return &Service{
+		resellerPackages: make(map[string]map[string]*ResellerPackage),
+	}
 }
 
 // Package represents an account package/plan
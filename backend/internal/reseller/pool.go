@@ -0,0 +1,142 @@
+package reseller
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/iSundram/OweHost/pkg/models"
+)
+
+var (
+	// ErrPoolExceeded is returned for an allocation beyond what a
+	// reseller's resource pool allows
+	ErrPoolExceeded = errors.New("reseller resource pool exceeded")
+	// ErrInvalidOversell is returned for an oversell ratio below 1
+	ErrInvalidOversell = errors.New("oversell ratios must be 0 or at least 1")
+)
+
+// validatePool checks a pool's oversell ratios
+func validatePool(pool *models.ResourcePool) error {
+	for _, ratio := range []float64{
+		pool.Oversell.Disk,
+		pool.Oversell.Bandwidth,
+		pool.Oversell.Accounts,
+		pool.Oversell.Domains,
+		pool.Oversell.Databases,
+	} {
+		if ratio != 0 && ratio < 1 {
+			return ErrInvalidOversell
+		}
+	}
+	return nil
+}
+
+// Allocatable returns how much of each resource a pool lets its reseller
+// hand out: the pool times its oversell ratio, or -1 where the pool
+// doesn't cap the resource
+func Allocatable(pool models.ResourcePool) models.ResourceAllocation {
+	return models.ResourceAllocation{
+		Accounts:    int(oversold(int64(pool.MaxUsers), pool.Oversell.Accounts)),
+		DiskMB:      oversold(pool.MaxDiskMB, pool.Oversell.Disk),
+		BandwidthMB: oversold(pool.MaxBandwidthMB, pool.Oversell.Bandwidth),
+		Domains:     int(oversold(int64(pool.MaxDomains), pool.Oversell.Domains)),
+		Databases:   int(oversold(int64(pool.MaxDatabases), pool.Oversell.Databases)),
+	}
+}
+
+func oversold(max int64, ratio float64) int64 {
+	if max <= 0 {
+		return -1
+	}
+	if ratio < 1 {
+		ratio = 1
+	}
+	return int64(float64(max) * ratio)
+}
+
+// CheckAllocation returns ErrPoolExceeded, naming the resource, when
+// allocated goes beyond what pool lets its reseller hand out
+func CheckAllocation(pool models.ResourcePool, allocated models.ResourceAllocation) error {
+	limit := Allocatable(pool)
+	for _, c := range []struct {
+		resource         string
+		allocated, limit int64
+	}{
+		{"accounts", int64(allocated.Accounts), int64(limit.Accounts)},
+		{"disk_mb", allocated.DiskMB, limit.DiskMB},
+		{"bandwidth_mb", allocated.BandwidthMB, limit.BandwidthMB},
+		{"domains", int64(allocated.Domains), int64(limit.Domains)},
+		{"databases", int64(allocated.Databases), int64(limit.Databases)},
+	} {
+		if c.limit < 0 {
+			continue
+		}
+		if c.allocated < 0 {
+			return fmt.Errorf("%w: unlimited %s from a pool of %d", ErrPoolExceeded, c.resource, c.limit)
+		}
+		if c.allocated > c.limit {
+			return fmt.Errorf("%w: %d %s from a pool of %d", ErrPoolExceeded, c.allocated, c.resource, c.limit)
+		}
+	}
+	return nil
+}
+
+// AddAllocation adds b to a. Unlimited wins.
+func AddAllocation(a, b models.ResourceAllocation) models.ResourceAllocation {
+	return models.ResourceAllocation{
+		Accounts:    int(addAmount(int64(a.Accounts), int64(b.Accounts))),
+		DiskMB:      addAmount(a.DiskMB, b.DiskMB),
+		BandwidthMB: addAmount(a.BandwidthMB, b.BandwidthMB),
+		Domains:     int(addAmount(int64(a.Domains), int64(b.Domains))),
+		Databases:   int(addAmount(int64(a.Databases), int64(b.Databases))),
+	}
+}
+
+func addAmount(a, b int64) int64 {
+	if a < 0 || b < 0 {
+		return -1
+	}
+	return a + b
+}
+
+// Ancestry returns the reseller with user ID userID followed by the
+// resellers above it, nearest first
+func (s *Service) Ancestry(userID string) []*models.Reseller {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var chain []*models.Reseller
+	r, exists := s.byUserID[userID]
+	for exists && len(chain) <= len(s.resellers) {
+		chain = append(chain, r)
+		if r.ParentResellerID == nil {
+			break
+		}
+		r, exists = s.resellers[*r.ParentResellerID]
+	}
+	return chain
+}
+
+// SubtreeUserIDs returns the user IDs of reseller id and every reseller
+// below it
+func (s *Service) SubtreeUserIDs(id string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, exists := s.resellers[id]
+	if !exists {
+		return nil
+	}
+	userIDs := []string{r.UserID}
+	seen := map[string]bool{id: true}
+	for queue := []string{id}; len(queue) > 0; queue = queue[1:] {
+		for _, child := range s.resellers {
+			if child.ParentResellerID != nil && *child.ParentResellerID == queue[0] && !seen[child.ID] {
+				seen[child.ID] = true
+				userIDs = append(userIDs, child.UserID)
+				queue = append(queue, child.ID)
+			}
+		}
+	}
+	return userIDs
+}
@@ -0,0 +1,93 @@
+package reseller_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/iSundram/OweHost/internal/reseller"
+	"github.com/iSundram/OweHost/pkg/models"
+)
+
+func TestAllocatable_Oversell(t *testing.T) {
+	pool := models.ResourcePool{
+		MaxUsers:  10,
+		MaxDiskMB: 10240,
+		Oversell:  models.OversellRatios{Disk: 1.5},
+	}
+
+	got := reseller.Allocatable(pool)
+	if got.Accounts != 10 {
+		t.Errorf("Expected 10 accounts, got %d", got.Accounts)
+	}
+	if got.DiskMB != 15360 {
+		t.Errorf("Expected 15360 MB of disk oversold 1.5x, got %d", got.DiskMB)
+	}
+	if got.Domains != -1 || got.BandwidthMB != -1 {
+		t.Errorf("Expected resources without a max to be uncapped, got %+v", got)
+	}
+}
+
+func TestCheckAllocation(t *testing.T) {
+	pool := models.ResourcePool{MaxUsers: 2, MaxDomains: 10, Oversell: models.OversellRatios{Domains: 2}}
+
+	tests := []struct {
+		name      string
+		allocated models.ResourceAllocation
+		exceeded  bool
+	}{
+		{"within pool", models.ResourceAllocation{Accounts: 2, Domains: 10}, false},
+		{"within oversell", models.ResourceAllocation{Accounts: 2, Domains: 20}, false},
+		{"beyond oversell", models.ResourceAllocation{Accounts: 2, Domains: 21}, true},
+		{"too many accounts", models.ResourceAllocation{Accounts: 3}, true},
+		{"unlimited from a capped pool", models.ResourceAllocation{Accounts: 1, Domains: -1}, true},
+		{"unlimited from an uncapped pool", models.ResourceAllocation{Accounts: 1, Databases: -1}, false},
+	}
+	for _, tt := range tests {
+		err := reseller.CheckAllocation(pool, tt.allocated)
+		if errors.Is(err, reseller.ErrPoolExceeded) != tt.exceeded {
+			t.Errorf("%s: expected exceeded=%v, got %v", tt.name, tt.exceeded, err)
+		}
+	}
+}
+
+func TestService_AncestryAndSubtree(t *testing.T) {
+	svc := reseller.NewService()
+
+	top, err := svc.Create(&models.ResellerCreateRequest{UserID: "usr-top", Name: "Top"})
+	if err != nil {
+		t.Fatalf("Failed to create reseller: %v", err)
+	}
+	mid, err := svc.Create(&models.ResellerCreateRequest{UserID: "usr-mid", ParentResellerID: &top.ID, Name: "Mid"})
+	if err != nil {
+		t.Fatalf("Failed to create reseller: %v", err)
+	}
+	if _, err := svc.Create(&models.ResellerCreateRequest{UserID: "usr-leaf", ParentResellerID: &mid.ID, Name: "Leaf"}); err != nil {
+		t.Fatalf("Failed to create reseller: %v", err)
+	}
+
+	chain := svc.Ancestry("usr-leaf")
+	if len(chain) != 3 || chain[0].UserID != "usr-leaf" || chain[2].UserID != "usr-top" {
+		t.Errorf("Expected leaf, mid, top; got %d resellers", len(chain))
+	}
+
+	subtree := map[string]bool{}
+	for _, userID := range svc.SubtreeUserIDs(mid.ID) {
+		subtree[userID] = true
+	}
+	if len(subtree) != 2 || !subtree["usr-mid"] || !subtree["usr-leaf"] {
+		t.Errorf("Expected mid and leaf, got %v", subtree)
+	}
+}
+
+func TestService_RejectsOversellBelowOne(t *testing.T) {
+	svc := reseller.NewService()
+
+	_, err := svc.Create(&models.ResellerCreateRequest{
+		UserID:       "usr-res",
+		Name:         "Reseller",
+		ResourcePool: models.ResourcePool{MaxDiskMB: 1024, Oversell: models.OversellRatios{Disk: 0.5}},
+	})
+	if !errors.Is(err, reseller.ErrInvalidOversell) {
+		t.Errorf("Expected ErrInvalidOversell, got %v", err)
+	}
+}
@@ -34,6 +34,9 @@ func (s *Service) Create(req *models.ResellerCreateRequest) (*models.Reseller, e
 	if _, exists := s.byUserID[req.UserID]; exists {
 		return nil, errors.New("user is already a reseller")
 	}
+	if err := validatePool(&req.ResourcePool); err != nil {
+		return nil, err
+	}
 
 	reseller := &models.Reseller{
 		ID:               utils.GenerateID("res"),
@@ -96,6 +99,11 @@ func (s *Service) Update(id string, req *models.ResellerUpdateRequest) (*models.
 	if !exists {
 		return nil, errors.New("reseller not found")
 	}
+	if req.ResourcePool != nil {
+		if err := validatePool(req.ResourcePool); err != nil {
+			return nil, err
+		}
+	}
 
 	if req.Name != nil {
 		reseller.Name = *req.Name
@@ -0,0 +1,527 @@
+// Package runtime provides runtime and language management for OweHost
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iSundram/OweHost/internal/storage/account"
+	"github.com/iSundram/OweHost/internal/storage/web"
+	"github.com/iSundram/OweHost/pkg/models"
+)
+
+// provisionTimeout bounds virtualenv creation and dependency installation
+const provisionTimeout = 10 * time.Minute
+
+// appRecord is the on-disk form of an application, stored in
+// <account>/runtime/apps/<id>.json so apps survive panel restarts
+type appRecord struct {
+	Type   models.RuntimeType `json:"type"`
+	NodeJS *models.NodeJSApp  `json:"nodejs,omitempty"`
+	Python *models.PythonApp  `json:"python,omitempty"`
+}
+
+// appOwner is the hosting account an application runs as
+type appOwner struct {
+	ID   int
+	Name string
+	UID  int
+	GID  int
+	Path string
+}
+
+// resolveOwner maps a panel user to the hosting account that runs its apps
+func (s *Service) resolveOwner(userID string) (*appOwner, error) {
+	identity, err := s.accounts.FindByName(userID)
+	if err != nil {
+		return nil, errors.New("no hosting account found for user")
+	}
+
+	return &appOwner{
+		ID:   identity.ID,
+		Name: identity.Name,
+		UID:  identity.UID,
+		GID:  identity.GID,
+		Path: s.accounts.AccountPath(identity.ID),
+	}, nil
+}
+
+// resolveAppRoot makes appRoot absolute and confines it to the account
+func resolveAppRoot(o *appOwner, appRoot string) (string, error) {
+	if appRoot == "" {
+		return "", errors.New("app root cannot be empty")
+	}
+
+	path := appRoot
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(o.Path, path)
+	}
+	path = filepath.Clean(path)
+
+	if !strings.HasPrefix(path, o.Path+string(filepath.Separator)) {
+		return "", errors.New("app root must be inside the account directory")
+	}
+	return path, nil
+}
+
+// appsDir is where an account's application records live
+func appsDir(o *appOwner) string {
+	return filepath.Join(o.Path, "runtime", "apps")
+}
+
+// appLogPath is the supervised output log of an application
+func appLogPath(o *appOwner, name, id string) string {
+	return filepath.Join(o.Path, "logs", "apps", name+"-"+id+".log")
+}
+
+// saveApp writes an application record atomically
+func saveApp(o *appOwner, id string, record *appRecord) error {
+	dir := appsDir(o)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return fmt.Errorf("failed to create apps directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, id+".json")
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0640); err != nil {
+		return fmt.Errorf("failed to write app record: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write app record: %w", err)
+	}
+	return nil
+}
+
+// removeApp deletes an application record
+func removeApp(o *appOwner, id string) {
+	os.Remove(filepath.Join(appsDir(o), id+".json"))
+}
+
+// persistApp saves the current state of an app. Callers hold s.mu.
+func (s *Service) persistApp(id string) error {
+	var userID string
+	record := &appRecord{}
+	if app, exists := s.nodejsApps[id]; exists {
+		userID, record.Type, record.NodeJS = app.UserID, models.RuntimeTypeNodeJS, app
+	} else if app, exists := s.pythonApps[id]; exists {
+		userID, record.Type, record.Python = app.UserID, models.RuntimeTypePython, app
+	} else {
+		return errors.New("app not found")
+	}
+
+	o, err := s.resolveOwner(userID)
+	if err != nil {
+		return err
+	}
+	return saveApp(o, id, record)
+}
+
+// onProcessUpdate mirrors supervisor state into the app models
+func (s *Service) onProcessUpdate(id string, pid int, status models.AppProcessStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var pidField **int
+	if app, exists := s.nodejsApps[id]; exists {
+		app.Status = status
+		pidField = &app.PID
+	} else if app, exists := s.pythonApps[id]; exists {
+		app.Status = status
+		pidField = &app.PID
+	} else {
+		return
+	}
+
+	previous := 0
+	if *pidField != nil {
+		previous = **pidField
+	}
+	if pid > 0 {
+		*pidField = &pid
+	} else {
+		*pidField = nil
+	}
+
+	// Only a new PID needs to reach disk; it is what reconcile adopts
+	if previous != pid {
+		s.persistApp(id)
+	}
+}
+
+// baseEnv is the environment every app starts from. The panel's own
+// environment is not inherited, as it holds secrets.
+func baseEnv(o *appOwner, port int) map[string]string {
+	return map[string]string{
+		"PATH": "/usr/local/bin:/usr/bin:/bin",
+		"HOME": filepath.Join(o.Path, "home"),
+		"USER": o.Name,
+		"LANG": "C.UTF-8",
+		"PORT": strconv.Itoa(port),
+		"HOST": "127.0.0.1",
+	}
+}
+
+// envList flattens env layers, later layers winning
+func envList(layers ...map[string]string) []string {
+	merged := make(map[string]string)
+	for _, layer := range layers {
+		for k, v := range layer {
+			merged[k] = v
+		}
+	}
+
+	env := make([]string, 0, len(merged))
+	for k, v := range merged {
+		env = append(env, k+"="+v)
+	}
+	return env
+}
+
+// runtimeBinary returns the interpreter for a version, falling back to PATH
+func (s *Service) runtimeBinary(runtimeType models.RuntimeType, version string, fallbacks ...string) (string, error) {
+	for _, v := range s.versions[runtimeType] {
+		if v.Version == version {
+			if _, err := os.Stat(v.Path); err == nil {
+				return v.Path, nil
+			}
+		}
+	}
+	for _, name := range fallbacks {
+		if path, err := exec.LookPath(name); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("%s %s is not installed", runtimeType, version)
+}
+
+// nodeSpec builds the process spec of a Node.js app. Callers hold s.mu.
+func (s *Service) nodeSpec(o *appOwner, app *models.NodeJSApp, site *web.SiteDescriptor) (appSpec, error) {
+	node, err := s.runtimeBinary(models.RuntimeTypeNodeJS, app.Version, "node"+app.Version, "node")
+	if err != nil {
+		return appSpec{}, err
+	}
+
+	siteEnv := map[string]string{}
+	if site != nil && site.NodeSettings != nil {
+		siteEnv = site.NodeSettings.Environment
+	}
+
+	return appSpec{
+		ID:          app.ID,
+		Command:     node,
+		Args:        []string{app.StartFile},
+		Dir:         app.AppRoot,
+		Env:         envList(baseEnv(o, app.Port), map[string]string{"NODE_ENV": "production"}, siteEnv, app.Environment),
+		UID:         o.UID,
+		GID:         o.GID,
+		CgroupPath:  account.CgroupPath(o.ID),
+		LogPath:     appLogPath(o, app.Name, app.ID),
+		Port:        app.Port,
+		AutoRestart: app.AutoRestart,
+		MaxRestarts: app.MaxRestarts,
+	}, nil
+}
+
+// pythonSpec builds the process spec of a Python app served by gunicorn
+// from its virtualenv. Callers hold s.mu.
+func (s *Service) pythonSpec(o *appOwner, app *models.PythonApp, site *web.SiteDescriptor) (appSpec, error) {
+	gunicorn := filepath.Join(app.VenvPath, "bin", "gunicorn")
+	if _, err := os.Stat(gunicorn); err != nil {
+		return appSpec{}, errors.New("virtualenv is not provisioned")
+	}
+
+	// wsgi_file accepts a path ("project/wsgi.py") or a gunicorn app spec ("project.wsgi:app")
+	module := app.WSGIFile
+	if !strings.Contains(module, ":") {
+		module = strings.TrimSuffix(module, ".py")
+		module = strings.ReplaceAll(strings.Trim(module, "/"), "/", ".") + ":application"
+	}
+
+	workers := 2
+	siteEnv := map[string]string{}
+	if site != nil && site.PythonSettings != nil {
+		if site.PythonSettings.WorkerCount > 0 {
+			workers = site.PythonSettings.WorkerCount
+		}
+		siteEnv = site.PythonSettings.Environment
+	}
+
+	venvEnv := map[string]string{
+		"VIRTUAL_ENV":      app.VenvPath,
+		"PATH":             filepath.Join(app.VenvPath, "bin") + ":/usr/local/bin:/usr/bin:/bin",
+		"PYTHONUNBUFFERED": "1",
+	}
+
+	return appSpec{
+		ID:      app.ID,
+		Command: gunicorn,
+		Args: []string{
+			"--bind", fmt.Sprintf("127.0.0.1:%d", app.Port),
+			"--workers", strconv.Itoa(workers),
+			"--chdir", app.AppRoot,
+			module,
+		},
+		Dir:         app.AppRoot,
+		Env:         envList(baseEnv(o, app.Port), venvEnv, siteEnv, app.Environment),
+		UID:         o.UID,
+		GID:         o.GID,
+		CgroupPath:  account.CgroupPath(o.ID),
+		LogPath:     appLogPath(o, app.Name, app.ID),
+		Port:        app.Port,
+		AutoRestart: app.AutoRestart,
+		MaxRestarts: app.MaxRestarts,
+	}, nil
+}
+
+// siteName maps a domain ID to the site directory name. Anything that is not
+// a known domain ID is taken to be the name already.
+func (s *Service) siteName(domainID string) string {
+	if s.domains != nil {
+		if d, err := s.domains.Get(domainID); err == nil {
+			return d.Name
+		}
+	}
+	return domainID
+}
+
+// linkedSite loads the site an app serves, if the account has one for its domain
+func (s *Service) linkedSite(o *appOwner, domainID string) *web.SiteDescriptor {
+	if domainID == "" {
+		return nil
+	}
+
+	site, err := s.sites.ReadSite(o.ID, s.siteName(domainID))
+	if err != nil {
+		return nil
+	}
+	return site
+}
+
+// syncSiteProxy points the site's nginx proxy at the app port when it changed
+func (s *Service) syncSiteProxy(o *appOwner, site *web.SiteDescriptor, port int) error {
+	if site == nil || (site.AppPort() == port && site.AppSocket() == "") {
+		return nil
+	}
+
+	switch {
+	case strings.HasPrefix(site.Runtime, "nodejs-"):
+		if site.NodeSettings == nil {
+			site.NodeSettings = web.DefaultNodeSettings(strings.TrimPrefix(site.Runtime, "nodejs-"))
+		}
+		site.NodeSettings.Port = port
+		site.NodeSettings.Socket = ""
+	case strings.HasPrefix(site.Runtime, "python-"):
+		if site.PythonSettings == nil {
+			site.PythonSettings = &web.PythonSettings{Version: strings.TrimPrefix(site.Runtime, "python-")}
+		}
+		site.PythonSettings.Port = port
+		site.PythonSettings.Socket = ""
+	default:
+		// PHP and static sites are served from disk, not proxied
+		return nil
+	}
+
+	site.UpdatedAt = time.Now().Format(time.RFC3339)
+	if err := s.sites.WriteSite(o.ID, site); err != nil {
+		return err
+	}
+	return s.webApply.GenerateSiteConfig(o.ID, site)
+}
+
+// startApp builds the spec for an app and hands it to the supervisor.
+// adoptPID is a process from a previous panel instance to watch instead.
+func (s *Service) startApp(id string, adoptPID int) error {
+	s.mu.Lock()
+	var (
+		spec     appSpec
+		userID   string
+		domainID string
+		port     int
+		err      error
+		o        *appOwner
+	)
+	if app, exists := s.nodejsApps[id]; exists {
+		userID, domainID, port = app.UserID, app.DomainID, app.Port
+		if o, err = s.resolveOwner(userID); err == nil {
+			site := s.linkedSite(o, domainID)
+			spec, err = s.nodeSpec(o, app, site)
+			if err == nil {
+				err = s.syncSiteProxy(o, site, port)
+			}
+		}
+		if err == nil {
+			app.Running = true
+			app.UpdatedAt = time.Now()
+		}
+	} else if app, exists := s.pythonApps[id]; exists {
+		userID, domainID, port = app.UserID, app.DomainID, app.Port
+		if o, err = s.resolveOwner(userID); err == nil {
+			site := s.linkedSite(o, domainID)
+			spec, err = s.pythonSpec(o, app, site)
+			if err == nil {
+				err = s.syncSiteProxy(o, site, port)
+			}
+		}
+		if err == nil {
+			app.Running = true
+			app.UpdatedAt = time.Now()
+		}
+	} else {
+		err = errors.New("app not found")
+	}
+	if err == nil {
+		err = s.persistApp(id)
+	}
+	s.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	// The supervisor reports back through onProcessUpdate, which takes s.mu
+	return s.supervisor.start(spec, adoptPID)
+}
+
+// stopApp stops an app and records that it should stay stopped
+func (s *Service) stopApp(id string) error {
+	s.mu.Lock()
+	if app, exists := s.nodejsApps[id]; exists {
+		app.Running = false
+		app.UpdatedAt = time.Now()
+	} else if app, exists := s.pythonApps[id]; exists {
+		app.Running = false
+		app.UpdatedAt = time.Now()
+	} else {
+		s.mu.Unlock()
+		return errors.New("app not found")
+	}
+	err := s.persistApp(id)
+	s.mu.Unlock()
+
+	s.supervisor.stop(id)
+	return err
+}
+
+// Reconcile loads application records from every account and brings apps
+// that should be running back under supervision, adopting processes that
+// survived a panel restart instead of starting duplicates.
+func (s *Service) Reconcile() error {
+	accountIDs, err := s.accounts.ListAccounts()
+	if err != nil {
+		return err
+	}
+
+	type pending struct {
+		id      string
+		pid     *int
+		command string
+	}
+	var toStart []pending
+
+	s.mu.Lock()
+	for _, accountID := range accountIDs {
+		dir := filepath.Join(s.accounts.AccountPath(accountID), "runtime", "apps")
+		files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+		for _, file := range files {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				continue
+			}
+			var record appRecord
+			if err := json.Unmarshal(data, &record); err != nil {
+				continue
+			}
+
+			switch {
+			case record.NodeJS != nil:
+				app := record.NodeJS
+				if _, exists := s.nodejsApps[app.ID]; exists {
+					continue
+				}
+				s.nodejsApps[app.ID] = app
+				if app.Running {
+					toStart = append(toStart, pending{app.ID, app.PID, "node"})
+				}
+			case record.Python != nil:
+				app := record.Python
+				if _, exists := s.pythonApps[app.ID]; exists {
+					continue
+				}
+				s.pythonApps[app.ID] = app
+				if app.Running {
+					toStart = append(toStart, pending{app.ID, app.PID, "gunicorn"})
+				}
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	var errs []string
+	for _, p := range toStart {
+		adoptPID := 0
+		if p.pid != nil && processMatches(*p.pid, p.command) {
+			adoptPID = *p.pid
+		}
+		if err := s.startApp(p.id, adoptPID); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", p.id, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to start apps: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// provisionVirtualenv creates the app's virtualenv as the account user and
+// installs gunicorn plus requirements.txt when present
+func (s *Service) provisionVirtualenv(o *appOwner, python, venvPath, appRoot string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), provisionTimeout)
+	defer cancel()
+
+	run := func(name string, args ...string) error {
+		cmd := exec.CommandContext(ctx, name, args...)
+		cmd.Dir = appRoot
+		cmd.Env = envList(baseEnv(o, 0))
+		configureProcess(cmd, o.UID, o.GID)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			msg := strings.TrimSpace(string(out))
+			if len(msg) > 2000 {
+				msg = msg[len(msg)-2000:]
+			}
+			return fmt.Errorf("%s failed: %s", filepath.Base(name), msg)
+		}
+		return nil
+	}
+
+	if err := run(python, "-m", "venv", venvPath); err != nil {
+		return err
+	}
+
+	pip := filepath.Join(venvPath, "bin", "pip")
+	if err := run(pip, "install", "--upgrade", "pip", "gunicorn"); err != nil {
+		return err
+	}
+
+	requirements := filepath.Join(appRoot, "requirements.txt")
+	if _, err := os.Stat(requirements); err == nil {
+		if err := run(pip, "install", "-r", requirements); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package runtime
+
+import (
+	"os/exec"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/iSundram/OweHost/pkg/models"
+)
+
+func TestGetApp_ReturnsCopy(t *testing.T) {
+	svc := NewService(nil)
+	pid := 4242
+	svc.nodejsApps["node-1"] = &models.NodeJSApp{
+		ID:          "node-1",
+		UserID:      "usr-nobody",
+		Environment: map[string]string{"NODE_ENV": "production"},
+		PID:         &pid,
+		Status:      models.AppProcessStatus{State: models.AppStateRunning},
+	}
+	svc.pythonApps["py-1"] = &models.PythonApp{
+		ID:          "py-1",
+		UserID:      "usr-nobody",
+		Environment: map[string]string{"DJANGO_DEBUG": "0"},
+	}
+
+	node, err := svc.GetNodeJSApp("node-1")
+	if err != nil {
+		t.Fatalf("Failed to get app: %v", err)
+	}
+	node.Environment["NODE_ENV"] = "development"
+	node.Status.State = models.AppStateStopped
+	if env := svc.nodejsApps["node-1"].Environment["NODE_ENV"]; env != "production" {
+		t.Errorf("Expected the stored environment to be unchanged, got %q", env)
+	}
+	if state := svc.nodejsApps["node-1"].Status.State; state != models.AppStateRunning {
+		t.Errorf("Expected the stored status to be unchanged, got %q", state)
+	}
+
+	// Supervisor updates leave copies already handed out alone
+	svc.onProcessUpdate("node-1", 0, models.AppProcessStatus{State: models.AppStateStopped})
+	if node.PID == nil || *node.PID != 4242 {
+		t.Errorf("Expected the copy to keep its PID, got %v", node.PID)
+	}
+
+	py, err := svc.GetPythonApp("py-1")
+	if err != nil {
+		t.Fatalf("Failed to get app: %v", err)
+	}
+	py.Environment["DJANGO_DEBUG"] = "1"
+	if env := svc.pythonApps["py-1"].Environment["DJANGO_DEBUG"]; env != "0" {
+		t.Errorf("Expected the stored environment to be unchanged, got %q", env)
+	}
+
+	if _, err := svc.GetNodeJSApp("py-1"); err == nil {
+		t.Error("Expected a Python app not to be found as a Node.js app")
+	}
+}
+
+func TestResolveAppRoot(t *testing.T) {
+	o := &appOwner{Path: "/srv/accounts/a-1001"}
+
+	tests := []struct {
+		root string
+		want string
+		ok   bool
+	}{
+		{"apps/api", "/srv/accounts/a-1001/apps/api", true},
+		{"/srv/accounts/a-1001/home/app", "/srv/accounts/a-1001/home/app", true},
+		{"", "", false},
+		{".", "", false},
+		{"../a-1002", "", false},
+		{"apps/../../a-1002/home", "", false},
+		{"/srv/accounts/a-10010/app", "", false},
+		{"/opt/owehost", "", false},
+	}
+	for _, tt := range tests {
+		got, err := resolveAppRoot(o, tt.root)
+		if (err == nil) != tt.ok {
+			t.Errorf("%q: expected ok=%v, got %v", tt.root, tt.ok, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q: expected %q, got %q", tt.root, tt.want, got)
+		}
+	}
+}
+
+func TestEnvList_LaterLayersWin(t *testing.T) {
+	o := &appOwner{Name: "alice", Path: "/srv/accounts/a-1001"}
+	env := envList(baseEnv(o, 3000), map[string]string{"PORT": "8080", "NODE_ENV": "production"})
+	sort.Strings(env)
+
+	want := []string{
+		"HOME=/srv/accounts/a-1001/home",
+		"HOST=127.0.0.1",
+		"LANG=C.UTF-8",
+		"NODE_ENV=production",
+		"PATH=/usr/local/bin:/usr/bin:/bin",
+		"PORT=8080",
+		"USER=alice",
+	}
+	if strings.Join(env, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected %v, got %v", want, env)
+	}
+}
+
+func TestJoinCgroup_NoCgroup(t *testing.T) {
+	cmd := exec.Command("/bin/true")
+	release, err := joinCgroup(cmd, "")
+	if err != nil {
+		t.Fatalf("Expected no error without a cgroup, got %v", err)
+	}
+	release()
+	if cmd.SysProcAttr != nil {
+		t.Errorf("Expected the command to be left alone, got %+v", cmd.SysProcAttr)
+	}
+}
@@ -0,0 +1,32 @@
+//go:build linux
+
+package runtime
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// joinCgroup has cmd start inside the cgroup at path, so the account's CPU
+// and memory limits apply from its first instruction rather than once it
+// has been moved there. Only root can place processes in account cgroups;
+// otherwise cmd starts where the panel runs. The returned func releases the
+// cgroup once cmd has started.
+func joinCgroup(cmd *exec.Cmd, path string) (func(), error) {
+	if path == "" || os.Geteuid() != 0 {
+		return func() {}, nil
+	}
+
+	fd, err := syscall.Open(path, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open account cgroup: %w", err)
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = fd
+	return func() { syscall.Close(fd) }, nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package runtime
+
+import "os/exec"
+
+// joinCgroup is a no-op on platforms without cgroups
+func joinCgroup(cmd *exec.Cmd, path string) (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,250 @@
+// Package runtime provides runtime and language management for OweHost
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/iSundram/OweHost/internal/storage/web"
+	"github.com/iSundram/OweHost/pkg/models"
+)
+
+// Pool records live with the account under runtime/php-pools and are the
+// only input to the pool.d files; nothing else writes those.
+
+// CreatePHPPool creates the user's PHP-FPM pool for a PHP version
+func (s *Service) CreatePHPPool(userID string, req *models.PHPPoolCreateRequest) (*models.PHPPool, error) {
+	o, err := s.resolveOwner(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, err := s.sites.ReadPHPPool(o.ID, req.Version)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, errors.New("pool already exists for user with this version")
+	}
+
+	pool := web.DefaultPHPPool(o.ID, o.Name, req.Version)
+	applyPoolRequest(pool, req)
+	if err := s.savePHPPool(pool, nil); err != nil {
+		return nil, err
+	}
+	return pool, nil
+}
+
+// GetPHPPool gets a PHP pool by ID
+func (s *Service) GetPHPPool(id string) (*models.PHPPool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.findPHPPool(id)
+}
+
+// ListPHPPoolsByUser lists PHP pools for a user
+func (s *Service) ListPHPPoolsByUser(userID string) []*models.PHPPool {
+	o, err := s.resolveOwner(userID)
+	if err != nil {
+		return []*models.PHPPool{}
+	}
+
+	pools, err := s.sites.ReadPHPPools(o.ID)
+	if err != nil {
+		return []*models.PHPPool{}
+	}
+	return pools
+}
+
+// UpdatePHPPool updates a PHP pool. Fields left empty in req keep their
+// current value.
+func (s *Service) UpdatePHPPool(id string, req *models.PHPPoolCreateRequest) (*models.PHPPool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pool, err := s.findPHPPool(id)
+	if err != nil {
+		return nil, err
+	}
+
+	previous := *pool
+	version := pool.Version
+	applyPoolRequest(pool, req)
+	pool.Version = version
+	if err := s.savePHPPool(pool, &previous); err != nil {
+		return nil, err
+	}
+	return pool, nil
+}
+
+// DeletePHPPool deletes a PHP pool that no site of its account runs on
+func (s *Service) DeletePHPPool(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pool, err := s.findPHPPool(id)
+	if err != nil {
+		return err
+	}
+
+	sites, err := s.sites.ListSites(pool.AccountID)
+	if err != nil {
+		return err
+	}
+	for _, site := range sites {
+		if site.PHPVersion() == pool.Version {
+			return fmt.Errorf("pool is in use by %s", site.Domain)
+		}
+	}
+
+	if err := s.sites.DeletePHPPool(pool.AccountID, pool.Version); err != nil {
+		return err
+	}
+	batch := s.webApply.NewPoolBatch()
+	batch.Remove(pool.AccountID, pool.Version)
+	return batch.Commit()
+}
+
+// EnablePHPExtension enables a PHP extension for a pool
+func (s *Service) EnablePHPExtension(poolID, extension string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pool, err := s.findPHPPool(poolID)
+	if err != nil {
+		return err
+	}
+
+	// Check if already enabled
+	for _, ext := range pool.Extensions {
+		if ext == extension {
+			return nil
+		}
+	}
+
+	previous := *pool
+	pool.Extensions = append(append([]string{}, pool.Extensions...), extension)
+	return s.savePHPPool(pool, &previous)
+}
+
+// DisablePHPExtension disables a PHP extension for a pool
+func (s *Service) DisablePHPExtension(poolID, extension string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pool, err := s.findPHPPool(poolID)
+	if err != nil {
+		return err
+	}
+
+	for i, ext := range pool.Extensions {
+		if ext == extension {
+			previous := *pool
+			pool.Extensions = append(append([]string{}, pool.Extensions[:i]...), pool.Extensions[i+1:]...)
+			return s.savePHPPool(pool, &previous)
+		}
+	}
+
+	return errors.New("extension not enabled")
+}
+
+// findPHPPool looks a pool up by ID across all accounts. Callers hold s.mu.
+func (s *Service) findPHPPool(id string) (*models.PHPPool, error) {
+	ids, err := s.accounts.ListAccounts()
+	if err != nil {
+		return nil, err
+	}
+	for _, accountID := range ids {
+		pools, err := s.sites.ReadPHPPools(accountID)
+		if err != nil {
+			continue
+		}
+		for _, pool := range pools {
+			if pool.ID == id {
+				return pool, nil
+			}
+		}
+	}
+	return nil, errors.New("pool not found")
+}
+
+// savePHPPool validates and stores a pool record, then renders it and
+// reloads PHP-FPM. If the new config is rejected the record goes back to
+// previous, or is removed when the pool is new. Callers hold s.mu.
+func (s *Service) savePHPPool(pool, previous *models.PHPPool) error {
+	if err := web.ValidatePHPPool(pool); err != nil {
+		return err
+	}
+
+	pool.UpdatedAt = time.Now()
+	if err := s.sites.WritePHPPool(pool); err != nil {
+		return err
+	}
+
+	batch := s.webApply.NewPoolBatch()
+	err := batch.Write(pool)
+	if err == nil {
+		err = batch.Commit()
+	}
+	if err == nil {
+		return nil
+	}
+
+	rollback := s.webApply.NewPoolBatch()
+	if previous != nil {
+		s.sites.WritePHPPool(previous)
+		if rollback.Write(previous) == nil {
+			rollback.Commit()
+		}
+	} else {
+		s.sites.DeletePHPPool(pool.AccountID, pool.Version)
+		rollback.Remove(pool.AccountID, pool.Version)
+		rollback.Commit()
+	}
+	return err
+}
+
+// applyPoolRequest copies the fields set in req onto pool
+func applyPoolRequest(pool *models.PHPPool, req *models.PHPPoolCreateRequest) {
+	if req.PMMode != "" {
+		pool.PMMode = req.PMMode
+	}
+	if req.MaxChildren > 0 {
+		pool.MaxChildren = req.MaxChildren
+	}
+	if req.StartServers > 0 {
+		pool.StartServers = req.StartServers
+	}
+	if req.MinSpareServers > 0 {
+		pool.MinSpareServers = req.MinSpareServers
+	}
+	if req.MaxSpareServers > 0 {
+		pool.MaxSpareServers = req.MaxSpareServers
+	}
+	if req.MaxRequests != nil {
+		pool.MaxRequests = *req.MaxRequests
+	}
+	if req.ProcessIdleTimeout > 0 {
+		pool.ProcessIdleTimeout = req.ProcessIdleTimeout
+	}
+	if req.RequestTerminateTimeout != nil {
+		pool.RequestTerminateTimeout = *req.RequestTerminateTimeout
+	}
+	if req.OpenBasedir != nil {
+		pool.OpenBasedir = req.OpenBasedir
+	}
+	if req.DisableFunctions != nil {
+		pool.DisableFunctions = req.DisableFunctions
+	}
+	if req.Extensions != nil {
+		pool.Extensions = req.Extensions
+	}
+	if req.INIOverrides != nil {
+		pool.INIOverrides = req.INIOverrides
+	}
+}
@@ -0,0 +1,36 @@
+//go:build !windows
+
+package runtime
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// configureProcess starts cmd in its own process group, as the account user
+// when the panel runs as root. The separate group keeps apps alive across
+// panel restarts and lets the supervisor signal everything they spawn.
+func configureProcess(cmd *exec.Cmd, uid, gid int) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if os.Geteuid() == 0 && uid > 0 {
+		cmd.SysProcAttr.Credential = &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+	}
+}
+
+// processAlive reports whether pid exists
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// terminateGroup asks the process group led by pid to exit
+func terminateGroup(pid int) error {
+	return syscall.Kill(-pid, syscall.SIGTERM)
+}
+
+// killGroup forcibly stops the process group led by pid
+func killGroup(pid int) error {
+	return syscall.Kill(-pid, syscall.SIGKILL)
+}
@@ -0,0 +1,31 @@
+//go:build windows
+
+package runtime
+
+import (
+	"os"
+	"os/exec"
+)
+
+// configureProcess is a no-op on platforms without POSIX process groups
+func configureProcess(cmd *exec.Cmd, uid, gid int) {}
+
+// processAlive reports whether pid exists
+func processAlive(pid int) bool {
+	_, err := os.FindProcess(pid)
+	return err == nil
+}
+
+// terminateGroup stops the process; Windows has no graceful equivalent
+func terminateGroup(pid int) error {
+	return killGroup(pid)
+}
+
+// killGroup forcibly stops the process
+func killGroup(pid int) error {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return p.Kill()
+}
@@ -0,0 +1,236 @@
+// Package runtime provides runtime and language management for OweHost
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/iSundram/OweHost/internal/storage/web"
+	"github.com/iSundram/OweHost/pkg/models"
+)
+
+const (
+	// appReadyTimeout bounds how long a reloaded app may take to accept connections
+	appReadyTimeout = 60 * time.Second
+	// appDrainTime keeps a replaced process alive after nginx has moved to its
+	// successor, so requests already in flight can finish
+	appDrainTime = 10 * time.Second
+)
+
+// ReloadApp replaces a running app without dropping requests. The new
+// process starts on a spare port, nginx is switched to it once it accepts
+// connections, and the old process is stopped after draining. An app that
+// is not running is simply started.
+func (s *Service) ReloadApp(id string) error {
+	if !s.supervisor.running(id) {
+		return s.startApp(id, 0)
+	}
+
+	s.mu.Lock()
+	var (
+		spec    appSpec
+		o       *appOwner
+		site    *web.SiteDescriptor
+		oldPort int
+		err     error
+	)
+	if app, exists := s.nodejsApps[id]; exists {
+		next := *app
+		next.Port = s.findAvailablePort(3000)
+		oldPort = app.Port
+		if o, err = s.resolveOwner(app.UserID); err == nil {
+			site = s.linkedSite(o, app.DomainID)
+			spec, err = s.nodeSpec(o, &next, site)
+		}
+	} else if app, exists := s.pythonApps[id]; exists {
+		next := *app
+		next.Port = s.findAvailablePort(8000)
+		oldPort = app.Port
+		if o, err = s.resolveOwner(app.UserID); err == nil {
+			site = s.linkedSite(o, app.DomainID)
+			spec, err = s.pythonSpec(o, &next, site)
+		}
+	} else {
+		err = errors.New("app not found")
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	staged, err := s.supervisor.stage(spec, appReadyTimeout)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	err = s.syncSiteProxy(o, site, spec.Port)
+	if err == nil {
+		if app, exists := s.nodejsApps[id]; exists {
+			app.Port = spec.Port
+			app.UpdatedAt = time.Now()
+		} else if app, exists := s.pythonApps[id]; exists {
+			app.Port = spec.Port
+			app.UpdatedAt = time.Now()
+		}
+		err = s.persistApp(id)
+	} else {
+		// Put nginx back on the process that is still serving
+		s.syncSiteProxy(o, site, oldPort)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		staged.halt()
+		return err
+	}
+
+	if old := s.supervisor.promote(staged); old != nil {
+		go func() {
+			time.Sleep(appDrainTime)
+			old.halt()
+		}()
+	}
+	return nil
+}
+
+// siteApps returns the running apps that serve a site. Callers hold s.mu.
+func (s *Service) siteApps(o *appOwner, domain string) []string {
+	var ids []string
+	for id, app := range s.nodejsApps {
+		if app.Running && app.UserID == o.Name && s.siteName(app.DomainID) == domain {
+			ids = append(ids, id)
+		}
+	}
+	for id, app := range s.pythonApps {
+		if app.Running && app.UserID == o.Name && s.siteName(app.DomainID) == domain {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// releaseSite loads a site of the user's account for release and site
+// settings operations. domain may be a domain ID or name.
+func (s *Service) releaseSite(userID, domain string) (*appOwner, *web.SiteDescriptor, error) {
+	o, err := s.resolveOwner(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if web.ValidateDomain(s.siteName(domain)) != nil {
+		return nil, nil, errors.New("site not found")
+	}
+	site := s.linkedSite(o, domain)
+	if site == nil {
+		return nil, nil, errors.New("site not found")
+	}
+	return o, site, nil
+}
+
+// reloadSite gracefully reloads whatever executes the site's code, so the
+// newly activated release is picked up. Static files need no reload: nginx
+// resolves the current symlink on every request.
+func (s *Service) reloadSite(o *appOwner, site *web.SiteDescriptor) error {
+	switch {
+	case strings.HasPrefix(site.Runtime, "php-"):
+		// PHP-FPM finishes in-flight requests on reload and drops opcache
+		return s.webApply.ReloadPHPFpm(site.PHPVersion())
+	case strings.HasPrefix(site.Runtime, "nodejs-"), strings.HasPrefix(site.Runtime, "python-"):
+		s.mu.RLock()
+		ids := s.siteApps(o, site.Domain)
+		s.mu.RUnlock()
+
+		var errs []string
+		for _, id := range ids {
+			if err := s.ReloadApp(id); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", id, err))
+			}
+		}
+		if len(errs) > 0 {
+			return fmt.Errorf("failed to reload apps: %s", strings.Join(errs, "; "))
+		}
+	}
+	return nil
+}
+
+// SiteReleasesEnabled reports whether a site of the user is deployed as releases
+func (s *Service) SiteReleasesEnabled(userID, domain string) bool {
+	_, site, err := s.releaseSite(userID, domain)
+	return err == nil && site.ReleasesEnabled()
+}
+
+// ConfigureSiteReleases turns release-based deploys on or off for a site
+func (s *Service) ConfigureSiteReleases(userID, domain string, req *models.SiteReleaseSettingsRequest) (*web.SiteDescriptor, error) {
+	o, site, err := s.releaseSite(userID, domain)
+	if err != nil {
+		return nil, err
+	}
+
+	settings := &web.ReleaseSettings{
+		Enabled:     req.Enabled,
+		Keep:        req.Keep,
+		SharedPaths: req.SharedPaths,
+	}
+	if err := s.webApply.ConfigureReleases(o.ID, site, settings); err != nil {
+		return nil, err
+	}
+	return site, nil
+}
+
+// ListSiteReleases lists a site's releases, oldest first
+func (s *Service) ListSiteReleases(userID, domain string) ([]web.Release, error) {
+	o, site, err := s.releaseSite(userID, domain)
+	if err != nil {
+		return nil, err
+	}
+	if !site.ReleasesEnabled() {
+		return nil, web.ErrReleasesDisabled
+	}
+	return s.sites.ReadReleases(o.ID, site.Domain)
+}
+
+// DeploySite publishes a new release of a site and reloads what serves it.
+// source records where the release came from (git, upload, installer) and
+// populate fills the release directory. When the reload fails the release
+// is already live and is returned along with the error.
+func (s *Service) DeploySite(userID, domain, source, ref string, populate func(dir string) error) (*web.Release, error) {
+	o, site, err := s.releaseSite(userID, domain)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := s.webApply.DeployRelease(o.ID, site, source, ref, populate)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.reloadSite(o, site); err != nil {
+		return release, fmt.Errorf("release %s activated but reload failed: %w", release.ID, err)
+	}
+	return release, nil
+}
+
+// DeploySiteArchive publishes an uploaded zip or tar archive as a release
+func (s *Service) DeploySiteArchive(userID, domain, name, archivePath string) (*web.Release, error) {
+	return s.DeploySite(userID, domain, "upload", name, func(dir string) error {
+		return web.ExtractArchive(archivePath, dir)
+	})
+}
+
+// RollbackSite reactivates an earlier release of a site, by default the one
+// before the active release, and reloads what serves it
+func (s *Service) RollbackSite(userID, domain, releaseID string) (*web.Release, error) {
+	o, site, err := s.releaseSite(userID, domain)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := s.webApply.RollbackRelease(o.ID, site, releaseID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.reloadSite(o, site); err != nil {
+		return release, fmt.Errorf("release %s activated but reload failed: %w", release.ID, err)
+	}
+	return release, nil
+}
@@ -3,32 +3,46 @@ package runtime
 
 import (
 	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
 	"sync"
 	"time"
 
+	"github.com/iSundram/OweHost/internal/domain"
+	"github.com/iSundram/OweHost/internal/storage/account"
+	"github.com/iSundram/OweHost/internal/storage/web"
 	"github.com/iSundram/OweHost/pkg/models"
 	"github.com/iSundram/OweHost/pkg/utils"
 )
 
 // Service provides runtime management functionality
 type Service struct {
-	phpPools     map[string]*models.PHPPool
 	nodejsApps   map[string]*models.NodeJSApp
 	pythonApps   map[string]*models.PythonApp
 	versions     map[models.RuntimeType][]models.RuntimeVersion
 	extensions   map[string][]models.PHPExtension
+	domains      *domain.Service
+	accounts     *account.StateManager
+	sites        *web.StateManager
+	webApply     *web.Applier
+	supervisor   *supervisor
 	mu           sync.RWMutex
 }
 
 // NewService creates a new runtime service
-func NewService() *Service {
+func NewService(domainSvc *domain.Service) *Service {
 	svc := &Service{
-		phpPools:   make(map[string]*models.PHPPool),
 		nodejsApps: make(map[string]*models.NodeJSApp),
 		pythonApps: make(map[string]*models.PythonApp),
 		versions:   make(map[models.RuntimeType][]models.RuntimeVersion),
 		extensions: make(map[string][]models.PHPExtension),
+		domains:    domainSvc,
+		accounts:   account.NewStateManager(),
+		sites:      web.NewStateManager(),
+		webApply:   web.NewApplier(),
 	}
+	svc.supervisor = newSupervisor(svc.onProcessUpdate)
 	svc.initDefaultVersions()
 	return svc
 }
@@ -74,176 +88,61 @@ func (s *Service) ListVersions(runtimeType models.RuntimeType) []models.RuntimeV
 	return s.versions[runtimeType]
 }
 
-// CreatePHPPool creates a PHP-FPM pool for a user
-func (s *Service) CreatePHPPool(userID string, req *models.PHPPoolCreateRequest) (*models.PHPPool, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	// Check if user already has a pool
-	for _, pool := range s.phpPools {
-		if pool.UserID == userID && pool.Version == req.Version {
-			return nil, errors.New("pool already exists for user with this version")
-		}
-	}
-
-	pool := &models.PHPPool{
-		ID:              utils.GenerateID("php"),
-		UserID:          userID,
-		Version:         req.Version,
-		PoolName:        "user_" + userID,
-		SocketPath:      "/var/run/php/php" + req.Version + "-fpm-" + userID + ".sock",
-		MaxChildren:     req.MaxChildren,
-		StartServers:    2,
-		MinSpareServers: 1,
-		MaxSpareServers: 3,
-		Extensions:      req.Extensions,
-		INIOverrides:    req.INIOverrides,
-		CreatedAt:       time.Now(),
-		UpdatedAt:       time.Now(),
-	}
-
-	if pool.MaxChildren == 0 {
-		pool.MaxChildren = 5
-	}
-
-	s.phpPools[pool.ID] = pool
-	return pool, nil
-}
-
-// GetPHPPool gets a PHP pool by ID
-func (s *Service) GetPHPPool(id string) (*models.PHPPool, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	pool, exists := s.phpPools[id]
-	if !exists {
-		return nil, errors.New("pool not found")
-	}
-	return pool, nil
-}
-
-// ListPHPPoolsByUser lists PHP pools for a user
-func (s *Service) ListPHPPoolsByUser(userID string) []*models.PHPPool {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	pools := make([]*models.PHPPool, 0)
-	for _, pool := range s.phpPools {
-		if pool.UserID == userID {
-			pools = append(pools, pool)
-		}
-	}
-	return pools
-}
-
-// UpdatePHPPool updates a PHP pool
-func (s *Service) UpdatePHPPool(id string, req *models.PHPPoolCreateRequest) (*models.PHPPool, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	pool, exists := s.phpPools[id]
-	if !exists {
-		return nil, errors.New("pool not found")
-	}
-
-	if req.MaxChildren > 0 {
-		pool.MaxChildren = req.MaxChildren
-	}
-	if req.Extensions != nil {
-		pool.Extensions = req.Extensions
-	}
-	if req.INIOverrides != nil {
-		pool.INIOverrides = req.INIOverrides
-	}
-
-	pool.UpdatedAt = time.Now()
-	return pool, nil
-}
-
-// DeletePHPPool deletes a PHP pool
-func (s *Service) DeletePHPPool(id string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	if _, exists := s.phpPools[id]; !exists {
-		return errors.New("pool not found")
-	}
-
-	delete(s.phpPools, id)
-	return nil
-}
-
-// EnablePHPExtension enables a PHP extension for a pool
-func (s *Service) EnablePHPExtension(poolID, extension string) error {
+// CreateNodeJSApp creates a Node.js application
+func (s *Service) CreateNodeJSApp(userID string, req *models.NodeJSAppCreateRequest) (*models.NodeJSApp, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	pool, exists := s.phpPools[poolID]
-	if !exists {
-		return errors.New("pool not found")
+	o, err := s.resolveOwner(userID)
+	if err != nil {
+		return nil, err
 	}
-
-	// Check if already enabled
-	for _, ext := range pool.Extensions {
-		if ext == extension {
-			return nil
-		}
+	appRoot, err := resolveAppRoot(o, req.AppRoot)
+	if err != nil {
+		return nil, err
 	}
-
-	pool.Extensions = append(pool.Extensions, extension)
-	pool.UpdatedAt = time.Now()
-	return nil
-}
-
-// DisablePHPExtension disables a PHP extension for a pool
-func (s *Service) DisablePHPExtension(poolID, extension string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	pool, exists := s.phpPools[poolID]
-	if !exists {
-		return errors.New("pool not found")
+	if !filepath.IsLocal(req.StartFile) {
+		return nil, errors.New("start file must be relative to the app root")
 	}
 
-	for i, ext := range pool.Extensions {
-		if ext == extension {
-			pool.Extensions = append(pool.Extensions[:i], pool.Extensions[i+1:]...)
-			pool.UpdatedAt = time.Now()
-			return nil
-		}
-	}
-
-	return errors.New("extension not enabled")
-}
-
-// CreateNodeJSApp creates a Node.js application
-func (s *Service) CreateNodeJSApp(userID string, req *models.NodeJSAppCreateRequest) (*models.NodeJSApp, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
 	// Find available port
 	port := s.findAvailablePort(3000)
 
+	defaults := web.DefaultNodeSettings(req.Version)
 	app := &models.NodeJSApp{
 		ID:          utils.GenerateID("node"),
 		UserID:      userID,
 		DomainID:    req.DomainID,
 		Name:        req.Name,
 		Version:     req.Version,
-		AppRoot:     req.AppRoot,
+		AppRoot:     appRoot,
 		StartFile:   req.StartFile,
 		Port:        port,
 		Environment: req.Environment,
+		AutoRestart: defaults.AutoRestart,
+		MaxRestarts: defaults.MaxRestarts,
 		Running:     false,
+		Status:      models.AppProcessStatus{State: models.AppStateStopped},
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 	}
+	if req.AutoRestart != nil {
+		app.AutoRestart = *req.AutoRestart
+	}
+	if req.MaxRestarts != nil {
+		app.MaxRestarts = *req.MaxRestarts
+	}
 
 	s.nodejsApps[app.ID] = app
+	if err := s.persistApp(app.ID); err != nil {
+		delete(s.nodejsApps, app.ID)
+		return nil, err
+	}
 	return app, nil
 }
 
-// GetNodeJSApp gets a Node.js app by ID
+// GetNodeJSApp gets a copy of a Node.js app by ID, as the supervisor keeps
+// updating the app itself
 func (s *Service) GetNodeJSApp(id string) (*models.NodeJSApp, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -252,40 +151,33 @@ func (s *Service) GetNodeJSApp(id string) (*models.NodeJSApp, error) {
 	if !exists {
 		return nil, errors.New("app not found")
 	}
-	return app, nil
+	copied := *app
+	copied.Environment = copyEnv(app.Environment)
+	return &copied, nil
 }
 
-// StartNodeJSApp starts a Node.js application
+// StartNodeJSApp starts a Node.js application under supervision
 func (s *Service) StartNodeJSApp(id string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	app, exists := s.nodejsApps[id]
+	s.mu.RLock()
+	_, exists := s.nodejsApps[id]
+	s.mu.RUnlock()
 	if !exists {
 		return errors.New("app not found")
 	}
 
-	app.Running = true
-	pid := 12345 // Simulated PID
-	app.PID = &pid
-	app.UpdatedAt = time.Now()
-	return nil
+	return s.startApp(id, 0)
 }
 
 // StopNodeJSApp stops a Node.js application
 func (s *Service) StopNodeJSApp(id string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	app, exists := s.nodejsApps[id]
+	s.mu.RLock()
+	_, exists := s.nodejsApps[id]
+	s.mu.RUnlock()
 	if !exists {
 		return errors.New("app not found")
 	}
 
-	app.Running = false
-	app.PID = nil
-	app.UpdatedAt = time.Now()
-	return nil
+	return s.stopApp(id)
 }
 
 // DeleteNodeJSApp deletes a Node.js application
@@ -302,6 +194,9 @@ func (s *Service) DeleteNodeJSApp(id string) error {
 		return errors.New("stop app before deleting")
 	}
 
+	if o, err := s.resolveOwner(app.UserID); err == nil {
+		removeApp(o, id)
+	}
 	delete(s.nodejsApps, id)
 	return nil
 }
@@ -311,6 +206,15 @@ func (s *Service) CreatePythonApp(userID string, req *models.PythonAppCreateRequ
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	o, err := s.resolveOwner(userID)
+	if err != nil {
+		return nil, err
+	}
+	appRoot, err := resolveAppRoot(o, req.AppRoot)
+	if err != nil {
+		return nil, err
+	}
+
 	port := s.findAvailablePort(8000)
 
 	app := &models.PythonApp{
@@ -319,21 +223,34 @@ func (s *Service) CreatePythonApp(userID string, req *models.PythonAppCreateRequ
 		DomainID:    req.DomainID,
 		Name:        req.Name,
 		Version:     req.Version,
-		VenvPath:    req.AppRoot + "/venv",
-		AppRoot:     req.AppRoot,
+		VenvPath:    appRoot + "/venv",
+		AppRoot:     appRoot,
 		WSGIFile:    req.WSGIFile,
 		Port:        port,
 		Environment: req.Environment,
+		AutoRestart: true,
+		MaxRestarts: 10,
 		Running:     false,
+		Status:      models.AppProcessStatus{State: models.AppStateStopped},
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 	}
+	if req.AutoRestart != nil {
+		app.AutoRestart = *req.AutoRestart
+	}
+	if req.MaxRestarts != nil {
+		app.MaxRestarts = *req.MaxRestarts
+	}
 
 	s.pythonApps[app.ID] = app
+	if err := s.persistApp(app.ID); err != nil {
+		delete(s.pythonApps, app.ID)
+		return nil, err
+	}
 	return app, nil
 }
 
-// GetPythonApp gets a Python app by ID
+// GetPythonApp gets a copy of a Python app by ID
 func (s *Service) GetPythonApp(id string) (*models.PythonApp, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -342,21 +259,69 @@ func (s *Service) GetPythonApp(id string) (*models.PythonApp, error) {
 	if !exists {
 		return nil, errors.New("app not found")
 	}
-	return app, nil
+	copied := *app
+	copied.Environment = copyEnv(app.Environment)
+	return &copied, nil
+}
+
+// copyEnv copies an app's environment
+func copyEnv(env map[string]string) map[string]string {
+	if env == nil {
+		return nil
+	}
+	copied := make(map[string]string, len(env))
+	for k, v := range env {
+		copied[k] = v
+	}
+	return copied
 }
 
 // ProvisionVirtualenv provisions a Python virtual environment
 func (s *Service) ProvisionVirtualenv(appID string) error {
 	s.mu.RLock()
-	defer s.mu.RUnlock()
+	app, exists := s.pythonApps[appID]
+	if !exists {
+		s.mu.RUnlock()
+		return errors.New("app not found")
+	}
+	userID, version, venvPath, appRoot := app.UserID, app.Version, app.VenvPath, app.AppRoot
+	python, err := s.runtimeBinary(models.RuntimeTypePython, version, "python"+version, "python3")
+	s.mu.RUnlock()
+	if err != nil {
+		return err
+	}
 
-	_, exists := s.pythonApps[appID]
+	o, err := s.resolveOwner(userID)
+	if err != nil {
+		return err
+	}
+
+	// Provisioning downloads packages and can take minutes, so it runs unlocked
+	return s.provisionVirtualenv(o, python, venvPath, appRoot)
+}
+
+// StartPythonApp starts a Python application under supervision
+func (s *Service) StartPythonApp(id string) error {
+	s.mu.RLock()
+	_, exists := s.pythonApps[id]
+	s.mu.RUnlock()
 	if !exists {
 		return errors.New("app not found")
 	}
 
-	// In production, this would run python -m venv
-	return nil
+	return s.startApp(id, 0)
+}
+
+// StopPythonApp stops a Python application
+func (s *Service) StopPythonApp(id string) error {
+	s.mu.RLock()
+	_, exists := s.pythonApps[id]
+	s.mu.RUnlock()
+	if !exists {
+		return errors.New("app not found")
+	}
+
+	return s.stopApp(id)
 }
 
 // DeletePythonApp deletes a Python application
@@ -373,6 +338,9 @@ func (s *Service) DeletePythonApp(id string) error {
 		return errors.New("stop app before deleting")
 	}
 
+	if o, err := s.resolveOwner(app.UserID); err == nil {
+		removeApp(o, id)
+	}
 	delete(s.pythonApps, id)
 	return nil
 }
@@ -389,9 +357,19 @@ func (s *Service) findAvailablePort(base int) int {
 	}
 
 	for port := base; port < 65535; port++ {
-		if !usedPorts[port] {
+		if !usedPorts[port] && portFree(port) {
 			return port
 		}
 	}
 	return base
 }
+
+// portFree reports whether nothing else on the host listens on port
+func portFree(port int) bool {
+	l, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return false
+	}
+	l.Close()
+	return true
+}
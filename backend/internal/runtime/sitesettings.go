@@ -0,0 +1,226 @@
+// Package runtime provides runtime and language management for OweHost
+package runtime
+
+import (
+	"errors"
+	"path"
+
+	"github.com/iSundram/OweHost/internal/storage/web"
+	"github.com/iSundram/OweHost/pkg/models"
+)
+
+// GetSite returns a site of the user's account
+func (s *Service) GetSite(userID, domain string) (*web.SiteDescriptor, error) {
+	_, site, err := s.releaseSite(userID, domain)
+	return site, err
+}
+
+// ConfigureSiteCache turns microcaching on or off for a site. A TTL of
+// zero keeps the current one, or one second for a site without one.
+func (s *Service) ConfigureSiteCache(userID, domain string, req *models.SiteCacheSettingsRequest) (*web.SiteDescriptor, error) {
+	o, site, err := s.releaseSite(userID, domain)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := req.TTL
+	if ttl == 0 {
+		ttl = 1
+		if site.Cache != nil && site.Cache.TTL > 0 {
+			ttl = site.Cache.TTL
+		}
+	}
+	site.Cache = &web.CacheSettings{
+		Enabled:       req.Enabled,
+		TTL:           ttl,
+		BypassCookies: req.BypassCookies,
+		BypassPaths:   req.BypassPaths,
+	}
+
+	if err := s.webApply.ReconfigureSite(o.ID, site); err != nil {
+		return nil, err
+	}
+	return site, nil
+}
+
+// PurgeSiteCache drops a site's cached pages, or those under path, and
+// returns how many were dropped
+func (s *Service) PurgeSiteCache(userID, domain, path string) (int, error) {
+	o, site, err := s.releaseSite(userID, domain)
+	if err != nil {
+		return 0, err
+	}
+	return s.webApply.PurgeCache(o.ID, site, path)
+}
+
+// SetSiteRateLimits replaces a site's per-path request and connection limits
+func (s *Service) SetSiteRateLimits(userID, domain string, req *models.SiteRateLimitsRequest) (*web.SiteDescriptor, error) {
+	o, site, err := s.releaseSite(userID, domain)
+	if err != nil {
+		return nil, err
+	}
+
+	limits := make([]web.RateLimit, 0, len(req.Limits))
+	for _, l := range req.Limits {
+		limits = append(limits, web.RateLimit{
+			Path:        l.Path,
+			Rate:        l.Rate,
+			Burst:       l.Burst,
+			Connections: l.Connections,
+		})
+	}
+	site.RateLimits = limits
+
+	if err := s.webApply.ReconfigureSite(o.ID, site); err != nil {
+		return nil, err
+	}
+	return site, nil
+}
+
+// ListSiteProtectedPaths lists a site's password-protected directories
+// with their users
+func (s *Service) ListSiteProtectedPaths(userID, domain string) ([]models.SiteProtectedPath, error) {
+	o, site, err := s.releaseSite(userID, domain)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]models.SiteProtectedPath, 0, len(site.ProtectedPaths))
+	for _, p := range site.ProtectedPaths {
+		users, err := s.webApply.AuthUsers(o.ID, site, p.Path)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, models.SiteProtectedPath{Path: p.Path, Realm: p.Realm, Users: users})
+	}
+	return paths, nil
+}
+
+// ProtectSitePath password-protects a directory of a site
+func (s *Service) ProtectSitePath(userID, domain string, req *models.SiteProtectedPathRequest) (*web.SiteDescriptor, error) {
+	o, site, err := s.releaseSite(userID, domain)
+	if err != nil {
+		return nil, err
+	}
+	protected := web.ProtectedPath{Path: cleanSitePath(req.Path), Realm: req.Realm}
+	if err := s.webApply.ProtectPath(o.ID, site, protected); err != nil {
+		return nil, err
+	}
+	return site, nil
+}
+
+// UnprotectSitePath removes the password protection of a directory
+func (s *Service) UnprotectSitePath(userID, domain, path string) error {
+	o, site, err := s.releaseSite(userID, domain)
+	if err != nil {
+		return err
+	}
+	return s.webApply.UnprotectPath(o.ID, site, cleanSitePath(path))
+}
+
+// SetSiteAuthUser adds a user to a protected directory or changes its password
+func (s *Service) SetSiteAuthUser(userID, domain string, req *models.SiteAuthUserRequest) error {
+	o, site, err := s.releaseSite(userID, domain)
+	if err != nil {
+		return err
+	}
+	return s.webApply.SetAuthUser(o.ID, site, cleanSitePath(req.Path), req.Username, req.Password)
+}
+
+// DeleteSiteAuthUser removes a user from a protected directory
+func (s *Service) DeleteSiteAuthUser(userID, domain, path, username string) error {
+	o, site, err := s.releaseSite(userID, domain)
+	if err != nil {
+		return err
+	}
+	return s.webApply.DeleteAuthUser(o.ID, site, cleanSitePath(path), username)
+}
+
+// SetSiteAccessRules replaces a site's IP allow/deny rules
+func (s *Service) SetSiteAccessRules(userID, domain string, req *models.SiteAccessRulesRequest) (*web.SiteDescriptor, error) {
+	o, site, err := s.releaseSite(userID, domain)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make([]web.AccessRule, 0, len(req.Rules))
+	for _, r := range req.Rules {
+		rules = append(rules, web.AccessRule{Path: cleanSitePath(r.Path), Allow: r.Allow, Deny: r.Deny})
+	}
+	if err := s.webApply.SetAccessRules(o.ID, site, rules); err != nil {
+		return nil, err
+	}
+	return site, nil
+}
+
+// ConfigureSiteWAF turns the web application firewall of a site on or off.
+// Without a mode the current one is kept; a new firewall starts out only
+// detecting, so false positives show up before anything is blocked.
+func (s *Service) ConfigureSiteWAF(userID, domain string, req *models.SiteWAFRequest) (*web.SiteDescriptor, error) {
+	o, site, err := s.releaseSite(userID, domain)
+	if err != nil {
+		return nil, err
+	}
+
+	mode := req.Mode
+	if mode == "" {
+		mode = web.WAFModeDetection
+		if site.WAF != nil && site.WAF.Mode != "" {
+			mode = site.WAF.Mode
+		}
+	}
+	site.WAF = &web.WAFSettings{
+		Enabled:       req.Enabled,
+		Mode:          mode,
+		ExcludedRules: req.ExcludedRules,
+	}
+
+	if err := s.webApply.ReconfigureSite(o.ID, site); err != nil {
+		return nil, err
+	}
+	return site, nil
+}
+
+// ExcludeSiteWAFRule switches off a firewall rule that keeps matching
+// legitimate requests of a site
+func (s *Service) ExcludeSiteWAFRule(userID, domain string, ruleID int) (*web.SiteDescriptor, error) {
+	o, site, err := s.releaseSite(userID, domain)
+	if err != nil {
+		return nil, err
+	}
+	if site.WAF == nil {
+		return nil, errors.New("web application firewall is not configured for this site")
+	}
+
+	for _, id := range site.WAF.ExcludedRules {
+		if id == ruleID {
+			return site, nil
+		}
+	}
+	site.WAF.ExcludedRules = append(site.WAF.ExcludedRules, ruleID)
+
+	if err := s.webApply.ReconfigureSite(o.ID, site); err != nil {
+		return nil, err
+	}
+	return site, nil
+}
+
+// ListSiteWAFEvents returns the latest requests the firewall blocked or,
+// in detection mode, would have blocked
+func (s *Service) ListSiteWAFEvents(userID, domain string, limit int) ([]web.WAFEvent, error) {
+	o, site, err := s.releaseSite(userID, domain)
+	if err != nil {
+		return nil, err
+	}
+	return s.webApply.WAFEvents(o.ID, site, limit)
+}
+
+// cleanSitePath normalizes a directory path from a request, so "/admin/"
+// and "admin" both mean "/admin". Anything that still is not a plain path
+// is left for validation to reject.
+func cleanSitePath(p string) string {
+	if p == "" {
+		return p
+	}
+	return path.Clean("/" + p)
+}
@@ -0,0 +1,515 @@
+// Package runtime provides runtime and language management for OweHost
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iSundram/OweHost/pkg/models"
+)
+
+const (
+	// Restart backoff doubles from initialBackoff up to maxBackoff
+	initialBackoff = time.Second
+	maxBackoff     = time.Minute
+	// A process that stays up this long resets the backoff and restart budget
+	stableRunTime = time.Minute
+
+	stopTimeout = 10 * time.Second
+
+	healthInterval  = 10 * time.Second
+	healthGrace     = 15 * time.Second
+	healthTimeout   = 2 * time.Second
+	healthFailLimit = 3
+
+	maxLogSize  = 10 * 1024 * 1024
+	maxLogFiles = 5
+)
+
+// appSpec describes how to run one supervised application
+type appSpec struct {
+	ID          string
+	Command     string
+	Args        []string
+	Dir         string
+	Env         []string
+	UID         int
+	GID         int
+	CgroupPath  string
+	LogPath     string
+	Port        int
+	AutoRestart bool
+	MaxRestarts int
+}
+
+// supervisor keeps application processes running and reports their state
+type supervisor struct {
+	procs    map[string]*process
+	onUpdate func(id string, pid int, status models.AppProcessStatus)
+	mu       sync.Mutex
+}
+
+// process is the supervision state of one application
+type process struct {
+	spec     appSpec
+	sup      *supervisor
+	pid      int
+	status   models.AppProcessStatus
+	quiet    bool // Staged and replaced processes do not report state
+	stopCh   chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+	mu       sync.Mutex
+}
+
+// newSupervisor creates a supervisor that reports state changes to onUpdate
+func newSupervisor(onUpdate func(id string, pid int, status models.AppProcessStatus)) *supervisor {
+	return &supervisor{
+		procs:    make(map[string]*process),
+		onUpdate: onUpdate,
+	}
+}
+
+// start supervises spec. A non-zero adoptPID is a process left running by a
+// previous panel instance, which is watched instead of launching a new one.
+func (s *supervisor) start(spec appSpec, adoptPID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p, exists := s.procs[spec.ID]; exists {
+		select {
+		case <-p.done:
+		default:
+			return errors.New("app is already running")
+		}
+	}
+
+	p := s.newProcess(spec)
+	s.procs[spec.ID] = p
+
+	go p.run(adoptPID)
+	go p.monitor()
+	return nil
+}
+
+// newProcess creates the supervision state for spec
+func (s *supervisor) newProcess(spec appSpec) *process {
+	return &process{
+		spec:   spec,
+		sup:    s,
+		status: models.AppProcessStatus{State: models.AppStateStarting, LogPath: spec.LogPath},
+		stopCh: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// running reports whether an app has a live supervised process
+func (s *supervisor) running(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, exists := s.procs[id]
+	if !exists {
+		return false
+	}
+	select {
+	case <-p.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// stage launches spec next to the app's current process and waits until it
+// accepts connections on its port. It reports nothing until promoted, so the
+// app keeps showing the process that is serving traffic.
+func (s *supervisor) stage(spec appSpec, timeout time.Duration) (*process, error) {
+	p := s.newProcess(spec)
+	p.quiet = true
+	go p.run(0)
+
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return nil, fmt.Errorf("new process exited before accepting connections: %s", p.lastError())
+		case <-deadline:
+			p.halt()
+			return nil, fmt.Errorf("new process did not accept connections on port %d within %s", spec.Port, timeout)
+		case <-ticker.C:
+		}
+
+		p.mu.Lock()
+		state := p.status.State
+		p.mu.Unlock()
+		if state == models.AppStateRunning && checkPort(spec.Port) {
+			return p, nil
+		}
+	}
+}
+
+// promote makes a staged process the current one for its app and returns
+// the process it replaced, which the caller retires once traffic has moved
+func (s *supervisor) promote(p *process) *process {
+	s.mu.Lock()
+	old := s.procs[p.spec.ID]
+	s.procs[p.spec.ID] = p
+	s.mu.Unlock()
+
+	if old != nil {
+		old.mu.Lock()
+		old.quiet = true
+		old.mu.Unlock()
+	}
+
+	p.mu.Lock()
+	p.quiet = false
+	p.mu.Unlock()
+
+	go p.monitor()
+	p.update(func(st *models.AppProcessStatus) {})
+	return old
+}
+
+// stop terminates an application and waits for it to exit
+func (s *supervisor) stop(id string) {
+	s.mu.Lock()
+	p, exists := s.procs[id]
+	delete(s.procs, id)
+	s.mu.Unlock()
+
+	if !exists {
+		return
+	}
+	p.halt()
+}
+
+// halt stops the process and waits for it to exit
+func (p *process) halt() {
+	p.stopOnce.Do(func() { close(p.stopCh) })
+	<-p.done
+}
+
+// lastError returns the most recent failure of the process
+func (p *process) lastError() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.status.LastError == "" {
+		return "no error reported"
+	}
+	return p.status.LastError
+}
+
+// run launches the process and restarts it with backoff until stopped
+func (p *process) run(adoptPID int) {
+	defer close(p.done)
+
+	backoff := initialBackoff
+	failures := 0
+
+	for {
+		started := time.Now()
+		var err error
+		if adoptPID > 0 {
+			p.setRunning(adoptPID, started)
+			err = p.waitAdopted(adoptPID)
+			adoptPID = 0
+		} else {
+			p.update(func(st *models.AppProcessStatus) { st.State = models.AppStateStarting })
+			var cmd *exec.Cmd
+			cmd, err = p.launch()
+			if err == nil {
+				p.setRunning(cmd.Process.Pid, started)
+				err = p.wait(cmd)
+			}
+		}
+
+		if p.stopped() {
+			p.setStopped(models.AppStateStopped, "")
+			return
+		}
+
+		if err == nil {
+			err = errors.New("process exited")
+		}
+		if time.Since(started) >= stableRunTime {
+			backoff = initialBackoff
+			failures = 0
+		}
+
+		if !p.spec.AutoRestart || (p.spec.MaxRestarts > 0 && failures >= p.spec.MaxRestarts) {
+			p.setStopped(models.AppStateFailed, err.Error())
+			return
+		}
+
+		failures++
+		p.setStopped(models.AppStateBackoff, err.Error())
+		p.update(func(st *models.AppProcessStatus) { st.Restarts++ })
+
+		select {
+		case <-time.After(backoff):
+		case <-p.stopCh:
+			p.setStopped(models.AppStateStopped, "")
+			return
+		}
+
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// launch starts the application with its output appended to the app log
+func (p *process) launch() (*exec.Cmd, error) {
+	logFile, err := openAppLog(p.spec.LogPath, p.spec.GID)
+	if err != nil {
+		return nil, err
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(p.spec.Command, p.spec.Args...)
+	cmd.Dir = p.spec.Dir
+	cmd.Env = p.spec.Env
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	configureProcess(cmd, p.spec.UID, p.spec.GID)
+
+	// Start the app in the account cgroup so its CPU and memory limits apply
+	release, err := joinCgroup(cmd, p.spec.CgroupPath)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", p.spec.Command, err)
+	}
+
+	return cmd, nil
+}
+
+// wait waits for a launched process, terminating it when asked to stop
+func (p *process) wait(cmd *exec.Cmd) error {
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	select {
+	case err := <-exited:
+		return err
+	case <-p.stopCh:
+		terminateGroup(cmd.Process.Pid)
+		select {
+		case err := <-exited:
+			return err
+		case <-time.After(stopTimeout):
+			killGroup(cmd.Process.Pid)
+			return <-exited
+		}
+	}
+}
+
+// waitAdopted polls a process this panel instance did not start
+func (p *process) waitAdopted(pid int) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !processAlive(pid) {
+				return errors.New("process exited")
+			}
+		case <-p.stopCh:
+			terminateGroup(pid)
+			deadline := time.Now().Add(stopTimeout)
+			for processAlive(pid) && time.Now().Before(deadline) {
+				time.Sleep(200 * time.Millisecond)
+			}
+			if processAlive(pid) {
+				killGroup(pid)
+			}
+			return nil
+		}
+	}
+}
+
+// monitor health-checks the app port and rotates the app log
+func (p *process) monitor() {
+	ticker := time.NewTicker(healthInterval)
+	defer ticker.Stop()
+
+	failures := 0
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+
+		rotateAppLog(p.spec.LogPath)
+
+		p.mu.Lock()
+		pid, state, startedAt := p.pid, p.status.State, p.status.StartedAt
+		p.mu.Unlock()
+		if state != models.AppStateRunning || startedAt == nil || time.Since(*startedAt) < healthGrace || p.spec.Port == 0 {
+			continue
+		}
+
+		healthy := checkPort(p.spec.Port)
+		now := time.Now()
+		p.update(func(st *models.AppProcessStatus) {
+			st.Healthy = healthy
+			st.LastCheckAt = &now
+			if !healthy {
+				st.LastError = fmt.Sprintf("health check failed: nothing listening on port %d", p.spec.Port)
+			}
+		})
+
+		if healthy {
+			failures = 0
+			continue
+		}
+
+		// A process that stopped answering is restarted through the normal
+		// crash path, so backoff and MaxRestarts still apply
+		if failures++; failures >= healthFailLimit && p.spec.AutoRestart && pid > 0 {
+			failures = 0
+			killGroup(pid)
+		}
+	}
+}
+
+// stopped reports whether a stop was requested
+func (p *process) stopped() bool {
+	select {
+	case <-p.stopCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// setRunning records a live process
+func (p *process) setRunning(pid int, startedAt time.Time) {
+	p.mu.Lock()
+	p.pid = pid
+	p.mu.Unlock()
+
+	p.update(func(st *models.AppProcessStatus) {
+		st.State = models.AppStateRunning
+		st.StartedAt = &startedAt
+		st.Healthy = false
+	})
+}
+
+// setStopped records that the process is gone
+func (p *process) setStopped(state, lastError string) {
+	p.mu.Lock()
+	p.pid = 0
+	p.mu.Unlock()
+
+	p.update(func(st *models.AppProcessStatus) {
+		st.State = state
+		st.Healthy = false
+		st.StartedAt = nil
+		if lastError != "" {
+			st.LastError = lastError
+		}
+	})
+}
+
+// update changes the status and reports it to the supervisor's owner
+func (p *process) update(change func(st *models.AppProcessStatus)) {
+	p.mu.Lock()
+	change(&p.status)
+	pid, status, quiet := p.pid, p.status, p.quiet
+	p.mu.Unlock()
+
+	if p.sup.onUpdate != nil && !quiet {
+		p.sup.onUpdate(p.spec.ID, pid, status)
+	}
+}
+
+// checkPort reports whether something accepts connections on the local port
+func checkPort(port int) bool {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), healthTimeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// processMatches guards adoption against a recycled PID by checking that the
+// process still runs the expected executable. Without /proc it trusts the PID.
+func processMatches(pid int, command string) bool {
+	if !processAlive(pid) {
+		return false
+	}
+	cmdline, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		_, statErr := os.Stat("/proc/self")
+		return statErr != nil
+	}
+	return strings.Contains(string(cmdline), filepath.Base(command))
+}
+
+// openAppLog opens the app log for appending, readable by the account group
+func openAppLog(path string, gid int) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open app log: %w", err)
+	}
+	if os.Geteuid() == 0 && gid > 0 {
+		os.Chown(filepath.Dir(path), 0, gid)
+		f.Chown(0, gid)
+	}
+	return f, nil
+}
+
+// rotateAppLog copies an oversized log aside and truncates it in place. The
+// app keeps its append-mode descriptor, so no restart or signal is needed.
+func rotateAppLog(path string) {
+	info, err := os.Stat(path)
+	if err != nil || info.Size() < maxLogSize {
+		return
+	}
+
+	for i := maxLogFiles - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", path, i), fmt.Sprintf("%s.%d", path, i+1))
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".1", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return
+	}
+	_, copyErr := io.Copy(dst, src)
+	dst.Close()
+
+	if copyErr == nil {
+		os.Truncate(path, 0)
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/iSundram/OweHost/internal/bruteforce"
 	"github.com/iSundram/OweHost/pkg/models"
 	"github.com/iSundram/OweHost/pkg/utils"
 	"golang.org/x/crypto/ssh"
@@ -24,6 +25,7 @@ type Service struct {
 	access     map[string]*models.SSHAccess
 	byUser     map[string][]*models.SSHKey
 	sessions   map[string]*models.SSHSession
+	guard      *bruteforce.Guard
 	mu         sync.RWMutex
 }
 
@@ -278,8 +280,31 @@ func (s *Service) DisableAccess(userID string) error {
 	return err
 }
 
+// SetLoginGuard has logins checked against guard's limits on failures
+func (s *Service) SetLoginGuard(guard *bruteforce.Guard) {
+	s.guard = guard
+}
+
 // ValidateAccess validates if a user can SSH with the given key
 func (s *Service) ValidateAccess(userID, fingerprint, remoteIP string) (*models.SSHAccess, *models.SSHKey, error) {
+	if s.guard == nil {
+		return s.checkAccess(userID, fingerprint, remoteIP)
+	}
+	if err := s.guard.Check(bruteforce.SurfaceSFTP, userID, remoteIP); err != nil {
+		return nil, nil, err
+	}
+	access, key, err := s.checkAccess(userID, fingerprint, remoteIP)
+	if err != nil {
+		s.guard.Fail(bruteforce.SurfaceSFTP, userID, remoteIP, err.Error())
+		return nil, nil, err
+	}
+	s.guard.Succeed(bruteforce.SurfaceSFTP, userID, remoteIP)
+	return access, key, nil
+}
+
+// checkAccess checks a login against the user's keys and address
+// whitelist
+func (s *Service) checkAccess(userID, fingerprint, remoteIP string) (*models.SSHAccess, *models.SSHKey, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -0,0 +1,281 @@
+// Package stats provides statistics and analytics services for OweHost
+package stats
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/iSundram/OweHost/internal/storage/web"
+)
+
+// ingestInterval is how often access logs are read
+const ingestInterval = 30 * time.Second
+
+// accessTailSize is how much of a log is read for its latest requests
+const accessTailSize = 1 << 20
+
+// maxIngestChunk bounds what is read from one log per pass, so a backlog
+// is worked off over several passes instead of all in memory at once
+const maxIngestChunk = 16 << 20
+
+// Ingester reads the sites' nginx access logs and the FTP and SFTP
+// transfer logs into hourly rollups
+type Ingester struct {
+	stats        *Service
+	logs         map[string]*logState
+	sftpSessions map[string]string // Login of each open sftp-server process
+	stopCh       chan struct{}
+	stopOnce     sync.Once
+	pruned       time.Time
+}
+
+// logState is how far a site's access log has been read. It is kept next
+// to the rollups so a panel restart neither skips nor recounts requests.
+// Log files are told apart by their first line: logrotate moves the file
+// and nginx starts a new one, or truncates it in place.
+type logState struct {
+	FirstLine string `json:"first_line"` // Hash, "" before the first complete line
+	Offset    int64  `json:"offset"`
+}
+
+// NewIngester creates an ingester writing the rollups stats reads
+func NewIngester(stats *Service) *Ingester {
+	return &Ingester{
+		stats:        stats,
+		logs:         make(map[string]*logState),
+		sftpSessions: make(map[string]string),
+		stopCh:       make(chan struct{}),
+	}
+}
+
+// Start reads the logs in the background
+func (in *Ingester) Start() {
+	go in.run()
+}
+
+// Stop stops reading the logs
+func (in *Ingester) Stop() {
+	in.stopOnce.Do(func() { close(in.stopCh) })
+}
+
+func (in *Ingester) run() {
+	ticker := time.NewTicker(ingestInterval)
+	defer ticker.Stop()
+
+	for {
+		in.Scan()
+		select {
+		case <-in.stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Scan reads what every site and file transfer logged since the last scan
+func (in *Ingester) Scan() {
+	accounts, err := in.stats.accounts.ListAccounts()
+	if err != nil {
+		return
+	}
+	for _, accountID := range accounts {
+		sites, err := in.stats.sites.ListSites(accountID)
+		if err != nil {
+			continue
+		}
+		for i := range sites {
+			if err := in.ingestSite(accountID, &sites[i]); err != nil {
+				fmt.Printf("warning: failed to ingest access log of %s: %v\n", sites[i].Domain, err)
+			}
+		}
+	}
+	in.ingestTransfers()
+
+	if time.Since(in.pruned) > 24*time.Hour {
+		in.stats.rollups.prune(time.Now())
+		in.pruned = time.Now()
+	}
+}
+
+// ingestSite counts a site's new requests
+func (in *Ingester) ingestSite(accountID int, site *web.SiteDescriptor) error {
+	logPath := in.stats.sites.AccessLogPath(accountID, site.Domain)
+	statePath := filepath.Join(in.stats.rollups.siteDir(accountID, site.Domain), "ingest.json")
+
+	hosts := map[string]bool{site.Domain: true, "www." + site.Domain: true}
+	for _, alias := range site.Aliases {
+		hosts[alias] = true
+	}
+	hours := make(map[time.Time]*hourRollup)
+
+	return in.follow(logPath, statePath,
+		func(file string, offset int64) (int64, error) {
+			return readEntries(file, offset, hosts, hours)
+		},
+		func() error {
+			if len(hours) == 0 {
+				return nil
+			}
+			return in.stats.rollups.add(accountID, site.Domain, hours)
+		})
+}
+
+// follow reads what was appended to a log since its saved state, then has
+// flush store what was read before the state moves on. A log rotated since
+// the last pass is finished from its first rotated copy before the new one
+// is read from the start.
+func (in *Ingester) follow(logPath, statePath string, read func(file string, offset int64) (int64, error), flush func() error) error {
+	state := in.logs[statePath]
+	if state == nil {
+		state = &logState{}
+		if data, err := os.ReadFile(statePath); err == nil {
+			json.Unmarshal(data, state)
+		}
+		in.logs[statePath] = state
+	}
+
+	info, err := os.Stat(logPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	previous := *state
+	first, err := firstLineHash(logPath)
+	if err != nil {
+		return err
+	}
+	offset := state.Offset
+	if state.FirstLine != "" && (first != state.FirstLine || info.Size() < state.Offset) {
+		if rotated, err := firstLineHash(logPath + ".1"); err == nil && rotated == state.FirstLine {
+			if _, err := read(logPath+".1", state.Offset); err != nil {
+				return err
+			}
+		}
+		offset = 0
+	}
+
+	offset, err = read(logPath, offset)
+	if err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	state.FirstLine = first
+	state.Offset = offset
+	if *state == previous {
+		return nil
+	}
+	return writeJSON(statePath, state)
+}
+
+// firstLineHash identifies a log file by its first complete line
+func firstLineHash(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err == io.EOF {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(line)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// readEntries counts the complete lines of a log after offset into hours
+// and returns the offset to continue from
+func readEntries(file string, offset int64, hosts map[string]bool, hours map[time.Time]*hourRollup) (int64, error) {
+	return readLines(file, offset, func(line []byte) {
+		entry, err := web.ParseAccessEntry(line)
+		if err != nil {
+			return
+		}
+		hour := entry.Time.UTC().Truncate(time.Hour)
+		h := hours[hour]
+		if h == nil {
+			h = newHourRollup()
+			hours[hour] = h
+		}
+		h.add(entry, hosts)
+	})
+}
+
+// readLines passes each complete line of a log after offset to fn and
+// returns the offset to continue from
+func readLines(file string, offset int64, fn func(line []byte)) (int64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return offset, err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.NewSectionReader(f, offset, maxIngestChunk))
+	if err != nil {
+		return offset, err
+	}
+	end := bytes.LastIndexByte(data, '\n')
+	if end < 0 {
+		if len(data) == maxIngestChunk {
+			// Not a line a logger wrote; skip it rather than stall on it
+			return offset + int64(len(data)), nil
+		}
+		return offset, nil
+	}
+
+	for _, line := range bytes.Split(data[:end], []byte("\n")) {
+		fn(line)
+	}
+	return offset + int64(end) + 1, nil
+}
+
+// tailLines returns the complete lines in the last size bytes of a file
+func tailLines(file string, size int64) ([][]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	offset := info.Size() - size
+	if offset < 0 {
+		offset = 0
+	}
+	data, err := io.ReadAll(io.NewSectionReader(f, offset, info.Size()-offset))
+	if err != nil {
+		return nil, err
+	}
+
+	// Starting mid-file cuts the first line, and nginx may be writing the last
+	if offset > 0 {
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			data = data[i+1:]
+		}
+	}
+	end := bytes.LastIndexByte(data, '\n')
+	if end < 0 {
+		return nil, nil
+	}
+	return bytes.Split(data[:end], []byte("\n")), nil
+}
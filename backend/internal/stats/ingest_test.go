@@ -0,0 +1,112 @@
+package stats
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// accessLine is one access log line in the JSON format sites log in
+func accessLine(uri string) string {
+	return fmt.Sprintf(`{"time":"2026-03-14T09:15:00+00:00","addr":"192.0.2.1","host":"example.com","method":"GET","uri":"%s","status":200,"bytes_in":120,"bytes_out":1000,"duration":0.010,"referer":"","agent":"%s"}`+"\n", uri, chromeAgent)
+}
+
+func appendLog(t *testing.T, path, data string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFollow_RotationAndPartialLines(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "access.log")
+	statePath := filepath.Join(dir, "state.json")
+	in := &Ingester{logs: make(map[string]*logState)}
+
+	hours := make(map[time.Time]*hourRollup)
+	read := func(file string, offset int64) (int64, error) {
+		return readEntries(file, offset, nil, hours)
+	}
+	flush := func() error { return nil }
+	requests := func() int64 {
+		var n int64
+		for _, h := range hours {
+			n += h.Requests
+		}
+		return n
+	}
+
+	steps := []struct {
+		name  string
+		setup func()
+		want  int64
+	}{
+		{"missing log", func() {}, 0},
+		{"two lines", func() { appendLog(t, logPath, accessLine("/")+accessLine("/a")) }, 2},
+		{"partial line waits", func() { appendLog(t, logPath, accessLine("/b")[:40]) }, 2},
+		{"line completed", func() { appendLog(t, logPath, accessLine("/b")[40:]) }, 3},
+		{"not json", func() { appendLog(t, logPath, "127.0.0.1 - - [14/Mar/2026] \"GET / HTTP/1.1\" 200\n") }, 3},
+		{"rotated with unread lines", func() {
+			appendLog(t, logPath, accessLine("/c"))
+			if err := os.Rename(logPath, logPath+".1"); err != nil {
+				t.Fatal(err)
+			}
+			appendLog(t, logPath, accessLine("/d")+accessLine("/e"))
+		}, 6},
+		{"nothing new", func() {}, 6},
+		{"truncated in place", func() {
+			if err := os.WriteFile(logPath, []byte(accessLine("/f")), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}, 7},
+	}
+	for _, step := range steps {
+		step.setup()
+		if err := in.follow(logPath, statePath, read, flush); err != nil {
+			t.Fatalf("%s: follow failed: %v", step.name, err)
+		}
+		if got := requests(); got != step.want {
+			t.Errorf("%s: expected %d requests, got %d", step.name, step.want, got)
+		}
+	}
+
+	// A restarted panel continues from the saved state
+	restarted := &Ingester{logs: make(map[string]*logState)}
+	appendLog(t, logPath, accessLine("/g"))
+	if err := restarted.follow(logPath, statePath, read, flush); err != nil {
+		t.Fatalf("follow after restart failed: %v", err)
+	}
+	if got := requests(); got != 8 {
+		t.Errorf("Expected only the new line after a restart, got %d requests", got)
+	}
+}
+
+func TestFollow_FailedFlushRereads(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "access.log")
+	statePath := filepath.Join(dir, "state.json")
+	in := &Ingester{logs: make(map[string]*logState)}
+	appendLog(t, logPath, accessLine("/"))
+
+	var read int
+	readFn := func(file string, offset int64) (int64, error) {
+		return readLines(file, offset, func(line []byte) { read++ })
+	}
+	if err := in.follow(logPath, statePath, readFn, func() error { return os.ErrPermission }); err == nil {
+		t.Fatal("Expected the flush error")
+	}
+	if err := in.follow(logPath, statePath, readFn, func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if read != 2 {
+		t.Errorf("Expected the line to be read again after a failed flush, read %d times", read)
+	}
+}
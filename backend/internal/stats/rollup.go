@@ -0,0 +1,410 @@
+// Package stats provides statistics and analytics services for OweHost
+package stats
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iSundram/OweHost/internal/storage/web"
+)
+
+// statsRoot holds the rollups of every site, one directory per account
+// and site with one file per UTC day
+const statsRoot = "/var/lib/owehost/stats"
+
+// Rollup bounds. Busy hours keep their most frequent pages and referrers
+// only; visitors beyond the cap are not told apart anymore.
+const (
+	maxHourVisitors = 20000
+	maxHourKeys     = 200
+	rollupRetention = 400 * 24 * time.Hour
+)
+
+// hourRollup aggregates one hour of a site's requests
+type hourRollup struct {
+	Requests   int64            `json:"requests"`
+	PageViews  int64            `json:"page_views"`
+	BytesIn    int64            `json:"bytes_in"`
+	BytesOut   int64            `json:"bytes_out"`
+	DurationMS int64            `json:"duration_ms"` // Sum of response times
+	Errors     int64            `json:"errors"`      // 5xx responses
+	Visitors   map[string]bool  `json:"visitors"`    // Hashes of address and user agent
+	Pages      map[string]int64 `json:"pages"`
+	Referrers  map[string]int64 `json:"referrers"` // Referring hosts
+	Statuses   map[string]int64 `json:"statuses"`
+	Browsers   map[string]int64 `json:"browsers"` // "name|major version"
+	Systems    map[string]int64 `json:"systems"`
+}
+
+// dayRollup is a day file: the hours of one UTC day that saw requests
+type dayRollup struct {
+	Date  string              `json:"date"`
+	Hours map[int]*hourRollup `json:"hours"`
+}
+
+func newHourRollup() *hourRollup {
+	return &hourRollup{
+		Visitors:  make(map[string]bool),
+		Pages:     make(map[string]int64),
+		Referrers: make(map[string]int64),
+		Statuses:  make(map[string]int64),
+		Browsers:  make(map[string]int64),
+		Systems:   make(map[string]int64),
+	}
+}
+
+// add counts one request. Crawlers count as hits and bandwidth but not as
+// visitors or page views.
+func (h *hourRollup) add(e *web.AccessEntry, siteHosts map[string]bool) {
+	h.Requests++
+	h.BytesIn += e.BytesIn
+	h.BytesOut += e.BytesOut
+	h.DurationMS += int64(e.Duration * 1000)
+	h.Statuses[fmt.Sprint(e.Status)]++
+	if e.Status >= 500 {
+		h.Errors++
+	}
+
+	browser, version := classifyBrowser(e.Agent)
+	if browser == "Bot" {
+		h.Browsers["Bot|"]++
+		return
+	}
+	h.Browsers[browser+"|"+version]++
+	h.Systems[classifyOS(e.Agent)]++
+
+	if len(h.Visitors) < maxHourVisitors {
+		h.Visitors[visitorHash(e.Addr, e.Agent)] = true
+	}
+
+	if !isPageView(e) {
+		return
+	}
+	h.PageViews++
+	p := e.URI
+	if i := strings.IndexByte(p, '?'); i >= 0 {
+		p = p[:i]
+	}
+	h.Pages[p]++
+	if ref, err := url.Parse(e.Referer); err == nil && ref.Host != "" && !siteHosts[strings.ToLower(ref.Hostname())] {
+		h.Referrers[strings.ToLower(ref.Hostname())]++
+	}
+}
+
+// merge adds the counts of o
+func (h *hourRollup) merge(o *hourRollup) {
+	h.Requests += o.Requests
+	h.PageViews += o.PageViews
+	h.BytesIn += o.BytesIn
+	h.BytesOut += o.BytesOut
+	h.DurationMS += o.DurationMS
+	h.Errors += o.Errors
+	for v := range o.Visitors {
+		if len(h.Visitors) >= maxHourVisitors {
+			break
+		}
+		h.Visitors[v] = true
+	}
+	mergeCounts(h.Pages, o.Pages)
+	mergeCounts(h.Referrers, o.Referrers)
+	mergeCounts(h.Statuses, o.Statuses)
+	mergeCounts(h.Browsers, o.Browsers)
+	mergeCounts(h.Systems, o.Systems)
+}
+
+// trim keeps the most frequent pages and referrers
+func (h *hourRollup) trim() {
+	h.Pages = topCounts(h.Pages, maxHourKeys)
+	h.Referrers = topCounts(h.Referrers, maxHourKeys)
+}
+
+func mergeCounts(dst, src map[string]int64) {
+	for k, n := range src {
+		dst[k] += n
+	}
+}
+
+// countEntry is a key and its count, for sorting
+type countEntry struct {
+	Key   string
+	Count int64
+}
+
+// sortedCounts orders counts by frequency, ties by key
+func sortedCounts(counts map[string]int64) []countEntry {
+	out := make([]countEntry, 0, len(counts))
+	for k, n := range counts {
+		out = append(out, countEntry{k, n})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Key < out[j].Key
+	})
+	return out
+}
+
+func topCounts(counts map[string]int64, n int) map[string]int64 {
+	if len(counts) <= n {
+		return counts
+	}
+	top := make(map[string]int64, n)
+	for _, c := range sortedCounts(counts)[:n] {
+		top[c.Key] = c.Count
+	}
+	return top
+}
+
+// visitorHash identifies a visitor without storing the address
+func visitorHash(addr, agent string) string {
+	sum := sha256.Sum256([]byte(addr + "|" + agent))
+	return hex.EncodeToString(sum[:8])
+}
+
+// pageExtensions are the files counted as pages; everything else with an
+// extension is an asset
+var pageExtensions = map[string]bool{"": true, ".html": true, ".htm": true, ".php": true}
+
+// isPageView reports whether a request loaded a page
+func isPageView(e *web.AccessEntry) bool {
+	if e.Method != "GET" || !(e.Status >= 200 && e.Status < 300 || e.Status == 304) {
+		return false
+	}
+	p := e.URI
+	if i := strings.IndexAny(p, "?#"); i >= 0 {
+		p = p[:i]
+	}
+	return pageExtensions[strings.ToLower(path.Ext(p))]
+}
+
+var (
+	botAgent     = regexp.MustCompile(`(?i)bot|crawl|spider|slurp|curl|wget|python-requests|go-http-client|monitor`)
+	browserRules = []struct {
+		Name    string
+		Pattern *regexp.Regexp
+	}{
+		{"Edge", regexp.MustCompile(`Edg(?:e|A|iOS)?/(\d+)`)},
+		{"Opera", regexp.MustCompile(`(?:OPR|Opera)/(\d+)`)},
+		{"Samsung Internet", regexp.MustCompile(`SamsungBrowser/(\d+)`)},
+		{"Firefox", regexp.MustCompile(`(?:Firefox|FxiOS)/(\d+)`)},
+		{"Chrome", regexp.MustCompile(`(?:Chrome|CriOS)/(\d+)`)},
+		{"Safari", regexp.MustCompile(`Version/(\d+)[\d.]* (?:Mobile/\S+ )?Safari/`)},
+		{"Internet Explorer", regexp.MustCompile(`(?:MSIE |Trident/.*rv:)(\d+)`)},
+	}
+)
+
+// classifyBrowser returns a user agent's browser and major version.
+// Order matters: most browsers also claim to be Chrome or Safari.
+func classifyBrowser(agent string) (string, string) {
+	if agent == "" || botAgent.MatchString(agent) {
+		return "Bot", ""
+	}
+	for _, rule := range browserRules {
+		if m := rule.Pattern.FindStringSubmatch(agent); m != nil {
+			return rule.Name, m[1]
+		}
+	}
+	return "Other", ""
+}
+
+// classifyOS returns a user agent's operating system
+func classifyOS(agent string) string {
+	switch {
+	case strings.Contains(agent, "Windows"):
+		return "Windows"
+	case strings.Contains(agent, "Android"):
+		return "Android"
+	case strings.Contains(agent, "iPhone"), strings.Contains(agent, "iPad"), strings.Contains(agent, "iPod"):
+		return "iOS"
+	case strings.Contains(agent, "Mac OS X"), strings.Contains(agent, "Macintosh"):
+		return "macOS"
+	case strings.Contains(agent, "CrOS"):
+		return "ChromeOS"
+	case strings.Contains(agent, "Linux"):
+		return "Linux"
+	default:
+		return "Other"
+	}
+}
+
+// rollupStore reads and writes day files
+type rollupStore struct {
+	root string
+	mu   sync.Mutex
+
+	// Bytes out per hour of day files no longer written to, by path. The
+	// bandwidth of a billing period is summed from them over and over.
+	closedBytes map[string][24]int64
+}
+
+// siteDir returns the rollup directory of a site
+func (r *rollupStore) siteDir(accountID int, domain string) string {
+	return filepath.Join(r.root, fmt.Sprintf("a-%d", accountID), domain)
+}
+
+func (r *rollupStore) dayPath(accountID int, domain string, day time.Time) string {
+	return filepath.Join(r.siteDir(accountID, domain), day.UTC().Format("2006-01-02")+".json")
+}
+
+// readDay loads a day file. Days without requests have none.
+func (r *rollupStore) readDay(accountID int, domain string, day time.Time) (*dayRollup, error) {
+	data, err := os.ReadFile(r.dayPath(accountID, domain, day))
+	if os.IsNotExist(err) {
+		return &dayRollup{Date: day.UTC().Format("2006-01-02"), Hours: make(map[int]*hourRollup)}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var d dayRollup
+	if err := json.Unmarshal(data, &d); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", r.dayPath(accountID, domain, day), err)
+	}
+	if d.Hours == nil {
+		d.Hours = make(map[int]*hourRollup)
+	}
+	return &d, nil
+}
+
+// add merges freshly counted hours, keyed by their start, into the day files
+func (r *rollupStore) add(accountID int, domain string, hours map[time.Time]*hourRollup) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	days := make(map[string][]time.Time)
+	for hour := range hours {
+		key := hour.Format("2006-01-02")
+		days[key] = append(days[key], hour)
+	}
+
+	for _, list := range days {
+		day, err := r.readDay(accountID, domain, list[0])
+		if err != nil {
+			return err
+		}
+		for _, hour := range list {
+			h := day.Hours[hour.Hour()]
+			if h == nil {
+				h = newHourRollup()
+				day.Hours[hour.Hour()] = h
+			}
+			h.merge(hours[hour])
+			h.trim()
+		}
+		if err := writeJSON(r.dayPath(accountID, domain, list[0]), day); err != nil {
+			return err
+		}
+		delete(r.closedBytes, r.dayPath(accountID, domain, list[0]))
+	}
+	return nil
+}
+
+// names returns the rollups of an account: its sites, including removed
+// ones, and its file transfers
+func (r *rollupStore) names(accountID int) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(r.root, fmt.Sprintf("a-%d", accountID)))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+// hourlyBytesOut returns the bytes out of each hour of a day. Logs are
+// read with some delay, so only days before yesterday are kept in memory.
+func (r *rollupStore) hourlyBytesOut(accountID int, name string, day, now time.Time) ([24]int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	path := r.dayPath(accountID, name, day)
+	if hours, ok := r.closedBytes[path]; ok {
+		return hours, nil
+	}
+
+	var hours [24]int64
+	d, err := r.readDay(accountID, name, day)
+	if err != nil {
+		return hours, err
+	}
+	for h, rollup := range d.Hours {
+		if h >= 0 && h < 24 {
+			hours[h] = rollup.BytesOut
+		}
+	}
+	if day.UTC().Format("2006-01-02") < now.UTC().AddDate(0, 0, -1).Format("2006-01-02") {
+		if r.closedBytes == nil {
+			r.closedBytes = make(map[string][24]int64)
+		}
+		r.closedBytes[path] = hours
+	}
+	return hours, nil
+}
+
+// days returns the day files between start and end, oldest first
+func (r *rollupStore) days(accountID int, domain string, start, end time.Time) ([]*dayRollup, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var out []*dayRollup
+	first := start.UTC().Truncate(24 * time.Hour)
+	for d := first; !d.After(end); d = d.Add(24 * time.Hour) {
+		day, err := r.readDay(accountID, domain, d)
+		if err != nil {
+			return nil, err
+		}
+		if len(day.Hours) > 0 {
+			out = append(out, day)
+		}
+	}
+	return out, nil
+}
+
+// prune removes day files older than the retention period
+func (r *rollupStore) prune(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.closedBytes = nil
+	cutoff := now.Add(-rollupRetention).UTC().Format("2006-01-02")
+	files, _ := filepath.Glob(filepath.Join(r.root, "a-*", "*", "????-??-??.json"))
+	for _, f := range files {
+		if strings.TrimSuffix(filepath.Base(f), ".json") < cutoff {
+			os.Remove(f)
+		}
+	}
+}
+
+// writeJSON atomically replaces a file with v
+func writeJSON(file string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0750); err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0640); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
@@ -0,0 +1,153 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/iSundram/OweHost/internal/storage/web"
+)
+
+const (
+	chromeAgent  = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36"
+	edgeAgent    = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36 Edg/124.0.2478.51"
+	safariAgent  = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1"
+	firefoxAgent = "Mozilla/5.0 (X11; Linux x86_64; rv:125.0) Gecko/20100101 Firefox/125.0"
+	botAgentUA   = "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"
+)
+
+func TestClassifyBrowser(t *testing.T) {
+	tests := []struct {
+		agent, browser, version, os string
+	}{
+		{chromeAgent, "Chrome", "124", "Windows"},
+		{edgeAgent, "Edge", "124", "Windows"},
+		{safariAgent, "Safari", "17", "iOS"},
+		{firefoxAgent, "Firefox", "125", "Linux"},
+		{"Mozilla/5.0 (Linux; Android 14; SM-S918B) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/24.0 Chrome/117.0.0.0 Mobile Safari/537.36", "Samsung Internet", "24", "Android"},
+		{"Mozilla/5.0 (Windows NT 10.0; Trident/7.0; rv:11.0) like Gecko", "Internet Explorer", "11", "Windows"},
+		{botAgentUA, "Bot", "", "Other"},
+		{"curl/8.5.0", "Bot", "", "Other"},
+		{"", "Bot", "", "Other"},
+		{"Lynx/2.8.9rel.1", "Other", "", "Other"},
+	}
+	for _, tt := range tests {
+		browser, version := classifyBrowser(tt.agent)
+		if browser != tt.browser || version != tt.version {
+			t.Errorf("%q: expected %s %s, got %s %s", tt.agent, tt.browser, tt.version, browser, version)
+		}
+		if os := classifyOS(tt.agent); os != tt.os {
+			t.Errorf("%q: expected OS %s, got %s", tt.agent, tt.os, os)
+		}
+	}
+}
+
+func TestIsPageView(t *testing.T) {
+	tests := []struct {
+		method string
+		uri    string
+		status int
+		want   bool
+	}{
+		{"GET", "/", 200, true},
+		{"GET", "/about.html?ref=nav", 200, true},
+		{"GET", "/index.php#top", 304, true},
+		{"GET", "/blog/post-1", 200, true},
+		{"GET", "/app.js", 200, false},
+		{"GET", "/logo.PNG", 200, false},
+		{"POST", "/contact", 200, false},
+		{"GET", "/missing", 404, false},
+		{"GET", "/old", 301, false},
+	}
+	for _, tt := range tests {
+		e := &web.AccessEntry{Method: tt.method, URI: tt.uri, Status: tt.status}
+		if got := isPageView(e); got != tt.want {
+			t.Errorf("%s %s %d: expected %v, got %v", tt.method, tt.uri, tt.status, tt.want, got)
+		}
+	}
+}
+
+func TestHourRollup_Add(t *testing.T) {
+	hosts := map[string]bool{"example.com": true, "www.example.com": true}
+	entries := []*web.AccessEntry{
+		{Addr: "192.0.2.1", Method: "GET", URI: "/?utm=x", Status: 200, BytesOut: 1000, Duration: 0.25, Agent: chromeAgent, Referer: "https://www.Google.com/search"},
+		{Addr: "192.0.2.1", Method: "GET", URI: "/style.css", Status: 200, BytesOut: 500, Agent: chromeAgent, Referer: "https://example.com/"},
+		{Addr: "192.0.2.1", Method: "GET", URI: "/about", Status: 200, BytesOut: 800, Agent: chromeAgent, Referer: "https://www.example.com/"},
+		{Addr: "192.0.2.2", Method: "GET", URI: "/", Status: 200, BytesOut: 1000, Agent: firefoxAgent},
+		{Addr: "192.0.2.3", Method: "GET", URI: "/", Status: 200, BytesOut: 1000, Agent: botAgentUA},
+		{Addr: "192.0.2.2", Method: "POST", URI: "/contact", Status: 502, BytesIn: 300, BytesOut: 200, Agent: firefoxAgent},
+	}
+
+	h := newHourRollup()
+	for _, e := range entries {
+		h.add(e, hosts)
+	}
+
+	if h.Requests != 6 || h.BytesOut != 4500 || h.BytesIn != 300 {
+		t.Errorf("Expected 6 requests, 4500 bytes out and 300 in, got %d, %d, %d", h.Requests, h.BytesOut, h.BytesIn)
+	}
+	if h.PageViews != 3 {
+		t.Errorf("Expected 3 page views without assets, posts or bots, got %d", h.PageViews)
+	}
+	if len(h.Visitors) != 2 {
+		t.Errorf("Expected 2 visitors without bots, got %d", len(h.Visitors))
+	}
+	if h.Errors != 1 || h.Statuses["502"] != 1 || h.Statuses["200"] != 5 {
+		t.Errorf("Expected one 502 and five 200s, got %v", h.Statuses)
+	}
+	if h.Pages["/"] != 2 || h.Pages["/about"] != 1 {
+		t.Errorf("Expected pages without query strings, got %v", h.Pages)
+	}
+	if len(h.Referrers) != 1 || h.Referrers["www.google.com"] != 1 {
+		t.Errorf("Expected only the external referrer, got %v", h.Referrers)
+	}
+	if h.Browsers["Bot|"] != 1 || h.Browsers["Chrome|124"] != 3 || h.Browsers["Firefox|125"] != 2 {
+		t.Errorf("Unexpected browsers %v", h.Browsers)
+	}
+	if h.DurationMS != 250 {
+		t.Errorf("Expected 250ms of response time, got %d", h.DurationMS)
+	}
+}
+
+func TestRollupStore_AddMerges(t *testing.T) {
+	store := &rollupStore{root: t.TempDir()}
+	hour := time.Date(2026, 3, 14, 9, 0, 0, 0, time.UTC)
+
+	batch := func(bytes int64, visitor string) map[time.Time]*hourRollup {
+		h := newHourRollup()
+		h.add(&web.AccessEntry{Addr: visitor, Method: "GET", URI: "/", Status: 200, BytesOut: bytes, Agent: chromeAgent}, nil)
+		return map[time.Time]*hourRollup{hour: h}
+	}
+	if err := store.add(1001, "example.com", batch(100, "192.0.2.1")); err != nil {
+		t.Fatalf("Failed to add: %v", err)
+	}
+	if err := store.add(1001, "example.com", batch(50, "192.0.2.2")); err != nil {
+		t.Fatalf("Failed to add: %v", err)
+	}
+
+	day, err := store.readDay(1001, "example.com", hour)
+	if err != nil {
+		t.Fatalf("Failed to read day: %v", err)
+	}
+	h := day.Hours[9]
+	if h == nil || h.Requests != 2 || h.BytesOut != 150 || len(h.Visitors) != 2 {
+		t.Fatalf("Expected both batches merged into 09:00, got %+v", h)
+	}
+
+	bytes, err := store.hourlyBytesOut(1001, "example.com", hour, hour.Add(72*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes[9] != 150 {
+		t.Errorf("Expected 150 bytes out at 09:00, got %d", bytes[9])
+	}
+
+	days, err := store.days(1001, "example.com", hour.AddDate(0, 0, -2), hour.AddDate(0, 0, 2))
+	if err != nil || len(days) != 1 {
+		t.Errorf("Expected one day with traffic, got %d, %v", len(days), err)
+	}
+
+	store.prune(hour.Add(rollupRetention + 48*time.Hour))
+	if days, _ := store.days(1001, "example.com", hour, hour); len(days) != 0 {
+		t.Error("Expected day files past retention to be pruned")
+	}
+}
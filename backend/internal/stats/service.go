@@ -2,77 +2,190 @@
 package stats
 
 import (
+	"fmt"
 	"math/rand"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/iSundram/OweHost/internal/domain"
+	"github.com/iSundram/OweHost/internal/storage/account"
+	"github.com/iSundram/OweHost/internal/storage/web"
 	"github.com/iSundram/OweHost/pkg/models"
 	"github.com/iSundram/OweHost/pkg/utils"
 )
 
-// Service provides statistics and analytics functionality
+// Service provides statistics and analytics functionality. Traffic
+// figures come from the hourly rollups the Ingester builds from the sites'
+// access logs.
 type Service struct {
-	bandwidthStats  map[string][]*models.BandwidthStat
-	visitorStats    map[string][]*models.VisitorStat
-	errorLogs       map[string][]*models.ErrorLogEntry
-	accessLogs      map[string][]*models.AccessLogEntry
-	resourceStats   map[string]*models.ResourceStat
-	mu              sync.RWMutex
+	errorLogs     map[string][]*models.ErrorLogEntry
+	resourceStats map[string]*models.ResourceStat
+	domains       *domain.Service
+	accounts      *account.StateManager
+	sites         *web.StateManager
+	rollups       *rollupStore
+	mu            sync.RWMutex
 }
 
 // NewService creates a new stats service
-func NewService() *Service {
+func NewService(domainSvc *domain.Service) *Service {
 	return &Service{
-		bandwidthStats: make(map[string][]*models.BandwidthStat),
-		visitorStats:   make(map[string][]*models.VisitorStat),
-		errorLogs:      make(map[string][]*models.ErrorLogEntry),
-		accessLogs:     make(map[string][]*models.AccessLogEntry),
-		resourceStats:  make(map[string]*models.ResourceStat),
+		errorLogs:     make(map[string][]*models.ErrorLogEntry),
+		resourceStats: make(map[string]*models.ResourceStat),
+		domains:       domainSvc,
+		accounts:      account.NewStateManager(),
+		sites:         web.NewStateManager(),
+		rollups:       &rollupStore{root: statsRoot},
 	}
 }
 
-// GetBandwidthStats gets bandwidth statistics for a domain
-func (s *Service) GetBandwidthStats(domainID string, startDate, endDate time.Time) []*models.BandwidthStat {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	stats := s.bandwidthStats[domainID]
-	if stats == nil {
-		// Generate mock data for demonstration
-		stats = s.generateMockBandwidthStats(domainID, startDate, endDate)
+// siteFor finds the account hosting a domain. domainID may also be the
+// domain name itself.
+func (s *Service) siteFor(domainID string) (int, string, bool) {
+	name := domainID
+	if s.domains != nil {
+		if d, err := s.domains.Get(domainID); err == nil {
+			name = d.Name
+		}
+	}
+	if web.ValidateDomain(name) != nil {
+		return 0, "", false
 	}
 
-	// Filter by date range
-	filtered := make([]*models.BandwidthStat, 0)
-	for _, stat := range stats {
-		if !stat.Date.Before(startDate) && !stat.Date.After(endDate) {
-			filtered = append(filtered, stat)
+	accounts, err := s.accounts.ListAccounts()
+	if err != nil {
+		return 0, "", false
+	}
+	for _, id := range accounts {
+		if s.sites.Exists(id, name) {
+			return id, name, true
 		}
 	}
+	return 0, "", false
+}
 
-	return filtered
+// dayRollups returns a domain's day files between two dates
+func (s *Service) dayRollups(domainID string, startDate, endDate time.Time) []*dayRollup {
+	accountID, name, ok := s.siteFor(domainID)
+	if !ok {
+		return nil
+	}
+	days, err := s.rollups.days(accountID, name, startDate, endDate)
+	if err != nil {
+		fmt.Printf("warning: failed to read stats of %s: %v\n", name, err)
+	}
+	return days
+}
+
+// rollupDate returns the UTC midnight a day file covers
+func rollupDate(day *dayRollup) time.Time {
+	t, _ := time.Parse("2006-01-02", day.Date)
+	return t
 }
 
-// GetVisitorStats gets visitor statistics for a domain
+// GetBandwidthStats gets daily bandwidth statistics for a domain
+func (s *Service) GetBandwidthStats(domainID string, startDate, endDate time.Time) []*models.BandwidthStat {
+	stats := make([]*models.BandwidthStat, 0)
+	for _, day := range s.dayRollups(domainID, startDate, endDate) {
+		stat := &models.BandwidthStat{
+			ID:       utils.GenerateID("bw"),
+			DomainID: domainID,
+			Date:     rollupDate(day),
+		}
+		for _, h := range day.Hours {
+			stat.BytesIn += h.BytesIn
+			stat.BytesOut += h.BytesOut
+			stat.Requests += h.Requests
+		}
+		stats = append(stats, stat)
+	}
+	return stats
+}
+
+// GetVisitorStats gets daily visitor statistics for a domain
 func (s *Service) GetVisitorStats(domainID string, startDate, endDate time.Time) []*models.VisitorStat {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	stats := make([]*models.VisitorStat, 0)
+	for _, day := range s.dayRollups(domainID, startDate, endDate) {
+		stat := &models.VisitorStat{
+			ID:       utils.GenerateID("vs"),
+			DomainID: domainID,
+			Date:     rollupDate(day),
+		}
+		visitors := make(map[string]bool)
+		for _, h := range day.Hours {
+			for v := range h.Visitors {
+				visitors[v] = true
+			}
+			stat.PageViews += int(h.PageViews)
+			stat.Hits += int(h.Requests)
+		}
+		stat.UniqueVisitors = len(visitors)
+		stats = append(stats, stat)
+	}
+	return stats
+}
 
-	stats := s.visitorStats[domainID]
-	if stats == nil {
-		// Generate mock data for demonstration
-		stats = s.generateMockVisitorStats(domainID, startDate, endDate)
+// GetTrafficBreakdown gets a domain's top pages and referrers and its
+// status codes, browsers and operating systems between two dates
+func (s *Service) GetTrafficBreakdown(domainID string, startDate, endDate time.Time, limit int) *models.TrafficBreakdown {
+	total := newHourRollup()
+	for _, day := range s.dayRollups(domainID, startDate, endDate) {
+		for _, h := range day.Hours {
+			total.merge(h)
+		}
 	}
 
-	// Filter by date range
-	filtered := make([]*models.VisitorStat, 0)
-	for _, stat := range stats {
-		if !stat.Date.Before(startDate) && !stat.Date.After(endDate) {
-			filtered = append(filtered, stat)
+	breakdown := &models.TrafficBreakdown{
+		DomainID:         domainID,
+		StartDate:        startDate,
+		EndDate:          endDate,
+		TopPages:         make([]models.TopPage, 0),
+		TopReferrers:     make([]models.TopReferrer, 0),
+		StatusCodes:      total.Statuses,
+		Browsers:         make([]models.BrowserStat, 0),
+		OperatingSystems: make([]models.OSStat, 0),
+	}
+
+	for i, c := range sortedCounts(total.Pages) {
+		if limit > 0 && i == limit {
+			break
+		}
+		breakdown.TopPages = append(breakdown.TopPages, models.TopPage{Path: c.Key, Views: int(c.Count)})
+	}
+	for i, c := range sortedCounts(total.Referrers) {
+		if limit > 0 && i == limit {
+			break
 		}
+		breakdown.TopReferrers = append(breakdown.TopReferrers, models.TopReferrer{Referrer: c.Key, Count: int(c.Count)})
 	}
 
-	return filtered
+	var browsers, systems int64
+	for _, n := range total.Browsers {
+		browsers += n
+	}
+	for _, n := range total.Systems {
+		systems += n
+	}
+	for _, c := range sortedCounts(total.Browsers) {
+		name, version, _ := strings.Cut(c.Key, "|")
+		breakdown.Browsers = append(breakdown.Browsers, models.BrowserStat{
+			Browser: name, Version: version, Count: int(c.Count), Percent: percent(c.Count, browsers),
+		})
+	}
+	for _, c := range sortedCounts(total.Systems) {
+		breakdown.OperatingSystems = append(breakdown.OperatingSystems, models.OSStat{
+			OS: c.Key, Count: int(c.Count), Percent: percent(c.Count, systems),
+		})
+	}
+	return breakdown
+}
+
+func percent(n, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total) * 100
 }
 
 // GetErrorLogs gets error logs for a domain
@@ -92,15 +205,37 @@ func (s *Service) GetErrorLogs(domainID string, limit int) []*models.ErrorLogEnt
 	return logs
 }
 
-// GetAccessLogs gets access logs for a domain
+// GetAccessLogs gets the latest requests of a domain from its access log
 func (s *Service) GetAccessLogs(domainID string, limit int) []*models.AccessLogEntry {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	logs := make([]*models.AccessLogEntry, 0)
 
-	logs := s.accessLogs[domainID]
-	if logs == nil {
-		// Generate mock data
-		logs = s.generateMockAccessLogs(domainID)
+	accountID, name, ok := s.siteFor(domainID)
+	if !ok {
+		return logs
+	}
+	lines, err := tailLines(s.sites.AccessLogPath(accountID, name), accessTailSize)
+	if err != nil {
+		return logs
+	}
+
+	for _, line := range lines {
+		entry, err := web.ParseAccessEntry(line)
+		if err != nil {
+			continue
+		}
+		logs = append(logs, &models.AccessLogEntry{
+			ID:           utils.GenerateID("acc"),
+			DomainID:     domainID,
+			Timestamp:    entry.Time,
+			IPAddress:    entry.Addr,
+			Method:       entry.Method,
+			Path:         entry.URI,
+			StatusCode:   entry.Status,
+			BytesSent:    entry.BytesOut,
+			ResponseTime: int(entry.Duration * 1000),
+			UserAgent:    entry.Agent,
+			Referer:      entry.Referer,
+		})
 	}
 
 	if limit > 0 && limit < len(logs) {
@@ -122,39 +257,35 @@ func (s *Service) GetResourceStats(userID string) *models.ResourceStat {
 	return stat
 }
 
-// GetDomainSummary gets a summary of statistics for a domain
+// GetDomainSummary gets a summary of the last 30 days of a domain
 func (s *Service) GetDomainSummary(domainID string) *models.DomainStatsSummary {
 	endDate := time.Now()
-	startDate := endDate.AddDate(0, 0, -30) // Last 30 days
-
-	bandwidthStats := s.GetBandwidthStats(domainID, startDate, endDate)
-	visitorStats := s.GetVisitorStats(domainID, startDate, endDate)
+	startDate := endDate.AddDate(0, 0, -30)
 
-	var totalBandwidth int64
-	var totalVisitors int64
-	var totalPageViews int64
-	var totalHits int64
-
-	for _, stat := range bandwidthStats {
-		totalBandwidth += stat.BytesIn + stat.BytesOut
+	summary := &models.DomainStatsSummary{
+		DomainID: domainID,
+		Period:   "30d",
 	}
 
-	for _, stat := range visitorStats {
-		totalVisitors += int64(stat.UniqueVisitors)
-		totalPageViews += int64(stat.PageViews)
-		totalHits += int64(stat.Hits)
+	var duration int64
+	for _, day := range s.dayRollups(domainID, startDate, endDate) {
+		visitors := make(map[string]bool)
+		for _, h := range day.Hours {
+			summary.TotalBandwidth += h.BytesIn + h.BytesOut
+			summary.TotalPageViews += h.PageViews
+			summary.TotalHits += h.Requests
+			summary.ErrorCount += int(h.Errors)
+			duration += h.DurationMS
+			for v := range h.Visitors {
+				visitors[v] = true
+			}
+		}
+		summary.TotalVisitors += int64(len(visitors))
 	}
-
-	return &models.DomainStatsSummary{
-		DomainID:         domainID,
-		Period:           "30d",
-		TotalBandwidth:   totalBandwidth,
-		TotalVisitors:    totalVisitors,
-		TotalPageViews:   totalPageViews,
-		TotalHits:        totalHits,
-		AverageLoadTime:  250, // ms
-		ErrorCount:       len(s.GetErrorLogs(domainID, 0)),
+	if summary.TotalHits > 0 {
+		summary.AverageLoadTime = int(duration / summary.TotalHits)
 	}
+	return summary
 }
 
 // GetUserSummary gets a summary of statistics for a user
@@ -178,63 +309,6 @@ func (s *Service) GetUserSummary(userID string) *models.UserStatsSummary {
 	}
 }
 
-// RecordBandwidth records bandwidth usage
-func (s *Service) RecordBandwidth(domainID string, bytesIn, bytesOut int64) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	today := time.Now().Truncate(24 * time.Hour)
-
-	stats := s.bandwidthStats[domainID]
-	for _, stat := range stats {
-		if stat.Date.Equal(today) {
-			stat.BytesIn += bytesIn
-			stat.BytesOut += bytesOut
-			stat.Requests++
-			return
-		}
-	}
-
-	// Create new stat for today
-	stat := &models.BandwidthStat{
-		ID:       utils.GenerateID("bw"),
-		DomainID: domainID,
-		Date:     today,
-		BytesIn:  bytesIn,
-		BytesOut: bytesOut,
-		Requests: 1,
-	}
-	s.bandwidthStats[domainID] = append(s.bandwidthStats[domainID], stat)
-}
-
-// RecordVisitor records a visitor
-func (s *Service) RecordVisitor(domainID, ipAddress, userAgent, path string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	today := time.Now().Truncate(24 * time.Hour)
-
-	stats := s.visitorStats[domainID]
-	for _, stat := range stats {
-		if stat.Date.Equal(today) {
-			stat.Hits++
-			stat.PageViews++
-			return
-		}
-	}
-
-	// Create new stat for today
-	stat := &models.VisitorStat{
-		ID:             utils.GenerateID("vs"),
-		DomainID:       domainID,
-		Date:           today,
-		UniqueVisitors: 1,
-		PageViews:      1,
-		Hits:           1,
-	}
-	s.visitorStats[domainID] = append(s.visitorStats[domainID], stat)
-}
-
 // RecordError records an error log entry
 func (s *Service) RecordError(domainID string, level, message, file string, line int) {
 	s.mu.Lock()
@@ -260,40 +334,6 @@ func (s *Service) RecordError(domainID string, level, message, file string, line
 
 // Helper functions for generating mock data
 
-func (s *Service) generateMockBandwidthStats(domainID string, startDate, endDate time.Time) []*models.BandwidthStat {
-	stats := make([]*models.BandwidthStat, 0)
-	for d := startDate; !d.After(endDate); d = d.AddDate(0, 0, 1) {
-		stat := &models.BandwidthStat{
-			ID:       utils.GenerateID("bw"),
-			DomainID: domainID,
-			Date:     d,
-			BytesIn:  int64(rand.Intn(1000000000)),
-			BytesOut: int64(rand.Intn(5000000000)),
-			Requests: int64(rand.Intn(10000)),
-		}
-		stats = append(stats, stat)
-	}
-	return stats
-}
-
-func (s *Service) generateMockVisitorStats(domainID string, startDate, endDate time.Time) []*models.VisitorStat {
-	stats := make([]*models.VisitorStat, 0)
-	for d := startDate; !d.After(endDate); d = d.AddDate(0, 0, 1) {
-		stat := &models.VisitorStat{
-			ID:             utils.GenerateID("vs"),
-			DomainID:       domainID,
-			Date:           d,
-			UniqueVisitors: rand.Intn(500),
-			PageViews:      rand.Intn(2000),
-			Hits:           rand.Intn(5000),
-			BounceRate:     float64(rand.Intn(80)),
-			AvgSessionTime: rand.Intn(300),
-		}
-		stats = append(stats, stat)
-	}
-	return stats
-}
-
 func (s *Service) generateMockErrorLogs(domainID string) []*models.ErrorLogEntry {
 	levels := []string{"error", "warning", "notice"}
 	messages := []string{
@@ -319,35 +359,6 @@ func (s *Service) generateMockErrorLogs(domainID string) []*models.ErrorLogEntry
 	return logs
 }
 
-func (s *Service) generateMockAccessLogs(domainID string) []*models.AccessLogEntry {
-	methods := []string{"GET", "POST", "PUT", "DELETE"}
-	paths := []string{"/", "/api/users", "/products", "/about", "/contact"}
-	statuses := []int{200, 200, 200, 200, 301, 404, 500}
-	userAgents := []string{
-		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36",
-		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7)",
-		"curl/7.68.0",
-	}
-
-	logs := make([]*models.AccessLogEntry, 0)
-	for i := 0; i < 50; i++ {
-		log := &models.AccessLogEntry{
-			ID:           utils.GenerateID("acc"),
-			DomainID:     domainID,
-			Timestamp:    time.Now().Add(-time.Duration(i) * time.Minute),
-			IPAddress:    "192.168.1." + string(rune(rand.Intn(255))),
-			Method:       methods[rand.Intn(len(methods))],
-			Path:         paths[rand.Intn(len(paths))],
-			StatusCode:   statuses[rand.Intn(len(statuses))],
-			BytesSent:    int64(rand.Intn(50000)),
-			ResponseTime: rand.Intn(500),
-			UserAgent:    userAgents[rand.Intn(len(userAgents))],
-		}
-		logs = append(logs, log)
-	}
-	return logs
-}
-
 func (s *Service) generateMockResourceStats(userID string) *models.ResourceStat {
 	return &models.ResourceStat{
 		UserID:           userID,
@@ -0,0 +1,269 @@
+// Package stats provides statistics and analytics services for OweHost
+package stats
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iSundram/OweHost/internal/storage/account"
+)
+
+// Transfers are rolled up per account next to its sites, under names no
+// domain can have. Requests count files, bytes out what clients downloaded.
+const (
+	ftpRollup  = "ftp"
+	sftpRollup = "sftp"
+)
+
+// maxSFTPSessions bounds the sessions remembered at once
+const maxSFTPSessions = 10000
+
+// Logs file transfers are read from: the FTP server's xferlog, and sshd's
+// log where sftp-server runs with -l INFO. Distributions differ in where
+// sshd logs; whichever files exist are read.
+var (
+	xferLogPath  = "/var/log/xferlog"
+	authLogPaths = []string{"/var/log/auth.log", "/var/log/secure"}
+)
+
+var (
+	sftpLine    = regexp.MustCompile(`^(.*?) \S+ (?:internal-sftp|sftp-server)\[(\d+)\]: (.*)$`)
+	sftpOpened  = regexp.MustCompile(`^session opened for local user (\S+) from`)
+	sftpClosed  = regexp.MustCompile(`^session closed for local user `)
+	sftpClose   = regexp.MustCompile(`^close "(.*)" bytes read (\d+) written (\d+)$`)
+	accountPath = regexp.MustCompile(`^` + regexp.QuoteMeta(account.BaseAccountPath+"/"+account.AccountPrefix) + `(\d+)(?:/|$)`)
+)
+
+// AccountBytes is what an account sent to clients, by protocol
+type AccountBytes struct {
+	HTTP int64 `json:"http"`
+	FTP  int64 `json:"ftp"`
+	SFTP int64 `json:"sftp"`
+}
+
+// AccountBytesOut sums what an account sent between two times, to the
+// hour. Sites removed since still count: their traffic was served.
+func (s *Service) AccountBytesOut(accountID int, start, end time.Time) (*AccountBytes, error) {
+	names, err := s.rollups.names(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	total := &AccountBytes{}
+	first := start.UTC().Truncate(time.Hour)
+	for _, name := range names {
+		for d := first.Truncate(24 * time.Hour); d.Before(end); d = d.Add(24 * time.Hour) {
+			hours, err := s.rollups.hourlyBytesOut(accountID, name, d, time.Now())
+			if err != nil {
+				return nil, err
+			}
+			var sum int64
+			for h, n := range hours {
+				if at := d.Add(time.Duration(h) * time.Hour); !at.Before(first) && at.Before(end) {
+					sum += n
+				}
+			}
+			switch name {
+			case ftpRollup:
+				total.FTP += sum
+			case sftpRollup:
+				total.SFTP += sum
+			default:
+				total.HTTP += sum
+			}
+		}
+	}
+	return total, nil
+}
+
+// transferHours collects transfers read in one pass, by account
+type transferHours map[int]map[time.Time]*hourRollup
+
+func (t transferHours) add(accountID int, at time.Time, in, out int64) {
+	hours := t[accountID]
+	if hours == nil {
+		hours = make(map[time.Time]*hourRollup)
+		t[accountID] = hours
+	}
+	hour := at.UTC().Truncate(time.Hour)
+	h := hours[hour]
+	if h == nil {
+		h = newHourRollup()
+		hours[hour] = h
+	}
+	h.Requests++
+	h.BytesIn += in
+	h.BytesOut += out
+}
+
+// ingestTransfers counts the FTP and SFTP transfers logged since the last
+// scan
+func (in *Ingester) ingestTransfers() {
+	users := in.accountUsers()
+	owner := func(path, user string) (int, bool) {
+		return in.transferOwner(path, user, users)
+	}
+
+	if err := in.ingestTransferLog(xferLogPath, ftpRollup, func(line []byte, t transferHours) {
+		parseXferLine(string(line), owner, t)
+	}); err != nil {
+		fmt.Printf("warning: failed to ingest %s: %v\n", xferLogPath, err)
+	}
+	for _, path := range authLogPaths {
+		if err := in.ingestTransferLog(path, sftpRollup, func(line []byte, t transferHours) {
+			in.parseSFTPLine(string(line), owner, t)
+		}); err != nil {
+			fmt.Printf("warning: failed to ingest %s: %v\n", path, err)
+		}
+	}
+}
+
+// ingestTransferLog reads one transfer log into the rollup name of each
+// account it mentions
+func (in *Ingester) ingestTransferLog(logPath, name string, parse func(line []byte, t transferHours)) error {
+	statePath := filepath.Join(in.stats.rollups.root, "ingest-"+filepath.Base(logPath)+".json")
+	transfers := make(transferHours)
+
+	return in.follow(logPath, statePath,
+		func(file string, offset int64) (int64, error) {
+			return readLines(file, offset, func(line []byte) { parse(line, transfers) })
+		},
+		func() error {
+			for accountID, hours := range transfers {
+				if err := in.stats.rollups.add(accountID, name, hours); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+}
+
+// accountUsers maps the accounts' system users to their IDs
+func (in *Ingester) accountUsers() map[string]int {
+	users := make(map[string]int)
+	accounts, err := in.stats.accounts.ListAccounts()
+	if err != nil {
+		return users
+	}
+	for _, id := range accounts {
+		if identity, err := in.stats.accounts.ReadIdentity(id); err == nil {
+			users[identity.Name] = id
+		}
+	}
+	return users
+}
+
+// transferOwner finds the account a transfer is billed to: the one whose
+// tree holds the file, else the one that logged in. Chrooted servers log
+// paths relative to the login's home, which say nothing about the account.
+// FTP logins of the form user@domain belong to the account hosting domain.
+func (in *Ingester) transferOwner(path, user string, users map[string]int) (int, bool) {
+	if m := accountPath.FindStringSubmatch(path); m != nil {
+		id, err := strconv.Atoi(m[1])
+		return id, err == nil
+	}
+	if id, ok := users[user]; ok {
+		return id, true
+	}
+	if i := strings.LastIndexByte(user, '@'); i >= 0 {
+		if id, _, ok := in.stats.siteFor(strings.ToLower(user[i+1:])); ok {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// parseXferLine counts one line of a standard xferlog:
+//
+//	Mon Oct 18 12:00:01 2026 1 192.0.2.1 1048576 /file.zip b _ o r alice ftp 0 * c
+//
+// The file name may contain spaces, so fields are taken from both ends.
+// Times are local; the file size is what was actually transferred.
+func parseXferLine(line string, owner func(path, user string) (int, bool), t transferHours) {
+	fields := strings.Fields(line)
+	n := len(fields)
+	if n < 18 {
+		return
+	}
+	at, err := time.ParseInLocation("Mon Jan 2 15:04:05 2006", strings.Join(fields[:5], " "), time.Local)
+	if err != nil {
+		return
+	}
+	size, err := strconv.ParseInt(fields[7], 10, 64)
+	if err != nil || size < 0 {
+		return
+	}
+	path := strings.Join(fields[8:n-9], " ")
+	accountID, ok := owner(path, fields[n-5])
+	if !ok {
+		return
+	}
+	switch fields[n-7] {
+	case "o":
+		t.add(accountID, at, 0, size)
+	case "i":
+		t.add(accountID, at, size, 0)
+	}
+}
+
+// parseSFTPLine counts a file sftp-server closed. Its log lines only name
+// the login when the session opens, so sessions are remembered by process
+// until they close; those open across a panel restart are billed by path.
+func (in *Ingester) parseSFTPLine(line string, owner func(path, user string) (int, bool), t transferHours) {
+	m := sftpLine.FindStringSubmatch(line)
+	if m == nil {
+		return
+	}
+	pid, msg := m[2], m[3]
+
+	if o := sftpOpened.FindStringSubmatch(msg); o != nil {
+		if len(in.sftpSessions) >= maxSFTPSessions {
+			// Closes went missing; forget them rather than grow forever
+			in.sftpSessions = make(map[string]string)
+		}
+		in.sftpSessions[pid] = o[1]
+		return
+	}
+	if sftpClosed.MatchString(msg) {
+		delete(in.sftpSessions, pid)
+		return
+	}
+	c := sftpClose.FindStringSubmatch(msg)
+	if c == nil {
+		return
+	}
+	at, ok := parseSyslogTime(m[1], time.Now())
+	if !ok {
+		return
+	}
+	read, _ := strconv.ParseInt(c[2], 10, 64)
+	written, _ := strconv.ParseInt(c[3], 10, 64)
+	accountID, ok := owner(c[1], in.sftpSessions[pid])
+	if !ok {
+		return
+	}
+	// sftp-server reads files to send them and writes what it receives
+	t.add(accountID, at, written, read)
+}
+
+// parseSyslogTime parses a syslog timestamp, either RFC 3339 or the
+// traditional local "Oct 18 12:00:01" without a year. A traditional time
+// later than tomorrow was logged last year.
+func parseSyslogTime(s string, now time.Time) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, true
+	}
+	t, err := time.ParseInLocation("Jan 2 15:04:05", strings.Join(strings.Fields(s), " "), time.Local)
+	if err != nil {
+		return time.Time{}, false
+	}
+	t = t.AddDate(now.Year(), 0, 0)
+	if t.After(now.Add(24 * time.Hour)) {
+		t = t.AddDate(-1, 0, 0)
+	}
+	return t, true
+}
@@ -0,0 +1,200 @@
+package stats
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/iSundram/OweHost/internal/storage/account"
+)
+
+// newTestOwner bills transfers to accounts by path, else to alice (1) and
+// bob (2) by login. No account hosts any domain.
+func newTestOwner(t *testing.T) func(path, user string) (int, bool) {
+	in := &Ingester{stats: &Service{accounts: account.NewStateManagerWithPath(t.TempDir())}}
+	return func(path, user string) (int, bool) {
+		return in.transferOwner(path, user, map[string]int{"alice": 1, "bob": 2})
+	}
+}
+
+// hourOf returns the rollup hour of a local time on 18 October 2026
+func hourOf(hour int) time.Time {
+	return time.Date(2026, 10, 18, hour, 0, 0, 0, time.Local).UTC().Truncate(time.Hour)
+}
+
+func TestTransferOwner(t *testing.T) {
+	owner := newTestOwner(t)
+	tests := []struct {
+		name, path, user string
+		id               int
+		ok               bool
+	}{
+		{"account tree", "/srv/accounts/a-7/sites/example.com/file.zip", "alice", 7, true},
+		{"account home", "/srv/accounts/a-7", "", 7, true},
+		{"login", "/file.zip", "bob", 2, true},
+		{"similar prefix", "/srv/accounts/a-7x/file.zip", "alice", 1, true},
+		{"unknown login", "/file.zip", "mallory", 0, false},
+		{"unknown domain login", "/file.zip", "ftp@example.com", 0, false},
+	}
+	for _, tt := range tests {
+		id, ok := owner(tt.path, tt.user)
+		if id != tt.id || ok != tt.ok {
+			t.Errorf("%s: expected %d %v, got %d %v", tt.name, tt.id, tt.ok, id, ok)
+		}
+	}
+}
+
+func TestParseXferLine(t *testing.T) {
+	owner := newTestOwner(t)
+	tests := []struct {
+		name    string
+		line    string
+		account int
+		hour    time.Time
+		in, out int64
+	}{
+		{"download", "Sun Oct 18 12:00:01 2026 1 192.0.2.1 1048576 /file.zip b _ o r alice ftp 0 * c", 1, hourOf(12), 0, 1048576},
+		{"upload", "Sun Oct 18 13:30:00 2026 2 192.0.2.1 2048 /upload.bin b _ i r bob ftp 0 * c", 2, hourOf(13), 2048, 0},
+		{"name with spaces", "Sun Oct 18 14:00:00 2026 1 192.0.2.1 512 /srv/accounts/a-3/my file (1).txt a _ o r alice ftp 0 * c", 3, hourOf(14), 0, 512},
+		{"padded day", "Sun Oct  4 09:00:00 2026 1 192.0.2.1 100 /a b _ o r alice ftp 0 * c", 1,
+			time.Date(2026, 10, 4, 9, 0, 0, 0, time.Local).UTC().Truncate(time.Hour), 0, 100},
+		{"incomplete transfer", "Sun Oct 18 12:00:01 2026 1 192.0.2.1 300 /file.zip b _ o r alice ftp 0 * i", 1, hourOf(12), 0, 300},
+	}
+	for _, tt := range tests {
+		hours := make(transferHours)
+		parseXferLine(tt.line, owner, hours)
+		h := hours[tt.account][tt.hour]
+		if len(hours) != 1 || h == nil {
+			t.Errorf("%s: expected a transfer for account %d at %v, got %v", tt.name, tt.account, tt.hour, hours)
+			continue
+		}
+		if h.Requests != 1 || h.BytesIn != tt.in || h.BytesOut != tt.out {
+			t.Errorf("%s: expected %d in, %d out, got %+v", tt.name, tt.in, tt.out, h)
+		}
+	}
+
+	skipped := []struct {
+		name string
+		line string
+	}{
+		{"too few fields", "Sun Oct 18 12:00:01 2026 1 192.0.2.1 1048576 /file.zip b _ o r alice"},
+		{"bad time", "Sun Oct 32 12:00:01 2026 1 192.0.2.1 1048576 /file.zip b _ o r alice ftp 0 * c"},
+		{"bad size", "Sun Oct 18 12:00:01 2026 1 192.0.2.1 lots /file.zip b _ o r alice ftp 0 * c"},
+		{"negative size", "Sun Oct 18 12:00:01 2026 1 192.0.2.1 -5 /file.zip b _ o r alice ftp 0 * c"},
+		{"delete", "Sun Oct 18 12:00:01 2026 1 192.0.2.1 0 /file.zip b _ d r alice ftp 0 * c"},
+		{"unknown login", "Sun Oct 18 12:00:01 2026 1 192.0.2.1 1048576 /file.zip b _ o r mallory ftp 0 * c"},
+	}
+	for _, tt := range skipped {
+		hours := make(transferHours)
+		parseXferLine(tt.line, owner, hours)
+		if len(hours) != 0 {
+			t.Errorf("%s: expected the line skipped, got %v", tt.name, hours)
+		}
+	}
+}
+
+func TestParseSFTPLine(t *testing.T) {
+	owner := newTestOwner(t)
+	in := &Ingester{sftpSessions: make(map[string]string)}
+	hours := make(transferHours)
+	at := "2026-10-18T12:00:01+00:00 web1"
+	noon := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	lines := []string{
+		at + " sshd[4241]: Accepted publickey for alice from 192.0.2.1 port 50000 ssh2",
+		at + " sftp-server[4242]: session opened for local user alice from [192.0.2.1]",
+		at + ` sftp-server[4242]: open "/home/alice/site.tar.gz" flags READ mode 0666`,
+		at + ` sftp-server[4242]: close "/home/alice/site.tar.gz" bytes read 1048576 written 0`,
+		at + ` sftp-server[4242]: close "/home/alice/notes.txt" bytes read 0 written 300`,
+		at + " sftp-server[4242]: session closed for local user alice from [192.0.2.1]",
+		// The process is gone, and so is the login
+		at + ` sftp-server[4242]: close "/home/alice/late.txt" bytes read 999 written 0`,
+		// A session opened before a restart is billed by path
+		at + ` internal-sftp[4300]: close "/srv/accounts/a-2/backup.zip" bytes read 4096 written 0`,
+		at + ` internal-sftp[4301]: close "/tmp/x" bytes read 5 written 0`,
+	}
+	for _, line := range lines {
+		in.parseSFTPLine(line, owner, hours)
+	}
+
+	alice := hours[1][noon]
+	if alice == nil || alice.Requests != 2 || alice.BytesOut != 1048576 || alice.BytesIn != 300 {
+		t.Errorf("Expected alice's two transfers, got %+v", alice)
+	}
+	bob := hours[2][noon]
+	if bob == nil || bob.Requests != 1 || bob.BytesOut != 4096 {
+		t.Errorf("Expected the transfer billed by path, got %+v", bob)
+	}
+	if len(hours) != 2 {
+		t.Errorf("Expected only alice and bob billed, got %v", hours)
+	}
+	if len(in.sftpSessions) != 0 {
+		t.Errorf("Expected closed sessions forgotten, got %v", in.sftpSessions)
+	}
+}
+
+func TestParseSyslogTime(t *testing.T) {
+	now := time.Date(2026, 1, 1, 10, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		name string
+		s    string
+		want time.Time
+		ok   bool
+	}{
+		{"RFC 3339", "2026-10-18T12:00:01.123456+02:00", time.Date(2026, 10, 18, 10, 0, 1, 123456000, time.UTC), true},
+		{"this year", "Jan  1 09:00:00", time.Date(2026, 1, 1, 9, 0, 0, 0, time.Local), true},
+		{"tomorrow", "Jan  2 09:00:00", time.Date(2026, 1, 2, 9, 0, 0, 0, time.Local), true},
+		{"last year", "Dec 31 23:00:00", time.Date(2025, 12, 31, 23, 0, 0, 0, time.Local), true},
+		{"not a time", "yesterday", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseSyslogTime(tt.s, now)
+		if ok != tt.ok || !got.Equal(tt.want) {
+			t.Errorf("%s: expected %v %v, got %v %v", tt.name, tt.want, tt.ok, got, ok)
+		}
+	}
+}
+
+func TestAccountBytesOut(t *testing.T) {
+	s := &Service{rollups: &rollupStore{root: filepath.Join(t.TempDir(), "stats")}}
+	day := time.Date(2026, 3, 14, 0, 0, 0, 0, time.UTC)
+
+	record := func(accountID int, name string, at time.Time, out int64) {
+		t.Helper()
+		hours := make(transferHours)
+		hours.add(accountID, at, 0, out)
+		if err := s.rollups.add(accountID, name, hours[accountID]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	record(1, "example.com", day.Add(9*time.Hour), 1000)
+	record(1, "example.com", day.Add(33*time.Hour), 2000)    // The next day
+	record(1, "removed.example", day.Add(10*time.Hour), 400) // A site since removed
+	record(1, ftpRollup, day.Add(10*time.Hour+30*time.Minute), 300)
+	record(1, sftpRollup, day.Add(23*time.Hour), 50)
+	record(1, "example.com", day.Add(-time.Hour), 7000)      // Before the period
+	record(1, "example.com", day.Add(48*time.Hour), 9000)    // After it
+	record(2, "other.example", day.Add(9*time.Hour), 100000) // Another account
+
+	tests := []struct {
+		name       string
+		accountID  int
+		start, end time.Time
+		want       AccountBytes
+	}{
+		{"period", 1, day, day.Add(48 * time.Hour), AccountBytes{HTTP: 3400, FTP: 300, SFTP: 50}},
+		{"start mid-hour", 1, day.Add(10*time.Hour + 45*time.Minute), day.Add(24 * time.Hour), AccountBytes{HTTP: 400, FTP: 300, SFTP: 50}},
+		{"end excludes its hour", 1, day, day.Add(10 * time.Hour), AccountBytes{HTTP: 1000}},
+		{"no traffic", 3, day, day.Add(48 * time.Hour), AccountBytes{}},
+	}
+	for _, tt := range tests {
+		got, err := s.AccountBytesOut(tt.accountID, tt.start, tt.end)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if *got != tt.want {
+			t.Errorf("%s: expected %+v, got %+v", tt.name, tt.want, *got)
+		}
+	}
+}
@@ -12,7 +12,16 @@ import (
 
 // Applier handles idempotent state application
 type Applier struct {
-	state *StateManager
+	state     *StateManager
+	bandwidth BandwidthEnforcer
+}
+
+// BandwidthEnforcer puts an account's over-quota action in front of its
+// sites. The web applier implements it.
+type BandwidthEnforcer interface {
+	// EnforceBandwidth applies action to the account's traffic, or lifts
+	// any restriction when action is ""
+	EnforceBandwidth(accountID int, action string) error
 }
 
 // NewApplier creates a new applier
@@ -27,6 +36,11 @@ func NewApplierWithState(state *StateManager) *Applier {
 	return &Applier{state: state}
 }
 
+// SetBandwidthEnforcer sets what restricts accounts over their bandwidth
+func (a *Applier) SetBandwidthEnforcer(e BandwidthEnforcer) {
+	a.bandwidth = e
+}
+
 // Apply idempotently applies the desired state to the filesystem
 // This is the core function that ensures filesystem state matches desired state
 func (a *Applier) Apply(accountID int, config *ApplyConfig) error {
@@ -136,6 +150,7 @@ func (a *Applier) applyOwnership(accountID, uid, gid int) error {
 		filepath.Join(basePath, "home"),
 		filepath.Join(basePath, "web"),
 		filepath.Join(basePath, "mail"),
+		filepath.Join(basePath, "repositories"),
 		filepath.Join(basePath, "tmp"),
 	}
 
@@ -217,9 +232,14 @@ func (a *Applier) setDiskQuota(uid, diskMB, inodes int) error {
 	return nil
 }
 
+// CgroupPath returns the cgroup v2 directory for an account
+func CgroupPath(accountID int) string {
+	return fmt.Sprintf("/sys/fs/cgroup/owehost/account-%d", accountID)
+}
+
 // applyCgroupLimits applies cgroup v2 limits
 func (a *Applier) applyCgroupLimits(accountID int, limits *ResourceLimits) error {
-	cgroupPath := fmt.Sprintf("/sys/fs/cgroup/owehost/account-%d", accountID)
+	cgroupPath := CgroupPath(accountID)
 
 	// Create cgroup directory
 	if err := os.MkdirAll(cgroupPath, 0755); err != nil {
@@ -257,6 +277,8 @@ func (a *Applier) Suspend(accountID int, reason, actor string) error {
 		Reason:      &reason,
 		SuspendedAt: &now,
 		SuspendedBy: &actor,
+
+		BandwidthRestriction: a.bandwidthRestriction(accountID),
 	}
 
 	if err := a.state.WriteStatus(accountID, status); err != nil {
@@ -278,6 +300,8 @@ func (a *Applier) Unsuspend(accountID int) error {
 	status := &AccountStatus{
 		Suspended: false,
 		Locked:    false,
+
+		BandwidthRestriction: a.bandwidthRestriction(accountID),
 	}
 
 	if err := a.state.WriteStatus(accountID, status); err != nil {
@@ -303,6 +327,8 @@ func (a *Applier) Terminate(accountID int, reason, actor string) error {
 		Reason:      &reason,
 		SuspendedAt: &now,
 		SuspendedBy: &actor,
+
+		BandwidthRestriction: a.bandwidthRestriction(accountID),
 	}
 
 	if err := a.state.WriteStatus(accountID, status); err != nil {
@@ -319,6 +345,48 @@ func (a *Applier) Terminate(accountID int, reason, actor string) error {
 	return a.state.WriteIdentity(accountID, identity)
 }
 
+// RestrictBandwidth applies an account's over-quota action: its sites are
+// throttled or replaced by a notice. Alerting needs nothing applied.
+func (a *Applier) RestrictBandwidth(accountID int, action string) error {
+	if action != BandwidthActionThrottle && action != BandwidthActionSuspend {
+		return ErrInvalidBandwidthAction
+	}
+	return a.setBandwidthRestriction(accountID, action)
+}
+
+// LiftBandwidthRestriction serves an account's sites normally again
+func (a *Applier) LiftBandwidthRestriction(accountID int) error {
+	return a.setBandwidthRestriction(accountID, "")
+}
+
+// setBandwidthRestriction enforces action before recording it, so
+// status.json never claims a restriction the web server doesn't apply
+func (a *Applier) setBandwidthRestriction(accountID int, action string) error {
+	status, err := a.state.ReadStatus(accountID)
+	if err != nil {
+		return err
+	}
+	if a.bandwidth != nil {
+		if err := a.bandwidth.EnforceBandwidth(accountID, action); err != nil {
+			return fmt.Errorf("failed to enforce bandwidth restriction: %w", err)
+		}
+	}
+	if status.BandwidthRestriction == action {
+		return nil
+	}
+	status.BandwidthRestriction = action
+	return a.state.WriteStatus(accountID, status)
+}
+
+// bandwidthRestriction returns the over-quota action in force
+func (a *Applier) bandwidthRestriction(accountID int) string {
+	status, err := a.state.ReadStatus(accountID)
+	if err != nil {
+		return ""
+	}
+	return status.BandwidthRestriction
+}
+
 // Delete completely removes an account from the filesystem
 func (a *Applier) Delete(accountID int) error {
 	// Read identity to get username for system user deletion
@@ -329,8 +397,13 @@ func (a *Applier) Delete(accountID int) error {
 		exec.Command("groupdel", identity.Name).Run()
 
 		// Remove cgroup
-		cgroupPath := fmt.Sprintf("/sys/fs/cgroup/owehost/account-%d", accountID)
-		os.RemoveAll(cgroupPath)
+		os.RemoveAll(CgroupPath(accountID))
+	}
+
+	if a.bandwidth != nil {
+		if err := a.bandwidth.EnforceBandwidth(accountID, ""); err != nil {
+			return fmt.Errorf("failed to lift bandwidth restriction: %w", err)
+		}
 	}
 
 	// Remove account directory
@@ -164,6 +164,31 @@ func (s *StateManager) WriteMetadata(accountID int, metadata *AccountMetadata) e
 	return s.atomicWrite(path, metadata)
 }
 
+// ReadBandwidth reads bandwidth.json. Accounts not measured yet have none.
+func (s *StateManager) ReadBandwidth(accountID int) (*BandwidthPeriod, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var period BandwidthPeriod
+	err := s.readJSON(filepath.Join(s.AccountPath(accountID), "bandwidth.json"), &period)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bandwidth.json: %w", err)
+	}
+	return &period, nil
+}
+
+// WriteBandwidth writes bandwidth.json atomically
+func (s *StateManager) WriteBandwidth(accountID int, period *BandwidthPeriod) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := filepath.Join(s.AccountPath(accountID), "bandwidth.json")
+	return s.atomicWrite(path, period)
+}
+
 // ReadAccount reads the complete account state
 func (s *StateManager) ReadAccount(accountID int) (*Account, error) {
 	identity, err := s.ReadIdentity(accountID)
@@ -201,6 +226,7 @@ func (s *StateManager) CreateAccountStructure(accountID int) error {
 		filepath.Join(base, "ssl"),
 		filepath.Join(base, "cron"),
 		filepath.Join(base, "runtime"),
+		filepath.Join(base, "repositories"),
 		filepath.Join(base, "backups"),
 		filepath.Join(base, "logs"),
 		filepath.Join(base, "tmp"),
@@ -260,6 +286,26 @@ func (s *StateManager) ListAccounts() ([]int, error) {
 	return accounts, nil
 }
 
+// FindByName returns the identity of the account with the given system name
+func (s *StateManager) FindByName(name string) (*AccountIdentity, error) {
+	accounts, err := s.ListAccounts()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range accounts {
+		identity, err := s.ReadIdentity(id)
+		if err != nil {
+			continue
+		}
+		if identity.Name == name {
+			return identity, nil
+		}
+	}
+
+	return nil, fmt.Errorf("account not found: %s", name)
+}
+
 // GetNextAccountID returns the next available account ID
 func (s *StateManager) GetNextAccountID() (int, error) {
 	accounts, err := s.ListAccounts()
@@ -37,6 +37,8 @@ type ResourceLimits struct {
 	FTPAccounts  int `json:"ftp_accounts"`
 	Bandwidth    int `json:"bandwidth_gb"` // Monthly bandwidth in GB
 	Inodes       int `json:"inodes"`
+	BillingDay      int    `json:"billing_day,omitempty"`      // Day of month the bandwidth period starts, 0 for the day the account was created
+	BandwidthAction string `json:"bandwidth_action,omitempty"` // What happens over the bandwidth limit, alert when empty
 }
 
 // AccountStatus represents status.json - current account state
@@ -48,6 +50,7 @@ type AccountStatus struct {
 	SuspendedBy  *string `json:"suspended_by,omitempty"`
 	LockedAt     *string `json:"locked_at,omitempty"`
 	LockedReason *string `json:"locked_reason,omitempty"`
+	BandwidthRestriction string `json:"bandwidth_restriction,omitempty"` // Over-quota action in force: throttle or suspend
 }
 
 // AccountMetadata represents additional account metadata
@@ -72,6 +75,24 @@ type AccountUsage struct {
 	LastUpdated   time.Time `json:"last_updated"`
 }
 
+// BandwidthPeriod represents bandwidth.json - what the account sent to
+// clients in its current billing period
+type BandwidthPeriod struct {
+	Start     string `json:"start"` // RFC3339
+	End       string `json:"end"`
+	HTTPBytes int64  `json:"http_bytes"`
+	FTPBytes  int64  `json:"ftp_bytes"`
+	SFTPBytes int64  `json:"sftp_bytes"`
+	Warned    int    `json:"warned_percent,omitempty"` // Highest usage warning sent this period
+	Exceeded  bool   `json:"exceeded,omitempty"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+// TotalBytes returns the period's traffic over all protocols
+func (p *BandwidthPeriod) TotalBytes() int64 {
+	return p.HTTPBytes + p.FTPBytes + p.SFTPBytes
+}
+
 // Account represents the complete account state
 type Account struct {
 	Identity *AccountIdentity  `json:"identity"`
@@ -97,6 +118,13 @@ const (
 	StatePending    = "pending"
 )
 
+// Over-quota bandwidth actions
+const (
+	BandwidthActionAlert    = "alert"    // Notify only
+	BandwidthActionThrottle = "throttle" // Slow down the account's sites
+	BandwidthActionSuspend  = "suspend"  // Serve a notice instead of the sites
+)
+
 // Plan presets
 var PlanLimits = map[string]ResourceLimits{
 	"starter": {
@@ -9,19 +9,21 @@ import (
 
 // Validation errors
 var (
-	ErrInvalidAccountID   = errors.New("invalid account ID: must be positive integer")
-	ErrInvalidAccountName = errors.New("invalid account name: must be 3-32 lowercase alphanumeric characters starting with a letter")
-	ErrInvalidEmail       = errors.New("invalid email address")
-	ErrInvalidPlan        = errors.New("invalid plan: must be one of starter, standard, premium, enterprise")
-	ErrInvalidState       = errors.New("invalid state: must be one of active, suspended, terminated, pending")
-	ErrInvalidUID         = errors.New("invalid UID: must be >= 1000")
-	ErrInvalidGID         = errors.New("invalid GID: must be >= 1000")
-	ErrInvalidNode        = errors.New("invalid node identifier")
-	ErrInvalidOwner       = errors.New("invalid owner identifier")
-	ErrInvalidDiskLimit   = errors.New("invalid disk limit: must be at least 100 MB or -1 for unlimited")
-	ErrInvalidCPULimit    = errors.New("invalid CPU limit: must be between 1 and 400 percent or -1 for unlimited")
-	ErrInvalidRAMLimit    = errors.New("invalid RAM limit: must be at least 128 MB or -1 for unlimited")
-	ErrInvalidDomainLimit = errors.New("invalid domain limit: must be at least 1 or -1 for unlimited")
+	ErrInvalidAccountID       = errors.New("invalid account ID: must be positive integer")
+	ErrInvalidAccountName     = errors.New("invalid account name: must be 3-32 lowercase alphanumeric characters starting with a letter")
+	ErrInvalidEmail           = errors.New("invalid email address")
+	ErrInvalidPlan            = errors.New("invalid plan: must be one of starter, standard, premium, enterprise")
+	ErrInvalidState           = errors.New("invalid state: must be one of active, suspended, terminated, pending")
+	ErrInvalidUID             = errors.New("invalid UID: must be >= 1000")
+	ErrInvalidGID             = errors.New("invalid GID: must be >= 1000")
+	ErrInvalidNode            = errors.New("invalid node identifier")
+	ErrInvalidOwner           = errors.New("invalid owner identifier")
+	ErrInvalidDiskLimit       = errors.New("invalid disk limit: must be at least 100 MB or -1 for unlimited")
+	ErrInvalidCPULimit        = errors.New("invalid CPU limit: must be between 1 and 400 percent or -1 for unlimited")
+	ErrInvalidRAMLimit        = errors.New("invalid RAM limit: must be at least 128 MB or -1 for unlimited")
+	ErrInvalidDomainLimit     = errors.New("invalid domain limit: must be at least 1 or -1 for unlimited")
+	ErrInvalidBillingDay      = errors.New("invalid billing day: must be between 1 and 28, or 0 for the day the account was created")
+	ErrInvalidBandwidthAction = errors.New("invalid bandwidth action: must be one of alert, throttle, suspend")
 )
 
 // Regex patterns for validation
@@ -29,13 +31,14 @@ var (
 	validAccountName = regexp.MustCompile(`^[a-z][a-z0-9_]{2,31}$`)
 	validEmail       = regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
 	validNodeID      = regexp.MustCompile(`^[a-z][a-z0-9-]{0,62}$`)
-	validOwnerID     = regexp.MustCompile(`^(admin|reseller-[0-9]+|partner-[0-9]+)$`)
+	validOwnerID     = regexp.MustCompile(`^(admin|reseller-[A-Za-z0-9_]+|partner-[0-9]+)$`)
 )
 
 // Valid values
 var (
-	validPlans  = map[string]bool{"starter": true, "standard": true, "premium": true, "enterprise": true}
-	validStates = map[string]bool{"active": true, "suspended": true, "terminated": true, "pending": true}
+	validPlans            = map[string]bool{"starter": true, "standard": true, "premium": true, "enterprise": true}
+	validStates           = map[string]bool{"active": true, "suspended": true, "terminated": true, "pending": true}
+	validBandwidthActions = map[string]bool{"": true, BandwidthActionAlert: true, BandwidthActionThrottle: true, BandwidthActionSuspend: true}
 )
 
 // ValidateIdentity validates account identity
@@ -104,6 +107,15 @@ func ValidateLimits(limits *ResourceLimits) error {
 		return ErrInvalidDomainLimit
 	}
 
+	// Later days don't exist in every month
+	if limits.BillingDay < 0 || limits.BillingDay > 28 {
+		return ErrInvalidBillingDay
+	}
+
+	if !validBandwidthActions[limits.BandwidthAction] {
+		return ErrInvalidBandwidthAction
+	}
+
 	return nil
 }
 
@@ -16,6 +16,12 @@ const (
 	EventAccountTerminate EventType = "account.terminate"
 	EventAccountDelete    EventType = "account.delete"
 
+	// Bandwidth events
+	EventBandwidthWarning  EventType = "account.bandwidth.warning"
+	EventBandwidthExceeded EventType = "account.bandwidth.exceeded"
+	EventBandwidthRestrict EventType = "account.bandwidth.restrict"
+	EventBandwidthRestore  EventType = "account.bandwidth.restore"
+
 	// Domain events
 	EventDomainAdd       EventType = "domain.add"
 	EventDomainRemove    EventType = "domain.remove"
@@ -54,11 +60,17 @@ const (
 	// Security events
 	EventLoginSuccess EventType = "security.login.success"
 	EventLoginFailed  EventType = "security.login.failed"
+	EventLoginUnlock  EventType = "security.login.unlock"
 	EventPasswordChange EventType = "security.password.change"
 	EventTwoFactorEnable EventType = "security.2fa.enable"
 	EventTwoFactorDisable EventType = "security.2fa.disable"
 	EventAPIKeyCreate EventType = "security.apikey.create"
 	EventAPIKeyRevoke EventType = "security.apikey.revoke"
+	EventSessionRevoke EventType = "security.session.revoke"
+	EventSigningKeyRotate EventType = "security.signing_key.rotate"
+	EventImpersonationStart EventType = "security.impersonation.start"
+	EventImpersonationEnd EventType = "security.impersonation.end"
+	EventImpersonationAction EventType = "security.impersonation.action"
 
 	// System events
 	EventConfigChange   EventType = "system.config.change"
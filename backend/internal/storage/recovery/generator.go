@@ -74,23 +74,21 @@ func (g *Generator) GenerateAll(opts GenerateOptions) (*GenerateResult, error) {
 		accounts = scanResult.Accounts
 	}
 
+	// Pools of all accounts are tested and reloaded once per PHP version
+	pools := g.webApplier.NewPoolBatch()
+
 	// Process each account
 	for _, scan := range accounts {
-		if err := g.generateForAccount(&scan, opts, result); err != nil {
+		if err := g.generateForAccount(&scan, opts, pools, result); err != nil {
 			result.Errors = append(result.Errors, fmt.Sprintf("account %d: %v", scan.ID, err))
 		}
 	}
 
 	// Reload services if not dry run
 	if !opts.DryRun {
-		if !opts.SkipNginx && result.NginxConfigs > 0 {
-			if err := g.reloadNginx(); err != nil {
-				result.Errors = append(result.Errors, fmt.Sprintf("nginx reload: %v", err))
-			}
-		}
-
+		// Each nginx config was tested and loaded as it was installed
 		if !opts.SkipPHPFpm && result.PHPFpmPools > 0 {
-			if err := g.reloadPHPFpm(); err != nil {
+			if err := pools.Commit(); err != nil {
 				result.Errors = append(result.Errors, fmt.Sprintf("php-fpm reload: %v", err))
 			}
 		}
@@ -100,7 +98,7 @@ func (g *Generator) GenerateAll(opts GenerateOptions) (*GenerateResult, error) {
 }
 
 // generateForAccount generates configs for a single account
-func (g *Generator) generateForAccount(scan *AccountScan, opts GenerateOptions, result *GenerateResult) error {
+func (g *Generator) generateForAccount(scan *AccountScan, opts GenerateOptions, pools *web.PoolBatch, result *GenerateResult) error {
 	// Generate system user
 	if !opts.SkipUsers && scan.Identity != nil {
 		if err := g.ensureSystemUser(scan.Identity, opts.DryRun); err != nil {
@@ -119,29 +117,36 @@ func (g *Generator) generateForAccount(scan *AccountScan, opts GenerateOptions,
 		}
 	}
 
-	// Generate nginx configs for each site
+	// Generate web server configs for each site
 	for _, site := range scan.Sites {
 		if !opts.SkipNginx {
 			if opts.DryRun {
 				result.NginxConfigs++
 			} else {
-				if err := g.webApplier.GenerateNginxConfig(scan.ID, &site); err != nil {
+				if err := g.webApplier.GenerateSiteConfig(scan.ID, &site); err != nil {
 					result.Errors = append(result.Errors, fmt.Sprintf("nginx %s: %v", site.Domain, err))
 				} else {
+					name := web.SiteConfigName(scan.ID, site.Domain)
 					result.NginxConfigs++
-					result.ConfigsPaths = append(result.ConfigsPaths,
-						fmt.Sprintf("/etc/nginx/sites-available/a-%d-%s.conf", scan.ID, site.Domain))
+					result.ConfigsPaths = append(result.ConfigsPaths, web.ConfigPath(web.ServerNginx, name))
+					if site.UsesApache() {
+						result.ConfigsPaths = append(result.ConfigsPaths, web.ConfigPath(web.ServerApache, name))
+					}
 				}
 			}
 		}
+	}
 
-		// Generate PHP-FPM pool if PHP runtime
-		if !opts.SkipPHPFpm && strings.HasPrefix(site.Runtime, "php-") {
-			if opts.DryRun {
-				result.PHPFpmPools++
-			} else {
-				if err := g.webApplier.GeneratePHPFpmPool(scan.ID, &site); err != nil {
-					result.Errors = append(result.Errors, fmt.Sprintf("phpfpm %s: %v", site.Domain, err))
+	// Generate one PHP-FPM pool per PHP version used by the account's sites
+	if !opts.SkipPHPFpm {
+		versions := make(map[string]bool)
+		for _, site := range scan.Sites {
+			if version := site.PHPVersion(); version != "" && !versions[version] {
+				versions[version] = true
+				if opts.DryRun {
+					result.PHPFpmPools++
+				} else if err := g.webApplier.EnsurePHPPool(scan.ID, version, pools); err != nil {
+					result.Errors = append(result.Errors, fmt.Sprintf("phpfpm %d/%s: %v", scan.ID, version, err))
 				} else {
 					result.PHPFpmPools++
 				}
@@ -210,30 +215,7 @@ func (g *Generator) ensureCgroup(accountID int, limits *account.ResourceLimits,
 	return nil
 }
 
-// reloadNginx reloads nginx configuration
-func (g *Generator) reloadNginx() error {
-	// Test config first
-	if err := exec.Command("nginx", "-t").Run(); err != nil {
-		return fmt.Errorf("config test failed: %w", err)
-	}
-	return exec.Command("nginx", "-s", "reload").Run()
-}
-
-// reloadPHPFpm reloads all PHP-FPM services
-func (g *Generator) reloadPHPFpm() error {
-	// Find and reload all PHP-FPM versions
-	versions := []string{"7.4", "8.0", "8.1", "8.2", "8.3"}
-	for _, version := range versions {
-		service := fmt.Sprintf("php%s-fpm", version)
-		// Check if service exists before reloading
-		if exec.Command("systemctl", "is-active", service).Run() == nil {
-			exec.Command("systemctl", "reload", service).Run()
-		}
-	}
-	return nil
-}
-
-// CleanupStaleConfigs removes nginx configs for non-existent sites
+// CleanupStaleConfigs removes nginx and Apache configs for non-existent sites
 func (g *Generator) CleanupStaleConfigs() ([]string, error) {
 	var removed []string
 
@@ -252,25 +234,31 @@ func (g *Generator) CleanupStaleConfigs() ([]string, error) {
 		}
 	}
 
-	// Scan nginx sites-available
-	entries, err := os.ReadDir("/etc/nginx/sites-available")
-	if err != nil {
-		return nil, err
-	}
-
-	for _, entry := range entries {
-		if !strings.HasPrefix(entry.Name(), "a-") {
-			continue // Not an OweHost config
+	// Scan sites-available of both servers. Apache is only installed when
+	// some site uses it.
+	for _, server := range []string{web.ServerNginx, web.ServerApache} {
+		dir := web.ConfigDir(server)
+		entries, err := os.ReadDir(dir)
+		if os.IsNotExist(err) && server == web.ServerApache {
+			continue
+		}
+		if err != nil {
+			return removed, err
 		}
 
-		if !validConfigs[entry.Name()] {
-			// Config is stale, remove it
-			availPath := filepath.Join("/etc/nginx/sites-available", entry.Name())
-			enabledPath := filepath.Join("/etc/nginx/sites-enabled", entry.Name())
+		for _, entry := range entries {
+			if !strings.HasPrefix(entry.Name(), "a-") {
+				continue // Not an OweHost config
+			}
 
-			os.Remove(enabledPath)
-			os.Remove(availPath)
-			removed = append(removed, entry.Name())
+			if !validConfigs[entry.Name()] {
+				// Config is stale, remove it
+				name := strings.TrimSuffix(entry.Name(), ".conf")
+				if err := g.webApplier.RemoveConfig(server, name); err != nil {
+					return removed, fmt.Errorf("failed to remove %s: %w", entry.Name(), err)
+				}
+				removed = append(removed, filepath.Join(dir, entry.Name()))
+			}
 		}
 	}
 
@@ -0,0 +1,62 @@
+// Package web provides filesystem-based web/site state management
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"time"
+)
+
+// AccessLogFormat is the nginx log_format site access logs are written in
+const AccessLogFormat = "owehost_json"
+
+// nginxLogFormatPath declares AccessLogFormat in the http context
+const nginxLogFormatPath = "/etc/nginx/conf.d/owehost-log.conf"
+
+// nginxLogFormat writes one JSON object per request. escape=json keeps
+// visitor-controlled values such as the user agent from breaking a line.
+const nginxLogFormat = `# Generated by OweHost - changes will be overwritten
+log_format ` + AccessLogFormat + ` escape=json '{"time":"$time_iso8601","addr":"$remote_addr","host":"$host",'
+    '"method":"$request_method","uri":"$request_uri","status":$status,'
+    '"bytes_in":$request_length,"bytes_out":$bytes_sent,"duration":$request_time,'
+    '"referer":"$http_referer","agent":"$http_user_agent"}';
+`
+
+// AccessEntry is one request from a site's access log
+type AccessEntry struct {
+	Time     time.Time `json:"time"`
+	Addr     string    `json:"addr"`
+	Host     string    `json:"host"`
+	Method   string    `json:"method"`
+	URI      string    `json:"uri"`
+	Status   int       `json:"status"`
+	BytesIn  int64     `json:"bytes_in"`
+	BytesOut int64     `json:"bytes_out"`
+	Duration float64   `json:"duration"` // Seconds
+	Referer  string    `json:"referer"`
+	Agent    string    `json:"agent"`
+}
+
+// AccessLogPath returns the access log nginx writes for a site
+func (s *StateManager) AccessLogPath(accountID int, domain string) string {
+	return filepath.Join(s.SitePath(accountID, domain), "logs", "access.log")
+}
+
+// ensureLogFormat declares the access log format site configs refer to
+func ensureLogFormat() error {
+	return writeIfChanged(nginxLogFormatPath, []byte(nginxLogFormat))
+}
+
+// ParseAccessEntry parses one line of a site's access log. Lines nginx
+// wrote before the site switched to the JSON format are rejected.
+func ParseAccessEntry(line []byte) (*AccessEntry, error) {
+	var entry AccessEntry
+	if err := json.Unmarshal(line, &entry); err != nil {
+		return nil, err
+	}
+	if entry.Time.IsZero() || entry.Status == 0 {
+		return nil, errors.New("not an access log entry")
+	}
+	return &entry, nil
+}
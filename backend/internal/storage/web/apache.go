@@ -0,0 +1,194 @@
+// Package web provides filesystem-based web/site state management
+package web
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"text/template"
+)
+
+// Site backends. nginx always terminates HTTP and TLS; with the apache and
+// hybrid backends it proxies to Apache, which honours .htaccess files.
+const (
+	BackendNginx  = "nginx"  // nginx serves the site directly
+	BackendApache = "apache" // nginx passes every request to Apache
+	BackendHybrid = "hybrid" // nginx serves static assets, Apache the rest
+)
+
+// Apache only listens on loopback behind nginx, so sites on different
+// backends can share a server
+const apacheBackendAddr = "127.0.0.1:8080"
+
+const apachePortsPath = "/etc/apache2/ports.conf"
+
+const apachePortsConf = `# Generated by OweHost - changes will be overwritten
+# Apache serves sites behind nginx, which owns ports 80 and 443
+Listen ` + apacheBackendAddr + `
+`
+
+// Modules the generated vhosts rely on. mpm_event replaces prefork, which
+// mod_php needs; PHP runs in the account's PHP-FPM pool instead.
+var apacheModules = []string{"mpm_event", "proxy", "proxy_fcgi", "remoteip", "setenvif", "rewrite", "headers"}
+
+// apacheQuote renders s as a double-quoted Apache argument
+func apacheQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
+
+// ensureApacheSupport moves Apache onto its loopback port and enables the
+// modules the vhosts use
+func ensureApacheSupport() error {
+	if err := writeIfChanged(apachePortsPath, []byte(apachePortsConf)); err != nil {
+		return err
+	}
+
+	var missing []string
+	for _, module := range apacheModules {
+		if _, err := os.Stat("/etc/apache2/mods-enabled/" + module + ".load"); err != nil {
+			missing = append(missing, module)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	exec.Command("a2dismod", "-q", "mpm_prefork", "mpm_worker").Run()
+	if out, err := exec.Command("a2enmod", append([]string{"-q"}, missing...)...).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to enable apache modules: %s", strings.TrimSpace(string(out)))
+	}
+
+	// A graceful reload cannot switch MPMs
+	if missing[0] == "mpm_event" {
+		if out, err := exec.Command("systemctl", "restart", "apache2").CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to restart apache: %s", strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
+
+// renderApacheConfig renders the Apache vhost of a site on the apache or
+// hybrid backend
+func (a *Applier) renderApacheConfig(accountID int, site *SiteDescriptor) (string, error) {
+	sitePath := a.state.SitePath(accountID, site.Domain)
+	docPath := site.DocumentPath(sitePath)
+
+	aliases := append([]string{"www." + site.Domain}, site.Aliases...)
+
+	data := struct {
+		Domain       string
+		AccountID    int
+		Listen       string
+		ServerAlias  string
+		DocumentPath string
+		ErrorLog     string
+		PHPHandler   string
+		PHPValue     string
+	}{
+		Domain:       site.Domain,
+		AccountID:    accountID,
+		Listen:       apacheBackendAddr,
+		ServerAlias:  strings.Join(aliases, " "),
+		DocumentPath: apacheQuote(docPath),
+		ErrorLog:     apacheQuote(sitePath + "/logs/apache-error.log"),
+	}
+
+	if version := site.PHPVersion(); version != "" {
+		data.PHPHandler = apacheQuote("proxy:unix:" + PHPSocketPath(accountID, version) + "|fcgi://localhost")
+		if value := phpValue(site.PHPSettings); value != "" {
+			// ap_expr strings turn \n back into the newlines PHP-FPM splits on
+			data.PHPValue = `"` + value + `"`
+		}
+	}
+
+	var buf strings.Builder
+	if err := a.apacheTemplate.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// GenerateSiteConfig renders and installs all web server configuration of a
+// site: its nginx config and, on the apache and hybrid backends, the Apache
+// vhost nginx proxies to. The vhost goes live first so nginx never points at
+// a missing backend, and is removed once a site leaves Apache.
+func (a *Applier) GenerateSiteConfig(accountID int, site *SiteDescriptor) error {
+	name := SiteConfigName(accountID, site.Domain)
+
+	if site.UsesApache() {
+		config, err := a.renderApacheConfig(accountID, site)
+		if err != nil {
+			return err
+		}
+		if err := ensureApacheSupport(); err != nil {
+			return err
+		}
+		if err := a.InstallConfig(ServerApache, name, config); err != nil {
+			return fmt.Errorf("failed to apply apache config: %w", err)
+		}
+	}
+
+	if err := a.GenerateNginxConfig(accountID, site); err != nil {
+		return err
+	}
+
+	if !site.UsesApache() {
+		if _, err := os.Stat(ConfigPath(ServerApache, name)); err == nil {
+			return a.RemoveConfig(ServerApache, name)
+		}
+	}
+	return nil
+}
+
+// newApacheTemplate parses the vhost template
+func newApacheTemplate() *template.Template {
+	return template.Must(template.New("apache").Parse(apacheVHostTemplate))
+}
+
+// Apache vhost template. Requests arrive from nginx, which has already
+// terminated TLS and logged them.
+const apacheVHostTemplate = `# Generated by OweHost for {{ .Domain }}
+# Account: {{ .AccountID }}
+
+<VirtualHost {{ .Listen }}>
+    ServerName {{ .Domain }}
+    ServerAlias {{ .ServerAlias }}
+    DocumentRoot {{ .DocumentPath }}
+
+    ErrorLog {{ .ErrorLog }}
+
+    # Client address and scheme as seen by nginx
+    RemoteIPHeader X-Real-IP
+    RemoteIPInternalProxy 127.0.0.1
+    SetEnvIf X-Forwarded-Proto "^https$" HTTPS=on
+
+    <Directory {{ .DocumentPath }}>
+        Options -Indexes +SymLinksIfOwnerMatch
+        AllowOverride All
+        Require all granted
+        DirectoryIndex index.php index.html index.htm
+    </Directory>
+{{- if .PHPHandler }}
+
+    <FilesMatch "\.php$">
+        <If "-f %{REQUEST_FILENAME}">
+            SetHandler {{ .PHPHandler }}
+        </If>
+    </FilesMatch>
+{{- if .PHPValue }}
+    ProxyFCGISetEnvIf "true" PHP_VALUE {{ .PHPValue }}
+{{- end }}
+{{- end }}
+
+    <DirectoryMatch "/\.(git|svn)">
+        Require all denied
+    </DirectoryMatch>
+
+    <FilesMatch "^\.(env|ht)">
+        Require all denied
+    </FilesMatch>
+</VirtualHost>
+`
@@ -6,6 +6,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 	"text/template"
 
@@ -16,6 +17,7 @@ import (
 type Applier struct {
 	state          *StateManager
 	nginxTemplate  *template.Template
+	apacheTemplate *template.Template
 	phpFpmTemplate *template.Template
 }
 
@@ -31,6 +33,7 @@ func NewApplier() *Applier {
 // initTemplates initializes configuration templates
 func (a *Applier) initTemplates() {
 	a.nginxTemplate = template.Must(template.New("nginx").Parse(nginxConfigTemplate))
+	a.apacheTemplate = newApacheTemplate()
 	a.phpFpmTemplate = template.Must(template.New("phpfpm").Parse(phpFpmPoolTemplate))
 }
 
@@ -46,25 +49,34 @@ func (a *Applier) ApplySite(accountID int, site *SiteDescriptor) error {
 		return fmt.Errorf("failed to write site: %w", err)
 	}
 
-	// Step 3: Create default index if needed
+	// Step 3: Create default index if needed. Release-based sites get their
+	// content from the first deploy instead.
 	docRoot := site.DocumentRoot
 	if docRoot == "" {
 		docRoot = "public"
 	}
-	if err := a.state.CreateDefaultIndex(accountID, site.Domain, docRoot); err != nil {
-		fmt.Printf("warning: failed to create default index: %v\n", err)
+	if !site.ReleasesEnabled() {
+		if err := a.state.CreateDefaultIndex(accountID, site.Domain, docRoot); err != nil {
+			fmt.Printf("warning: failed to create default index: %v\n", err)
+		}
 	}
 
-	// Step 4: Generate and apply web server config
-	if err := a.GenerateNginxConfig(accountID, site); err != nil {
-		return fmt.Errorf("failed to generate nginx config: %w", err)
+	// Step 4: Make sure the account has a PHP-FPM pool for the site's
+	// version before a web server config refers to its socket
+	if version := site.PHPVersion(); version != "" {
+		batch := a.NewPoolBatch()
+		err := a.EnsurePHPPool(accountID, version, batch)
+		if err == nil {
+			err = batch.Commit()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to apply PHP-FPM pool: %w", err)
+		}
 	}
 
-	// Step 5: Generate PHP-FPM pool if needed
-	if strings.HasPrefix(site.Runtime, "php-") {
-		if err := a.GeneratePHPFpmPool(accountID, site); err != nil {
-			return fmt.Errorf("failed to generate PHP-FPM pool: %w", err)
-		}
+	// Step 5: Generate and apply web server configs
+	if err := a.GenerateSiteConfig(accountID, site); err != nil {
+		return fmt.Errorf("failed to generate web server config: %w", err)
 	}
 
 	// Step 6: Set up SSL if enabled
@@ -77,123 +89,180 @@ func (a *Applier) ApplySite(accountID int, site *SiteDescriptor) error {
 	return nil
 }
 
+// ReconfigureSite saves changed settings of an existing site and
+// regenerates its web server configs. If a web server rejects the new
+// configuration, the previous site.json is restored so the stored settings
+// keep matching what is live.
+func (a *Applier) ReconfigureSite(accountID int, site *SiteDescriptor) error {
+	if err := ValidateSite(site); err != nil {
+		return fmt.Errorf("validation failed: %w", err)
+	}
+
+	previous, err := a.state.ReadSite(accountID, site.Domain)
+	if err != nil {
+		return err
+	}
+
+	if err := a.state.WriteSite(accountID, site); err != nil {
+		return fmt.Errorf("failed to write site: %w", err)
+	}
+	if err := a.GenerateSiteConfig(accountID, site); err != nil {
+		a.state.WriteSite(accountID, previous)
+		return fmt.Errorf("failed to generate web server config: %w", err)
+	}
+	return nil
+}
+
 // DeleteSite removes a site and its configuration
 func (a *Applier) DeleteSite(accountID int, domain string) error {
-	// Remove nginx config
-	nginxPath := fmt.Sprintf("/etc/nginx/sites-enabled/a-%d-%s.conf", accountID, domain)
-	os.Remove(nginxPath)
-	os.Remove(strings.Replace(nginxPath, "sites-enabled", "sites-available", 1))
+	// Remove web server configs, nginx first so it stops proxying to Apache
+	name := SiteConfigName(accountID, domain)
+	if err := a.RemoveConfig(ServerNginx, name); err != nil {
+		return fmt.Errorf("failed to remove nginx config: %w", err)
+	}
+	if _, err := os.Stat(ConfigPath(ServerApache, name)); err == nil {
+		if err := a.RemoveConfig(ServerApache, name); err != nil {
+			return fmt.Errorf("failed to remove apache config: %w", err)
+		}
+	}
+	if err := removeWAFRules(accountID, domain); err != nil {
+		return fmt.Errorf("failed to remove firewall rules: %w", err)
+	}
 
-	// Remove PHP-FPM pool if exists
-	phpFpmPath := fmt.Sprintf("/etc/php/8.2/fpm/pool.d/a-%d-%s.conf", accountID, domain)
-	os.Remove(phpFpmPath)
+	version := ""
+	if site, err := a.state.ReadSite(accountID, domain); err == nil {
+		version = site.PHPVersion()
+	}
 
 	// Remove site directory
-	return a.state.DeleteSite(accountID, domain)
+	if err := a.state.DeleteSite(accountID, domain); err != nil {
+		return err
+	}
+
+	// Drop the account's PHP-FPM pool once no other site needs it
+	if version != "" {
+		batch := a.NewPoolBatch()
+		if err := a.RemoveUnusedPHPPool(accountID, version, batch); err != nil {
+			return err
+		}
+		return batch.Commit()
+	}
+	return nil
 }
 
-// GenerateNginxConfig generates and writes nginx configuration
+// SiteConfigName returns the name of a site's config files, the same for
+// every web server
+func SiteConfigName(accountID int, domain string) string {
+	return fmt.Sprintf("a-%d-%s", accountID, domain)
+}
+
+// GenerateNginxConfig renders a site's nginx configuration and installs it
+// through InstallConfig, so a config nginx rejects never goes live
 func (a *Applier) GenerateNginxConfig(accountID int, site *SiteDescriptor) error {
 	config, err := a.renderNginxConfig(accountID, site)
 	if err != nil {
 		return err
 	}
 
-	// Write to sites-available
-	availablePath := fmt.Sprintf("/etc/nginx/sites-available/a-%d-%s.conf", accountID, site.Domain)
-	if err := os.MkdirAll(filepath.Dir(availablePath), 0755); err != nil {
-		return err
+	if err := ensureLogFormat(); err != nil {
+		return fmt.Errorf("failed to write log format: %w", err)
 	}
-	if err := os.WriteFile(availablePath, []byte(config), 0644); err != nil {
-		return err
+	if site.IsProxied() || site.UsesApache() {
+		if err := ensureProxySupport(); err != nil {
+			return fmt.Errorf("failed to write proxy support files: %w", err)
+		}
+	}
+	if site.CacheEnabled() {
+		if err := ensureCacheZones(accountID); err != nil {
+			return fmt.Errorf("failed to write cache zones: %w", err)
+		}
+	}
+	if site.WAFEnabled() {
+		if err := ensureWAFRules(accountID, site); err != nil {
+			return fmt.Errorf("failed to write firewall rules: %w", err)
+		}
 	}
 
-	// Symlink to sites-enabled
-	enabledPath := fmt.Sprintf("/etc/nginx/sites-enabled/a-%d-%s.conf", accountID, site.Domain)
-	os.Remove(enabledPath)
-	return os.Symlink(availablePath, enabledPath)
+	if err := a.InstallConfig(ServerNginx, SiteConfigName(accountID, site.Domain), config); err != nil {
+		return err
+	}
+	if !site.WAFEnabled() {
+		return removeWAFRules(accountID, site.Domain)
+	}
+	return nil
 }
 
 // renderNginxConfig renders the nginx configuration
-func (a *Applier) renderNginxConfig(accountID int, site *SiteDescriptor) (string, error) {
-	data := struct {
-		*SiteDescriptor
-		AccountID    int
-		AccountPath  string
-		SitePath     string
-		DocumentPath string
-		PHPSocket    string
-		ServerNames  string
-	}{
-		SiteDescriptor: site,
+func (a *Applier) renderNginxConfig(accountID int, desc *SiteDescriptor) (string, error) {
+	site := &nginxSite{
+		SiteDescriptor: desc,
 		AccountID:      accountID,
 		AccountPath:    fmt.Sprintf("%s/%s%d", account.BaseAccountPath, account.AccountPrefix, accountID),
-		SitePath:       a.state.SitePath(accountID, site.Domain),
+		SitePath:       a.state.SitePath(accountID, desc.Domain),
+		RedirectList:   siteRedirects(desc.Redirects),
+		ErrorPageList:  siteErrorPages(desc.ErrorPages),
+		BandwidthConf:  bandwidthInclude(accountID),
 	}
 
-	docRoot := site.DocumentRoot
-	if docRoot == "" {
-		docRoot = "public"
-	}
-	data.DocumentPath = filepath.Join(data.SitePath, docRoot)
+	site.DocumentPath = nginxQuote(desc.DocumentPath(site.SitePath))
 
-	if site.PHPSettings != nil {
-		data.PHPSocket = fmt.Sprintf("/run/php/php%s-fpm-a%d.sock", site.PHPSettings.Version, accountID)
-	} else if strings.HasPrefix(site.Runtime, "php-") {
-		version := strings.TrimPrefix(site.Runtime, "php-")
-		data.PHPSocket = fmt.Sprintf("/run/php/php%s-fpm-a%d.sock", version, accountID)
+	switch {
+	case desc.IsProxied():
+		addr, err := upstreamAddr(site.AccountPath, desc)
+		if err != nil {
+			return "", err
+		}
+		site.Upstream = upstreamName(accountID, desc.Domain)
+		site.UpstreamAddr = addr
+		site.StaticBypass = addr != ""
+		site.Maintenance = siteMaintenanceCodes(desc.ErrorPages)
+	case desc.UsesApache():
+		// Apache runs PHP itself, so nginx only forwards. In hybrid mode
+		// nginx still serves the assets it finds on disk.
+		site.Upstream = upstreamName(accountID, desc.Domain)
+		site.UpstreamAddr = apacheBackendAddr
+		site.StaticBypass = desc.Backend() == BackendHybrid
+		site.Maintenance = siteMaintenanceCodes(desc.ErrorPages)
+	default:
+		if version := desc.PHPVersion(); version != "" {
+			site.PHPSocket = PHPSocketPath(accountID, version)
+			site.PHPValue = phpValue(desc.PHPSettings)
+		}
 	}
 
-	serverNames := []string{site.Domain, "www." + site.Domain}
-	serverNames = append(serverNames, site.Aliases...)
-	data.ServerNames = strings.Join(serverNames, " ")
-
-	var buf strings.Builder
-	if err := a.nginxTemplate.Execute(&buf, data); err != nil {
-		return "", err
+	if desc.CacheEnabled() {
+		if site.PHPSocket != "" {
+			site.applyCache(accountID, "fastcgi", desc.Cache)
+		} else if site.UpstreamAddr != "" {
+			site.applyCache(accountID, "proxy", desc.Cache)
+		}
 	}
-
-	return buf.String(), nil
-}
-
-// GeneratePHPFpmPool generates PHP-FPM pool configuration
-func (a *Applier) GeneratePHPFpmPool(accountID int, site *SiteDescriptor) error {
-	version := "8.2"
-	if site.PHPSettings != nil && site.PHPSettings.Version != "" {
-		version = site.PHPSettings.Version
-	} else if strings.HasPrefix(site.Runtime, "php-") {
-		version = strings.TrimPrefix(site.Runtime, "php-")
+	site.applyRateLimits(accountID, desc.RateLimits)
+	site.applyAccess(desc)
+	if desc.WAFEnabled() {
+		site.WAFRules = wafRulesPath(accountID, desc.Domain)
 	}
 
+	serverNames := []string{desc.Domain, "www." + desc.Domain}
+	serverNames = append(serverNames, desc.Aliases...)
+	site.ServerNames = strings.Join(serverNames, " ")
+
 	data := struct {
-		AccountID   int
-		Domain      string
-		Version     string
-		PHPSettings *PHPSettings
-		AccountPath string
+		*nginxSite
+		HTTP  nginxServer
+		HTTPS nginxServer
 	}{
-		AccountID:   accountID,
-		Domain:      site.Domain,
-		Version:     version,
-		PHPSettings: site.PHPSettings,
-		AccountPath: fmt.Sprintf("%s/%s%d", account.BaseAccountPath, account.AccountPrefix, accountID),
-	}
-
-	if data.PHPSettings == nil {
-		data.PHPSettings = DefaultPHPSettings(version)
+		nginxSite: site,
+		HTTP:      nginxServer{site, siteHeaders(baseHTTPHeaders, desc.Headers)},
+		HTTPS:     nginxServer{site, siteHeaders(baseHTTPSHeaders, desc.Headers)},
 	}
 
 	var buf strings.Builder
-	if err := a.phpFpmTemplate.Execute(&buf, data); err != nil {
-		return err
-	}
-
-	poolPath := fmt.Sprintf("/etc/php/%s/fpm/pool.d/a-%d-%s.conf", version, accountID, site.Domain)
-	if err := os.MkdirAll(filepath.Dir(poolPath), 0755); err != nil {
-		return err
+	if err := a.nginxTemplate.Execute(&buf, data); err != nil {
+		return "", err
 	}
 
-	return os.WriteFile(poolPath, []byte(buf.String()), 0644)
+	return buf.String(), nil
 }
 
 // ensureSSL ensures SSL is set up for the domain
@@ -237,12 +306,9 @@ func (a *Applier) createSelfSignedCert(accountID int, domain string) error {
 	return a.state.atomicWrite(filepath.Join(sslPath, "meta.json"), meta)
 }
 
-// ReloadNginx reloads nginx configuration
+// ReloadNginx tests and reloads nginx configuration
 func (a *Applier) ReloadNginx() error {
-	if err := exec.Command("nginx", "-t").Run(); err != nil {
-		return fmt.Errorf("nginx config test failed: %w", err)
-	}
-	return exec.Command("nginx", "-s", "reload").Run()
+	return a.ReloadServer(ServerNginx)
 }
 
 // ReloadPHPFpm reloads PHP-FPM
@@ -251,9 +317,32 @@ func (a *Applier) ReloadPHPFpm(version string) error {
 	return exec.Command("systemctl", "reload", serviceName).Run()
 }
 
-// Nginx configuration template
+// Nginx configuration template. Site-provided values reach it already
+// quoted (see nginx.go), so they cannot break out of their directive.
 const nginxConfigTemplate = `# Generated by OweHost for {{ .Domain }}
 # Account: {{ .AccountID }}
+{{- range .Maps }}
+{{- $value := .Value }}
+
+map {{ .Source }} ${{ .Name }} {
+    default "";
+{{- range .Patterns }}
+    {{ . }} {{ $value }};
+{{- end }}
+}
+{{- end }}
+{{- if .LimitZones }}
+{{ range .LimitZones }}
+limit_{{ .Kind }}_zone {{ .Key }} zone={{ .Zone }}:1m{{ if .Rate }} rate={{ .Rate }}r/m{{ end }};
+{{- end }}
+{{- end }}
+{{- if .UpstreamAddr }}
+
+upstream {{ .Upstream }} {
+    server {{ .UpstreamAddr }};
+    keepalive 16;
+}
+{{- end }}
 
 server {
     listen 80;
@@ -263,45 +352,18 @@ server {
     root {{ .DocumentPath }};
     index index.php index.html index.htm;
 
-    access_log {{ .SitePath }}/logs/access.log;
+    access_log {{ .SitePath }}/logs/access.log ` + AccessLogFormat + `;
     error_log {{ .SitePath }}/logs/error.log;
+{{- template "headers" .HTTP }}
+{{- if and .SSL .SSLRedirect }}
 
-    add_header X-Frame-Options "SAMEORIGIN" always;
-    add_header X-Content-Type-Options "nosniff" always;
-    add_header X-XSS-Protection "1; mode=block" always;
-
-    {{ if .SSL }}{{ if .SSLRedirect }}
     return 301 https://$server_name$request_uri;
-    {{ end }}{{ end }}
-
-    location / {
-        try_files $uri $uri/ /index.php?$query_string;
-    }
-
-    {{ if .PHPSocket }}
-    location ~ \.php$ {
-        fastcgi_pass unix:{{ .PHPSocket }};
-        fastcgi_index index.php;
-        fastcgi_param SCRIPT_FILENAME $realpath_root$fastcgi_script_name;
-        include fastcgi_params;
-    }
-    {{ end }}
-
-    location ~ /\.(ht|git|svn) {
-        deny all;
-    }
-
-    location ~ /\.(env|json|lock|md)$ {
-        deny all;
-    }
-
-    location ~* \.(jpg|jpeg|png|gif|ico|css|js|woff2?)$ {
-        expires 30d;
-        add_header Cache-Control "public, immutable";
-    }
+{{- else }}
+{{- template "locations" .HTTP }}
+{{- end }}
 }
+{{- if .SSL }}
 
-{{ if .SSL }}
 server {
     listen 443 ssl http2;
     listen [::]:443 ssl http2;
@@ -316,70 +378,267 @@ server {
     ssl_ciphers ECDHE-ECDSA-AES128-GCM-SHA256:ECDHE-RSA-AES128-GCM-SHA256;
     ssl_prefer_server_ciphers off;
 
-    access_log {{ .SitePath }}/logs/access.log;
+    access_log {{ .SitePath }}/logs/access.log ` + AccessLogFormat + `;
     error_log {{ .SitePath }}/logs/error.log;
+{{- template "headers" .HTTPS }}
+{{- template "locations" .HTTPS }}
+}
+{{- end }}
+{{ define "headers" }}
+{{- if .Headers }}{{ "\n" }}{{ end }}
+{{- range .Headers }}
+    add_header {{ .Name }} {{ .Value }} always;
+{{- end }}
+{{- end }}
+
+{{- define "locations" }}
+
+    include {{ .BandwidthConf }};
+{{- if .WAFRules }}
+
+    modsecurity on;
+    modsecurity_rules_file {{ .WAFRules }};
+{{- end }}
+{{- if .SiteAccess }}{{ "\n" }}{{ end }}
+{{- range .SiteAccess }}
+    {{ . }};
+{{- end }}
+{{- if or .LimitReq .LimitConn }}{{ "\n" }}{{ end }}
+{{- range .LimitReq }}
+    limit_req {{ . }};
+{{- end }}
+{{- if .LimitReq }}
+    limit_req_status 429;
+{{- end }}
+{{- range .LimitConn }}
+    limit_conn {{ . }};
+{{- end }}
+{{- if .LimitConn }}
+    limit_conn_status 429;
+{{- end }}
+{{- if or .ErrorPageList .Maintenance }}{{ "\n" }}{{ end }}
+{{- range .ErrorPageList }}
+    error_page {{ .Code }} {{ .Page }};
+{{- end }}
+{{- if .Maintenance }}
+    error_page {{ .Maintenance }} /__owehost/maintenance.html;
+{{- end }}
+{{- range .RedirectList }}
+
+    location {{ .Match }} {
+        return {{ .Code }} {{ .Target }};
+    }
+{{- end }}
+{{- if .Upstream }}
+{{- if .UpstreamAddr }}
 
-    add_header X-Frame-Options "SAMEORIGIN" always;
-    add_header X-Content-Type-Options "nosniff" always;
-    add_header Strict-Transport-Security "max-age=31536000; includeSubDomains" always;
+    location / {
+        {{- template "proxy" . }}
+    }
 
+    location @app {
+        {{- template "proxy" . }}
+    }
+{{- else }}
+
+    # No app is attached to this site yet
     location / {
-        try_files $uri $uri/ /index.php?$query_string;
+        return 503;
     }
+{{- end }}
 
-    {{ if .PHPSocket }}
-    location ~ \.php$ {
-        fastcgi_pass unix:{{ .PHPSocket }};
-        fastcgi_index index.php;
-        fastcgi_param SCRIPT_FILENAME $realpath_root$fastcgi_script_name;
-        include fastcgi_params;
+    location = /__owehost/maintenance.html {
+        internal;
+        alias ` + maintenancePagePath + `;
+        add_header Cache-Control "no-store" always;
+        add_header Retry-After "30" always;
+    }
+{{- else }}
+
+    location / {
+        try_files $uri $uri/ /index.php?$query_string;
     }
-    {{ end }}
+{{- end }}
 
     location ~ /\.(ht|git|svn) {
         deny all;
     }
 
+    location ~ /\.(env|json|lock|md)$ {
+        deny all;
+    }
+{{- $server := . }}
+{{- range .ProtectedList }}
+{{- if $server.PHPSocket }}
+
+    location ~ {{ .PHPPattern }} {
+        {{- range .Access }}
+        {{ . }};
+        {{- end }}
+        {{- template "php" $server }}
+    }
+{{- end }}
+
+    location ~ {{ .Pattern }} {
+        {{- range .Access }}
+        {{ . }};
+        {{- end }}
+        {{- if $server.UpstreamAddr }}
+        {{- template "proxy" $server }}
+        {{- else if $server.Upstream }}
+        return 503;
+        {{- else }}
+        try_files $uri $uri/ /index.php?$query_string;
+        {{- end }}
+    }
+{{- end }}
+{{- if .PHPSocket }}
+
+    location ~ \.php$ {
+        {{- template "php" . }}
+    }
+{{- end }}
+{{- if not .Upstream }}
+
     location ~* \.(jpg|jpeg|png|gif|ico|css|js|woff2?)$ {
         expires 30d;
         add_header Cache-Control "public, immutable";
+        {{- range .Headers }}
+        add_header {{ .Name }} {{ .Value }} always;
+        {{- end }}
     }
-}
-{{ end }}
-`
+{{- else if .StaticBypass }}
+
+    # Assets found in the document root skip the app
+    location ~* \.(jpg|jpeg|png|gif|ico|css|js|map|svg|webp|woff2?|ttf|txt)$ {
+        try_files $uri @app;
+        expires 30d;
+        add_header Cache-Control "public";
+        {{- range .Headers }}
+        add_header {{ .Name }} {{ .Value }} always;
+        {{- end }}
+    }
+{{- end }}
+{{- end }}
+
+{{- define "php" }}
+        fastcgi_pass unix:{{ .PHPSocket }};
+        fastcgi_index index.php;
+        fastcgi_param SCRIPT_FILENAME $realpath_root$fastcgi_script_name;
+        fastcgi_param DOCUMENT_ROOT $realpath_root;
+        include fastcgi_params;
+        {{- if .PHPValue }}
+        fastcgi_param PHP_VALUE "{{ .PHPValue }}";
+        {{- end }}
+        {{- template "cache" . }}
+{{- end }}
+
+{{- define "proxy" }}
+        proxy_pass http://{{ .Upstream }};
+        proxy_http_version 1.1;
+        proxy_set_header Host $host;
+        proxy_set_header X-Real-IP $remote_addr;
+        proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
+        proxy_set_header X-Forwarded-Proto $scheme;
+        proxy_set_header Upgrade $http_upgrade;
+        proxy_set_header Connection $owehost_connection_upgrade;
+        proxy_read_timeout 300s;
+        {{- template "cache" . }}
+{{- end }}
+
+{{- define "cache" }}
+{{- if .CacheZone }}
+        {{ .CacheModule }}_cache {{ .CacheZone }};
+        {{ .CacheModule }}_cache_key "` + cacheKey + `";
+        {{ .CacheModule }}_cache_valid 200 301 302 {{ .CacheTTL }}s;
+        {{ .CacheModule }}_cache_bypass {{ .CacheBypass }};
+        {{ .CacheModule }}_no_cache {{ .CacheBypass }};
+        {{ .CacheModule }}_cache_use_stale error timeout updating http_500 http_503;
+        {{ .CacheModule }}_cache_background_update on;
+        {{ .CacheModule }}_cache_lock on;
+        add_header X-Cache-Status $upstream_cache_status always;
+        {{- range .Headers }}
+        add_header {{ .Name }} {{ .Value }} always;
+        {{- end }}
+{{- end }}
+{{- end }}`
 
 // PHP-FPM pool template
-const phpFpmPoolTemplate = `; Generated by OweHost for {{ .Domain }}
-; Account: {{ .AccountID }}
+const phpFpmPoolTemplate = `; Generated by OweHost - changes will be overwritten
+; Account: {{ .AccountID }} ({{ .User }}), PHP {{ .Version }}
 
-[a{{ .AccountID }}-{{ .Domain }}]
-user = a{{ .AccountID }}
-group = a{{ .AccountID }}
+[{{ .PoolName }}]
+user = {{ .User }}
+group = {{ .Group }}
 
-listen = /run/php/php{{ .Version }}-fpm-a{{ .AccountID }}.sock
+listen = {{ .Socket }}
 listen.owner = www-data
 listen.group = www-data
 listen.mode = 0660
 
-pm = dynamic
-pm.max_children = 10
-pm.start_servers = 2
-pm.min_spare_servers = 1
-pm.max_spare_servers = 4
-pm.max_requests = 500
-
-chdir = {{ .AccountPath }}/web/{{ .Domain }}
-
-{{ with .PHPSettings }}
-php_admin_value[max_execution_time] = {{ .MaxExecutionTime }}
-php_admin_value[memory_limit] = {{ .MemoryLimit }}
-php_admin_value[post_max_size] = {{ .PostMaxSize }}
-php_admin_value[upload_max_filesize] = {{ .UploadMaxFilesize }}
-php_admin_value[max_input_vars] = {{ .MaxInputVars }}
-php_admin_value[display_errors] = {{ if .DisplayErrors }}On{{ else }}Off{{ end }}
-{{ end }}
-
-php_admin_value[open_basedir] = {{ .AccountPath }}:/tmp:/usr/share/php
-php_admin_value[disable_functions] = exec,passthru,shell_exec,system,proc_open,popen
+pm = {{ .PMMode }}
+pm.max_children = {{ .MaxChildren }}
+{{- if eq .PMMode "dynamic" }}
+pm.start_servers = {{ .StartServers }}
+pm.min_spare_servers = {{ .MinSpareServers }}
+pm.max_spare_servers = {{ .MaxSpareServers }}
+{{- end }}
+{{- if eq .PMMode "ondemand" }}
+pm.process_idle_timeout = {{ .ProcessIdleTimeout }}s
+{{- end }}
+pm.max_requests = {{ .MaxRequests }}
+request_terminate_timeout = {{ .RequestTerminateTimeout }}s
+
+chdir = /
+
+php_admin_value[open_basedir] = {{ .OpenBasedir }}
+php_admin_value[disable_functions] = {{ .DisableFunctions }}
 php_admin_value[error_log] = {{ .AccountPath }}/logs/php-error.log
+php_admin_flag[log_errors] = on
+php_admin_value[upload_tmp_dir] = {{ .AccountPath }}/tmp
+php_admin_value[session.save_path] = {{ .AccountPath }}/tmp
+{{ range .Extensions }}
+php_admin_value[extension] = {{ . }}
+{{- end }}
+{{ range .INI }}
+php_admin_value[{{ .Key }}] = {{ .Value }}
+{{- end }}
 `
+
+// phpValue renders a site's PHP settings for nginx's PHP_VALUE parameter.
+// Sites of an account share one pool, so every request carries its own
+// values rather than inheriting whatever the worker served last.
+func phpValue(settings *PHPSettings) string {
+	if settings == nil {
+		return ""
+	}
+
+	var lines []string
+	if settings.MaxExecutionTime > 0 {
+		lines = append(lines, fmt.Sprintf("max_execution_time=%d", settings.MaxExecutionTime))
+	}
+	if settings.MaxInputVars > 0 {
+		lines = append(lines, fmt.Sprintf("max_input_vars=%d", settings.MaxInputVars))
+	}
+	for key, value := range map[string]string{
+		"memory_limit":        settings.MemoryLimit,
+		"post_max_size":       settings.PostMaxSize,
+		"upload_max_filesize": settings.UploadMaxFilesize,
+	} {
+		if value != "" {
+			lines = append(lines, key+"="+value)
+		}
+	}
+	if settings.DisplayErrors {
+		lines = append(lines, "display_errors=On")
+	} else {
+		lines = append(lines, "display_errors=Off")
+	}
+	for key, value := range settings.CustomINI {
+		lines = append(lines, key+"="+value)
+	}
+
+	// One setting per line, written with nginx's \n escape
+	sort.Strings(lines)
+	return strings.Join(lines, `\n`)
+}
@@ -0,0 +1,358 @@
+package web
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/iSundram/OweHost/internal/storage/account"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite golden files in testdata")
+
+func TestRenderNginxConfig_Golden(t *testing.T) {
+	tests := []struct {
+		name string
+		site *SiteDescriptor
+	}{
+		{
+			name: "static",
+			site: &SiteDescriptor{
+				Domain:  "example.com",
+				Runtime: "static",
+			},
+		},
+		{
+			name: "php_ssl_redirects",
+			site: &SiteDescriptor{
+				Domain:      "example.com",
+				Runtime:     "php-8.2",
+				SSL:         true,
+				Aliases:     []string{"example.org"},
+				PHPSettings: &PHPSettings{Version: "8.2", MemoryLimit: "256M"},
+				Redirects: []Redirect{
+					{Source: "/old", Target: "/new", Code: 301},
+					{Source: "/blog/*", Target: "https://blog.example.com/$1", Code: 302, IsWildcard: true},
+					{Source: `^/post/(\d+)\.html$`, Target: "/posts/$1", Code: 308, IsRegex: true},
+				},
+				ErrorPages: map[string]string{"500": "/errors/500.html", "404": "404.html"},
+				Headers: map[string]string{
+					"Content-Security-Policy": "default-src 'self'",
+					"x-frame-options":         "DENY",
+				},
+			},
+		},
+		{
+			name: "ssl_redirect",
+			site: &SiteDescriptor{
+				Domain:      "example.com",
+				Runtime:     "static",
+				SSL:         true,
+				SSLRedirect: true,
+				Redirects:   []Redirect{{Source: "/old", Target: "/new", Code: 301}},
+				Headers:     map[string]string{"X-Powered-By": "OweHost"},
+			},
+		},
+		{
+			name: "nodejs_proxy",
+			site: &SiteDescriptor{
+				Domain:       "app.example.com",
+				Runtime:      "nodejs-20",
+				SSL:          true,
+				NodeSettings: &NodeSettings{Version: "20", Port: 3000},
+				ErrorPages:   map[string]string{"503": "down.html"},
+			},
+		},
+		{
+			name: "python_socket",
+			site: &SiteDescriptor{
+				Domain:         "py.example.com",
+				Runtime:        "python-3.12",
+				PythonSettings: &PythonSettings{Version: "3.12", Socket: "/srv/accounts/a-1001/run/gunicorn.sock"},
+			},
+		},
+		{
+			name: "nodejs_no_app",
+			site: &SiteDescriptor{
+				Domain:  "idle.example.com",
+				Runtime: "nodejs-20",
+			},
+		},
+		{
+			name: "php_hybrid",
+			site: &SiteDescriptor{
+				Domain:      "example.com",
+				Runtime:     "php-8.2",
+				WebServer:   BackendHybrid,
+				SSL:         true,
+				SSLRedirect: true,
+				PHPSettings: &PHPSettings{Version: "8.2", MemoryLimit: "256M", MaxExecutionTime: 60},
+				Redirects:   []Redirect{{Source: "/old", Target: "/new", Code: 301}},
+			},
+		},
+		{
+			name: "apache_static",
+			site: &SiteDescriptor{
+				Domain:       "example.com",
+				Runtime:      "static",
+				WebServer:    BackendApache,
+				DocumentRoot: "web root",
+				ErrorPages:   map[string]string{"502": "busy.html"},
+			},
+		},
+		{
+			name: "php_cache_limits",
+			site: &SiteDescriptor{
+				Domain:  "my-shop.example.com",
+				Runtime: "php-8.3",
+				Cache:   &CacheSettings{Enabled: true, TTL: 5, BypassCookies: []string{"cart.id"}, BypassPaths: []string{"/wp-admin", "/checkout"}},
+				RateLimits: []RateLimit{
+					{Path: "/wp-login.php", Rate: 10, Burst: 5},
+					{Path: "/api/", Rate: 600, Connections: 20},
+				},
+			},
+		},
+		{
+			name: "nodejs_cache",
+			site: &SiteDescriptor{
+				Domain:       "app.example.com",
+				Runtime:      "nodejs-20",
+				NodeSettings: &NodeSettings{Version: "20", Port: 3000},
+				Cache:        &CacheSettings{Enabled: true, TTL: 1},
+				RateLimits:   []RateLimit{{Path: "/", Connections: 50}},
+			},
+		},
+		{
+			name: "php_protected",
+			site: &SiteDescriptor{
+				Domain:  "example.com",
+				Runtime: "php-8.2",
+				ProtectedPaths: []ProtectedPath{
+					{Path: "/admin", Realm: `Staff "only"`},
+					{Path: "/admin/reports"},
+				},
+				AccessRules: []AccessRule{
+					{Path: "/", Deny: []string{"203.0.113.7"}},
+					{Path: "/admin", Allow: []string{"10.0.0.0/8", "2001:db8::/32"}, Deny: []string{"10.9.9.9"}},
+				},
+			},
+		},
+		{
+			name: "nodejs_protected",
+			site: &SiteDescriptor{
+				Domain:         "app.example.com",
+				Runtime:        "nodejs-20",
+				NodeSettings:   &NodeSettings{Version: "20", Port: 3000},
+				ProtectedPaths: []ProtectedPath{{Path: "/"}, {Path: "/metrics"}},
+			},
+		},
+		{
+			name: "php_waf",
+			site: &SiteDescriptor{
+				Domain:      "example.com",
+				Runtime:     "php-8.2",
+				SSL:         true,
+				SSLRedirect: true,
+				WAF:         &WAFSettings{Enabled: true, Mode: WAFModeBlocking, ExcludedRules: []int{942100, 920350}},
+			},
+		},
+		{
+			name: "escaping",
+			site: &SiteDescriptor{
+				Domain:  "example.com",
+				Runtime: "static",
+				Redirects: []Redirect{
+					{Source: `/a "quoted" path; return 200`, Target: `/b\c`, Code: 301},
+					{Source: `^/x\"y$`, Target: "/z", Code: 302, IsRegex: true},
+				},
+				ErrorPages: map[string]string{"404": `odd "name".html`},
+				Headers:    map[string]string{"X-Test": `a" always; add_header X-Evil "1`},
+			},
+		},
+	}
+
+	a := NewApplier()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateSite(tt.site); err != nil {
+				t.Fatalf("site should be valid: %v", err)
+			}
+
+			got, err := a.renderNginxConfig(1001, tt.site)
+			if err != nil {
+				t.Fatalf("render failed: %v", err)
+			}
+
+			golden := filepath.Join("testdata", "nginx", tt.name+".conf")
+			if *updateGolden {
+				if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("missing golden file (run with -update): %v", err)
+			}
+			if got != string(want) {
+				t.Errorf("config differs from %s:\n%s", golden, got)
+			}
+
+			if !tt.site.UsesApache() {
+				return
+			}
+			got, err = a.renderApacheConfig(1001, tt.site)
+			if err != nil {
+				t.Fatalf("apache render failed: %v", err)
+			}
+			golden = filepath.Join("testdata", "apache", tt.name+".conf")
+			if *updateGolden {
+				if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err = os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("missing golden file (run with -update): %v", err)
+			}
+			if got != string(want) {
+				t.Errorf("apache config differs from %s:\n%s", golden, got)
+			}
+		})
+	}
+}
+
+func TestRenderNginxConfig_SocketOutsideAccount(t *testing.T) {
+	site := &SiteDescriptor{
+		Domain:       "example.com",
+		Runtime:      "nodejs-20",
+		NodeSettings: &NodeSettings{Version: "20", Socket: "/srv/accounts/a-1002/run/app.sock"},
+	}
+	if _, err := NewApplier().renderNginxConfig(1001, site); err == nil {
+		t.Error("expected an error for another account's socket")
+	}
+}
+
+func TestValidateSite_RejectsInjection(t *testing.T) {
+	tests := []struct {
+		name string
+		site *SiteDescriptor
+		want error
+	}{
+		{"newline in redirect source", &SiteDescriptor{Redirects: []Redirect{{Source: "/a\n}", Target: "/b", Code: 301}}}, ErrInvalidRedirectSource},
+		{"relative redirect source", &SiteDescriptor{Redirects: []Redirect{{Source: "a", Target: "/b", Code: 301}}}, ErrInvalidRedirectSource},
+		{"newline in redirect target", &SiteDescriptor{Redirects: []Redirect{{Source: "/a", Target: "/b\nx", Code: 301}}}, ErrInvalidRedirectURL},
+		{"variable in redirect target", &SiteDescriptor{Redirects: []Redirect{{Source: "/a", Target: "/$http_cookie", Code: 301}}}, ErrInvalidRedirectURL},
+		{"scheme in redirect target", &SiteDescriptor{Redirects: []Redirect{{Source: "/a", Target: "javascript:alert(1)", Code: 301}}}, ErrInvalidRedirectURL},
+		{"duplicate redirect", &SiteDescriptor{Redirects: []Redirect{{Source: "/a", Target: "/b", Code: 301}, {Source: "/a", Target: "/c", Code: 302}}}, ErrDuplicateRedirect},
+		{"unknown web server", &SiteDescriptor{WebServer: "lighttpd"}, ErrInvalidWebServer},
+		{"apache for app runtime", &SiteDescriptor{Runtime: "nodejs-20", WebServer: BackendApache}, ErrWebServerRuntime},
+		{"cache on static site", &SiteDescriptor{Cache: &CacheSettings{Enabled: true, TTL: 1}}, ErrCacheRuntime},
+		{"cache TTL", &SiteDescriptor{Runtime: "php-8.2", Cache: &CacheSettings{Enabled: true}}, ErrInvalidCacheTTL},
+		{"regex in bypass cookie", &SiteDescriptor{Runtime: "php-8.2", Cache: &CacheSettings{Enabled: true, TTL: 1, BypassCookies: []string{"a|.*"}}}, ErrInvalidCookieName},
+		{"quote in bypass path", &SiteDescriptor{Runtime: "php-8.2", Cache: &CacheSettings{Enabled: true, TTL: 1, BypassPaths: []string{`/a"`}}}, ErrInvalidLimitPath},
+		{"rate limit path", &SiteDescriptor{RateLimits: []RateLimit{{Path: "/a;b", Rate: 1}}}, ErrInvalidLimitPath},
+		{"empty rate limit", &SiteDescriptor{RateLimits: []RateLimit{{Path: "/a"}}}, ErrInvalidRateLimit},
+		{"burst without rate", &SiteDescriptor{RateLimits: []RateLimit{{Path: "/a", Burst: 5, Connections: 1}}}, ErrInvalidRateLimit},
+		{"protected path not clean", &SiteDescriptor{ProtectedPaths: []ProtectedPath{{Path: "/admin/"}}}, ErrInvalidLimitPath},
+		{"duplicate protected path", &SiteDescriptor{ProtectedPaths: []ProtectedPath{{Path: "/a"}, {Path: "/a"}}}, ErrDuplicatePath},
+		{"variable in realm", &SiteDescriptor{ProtectedPaths: []ProtectedPath{{Path: "/a", Realm: "$host"}}}, ErrInvalidRealm},
+		{"invalid CIDR", &SiteDescriptor{AccessRules: []AccessRule{{Path: "/", Allow: []string{"10.0.0.0/33"}}}}, ErrInvalidCIDR},
+		{"directive in address", &SiteDescriptor{AccessRules: []AccessRule{{Path: "/", Deny: []string{"all; allow all"}}}}, ErrInvalidCIDR},
+		{"WAF mode", &SiteDescriptor{WAF: &WAFSettings{Enabled: true, Mode: "off"}}, ErrInvalidWAFMode},
+		{"WAF rule ID", &SiteDescriptor{WAF: &WAFSettings{Enabled: true, Mode: WAFModeDetection, ExcludedRules: []int{0}}}, ErrInvalidWAFRule},
+		{"error page code", &SiteDescriptor{ErrorPages: map[string]string{"200": "ok.html"}}, ErrInvalidErrorPage},
+		{"error page traversal", &SiteDescriptor{ErrorPages: map[string]string{"404": "../../etc/passwd"}}, ErrInvalidErrorPage},
+		{"error page variable", &SiteDescriptor{ErrorPages: map[string]string{"404": "$uri"}}, ErrInvalidErrorPage},
+		{"header name", &SiteDescriptor{Headers: map[string]string{"X-A b": "1"}}, ErrInvalidHeader},
+		{"header newline", &SiteDescriptor{Headers: map[string]string{"X-A": "1\r\nSet-Cookie: x"}}, ErrInvalidHeader},
+		{"socket injection", &SiteDescriptor{Runtime: "nodejs-20", NodeSettings: &NodeSettings{Version: "20", Socket: "/tmp/a.sock; }"}}, ErrInvalidSocket},
+		{"relative socket", &SiteDescriptor{Runtime: "python-3.12", PythonSettings: &PythonSettings{Version: "3.12", Socket: "app.sock"}}, ErrInvalidSocket},
+		{"header variable", &SiteDescriptor{Headers: map[string]string{"X-A": "$remote_addr"}}, ErrInvalidHeader},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.site.Domain = "example.com"
+			if tt.site.Runtime == "" {
+				tt.site.Runtime = "static"
+			}
+			err := ValidateSite(tt.site)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestRenderWAFRules(t *testing.T) {
+	site := &SiteDescriptor{
+		Domain: "example.com",
+		WAF:    &WAFSettings{Enabled: true, Mode: WAFModeDetection, ExcludedRules: []int{942100, 920350}},
+	}
+	want := `# Generated by OweHost for example.com - changes will be overwritten
+Include /etc/nginx/owehost/modsecurity.conf
+SecRuleEngine DetectionOnly
+SecAuditLog /var/log/nginx/owehost-waf/a-1001-example.com.log
+SecRuleRemoveById 942100 920350
+`
+	if got := renderWAFRules(1001, site); got != want {
+		t.Errorf("unexpected rules:\n%s", got)
+	}
+}
+
+func TestRenderBandwidthConf(t *testing.T) {
+	want := `# Generated by OweHost - changes will be overwritten
+# Account 1001 is over its monthly bandwidth
+limit_rate 64k;
+`
+	if got := renderBandwidthConf(1001, account.BandwidthActionThrottle); got != want {
+		t.Errorf("unexpected throttle:\n%s", got)
+	}
+
+	got := renderBandwidthConf(1001, account.BandwidthActionSuspend)
+	for _, line := range []string{
+		"error_page 503 /__owehost/bandwidth-exceeded.html;",
+		"if ($uri != /__owehost/bandwidth-exceeded.html) {",
+		"    internal;",
+		"    alias /etc/nginx/owehost/bandwidth-exceeded.html;",
+	} {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("suspension is missing %q:\n%s", line, got)
+		}
+	}
+}
+
+func TestParseWAFEntry(t *testing.T) {
+	line := `{"transaction":{"client_ip":"203.0.113.9","time_stamp":"Sun Oct 18 09:15:02 2026","server_id":"x",` +
+		`"client_port":51200,"host_ip":"192.0.2.10","host_port":443,"unique_id":"176077890212.345678",` +
+		`"request":{"method":"GET","http_version":1.1,"uri":"/?id=1%27%20or%201=1","headers":{"host":"example.com"}},` +
+		`"response":{"http_code":403,"headers":{}},` +
+		`"producer":{"modsecurity":"ModSecurity v3.0.12 (Linux)","secrules_engine":"Enabled","components":["OWASP_CRS/3.3.5"]},` +
+		`"messages":[{"message":"SQL Injection Attack Detected via libinjection","details":{"ruleId":"942100","data":"Matched Data: s&1c found","severity":"2"}},` +
+		`{"message":"Inbound Anomaly Score Exceeded (Total Score: 5)","details":{"ruleId":"949110","data":"","severity":"2"}}]}}`
+
+	event, err := ParseWAFEntry([]byte(line))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !event.Blocked || event.Status != 403 || event.Host != "example.com" || event.ClientIP != "203.0.113.9" {
+		t.Errorf("unexpected event: %+v", event)
+	}
+	if event.Severity != "critical" || len(event.Rules) != 2 || event.Rules[0].ID != 942100 {
+		t.Errorf("unexpected rules: %+v", event.Rules)
+	}
+	if event.Time.IsZero() {
+		t.Error("time stamp not parsed")
+	}
+
+	detected := strings.Replace(line, `"Enabled"`, `"DetectionOnly"`, 1)
+	if event, err := ParseWAFEntry([]byte(detected)); err != nil || event.Blocked {
+		t.Errorf("detection-only entry reported as blocked: %+v, %v", event, err)
+	}
+	if _, err := ParseWAFEntry([]byte(`{"foo":1}`)); err == nil {
+		t.Error("expected an error for a foreign JSON line")
+	}
+}
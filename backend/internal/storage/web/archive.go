@@ -0,0 +1,212 @@
+// Package web provides filesystem-based web/site state management
+package web
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxArchiveSize caps the unpacked size of an uploaded release archive
+const maxArchiveSize = 2 << 30
+
+// ErrUnsupportedArchive is returned for uploads that are not zip or tar(.gz)
+var ErrUnsupportedArchive = errors.New("unsupported archive: expected .zip, .tar or .tar.gz")
+
+// ExtractArchive unpacks a zip, tar or gzipped tar archive into dest.
+// Entries escaping dest are rejected, symlinks and device files skipped, and a
+// single top-level directory (as in GitHub and GitLab downloads) is
+// stripped so the archive contents become the release root.
+func ExtractArchive(path, dest string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	header := make([]byte, 262)
+	n, _ := io.ReadFull(f, header)
+	header = header[:n]
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	switch {
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")):
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if err := extractZip(f, info.Size(), dest); err != nil {
+			return err
+		}
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(bufio.NewReader(f))
+		if err != nil {
+			return fmt.Errorf("invalid gzip archive: %w", err)
+		}
+		defer gz.Close()
+		if err := extractTar(gz, dest); err != nil {
+			return err
+		}
+	case len(header) >= 262 && string(header[257:262]) == "ustar":
+		if err := extractTar(f, dest); err != nil {
+			return err
+		}
+	default:
+		return ErrUnsupportedArchive
+	}
+
+	return stripSingleRoot(dest)
+}
+
+// extractTar unpacks regular files and directories from a tar stream
+func extractTar(r io.Reader, dest string) error {
+	tr := tar.NewReader(r)
+	var total int64
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid tar archive: %w", err)
+		}
+
+		target, err := archiveTarget(dest, hdr.Name)
+		if err != nil {
+			return err
+		}
+		if target == "" {
+			continue
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if total += hdr.Size; total > maxArchiveSize {
+				return errors.New("archive is too large")
+			}
+			if err := writeArchiveFile(target, tr, hdr.FileInfo().Mode()); err != nil {
+				return err
+			}
+		default:
+			// Links and special files could point outside the release
+			continue
+		}
+	}
+}
+
+// extractZip unpacks regular files and directories from a zip archive
+func extractZip(r io.ReaderAt, size int64, dest string) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return fmt.Errorf("invalid zip archive: %w", err)
+	}
+
+	var total uint64
+	for _, zf := range zr.File {
+		target, err := archiveTarget(dest, zf.Name)
+		if err != nil {
+			return err
+		}
+		if target == "" {
+			continue
+		}
+
+		mode := zf.Mode()
+		switch {
+		case mode.IsDir():
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case mode.IsRegular():
+			if total += zf.UncompressedSize64; total > maxArchiveSize {
+				return errors.New("archive is too large")
+			}
+			rc, err := zf.Open()
+			if err != nil {
+				return err
+			}
+			err = writeArchiveFile(target, rc, mode)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// archiveTarget maps an archive entry name to a path inside dest. It
+// returns "" for entries that name the root itself.
+func archiveTarget(dest, name string) (string, error) {
+	name = strings.TrimPrefix(filepath.ToSlash(name), "./")
+	name = strings.TrimSuffix(name, "/")
+	if name == "" || name == "." {
+		return "", nil
+	}
+	if !filepath.IsLocal(filepath.FromSlash(name)) {
+		return "", fmt.Errorf("archive entry %q escapes the release directory", name)
+	}
+	return filepath.Join(dest, filepath.FromSlash(name)), nil
+}
+
+// writeArchiveFile writes one file, keeping only its permission bits
+func writeArchiveFile(target string, r io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	perm := mode.Perm() &^ 0022
+	if perm&0400 == 0 {
+		perm = 0644
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, io.LimitReader(r, maxArchiveSize))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// stripSingleRoot hoists the contents of dest/<dir> into dest when that
+// directory is the only entry
+func stripSingleRoot(dest string) error {
+	entries, err := os.ReadDir(dest)
+	if err != nil || len(entries) != 1 || !entries[0].IsDir() {
+		return err
+	}
+
+	root := filepath.Join(dest, entries[0].Name())
+	children, err := os.ReadDir(root)
+	if err != nil {
+		return err
+	}
+
+	// Move the root aside first, in case it contains an entry of the same name
+	tmp := filepath.Join(dest, ".extract-root")
+	if err := os.Rename(root, tmp); err != nil {
+		return err
+	}
+	for _, child := range children {
+		if err := os.Rename(filepath.Join(tmp, child.Name()), filepath.Join(dest, child.Name())); err != nil {
+			return err
+		}
+	}
+	return os.Remove(tmp)
+}
@@ -0,0 +1,131 @@
+// Package web provides filesystem-based web/site state management
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/iSundram/OweHost/internal/storage/account"
+)
+
+// Bandwidth restrictions are account-wide, so they live in one file per
+// account that every site config of the account includes. Switching one
+// on or off is a reload, not a regeneration of each site.
+const (
+	bandwidthConfDir      = "/etc/nginx/owehost/bandwidth"
+	bandwidthPagePath     = "/etc/nginx/owehost/bandwidth-exceeded.html"
+	bandwidthPageLocation = "/__owehost/bandwidth-exceeded.html"
+)
+
+// throttledRate is the per-connection rate of a throttled account
+const throttledRate = "64k"
+
+const bandwidthPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Bandwidth limit exceeded</title>
+<style>body{font-family:sans-serif;max-width:32em;margin:4em auto;padding:0 1em;color:#333}</style>
+</head>
+<body>
+<h1>Bandwidth limit exceeded</h1>
+<p>This site has used up its monthly bandwidth. It will be back when the next billing period starts or its limit is raised.</p>
+</body>
+</html>
+`
+
+// bandwidthConfPath is the restriction file of an account
+func bandwidthConfPath(accountID int) string {
+	return filepath.Join(bandwidthConfDir, fmt.Sprintf("a-%d.conf", accountID))
+}
+
+// bandwidthInclude is what site configs include. The pattern makes nginx
+// glob for the file, and a glob matching nothing is not an error, so
+// unrestricted accounts need no file.
+func bandwidthInclude(accountID int) string {
+	return filepath.Join(bandwidthConfDir, fmt.Sprintf("a-%d.con[f]", accountID))
+}
+
+// renderBandwidthConf renders the server-level directives of a restriction.
+// A suspended account answers 503 with a notice for every request; the
+// rewrite runs before any location is chosen, so no site rule gets around it.
+func renderBandwidthConf(accountID int, action string) string {
+	conf := fmt.Sprintf("# Generated by OweHost - changes will be overwritten\n# Account %d is over its monthly bandwidth\n", accountID)
+	switch action {
+	case account.BandwidthActionThrottle:
+		conf += "limit_rate " + throttledRate + ";\n"
+	case account.BandwidthActionSuspend:
+		conf += `error_page 503 ` + bandwidthPageLocation + `;
+if ($uri != ` + bandwidthPageLocation + `) {
+    return 503;
+}
+location = ` + bandwidthPageLocation + ` {
+    internal;
+    alias ` + bandwidthPagePath + `;
+    add_header Cache-Control "no-store" always;
+}
+`
+	}
+	return conf
+}
+
+// EnforceBandwidth throttles an account's sites or replaces them with a
+// notice, and lifts the restriction when action is "". nginx is tested and
+// reloaded; a restriction it rejects is rolled back.
+func (a *Applier) EnforceBandwidth(accountID int, action string) error {
+	if action != "" && action != account.BandwidthActionThrottle && action != account.BandwidthActionSuspend {
+		return account.ErrInvalidBandwidthAction
+	}
+	t := serverTargets[ServerNginx]
+	path := bandwidthConfPath(accountID)
+
+	serverMu.Lock()
+	defer serverMu.Unlock()
+
+	previous, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	hadPrevious := err == nil
+
+	if action == "" {
+		if !hadPrevious {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	} else {
+		conf := []byte(renderBandwidthConf(accountID, action))
+		if hadPrevious && bytes.Equal(previous, conf) {
+			return nil
+		}
+		if action == account.BandwidthActionSuspend {
+			if err := writeIfChanged(bandwidthPagePath, []byte(bandwidthPage)); err != nil {
+				return err
+			}
+		}
+		if err := os.MkdirAll(bandwidthConfDir, 0755); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(path, conf, 0644); err != nil {
+			return err
+		}
+	}
+
+	if err := t.testAndReload(); err != nil {
+		if hadPrevious {
+			writeFileAtomic(path, previous, 0644)
+		} else {
+			os.Remove(path)
+		}
+		if _, err := t.runTest(); err == nil {
+			t.run(t.reload)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+// Package web provides filesystem-based web/site state management
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// nginxCacheRoot holds one cache directory per account. It is outside the
+// account tree so account users cannot plant cached responses.
+const nginxCacheRoot = "/var/cache/nginx/owehost"
+
+// cacheKey identifies a cached response. Its fields are separated so a
+// purge can match the host and path of an entry exactly.
+const cacheKey = "$scheme|$request_method|$host|$request_uri"
+
+// Cookies of logged-in visitors and open sessions. Requests carrying them
+// always skip the cache, on top of the cookies a site lists itself.
+var defaultBypassCookies = []string{
+	"wordpress_logged_in", "wp-postpass", "comment_author", "woocommerce_items_in_cart",
+	"PHPSESSID", "laravel_session", "sessionid",
+}
+
+// nginxMap is a map block in the http context. Requests matching one of
+// the patterns set the variable to Value, all others to "".
+type nginxMap struct {
+	Source   string
+	Name     string
+	Value    string
+	Patterns []string // Quoted regexes
+}
+
+// cacheZone returns the cache zone of an account. nginx ties a zone to the
+// module that declared it, so FastCGI and proxied sites need one each.
+func cacheZone(module string, accountID int) string {
+	return fmt.Sprintf("owehost_%s_a%d", module, accountID)
+}
+
+// cacheZonesPath is the shared file declaring an account's cache zones
+func cacheZonesPath(accountID int) string {
+	return fmt.Sprintf("/etc/nginx/conf.d/owehost-cache-a%d.conf", accountID)
+}
+
+// ensureCacheZones declares the account's cache zones in the http context.
+// All of the account's sites share them and the zones' size limits.
+func ensureCacheZones(accountID int) error {
+	dir := filepath.Join(nginxCacheRoot, fmt.Sprintf("a-%d", accountID))
+
+	var buf strings.Builder
+	buf.WriteString("# Generated by OweHost - changes will be overwritten\n")
+	for _, module := range []string{"fastcgi", "proxy"} {
+		fmt.Fprintf(&buf, "%s_cache_path %s/%s levels=1:2 keys_zone=%s:10m max_size=256m inactive=60m use_temp_path=off;\n",
+			module, dir, module, cacheZone(module, accountID))
+	}
+	return writeIfChanged(cacheZonesPath(accountID), []byte(buf.String()))
+}
+
+// siteVarPrefix returns a prefix for the site's nginx variables and zones.
+// Variable names cannot contain "-", and labels never start or end with
+// one, so "__" keeps names of different domains apart.
+func siteVarPrefix(accountID int, domain string) string {
+	domain = strings.ReplaceAll(domain, "-", "__")
+	return fmt.Sprintf("owehost_a%d_%s", accountID, strings.ReplaceAll(domain, ".", "_"))
+}
+
+// prefixPattern returns a quoted regex matching paths that start with path
+func prefixPattern(path string) string {
+	return nginxQuote("~^" + regexp.QuoteMeta(path))
+}
+
+// applyCache fills in the site's cache settings. module is "fastcgi" for
+// PHP served by nginx and "proxy" for apps and Apache.
+func (s *nginxSite) applyCache(accountID int, module string, settings *CacheSettings) {
+	prefix := siteVarPrefix(accountID, s.Domain)
+
+	cookies := make([]string, 0, len(defaultBypassCookies)+len(settings.BypassCookies))
+	for _, name := range append(append([]string{}, defaultBypassCookies...), settings.BypassCookies...) {
+		cookies = append(cookies, regexp.QuoteMeta(name))
+	}
+	cookieMap := nginxMap{
+		Source:   "$http_cookie",
+		Name:     prefix + "_nocache_cookie",
+		Value:    "1",
+		Patterns: []string{nginxQuote(`~(^|;\s*)(` + strings.Join(cookies, "|") + `)`)},
+	}
+	s.Maps = append(s.Maps, cookieMap)
+
+	// Requests with credentials are personal even without a cookie
+	bypass := []string{"$http_authorization", "$" + cookieMap.Name}
+
+	if len(settings.BypassPaths) > 0 {
+		pathMap := nginxMap{Source: "$uri", Name: prefix + "_nocache_path", Value: "1"}
+		for _, path := range settings.BypassPaths {
+			pathMap.Patterns = append(pathMap.Patterns, prefixPattern(path))
+		}
+		s.Maps = append(s.Maps, pathMap)
+		bypass = append(bypass, "$"+pathMap.Name)
+	}
+
+	s.CacheModule = module
+	s.CacheZone = cacheZone(module, accountID)
+	s.CacheTTL = settings.TTL
+	s.CacheBypass = strings.Join(bypass, " ")
+}
+
+// PurgeCache removes a site's cached responses and returns how many were
+// removed. With a prefix only entries whose path starts with it go. nginx
+// checks for the file on every lookup, so a purged entry is simply fetched
+// from the site again.
+func (a *Applier) PurgeCache(accountID int, site *SiteDescriptor, prefix string) (int, error) {
+	if prefix != "" && !validLimitPath.MatchString(prefix) {
+		return 0, ErrInvalidLimitPath
+	}
+
+	hosts := map[string]bool{site.Domain: true, "www." + site.Domain: true}
+	for _, alias := range site.Aliases {
+		hosts[alias] = true
+	}
+
+	removed := 0
+	root := filepath.Join(nginxCacheRoot, fmt.Sprintf("a-%d", accountID))
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		// Entries being written or evicted meanwhile are skipped
+		key, err := readCacheKey(path)
+		if err != nil {
+			return nil
+		}
+		fields := strings.SplitN(key, "|", 4)
+		if len(fields) != 4 || !hosts[strings.ToLower(fields[2])] || !strings.HasPrefix(fields[3], prefix) {
+			return nil
+		}
+		if os.Remove(path) == nil {
+			removed++
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// readCacheKey reads the key nginx stores in the header of a cache file
+func readCacheKey(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	header := make([]byte, 4096)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	header = header[:n]
+
+	start := bytes.Index(header, []byte("\nKEY: "))
+	if start < 0 {
+		return "", fmt.Errorf("no cache key in %s", path)
+	}
+	key := header[start+len("\nKEY: "):]
+	end := bytes.IndexByte(key, '\n')
+	if end < 0 {
+		return "", fmt.Errorf("truncated cache key in %s", path)
+	}
+	return string(key[:end]), nil
+}
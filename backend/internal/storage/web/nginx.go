@@ -0,0 +1,255 @@
+// Package web provides filesystem-based web/site state management
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// nginxSite holds everything the nginx template renders for a site
+type nginxSite struct {
+	*SiteDescriptor
+	AccountID     int
+	AccountPath   string
+	SitePath      string
+	DocumentPath  string // Quoted
+	PHPSocket     string
+	PHPValue      string
+	Upstream      string // Upstream name for proxied sites
+	UpstreamAddr  string // Address of the app or Apache, "" when there is none yet
+	StaticBypass  bool   // Serve assets found on disk without the upstream
+	Maintenance   string // Status codes answered with the maintenance page
+	ServerNames   string
+	RedirectList  []nginxRedirect
+	ErrorPageList []nginxErrorPage
+	CacheModule   string // fastcgi or proxy, "" when the cache is off
+	CacheZone     string
+	CacheTTL      int
+	CacheBypass   string // Variables that skip the cache when set
+	Maps          []nginxMap
+	LimitZones    []nginxLimitZone
+	LimitReq      []string // limit_req arguments
+	LimitConn     []string // limit_conn arguments
+	SiteAccess    []string // Login and IP directives for the whole site
+	ProtectedList []nginxProtected
+	WAFRules      string // ModSecurity config of the site, "" when its firewall is off
+	BandwidthConf string // Include pattern of the account's over-quota restriction
+}
+
+// nginxServer is a site as seen from one server block. The HTTP and HTTPS
+// blocks send different security headers.
+type nginxServer struct {
+	*nginxSite
+	Headers []nginxHeader
+}
+
+// nginxHeader is one add_header directive
+type nginxHeader struct {
+	Name  string
+	Value string
+}
+
+// nginxRedirect is a redirect rendered as its own location block
+type nginxRedirect struct {
+	Match  string // Location modifier and quoted pattern, e.g. `= "/old"`
+	Code   int
+	Target string // Quoted
+}
+
+// nginxErrorPage is one error_page directive
+type nginxErrorPage struct {
+	Code int
+	Page string // Quoted
+}
+
+// Headers nginx adds to every response, before the site's own
+var (
+	baseHTTPHeaders = []nginxHeader{
+		{"X-Frame-Options", "SAMEORIGIN"},
+		{"X-Content-Type-Options", "nosniff"},
+		{"X-XSS-Protection", "1; mode=block"},
+	}
+	baseHTTPSHeaders = []nginxHeader{
+		{"X-Frame-Options", "SAMEORIGIN"},
+		{"X-Content-Type-Options", "nosniff"},
+		{"Strict-Transport-Security", "max-age=31536000; includeSubDomains"},
+	}
+)
+
+// Files shared by the configs of all proxied sites
+const (
+	nginxProxyConfPath  = "/etc/nginx/conf.d/owehost-proxy.conf"
+	maintenancePagePath = "/etc/nginx/owehost/maintenance.html"
+)
+
+// Apps can't be reached while they start, restart or crash; visitors get
+// this page instead of nginx's bare 502
+var maintenanceCodes = []string{"502", "503", "504"}
+
+// nginxProxyConf lives in the http context. The map keeps upstream
+// connections alive for plain requests and switches websocket handshakes to
+// "Connection: upgrade".
+const nginxProxyConf = `# Generated by OweHost - changes will be overwritten
+map $http_upgrade $owehost_connection_upgrade {
+    default upgrade;
+    ''      '';
+}
+`
+
+const maintenancePage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Temporarily unavailable</title>
+<style>body{font-family:sans-serif;max-width:32em;margin:4em auto;padding:0 1em;color:#333}</style>
+</head>
+<body>
+<h1>Temporarily unavailable</h1>
+<p>This site is being updated or restarted. Please try again in a moment.</p>
+</body>
+</html>
+`
+
+// upstreamName returns the nginx upstream of a site. Upstream names are
+// global to nginx, so they include the account.
+func upstreamName(accountID int, domain string) string {
+	return fmt.Sprintf("owehost_a%d_%s", accountID, strings.ReplaceAll(domain, ".", "_"))
+}
+
+// upstreamAddr returns the upstream server of a proxied site. Sockets must
+// be inside the account so a site cannot be pointed at another account's app.
+func upstreamAddr(accountPath string, site *SiteDescriptor) (string, error) {
+	if socket := site.AppSocket(); socket != "" {
+		if !strings.HasPrefix(socket, accountPath+"/") {
+			return "", fmt.Errorf("socket %s is outside the account", socket)
+		}
+		return "unix:" + socket, nil
+	}
+	if port := site.AppPort(); port > 0 {
+		return fmt.Sprintf("127.0.0.1:%d", port), nil
+	}
+	return "", nil
+}
+
+// siteMaintenanceCodes lists the maintenance codes the site has no error
+// page of its own for
+func siteMaintenanceCodes(pages map[string]string) string {
+	var codes []string
+	for _, code := range maintenanceCodes {
+		if _, custom := pages[code]; !custom {
+			codes = append(codes, code)
+		}
+	}
+	return strings.Join(codes, " ")
+}
+
+// ensureProxySupport writes the shared files proxied site configs refer to
+func ensureProxySupport() error {
+	if err := writeIfChanged(nginxProxyConfPath, []byte(nginxProxyConf)); err != nil {
+		return err
+	}
+	return writeIfChanged(maintenancePagePath, []byte(maintenancePage))
+}
+
+// writeIfChanged writes a shared file unless it already has the content
+func writeIfChanged(path string, data []byte) error {
+	if current, err := os.ReadFile(path); err == nil && bytes.Equal(current, data) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0644)
+}
+
+// nginxQuote renders s as a double-quoted nginx argument. Inside quotes
+// nginx only treats backslash sequences specially, so escaping backslashes
+// and quotes keeps any value a single argument. Values are validated to
+// contain no control characters before they get here.
+func nginxQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
+
+// siteHeaders merges the site's custom headers over base. A custom header
+// replaces a base header of the same name instead of being sent twice.
+func siteHeaders(base []nginxHeader, custom map[string]string) []nginxHeader {
+	headers := make([]nginxHeader, 0, len(base)+len(custom))
+	for _, h := range base {
+		if _, overridden := lookupHeader(custom, h.Name); !overridden {
+			headers = append(headers, nginxHeader{h.Name, nginxQuote(h.Value)})
+		}
+	}
+
+	names := make([]string, 0, len(custom))
+	for name := range custom {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		headers = append(headers, nginxHeader{name, nginxQuote(custom[name])})
+	}
+	return headers
+}
+
+// lookupHeader finds a header by case-insensitive name
+func lookupHeader(headers map[string]string, name string) (string, bool) {
+	for k, v := range headers {
+		if strings.EqualFold(k, name) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// siteRedirects turns the site's redirects into location blocks. Exact
+// sources become "=" locations; wildcard and regex sources become regex
+// locations, which nginx checks in order, so earlier redirects win.
+func siteRedirects(redirects []Redirect) []nginxRedirect {
+	out := make([]nginxRedirect, 0, len(redirects))
+	for _, r := range redirects {
+		var match string
+		switch {
+		case r.IsRegex:
+			match = "~ " + nginxQuote(r.Source)
+		case r.IsWildcard:
+			match = "~ " + nginxQuote(wildcardPattern(r.Source))
+		default:
+			match = "= " + nginxQuote(r.Source)
+		}
+		out = append(out, nginxRedirect{Match: match, Code: r.Code, Target: nginxQuote(r.Target)})
+	}
+	return out
+}
+
+// wildcardPattern converts a source like /blog/* into an anchored regex.
+// Each * captures, so targets can refer to $1, $2, ...
+func wildcardPattern(source string) string {
+	parts := strings.Split(source, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return "^" + strings.Join(parts, "(.*)") + "$"
+}
+
+// siteErrorPages returns the site's error pages ordered by status code
+func siteErrorPages(pages map[string]string) []nginxErrorPage {
+	out := make([]nginxErrorPage, 0, len(pages))
+	for code, page := range pages {
+		n, err := strconv.Atoi(code)
+		if err != nil {
+			continue
+		}
+		out = append(out, nginxErrorPage{Code: n, Page: nginxQuote("/" + strings.TrimPrefix(page, "/"))})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Code < out[j].Code })
+	return out
+}
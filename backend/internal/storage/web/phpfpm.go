@@ -0,0 +1,394 @@
+// Package web provides filesystem-based web/site state management
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/iSundram/OweHost/internal/storage/account"
+	"github.com/iSundram/OweHost/pkg/models"
+	"github.com/iSundram/OweHost/pkg/utils"
+)
+
+// Functions disabled in every pool; pools may only add to this list
+var baselineDisabledFunctions = []string{"exec", "passthru", "shell_exec", "system", "proc_open", "popen"}
+
+// PHPPoolConfigPath returns the pool.d file rendered for an account's pool
+func PHPPoolConfigPath(accountID int, version string) string {
+	return fmt.Sprintf("/etc/php/%s/fpm/pool.d/a-%d.conf", version, accountID)
+}
+
+// PHPSocketPath returns the FastCGI socket of an account's pool
+func PHPSocketPath(accountID int, version string) string {
+	return fmt.Sprintf("/run/php/php%s-fpm-a%d.sock", version, accountID)
+}
+
+// DefaultPHPPool returns the pool created for an account when one of its
+// sites first uses a PHP version
+func DefaultPHPPool(accountID int, userID, version string) *models.PHPPool {
+	now := time.Now()
+	return &models.PHPPool{
+		ID:                      utils.GenerateID("php"),
+		UserID:                  userID,
+		AccountID:               accountID,
+		Version:                 version,
+		PoolName:                fmt.Sprintf("a%d", accountID),
+		SocketPath:              PHPSocketPath(accountID, version),
+		PMMode:                  models.PHPPMDynamic,
+		MaxChildren:             10,
+		StartServers:            2,
+		MinSpareServers:         1,
+		MaxSpareServers:         4,
+		MaxRequests:             500,
+		ProcessIdleTimeout:      10,
+		RequestTerminateTimeout: 300,
+		Extensions:              []string{},
+		INIOverrides:            map[string]string{},
+		CreatedAt:               now,
+		UpdatedAt:               now,
+	}
+}
+
+// phpPoolsPath is where an account's pool records live
+func (s *StateManager) phpPoolsPath(accountID int) string {
+	return filepath.Join(
+		account.BaseAccountPath,
+		fmt.Sprintf("%s%d", account.AccountPrefix, accountID),
+		"runtime",
+		"php-pools",
+	)
+}
+
+// ReadPHPPools reads all pool records of an account
+func (s *StateManager) ReadPHPPools(accountID int) ([]*models.PHPPool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	files, err := filepath.Glob(filepath.Join(s.phpPoolsPath(accountID), "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	pools := make([]*models.PHPPool, 0, len(files))
+	for _, file := range files {
+		pool, err := readPHPPoolFile(file)
+		if err != nil {
+			return nil, err
+		}
+		pools = append(pools, pool)
+	}
+	return pools, nil
+}
+
+// ReadPHPPool reads the account's pool for a PHP version, or nil when it has none
+func (s *StateManager) ReadPHPPool(accountID int, version string) (*models.PHPPool, error) {
+	if !validPHPVersions[version] {
+		return nil, ErrInvalidPHPVersion
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pool, err := readPHPPoolFile(filepath.Join(s.phpPoolsPath(accountID), version+".json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return pool, err
+}
+
+// readPHPPoolFile parses one pool record
+func readPHPPoolFile(path string) (*models.PHPPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var pool models.PHPPool
+	if err := json.Unmarshal(data, &pool); err != nil {
+		return nil, fmt.Errorf("failed to parse pool %s: %w", filepath.Base(path), err)
+	}
+	return &pool, nil
+}
+
+// WritePHPPool writes a pool record atomically
+func (s *StateManager) WritePHPPool(pool *models.PHPPool) error {
+	if !validPHPVersions[pool.Version] {
+		return ErrInvalidPHPVersion
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dir := s.phpPoolsPath(pool.AccountID)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return fmt.Errorf("failed to create pool directory: %w", err)
+	}
+	return s.atomicWrite(filepath.Join(dir, pool.Version+".json"), pool)
+}
+
+// DeletePHPPool removes a pool record
+func (s *StateManager) DeletePHPPool(accountID int, version string) error {
+	if !validPHPVersions[version] {
+		return ErrInvalidPHPVersion
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := os.Remove(filepath.Join(s.phpPoolsPath(accountID), version+".json"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// EnsurePHPPool makes sure the account has a pool for version, creating the
+// default record on first use, and queues its config in batch
+func (a *Applier) EnsurePHPPool(accountID int, version string, batch *PoolBatch) error {
+	pool, err := a.state.ReadPHPPool(accountID, version)
+	if err != nil {
+		return err
+	}
+
+	if pool == nil {
+		identity, err := account.NewStateManager().ReadIdentity(accountID)
+		if err != nil {
+			return fmt.Errorf("failed to read account identity: %w", err)
+		}
+		pool = DefaultPHPPool(accountID, identity.Name, version)
+		if err := a.state.WritePHPPool(pool); err != nil {
+			return err
+		}
+	}
+
+	return batch.Write(pool)
+}
+
+// RemoveUnusedPHPPool removes the account's pool for version once none of
+// its sites run on that version anymore
+func (a *Applier) RemoveUnusedPHPPool(accountID int, version string, batch *PoolBatch) error {
+	sites, err := a.state.ListSites(accountID)
+	if err != nil {
+		return err
+	}
+	for _, site := range sites {
+		if site.PHPVersion() == version {
+			return nil
+		}
+	}
+
+	if err := a.state.DeletePHPPool(accountID, version); err != nil {
+		return err
+	}
+	batch.Remove(accountID, version)
+	return nil
+}
+
+// renderPHPPool renders the pool.d configuration of a pool
+func (a *Applier) renderPHPPool(pool *models.PHPPool) (string, error) {
+	if err := ValidatePHPPool(pool); err != nil {
+		return "", err
+	}
+
+	identity, err := account.NewStateManager().ReadIdentity(pool.AccountID)
+	if err != nil {
+		return "", fmt.Errorf("failed to read account identity: %w", err)
+	}
+
+	accountPath := fmt.Sprintf("%s/%s%d", account.BaseAccountPath, account.AccountPrefix, pool.AccountID)
+	openBasedir := []string{accountPath, "/tmp", "/usr/share/php"}
+	for _, path := range pool.OpenBasedir {
+		clean := filepath.Clean(path)
+		if !strings.HasPrefix(clean+"/", accountPath+"/") {
+			return "", fmt.Errorf("open_basedir path %s is outside the account", path)
+		}
+		openBasedir = append(openBasedir, clean)
+	}
+
+	type iniEntry struct{ Key, Value string }
+	ini := make([]iniEntry, 0, len(pool.INIOverrides))
+	for k, v := range pool.INIOverrides {
+		ini = append(ini, iniEntry{k, v})
+	}
+	sort.Slice(ini, func(i, j int) bool { return ini[i].Key < ini[j].Key })
+
+	data := struct {
+		*models.PHPPool
+		User             string
+		Group            string
+		Socket           string
+		AccountPath      string
+		OpenBasedir      string
+		DisableFunctions string
+		INI              []iniEntry
+	}{
+		PHPPool:          pool,
+		User:             identity.Name,
+		Group:            identity.Name,
+		Socket:           PHPSocketPath(pool.AccountID, pool.Version),
+		AccountPath:      accountPath,
+		OpenBasedir:      strings.Join(openBasedir, ":"),
+		DisableFunctions: strings.Join(append(append([]string{}, baselineDisabledFunctions...), pool.DisableFunctions...), ","),
+		INI:              ini,
+	}
+
+	var buf strings.Builder
+	if err := a.phpFpmTemplate.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// PoolBatch collects pool changes so each PHP version is validated and
+// reloaded once, however many pools changed
+type PoolBatch struct {
+	applier  *Applier
+	previous map[string][]byte // Config content before the batch; nil when absent
+	versions map[string][]string
+}
+
+// NewPoolBatch starts a batch of pool changes
+func (a *Applier) NewPoolBatch() *PoolBatch {
+	return &PoolBatch{
+		applier:  a,
+		previous: make(map[string][]byte),
+		versions: make(map[string][]string),
+	}
+}
+
+// remember records a config file's content before its first change
+func (b *PoolBatch) remember(version, path string) {
+	if _, seen := b.previous[path]; seen {
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		data = nil
+	}
+	b.previous[path] = data
+	b.versions[version] = append(b.versions[version], path)
+}
+
+// Write renders a pool into pool.d. Per-site pool files from older
+// releases share the account socket, so they are removed as well.
+func (b *PoolBatch) Write(pool *models.PHPPool) error {
+	config, err := b.applier.renderPHPPool(pool)
+	if err != nil {
+		return err
+	}
+
+	path := PHPPoolConfigPath(pool.AccountID, pool.Version)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	legacy, _ := filepath.Glob(filepath.Join(filepath.Dir(path), fmt.Sprintf("a-%d-*.conf", pool.AccountID)))
+	for _, old := range legacy {
+		b.remember(pool.Version, old)
+		os.Remove(old)
+	}
+
+	b.remember(pool.Version, path)
+	if err := writeFileAtomic(path, []byte(config), 0644); err != nil {
+		return err
+	}
+
+	// Workers run as the account and cannot create files in the root-owned
+	// logs directory, so the error log is created up front
+	logPath := fmt.Sprintf("%s/%s%d/logs/php-error.log", account.BaseAccountPath, account.AccountPrefix, pool.AccountID)
+	if f, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640); err == nil {
+		f.Close()
+		if uid, gid, ok := accountOwner(pool.AccountID); ok {
+			os.Chown(logPath, uid, gid)
+		}
+	}
+	return nil
+}
+
+// Remove deletes an account's pool config for version
+func (b *PoolBatch) Remove(accountID int, version string) {
+	path := PHPPoolConfigPath(accountID, version)
+	b.remember(version, path)
+	os.Remove(path)
+}
+
+// Commit tests every PHP version touched by the batch with php-fpm -t and
+// reloads it. A version failing the test gets its previous pool files back
+// and is not reloaded, so a bad pool never reaches the running master.
+func (b *PoolBatch) Commit() error {
+	versions := make([]string, 0, len(b.versions))
+	for version := range b.versions {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+
+	var errs []string
+	for _, version := range versions {
+		if err := testPHPFpm(version); err != nil {
+			b.restore(version)
+			errs = append(errs, err.Error())
+			continue
+		}
+		if err := b.applier.ReloadPHPFpm(version); err != nil {
+			errs = append(errs, fmt.Sprintf("php-fpm %s reload failed: %v", version, err))
+		}
+	}
+
+	b.previous = make(map[string][]byte)
+	b.versions = make(map[string][]string)
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// restore puts back the pool files of version as they were before the batch
+func (b *PoolBatch) restore(version string) {
+	for _, path := range b.versions[version] {
+		if data := b.previous[path]; data != nil {
+			writeFileAtomic(path, data, 0644)
+		} else {
+			os.Remove(path)
+		}
+	}
+}
+
+// testPHPFpm checks the complete FPM configuration of a PHP version
+func testPHPFpm(version string) error {
+	binary := ""
+	for _, name := range []string{"php-fpm" + version, "/usr/sbin/php-fpm" + version, "php-fpm"} {
+		if path, err := exec.LookPath(name); err == nil {
+			binary = path
+			break
+		}
+	}
+	if binary == "" {
+		return fmt.Errorf("php-fpm %s is not installed", version)
+	}
+
+	out, err := exec.Command(binary, "-t").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("php-fpm %s config test failed: %s", version, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to path through a temp file and rename
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package web
+
+import (
+	"testing"
+
+	"github.com/iSundram/OweHost/pkg/models"
+)
+
+func TestValidatePHPPool(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(pool *models.PHPPool)
+		valid  bool
+	}{
+		{"default", func(pool *models.PHPPool) {}, true},
+		{"static", func(pool *models.PHPPool) { pool.PMMode = models.PHPPMStatic }, true},
+		{"ondemand", func(pool *models.PHPPool) { pool.PMMode = models.PHPPMOndemand }, true},
+		{"ondemand without idle timeout", func(pool *models.PHPPool) {
+			pool.PMMode, pool.ProcessIdleTimeout = models.PHPPMOndemand, 0
+		}, false},
+		{"unknown pm mode", func(pool *models.PHPPool) { pool.PMMode = "adaptive" }, false},
+		{"unknown version", func(pool *models.PHPPool) { pool.Version = "5.2" }, false},
+		{"no children", func(pool *models.PHPPool) { pool.MaxChildren = 0 }, false},
+		{"spare servers above max children", func(pool *models.PHPPool) { pool.MaxSpareServers = 11 }, false},
+		{"start below min spare", func(pool *models.PHPPool) { pool.StartServers, pool.MinSpareServers = 1, 2 }, false},
+		{"negative max requests", func(pool *models.PHPPool) { pool.MaxRequests = -1 }, false},
+		{"terminate timeout too long", func(pool *models.PHPPool) { pool.RequestTerminateTimeout = 86401 }, false},
+		{"open_basedir relative", func(pool *models.PHPPool) { pool.OpenBasedir = []string{"tmp"} }, false},
+		{"open_basedir separator", func(pool *models.PHPPool) { pool.OpenBasedir = []string{"/srv/accounts/a-1:/"} }, false},
+		{"disable function", func(pool *models.PHPPool) { pool.DisableFunctions = []string{"mail"} }, true},
+		{"disable function injection", func(pool *models.PHPPool) { pool.DisableFunctions = []string{"mail\nphp_admin_value"} }, false},
+		{"extension", func(pool *models.PHPPool) { pool.Extensions = []string{"intl"} }, true},
+		{"extension path", func(pool *models.PHPPool) { pool.Extensions = []string{"../evil.so"} }, false},
+		{"ini override", func(pool *models.PHPPool) { pool.INIOverrides["memory_limit"] = "256M" }, true},
+		{"ini value newline", func(pool *models.PHPPool) { pool.INIOverrides["memory_limit"] = "256M\npm = static" }, false},
+		{"ini value quote", func(pool *models.PHPPool) { pool.INIOverrides["memory_limit"] = `256M"` }, false},
+		{"ini key injection", func(pool *models.PHPPool) { pool.INIOverrides["memory_limit]"] = "256M" }, false},
+	}
+	for _, tt := range tests {
+		pool := DefaultPHPPool(1001, "usr-1", "8.2")
+		tt.modify(pool)
+		if err := ValidatePHPPool(pool); (err == nil) != tt.valid {
+			t.Errorf("%s: expected valid=%v, got %v", tt.name, tt.valid, err)
+		}
+	}
+}
+
+func TestValidatePHPPool_ProtectedINIKeys(t *testing.T) {
+	keys := []string{
+		"open_basedir",
+		"disable_functions",
+		"disable_classes",
+		"extension",
+		"zend_extension",
+		"extension_dir",
+		"error_log",
+		"upload_tmp_dir",
+		"session.save_path",
+		"sendmail_path",
+		"mail.force_extra_parameters",
+		"auto_prepend_file",
+		"auto_append_file",
+	}
+	for _, key := range keys {
+		pool := DefaultPHPPool(1001, "usr-1", "8.2")
+		pool.INIOverrides[key] = "/tmp/x"
+		if err := ValidatePHPPool(pool); err == nil {
+			t.Errorf("Expected %s to be refused as an INI override", key)
+		}
+	}
+}
@@ -0,0 +1,325 @@
+// Package web provides filesystem-based web/site state management
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Directory privacy errors
+var (
+	ErrPathNotProtected = errors.New("path is not protected")
+	ErrAuthUserNotFound = errors.New("user not found")
+)
+
+const defaultRealm = "Restricted"
+
+// htpasswdMu serializes read-modify-write cycles of htpasswd files
+var htpasswdMu sync.Mutex
+
+// nginxProtected is a directory rendered with its own locations because
+// its login or IP rules differ from the rest of the site
+type nginxProtected struct {
+	Pattern    string   // Quoted regex matching the directory
+	PHPPattern string   // Quoted regex matching PHP scripts in it
+	Access     []string // auth_basic and allow/deny directives
+}
+
+// htpasswdPath returns the users file of a protected path. It sits next to
+// the document root, where nginx never serves from.
+func htpasswdPath(sitePath, path string) string {
+	sum := sha256.Sum256([]byte(path))
+	return filepath.Join(sitePath, "auth", hex.EncodeToString(sum[:6])+".htpasswd")
+}
+
+// coversPath reports whether rules for directory parent apply to p
+func coversPath(parent, p string) bool {
+	return parent == "/" || p == parent || strings.HasPrefix(p, parent+"/")
+}
+
+// applyAccess fills in the site's password protection and IP rules. Rules
+// for "/" apply to the whole server. Every other path gets regex
+// locations, which nginx checks before the site's PHP location, so scripts
+// in a protected directory cannot be reached around the login. A directory
+// uses the most specific protected path and allow list that cover it, and
+// the denied addresses of all rules that do.
+func (s *nginxSite) applyAccess(desc *SiteDescriptor) {
+	paths := make(map[string]bool)
+	for _, p := range desc.ProtectedPaths {
+		paths[p.Path] = true
+	}
+	for _, r := range desc.AccessRules {
+		paths[r.Path] = true
+	}
+
+	s.SiteAccess = s.accessFor("/", desc)
+
+	ordered := make([]string, 0, len(paths))
+	for p := range paths {
+		if p != "/" {
+			ordered = append(ordered, p)
+		}
+	}
+	// Regex locations match in order, so nested directories go first
+	sort.Slice(ordered, func(i, j int) bool {
+		if len(ordered[i]) != len(ordered[j]) {
+			return len(ordered[i]) > len(ordered[j])
+		}
+		return ordered[i] < ordered[j]
+	})
+
+	for _, p := range ordered {
+		base := "^" + regexp.QuoteMeta(p)
+		s.ProtectedList = append(s.ProtectedList, nginxProtected{
+			Pattern:    nginxQuote(base + "(/|$)"),
+			PHPPattern: nginxQuote(base + `/.*\.php$`),
+			Access:     s.accessFor(p, desc),
+		})
+	}
+}
+
+// accessFor returns the directives guarding directory p
+func (s *nginxSite) accessFor(p string, desc *SiteDescriptor) []string {
+	var directives []string
+
+	var protected *ProtectedPath
+	for i := range desc.ProtectedPaths {
+		pp := &desc.ProtectedPaths[i]
+		if coversPath(pp.Path, p) && (protected == nil || len(pp.Path) > len(protected.Path)) {
+			protected = pp
+		}
+	}
+	if protected != nil {
+		realm := protected.Realm
+		if realm == "" {
+			realm = defaultRealm
+		}
+		directives = append(directives,
+			"auth_basic "+nginxQuote(realm),
+			"auth_basic_user_file "+nginxQuote(htpasswdPath(s.SitePath, protected.Path)))
+	}
+
+	// Denied addresses of every covering rule apply, since a location's
+	// allow/deny replace the server's. nginx stops at the first match, so
+	// they also win over allowed networks that contain them.
+	var allow *AccessRule
+	denied := make(map[string]bool)
+	for i := range desc.AccessRules {
+		r := &desc.AccessRules[i]
+		if !coversPath(r.Path, p) {
+			continue
+		}
+		for _, entry := range r.Deny {
+			if !denied[entry] {
+				denied[entry] = true
+				directives = append(directives, "deny "+entry)
+			}
+		}
+		if len(r.Allow) > 0 && (allow == nil || len(r.Path) > len(allow.Path)) {
+			allow = r
+		}
+	}
+	if allow != nil {
+		for _, entry := range allow.Allow {
+			directives = append(directives, "allow "+entry)
+		}
+		directives = append(directives, "deny all")
+	}
+	return directives
+}
+
+// ProtectPath adds a password-protected directory to a site, or changes the
+// realm of one. Until users are added nobody can log in.
+func (a *Applier) ProtectPath(accountID int, site *SiteDescriptor, protected ProtectedPath) error {
+	if err := ValidateProtectedPath(&protected); err != nil {
+		return err
+	}
+
+	file := htpasswdPath(a.state.SitePath(accountID, site.Domain), protected.Path)
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	// nginx fails requests whose users file is missing
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_RDONLY, 0644)
+	if err != nil {
+		return err
+	}
+	f.Close()
+
+	found := false
+	for i := range site.ProtectedPaths {
+		if site.ProtectedPaths[i].Path == protected.Path {
+			site.ProtectedPaths[i] = protected
+			found = true
+		}
+	}
+	if !found {
+		site.ProtectedPaths = append(site.ProtectedPaths, protected)
+	}
+	return a.ReconfigureSite(accountID, site)
+}
+
+// UnprotectPath removes a protected directory and its users
+func (a *Applier) UnprotectPath(accountID int, site *SiteDescriptor, path string) error {
+	kept := site.ProtectedPaths[:0:0]
+	for _, p := range site.ProtectedPaths {
+		if p.Path != path {
+			kept = append(kept, p)
+		}
+	}
+	if len(kept) == len(site.ProtectedPaths) {
+		return ErrPathNotProtected
+	}
+	site.ProtectedPaths = kept
+
+	if err := a.ReconfigureSite(accountID, site); err != nil {
+		return err
+	}
+	os.Remove(htpasswdPath(a.state.SitePath(accountID, site.Domain), path))
+	return nil
+}
+
+// SetAccessRules replaces a site's IP allow/deny rules
+func (a *Applier) SetAccessRules(accountID int, site *SiteDescriptor, rules []AccessRule) error {
+	site.AccessRules = rules
+	return a.ReconfigureSite(accountID, site)
+}
+
+// AuthUsers lists the users of a protected directory
+func (a *Applier) AuthUsers(accountID int, site *SiteDescriptor, path string) ([]string, error) {
+	if !site.isProtected(path) {
+		return nil, ErrPathNotProtected
+	}
+
+	htpasswdMu.Lock()
+	defer htpasswdMu.Unlock()
+
+	entries, err := readHtpasswd(htpasswdPath(a.state.SitePath(accountID, site.Domain), path))
+	if err != nil {
+		return nil, err
+	}
+	users := make([]string, 0, len(entries))
+	for _, e := range entries {
+		users = append(users, e[0])
+	}
+	return users, nil
+}
+
+// SetAuthUser adds a user to a protected directory or changes its password.
+// nginx reads the file on every request, so no reload is needed.
+func (a *Applier) SetAuthUser(accountID int, site *SiteDescriptor, path, username, password string) error {
+	if !site.isProtected(path) {
+		return ErrPathNotProtected
+	}
+	if err := ValidateAuthUser(username, password); err != nil {
+		return err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	htpasswdMu.Lock()
+	defer htpasswdMu.Unlock()
+
+	file := htpasswdPath(a.state.SitePath(accountID, site.Domain), path)
+	entries, err := readHtpasswd(file)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for i := range entries {
+		if entries[i][0] == username {
+			entries[i][1] = string(hash)
+			found = true
+		}
+	}
+	if !found {
+		entries = append(entries, [2]string{username, string(hash)})
+	}
+	return writeHtpasswd(file, entries)
+}
+
+// DeleteAuthUser removes a user from a protected directory
+func (a *Applier) DeleteAuthUser(accountID int, site *SiteDescriptor, path, username string) error {
+	if !site.isProtected(path) {
+		return ErrPathNotProtected
+	}
+
+	htpasswdMu.Lock()
+	defer htpasswdMu.Unlock()
+
+	file := htpasswdPath(a.state.SitePath(accountID, site.Domain), path)
+	entries, err := readHtpasswd(file)
+	if err != nil {
+		return err
+	}
+
+	kept := entries[:0]
+	for _, e := range entries {
+		if e[0] != username {
+			kept = append(kept, e)
+		}
+	}
+	if len(kept) == len(entries) {
+		return ErrAuthUserNotFound
+	}
+	return writeHtpasswd(file, kept)
+}
+
+// isProtected reports whether path is one of the site's protected paths
+func (s *SiteDescriptor) isProtected(path string) bool {
+	for _, p := range s.ProtectedPaths {
+		if p.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// readHtpasswd reads user:hash entries. A missing file has no users.
+func readHtpasswd(file string) ([][2]string, error) {
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries [][2]string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		user, hash, ok := strings.Cut(scanner.Text(), ":")
+		if ok && user != "" {
+			entries = append(entries, [2]string{user, hash})
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// writeHtpasswd replaces a users file. It holds only bcrypt hashes and
+// must be readable by the nginx workers.
+func writeHtpasswd(file string, entries [][2]string) error {
+	var buf bytes.Buffer
+	for _, e := range entries {
+		buf.WriteString(e[0] + ":" + e[1] + "\n")
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(file, buf.Bytes(), 0644)
+}
@@ -0,0 +1,48 @@
+// Package web provides filesystem-based web/site state management
+package web
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// nginxLimitZone is a limit_req_zone or limit_conn_zone declaration
+type nginxLimitZone struct {
+	Kind string // req or conn
+	Key  string
+	Zone string
+	Rate int // Requests per minute, req zones only
+}
+
+// applyRateLimits fills in the site's rate limits. Each rule gets zones
+// keyed by a map that yields the client address only for the rule's path;
+// nginx does not account requests with an empty key, so the limits can be
+// set for the whole server without touching its locations.
+func (s *nginxSite) applyRateLimits(accountID int, limits []RateLimit) {
+	prefix := siteVarPrefix(accountID, s.Domain)
+
+	for i, limit := range limits {
+		key := nginxMap{
+			Source:   "$uri",
+			Name:     fmt.Sprintf("%s_limit%d", prefix, i),
+			Value:    "$binary_remote_addr",
+			Patterns: []string{prefixPattern(limit.Path)},
+		}
+		s.Maps = append(s.Maps, key)
+
+		if limit.Rate > 0 {
+			zone := fmt.Sprintf("%s_req%d", prefix, i)
+			s.LimitZones = append(s.LimitZones, nginxLimitZone{Kind: "req", Key: "$" + key.Name, Zone: zone, Rate: limit.Rate})
+			directive := "zone=" + zone
+			if limit.Burst > 0 {
+				directive += " burst=" + strconv.Itoa(limit.Burst) + " nodelay"
+			}
+			s.LimitReq = append(s.LimitReq, directive)
+		}
+		if limit.Connections > 0 {
+			zone := fmt.Sprintf("%s_conn%d", prefix, i)
+			s.LimitZones = append(s.LimitZones, nginxLimitZone{Kind: "conn", Key: "$" + key.Name, Zone: zone})
+			s.LimitConn = append(s.LimitConn, zone+" "+strconv.Itoa(limit.Connections))
+		}
+	}
+}
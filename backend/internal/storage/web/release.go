@@ -0,0 +1,465 @@
+// Package web provides filesystem-based web/site state management
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/iSundram/OweHost/internal/storage/account"
+)
+
+// DefaultKeepReleases is the number of releases kept when a site does not set one
+const DefaultKeepReleases = 5
+
+// Release errors
+var (
+	ErrReleasesDisabled  = errors.New("release-based deploys are not enabled for this site")
+	ErrReleaseNotFound   = errors.New("release not found")
+	ErrNoPreviousRelease = errors.New("no previous release to roll back to")
+)
+
+// ReleasesPath returns the directory holding a site's releases
+func (s *StateManager) ReleasesPath(accountID int, domain string) string {
+	return filepath.Join(s.SitePath(accountID, domain), "releases")
+}
+
+// SharedPath returns the directory holding files shared by every release
+func (s *StateManager) SharedPath(accountID int, domain string) string {
+	return filepath.Join(s.SitePath(accountID, domain), "shared")
+}
+
+// CurrentPath returns the symlink that points at the active release
+func (s *StateManager) CurrentPath(accountID int, domain string) string {
+	return filepath.Join(s.SitePath(accountID, domain), "current")
+}
+
+// ReadReleases reads the release history of a site, oldest first
+func (s *StateManager) ReadReleases(accountID int, domain string) ([]Release, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.readReleases(accountID, domain)
+}
+
+// readReleases reads releases.json. Callers hold s.mu.
+func (s *StateManager) readReleases(accountID int, domain string) ([]Release, error) {
+	data, err := os.ReadFile(filepath.Join(s.SitePath(accountID, domain), "releases.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Release{}, nil
+		}
+		return nil, fmt.Errorf("failed to read releases.json: %w", err)
+	}
+
+	var releases []Release
+	if err := json.Unmarshal(data, &releases); err != nil {
+		return nil, fmt.Errorf("failed to parse releases.json: %w", err)
+	}
+	return releases, nil
+}
+
+// CreateRelease makes a fresh, account-owned directory for a new release and
+// returns its ID and path
+func (s *StateManager) CreateRelease(accountID int, domain string) (string, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	releasesPath := s.ReleasesPath(accountID, domain)
+	if err := os.MkdirAll(releasesPath, 0755); err != nil {
+		return "", "", fmt.Errorf("failed to create releases directory: %w", err)
+	}
+	s.chownTree(accountID, releasesPath)
+
+	// Mkdir rather than MkdirAll: a release directory is never reused, so a
+	// failed deploy cannot clean up a directory that is being served
+	now := time.Now().UTC()
+	id := fmt.Sprintf("%s%06d", now.Format("20060102150405"), now.Nanosecond()/1000)
+	dir := filepath.Join(releasesPath, id)
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return "", "", fmt.Errorf("failed to create release directory: %w", err)
+	}
+	s.chownTree(accountID, dir)
+
+	return id, dir, nil
+}
+
+// DiscardRelease removes a release that was never activated
+func (s *StateManager) DiscardRelease(accountID int, domain, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if target, err := os.Readlink(s.CurrentPath(accountID, domain)); err == nil && filepath.Base(target) == id {
+		return errors.New("cannot discard the active release")
+	}
+	return os.RemoveAll(filepath.Join(s.ReleasesPath(accountID, domain), id))
+}
+
+// ActivateRelease links the shared paths into a release, atomically points
+// the current symlink at it and prunes releases beyond the keep limit
+func (s *StateManager) ActivateRelease(accountID int, domain string, release Release, settings *ReleaseSettings) (*Release, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if settings == nil {
+		settings = &ReleaseSettings{}
+	}
+
+	dir := filepath.Join(s.ReleasesPath(accountID, domain), release.ID)
+	if info, err := os.Lstat(dir); err != nil || !info.IsDir() || release.ID == "" || filepath.Base(dir) != release.ID {
+		return nil, ErrReleaseNotFound
+	}
+
+	releases, err := s.readReleases(accountID, domain)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.linkShared(accountID, domain, dir, settings.SharedPaths); err != nil {
+		return nil, err
+	}
+
+	// A relative target keeps the link valid if the account directory moves
+	current := s.CurrentPath(accountID, domain)
+	tmpLink := current + ".tmp"
+	os.Remove(tmpLink)
+	if err := os.Symlink(filepath.Join("releases", release.ID), tmpLink); err != nil {
+		return nil, fmt.Errorf("failed to create release link: %w", err)
+	}
+	s.chownPath(accountID, tmpLink)
+	if err := os.Rename(tmpLink, current); err != nil {
+		os.Remove(tmpLink)
+		return nil, fmt.Errorf("failed to switch current release: %w", err)
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	var activated *Release
+	for i := range releases {
+		releases[i].Active = releases[i].ID == release.ID
+		if releases[i].Active {
+			activated = &releases[i]
+		}
+	}
+	if activated == nil {
+		release.Active = true
+		if release.CreatedAt == "" {
+			release.CreatedAt = now
+		}
+		releases = append(releases, release)
+		activated = &releases[len(releases)-1]
+	}
+	activated.ActivatedAt = now
+	result := *activated
+
+	keep := settings.Keep
+	if keep <= 0 {
+		keep = DefaultKeepReleases
+	}
+	releases = s.pruneReleases(accountID, domain, releases, keep)
+
+	if err := s.atomicWrite(filepath.Join(s.SitePath(accountID, domain), "releases.json"), releases); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// pruneReleases removes the oldest inactive releases beyond keep and returns
+// the remaining history. Callers hold s.mu.
+func (s *StateManager) pruneReleases(accountID int, domain string, releases []Release, keep int) []Release {
+	excess := len(releases) - keep
+	if excess <= 0 {
+		return releases
+	}
+
+	kept := make([]Release, 0, keep)
+	for _, r := range releases {
+		if excess > 0 && !r.Active {
+			os.RemoveAll(filepath.Join(s.ReleasesPath(accountID, domain), r.ID))
+			excess--
+			continue
+		}
+		kept = append(kept, r)
+	}
+	return kept
+}
+
+// linkShared replaces each shared path in a release with a symlink into
+// shared/. A path the release ships but shared/ lacks seeds shared/ on first
+// deploy; otherwise missing entries start empty, as a file when the name has a
+// dot (".env", "wp-config.php") and as a directory otherwise ("uploads").
+// Callers hold s.mu.
+func (s *StateManager) linkShared(accountID int, domain, releaseDir string, paths []string) error {
+	sharedRoot := s.SharedPath(accountID, domain)
+	if err := os.MkdirAll(sharedRoot, 0755); err != nil {
+		return fmt.Errorf("failed to create shared directory: %w", err)
+	}
+	s.chownPath(accountID, sharedRoot)
+
+	for _, rel := range paths {
+		if !filepath.IsLocal(rel) {
+			return ErrInvalidSharedPath
+		}
+		shared := filepath.Join(sharedRoot, rel)
+		target := filepath.Join(releaseDir, rel)
+
+		// Release and shared contents are account-controlled, so never
+		// follow a symlinked parent out of the site while running as root
+		if err := checkNoSymlinks(releaseDir, filepath.Dir(target)); err != nil {
+			return err
+		}
+		if err := checkNoSymlinks(sharedRoot, filepath.Dir(shared)); err != nil {
+			return err
+		}
+
+		if _, err := os.Lstat(shared); os.IsNotExist(err) {
+			if err := os.MkdirAll(filepath.Dir(shared), 0755); err != nil {
+				return fmt.Errorf("failed to create shared path %s: %w", rel, err)
+			}
+			if _, err := os.Lstat(target); err == nil {
+				if err := os.Rename(target, shared); err != nil {
+					return fmt.Errorf("failed to seed shared path %s: %w", rel, err)
+				}
+			} else if strings.Contains(filepath.Base(rel), ".") {
+				if err := os.WriteFile(shared, nil, 0640); err != nil {
+					return fmt.Errorf("failed to create shared file %s: %w", rel, err)
+				}
+			} else if err := os.Mkdir(shared, 0755); err != nil {
+				return fmt.Errorf("failed to create shared directory %s: %w", rel, err)
+			}
+			s.chownTree(accountID, sharedRoot)
+		}
+
+		if err := os.RemoveAll(target); err != nil {
+			return fmt.Errorf("failed to replace %s with shared copy: %w", rel, err)
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		link, err := filepath.Rel(filepath.Dir(target), shared)
+		if err != nil {
+			return err
+		}
+		if err := os.Symlink(link, target); err != nil {
+			return fmt.Errorf("failed to link shared path %s: %w", rel, err)
+		}
+		s.chownPath(accountID, target)
+	}
+
+	return nil
+}
+
+// checkNoSymlinks fails when any existing directory between root and dir is a symlink
+func checkNoSymlinks(root, dir string) error {
+	rel, err := filepath.Rel(root, dir)
+	if err != nil || !filepath.IsLocal(rel) {
+		return ErrInvalidSharedPath
+	}
+	if rel == "." {
+		return nil
+	}
+
+	path := root
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		path = filepath.Join(path, part)
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("shared path parent %s is a symlink", path)
+		}
+	}
+	return nil
+}
+
+// chownTree gives path and everything below it to the account user. Symlinks
+// are changed themselves, never followed.
+func (s *StateManager) chownTree(accountID int, path string) {
+	uid, gid, ok := accountOwner(accountID)
+	if !ok {
+		return
+	}
+	filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err == nil {
+			os.Lchown(p, uid, gid)
+		}
+		return nil
+	})
+}
+
+// chownPath gives a single path to the account user
+func (s *StateManager) chownPath(accountID int, path string) {
+	if uid, gid, ok := accountOwner(accountID); ok {
+		os.Lchown(path, uid, gid)
+	}
+}
+
+// accountOwner returns the account's UID and GID when running as root
+func accountOwner(accountID int) (int, int, bool) {
+	if os.Geteuid() != 0 {
+		return 0, 0, false
+	}
+	identity, err := account.NewStateManager().ReadIdentity(accountID)
+	if err != nil {
+		return 0, 0, false
+	}
+	return identity.UID, identity.GID, true
+}
+
+// DeployRelease publishes a new release of a release-based site. populate
+// fills the empty release directory; the live site is untouched unless it
+// succeeds.
+func (a *Applier) DeployRelease(accountID int, site *SiteDescriptor, source, ref string, populate func(dir string) error) (*Release, error) {
+	if !site.ReleasesEnabled() {
+		return nil, ErrReleasesDisabled
+	}
+
+	id, dir, err := a.state.CreateRelease(accountID, site.Domain)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := populate(dir); err != nil {
+		a.state.DiscardRelease(accountID, site.Domain, id)
+		return nil, err
+	}
+	a.state.chownTree(accountID, dir)
+
+	release, err := a.state.ActivateRelease(accountID, site.Domain, Release{ID: id, Source: source, Ref: ref}, site.Releases)
+	if err != nil {
+		a.state.DiscardRelease(accountID, site.Domain, id)
+		return nil, err
+	}
+	return release, nil
+}
+
+// RollbackRelease reactivates releaseID, or the release deployed before the
+// active one when releaseID is empty
+func (a *Applier) RollbackRelease(accountID int, site *SiteDescriptor, releaseID string) (*Release, error) {
+	if !site.ReleasesEnabled() {
+		return nil, ErrReleasesDisabled
+	}
+
+	releases, err := a.state.ReadReleases(accountID, site.Domain)
+	if err != nil {
+		return nil, err
+	}
+
+	active := len(releases)
+	for i, r := range releases {
+		if r.Active {
+			active = i
+		}
+	}
+
+	var target *Release
+	if releaseID == "" {
+		if active == 0 || len(releases) == 0 {
+			return nil, ErrNoPreviousRelease
+		}
+		target = &releases[active-1]
+	} else {
+		for i := range releases {
+			if releases[i].ID == releaseID {
+				target = &releases[i]
+			}
+		}
+		if target == nil {
+			return nil, ErrReleaseNotFound
+		}
+	}
+
+	return a.state.ActivateRelease(accountID, site.Domain, *target, site.Releases)
+}
+
+// ConfigureReleases changes a site's release settings and regenerates its
+// nginx config. Enabling releases on a site that already has content turns
+// the existing document root into the first release, and leaves a symlink at
+// the old location so requests keep being served until nginx reloads.
+func (a *Applier) ConfigureReleases(accountID int, site *SiteDescriptor, settings *ReleaseSettings) error {
+	if err := ValidateReleaseSettings(settings); err != nil {
+		return err
+	}
+
+	sitePath := a.state.SitePath(accountID, site.Domain)
+	wasEnabled := site.ReleasesEnabled()
+	oldDocPath := site.DocumentPath(sitePath)
+	site.Releases = settings
+
+	if settings.Enabled && !wasEnabled {
+		if _, err := os.Lstat(a.state.CurrentPath(accountID, site.Domain)); os.IsNotExist(err) {
+			if err := a.importDocumentRoot(accountID, site, oldDocPath); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := a.state.WriteSite(accountID, site); err != nil {
+		return fmt.Errorf("failed to write site: %w", err)
+	}
+	if err := a.GenerateSiteConfig(accountID, site); err != nil {
+		return fmt.Errorf("failed to generate web server config: %w", err)
+	}
+	return nil
+}
+
+// importDocumentRoot moves an existing document root into a new release
+func (a *Applier) importDocumentRoot(accountID int, site *SiteDescriptor, docPath string) error {
+	info, err := os.Lstat(docPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("document root %s is not a directory", docPath)
+	}
+
+	// The site directory itself holds site.json, logs and the releases
+	docRoot := filepath.Clean(site.DocumentRoot)
+	if site.DocumentRoot == "" {
+		docRoot = "public"
+	}
+	if docRoot == "." {
+		return errors.New("cannot import a document root that is the site directory")
+	}
+
+	id, dir, err := a.state.CreateRelease(accountID, site.Domain)
+	if err != nil {
+		return err
+	}
+
+	newDocPath := filepath.Join(dir, docRoot)
+	if err := os.MkdirAll(filepath.Dir(newDocPath), 0755); err != nil {
+		a.state.DiscardRelease(accountID, site.Domain, id)
+		return err
+	}
+	if err := os.Rename(docPath, newDocPath); err != nil {
+		a.state.DiscardRelease(accountID, site.Domain, id)
+		return fmt.Errorf("failed to move document root into release: %w", err)
+	}
+	a.state.chownTree(accountID, dir)
+
+	if _, err := a.state.ActivateRelease(accountID, site.Domain, Release{ID: id, Source: "initial"}, site.Releases); err != nil {
+		os.Rename(newDocPath, docPath)
+		a.state.DiscardRelease(accountID, site.Domain, id)
+		return err
+	}
+
+	// nginx still serves the old path until it reloads
+	link, err := filepath.Rel(filepath.Dir(docPath), site.DocumentPath(a.state.SitePath(accountID, site.Domain)))
+	if err == nil {
+		os.Symlink(link, docPath)
+		a.state.chownPath(accountID, docPath)
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package web
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// archiveEntry is one file, directory or symlink in a test archive
+type archiveEntry struct {
+	name, body, link string
+	dir              bool
+}
+
+// writeTarGz writes entries as a gzipped tar and returns its path
+func writeTarGz(t *testing.T, entries []archiveEntry) string {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.body)), Typeflag: tar.TypeReg}
+		switch {
+		case e.dir:
+			hdr.Typeflag, hdr.Mode, hdr.Size = tar.TypeDir, 0755, 0
+		case e.link != "":
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeSymlink, e.link, 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			tw.Write([]byte(e.body))
+		}
+	}
+	tw.Close()
+	gz.Close()
+
+	path := filepath.Join(t.TempDir(), "release.tar.gz")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// writeZip writes regular files as a zip and returns its path
+func writeZip(t *testing.T, entries []archiveEntry) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		w, err := zw.Create(e.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(e.body))
+	}
+	zw.Close()
+
+	path := filepath.Join(t.TempDir(), "release.zip")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExtractArchive_StripsSingleRoot(t *testing.T) {
+	archive := writeTarGz(t, []archiveEntry{
+		{name: "site-main/", dir: true},
+		{name: "site-main/index.php", body: "<?php echo 1;"},
+		{name: "site-main/site-main/nested.txt", body: "same name as the root"},
+		{name: "site-main/passwd", link: "/etc/passwd"},
+	})
+	dest := t.TempDir()
+
+	if err := ExtractArchive(archive, dest); err != nil {
+		t.Fatalf("Failed to extract: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dest, "index.php")); err != nil || string(data) != "<?php echo 1;" {
+		t.Errorf("Expected index.php at the release root, got %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "site-main", "nested.txt")); err != nil {
+		t.Errorf("Expected a child named like the root to survive: %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(dest, "passwd")); !os.IsNotExist(err) {
+		t.Errorf("Expected symlinks to be skipped, got %v", err)
+	}
+}
+
+func TestExtractArchive_Rejects(t *testing.T) {
+	plain := filepath.Join(t.TempDir(), "release.txt")
+	if err := os.WriteFile(plain, []byte("not an archive"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		archive string
+	}{
+		{"tar parent escape", writeTarGz(t, []archiveEntry{{name: "../escape.txt", body: "x"}})},
+		{"tar absolute path", writeTarGz(t, []archiveEntry{{name: "/etc/cron.d/evil", body: "x"}})},
+		{"zip parent escape", writeZip(t, []archiveEntry{{name: "app/../../escape.txt", body: "x"}})},
+		{"not an archive", plain},
+	}
+	for _, tt := range tests {
+		parent := t.TempDir()
+		dest := filepath.Join(parent, "release")
+		if err := os.Mkdir(dest, 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ExtractArchive(tt.archive, dest); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+		if _, err := os.Stat(filepath.Join(parent, "escape.txt")); !os.IsNotExist(err) {
+			t.Errorf("%s: expected nothing written outside the release", tt.name)
+		}
+	}
+
+	if err := ExtractArchive(plain, t.TempDir()); !errors.Is(err, ErrUnsupportedArchive) {
+		t.Errorf("Expected ErrUnsupportedArchive, got %v", err)
+	}
+}
+
+func TestExtractArchive_Zip(t *testing.T) {
+	archive := writeZip(t, []archiveEntry{
+		{name: "index.html", body: "<h1>hi</h1>"},
+		{name: "assets/app.js", body: "console.log(1)"},
+	})
+	dest := t.TempDir()
+
+	if err := ExtractArchive(archive, dest); err != nil {
+		t.Fatalf("Failed to extract: %v", err)
+	}
+	for _, name := range []string{"index.html", "assets/app.js"} {
+		if _, err := os.Stat(filepath.Join(dest, name)); err != nil {
+			t.Errorf("Expected %s to be extracted: %v", name, err)
+		}
+	}
+}
+
+func TestValidateReleaseSettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings ReleaseSettings
+		want     error
+	}{
+		{"defaults", ReleaseSettings{}, nil},
+		{"shared paths", ReleaseSettings{Keep: 10, SharedPaths: []string{".env", "wp-content/uploads"}}, nil},
+		{"negative keep", ReleaseSettings{Keep: -1}, ErrInvalidKeep},
+		{"too many releases", ReleaseSettings{Keep: 51}, ErrInvalidKeep},
+		{"absolute", ReleaseSettings{SharedPaths: []string{"/etc"}}, ErrInvalidSharedPath},
+		{"parent", ReleaseSettings{SharedPaths: []string{"../shared"}}, ErrInvalidSharedPath},
+		{"release root", ReleaseSettings{SharedPaths: []string{"./"}}, ErrInvalidSharedPath},
+		{"backslash", ReleaseSettings{SharedPaths: []string{`uploads\..\..`}}, ErrInvalidSharedPath},
+	}
+	for _, tt := range tests {
+		err := ValidateReleaseSettings(&tt.settings)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+func TestCheckNoSymlinks(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "wp-content", "uploads"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/etc", filepath.Join(root, "linked")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		dir string
+		ok  bool
+	}{
+		{root, true},
+		{filepath.Join(root, "wp-content", "uploads"), true},
+		{filepath.Join(root, "wp-content", "missing", "deeper"), true},
+		{filepath.Join(root, "linked"), false},
+		{filepath.Join(root, "linked", "cron.d"), false},
+		{filepath.Dir(root), false},
+	}
+	for _, tt := range tests {
+		if err := checkNoSymlinks(root, tt.dir); (err == nil) != tt.ok {
+			t.Errorf("%s: expected ok=%v, got %v", tt.dir, tt.ok, err)
+		}
+	}
+}
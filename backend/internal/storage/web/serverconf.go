@@ -0,0 +1,431 @@
+// Package web provides filesystem-based web/site state management
+package web
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Web servers whose per-site configuration files the applier manages
+const (
+	ServerNginx  = "nginx"
+	ServerApache = "apache"
+)
+
+// configHistoryRoot keeps every installed version of every config file.
+// It is root-only, unlike the account tree, so a rollback can never install
+// a file an account user has edited.
+const configHistoryRoot = "/var/lib/owehost/config-history"
+
+// Config errors
+var (
+	ErrUnknownServer         = errors.New("unknown web server")
+	ErrInvalidConfigName     = errors.New("invalid config name")
+	ErrConfigVersionNotFound = errors.New("config version not found")
+)
+
+var validConfigName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,200}$`)
+
+// serverMu serializes config changes. The config test covers every file a
+// server loads, so two changes tested concurrently could each see the
+// other's half-applied state.
+var serverMu sync.Mutex
+
+// ConfigVersion is one installed version of a config file
+type ConfigVersion struct {
+	Version   int       `json:"version"`
+	Checksum  string    `json:"checksum"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+	Config    string    `json:"-"`
+}
+
+// serverTarget describes where a web server reads site configs from and
+// how it is tested and reloaded
+type serverTarget struct {
+	name         string
+	availableDir string
+	enabledDir   string
+	test         []string
+	reload       []string
+}
+
+var serverTargets = map[string]*serverTarget{
+	ServerNginx: {
+		name:         ServerNginx,
+		availableDir: "/etc/nginx/sites-available",
+		enabledDir:   "/etc/nginx/sites-enabled",
+		test:         []string{"nginx", "-t"},
+		reload:       []string{"nginx", "-s", "reload"},
+	},
+	ServerApache: {
+		name:         ServerApache,
+		availableDir: "/etc/apache2/sites-available",
+		enabledDir:   "/etc/apache2/sites-enabled",
+		test:         []string{"apache2ctl", "configtest"},
+		reload:       []string{"apache2ctl", "graceful"},
+	},
+}
+
+// lookupTarget resolves a server and config name
+func lookupTarget(server, name string) (*serverTarget, error) {
+	t, ok := serverTargets[server]
+	if !ok {
+		return nil, ErrUnknownServer
+	}
+	if name != "" && (!validConfigName.MatchString(name) || strings.Contains(name, "..")) {
+		return nil, ErrInvalidConfigName
+	}
+	return t, nil
+}
+
+// InstallConfig safely replaces the config file name (without .conf) of a
+// web server. The new file is staged next to the live one and renamed into
+// place, the server's full configuration is tested, and the server is
+// reloaded. The running server only reads its files on reload, so if the
+// test or the reload fails the previous file is restored before anything
+// serves the broken one. Each distinct config installed is kept as a version.
+func (a *Applier) InstallConfig(server, name, config string) error {
+	t, err := lookupTarget(server, name)
+	if err != nil {
+		return err
+	}
+
+	serverMu.Lock()
+	defer serverMu.Unlock()
+
+	if err := t.install(name, []byte(config)); err != nil {
+		return err
+	}
+	return t.recordVersion(name, []byte(config))
+}
+
+// RemoveConfig disables and deletes a config file and reloads the server.
+// Its history is kept.
+func (a *Applier) RemoveConfig(server, name string) error {
+	t, err := lookupTarget(server, name)
+	if err != nil {
+		return err
+	}
+
+	serverMu.Lock()
+	defer serverMu.Unlock()
+
+	available, enabled := t.paths(name)
+	previous, err := os.ReadFile(available)
+	if os.IsNotExist(err) {
+		os.Remove(enabled)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	os.Remove(enabled)
+	if err := os.Remove(available); err != nil {
+		return err
+	}
+	if err := t.testAndReload(); err != nil {
+		t.restore(name, previous, true)
+		return err
+	}
+
+	t.markInactive(name)
+	return nil
+}
+
+// ConfigHistory returns every recorded version of a config file, oldest first
+func (a *Applier) ConfigHistory(server, name string) ([]ConfigVersion, error) {
+	t, err := lookupTarget(server, name)
+	if err != nil {
+		return nil, err
+	}
+
+	serverMu.Lock()
+	defer serverMu.Unlock()
+
+	versions, err := t.readHistory(name)
+	if err != nil {
+		return nil, err
+	}
+	for i := range versions {
+		data, err := os.ReadFile(t.versionPath(name, versions[i].Version))
+		if err != nil {
+			return nil, err
+		}
+		versions[i].Config = string(data)
+	}
+	return versions, nil
+}
+
+// RollbackConfig reinstalls a recorded version of a config file, with the
+// same test-and-restore guarantees as InstallConfig
+func (a *Applier) RollbackConfig(server, name string, version int) error {
+	t, err := lookupTarget(server, name)
+	if err != nil {
+		return err
+	}
+
+	serverMu.Lock()
+	defer serverMu.Unlock()
+
+	data, err := os.ReadFile(t.versionPath(name, version))
+	if os.IsNotExist(err) {
+		return ErrConfigVersionNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := t.install(name, data); err != nil {
+		return err
+	}
+	return t.recordVersion(name, data)
+}
+
+// TestConfig runs the server's configuration test and returns its output
+func (a *Applier) TestConfig(server string) (string, error) {
+	t, err := lookupTarget(server, "")
+	if err != nil {
+		return "", err
+	}
+
+	serverMu.Lock()
+	defer serverMu.Unlock()
+
+	return t.runTest()
+}
+
+// ReloadServer tests and gracefully reloads a web server
+func (a *Applier) ReloadServer(server string) error {
+	t, err := lookupTarget(server, "")
+	if err != nil {
+		return err
+	}
+
+	serverMu.Lock()
+	defer serverMu.Unlock()
+
+	return t.testAndReload()
+}
+
+// ConfigPath returns where a web server's config file name is installed
+func ConfigPath(server, name string) string {
+	t, ok := serverTargets[server]
+	if !ok {
+		return ""
+	}
+	available, _ := t.paths(name)
+	return available
+}
+
+// ConfigDir returns the directory a web server's config files are installed in
+func ConfigDir(server string) string {
+	t, ok := serverTargets[server]
+	if !ok {
+		return ""
+	}
+	return t.availableDir
+}
+
+// paths returns the live and enabled paths of a config file
+func (t *serverTarget) paths(name string) (string, string) {
+	return filepath.Join(t.availableDir, name+".conf"), filepath.Join(t.enabledDir, name+".conf")
+}
+
+// install puts data live and reloads the server, restoring the previous
+// state on failure. Callers hold serverMu.
+func (t *serverTarget) install(name string, data []byte) error {
+	available, enabled := t.paths(name)
+
+	previous, err := os.ReadFile(available)
+	hadPrevious := err == nil
+	_, err = os.Lstat(enabled)
+	wasEnabled := err == nil
+	if hadPrevious && wasEnabled && bytes.Equal(previous, data) {
+		return nil
+	}
+
+	if err := os.MkdirAll(t.availableDir, 0755); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(t.enabledDir, 0755); err != nil {
+		return err
+	}
+
+	// Stage under a name the server does not load, then swap atomically
+	staged := filepath.Join(t.availableDir, "."+name+".conf.tmp")
+	if err := os.WriteFile(staged, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(staged, available); err != nil {
+		os.Remove(staged)
+		return err
+	}
+	if !wasEnabled {
+		if err := os.Symlink(available, enabled); err != nil {
+			t.restore(name, previous, wasEnabled)
+			return err
+		}
+	}
+
+	if err := t.testAndReload(); err != nil {
+		t.restore(name, previous, wasEnabled)
+		return err
+	}
+	return nil
+}
+
+// restore puts a config file back to its state before a failed change and
+// makes sure the server runs that state. previous is nil when the file did
+// not exist.
+func (t *serverTarget) restore(name string, previous []byte, wasEnabled bool) {
+	available, enabled := t.paths(name)
+	if previous != nil {
+		writeFileAtomic(available, previous, 0644)
+	} else {
+		os.Remove(available)
+	}
+	if wasEnabled {
+		if _, err := os.Lstat(enabled); err != nil {
+			os.Symlink(available, enabled)
+		}
+	} else {
+		os.Remove(enabled)
+	}
+
+	// A failed reload may have left the server on neither version
+	if _, err := t.runTest(); err == nil {
+		t.run(t.reload)
+	}
+}
+
+// testAndReload tests the full configuration and reloads the server
+func (t *serverTarget) testAndReload() error {
+	if _, err := t.runTest(); err != nil {
+		return err
+	}
+	if out, err := t.run(t.reload); err != nil {
+		return fmt.Errorf("%s reload failed: %s", t.name, out)
+	}
+	return nil
+}
+
+// runTest runs the configuration test
+func (t *serverTarget) runTest() (string, error) {
+	out, err := t.run(t.test)
+	if err != nil {
+		return out, fmt.Errorf("%s config test failed: %s", t.name, out)
+	}
+	return out, nil
+}
+
+// run executes a server command and returns its trimmed output
+func (t *serverTarget) run(args []string) (string, error) {
+	out, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+	msg := strings.TrimSpace(string(out))
+	if err != nil && msg == "" {
+		msg = err.Error()
+	}
+	return msg, err
+}
+
+// historyDir is where the versions of a config file are kept
+func (t *serverTarget) historyDir(name string) string {
+	return filepath.Join(configHistoryRoot, t.name, name)
+}
+
+func (t *serverTarget) versionPath(name string, version int) string {
+	return filepath.Join(t.historyDir(name), strconv.Itoa(version)+".conf")
+}
+
+// readHistory reads the version index of a config file
+func (t *serverTarget) readHistory(name string) ([]ConfigVersion, error) {
+	data, err := os.ReadFile(filepath.Join(t.historyDir(name), "versions.json"))
+	if os.IsNotExist(err) {
+		return []ConfigVersion{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []ConfigVersion
+	if err := json.Unmarshal(data, &versions); err != nil {
+		return nil, fmt.Errorf("failed to parse config history: %w", err)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+	return versions, nil
+}
+
+// writeHistory writes the version index of a config file
+func (t *serverTarget) writeHistory(name string, versions []ConfigVersion) error {
+	data, err := json.MarshalIndent(versions, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(t.historyDir(name), "versions.json"), data, 0600)
+}
+
+// recordVersion marks data as the active version of a config file. Content
+// seen before reactivates its version, so a rollback does not duplicate it.
+func (t *serverTarget) recordVersion(name string, data []byte) error {
+	if err := os.MkdirAll(t.historyDir(name), 0700); err != nil {
+		return err
+	}
+	versions, err := t.readHistory(name)
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+
+	found := false
+	latest := 0
+	for i := range versions {
+		versions[i].Active = versions[i].Checksum == checksum && !found
+		found = found || versions[i].Active
+		if versions[i].Version > latest {
+			latest = versions[i].Version
+		}
+	}
+
+	if !found {
+		version := ConfigVersion{
+			Version:   latest + 1,
+			Checksum:  checksum,
+			Active:    true,
+			CreatedAt: time.Now(),
+		}
+		if err := writeFileAtomic(t.versionPath(name, version.Version), data, 0600); err != nil {
+			return err
+		}
+		versions = append(versions, version)
+	}
+	return t.writeHistory(name, versions)
+}
+
+// markInactive records that no version of a config file is live
+func (t *serverTarget) markInactive(name string) {
+	versions, err := t.readHistory(name)
+	if err != nil || len(versions) == 0 {
+		return
+	}
+	for i := range versions {
+		versions[i].Active = false
+	}
+	t.writeHistory(name, versions)
+}
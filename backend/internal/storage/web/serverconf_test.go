@@ -0,0 +1,135 @@
+package web
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestTarget returns a server whose config test fails while any enabled
+// config contains "broken", and which logs each reload. A reload fails
+// while the file fail-reload exists.
+func newTestTarget(t *testing.T) (*serverTarget, string) {
+	t.Helper()
+	dir := t.TempDir()
+	enabled := filepath.Join(dir, "enabled")
+	reloads := filepath.Join(dir, "reloads")
+	return &serverTarget{
+		name:         "test",
+		availableDir: filepath.Join(dir, "available"),
+		enabledDir:   enabled,
+		test:         []string{"sh", "-c", `! grep -Rqs broken "$0"`, enabled},
+		reload:       []string{"sh", "-c", `[ ! -e "$0/fail-reload" ] && echo reload >> "$0/reloads"`, dir},
+	}, reloads
+}
+
+// reloadCount returns how often a test server was reloaded
+func reloadCount(t *testing.T, path string) int {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Count(string(data), "reload")
+}
+
+func TestServerTarget_InstallAndRestore(t *testing.T) {
+	target, reloads := newTestTarget(t)
+	available, enabled := target.paths("example.com")
+
+	if err := target.install("example.com", []byte("server { good }")); err != nil {
+		t.Fatalf("Failed to install: %v", err)
+	}
+	if data, _ := os.ReadFile(available); string(data) != "server { good }" {
+		t.Errorf("Expected the config to be installed, got %q", data)
+	}
+	if link, err := os.Readlink(enabled); err != nil || link != available {
+		t.Errorf("Expected the config to be enabled, got %q, %v", link, err)
+	}
+	if n := reloadCount(t, reloads); n != 1 {
+		t.Errorf("Expected 1 reload, got %d", n)
+	}
+
+	// Unchanged configs are not reloaded
+	if err := target.install("example.com", []byte("server { good }")); err != nil {
+		t.Fatalf("Failed to reinstall: %v", err)
+	}
+	if n := reloadCount(t, reloads); n != 1 {
+		t.Errorf("Expected an unchanged config not to reload, got %d reloads", n)
+	}
+
+	if err := target.install("example.com", []byte("server { broken }")); err == nil {
+		t.Fatal("Expected a config that fails its test to be refused")
+	}
+	if data, _ := os.ReadFile(available); string(data) != "server { good }" {
+		t.Errorf("Expected the previous config to be restored, got %q", data)
+	}
+	if _, err := os.Lstat(enabled); err != nil {
+		t.Errorf("Expected the previous config to stay enabled: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(target.availableDir, ".example.com.conf.tmp")); !os.IsNotExist(err) {
+		t.Errorf("Expected no staged file to be left behind, got %v", err)
+	}
+}
+
+func TestServerTarget_InstallNewBrokenConfig(t *testing.T) {
+	target, _ := newTestTarget(t)
+	available, enabled := target.paths("new.example.com")
+
+	if err := target.install("new.example.com", []byte("server { broken }")); err == nil {
+		t.Fatal("Expected a config that fails its test to be refused")
+	}
+	if _, err := os.Stat(available); !os.IsNotExist(err) {
+		t.Errorf("Expected the new config to be removed, got %v", err)
+	}
+	if _, err := os.Lstat(enabled); !os.IsNotExist(err) {
+		t.Errorf("Expected the new config to be disabled, got %v", err)
+	}
+}
+
+func TestServerTarget_ReloadFailureRestores(t *testing.T) {
+	target, reloads := newTestTarget(t)
+	available, _ := target.paths("example.com")
+
+	if err := target.install("example.com", []byte("server { v1 }")); err != nil {
+		t.Fatalf("Failed to install: %v", err)
+	}
+
+	failReload := filepath.Join(filepath.Dir(reloads), "fail-reload")
+	if err := os.WriteFile(failReload, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := target.install("example.com", []byte("server { v2 }")); err == nil {
+		t.Fatal("Expected a failed reload to fail the install")
+	}
+	if data, _ := os.ReadFile(available); string(data) != "server { v1 }" {
+		t.Errorf("Expected the previous config to be restored, got %q", data)
+	}
+}
+
+func TestLookupTarget(t *testing.T) {
+	tests := []struct {
+		server, name string
+		want         error
+	}{
+		{ServerNginx, "example.com", nil},
+		{ServerApache, "sub.example-site.org", nil},
+		{ServerNginx, "", nil},
+		{"lighttpd", "example.com", ErrUnknownServer},
+		{ServerNginx, "../nginx", ErrInvalidConfigName},
+		{ServerNginx, "a..b", ErrInvalidConfigName},
+		{ServerNginx, ".hidden", ErrInvalidConfigName},
+		{ServerNginx, "a/b", ErrInvalidConfigName},
+		{ServerNginx, "a b", ErrInvalidConfigName},
+	}
+	for _, tt := range tests {
+		if _, err := lookupTarget(tt.server, tt.name); !errors.Is(err, tt.want) {
+			t.Errorf("%s %q: expected %v, got %v", tt.server, tt.name, tt.want, err)
+		}
+	}
+}
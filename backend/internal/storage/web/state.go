@@ -87,11 +87,15 @@ func (s *StateManager) WriteSite(accountID int, site *SiteDescriptor) error {
 	// Create directory structure
 	dirs := []string{
 		basePath,
-		filepath.Join(basePath, docRoot),
 		filepath.Join(basePath, "logs"),
 		filepath.Join(basePath, "tmp"),
 		filepath.Join(basePath, "cache"),
 	}
+	if site.ReleasesEnabled() {
+		dirs = append(dirs, filepath.Join(basePath, "releases"), filepath.Join(basePath, "shared"))
+	} else {
+		dirs = append(dirs, filepath.Join(basePath, docRoot))
+	}
 
 	for _, dir := range dirs {
 		if err := os.MkdirAll(dir, 0755); err != nil {
@@ -1,10 +1,16 @@
 // Package web provides filesystem-based web/site state management
 package web
 
+import (
+	"path/filepath"
+	"strings"
+)
+
 // SiteDescriptor represents site.json - configuration for a website
 type SiteDescriptor struct {
 	Domain       string            `json:"domain"`
 	Runtime      string            `json:"runtime"`       // php-8.2, php-8.1, nodejs-20, python-3.12
+	WebServer    string            `json:"web_server,omitempty"` // nginx (default), apache, hybrid
 	SSL          bool              `json:"ssl"`
 	SSLRedirect  bool              `json:"ssl_redirect"`  // Force HTTPS redirect
 	DocumentRoot string            `json:"document_root"` // Relative to site directory (e.g., "public")
@@ -14,6 +20,13 @@ type SiteDescriptor struct {
 	Headers      map[string]string `json:"headers,omitempty"`     // Custom headers
 	PHPSettings  *PHPSettings      `json:"php_settings,omitempty"`
 	NodeSettings *NodeSettings     `json:"node_settings,omitempty"`
+	PythonSettings *PythonSettings `json:"python_settings,omitempty"`
+	Releases     *ReleaseSettings  `json:"releases,omitempty"`
+	Cache        *CacheSettings    `json:"cache,omitempty"`
+	RateLimits   []RateLimit       `json:"rate_limits,omitempty"`
+	ProtectedPaths []ProtectedPath `json:"protected_paths,omitempty"`
+	AccessRules  []AccessRule      `json:"access_rules,omitempty"`
+	WAF          *WAFSettings      `json:"waf,omitempty"`
 	CreatedAt    string            `json:"created_at"`
 	UpdatedAt    string            `json:"updated_at"`
 }
@@ -44,6 +57,7 @@ type PHPSettings struct {
 type NodeSettings struct {
 	Version        string            `json:"version"`         // e.g., "20"
 	Port           int               `json:"port"`            // Application port
+	Socket         string            `json:"socket,omitempty"` // Unix socket the app listens on instead of a port
 	StartCommand   string            `json:"start_command"`   // e.g., "npm start"
 	Environment    map[string]string `json:"environment"`     // Environment variables
 	AutoRestart    bool              `json:"auto_restart"`
@@ -55,6 +69,8 @@ type NodeSettings struct {
 // PythonSettings represents Python-specific configuration
 type PythonSettings struct {
 	Version      string            `json:"version"`       // e.g., "3.12"
+	Port         int               `json:"port"`          // Application port
+	Socket       string            `json:"socket,omitempty"` // Unix socket the app listens on instead of a port
 	AppPath      string            `json:"app_path"`      // Path to WSGI/ASGI app
 	Framework    string            `json:"framework"`     // django, flask, fastapi
 	Environment  map[string]string `json:"environment"`
@@ -62,6 +78,68 @@ type PythonSettings struct {
 	WorkerCount  int               `json:"worker_count"`
 }
 
+// ReleaseSettings enables release-based deploys. Each deploy is unpacked into
+// releases/<id> and published by switching the current symlink, so the site
+// never serves a half-copied tree.
+type ReleaseSettings struct {
+	Enabled     bool     `json:"enabled"`
+	Keep        int      `json:"keep"`                   // Releases kept for rollback
+	SharedPaths []string `json:"shared_paths,omitempty"` // Persist across releases, e.g. "uploads", ".env"
+}
+
+// CacheSettings enables microcaching: nginx keeps successful responses of
+// the site's PHP, app or Apache backend for a few seconds, which absorbs
+// traffic spikes without serving noticeably stale pages
+type CacheSettings struct {
+	Enabled       bool     `json:"enabled"`
+	TTL           int      `json:"ttl"`                      // Seconds a 200/301/302 response is reused
+	BypassCookies []string `json:"bypass_cookies,omitempty"` // Cookie name prefixes that skip the cache, e.g. "wordpress_logged_in"
+	BypassPaths   []string `json:"bypass_paths,omitempty"`   // Path prefixes never cached, e.g. "/wp-admin"
+}
+
+// RateLimit limits requests and connections per client IP for paths
+// starting with Path
+type RateLimit struct {
+	Path        string `json:"path"`        // e.g. "/wp-login.php"
+	Rate        int    `json:"rate"`        // Requests per minute, 0 for no request limit
+	Burst       int    `json:"burst"`       // Requests allowed above the rate before rejecting
+	Connections int    `json:"connections"` // Concurrent connections, 0 for no connection limit
+}
+
+// ProtectedPath requires a login for a directory of the site. Its users
+// live in an htpasswd file in the site's auth directory, not in site.json.
+type ProtectedPath struct {
+	Path  string `json:"path"`            // e.g. "/admin"; "/" protects the whole site
+	Realm string `json:"realm,omitempty"` // Shown in the browser's login prompt
+}
+
+// AccessRule restricts a directory of the site by client IP. With Allow
+// set only those networks get in; Deny networks are refused either way.
+type AccessRule struct {
+	Path  string   `json:"path"`            // "/" for the whole site
+	Allow []string `json:"allow,omitempty"` // CIDRs or single addresses
+	Deny  []string `json:"deny,omitempty"`
+}
+
+// WAFSettings runs the site's requests through ModSecurity with the OWASP
+// Core Rule Set. In detection mode matches are only logged, which lets a
+// site collect false positives to exclude before switching to blocking.
+type WAFSettings struct {
+	Enabled       bool   `json:"enabled"`
+	Mode          string `json:"mode"`                     // detection or blocking
+	ExcludedRules []int  `json:"excluded_rules,omitempty"` // CRS rule IDs switched off for this site
+}
+
+// Release represents one deploy of a release-based site (stored in releases.json)
+type Release struct {
+	ID          string `json:"id"`
+	Source      string `json:"source"`        // git, upload, installer, initial
+	Ref         string `json:"ref,omitempty"` // Commit, archive name or app slug
+	Active      bool   `json:"active"`
+	CreatedAt   string `json:"created_at"`
+	ActivatedAt string `json:"activated_at,omitempty"`
+}
+
 // SSLMeta represents SSL certificate metadata (stored in ssl/{domain}/meta.json)
 type SSLMeta struct {
 	Domain       string   `json:"domain"`
@@ -135,3 +213,86 @@ func DefaultNodeSettings(version string) *NodeSettings {
 		PassengerMode: true,
 	}
 }
+
+// AppPort returns the local port nginx proxies to for application runtimes,
+// or 0 when the site is served from disk
+func (s *SiteDescriptor) AppPort() int {
+	switch {
+	case strings.HasPrefix(s.Runtime, "nodejs-") && s.NodeSettings != nil:
+		return s.NodeSettings.Port
+	case strings.HasPrefix(s.Runtime, "python-") && s.PythonSettings != nil:
+		return s.PythonSettings.Port
+	}
+	return 0
+}
+
+// AppSocket returns the unix socket nginx proxies to for application
+// runtimes, or "" when the app listens on a port
+func (s *SiteDescriptor) AppSocket() string {
+	switch {
+	case strings.HasPrefix(s.Runtime, "nodejs-") && s.NodeSettings != nil:
+		return s.NodeSettings.Socket
+	case strings.HasPrefix(s.Runtime, "python-") && s.PythonSettings != nil:
+		return s.PythonSettings.Socket
+	}
+	return ""
+}
+
+// IsProxied reports whether nginx hands the site's requests to an
+// application process rather than serving them from disk
+func (s *SiteDescriptor) IsProxied() bool {
+	return strings.HasPrefix(s.Runtime, "nodejs-") || strings.HasPrefix(s.Runtime, "python-")
+}
+
+// Backend returns the web server behind nginx that serves the site
+func (s *SiteDescriptor) Backend() string {
+	if s.WebServer == "" {
+		return BackendNginx
+	}
+	return s.WebServer
+}
+
+// UsesApache reports whether nginx hands the site to Apache
+func (s *SiteDescriptor) UsesApache() bool {
+	backend := s.Backend()
+	return backend == BackendApache || backend == BackendHybrid
+}
+
+// CacheEnabled reports whether nginx caches the site's responses
+func (s *SiteDescriptor) CacheEnabled() bool {
+	return s.Cache != nil && s.Cache.Enabled
+}
+
+// WAFEnabled reports whether ModSecurity inspects the site's requests
+func (s *SiteDescriptor) WAFEnabled() bool {
+	return s.WAF != nil && s.WAF.Enabled
+}
+
+// ReleasesEnabled reports whether the site is deployed as releases
+func (s *SiteDescriptor) ReleasesEnabled() bool {
+	return s.Releases != nil && s.Releases.Enabled
+}
+
+// DocumentPath returns the directory served for the site. With releases the
+// document root is relative to the active release.
+func (s *SiteDescriptor) DocumentPath(sitePath string) string {
+	docRoot := s.DocumentRoot
+	if docRoot == "" {
+		docRoot = "public"
+	}
+	if s.ReleasesEnabled() {
+		return filepath.Join(sitePath, "current", docRoot)
+	}
+	return filepath.Join(sitePath, docRoot)
+}
+
+// PHPVersion returns the PHP version a site runs on, or "" for other runtimes
+func (s *SiteDescriptor) PHPVersion() string {
+	if !strings.HasPrefix(s.Runtime, "php-") {
+		return ""
+	}
+	if s.PHPSettings != nil && s.PHPSettings.Version != "" {
+		return s.PHPSettings.Version
+	}
+	return strings.TrimPrefix(s.Runtime, "php-")
+}
@@ -3,27 +3,103 @@ package web
 
 import (
 	"errors"
+	"fmt"
 	"net"
+	"net/url"
+	"path"
+	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+
+	"github.com/iSundram/OweHost/pkg/models"
 )
 
 // Validation errors
 var (
-	ErrInvalidDomain       = errors.New("invalid domain name")
-	ErrInvalidRuntime      = errors.New("invalid runtime")
-	ErrInvalidDocumentRoot = errors.New("invalid document root: must not contain path traversal")
-	ErrInvalidRedirectCode = errors.New("invalid redirect code: must be 301, 302, 307, or 308")
-	ErrInvalidRedirectURL  = errors.New("invalid redirect target URL")
-	ErrInvalidPHPVersion   = errors.New("invalid PHP version")
-	ErrInvalidNodeVersion  = errors.New("invalid Node.js version")
-	ErrInvalidPort         = errors.New("invalid port number: must be between 1024 and 65535")
+	ErrInvalidDomain         = errors.New("invalid domain name")
+	ErrInvalidRuntime        = errors.New("invalid runtime")
+	ErrInvalidWebServer      = errors.New("invalid web server: must be nginx, apache or hybrid")
+	ErrWebServerRuntime      = errors.New("apache and hybrid web servers only serve PHP and static sites")
+	ErrInvalidDocumentRoot   = errors.New("invalid document root: must not contain path traversal")
+	ErrInvalidRedirectCode   = errors.New("invalid redirect code: must be 301, 302, 307, or 308")
+	ErrInvalidRedirectURL    = errors.New("invalid redirect target URL")
+	ErrInvalidRedirectSource = errors.New("invalid redirect source: must be a path starting with /")
+	ErrDuplicateRedirect     = errors.New("duplicate redirect source")
+	ErrInvalidErrorPage      = errors.New("invalid error page: must map a 4xx or 5xx code to a file in the document root")
+	ErrInvalidHeader         = errors.New("invalid header")
+	ErrInvalidPHPVersion     = errors.New("invalid PHP version")
+	ErrInvalidNodeVersion    = errors.New("invalid Node.js version")
+	ErrInvalidPort           = errors.New("invalid port number: must be between 1024 and 65535")
+	ErrInvalidSocket         = errors.New("invalid socket: must be an absolute path")
+	ErrInvalidPythonVersion  = errors.New("invalid Python version")
+	ErrInvalidKeep           = errors.New("invalid release count: must be at most 50")
+	ErrInvalidSharedPath     = errors.New("invalid shared path: must be relative to the release")
+	ErrCacheRuntime          = errors.New("page cache needs a PHP, application or Apache-backed site")
+	ErrInvalidCacheTTL       = errors.New("invalid cache TTL: must be between 1 and 3600 seconds")
+	ErrInvalidCookieName     = errors.New("invalid bypass cookie name")
+	ErrInvalidLimitPath      = errors.New("invalid path: must be a plain path starting with /")
+	ErrInvalidRateLimit      = errors.New("invalid rate limit")
+	ErrDuplicatePath         = errors.New("duplicate path")
+	ErrInvalidRealm          = errors.New("invalid realm")
+	ErrInvalidCIDR           = errors.New("invalid IP address or CIDR")
+	ErrInvalidAuthUser       = errors.New("invalid username: use letters, digits, dots, dashes and underscores")
+	ErrInvalidAuthPassword   = errors.New("invalid password: must be 8 to 72 characters")
+	ErrInvalidWAFMode        = errors.New("invalid firewall mode: must be detection or blocking")
+	ErrInvalidWAFRule        = errors.New("invalid rule ID")
 )
 
 // Domain validation regex
 var validDomainRegex = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9\-]{0,61}[a-zA-Z0-9])?\.)+[a-zA-Z]{2,}$`)
 var validSubdomainRegex = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9\-]{0,61}[a-zA-Z0-9])?$`)
 
+// Supported PHP versions
+var validPHPVersions = map[string]bool{
+	"7.4": true, "8.0": true, "8.1": true, "8.2": true, "8.3": true,
+}
+
+// INI directive names, and directives only the panel may set
+var validINIKey = regexp.MustCompile(`^[a-zA-Z0-9_.]+$`)
+var protectedINIKeys = map[string]bool{
+	"open_basedir":                true,
+	"disable_functions":           true,
+	"disable_classes":             true,
+	"extension":                   true,
+	"zend_extension":              true,
+	"extension_dir":               true,
+	"error_log":                   true,
+	"upload_tmp_dir":              true,
+	"session.save_path":           true,
+	"sendmail_path":               true,
+	"mail.force_extra_parameters": true,
+	"auto_prepend_file":           true,
+	"auto_append_file":            true,
+}
+
+var validExtensionName = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// Variables a redirect target may use besides regex captures ($1..$9)
+var redirectTargetVar = regexp.MustCompile(`\$([0-9]|request_uri|uri|args|is_args|query_string|host|scheme)\b`)
+
+var validHeaderName = regexp.MustCompile(`^[A-Za-z0-9-]{1,100}$`)
+
+// Cookie names and paths end up in nginx regexes, so only plain characters
+// are allowed
+var validCookieName = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,100}$`)
+var validLimitPath = regexp.MustCompile(`^/[A-Za-z0-9._~/-]{0,200}$`)
+
+var validAuthUser = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Per-site limits on generated nginx zones, maps and locations
+const (
+	maxBypassRules    = 20
+	maxRateLimits     = 20
+	maxProtectedPaths = 20
+	maxAccessRules    = 20
+	maxAccessEntries  = 100
+	maxExcludedRules  = 200
+)
+
 // Valid redirect codes
 var validRedirectCodes = map[int]bool{
 	301: true, // Permanent
@@ -48,6 +124,18 @@ func ValidateSite(site *SiteDescriptor) error {
 		return ErrInvalidRuntime
 	}
 
+	// Validate web server. Apache serves files and PHP; app runtimes are
+	// proxied by nginx directly.
+	switch site.Backend() {
+	case BackendNginx:
+	case BackendApache, BackendHybrid:
+		if site.IsProxied() {
+			return ErrWebServerRuntime
+		}
+	default:
+		return ErrInvalidWebServer
+	}
+
 	// Validate document root (prevent path traversal)
 	if err := ValidateDocumentRoot(site.DocumentRoot); err != nil {
 		return err
@@ -60,11 +148,33 @@ func ValidateSite(site *SiteDescriptor) error {
 		}
 	}
 
-	// Validate redirects
+	// Validate redirects. Two exact redirects for one path would be two
+	// identical nginx locations, which nginx refuses to load.
+	exact := make(map[string]bool)
 	for _, redirect := range site.Redirects {
 		if err := ValidateRedirect(&redirect); err != nil {
 			return err
 		}
+		if !redirect.IsRegex && !redirect.IsWildcard {
+			if exact[redirect.Source] {
+				return fmt.Errorf("%w: %s", ErrDuplicateRedirect, redirect.Source)
+			}
+			exact[redirect.Source] = true
+		}
+	}
+
+	// Validate error pages
+	for code, page := range site.ErrorPages {
+		if err := ValidateErrorPage(code, page); err != nil {
+			return err
+		}
+	}
+
+	// Validate custom headers
+	for name, value := range site.Headers {
+		if err := ValidateHeader(name, value); err != nil {
+			return err
+		}
 	}
 
 	// Validate PHP settings if present
@@ -81,6 +191,76 @@ func ValidateSite(site *SiteDescriptor) error {
 		}
 	}
 
+	// Validate Python settings if present
+	if site.PythonSettings != nil {
+		if err := ValidatePythonSettings(site.PythonSettings); err != nil {
+			return err
+		}
+	}
+
+	// Validate release settings if present
+	if site.Releases != nil {
+		if err := ValidateReleaseSettings(site.Releases); err != nil {
+			return err
+		}
+	}
+
+	// Validate page cache. Files nginx serves from disk are not cached.
+	if site.CacheEnabled() {
+		if !site.IsProxied() && !site.UsesApache() && site.PHPVersion() == "" {
+			return ErrCacheRuntime
+		}
+		if err := ValidateCacheSettings(site.Cache); err != nil {
+			return err
+		}
+	}
+
+	// Validate protected paths and IP access rules. Each path gets its own
+	// nginx locations, so a path may appear only once per list.
+	if len(site.ProtectedPaths) > maxProtectedPaths {
+		return fmt.Errorf("at most %d protected paths", maxProtectedPaths)
+	}
+	protected := make(map[string]bool)
+	for i := range site.ProtectedPaths {
+		if err := ValidateProtectedPath(&site.ProtectedPaths[i]); err != nil {
+			return err
+		}
+		if protected[site.ProtectedPaths[i].Path] {
+			return fmt.Errorf("%w: %s", ErrDuplicatePath, site.ProtectedPaths[i].Path)
+		}
+		protected[site.ProtectedPaths[i].Path] = true
+	}
+	if len(site.AccessRules) > maxAccessRules {
+		return fmt.Errorf("at most %d access rules", maxAccessRules)
+	}
+	restricted := make(map[string]bool)
+	for i := range site.AccessRules {
+		if err := ValidateAccessRule(&site.AccessRules[i]); err != nil {
+			return err
+		}
+		if restricted[site.AccessRules[i].Path] {
+			return fmt.Errorf("%w: %s", ErrDuplicatePath, site.AccessRules[i].Path)
+		}
+		restricted[site.AccessRules[i].Path] = true
+	}
+
+	// Validate web application firewall
+	if site.WAF != nil {
+		if err := ValidateWAFSettings(site.WAF); err != nil {
+			return err
+		}
+	}
+
+	// Validate rate limits
+	if len(site.RateLimits) > maxRateLimits {
+		return fmt.Errorf("%w: at most %d rules", ErrInvalidRateLimit, maxRateLimits)
+	}
+	for i := range site.RateLimits {
+		if err := ValidateRateLimit(&site.RateLimits[i]); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -137,12 +317,15 @@ func ValidateDocumentRoot(docRoot string) error {
 	}
 
 	// Check for invalid characters
-	invalidChars := []string{"\\", ":", "*", "?", "\"", "<", ">", "|"}
+	invalidChars := []string{"\\", ":", "*", "?", "\"", "<", ">", "|", "$"}
 	for _, char := range invalidChars {
 		if strings.Contains(docRoot, char) {
 			return ErrInvalidDocumentRoot
 		}
 	}
+	if hasControlChars(docRoot) {
+		return ErrInvalidDocumentRoot
+	}
 
 	return nil
 }
@@ -157,11 +340,32 @@ func ValidateRedirect(redirect *Redirect) error {
 	if redirect.Source == "" {
 		return errors.New("redirect source cannot be empty")
 	}
+	if hasControlChars(redirect.Source) {
+		return ErrInvalidRedirectSource
+	}
+	if redirect.IsRegex {
+		if _, err := regexp.Compile(redirect.Source); err != nil {
+			return fmt.Errorf("invalid redirect pattern: %w", err)
+		}
+	} else if !strings.HasPrefix(redirect.Source, "/") {
+		return ErrInvalidRedirectSource
+	}
 
-	// Validate target
-	if redirect.Target == "" {
+	// Validate target: a local path or an absolute http(s) URL. nginx
+	// expands variables in targets, so only captures and a few request
+	// variables may appear.
+	if redirect.Target == "" || hasControlChars(redirect.Target) {
 		return ErrInvalidRedirectURL
 	}
+	if !strings.HasPrefix(redirect.Target, "/") {
+		u, err := url.Parse(redirect.Target)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return ErrInvalidRedirectURL
+		}
+	}
+	if strings.Count(redirect.Target, "$") != len(redirectTargetVar.FindAllString(redirect.Target, -1)) {
+		return fmt.Errorf("%w: unsupported variable", ErrInvalidRedirectURL)
+	}
 
 	// Validate code
 	if !validRedirectCodes[redirect.Code] {
@@ -171,6 +375,38 @@ func ValidateRedirect(redirect *Redirect) error {
 	return nil
 }
 
+// ValidateErrorPage validates an error page mapping such as "404" => "404.html"
+func ValidateErrorPage(code, page string) error {
+	n, err := strconv.Atoi(code)
+	if err != nil || n < 400 || n > 599 {
+		return ErrInvalidErrorPage
+	}
+
+	// nginx would expand variables in the page URI
+	page = strings.TrimPrefix(page, "/")
+	if page == "" || hasControlChars(page) || strings.Contains(page, "$") || !filepath.IsLocal(page) {
+		return ErrInvalidErrorPage
+	}
+	return nil
+}
+
+// ValidateHeader validates a custom response header
+func ValidateHeader(name, value string) error {
+	if !validHeaderName.MatchString(name) {
+		return fmt.Errorf("%w: name %q", ErrInvalidHeader, name)
+	}
+	if len(value) > 4096 || hasControlChars(value) || strings.Contains(value, "$") {
+		return fmt.Errorf("%w: value of %s", ErrInvalidHeader, name)
+	}
+	return nil
+}
+
+// hasControlChars reports whether s contains ASCII control characters,
+// which could end a directive or a header line early
+func hasControlChars(s string) bool {
+	return strings.IndexFunc(s, func(r rune) bool { return r < 0x20 || r == 0x7f }) >= 0
+}
+
 // ValidatePHPSettings validates PHP settings
 func ValidatePHPSettings(settings *PHPSettings) error {
 	if settings == nil {
@@ -178,13 +414,22 @@ func ValidatePHPSettings(settings *PHPSettings) error {
 	}
 
 	// Validate version
-	validPHPVersions := map[string]bool{
-		"7.4": true, "8.0": true, "8.1": true, "8.2": true, "8.3": true,
-	}
 	if !validPHPVersions[settings.Version] {
 		return ErrInvalidPHPVersion
 	}
 
+	// Site settings reach PHP through a quoted nginx fastcgi_param
+	for key, value := range settings.CustomINI {
+		if !validINIKey.MatchString(key) || protectedINIKeys[key] || !validINIValue(value) {
+			return fmt.Errorf("invalid custom ini directive: %s", key)
+		}
+	}
+	for _, value := range []string{settings.MemoryLimit, settings.PostMaxSize, settings.UploadMaxFilesize} {
+		if !validINIValue(value) {
+			return errors.New("invalid PHP size value")
+		}
+	}
+
 	// Validate execution time
 	if settings.MaxExecutionTime < 0 || settings.MaxExecutionTime > 3600 {
 		return errors.New("max_execution_time must be between 0 and 3600")
@@ -212,9 +457,8 @@ func ValidateNodeSettings(settings *NodeSettings) error {
 		return ErrInvalidNodeVersion
 	}
 
-	// Validate port (must be unprivileged)
-	if settings.Port < 1024 || settings.Port > 65535 {
-		return ErrInvalidPort
+	if err := validateAppListener(settings.Port, settings.Socket); err != nil {
+		return err
 	}
 
 	// Validate max restarts
@@ -225,6 +469,45 @@ func ValidateNodeSettings(settings *NodeSettings) error {
 	return nil
 }
 
+// ValidatePythonSettings validates Python settings
+func ValidatePythonSettings(settings *PythonSettings) error {
+	if settings == nil {
+		return nil
+	}
+
+	if !ValidRuntimes["python-"+settings.Version] {
+		return ErrInvalidPythonVersion
+	}
+
+	if err := validateAppListener(settings.Port, settings.Socket); err != nil {
+		return err
+	}
+
+	if settings.WorkerCount < 0 || settings.WorkerCount > 64 {
+		return errors.New("worker_count must be between 0 and 64")
+	}
+
+	return nil
+}
+
+// validateAppListener checks where an app accepts connections: an
+// unprivileged port, or an absolute unix socket path that is safe to put
+// in an nginx upstream
+func validateAppListener(port int, socket string) error {
+	if socket == "" {
+		if port < 1024 || port > 65535 {
+			return ErrInvalidPort
+		}
+		return nil
+	}
+
+	if !filepath.IsAbs(socket) || filepath.Clean(socket) != socket ||
+		strings.ContainsAny(socket, " ;{}\"'$") || hasControlChars(socket) {
+		return ErrInvalidSocket
+	}
+	return nil
+}
+
 // ValidateSSLMeta validates SSL metadata
 func ValidateSSLMeta(meta *SSLMeta) error {
 	if meta == nil {
@@ -282,3 +565,219 @@ func SanitizeDomain(domain string) string {
 
 	return domain
 }
+
+// ValidateReleaseSettings validates release-based deploy settings
+func ValidateReleaseSettings(settings *ReleaseSettings) error {
+	// Zero means the default
+	if settings.Keep < 0 || settings.Keep > 50 {
+		return ErrInvalidKeep
+	}
+
+	for _, path := range settings.SharedPaths {
+		clean := filepath.Clean(path)
+		if !filepath.IsLocal(path) || clean == "." || strings.Contains(path, "\\") {
+			return ErrInvalidSharedPath
+		}
+	}
+
+	return nil
+}
+
+// ValidateCacheSettings validates microcache settings
+func ValidateCacheSettings(settings *CacheSettings) error {
+	if !settings.Enabled {
+		return nil
+	}
+	if settings.TTL < 1 || settings.TTL > 3600 {
+		return ErrInvalidCacheTTL
+	}
+	if len(settings.BypassCookies) > maxBypassRules || len(settings.BypassPaths) > maxBypassRules {
+		return fmt.Errorf("at most %d cache bypass cookies and paths", maxBypassRules)
+	}
+	for _, name := range settings.BypassCookies {
+		if !validCookieName.MatchString(name) {
+			return fmt.Errorf("%w: %q", ErrInvalidCookieName, name)
+		}
+	}
+	for _, path := range settings.BypassPaths {
+		if !validLimitPath.MatchString(path) {
+			return fmt.Errorf("%w: %q", ErrInvalidLimitPath, path)
+		}
+	}
+	return nil
+}
+
+// ValidateRateLimit validates a per-path request and connection limit
+func ValidateRateLimit(limit *RateLimit) error {
+	if !validLimitPath.MatchString(limit.Path) {
+		return fmt.Errorf("%w: %q", ErrInvalidLimitPath, limit.Path)
+	}
+	if limit.Rate < 0 || limit.Rate > 60000 {
+		return fmt.Errorf("%w: rate must be between 1 and 60000 requests per minute", ErrInvalidRateLimit)
+	}
+	if limit.Burst < 0 || limit.Burst > 1000 || (limit.Burst > 0 && limit.Rate == 0) {
+		return fmt.Errorf("%w: burst must be between 0 and 1000 and needs a rate", ErrInvalidRateLimit)
+	}
+	if limit.Connections < 0 || limit.Connections > 1000 {
+		return fmt.Errorf("%w: connections must be between 1 and 1000", ErrInvalidRateLimit)
+	}
+	if limit.Rate == 0 && limit.Connections == 0 {
+		return fmt.Errorf("%w: set a rate or a connection limit", ErrInvalidRateLimit)
+	}
+	return nil
+}
+
+// ValidateWAFSettings validates ModSecurity settings. Rule IDs are rendered
+// into a ModSecurity config, so only plain numbers in the range ModSecurity
+// accepts are allowed.
+func ValidateWAFSettings(settings *WAFSettings) error {
+	if settings.Mode != WAFModeDetection && settings.Mode != WAFModeBlocking {
+		return ErrInvalidWAFMode
+	}
+	if len(settings.ExcludedRules) > maxExcludedRules {
+		return fmt.Errorf("at most %d excluded rules", maxExcludedRules)
+	}
+	seen := make(map[int]bool)
+	for _, id := range settings.ExcludedRules {
+		if id < 1 || id > 9999999 {
+			return fmt.Errorf("%w: %d", ErrInvalidWAFRule, id)
+		}
+		if seen[id] {
+			return fmt.Errorf("%w: %d excluded twice", ErrInvalidWAFRule, id)
+		}
+		seen[id] = true
+	}
+	return nil
+}
+
+// validSitePath checks a directory path of a site. Paths are stored clean,
+// so "/admin/" and "/admin" cannot become two rules for one directory.
+func validSitePath(p string) bool {
+	return validLimitPath.MatchString(p) && path.Clean(p) == p
+}
+
+// ValidateProtectedPath validates a password-protected directory
+func ValidateProtectedPath(protected *ProtectedPath) error {
+	if !validSitePath(protected.Path) {
+		return fmt.Errorf("%w: %q", ErrInvalidLimitPath, protected.Path)
+	}
+	// The realm is a quoted nginx argument, where $ starts a variable
+	if len(protected.Realm) > 100 || hasControlChars(protected.Realm) || strings.Contains(protected.Realm, "$") {
+		return ErrInvalidRealm
+	}
+	return nil
+}
+
+// ValidateAccessRule validates an IP allow/deny rule
+func ValidateAccessRule(rule *AccessRule) error {
+	if !validSitePath(rule.Path) {
+		return fmt.Errorf("%w: %q", ErrInvalidLimitPath, rule.Path)
+	}
+	if len(rule.Allow) == 0 && len(rule.Deny) == 0 {
+		return errors.New("access rule needs allowed or denied addresses")
+	}
+	if len(rule.Allow)+len(rule.Deny) > maxAccessEntries {
+		return fmt.Errorf("at most %d addresses per access rule", maxAccessEntries)
+	}
+	for _, entry := range append(append([]string{}, rule.Allow...), rule.Deny...) {
+		if err := ValidateCIDR(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateCIDR accepts an IPv4 or IPv6 address or network
+func ValidateCIDR(entry string) error {
+	if strings.Contains(entry, "/") {
+		if _, _, err := net.ParseCIDR(entry); err != nil {
+			return fmt.Errorf("%w: %q", ErrInvalidCIDR, entry)
+		}
+		return nil
+	}
+	if net.ParseIP(entry) == nil {
+		return fmt.Errorf("%w: %q", ErrInvalidCIDR, entry)
+	}
+	return nil
+}
+
+// ValidateAuthUser validates htpasswd credentials. bcrypt ignores
+// everything after 72 bytes, so longer passwords are refused rather than
+// silently truncated.
+func ValidateAuthUser(username, password string) error {
+	if !validAuthUser.MatchString(username) {
+		return ErrInvalidAuthUser
+	}
+	if len(password) < 8 || len(password) > 72 {
+		return ErrInvalidAuthPassword
+	}
+	return nil
+}
+
+// ValidatePHPPool validates a PHP-FPM pool definition
+func ValidatePHPPool(pool *models.PHPPool) error {
+	if pool == nil {
+		return errors.New("pool is nil")
+	}
+
+	if !validPHPVersions[pool.Version] {
+		return ErrInvalidPHPVersion
+	}
+
+	if pool.MaxChildren < 1 || pool.MaxChildren > 200 {
+		return errors.New("max_children must be between 1 and 200")
+	}
+
+	switch pool.PMMode {
+	case models.PHPPMStatic:
+	case models.PHPPMDynamic:
+		if pool.MinSpareServers < 1 || pool.MinSpareServers > pool.MaxSpareServers ||
+			pool.StartServers < pool.MinSpareServers || pool.StartServers > pool.MaxSpareServers ||
+			pool.MaxSpareServers > pool.MaxChildren {
+			return errors.New("dynamic pools need min_spare_servers <= start_servers <= max_spare_servers <= max_children")
+		}
+	case models.PHPPMOndemand:
+		if pool.ProcessIdleTimeout < 1 || pool.ProcessIdleTimeout > 3600 {
+			return errors.New("process_idle_timeout must be between 1 and 3600")
+		}
+	default:
+		return errors.New("pm_mode must be static, dynamic or ondemand")
+	}
+
+	if pool.MaxRequests < 0 {
+		return errors.New("max_requests cannot be negative")
+	}
+	if pool.RequestTerminateTimeout < 0 || pool.RequestTerminateTimeout > 86400 {
+		return errors.New("request_terminate_timeout must be between 0 and 86400")
+	}
+
+	for _, path := range pool.OpenBasedir {
+		if !filepath.IsAbs(path) || strings.ContainsAny(path, ":;\n\"") {
+			return fmt.Errorf("invalid open_basedir path: %s", path)
+		}
+	}
+	for _, fn := range pool.DisableFunctions {
+		if !validINIKey.MatchString(fn) {
+			return fmt.Errorf("invalid function name: %s", fn)
+		}
+	}
+	for _, ext := range pool.Extensions {
+		if !validExtensionName.MatchString(ext) {
+			return fmt.Errorf("invalid extension name: %s", ext)
+		}
+	}
+	for key, value := range pool.INIOverrides {
+		if !validINIKey.MatchString(key) || protectedINIKeys[key] || !validINIValue(value) {
+			return fmt.Errorf("invalid ini override: %s", key)
+		}
+	}
+
+	return nil
+}
+
+// validINIValue rejects values that could break out of a directive
+func validINIValue(value string) bool {
+	// Values end up inside a quoted nginx argument, where $ starts a
+	// variable, and an Apache expression string, where %{ does
+	return !strings.ContainsAny(value, "\n\r\"\\;$") && !strings.Contains(value, "%{")
+}
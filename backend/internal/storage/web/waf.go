@@ -0,0 +1,293 @@
+// Package web provides filesystem-based web/site state management
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WAF modes
+const (
+	WAFModeDetection = "detection"
+	WAFModeBlocking  = "blocking"
+)
+
+// ModSecurity files. Rules and audit logs stay outside the account tree:
+// account users must not be able to switch their rules off behind the
+// panel's back or plant entries that end up as intrusion events.
+const (
+	wafBasePath  = "/etc/nginx/owehost/modsecurity.conf"
+	wafRulesDir  = "/etc/nginx/owehost/waf"
+	WAFLogDir    = "/var/log/nginx/owehost-waf"
+	crsSetupPath = "/etc/modsecurity/crs/crs-setup.conf"
+	crsRulesGlob = "/usr/share/modsecurity-crs/rules/*.conf"
+)
+
+// wafTailSize is how much of a site's audit log is read for its recent
+// events. JSON entries are a few KB each.
+const wafTailSize = 2 << 20
+
+// wafBaseConf is included by every site's rules file. It carries the
+// engine defaults ModSecurity needs before the Core Rule Set loads; each
+// site then picks its engine mode and audit log.
+const wafBaseConf = `# Generated by OweHost - changes will be overwritten
+SecRequestBodyAccess On
+SecRequestBodyLimit 13107200
+SecRequestBodyNoFilesLimit 131072
+SecRequestBodyLimitAction Reject
+SecResponseBodyAccess Off
+SecPcreMatchLimit 100000
+SecPcreMatchLimitRecursion 100000
+SecTmpDir /tmp/
+SecDataDir /tmp/
+SecArgumentSeparator &
+SecCookieFormat 0
+SecAuditEngine RelevantOnly
+SecAuditLogRelevantStatus "^(?:5|4(?!04))"
+SecAuditLogParts ABHZ
+SecAuditLogType Serial
+SecAuditLogFormat JSON
+
+Include ` + crsSetupPath + `
+Include ` + crsRulesGlob + `
+`
+
+// WAFEvent is a request ModSecurity matched, as read from an audit log
+type WAFEvent struct {
+	ID       string         `json:"id"`
+	Time     time.Time      `json:"time"`
+	ClientIP string         `json:"client_ip"`
+	ServerIP string         `json:"server_ip"`
+	Host     string         `json:"host"`
+	Method   string         `json:"method"`
+	URI      string         `json:"uri"`
+	Status   int            `json:"status"`
+	Blocked  bool           `json:"blocked"`  // false when the site only detects
+	Severity string         `json:"severity"` // critical, warning or info
+	Rules    []WAFRuleMatch `json:"rules"`
+}
+
+// WAFRuleMatch is one rule that matched a request. Its ID is what a site
+// excludes to stop a false positive.
+type WAFRuleMatch struct {
+	ID       int    `json:"id"`
+	Message  string `json:"message"`
+	Data     string `json:"data,omitempty"`
+	Severity string `json:"severity"`
+}
+
+// wafAuditEntry is the part of a ModSecurity v3 JSON audit entry the panel
+// reads. Numbers ModSecurity writes as strings stay strings here.
+type wafAuditEntry struct {
+	Transaction struct {
+		ClientIP  string `json:"client_ip"`
+		TimeStamp string `json:"time_stamp"`
+		HostIP    string `json:"host_ip"`
+		UniqueID  string `json:"unique_id"`
+		Request   struct {
+			Method  string            `json:"method"`
+			URI     string            `json:"uri"`
+			Headers map[string]string `json:"headers"`
+		} `json:"request"`
+		Response struct {
+			HTTPCode int `json:"http_code"`
+		} `json:"response"`
+		Producer struct {
+			Engine string `json:"secrules_engine"`
+		} `json:"producer"`
+		Messages []struct {
+			Message string `json:"message"`
+			Details struct {
+				RuleID   string `json:"ruleId"`
+				Data     string `json:"data"`
+				Severity string `json:"severity"`
+			} `json:"details"`
+		} `json:"messages"`
+	} `json:"transaction"`
+}
+
+// wafRulesPath is the ModSecurity config nginx loads for a site
+func wafRulesPath(accountID int, domain string) string {
+	return filepath.Join(wafRulesDir, SiteConfigName(accountID, domain)+".conf")
+}
+
+// WAFLogPath is the audit log of a site. The file name is the site's
+// config name, which is how the log is traced back to its site.
+func WAFLogPath(accountID int, domain string) string {
+	return filepath.Join(WAFLogDir, SiteConfigName(accountID, domain)+".log")
+}
+
+// renderWAFRules renders a site's ModSecurity config. Exclusions must come
+// after the rules they remove.
+func renderWAFRules(accountID int, site *SiteDescriptor) string {
+	engine := "DetectionOnly"
+	if site.WAF.Mode == WAFModeBlocking {
+		engine = "On"
+	}
+
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "# Generated by OweHost for %s - changes will be overwritten\n", site.Domain)
+	buf.WriteString("Include " + wafBasePath + "\n")
+	buf.WriteString("SecRuleEngine " + engine + "\n")
+	buf.WriteString("SecAuditLog " + WAFLogPath(accountID, site.Domain) + "\n")
+	if len(site.WAF.ExcludedRules) > 0 {
+		ids := make([]string, 0, len(site.WAF.ExcludedRules))
+		for _, id := range site.WAF.ExcludedRules {
+			ids = append(ids, strconv.Itoa(id))
+		}
+		buf.WriteString("SecRuleRemoveById " + strings.Join(ids, " ") + "\n")
+	}
+	return buf.String()
+}
+
+// ensureWAFRules writes the files a site's nginx config loads when its
+// firewall is on. nginx tests them with the site config, so a rules file
+// ModSecurity rejects never goes live.
+func ensureWAFRules(accountID int, site *SiteDescriptor) error {
+	if err := writeIfChanged(wafBasePath, []byte(wafBaseConf)); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(WAFLogDir, 0750); err != nil {
+		return err
+	}
+	return writeIfChanged(wafRulesPath(accountID, site.Domain), []byte(renderWAFRules(accountID, site)))
+}
+
+// removeWAFRules drops a site's rules file once its config no longer
+// loads it
+func removeWAFRules(accountID int, domain string) error {
+	err := os.Remove(wafRulesPath(accountID, domain))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// ParseWAFEntry parses one line of a ModSecurity JSON audit log
+func ParseWAFEntry(line []byte) (*WAFEvent, error) {
+	var entry wafAuditEntry
+	if err := json.Unmarshal(line, &entry); err != nil {
+		return nil, err
+	}
+	tx := entry.Transaction
+	if tx.ClientIP == "" && tx.UniqueID == "" {
+		return nil, errors.New("not a ModSecurity audit entry")
+	}
+
+	event := &WAFEvent{
+		ID:       tx.UniqueID,
+		ClientIP: tx.ClientIP,
+		ServerIP: tx.HostIP,
+		Method:   tx.Request.Method,
+		URI:      tx.Request.URI,
+		Status:   tx.Response.HTTPCode,
+		Severity: "info",
+		Rules:    make([]WAFRuleMatch, 0, len(tx.Messages)),
+	}
+	if t, err := time.Parse(time.ANSIC, tx.TimeStamp); err == nil {
+		event.Time = t
+	}
+	for name, value := range tx.Request.Headers {
+		if strings.EqualFold(name, "Host") {
+			event.Host = value
+		}
+	}
+
+	// A blocking engine answers 403 for requests the rules reject; in
+	// detection mode the engine is "DetectionOnly" and nothing is refused
+	event.Blocked = tx.Producer.Engine == "Enabled" && tx.Response.HTTPCode == 403
+
+	for _, m := range tx.Messages {
+		id, err := strconv.Atoi(m.Details.RuleID)
+		if err != nil {
+			continue
+		}
+		severity := wafSeverity(m.Details.Severity)
+		event.Rules = append(event.Rules, WAFRuleMatch{ID: id, Message: m.Message, Data: m.Details.Data, Severity: severity})
+		if severityRank[severity] > severityRank[event.Severity] {
+			event.Severity = severity
+		}
+	}
+	return event, nil
+}
+
+var severityRank = map[string]int{"info": 0, "warning": 1, "critical": 2}
+
+// wafSeverity maps ModSecurity's syslog severities (0 emergency to
+// 7 debug) onto the panel's
+func wafSeverity(level string) string {
+	n, err := strconv.Atoi(level)
+	switch {
+	case err != nil:
+		return "info"
+	case n <= 2:
+		return "critical"
+	case n <= 4:
+		return "warning"
+	default:
+		return "info"
+	}
+}
+
+// WAFEvents returns a site's most recent firewall matches, newest first.
+// Only the end of the current audit log is read, so older events disappear
+// as the log grows or rotates.
+func (a *Applier) WAFEvents(accountID int, site *SiteDescriptor, limit int) ([]WAFEvent, error) {
+	events := make([]WAFEvent, 0)
+
+	f, err := os.Open(WAFLogPath(accountID, site.Domain))
+	if os.IsNotExist(err) {
+		return events, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	offset := info.Size() - wafTailSize
+	if offset < 0 {
+		offset = 0
+	}
+	data, err := io.ReadAll(io.NewSectionReader(f, offset, info.Size()-offset))
+	if err != nil {
+		return nil, err
+	}
+	// Starting mid-file cuts the first entry
+	if offset > 0 {
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			data = data[i+1:]
+		}
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), wafTailSize)
+	for scanner.Scan() {
+		if event, err := ParseWAFEntry(scanner.Bytes()); err == nil {
+			events = append(events, *event)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	if limit > 0 && len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
@@ -0,0 +1,191 @@
+// Package tenancy decides which users' resources each user can reach.
+//
+// Admins reach everything. A reseller reaches its own resources, those of
+// the resellers below it, and those of every hosting account owned by
+// itself or by one of them. Anyone else reaches only their own. Resources
+// record the user that owns them; a hosting account's resources may be
+// recorded under its account name, when it logged in as the account, or
+// under the panel user of the same name.
+package tenancy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/iSundram/OweHost/internal/reseller"
+	"github.com/iSundram/OweHost/internal/storage/account"
+	"github.com/iSundram/OweHost/pkg/models"
+)
+
+// ErrCrossTenant is returned for a resource outside the caller's tenancy
+var ErrCrossTenant = errors.New("access denied")
+
+// resellerOwnerPrefix starts the owner recorded on accounts a reseller owns
+const resellerOwnerPrefix = "reseller-"
+
+// ResellerOwner is the owner recorded on the accounts owned by the
+// reseller with user ID userID
+func ResellerOwner(userID string) string {
+	return resellerOwnerPrefix + userID
+}
+
+// ResellerUserID returns the user ID of the reseller an account owner
+// names, if it names one
+func ResellerUserID(owner string) (string, bool) {
+	userID, ok := strings.CutPrefix(owner, resellerOwnerPrefix)
+	return userID, ok && userID != ""
+}
+
+// UserDirectory looks up panel users
+type UserDirectory interface {
+	Get(id string) (*models.User, error)
+	GetByUsername(username string) (*models.User, error)
+}
+
+// AccountLister lists the hosting accounts
+type AccountLister interface {
+	List(ctx context.Context) ([]account.Account, error)
+}
+
+// Guard works out the scope of each caller
+type Guard struct {
+	users     UserDirectory
+	resellers *reseller.Service
+	accounts  AccountLister
+}
+
+// NewGuard creates a new tenancy guard
+func NewGuard(users UserDirectory, resellers *reseller.Service, accounts AccountLister) *Guard {
+	return &Guard{
+		users:     users,
+		resellers: resellers,
+		accounts:  accounts,
+	}
+}
+
+// Scope is what one caller can reach
+type Scope struct {
+	UserID string
+	Role   models.UserRole
+
+	all bool
+	// owners are the user IDs and account names whose resources are in
+	// reach
+	owners map[string]bool
+	// parents are the account owners ("admin", "reseller-<user ID>") new
+	// accounts may be put under
+	parents map[string]bool
+}
+
+// All reports whether the scope reaches every tenant
+func (s *Scope) All() bool {
+	return s.all
+}
+
+// Owns reports whether a resource owned by ownerID is in reach. A resource
+// with no owner is only in reach of admins.
+func (s *Scope) Owns(ownerID string) bool {
+	return s.all || (ownerID != "" && s.owners[ownerID])
+}
+
+// OwnsAccount reports whether a hosting account is in reach
+func (s *Scope) OwnsAccount(identity *account.AccountIdentity) bool {
+	if identity == nil {
+		return s.all
+	}
+	return s.Owns(identity.Name) || s.parents[identity.Owner]
+}
+
+// CanParent reports whether new accounts may be put under owner
+func (s *Scope) CanParent(owner string) bool {
+	return s.all || s.parents[owner]
+}
+
+// Scope works out what the user with ID userID can reach. Hosting accounts
+// log in as themselves and have no panel user: their ID is their account
+// name.
+func (g *Guard) Scope(ctx context.Context, userID string) *Scope {
+	scope := &Scope{
+		UserID:  userID,
+		Role:    models.UserRoleAccount,
+		owners:  make(map[string]bool),
+		parents: make(map[string]bool),
+	}
+	if userID == "" {
+		return scope
+	}
+
+	u, err := g.users.Get(userID)
+	if err != nil {
+		g.addAccount(scope, userID)
+		return scope
+	}
+	scope.Role = u.Role
+
+	switch u.Role {
+	case models.UserRoleAdmin:
+		scope.all = true
+	case models.UserRoleReseller:
+		g.addResellerTree(ctx, scope, u)
+	default:
+		scope.owners[u.ID] = true
+		g.addAccount(scope, u.Username)
+	}
+	return scope
+}
+
+// Check returns ErrCrossTenant unless the user with ID userID can reach a
+// resource owned by ownerID
+func (g *Guard) Check(ctx context.Context, userID, ownerID string) error {
+	if !g.Scope(ctx, userID).Owns(ownerID) {
+		return ErrCrossTenant
+	}
+	return nil
+}
+
+// addAccount brings a hosting account into scope, under its account name
+// and its panel user's ID. Only a user or account role panel user is the
+// account's: an admin or reseller sharing its name is not brought in.
+func (g *Guard) addAccount(scope *Scope, name string) {
+	scope.owners[name] = true
+	if u, err := g.users.GetByUsername(name); err == nil &&
+		(u.Role == models.UserRoleUser || u.Role == models.UserRoleAccount) {
+		scope.owners[u.ID] = true
+	}
+}
+
+// addResellerTree brings a reseller, the resellers below it, and the
+// accounts any of them own into scope
+func (g *Guard) addResellerTree(ctx context.Context, scope *Scope, u *models.User) {
+	scope.owners[u.ID] = true
+	scope.parents[ResellerOwner(u.ID)] = true
+
+	if res, err := g.resellers.GetByUserID(u.ID); err == nil {
+		if tree, err := g.resellers.GetOwnershipTree(res.ID); err == nil {
+			var walk func(node *models.OwnershipNode)
+			walk = func(node *models.OwnershipNode) {
+				if r, err := g.resellers.Get(node.ID); err == nil {
+					scope.owners[r.UserID] = true
+					scope.parents[ResellerOwner(r.UserID)] = true
+				}
+				for i := range node.Children {
+					walk(&node.Children[i])
+				}
+			}
+			walk(tree)
+		}
+	}
+
+	accounts, err := g.accounts.List(ctx)
+	if err != nil {
+		fmt.Printf("warning: failed to list accounts for reseller %s: %v\n", u.ID, err)
+		return
+	}
+	for _, acct := range accounts {
+		if acct.Identity != nil && scope.parents[acct.Identity.Owner] {
+			g.addAccount(scope, acct.Identity.Name)
+		}
+	}
+}
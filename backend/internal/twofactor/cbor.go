@@ -0,0 +1,139 @@
+// Package twofactor provides two-factor authentication for OweHost
+package twofactor
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// WebAuthn encodes attestation objects and public keys in CBOR (RFC 8949).
+// Authenticators use its canonical subset, so this decoder handles definite
+// lengths only and no floats beyond skipping them.
+
+// maxCBORDepth bounds nesting; real attestation objects go three deep
+const maxCBORDepth = 16
+
+var errCBOR = errors.New("malformed CBOR")
+
+// cborDecode decodes the first item of data and returns it with the number
+// of bytes it took. Integers decode to int64, byte strings to []byte, text
+// to string, arrays to []interface{} and maps to map[interface{}]interface{}.
+func cborDecode(data []byte) (interface{}, int, error) {
+	return cborItem(data, 0)
+}
+
+func cborItem(data []byte, depth int) (interface{}, int, error) {
+	if depth > maxCBORDepth || len(data) == 0 {
+		return nil, 0, errCBOR
+	}
+	major, info := data[0]>>5, data[0]&0x1f
+
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, 1, nil
+		case 21:
+			return true, 1, nil
+		case 22, 23:
+			return nil, 1, nil
+		case 25, 26, 27:
+			size := 1 << (info - 24)
+			if len(data) < 1+size {
+				return nil, 0, errCBOR
+			}
+			return nil, 1 + size, nil
+		}
+		return nil, 0, errCBOR
+	}
+
+	arg, n, err := cborArgument(data, info)
+	if err != nil {
+		return nil, 0, err
+	}
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, 0, errCBOR
+		}
+		return int64(arg), n, nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, 0, errCBOR
+		}
+		return -1 - int64(arg), n, nil
+	case 2, 3:
+		if arg > uint64(len(data)-n) {
+			return nil, 0, errCBOR
+		}
+		end := n + int(arg)
+		if major == 2 {
+			return append([]byte(nil), data[n:end]...), end, nil
+		}
+		return string(data[n:end]), end, nil
+	case 4:
+		if arg > uint64(len(data)) {
+			return nil, 0, errCBOR
+		}
+		items := make([]interface{}, 0, int(arg))
+		for i := uint64(0); i < arg; i++ {
+			item, m, err := cborItem(data[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			items = append(items, item)
+			n += m
+		}
+		return items, n, nil
+	case 5:
+		if arg > uint64(len(data)) {
+			return nil, 0, errCBOR
+		}
+		items := make(map[interface{}]interface{}, int(arg))
+		for i := uint64(0); i < arg; i++ {
+			key, m, err := cborItem(data[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			n += m
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, 0, errCBOR
+			}
+			value, m, err := cborItem(data[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			n += m
+			items[key] = value
+		}
+		return items, n, nil
+	case 6:
+		// Tags only annotate; the tagged item is what matters
+		item, m, err := cborItem(data[n:], depth+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		return item, n + m, nil
+	}
+	return nil, 0, errCBOR
+}
+
+// cborArgument reads the argument of an item's initial byte
+func cborArgument(data []byte, info byte) (uint64, int, error) {
+	switch {
+	case info < 24:
+		return uint64(info), 1, nil
+	case info == 24 && len(data) >= 2:
+		return uint64(data[1]), 2, nil
+	case info == 25 && len(data) >= 3:
+		return uint64(binary.BigEndian.Uint16(data[1:])), 3, nil
+	case info == 26 && len(data) >= 5:
+		return uint64(binary.BigEndian.Uint32(data[1:])), 5, nil
+	case info == 27 && len(data) >= 9:
+		return binary.BigEndian.Uint64(data[1:]), 9, nil
+	}
+	// Indefinite lengths (31) and reserved values
+	return 0, 0, errCBOR
+}
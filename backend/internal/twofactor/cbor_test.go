@@ -0,0 +1,144 @@
+package twofactor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// cborHead encodes an item's initial byte and argument
+func cborHead(major byte, arg uint64) []byte {
+	switch {
+	case arg < 24:
+		return []byte{major<<5 | byte(arg)}
+	case arg <= 0xff:
+		return []byte{major<<5 | 24, byte(arg)}
+	case arg <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(arg))
+	case arg <= 0xffffffff:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(arg))
+	}
+	return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, arg)
+}
+
+func cborInt(v int64) []byte {
+	if v < 0 {
+		return cborHead(1, uint64(-1-v))
+	}
+	return cborHead(0, uint64(v))
+}
+
+func cborBytes(b []byte) []byte {
+	return append(cborHead(2, uint64(len(b))), b...)
+}
+
+func cborText(s string) []byte {
+	return append(cborHead(3, uint64(len(s))), s...)
+}
+
+// cborMap encodes a map from its keys and values, in turn
+func cborMap(items ...[]byte) []byte {
+	return append(cborHead(5, uint64(len(items)/2)), bytes.Join(items, nil)...)
+}
+
+func TestCBORDecode(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want interface{}
+		n    int
+	}{
+		{"small int", []byte{0x17}, int64(23), 1},
+		{"one byte int", []byte{0x18, 0x18}, int64(24), 2},
+		{"two byte int", []byte{0x19, 0x01, 0x00}, int64(256), 3},
+		{"four byte int", []byte{0x1a, 0x00, 0x01, 0x00, 0x00}, int64(65536), 5},
+		{"eight byte int", cborHead(0, 1<<40), int64(1 << 40), 9},
+		{"negative", []byte{0x20}, int64(-1), 1},
+		{"negative one byte", []byte{0x38, 0x63}, int64(-100), 2},
+		{"COSE RS256", cborInt(coseAlgRS256), int64(-257), 3},
+		{"bytes", []byte{0x43, 1, 2, 3}, []byte{1, 2, 3}, 4},
+		{"empty bytes", []byte{0x40}, []byte(nil), 1},
+		{"text", cborText("none"), "none", 5},
+		{"array", []byte{0x82, 0x01, 0x20}, []interface{}{int64(1), int64(-1)}, 3},
+		{"map", cborMap(cborInt(1), cborInt(2), cborText("fmt"), cborText("none")),
+			map[interface{}]interface{}{int64(1): int64(2), "fmt": "none"}, 12},
+		{"false", []byte{0xf4}, false, 1},
+		{"true", []byte{0xf5}, true, 1},
+		{"null", []byte{0xf6}, nil, 1},
+		{"half float skipped", []byte{0xf9, 0x3c, 0x00}, nil, 3},
+		{"double skipped", []byte{0xfb, 0, 0, 0, 0, 0, 0, 0, 0}, nil, 9},
+		{"tag", []byte{0xc1, 0x1a, 0x00, 0x00, 0x00, 0x01}, int64(1), 6},
+		{"trailing bytes left", []byte{0x01, 0x02}, int64(1), 1},
+	}
+	for _, tt := range tests {
+		got, n, err := cborDecode(tt.data)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) || n != tt.n {
+			t.Errorf("%s: expected %#v (%d bytes), got %#v (%d bytes)", tt.name, tt.want, tt.n, got, n)
+		}
+	}
+}
+
+func TestCBORDecode_Malformed(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"truncated argument", []byte{0x19, 0x01}},
+		{"truncated eight byte argument", []byte{0x1b, 0, 0, 0}},
+		{"truncated bytes", []byte{0x43, 1, 2}},
+		{"truncated text", []byte{0x64, 'n', 'o'}},
+		{"truncated array", []byte{0x82, 0x01}},
+		{"map without value", []byte{0xa1, 0x01}},
+		{"map with a byte string key", []byte{0xa1, 0x41, 0x00, 0x01}},
+		{"map with an array key", []byte{0xa1, 0x80, 0x01}},
+		{"indefinite bytes", []byte{0x5f, 0x41, 0x00, 0xff}},
+		{"indefinite array", []byte{0x9f, 0x01, 0xff}},
+		{"reserved argument", []byte{0x1c}},
+		{"int past int64", cborHead(0, 1<<63)},
+		{"negative past int64", cborHead(1, 1<<63)},
+		{"bytes longer than the input", cborHead(2, 1<<62)},
+		{"array longer than the input", cborHead(4, 1<<62)},
+		{"map longer than the input", cborHead(5, 1<<62)},
+		{"truncated float", []byte{0xfa, 0x00}},
+		{"unknown simple value", []byte{0xf8, 0x20}},
+		{"break outside indefinite item", []byte{0xff}},
+		{"tag without item", []byte{0xc1}},
+	}
+	for _, tt := range tests {
+		if _, _, err := cborDecode(tt.data); !errors.Is(err, errCBOR) {
+			t.Errorf("%s: expected errCBOR, got %v", tt.name, err)
+		}
+	}
+}
+
+func TestCBORDecode_Nesting(t *testing.T) {
+	nested := func(prefix byte, depth int) []byte {
+		return append(bytes.Repeat([]byte{prefix}, depth), 0x00)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		ok   bool
+	}{
+		{"arrays at the limit", nested(0x81, maxCBORDepth), true},
+		{"arrays past the limit", nested(0x81, maxCBORDepth+1), false},
+		{"maps at the limit", append(bytes.Repeat([]byte{0xa1, 0x01}, maxCBORDepth), 0x00), true},
+		{"maps past the limit", append(bytes.Repeat([]byte{0xa1, 0x01}, maxCBORDepth+1), 0x00), false},
+		{"tags past the limit", nested(0xc1, maxCBORDepth+1), false},
+		{"deeply nested", nested(0x81, 100000), false},
+	}
+	for _, tt := range tests {
+		_, _, err := cborDecode(tt.data)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: expected ok=%v, got %v", tt.name, tt.ok, err)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+// Package twofactor provides two-factor authentication for OweHost
+package twofactor
+
+import (
+	"errors"
+	"time"
+
+	"github.com/iSundram/OweHost/pkg/models"
+	"github.com/iSundram/OweHost/pkg/utils"
+)
+
+// rememberDeviceTTL is how long a device may skip the second factor
+const rememberDeviceTTL = 30 * 24 * time.Hour
+
+// ErrInvalidPolicy is returned for a policy naming an unknown role
+var ErrInvalidPolicy = errors.New("invalid 2FA policy")
+
+// rememberedDevice is a browser a user chose to trust after passing the
+// second factor. Only the token's hash is kept.
+type rememberedDevice struct {
+	Hash      string    `json:"hash"`
+	UserID    string    `json:"user_id"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// RememberDevice issues a token that lets a user skip the second factor
+// on this device until it expires
+func (s *Service) RememberDevice(userID, userAgent string) (string, time.Time, error) {
+	token, err := utils.GenerateRefreshToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	now := time.Now()
+	device := &rememberedDevice{
+		Hash:      utils.HashAPIKey(token),
+		UserID:    userID,
+		UserAgent: userAgent,
+		CreatedAt: now,
+		ExpiresAt: now.Add(rememberDeviceTTL),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, d := range s.devices {
+		if now.After(d.ExpiresAt) {
+			delete(s.devices, hash)
+		}
+	}
+	s.devices[device.Hash] = device
+	if err := s.saveLocked(); err != nil {
+		delete(s.devices, device.Hash)
+		return "", time.Time{}, err
+	}
+	return token, device.ExpiresAt, nil
+}
+
+// IsDeviceRemembered reports whether token is a live remembered device of
+// the user with a second factor still set up
+func (s *Service) IsDeviceRemembered(userID, token string) bool {
+	if token == "" {
+		return false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	device, exists := s.devices[utils.HashAPIKey(token)]
+	if !exists || device.UserID != userID || time.Now().After(device.ExpiresAt) {
+		return false
+	}
+	return s.hasSecondFactorLocked(userID)
+}
+
+// ForgetDevices makes every device of a user ask for the second factor
+// again
+func (s *Service) ForgetDevices(userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.forgetDevicesLocked(userID)
+	return s.saveLocked()
+}
+
+func (s *Service) forgetDevicesLocked(userID string) {
+	for hash, d := range s.devices {
+		if d.UserID == userID {
+			delete(s.devices, hash)
+		}
+	}
+}
+
+// GetPolicy returns who must use two-factor authentication
+func (s *Service) GetPolicy() models.TwoFactorPolicy {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.policy
+}
+
+// SetPolicy sets who must use two-factor authentication. Users it covers
+// without 2FA set up enroll at their next login.
+func (s *Service) SetPolicy(policy models.TwoFactorPolicy) error {
+	for _, role := range policy.Roles {
+		switch role {
+		case models.UserRoleAdmin, models.UserRoleReseller, models.UserRoleUser, models.UserRoleAccount:
+		default:
+			return ErrInvalidPolicy
+		}
+	}
+	policy.UpdatedAt = time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous := s.policy
+	s.policy = policy
+	if err := s.saveLocked(); err != nil {
+		s.policy = previous
+		return err
+	}
+	return nil
+}
+
+// IsRequired reports whether the policy makes a user with role, owned by
+// owner, use two-factor authentication. Owners are tenants for panel
+// users and the owning reseller for accounts.
+func (s *Service) IsRequired(role models.UserRole, owner string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, r := range s.policy.Roles {
+		if r == role {
+			return true
+		}
+	}
+	for _, o := range s.policy.Owners {
+		if owner != "" && o == owner {
+			return true
+		}
+	}
+	return false
+}
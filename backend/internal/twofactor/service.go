@@ -33,16 +33,36 @@ type Service struct {
 	configs      map[string]*models.TwoFactorConfig
 	attempts     []*models.LoginAttempt
 	pendingSetup map[string]string // userID -> secret (before verification)
+	pendingCodes map[string][]string // userID -> backup codes shown at setup
+	devices      map[string]*rememberedDevice
+	policy       models.TwoFactorPolicy
+	credentials  map[string]*models.WebAuthnCredential // base64url credential ID -> credential
+	ceremonies   map[string]*webauthnCeremony
+	rpID         string
+	rpName       string
+	origins      []string
+	statePath    string
 	mu           sync.RWMutex
 }
 
 // NewService creates a new 2FA service
 func NewService() *Service {
-	return &Service{
+	s := &Service{
 		configs:      make(map[string]*models.TwoFactorConfig),
 		attempts:     make([]*models.LoginAttempt, 0),
 		pendingSetup: make(map[string]string),
+		pendingCodes: make(map[string][]string),
+		devices:      make(map[string]*rememberedDevice),
+		credentials:  make(map[string]*models.WebAuthnCredential),
+		ceremonies:   make(map[string]*webauthnCeremony),
+		rpID:         "localhost",
+		rpName:       totpIssuer,
+		statePath:    statePath,
 	}
+	if err := s.load(); err != nil {
+		fmt.Printf("warning: failed to load 2FA state: %v\n", err)
+	}
+	return s
 }
 
 // SetupTOTP initiates TOTP setup for a user
@@ -69,8 +89,9 @@ func (s *Service) SetupTOTP(userID, username string) (*models.TOTPSetupResponse,
 	qrURL := fmt.Sprintf("otpauth://totp/%s:%s?secret=%s&issuer=%s&digits=%d&period=%d",
 		totpIssuer, username, secretBase32, totpIssuer, totpDigits, totpPeriod)
 
-	// Generate backup codes
+	// Generate backup codes; they take effect with the secret
 	backupCodes := s.generateBackupCodes()
+	s.pendingCodes[userID] = backupCodes
 
 	return &models.TOTPSetupResponse{
 		Secret:      secretBase32,
@@ -94,11 +115,11 @@ func (s *Service) VerifySetup(userID, code string) error {
 		return errors.New("invalid verification code")
 	}
 
-	// Generate and hash backup codes
-	backupCodes := s.generateBackupCodes()
+	// Hash the backup codes the user was shown
+	backupCodes := s.pendingCodes[userID]
 	hashedCodes := make([]string, len(backupCodes))
 	for i, code := range backupCodes {
-		hash, _ := utils.HashPassword(code)
+		hash, _ := utils.HashPassword(strings.ReplaceAll(code, "-", ""))
 		hashedCodes[i] = hash
 	}
 
@@ -118,6 +139,8 @@ func (s *Service) VerifySetup(userID, code string) error {
 
 	s.configs[userID] = config
 	delete(s.pendingSetup, userID)
+	delete(s.pendingCodes, userID)
+	s.saveOrWarn()
 
 	return nil
 }
@@ -136,6 +159,7 @@ func (s *Service) VerifyCode(userID, code string) (bool, error) {
 	if s.verifyTOTP(config.Secret, code) {
 		now := time.Now()
 		config.LastUsedAt = &now
+		s.saveOrWarn()
 		return true, nil
 	}
 
@@ -148,6 +172,7 @@ func (s *Service) VerifyCode(userID, code string) (bool, error) {
 			config.BackupCodesUsed++
 			now := time.Now()
 			config.LastUsedAt = &now
+			s.saveOrWarn()
 			return true, nil
 		}
 	}
@@ -192,6 +217,8 @@ func (s *Service) Disable(userID, code string) error {
 	}
 
 	delete(s.configs, userID)
+	s.forgetDevicesLocked(userID)
+	s.saveOrWarn()
 	return nil
 }
 
@@ -214,13 +241,14 @@ func (s *Service) RegenerateBackupCodes(userID, code string) ([]string, error) {
 	backupCodes := s.generateBackupCodes()
 	hashedCodes := make([]string, len(backupCodes))
 	for i, code := range backupCodes {
-		hash, _ := utils.HashPassword(code)
+		hash, _ := utils.HashPassword(strings.ReplaceAll(code, "-", ""))
 		hashedCodes[i] = hash
 	}
 
 	config.BackupCodes = hashedCodes
 	config.BackupCodesUsed = 0
 	config.UpdatedAt = time.Now()
+	s.saveOrWarn()
 
 	return backupCodes, nil
 }
@@ -346,5 +374,13 @@ func (s *Service) DeleteByUser(userID string) error {
 
 	delete(s.configs, userID)
 	delete(s.pendingSetup, userID)
+	delete(s.pendingCodes, userID)
+	for key, c := range s.credentials {
+		if c.UserID == userID {
+			delete(s.credentials, key)
+		}
+	}
+	s.forgetDevicesLocked(userID)
+	s.saveOrWarn()
 	return nil
 }
@@ -0,0 +1,125 @@
+// Package twofactor provides two-factor authentication for OweHost
+package twofactor
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/iSundram/OweHost/pkg/models"
+)
+
+// statePath holds the enabled second factors, security keys, remembered
+// devices and the enforcement policy. Losing them on restart would quietly
+// turn 2FA off.
+const statePath = "/var/lib/owehost/auth/twofactor.json"
+
+// storedState is what statePath holds
+type storedState struct {
+	Configs     []storedConfig          `json:"configs"`
+	Credentials []storedCredential      `json:"credentials"`
+	Devices     []*rememberedDevice     `json:"devices"`
+	Policy      *models.TwoFactorPolicy `json:"policy,omitempty"`
+}
+
+// storedConfig is a config with the secrets the API never shows
+type storedConfig struct {
+	*models.TwoFactorConfig
+	TOTPSecret       string   `json:"secret"`
+	BackupCodeHashes []string `json:"backup_codes"`
+}
+
+// storedCredential is a security key with the key material the API never
+// shows
+type storedCredential struct {
+	*models.WebAuthnCredential
+	RawCredentialID []byte `json:"credential_id"`
+	RawPublicKey    []byte `json:"public_key"`
+	RawAAGUID       []byte `json:"aaguid,omitempty"`
+}
+
+// load reads the saved state. Nothing saved yet is not an error.
+func (s *Service) load() error {
+	data, err := os.ReadFile(s.statePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var state storedState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	for _, c := range state.Configs {
+		if c.TwoFactorConfig == nil {
+			continue
+		}
+		c.Secret = c.TOTPSecret
+		c.BackupCodes = c.BackupCodeHashes
+		s.configs[c.UserID] = c.TwoFactorConfig
+	}
+	for _, c := range state.Credentials {
+		if c.WebAuthnCredential == nil {
+			continue
+		}
+		c.CredentialID = c.RawCredentialID
+		c.PublicKey = c.RawPublicKey
+		c.AAGUID = c.RawAAGUID
+		s.credentials[b64(c.CredentialID)] = c.WebAuthnCredential
+	}
+	for _, d := range state.Devices {
+		s.devices[d.Hash] = d
+	}
+	if state.Policy != nil {
+		s.policy = *state.Policy
+	}
+	return nil
+}
+
+// saveLocked writes the state out; s.mu must be held. It holds TOTP
+// secrets, so only root may read it.
+func (s *Service) saveLocked() error {
+	state := storedState{
+		Configs:     make([]storedConfig, 0, len(s.configs)),
+		Credentials: make([]storedCredential, 0, len(s.credentials)),
+		Devices:     make([]*rememberedDevice, 0, len(s.devices)),
+		Policy:      &s.policy,
+	}
+	for _, c := range s.configs {
+		state.Configs = append(state.Configs, storedConfig{TwoFactorConfig: c, TOTPSecret: c.Secret, BackupCodeHashes: c.BackupCodes})
+	}
+	sort.Slice(state.Configs, func(i, j int) bool { return state.Configs[i].UserID < state.Configs[j].UserID })
+	for _, c := range s.credentials {
+		state.Credentials = append(state.Credentials, storedCredential{WebAuthnCredential: c, RawCredentialID: c.CredentialID, RawPublicKey: c.PublicKey, RawAAGUID: c.AAGUID})
+	}
+	sort.Slice(state.Credentials, func(i, j int) bool { return state.Credentials[i].ID < state.Credentials[j].ID })
+	for _, d := range s.devices {
+		state.Devices = append(state.Devices, d)
+	}
+	sort.Slice(state.Devices, func(i, j int) bool { return state.Devices[i].CreatedAt.Before(state.Devices[j].CreatedAt) })
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.statePath), 0700); err != nil {
+		return err
+	}
+	tmp := s.statePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.statePath)
+}
+
+// saveOrWarn saves where the change has already been made and can't be
+// failed anymore
+func (s *Service) saveOrWarn() {
+	if err := s.saveLocked(); err != nil {
+		fmt.Printf("warning: failed to save 2FA state: %v\n", err)
+	}
+}
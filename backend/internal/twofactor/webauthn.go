@@ -0,0 +1,752 @@
+// Package twofactor provides two-factor authentication for OweHost
+package twofactor
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/iSundram/OweHost/pkg/models"
+	"github.com/iSundram/OweHost/pkg/utils"
+)
+
+// WebAuthn ceremonies must finish within webauthnTimeout of starting.
+// Attestation is not asked for: any authenticator is welcome, so there is
+// nothing to verify it against.
+const (
+	webauthnTimeout       = 5 * time.Minute
+	maxWebAuthnCeremonies = 10000
+)
+
+// COSE algorithms accepted for credentials
+const (
+	coseAlgES256 = -7
+	coseAlgEdDSA = -8
+	coseAlgRS256 = -257
+)
+
+// Authenticator data flags
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+)
+
+// WebAuthn errors
+var (
+	ErrWebAuthnCeremony     = errors.New("no WebAuthn ceremony in progress, or it expired")
+	ErrWebAuthnResponse     = errors.New("invalid WebAuthn response")
+	ErrCredentialNotFound   = errors.New("credential not found")
+	ErrCredentialExists     = errors.New("credential already registered")
+	ErrCredentialCloned     = errors.New("credential signature counter went backwards; the key may have been cloned")
+	ErrUnsupportedAlgorithm = errors.New("unsupported credential algorithm")
+	ErrAttestationFormat    = errors.New(`only attestation "none" is accepted`)
+)
+
+// RelyingParty names the panel to authenticators
+type RelyingParty struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name"`
+}
+
+// WebAuthnUser is the user a credential is created for. ID is an opaque
+// handle, base64url encoded like every binary value below.
+type WebAuthnUser struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+// CredentialParameter is an accepted credential type and algorithm
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+// CredentialDescriptor identifies a credential
+type CredentialDescriptor struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+// AuthenticatorSelection states what authenticators may be used
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// CredentialCreationOptions are passed to navigator.credentials.create
+type CredentialCreationOptions struct {
+	RP                     RelyingParty           `json:"rp"`
+	User                   WebAuthnUser           `json:"user"`
+	Challenge              string                 `json:"challenge"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// CredentialRequestOptions are passed to navigator.credentials.get
+type CredentialRequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	Timeout          int64                  `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials,omitempty"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// PublicKeyCredential is what the browser returns from either ceremony,
+// as serialized by its toJSON
+type PublicKeyCredential struct {
+	ID       string                `json:"id"`
+	RawID    string                `json:"rawId"`
+	Type     string                `json:"type"`
+	Response AuthenticatorResponse `json:"response"`
+}
+
+// AuthenticatorResponse holds the attestation of a new credential or the
+// assertion of an existing one
+type AuthenticatorResponse struct {
+	ClientDataJSON    string   `json:"clientDataJSON"`
+	AttestationObject string   `json:"attestationObject,omitempty"`
+	Transports        []string `json:"transports,omitempty"`
+	AuthenticatorData string   `json:"authenticatorData,omitempty"`
+	Signature         string   `json:"signature,omitempty"`
+	UserHandle        string   `json:"userHandle,omitempty"`
+}
+
+// webauthnCeremony is a registration or assertion waiting for the
+// authenticator's answer
+type webauthnCeremony struct {
+	challenge []byte
+	userID    string
+	expiresAt time.Time
+}
+
+// authenticatorData is the parsed authenticator data of a response
+type authenticatorData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	aaguid       []byte
+	credentialID []byte
+	publicKey    []byte // COSE_Key
+}
+
+// ConfigureWebAuthn sets the relying party: the domain credentials are
+// bound to and the origins the panel is served from. Without origins, any
+// HTTPS origin on the domain or its subdomains is accepted.
+func (s *Service) ConfigureWebAuthn(rpID, rpName string, origins []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rpID = rpID
+	s.rpName = rpName
+	s.origins = origins
+}
+
+// BeginRegistration starts registering a new credential for a user
+func (s *Service) BeginRegistration(userID, username string) (*CredentialCreationOptions, error) {
+	challenge, err := s.startCeremony("register:"+userID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return &CredentialCreationOptions{
+		RP:        RelyingParty{ID: s.rpID, Name: s.rpName},
+		User:      WebAuthnUser{ID: b64(userHandle(userID)), Name: username, DisplayName: username},
+		Challenge: b64(challenge),
+		PubKeyCredParams: []CredentialParameter{
+			{Type: "public-key", Alg: coseAlgES256},
+			{Type: "public-key", Alg: coseAlgEdDSA},
+			{Type: "public-key", Alg: coseAlgRS256},
+		},
+		Timeout:            webauthnTimeout.Milliseconds(),
+		ExcludeCredentials: s.descriptorsLocked(userID),
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:      "preferred",
+			UserVerification: "preferred",
+		},
+		Attestation: "none",
+	}, nil
+}
+
+// FinishRegistration checks the authenticator's answer to BeginRegistration
+// and adds the credential under name
+func (s *Service) FinishRegistration(userID, name string, cred *PublicKeyCredential) (*models.WebAuthnCredential, error) {
+	ceremony, err := s.takeCeremony("register:" + userID)
+	if err != nil {
+		return nil, err
+	}
+
+	clientData, err := decodeB64(cred.Response.ClientDataJSON)
+	if err != nil {
+		return nil, ErrWebAuthnResponse
+	}
+	if err := s.checkClientData(clientData, "webauthn.create", ceremony.challenge); err != nil {
+		return nil, err
+	}
+
+	raw, err := decodeB64(cred.Response.AttestationObject)
+	if err != nil {
+		return nil, ErrWebAuthnResponse
+	}
+	decoded, _, err := cborDecode(raw)
+	if err != nil {
+		return nil, ErrWebAuthnResponse
+	}
+	attestation, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, ErrWebAuthnResponse
+	}
+	if format, _ := attestation["fmt"].(string); format != "none" {
+		return nil, ErrAttestationFormat
+	}
+	authData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return nil, ErrWebAuthnResponse
+	}
+
+	ad, err := parseAuthenticatorData(authData)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkAuthenticatorData(ad, false); err != nil {
+		return nil, err
+	}
+	if ad.flags&flagAttested == 0 {
+		return nil, ErrWebAuthnResponse
+	}
+	if rawID, err := decodeB64(cred.RawID); err != nil || !bytes.Equal(rawID, ad.credentialID) {
+		return nil, ErrWebAuthnResponse
+	}
+	if _, _, err := parseCOSEKey(ad.publicKey); err != nil {
+		return nil, err
+	}
+
+	if name == "" {
+		name = "Security key"
+	}
+	now := time.Now()
+	credential := &models.WebAuthnCredential{
+		ID:           utils.GenerateID("wac"),
+		UserID:       userID,
+		Name:         name,
+		CredentialID: ad.credentialID,
+		PublicKey:    ad.publicKey,
+		AAGUID:       ad.aaguid,
+		SignCount:    ad.signCount,
+		Transports:   cred.Response.Transports,
+		CreatedAt:    now,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := b64(ad.credentialID)
+	if _, exists := s.credentials[key]; exists {
+		return nil, ErrCredentialExists
+	}
+	s.credentials[key] = credential
+	if err := s.saveLocked(); err != nil {
+		delete(s.credentials, key)
+		return nil, err
+	}
+	copied := *credential
+	return &copied, nil
+}
+
+// BeginAssertion starts a second-factor check of a user's credentials
+func (s *Service) BeginAssertion(userID string) (*CredentialRequestOptions, error) {
+	s.mu.RLock()
+	allowed := s.descriptorsLocked(userID)
+	s.mu.RUnlock()
+	if len(allowed) == 0 {
+		return nil, ErrCredentialNotFound
+	}
+
+	challenge, err := s.startCeremony("assert:"+userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return &CredentialRequestOptions{
+		Challenge:        b64(challenge),
+		Timeout:          webauthnTimeout.Milliseconds(),
+		RPID:             s.rpID,
+		AllowCredentials: allowed,
+		UserVerification: "preferred",
+	}, nil
+}
+
+// FinishAssertion checks the answer to BeginAssertion came from one of the
+// user's credentials
+func (s *Service) FinishAssertion(userID string, cred *PublicKeyCredential) error {
+	ceremony, err := s.takeCeremony("assert:" + userID)
+	if err != nil {
+		return err
+	}
+	credential, err := s.verifyAssertion(ceremony.challenge, cred, false)
+	if err != nil {
+		return err
+	}
+	if credential.UserID != userID {
+		return ErrCredentialNotFound
+	}
+	return nil
+}
+
+// BeginPasskeyLogin starts a login without a username or password. The
+// browser offers the passkeys it holds for the panel; the returned session
+// ties the answer to this challenge.
+func (s *Service) BeginPasskeyLogin() (string, *CredentialRequestOptions, error) {
+	session, err := utils.GenerateRefreshToken()
+	if err != nil {
+		return "", nil, err
+	}
+	challenge, err := s.startCeremony("passkey:"+session, "")
+	if err != nil {
+		return "", nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return session, &CredentialRequestOptions{
+		Challenge:        b64(challenge),
+		Timeout:          webauthnTimeout.Milliseconds(),
+		RPID:             s.rpID,
+		UserVerification: "required",
+	}, nil
+}
+
+// FinishPasskeyLogin checks the answer to BeginPasskeyLogin and returns
+// whose passkey it was. The authenticator must have verified the user, by
+// PIN or biometrics, as the passkey stands in for password and code both.
+func (s *Service) FinishPasskeyLogin(session string, cred *PublicKeyCredential) (string, error) {
+	ceremony, err := s.takeCeremony("passkey:" + session)
+	if err != nil {
+		return "", err
+	}
+	credential, err := s.verifyAssertion(ceremony.challenge, cred, true)
+	if err != nil {
+		return "", err
+	}
+	handle, err := decodeB64(cred.Response.UserHandle)
+	if err != nil || !bytes.Equal(handle, userHandle(credential.UserID)) {
+		return "", ErrWebAuthnResponse
+	}
+	return credential.UserID, nil
+}
+
+// verifyAssertion checks an assertion's signature and counter and returns
+// the credential that made it
+func (s *Service) verifyAssertion(challenge []byte, cred *PublicKeyCredential, requireUV bool) (*models.WebAuthnCredential, error) {
+	rawID, err := decodeB64(cred.RawID)
+	if err != nil {
+		return nil, ErrWebAuthnResponse
+	}
+	s.mu.RLock()
+	stored, exists := s.credentials[b64(rawID)]
+	var credential models.WebAuthnCredential
+	if exists {
+		credential = *stored
+	}
+	s.mu.RUnlock()
+	if !exists {
+		return nil, ErrCredentialNotFound
+	}
+	if credential.CloneDetectedAt != nil {
+		return nil, ErrCredentialCloned
+	}
+
+	clientData, err := decodeB64(cred.Response.ClientDataJSON)
+	if err != nil {
+		return nil, ErrWebAuthnResponse
+	}
+	if err := s.checkClientData(clientData, "webauthn.get", challenge); err != nil {
+		return nil, err
+	}
+	authData, err := decodeB64(cred.Response.AuthenticatorData)
+	if err != nil {
+		return nil, ErrWebAuthnResponse
+	}
+	ad, err := parseAuthenticatorData(authData)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkAuthenticatorData(ad, requireUV); err != nil {
+		return nil, err
+	}
+	signature, err := decodeB64(cred.Response.Signature)
+	if err != nil {
+		return nil, ErrWebAuthnResponse
+	}
+
+	alg, publicKey, err := parseCOSEKey(credential.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	clientDataHash := sha256.Sum256(clientData)
+	signed := append(append([]byte(nil), authData...), clientDataHash[:]...)
+	if !verifySignature(alg, publicKey, signed, signature) {
+		return nil, ErrWebAuthnResponse
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, exists = s.credentials[b64(rawID)]
+	if !exists {
+		return nil, ErrCredentialNotFound
+	}
+	now := time.Now()
+	// Authenticators without a counter always send 0. Any other counter
+	// must have gone up since the last use, or two copies of the key exist.
+	if (ad.signCount != 0 || stored.SignCount != 0) && ad.signCount <= stored.SignCount {
+		stored.CloneDetectedAt = &now
+		s.saveOrWarn()
+		return nil, ErrCredentialCloned
+	}
+	stored.SignCount = ad.signCount
+	stored.LastUsedAt = &now
+	s.saveOrWarn()
+
+	copied := *stored
+	return &copied, nil
+}
+
+// ListCredentials returns a user's credentials, oldest first
+func (s *Service) ListCredentials(userID string) []*models.WebAuthnCredential {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	credentials := make([]*models.WebAuthnCredential, 0)
+	for _, c := range s.credentials {
+		if c.UserID == userID {
+			copied := *c
+			credentials = append(credentials, &copied)
+		}
+	}
+	sort.Slice(credentials, func(i, j int) bool { return credentials[i].CreatedAt.Before(credentials[j].CreatedAt) })
+	return credentials
+}
+
+// RenameCredential renames one of a user's credentials
+func (s *Service) RenameCredential(userID, id, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range s.credentials {
+		if c.ID == id && c.UserID == userID {
+			c.Name = name
+			return s.saveLocked()
+		}
+	}
+	return ErrCredentialNotFound
+}
+
+// DeleteCredential removes one of a user's credentials
+func (s *Service) DeleteCredential(userID, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, c := range s.credentials {
+		if c.ID == id && c.UserID == userID {
+			delete(s.credentials, key)
+			if !s.hasSecondFactorLocked(userID) {
+				s.forgetDevicesLocked(userID)
+			}
+			return s.saveLocked()
+		}
+	}
+	return ErrCredentialNotFound
+}
+
+// HasSecondFactor reports whether a user has TOTP or a security key set up
+func (s *Service) HasSecondFactor(userID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.hasSecondFactorLocked(userID)
+}
+
+func (s *Service) hasSecondFactorLocked(userID string) bool {
+	if config, exists := s.configs[userID]; exists && config.Enabled {
+		return true
+	}
+	for _, c := range s.credentials {
+		if c.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// Methods lists the second factors a user can log in with
+func (s *Service) Methods(userID string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	methods := make([]string, 0, 2)
+	if config, exists := s.configs[userID]; exists && config.Enabled {
+		methods = append(methods, string(models.TwoFactorTypeTOTP))
+	}
+	for _, c := range s.credentials {
+		if c.UserID == userID {
+			methods = append(methods, string(models.TwoFactorTypeWebAuthn))
+			break
+		}
+	}
+	return methods
+}
+
+func (s *Service) descriptorsLocked(userID string) []CredentialDescriptor {
+	descriptors := make([]CredentialDescriptor, 0)
+	for _, c := range s.credentials {
+		if c.UserID == userID && c.CloneDetectedAt == nil {
+			descriptors = append(descriptors, CredentialDescriptor{Type: "public-key", ID: b64(c.CredentialID), Transports: c.Transports})
+		}
+	}
+	return descriptors
+}
+
+// startCeremony issues the challenge of a ceremony, replacing any earlier
+// one under the same key
+func (s *Service) startCeremony(key, userID string) ([]byte, error) {
+	challenge := make([]byte, 32)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, c := range s.ceremonies {
+		if now.After(c.expiresAt) {
+			delete(s.ceremonies, k)
+		}
+	}
+	if len(s.ceremonies) >= maxWebAuthnCeremonies {
+		return nil, errors.New("too many WebAuthn ceremonies in progress")
+	}
+	s.ceremonies[key] = &webauthnCeremony{challenge: challenge, userID: userID, expiresAt: now.Add(webauthnTimeout)}
+	return challenge, nil
+}
+
+// takeCeremony ends a ceremony. Each challenge is answered at most once.
+func (s *Service) takeCeremony(key string) (*webauthnCeremony, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ceremony, exists := s.ceremonies[key]
+	delete(s.ceremonies, key)
+	if !exists || time.Now().After(ceremony.expiresAt) {
+		return nil, ErrWebAuthnCeremony
+	}
+	return ceremony, nil
+}
+
+// checkClientData checks what the browser says it signed: the ceremony,
+// the challenge and the page that asked
+func (s *Service) checkClientData(raw []byte, ceremonyType string, challenge []byte) error {
+	var clientData struct {
+		Type        string `json:"type"`
+		Challenge   string `json:"challenge"`
+		Origin      string `json:"origin"`
+		CrossOrigin bool   `json:"crossOrigin"`
+	}
+	if err := json.Unmarshal(raw, &clientData); err != nil {
+		return ErrWebAuthnResponse
+	}
+	if clientData.Type != ceremonyType || clientData.CrossOrigin {
+		return ErrWebAuthnResponse
+	}
+	got, err := decodeB64(clientData.Challenge)
+	if err != nil || subtle.ConstantTimeCompare(got, challenge) != 1 {
+		return ErrWebAuthnResponse
+	}
+	if !s.originAllowed(clientData.Origin) {
+		return ErrWebAuthnResponse
+	}
+	return nil
+}
+
+func (s *Service) originAllowed(origin string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.origins) > 0 {
+		for _, o := range s.origins {
+			if o == origin {
+				return true
+			}
+		}
+		return false
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	host := u.Hostname()
+	if u.Scheme != "https" && !(u.Scheme == "http" && host == "localhost") {
+		return false
+	}
+	return host == s.rpID || strings.HasSuffix(host, "."+s.rpID)
+}
+
+// checkAuthenticatorData checks the authenticator acted for this panel
+// with the user present, and verified if required
+func (s *Service) checkAuthenticatorData(ad *authenticatorData, requireUV bool) error {
+	s.mu.RLock()
+	rpIDHash := sha256.Sum256([]byte(s.rpID))
+	s.mu.RUnlock()
+
+	if subtle.ConstantTimeCompare(ad.rpIDHash, rpIDHash[:]) != 1 {
+		return ErrWebAuthnResponse
+	}
+	if ad.flags&flagUserPresent == 0 {
+		return ErrWebAuthnResponse
+	}
+	if requireUV && ad.flags&flagUserVerified == 0 {
+		return ErrWebAuthnResponse
+	}
+	return nil
+}
+
+// parseAuthenticatorData splits authenticator data into its fields. The
+// credential's key is only there at registration.
+func parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < 37 {
+		return nil, ErrWebAuthnResponse
+	}
+	ad := &authenticatorData{
+		rpIDHash:  data[:32],
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	if ad.flags&flagAttested == 0 {
+		return ad, nil
+	}
+
+	rest := data[37:]
+	if len(rest) < 18 {
+		return nil, ErrWebAuthnResponse
+	}
+	ad.aaguid = rest[:16]
+	idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if idLen == 0 || idLen > 1023 || len(rest) < idLen {
+		return nil, ErrWebAuthnResponse
+	}
+	ad.credentialID = rest[:idLen]
+	rest = rest[idLen:]
+	_, n, err := cborDecode(rest)
+	if err != nil {
+		return nil, ErrWebAuthnResponse
+	}
+	ad.publicKey = rest[:n]
+	return ad, nil
+}
+
+// parseCOSEKey parses a credential's public key
+func parseCOSEKey(data []byte) (int64, crypto.PublicKey, error) {
+	decoded, _, err := cborDecode(data)
+	if err != nil {
+		return 0, nil, ErrWebAuthnResponse
+	}
+	key, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return 0, nil, ErrWebAuthnResponse
+	}
+	kty, _ := key[int64(1)].(int64)
+	alg, _ := key[int64(3)].(int64)
+
+	switch alg {
+	case coseAlgES256:
+		crv, _ := key[int64(-1)].(int64)
+		x, _ := key[int64(-2)].([]byte)
+		y, _ := key[int64(-3)].([]byte)
+		if kty != 2 || crv != 1 || len(x) != 32 || len(y) != 32 {
+			return 0, nil, ErrWebAuthnResponse
+		}
+		// ecdh checks the point is on the curve
+		point := append(append([]byte{4}, x...), y...)
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return 0, nil, ErrWebAuthnResponse
+		}
+		return alg, &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case coseAlgEdDSA:
+		crv, _ := key[int64(-1)].(int64)
+		x, _ := key[int64(-2)].([]byte)
+		if kty != 1 || crv != 6 || len(x) != ed25519.PublicKeySize {
+			return 0, nil, ErrWebAuthnResponse
+		}
+		return alg, ed25519.PublicKey(x), nil
+	case coseAlgRS256:
+		n, _ := key[int64(-1)].([]byte)
+		e, _ := key[int64(-2)].([]byte)
+		if kty != 3 || len(e) == 0 || len(e) > 4 {
+			return 0, nil, ErrWebAuthnResponse
+		}
+		modulus := new(big.Int).SetBytes(n)
+		exponent := int(new(big.Int).SetBytes(e).Int64())
+		if modulus.BitLen() < 2048 || exponent < 3 {
+			return 0, nil, ErrWebAuthnResponse
+		}
+		return alg, &rsa.PublicKey{N: modulus, E: exponent}, nil
+	}
+	return 0, nil, ErrUnsupportedAlgorithm
+}
+
+// verifySignature checks a signature made with a credential's key
+func verifySignature(alg int64, publicKey crypto.PublicKey, message, signature []byte) bool {
+	digest := sha256.Sum256(message)
+	switch alg {
+	case coseAlgES256:
+		key, ok := publicKey.(*ecdsa.PublicKey)
+		return ok && ecdsa.VerifyASN1(key, digest[:], signature)
+	case coseAlgEdDSA:
+		key, ok := publicKey.(ed25519.PublicKey)
+		return ok && ed25519.Verify(key, message, signature)
+	case coseAlgRS256:
+		key, ok := publicKey.(*rsa.PublicKey)
+		return ok && rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	}
+	return false
+}
+
+// userHandle is the opaque ID authenticators store for a user, so a
+// passkey names its owner without revealing the panel's user ID
+func userHandle(userID string) []byte {
+	sum := sha256.Sum256([]byte("owehost-webauthn:" + userID))
+	return sum[:]
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeB64 decodes base64url as browsers send it, tolerating padding
+func decodeB64(s string) ([]byte, error) {
+	if s == "" {
+		return nil, ErrWebAuthnResponse
+	}
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
@@ -0,0 +1,596 @@
+package twofactor
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"github.com/iSundram/OweHost/pkg/models"
+)
+
+const (
+	testRPID   = "example.com"
+	testOrigin = "https://panel.example.com"
+)
+
+// newTestService returns a service that keeps its state in dir, for the
+// relying party example.com
+func newTestService(t *testing.T, dir string) *Service {
+	t.Helper()
+	s := &Service{
+		configs:      make(map[string]*models.TwoFactorConfig),
+		pendingSetup: make(map[string]string),
+		pendingCodes: make(map[string][]string),
+		devices:      make(map[string]*rememberedDevice),
+		credentials:  make(map[string]*models.WebAuthnCredential),
+		ceremonies:   make(map[string]*webauthnCeremony),
+		rpName:       totpIssuer,
+		statePath:    filepath.Join(dir, "twofactor.json"),
+	}
+	if err := s.load(); err != nil {
+		t.Fatalf("Failed to load 2FA state: %v", err)
+	}
+	s.ConfigureWebAuthn(testRPID, totpIssuer, nil)
+	return s
+}
+
+// testAuthenticator is a security key holding one credential
+type testAuthenticator struct {
+	id        []byte
+	alg       int64
+	coseKey   []byte
+	sign      func(message []byte) []byte
+	signCount uint32
+}
+
+func newAuthenticator(t *testing.T, alg int64) *testAuthenticator {
+	t.Helper()
+	a := &testAuthenticator{id: make([]byte, 16), alg: alg}
+	if _, err := rand.Read(a.id); err != nil {
+		t.Fatal(err)
+	}
+
+	switch alg {
+	case coseAlgES256:
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.coseKey = cborMap(
+			cborInt(1), cborInt(2),
+			cborInt(3), cborInt(alg),
+			cborInt(-1), cborInt(1),
+			cborInt(-2), cborBytes(key.X.FillBytes(make([]byte, 32))),
+			cborInt(-3), cborBytes(key.Y.FillBytes(make([]byte, 32))),
+		)
+		a.sign = func(message []byte) []byte {
+			digest := sha256.Sum256(message)
+			sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			return sig
+		}
+	case coseAlgEdDSA:
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.coseKey = cborMap(
+			cborInt(1), cborInt(1),
+			cborInt(3), cborInt(alg),
+			cborInt(-1), cborInt(6),
+			cborInt(-2), cborBytes(public),
+		)
+		a.sign = func(message []byte) []byte {
+			return ed25519.Sign(private, message)
+		}
+	case coseAlgRS256:
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.coseKey = cborMap(
+			cborInt(1), cborInt(3),
+			cborInt(3), cborInt(alg),
+			cborInt(-1), cborBytes(key.N.Bytes()),
+			cborInt(-2), cborBytes(big.NewInt(int64(key.E)).Bytes()),
+		)
+		a.sign = func(message []byte) []byte {
+			digest := sha256.Sum256(message)
+			sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			return sig
+		}
+	default:
+		t.Fatalf("Unknown algorithm %d", alg)
+	}
+	return a
+}
+
+// ceremonyAnswer is what an authenticator and browser put in an answer,
+// for tests to tamper with before it is encoded
+type ceremonyAnswer struct {
+	ceremonyType string
+	challenge    []byte
+	origin       string
+	crossOrigin  bool
+	rpID         string
+	flags        byte
+	signCount    uint32
+	format       string
+	rawID        []byte
+	userHandle   []byte
+	badSignature bool
+}
+
+func (a *testAuthenticator) answer(challenge string, ceremonyType string, flags byte) *ceremonyAnswer {
+	decoded, err := decodeB64(challenge)
+	if err != nil {
+		panic(err)
+	}
+	return &ceremonyAnswer{
+		ceremonyType: ceremonyType,
+		challenge:    decoded,
+		origin:       testOrigin,
+		rpID:         testRPID,
+		flags:        flags,
+		signCount:    a.signCount,
+		format:       "none",
+		rawID:        a.id,
+	}
+}
+
+func (a *testAuthenticator) clientData(ans *ceremonyAnswer) []byte {
+	data, _ := json.Marshal(map[string]interface{}{
+		"type":        ans.ceremonyType,
+		"challenge":   b64(ans.challenge),
+		"origin":      ans.origin,
+		"crossOrigin": ans.crossOrigin,
+	})
+	return data
+}
+
+func (a *testAuthenticator) authData(ans *ceremonyAnswer) []byte {
+	rpIDHash := sha256.Sum256([]byte(ans.rpID))
+	data := append(rpIDHash[:], ans.flags)
+	data = binary.BigEndian.AppendUint32(data, ans.signCount)
+	if ans.flags&flagAttested != 0 {
+		data = append(data, make([]byte, 16)...)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.id)))
+		data = append(data, a.id...)
+		data = append(data, a.coseKey...)
+	}
+	return data
+}
+
+// attestation encodes a registration answer
+func (a *testAuthenticator) attestation(ans *ceremonyAnswer) *PublicKeyCredential {
+	object := cborMap(
+		cborText("fmt"), cborText(ans.format),
+		cborText("attStmt"), cborMap(),
+		cborText("authData"), cborBytes(a.authData(ans)),
+	)
+	return &PublicKeyCredential{
+		ID:    b64(ans.rawID),
+		RawID: b64(ans.rawID),
+		Type:  "public-key",
+		Response: AuthenticatorResponse{
+			ClientDataJSON:    b64(a.clientData(ans)),
+			AttestationObject: b64(object),
+		},
+	}
+}
+
+// assertion encodes and signs an assertion answer
+func (a *testAuthenticator) assertion(ans *ceremonyAnswer) *PublicKeyCredential {
+	clientData := a.clientData(ans)
+	authData := a.authData(ans)
+	clientDataHash := sha256.Sum256(clientData)
+	signature := a.sign(append(append([]byte(nil), authData...), clientDataHash[:]...))
+	if ans.badSignature {
+		signature[len(signature)-1] ^= 0xff
+	}
+	cred := &PublicKeyCredential{
+		ID:    b64(ans.rawID),
+		RawID: b64(ans.rawID),
+		Type:  "public-key",
+		Response: AuthenticatorResponse{
+			ClientDataJSON:    b64(clientData),
+			AuthenticatorData: b64(authData),
+			Signature:         b64(signature),
+		},
+	}
+	if ans.userHandle != nil {
+		cred.Response.UserHandle = b64(ans.userHandle)
+	}
+	return cred
+}
+
+// register adds a's credential to userID's security keys
+func register(t *testing.T, s *Service, userID string, a *testAuthenticator) *models.WebAuthnCredential {
+	t.Helper()
+	options, err := s.BeginRegistration(userID, userID)
+	if err != nil {
+		t.Fatalf("Failed to begin registration: %v", err)
+	}
+	ans := a.answer(options.Challenge, "webauthn.create", flagUserPresent|flagAttested)
+	credential, err := s.FinishRegistration(userID, "", a.attestation(ans))
+	if err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	return credential
+}
+
+// assert answers a second-factor check for userID with a, as changed by
+// tamper
+func assert(t *testing.T, s *Service, userID string, a *testAuthenticator, tamper func(*ceremonyAnswer)) error {
+	t.Helper()
+	options, err := s.BeginAssertion(userID)
+	if err != nil {
+		t.Fatalf("Failed to begin assertion: %v", err)
+	}
+	ans := a.answer(options.Challenge, "webauthn.get", flagUserPresent)
+	if tamper != nil {
+		tamper(ans)
+	}
+	return s.FinishAssertion(userID, a.assertion(ans))
+}
+
+func TestParseCOSEKey(t *testing.T) {
+	for _, alg := range []int64{coseAlgES256, coseAlgEdDSA, coseAlgRS256} {
+		a := newAuthenticator(t, alg)
+		got, key, err := parseCOSEKey(a.coseKey)
+		if err != nil || got != alg || key == nil {
+			t.Errorf("Algorithm %d: expected the key to parse, got %d %v", alg, got, err)
+		}
+	}
+
+	x := bytes.Repeat([]byte{1}, 32)
+	shortModulus := append([]byte{0x80}, make([]byte, 127)...)
+	longModulus := append([]byte{0x80}, make([]byte, 255)...)
+	tests := []struct {
+		name string
+		key  []byte
+		want error
+	}{
+		{"not CBOR", []byte{0x1c}, ErrWebAuthnResponse},
+		{"not a map", cborBytes(x), ErrWebAuthnResponse},
+		{"no algorithm", cborMap(cborInt(1), cborInt(2)), ErrUnsupportedAlgorithm},
+		{"unsupported algorithm", cborMap(cborInt(1), cborInt(2), cborInt(3), cborInt(-35)), ErrUnsupportedAlgorithm},
+		{"ES256 wrong key type", cborMap(cborInt(1), cborInt(1), cborInt(3), cborInt(coseAlgES256),
+			cborInt(-1), cborInt(1), cborInt(-2), cborBytes(x), cborInt(-3), cborBytes(x)), ErrWebAuthnResponse},
+		{"ES256 wrong curve", cborMap(cborInt(1), cborInt(2), cborInt(3), cborInt(coseAlgES256),
+			cborInt(-1), cborInt(2), cborInt(-2), cborBytes(x), cborInt(-3), cborBytes(x)), ErrWebAuthnResponse},
+		{"ES256 short coordinate", cborMap(cborInt(1), cborInt(2), cborInt(3), cborInt(coseAlgES256),
+			cborInt(-1), cborInt(1), cborInt(-2), cborBytes(x[:31]), cborInt(-3), cborBytes(x)), ErrWebAuthnResponse},
+		{"ES256 point off the curve", cborMap(cborInt(1), cborInt(2), cborInt(3), cborInt(coseAlgES256),
+			cborInt(-1), cborInt(1), cborInt(-2), cborBytes(x), cborInt(-3), cborBytes(x)), ErrWebAuthnResponse},
+		{"EdDSA wrong curve", cborMap(cborInt(1), cborInt(1), cborInt(3), cborInt(coseAlgEdDSA),
+			cborInt(-1), cborInt(4), cborInt(-2), cborBytes(x)), ErrWebAuthnResponse},
+		{"EdDSA short key", cborMap(cborInt(1), cborInt(1), cborInt(3), cborInt(coseAlgEdDSA),
+			cborInt(-1), cborInt(6), cborInt(-2), cborBytes(x[:16])), ErrWebAuthnResponse},
+		{"RS256 short modulus", cborMap(cborInt(1), cborInt(3), cborInt(3), cborInt(coseAlgRS256),
+			cborInt(-1), cborBytes(shortModulus), cborInt(-2), cborBytes([]byte{1, 0, 1})), ErrWebAuthnResponse},
+		{"RS256 exponent too small", cborMap(cborInt(1), cborInt(3), cborInt(3), cborInt(coseAlgRS256),
+			cborInt(-1), cborBytes(longModulus), cborInt(-2), cborBytes([]byte{1})), ErrWebAuthnResponse},
+		{"RS256 exponent too long", cborMap(cborInt(1), cborInt(3), cborInt(3), cborInt(coseAlgRS256),
+			cborInt(-1), cborBytes(longModulus), cborInt(-2), cborBytes([]byte{1, 0, 0, 0, 1})), ErrWebAuthnResponse},
+	}
+	for _, tt := range tests {
+		if _, _, err := parseCOSEKey(tt.key); !errors.Is(err, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+func TestParseAuthenticatorData(t *testing.T) {
+	a := newAuthenticator(t, coseAlgEdDSA)
+	ans := &ceremonyAnswer{rpID: testRPID, flags: flagUserPresent | flagAttested, signCount: 7}
+	full := a.authData(ans)
+
+	ad, err := parseAuthenticatorData(full)
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if ad.signCount != 7 || !bytes.Equal(ad.credentialID, a.id) || !bytes.Equal(ad.publicKey, a.coseKey) {
+		t.Errorf("Unexpected authenticator data %+v", ad)
+	}
+
+	zeroLength := append([]byte(nil), full...)
+	binary.BigEndian.PutUint16(zeroLength[53:], 0)
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"too short", full[:36]},
+		{"attested without credential", full[:37]},
+		{"credential ID cut off", full[:55+len(a.id)/2]},
+		{"empty credential ID", zeroLength},
+		{"key cut off", full[:len(full)-1]},
+	}
+	for _, tt := range tests {
+		if _, err := parseAuthenticatorData(tt.data); !errors.Is(err, ErrWebAuthnResponse) {
+			t.Errorf("%s: expected ErrWebAuthnResponse, got %v", tt.name, err)
+		}
+	}
+}
+
+func TestRegistrationAndAssertion(t *testing.T) {
+	for _, alg := range []int64{coseAlgES256, coseAlgEdDSA, coseAlgRS256} {
+		s := newTestService(t, t.TempDir())
+		a := newAuthenticator(t, alg)
+		a.signCount = 1
+		credential := register(t, s, "usr-alice", a)
+		if credential.Name != "Security key" || credential.SignCount != 1 {
+			t.Errorf("Algorithm %d: unexpected credential %+v", alg, credential)
+		}
+
+		a.signCount = 2
+		if err := assert(t, s, "usr-alice", a, nil); err != nil {
+			t.Errorf("Algorithm %d: expected the assertion to pass, got %v", alg, err)
+		}
+		if got := s.ListCredentials("usr-alice"); len(got) != 1 || got[0].SignCount != 2 || got[0].LastUsedAt == nil {
+			t.Errorf("Algorithm %d: expected the counter and last use recorded, got %+v", alg, got)
+		}
+	}
+}
+
+func TestFinishRegistration_Rejects(t *testing.T) {
+	s := newTestService(t, t.TempDir())
+	a := newAuthenticator(t, coseAlgES256)
+
+	tests := []struct {
+		name   string
+		tamper func(*ceremonyAnswer)
+		want   error
+	}{
+		{"ceremony type", func(ans *ceremonyAnswer) { ans.ceremonyType = "webauthn.get" }, ErrWebAuthnResponse},
+		{"challenge", func(ans *ceremonyAnswer) { ans.challenge = []byte("another challenge") }, ErrWebAuthnResponse},
+		{"origin", func(ans *ceremonyAnswer) { ans.origin = "https://example.net" }, ErrWebAuthnResponse},
+		{"cross origin", func(ans *ceremonyAnswer) { ans.crossOrigin = true }, ErrWebAuthnResponse},
+		{"rpIdHash", func(ans *ceremonyAnswer) { ans.rpID = "example.net" }, ErrWebAuthnResponse},
+		{"user not present", func(ans *ceremonyAnswer) { ans.flags = flagAttested }, ErrWebAuthnResponse},
+		{"no credential", func(ans *ceremonyAnswer) { ans.flags = flagUserPresent }, ErrWebAuthnResponse},
+		{"raw ID differs", func(ans *ceremonyAnswer) { ans.rawID = []byte("another credential") }, ErrWebAuthnResponse},
+		{"attestation format", func(ans *ceremonyAnswer) { ans.format = "packed" }, ErrAttestationFormat},
+	}
+	for _, tt := range tests {
+		options, err := s.BeginRegistration("usr-alice", "alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ans := a.answer(options.Challenge, "webauthn.create", flagUserPresent|flagAttested)
+		tt.tamper(ans)
+		if _, err := s.FinishRegistration("usr-alice", "", a.attestation(ans)); !errors.Is(err, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+
+	// A truncated attestation object
+	options, err := s.BeginRegistration("usr-alice", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cred := a.attestation(a.answer(options.Challenge, "webauthn.create", flagUserPresent|flagAttested))
+	object, _ := decodeB64(cred.Response.AttestationObject)
+	cred.Response.AttestationObject = b64(object[:len(object)-10])
+	if _, err := s.FinishRegistration("usr-alice", "", cred); !errors.Is(err, ErrWebAuthnResponse) {
+		t.Errorf("Expected ErrWebAuthnResponse for a truncated attestation, got %v", err)
+	}
+
+	// Each challenge is answered once
+	if _, err := s.FinishRegistration("usr-alice", "", cred); !errors.Is(err, ErrWebAuthnCeremony) {
+		t.Errorf("Expected ErrWebAuthnCeremony without a ceremony, got %v", err)
+	}
+	if len(s.ListCredentials("usr-alice")) != 0 {
+		t.Error("Expected no credential registered")
+	}
+
+	// The same key twice
+	register(t, s, "usr-alice", a)
+	options, err = s.BeginRegistration("usr-alice", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cred = a.attestation(a.answer(options.Challenge, "webauthn.create", flagUserPresent|flagAttested))
+	if _, err := s.FinishRegistration("usr-alice", "", cred); !errors.Is(err, ErrCredentialExists) {
+		t.Errorf("Expected ErrCredentialExists, got %v", err)
+	}
+}
+
+func TestFinishAssertion_Rejects(t *testing.T) {
+	s := newTestService(t, t.TempDir())
+	a := newAuthenticator(t, coseAlgES256)
+	register(t, s, "usr-alice", a)
+	register(t, s, "usr-bob", newAuthenticator(t, coseAlgEdDSA))
+
+	tests := []struct {
+		name   string
+		tamper func(*ceremonyAnswer)
+		want   error
+	}{
+		{"ceremony type", func(ans *ceremonyAnswer) { ans.ceremonyType = "webauthn.create" }, ErrWebAuthnResponse},
+		{"challenge", func(ans *ceremonyAnswer) { ans.challenge = []byte("another challenge") }, ErrWebAuthnResponse},
+		{"origin", func(ans *ceremonyAnswer) { ans.origin = "https://example.com.example.net" }, ErrWebAuthnResponse},
+		{"rpIdHash", func(ans *ceremonyAnswer) { ans.rpID = "example.net" }, ErrWebAuthnResponse},
+		{"user not present", func(ans *ceremonyAnswer) { ans.flags = 0 }, ErrWebAuthnResponse},
+		{"signature", func(ans *ceremonyAnswer) { ans.badSignature = true }, ErrWebAuthnResponse},
+		{"unknown credential", func(ans *ceremonyAnswer) { ans.rawID = []byte("another credential") }, ErrCredentialNotFound},
+	}
+	for _, tt := range tests {
+		if err := assert(t, s, "usr-alice", a, tt.tamper); !errors.Is(err, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+
+	// Alice's key doesn't answer for Bob
+	options, err := s.BeginAssertion("usr-bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cred := a.assertion(a.answer(options.Challenge, "webauthn.get", flagUserPresent))
+	if err := s.FinishAssertion("usr-bob", cred); !errors.Is(err, ErrCredentialNotFound) {
+		t.Errorf("Expected ErrCredentialNotFound for another user's key, got %v", err)
+	}
+
+	// User verification is not needed for a second factor
+	if err := assert(t, s, "usr-alice", a, nil); err != nil {
+		t.Errorf("Expected the untampered assertion to pass, got %v", err)
+	}
+	if _, err := s.BeginAssertion("usr-carol"); !errors.Is(err, ErrCredentialNotFound) {
+		t.Errorf("Expected ErrCredentialNotFound without keys, got %v", err)
+	}
+}
+
+func TestVerifyAssertion_CloneDetection(t *testing.T) {
+	s := newTestService(t, t.TempDir())
+	a := newAuthenticator(t, coseAlgEdDSA)
+	a.signCount = 5
+	register(t, s, "usr-alice", a)
+
+	tests := []struct {
+		name      string
+		signCount uint32
+		want      error
+	}{
+		{"counter went up", 6, nil},
+		{"counter repeated", 6, ErrCredentialCloned},
+	}
+	for _, tt := range tests {
+		a.signCount = tt.signCount
+		if err := assert(t, s, "usr-alice", a, nil); !errors.Is(err, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+
+	if got := s.ListCredentials("usr-alice"); len(got) != 1 || got[0].CloneDetectedAt == nil {
+		t.Errorf("Expected the clone recorded, got %+v", got)
+	}
+	if _, err := s.BeginAssertion("usr-alice"); !errors.Is(err, ErrCredentialNotFound) {
+		t.Errorf("Expected a cloned key not to be offered, got %v", err)
+	}
+	a.signCount = 10
+	challenge := []byte("a challenge the key was not offered for")
+	cred := a.assertion(a.answer(b64(challenge), "webauthn.get", flagUserPresent))
+	if _, err := s.verifyAssertion(challenge, cred, false); !errors.Is(err, ErrCredentialCloned) {
+		t.Errorf("Expected a cloned key refused from then on, got %v", err)
+	}
+
+	// Authenticators without a counter always send 0
+	counterless := newAuthenticator(t, coseAlgES256)
+	register(t, s, "usr-bob", counterless)
+	for i := 0; i < 2; i++ {
+		if err := assert(t, s, "usr-bob", counterless, nil); err != nil {
+			t.Errorf("Use %d: expected a counterless key to pass, got %v", i, err)
+		}
+	}
+
+	// A counter that starts counting after registration is fine; one
+	// falling back to 0 is not
+	counterless.signCount = 1
+	if err := assert(t, s, "usr-bob", counterless, nil); err != nil {
+		t.Errorf("Expected the counter to start, got %v", err)
+	}
+	counterless.signCount = 0
+	if err := assert(t, s, "usr-bob", counterless, nil); !errors.Is(err, ErrCredentialCloned) {
+		t.Errorf("Expected ErrCredentialCloned when the counter drops to 0, got %v", err)
+	}
+}
+
+func TestPasskeyLogin(t *testing.T) {
+	s := newTestService(t, t.TempDir())
+	a := newAuthenticator(t, coseAlgES256)
+	register(t, s, "usr-alice", a)
+	register(t, s, "usr-bob", newAuthenticator(t, coseAlgEdDSA))
+
+	tests := []struct {
+		name   string
+		tamper func(*ceremonyAnswer)
+		want   error
+	}{
+		{"verified", nil, nil},
+		{"user not verified", func(ans *ceremonyAnswer) { ans.flags = flagUserPresent }, ErrWebAuthnResponse},
+		{"no user handle", func(ans *ceremonyAnswer) { ans.userHandle = nil }, ErrWebAuthnResponse},
+		{"another user's handle", func(ans *ceremonyAnswer) { ans.userHandle = userHandle("usr-bob") }, ErrWebAuthnResponse},
+		{"user ID as handle", func(ans *ceremonyAnswer) { ans.userHandle = []byte("usr-alice") }, ErrWebAuthnResponse},
+	}
+	for _, tt := range tests {
+		session, options, err := s.BeginPasskeyLogin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if options.UserVerification != "required" {
+			t.Errorf("Expected user verification required, got %q", options.UserVerification)
+		}
+		a.signCount++
+		ans := a.answer(options.Challenge, "webauthn.get", flagUserPresent|flagUserVerified)
+		ans.userHandle = userHandle("usr-alice")
+		if tt.tamper != nil {
+			tt.tamper(ans)
+		}
+		userID, err := s.FinishPasskeyLogin(session, a.assertion(ans))
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+		if err == nil && userID != "usr-alice" {
+			t.Errorf("%s: expected usr-alice, got %q", tt.name, userID)
+		}
+	}
+
+	// A session answers one login
+	session, options, err := s.BeginPasskeyLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.signCount++
+	ans := a.answer(options.Challenge, "webauthn.get", flagUserPresent|flagUserVerified)
+	ans.userHandle = userHandle("usr-alice")
+	if _, err := s.FinishPasskeyLogin(session, a.assertion(ans)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.FinishPasskeyLogin(session, a.assertion(ans)); !errors.Is(err, ErrWebAuthnCeremony) {
+		t.Errorf("Expected ErrWebAuthnCeremony answering twice, got %v", err)
+	}
+}
+
+func TestOriginAllowed(t *testing.T) {
+	s := newTestService(t, t.TempDir())
+
+	tests := []struct {
+		name    string
+		rpID    string
+		origins []string
+		origin  string
+		want    bool
+	}{
+		{"relying party", "example.com", nil, "https://example.com", true},
+		{"subdomain", "example.com", nil, "https://panel.example.com:2083", true},
+		{"plain HTTP", "example.com", nil, "http://example.com", false},
+		{"suffix of another name", "example.com", nil, "https://badexample.com", false},
+		{"relying party as subdomain", "example.com", nil, "https://example.com.example.net", false},
+		{"not a URL", "example.com", nil, "://example.com", false},
+		{"HTTP on localhost", "localhost", nil, "http://localhost:8080", true},
+		{"configured origin", "example.com", []string{"https://panel.example.com"}, "https://panel.example.com", true},
+		{"other subdomain than configured", "example.com", []string{"https://panel.example.com"}, "https://example.com", false},
+	}
+	for _, tt := range tests {
+		s.ConfigureWebAuthn(tt.rpID, totpIssuer, tt.origins)
+		if got := s.originAllowed(tt.origin); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
@@ -378,6 +378,34 @@ func (r *Repository) IncrementFailedLogin(ctx context.Context, id string) error
 	return err
 }
 
+// LockUntil locks a user out of logging in until a time
+func (r *Repository) LockUntil(ctx context.Context, username string, until time.Time) error {
+	query := `UPDATE users SET locked_until = $2 WHERE username = $1`
+	_, err := r.Querier().ExecContext(ctx, query, username, until)
+	return err
+}
+
+// Unlock lifts a user's login lockout and resets its failed login count
+func (r *Repository) Unlock(ctx context.Context, username string) error {
+	query := `UPDATE users SET locked_until = NULL, failed_login_count = 0 WHERE username = $1`
+	_, err := r.Querier().ExecContext(ctx, query, username)
+	return err
+}
+
+// GetLockedUntil returns when a user's login lockout ends, or nil if it
+// has none
+func (r *Repository) GetLockedUntil(ctx context.Context, id string) (*time.Time, error) {
+	var lockedUntil sql.NullTime
+	query := `SELECT locked_until FROM users WHERE id = $1`
+	if err := r.Querier().QueryRowContext(ctx, query, id).Scan(&lockedUntil); err != nil {
+		return nil, fmt.Errorf("failed to get user lock: %w", err)
+	}
+	if !lockedUntil.Valid {
+		return nil, nil
+	}
+	return &lockedUntil.Time, nil
+}
+
 // SuspendUser suspends a user account
 func (r *Repository) SuspendUser(ctx context.Context, id, reason string) error {
 	query := `
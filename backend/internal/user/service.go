@@ -12,6 +12,10 @@ import (
 	"github.com/iSundram/OweHost/pkg/utils"
 )
 
+// ErrAccountLocked is a login to a user locked out after too many failed
+// attempts
+var ErrAccountLocked = errors.New("account is temporarily locked")
+
 // Service provides user management functionality
 type Service struct {
 	config *config.Config
@@ -293,6 +297,12 @@ func (s *Service) ValidateCredentials(username, password string) (*models.User,
 		return nil, errors.New("account is not active")
 	}
 
+	// A locked user's password isn't checked at all, so guessing goes
+	// nowhere until the lock runs out
+	if until, err := s.repo.GetLockedUntil(ctx, user.ID); err == nil && until != nil && time.Now().Before(*until) {
+		return nil, ErrAccountLocked
+	}
+
 	if !utils.CheckPassword(password, user.PasswordHash) {
 		s.repo.IncrementFailedLogin(ctx, user.ID)
 		return nil, errors.New("invalid credentials")
@@ -302,6 +312,17 @@ func (s *Service) ValidateCredentials(username, password string) (*models.User,
 	return user, nil
 }
 
+// Lock locks a user out of password logins until a time. Unknown
+// usernames are ignored, so a lock says nothing about who exists.
+func (s *Service) Lock(username string, until time.Time) error {
+	return s.repo.LockUntil(context.Background(), username, until)
+}
+
+// Unlock lifts a user's login lockout and clears its failed logins
+func (s *Service) Unlock(username string) error {
+	return s.repo.Unlock(context.Background(), username)
+}
+
 // Delete deletes a user
 func (s *Service) Delete(id string) error {
 	return s.repo.Delete(context.Background(), id)
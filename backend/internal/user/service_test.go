@@ -2,15 +2,40 @@ package user_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/iSundram/OweHost/internal/user"
 	"github.com/iSundram/OweHost/pkg/config"
+	"github.com/iSundram/OweHost/pkg/database"
 	"github.com/iSundram/OweHost/pkg/models"
 )
 
-func TestUserService_Create(t *testing.T) {
+// newTestService returns a user service on the configured database. Users
+// live in the database, so the tests skip where there is none.
+func newTestService(t *testing.T) *user.Service {
+	t.Helper()
 	cfg := config.Load()
-	svc := user.NewService(cfg)
+	db, err := database.New(database.Config{
+		Driver:          cfg.Database.Driver,
+		Host:            cfg.Database.Host,
+		Port:            cfg.Database.Port,
+		Database:        cfg.Database.Name,
+		Username:        cfg.Database.User,
+		Password:        cfg.Database.Password,
+		SSLMode:         cfg.Database.SSLMode,
+		MaxOpenConns:    5,
+		MaxIdleConns:    1,
+		ConnMaxLifetime: time.Minute,
+	})
+	if err != nil {
+		t.Skipf("database unavailable: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return user.NewService(cfg, user.NewRepository(db))
+}
+
+func TestUserService_Create(t *testing.T) {
+	svc := newTestService(t)
 
 	req := &models.UserCreateRequest{
 		Username: "testuser",
@@ -41,8 +66,7 @@ func TestUserService_Create(t *testing.T) {
 }
 
 func TestUserService_Get(t *testing.T) {
-	cfg := config.Load()
-	svc := user.NewService(cfg)
+	svc := newTestService(t)
 
 	req := &models.UserCreateRequest{
 		Username: "getuser",
@@ -66,8 +90,7 @@ func TestUserService_Get(t *testing.T) {
 }
 
 func TestUserService_DuplicateEmail(t *testing.T) {
-	cfg := config.Load()
-	svc := user.NewService(cfg)
+	svc := newTestService(t)
 
 	req := &models.UserCreateRequest{
 		Username: "user1",
@@ -93,8 +116,7 @@ func TestUserService_DuplicateEmail(t *testing.T) {
 }
 
 func TestUserService_DuplicateUsername(t *testing.T) {
-	cfg := config.Load()
-	svc := user.NewService(cfg)
+	svc := newTestService(t)
 
 	req := &models.UserCreateRequest{
 		Username: "duplicateuser",
@@ -120,8 +142,7 @@ func TestUserService_DuplicateUsername(t *testing.T) {
 }
 
 func TestUserService_Suspend(t *testing.T) {
-	cfg := config.Load()
-	svc := user.NewService(cfg)
+	svc := newTestService(t)
 
 	req := &models.UserCreateRequest{
 		Username: "suspenduser",
@@ -146,8 +167,7 @@ func TestUserService_Suspend(t *testing.T) {
 }
 
 func TestUserService_ValidateCredentials(t *testing.T) {
-	cfg := config.Load()
-	svc := user.NewService(cfg)
+	svc := newTestService(t)
 
 	req := &models.UserCreateRequest{
 		Username: "authuser",
@@ -183,8 +203,7 @@ func TestUserService_ValidateCredentials(t *testing.T) {
 }
 
 func TestUserService_Delete(t *testing.T) {
-	cfg := config.Load()
-	svc := user.NewService(cfg)
+	svc := newTestService(t)
 
 	req := &models.UserCreateRequest{
 		Username: "deleteuser",
@@ -5,19 +5,23 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"path/filepath"
 	"sync"
 	"time"
 
+	"github.com/iSundram/OweHost/internal/storage/web"
 	"github.com/iSundram/OweHost/pkg/models"
 	"github.com/iSundram/OweHost/pkg/utils"
 )
 
-// Service provides web server control functionality
+// Service provides web server control functionality. Virtual host configs
+// are installed through the web applier, which tests them before they go
+// live and keeps their version history on disk.
 type Service struct {
 	vhosts        map[string]*models.VirtualHost
-	configs       map[string][]*models.WebServerConfig
 	byDomain      map[string]*models.VirtualHost
+	web           *web.Applier
 	mu            sync.RWMutex
 }
 
@@ -25,8 +29,8 @@ type Service struct {
 func NewService() *Service {
 	return &Service{
 		vhosts:   make(map[string]*models.VirtualHost),
-		configs:  make(map[string][]*models.WebServerConfig),
 		byDomain: make(map[string]*models.VirtualHost),
+		web:      web.NewApplier(),
 	}
 }
 
@@ -54,8 +58,6 @@ func (s *Service) CreateVHost(req *models.VirtualHostCreateRequest) (*models.Vir
 		documentRoot = filepath.Join("/var/www", req.DomainID)
 	}
 
-	configPath := filepath.Join("/etc", string(req.ServerType), "sites-available", req.DomainID+".conf")
-
 	vhost := &models.VirtualHost{
 		ID:           utils.GenerateID("vhost"),
 		DomainID:     req.DomainID,
@@ -66,29 +68,21 @@ func (s *Service) CreateVHost(req *models.VirtualHostCreateRequest) (*models.Vir
 		PHPEnabled:   req.PHPEnabled,
 		PHPVersion:   req.PHPVersion,
 		ProxyPass:    req.ProxyPass,
-		ConfigPath:   configPath,
+		ConfigPath:   web.ConfigPath(vhostServer(req.ServerType), req.DomainID),
 		CreatedAt:    time.Now(),
 		UpdatedAt:    time.Now(),
 	}
 
-	// Generate initial config
+	// Generate and install initial config
 	config := s.generateConfig(vhost)
+	if err := s.web.InstallConfig(vhostServer(vhost.ServerType), vhost.DomainID, config); err != nil {
+		return nil, fmt.Errorf("failed to apply config: %w", err)
+	}
 	vhost.ConfigChecksum = checksumConfig(config)
 
 	s.vhosts[vhost.ID] = vhost
 	s.byDomain[req.DomainID] = vhost
 
-	// Store config version
-	configVersion := &models.WebServerConfig{
-		ID:         utils.GenerateID("conf"),
-		VHostID:    vhost.ID,
-		ConfigData: config,
-		Version:    1,
-		Active:     true,
-		CreatedAt:  time.Now(),
-	}
-	s.configs[vhost.ID] = []*models.WebServerConfig{configVersion}
-
 	return vhost, nil
 }
 
@@ -126,6 +120,7 @@ func (s *Service) UpdateVHost(id string, req *models.VirtualHostCreateRequest) (
 		return nil, errors.New("virtual host not found")
 	}
 
+	previous := *vhost
 	if req.DocumentRoot != "" {
 		vhost.DocumentRoot = req.DocumentRoot
 	}
@@ -134,35 +129,15 @@ func (s *Service) UpdateVHost(id string, req *models.VirtualHostCreateRequest) (
 	vhost.PHPVersion = req.PHPVersion
 	vhost.ProxyPass = req.ProxyPass
 
-	// Generate new config and create version
+	// Install the new config; it becomes a new version when it changed
 	config := s.generateConfig(vhost)
 	newChecksum := checksumConfig(config)
 
 	if newChecksum != vhost.ConfigChecksum {
-		// Create new config version
-		configs := s.configs[id]
-		latestVersion := 0
-		for _, c := range configs {
-			if c.Version > latestVersion {
-				latestVersion = c.Version
-			}
+		if err := s.web.InstallConfig(vhostServer(vhost.ServerType), vhost.DomainID, config); err != nil {
+			*vhost = previous
+			return nil, fmt.Errorf("failed to apply config: %w", err)
 		}
-
-		configVersion := &models.WebServerConfig{
-			ID:         utils.GenerateID("conf"),
-			VHostID:    id,
-			ConfigData: config,
-			Version:    latestVersion + 1,
-			Active:     true,
-			CreatedAt:  time.Now(),
-		}
-
-		// Deactivate previous
-		for _, c := range configs {
-			c.Active = false
-		}
-
-		s.configs[id] = append(s.configs[id], configVersion)
 		vhost.ConfigChecksum = newChecksum
 	}
 
@@ -180,87 +155,86 @@ func (s *Service) DeleteVHost(id string) error {
 		return errors.New("virtual host not found")
 	}
 
+	if err := s.web.RemoveConfig(vhostServer(vhost.ServerType), vhost.DomainID); err != nil {
+		return fmt.Errorf("failed to remove config: %w", err)
+	}
+
 	delete(s.vhosts, id)
 	delete(s.byDomain, vhost.DomainID)
-	delete(s.configs, id)
 
 	return nil
 }
 
-// Reload safely reloads web server configuration
+// Reload safely reloads web server configuration. A hybrid setup reloads
+// Apache and then the nginx in front of it.
 func (s *Service) Reload(serverType models.WebServerType) (*models.ConfigReloadStatus, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	if !isValidServerType(serverType) {
+		return nil, errors.New("invalid server type")
+	}
+
+	servers := []string{vhostServer(serverType)}
+	if serverType == models.WebServerTypeHybrid {
+		servers = []string{web.ServerApache, web.ServerNginx}
+	}
 
-	// In production, this would call the actual web server reload
-	// For now, we simulate success
 	status := &models.ConfigReloadStatus{
 		ServerType: serverType,
 		Success:    true,
 		ReloadedAt: time.Now(),
 	}
+	for _, server := range servers {
+		if err := s.web.ReloadServer(server); err != nil {
+			msg := err.Error()
+			status.Success = false
+			status.ErrorMessage = &msg
+			return status, err
+		}
+	}
 
 	return status, nil
 }
 
-// ValidateConfig validates configuration before reload
+// ValidateConfig runs the config test of the web server a virtual host
+// (or generated site config, see configTarget) is served by
 func (s *Service) ValidateConfig(id string) (bool, string) {
 	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	configs, exists := s.configs[id]
-	if !exists || len(configs) == 0 {
-		return false, "no config found"
-	}
-
-	// Find active config
-	var activeConfig *models.WebServerConfig
-	for _, c := range configs {
-		if c.Active {
-			activeConfig = c
-			break
-		}
+	server, _, err := s.configTarget(id)
+	s.mu.RUnlock()
+	if err != nil {
+		return false, err.Error()
 	}
 
-	if activeConfig == nil {
-		return false, "no active config found"
+	out, err := s.web.TestConfig(server)
+	if err != nil {
+		return false, err.Error()
 	}
-
-	// In production, this would call nginx -t or apachectl configtest
-	return true, "configuration valid"
+	return true, out
 }
 
-// Rollback rolls back to a previous config version
+// Rollback reinstalls a previous config version. The version is tested
+// like any new config and the live one stays in place if it fails.
 func (s *Service) Rollback(id string, version int) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	configs, exists := s.configs[id]
-	if !exists {
-		return errors.New("no configs found")
+	server, name, err := s.configTarget(id)
+	if err != nil {
+		return err
 	}
 
-	var targetConfig *models.WebServerConfig
-	for _, c := range configs {
-		if c.Version == version {
-			targetConfig = c
-			break
-		}
-	}
-
-	if targetConfig == nil {
-		return errors.New("config version not found")
-	}
-
-	// Deactivate all and activate target
-	for _, c := range configs {
-		c.Active = (c.Version == version)
+	if err := s.web.RollbackConfig(server, name, version); err != nil {
+		return err
 	}
 
 	// Update vhost checksum
-	vhost := s.vhosts[id]
-	if vhost != nil {
-		vhost.ConfigChecksum = checksumConfig(targetConfig.ConfigData)
+	if vhost := s.vhosts[id]; vhost != nil {
+		if history, err := s.web.ConfigHistory(server, name); err == nil {
+			for _, v := range history {
+				if v.Active {
+					vhost.ConfigChecksum = checksumConfig(v.Config)
+				}
+			}
+		}
 		vhost.UpdatedAt = time.Now()
 	}
 
@@ -272,7 +246,50 @@ func (s *Service) GetConfigHistory(id string) []*models.WebServerConfig {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	return s.configs[id]
+	server, name, err := s.configTarget(id)
+	if err != nil {
+		return []*models.WebServerConfig{}
+	}
+
+	history, err := s.web.ConfigHistory(server, name)
+	if err != nil {
+		return []*models.WebServerConfig{}
+	}
+
+	configs := make([]*models.WebServerConfig, 0, len(history))
+	for _, v := range history {
+		configs = append(configs, &models.WebServerConfig{
+			ID:         fmt.Sprintf("%s@%d", name, v.Version),
+			VHostID:    id,
+			ConfigData: v.Config,
+			Version:    v.Version,
+			Active:     v.Active,
+			CreatedAt:  v.CreatedAt,
+		})
+	}
+	return configs
+}
+
+// configTarget maps an ID to a web server and config name. IDs are virtual
+// host IDs, or the names of configs generated for account sites
+// ("a-12-example.com"), which are always nginx.
+func (s *Service) configTarget(id string) (string, string, error) {
+	if vhost, exists := s.vhosts[id]; exists {
+		return vhostServer(vhost.ServerType), vhost.DomainID, nil
+	}
+	if id == "" {
+		return "", "", errors.New("no config found")
+	}
+	return web.ServerNginx, id, nil
+}
+
+// vhostServer returns the web server that loads a virtual host's config.
+// Hybrid virtual hosts are nginx configs proxying to Apache.
+func vhostServer(serverType models.WebServerType) string {
+	if serverType == models.WebServerTypeApache {
+		return web.ServerApache
+	}
+	return web.ServerNginx
 }
 
 // generateConfig generates web server configuration
@@ -487,10 +504,11 @@ func (s *Service) GetStatus() (*models.WebServerStatus, error) {
 	}, nil
 }
 
-// TestConfig tests the web server configuration
+// TestConfig tests the nginx configuration
 func (s *Service) TestConfig() (*models.ConfigTestResult, error) {
-	return &models.ConfigTestResult{
-		Valid:   true,
-		Message: "configuration file syntax is ok",
-	}, nil
+	out, err := s.web.TestConfig(web.ServerNginx)
+	if err != nil {
+		return &models.ConfigTestResult{Valid: false, Message: err.Error()}, nil
+	}
+	return &models.ConfigTestResult{Valid: true, Message: out}, nil
 }
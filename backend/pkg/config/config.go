@@ -4,6 +4,7 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -26,10 +27,17 @@ type ServerConfig struct {
 	EnableGRPC   bool
 	GRPCPort     int
 
+	// PublicHostname is the hostname customers use to reach this server
+	PublicHostname string
+
 	// Panel ports
 	UserPanelPort     int // Port 2083 - User cPanel-like interface
 	AdminPanelPort    int // Port 2087 - WHM/Admin interface
 	ResellerPanelPort int // Port 2086 - Reseller interface
+
+	// TrustedProxies are the addresses and CIDRs of reverse proxies whose
+	// X-Forwarded-For and X-Real-IP headers name the client
+	TrustedProxies []string
 }
 
 // DatabaseConfig holds database configuration
@@ -45,12 +53,73 @@ type DatabaseConfig struct {
 
 // AuthConfig holds authentication configuration
 type AuthConfig struct {
-	JWTSecret             string
+	// JWTAlgorithm signs access tokens: ES256 or EdDSA. The signing key is
+	// replaced every JWTKeyRotation; verifiers fetch the public keys from
+	// /.well-known/jwks.json.
+	JWTAlgorithm          string
+	JWTKeyRotation        time.Duration
+	JWTIssuer             string
+	JWTAudience           string
 	JWTExpiry             time.Duration
 	RefreshTokenExpiry    time.Duration
 	APIKeyPrefix          string
 	PasswordMinLength     int
 	MaxConcurrentSessions int
+	// ImpersonationTTL is how long a "log in as user" token lasts; it
+	// can't be refreshed
+	ImpersonationTTL time.Duration
+	// WebAuthnRPID is the domain security keys are registered to; it must
+	// be the panel's hostname or a parent of it
+	WebAuthnRPID    string
+	WebAuthnOrigins []string
+	OIDC            OIDCConfig
+	BruteForce      BruteForceConfig
+}
+
+// BruteForceConfig holds the limits on failed logins, counted per username
+// and per address over Window. From DelayAfter failures on, each attempt
+// must wait twice as long as the one before, up to MaxDelay. A threshold
+// of 0 turns its lockout off.
+type BruteForceConfig struct {
+	Window            time.Duration
+	DelayAfter        int
+	BaseDelay         time.Duration
+	MaxDelay          time.Duration
+	UserLockThreshold int
+	UserLockDuration  time.Duration
+	IPLockThreshold   int
+	IPLockDuration    time.Duration
+	// IPBanThreshold failures get an address a firewall deny rule for
+	// IPBanDuration
+	IPBanThreshold int
+	IPBanDuration  time.Duration
+	// An address failing against StuffingUsernames usernames, or a username
+	// failing from DistributedIPs addresses, raises a security alert
+	StuffingUsernames int
+	DistributedIPs    int
+	// TrustedIPs (addresses or CIDRs) are never locked or banned; their
+	// usernames still are
+	TrustedIPs []string
+}
+
+// OIDCConfig holds single sign-on configuration. SSO is on when an issuer
+// and client ID are set.
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string // empty for a public client relying on PKCE alone
+	RedirectURL  string
+	Scopes       []string
+	GroupsClaim  string
+	// GroupRoles maps IdP groups to panel roles; a user in several gets the
+	// most privileged. Users in none get DefaultRole, or are refused if
+	// it is empty.
+	GroupRoles  map[string]string
+	DefaultRole string
+	// GroupAuthzRoles maps IdP groups to authorization roles, by name
+	GroupAuthzRoles map[string]string
+	AutoProvision   bool
+	AdminSSOOnly    bool
 }
 
 // AdminConfig holds default admin user configuration
@@ -84,9 +153,11 @@ func Load() *Config {
 			WriteTimeout:      time.Duration(getEnvInt("OWEHOST_WRITE_TIMEOUT", 30)) * time.Second,
 			EnableGRPC:        getEnvBool("OWEHOST_ENABLE_GRPC", false),
 			GRPCPort:          getEnvInt("OWEHOST_GRPC_PORT", 9090),
+			PublicHostname:    getEnv("OWEHOST_PUBLIC_HOSTNAME", "localhost"),
 			UserPanelPort:     getEnvInt("OWEHOST_USER_PANEL_PORT", 2083),
 			AdminPanelPort:    getEnvInt("OWEHOST_ADMIN_PANEL_PORT", 2087),
 			ResellerPanelPort: getEnvInt("OWEHOST_RESELLER_PANEL_PORT", 2086),
+			TrustedProxies:    getEnvList("OWEHOST_TRUSTED_PROXIES", nil),
 		},
 		Database: DatabaseConfig{
 			Driver:   getEnv("OWEHOST_DB_DRIVER", "postgres"),
@@ -98,12 +169,46 @@ func Load() *Config {
 			SSLMode:  getEnv("OWEHOST_DB_SSLMODE", "disable"),
 		},
 		Auth: AuthConfig{
-			JWTSecret:             getEnv("OWEHOST_JWT_SECRET", "change-me-in-production"),
+			JWTAlgorithm:          getEnv("OWEHOST_JWT_ALGORITHM", "ES256"),
+			JWTKeyRotation:        time.Duration(getEnvInt("OWEHOST_JWT_KEY_ROTATION_DAYS", 30)) * 24 * time.Hour,
+			JWTIssuer:             getEnv("OWEHOST_JWT_ISSUER", "owehost"),
+			JWTAudience:           getEnv("OWEHOST_JWT_AUDIENCE", "owehost-api"),
 			JWTExpiry:             time.Duration(getEnvInt("OWEHOST_JWT_EXPIRY_MINUTES", 15)) * time.Minute,
 			RefreshTokenExpiry:    time.Duration(getEnvInt("OWEHOST_REFRESH_EXPIRY_DAYS", 7)) * 24 * time.Hour,
 			APIKeyPrefix:          getEnv("OWEHOST_API_KEY_PREFIX", "owh_"),
 			PasswordMinLength:     getEnvInt("OWEHOST_PASSWORD_MIN_LENGTH", 8),
 			MaxConcurrentSessions: getEnvInt("OWEHOST_MAX_SESSIONS", 5),
+			ImpersonationTTL:      time.Duration(getEnvInt("OWEHOST_IMPERSONATION_MINUTES", 30)) * time.Minute,
+			WebAuthnRPID:          getEnv("OWEHOST_WEBAUTHN_RP_ID", getEnv("OWEHOST_PUBLIC_HOSTNAME", "localhost")),
+			WebAuthnOrigins:       getEnvList("OWEHOST_WEBAUTHN_ORIGINS", nil),
+			OIDC: OIDCConfig{
+				Issuer:          getEnv("OWEHOST_OIDC_ISSUER", ""),
+				ClientID:        getEnv("OWEHOST_OIDC_CLIENT_ID", ""),
+				ClientSecret:    getEnv("OWEHOST_OIDC_CLIENT_SECRET", ""),
+				RedirectURL:     getEnv("OWEHOST_OIDC_REDIRECT_URL", ""),
+				Scopes:          getEnvList("OWEHOST_OIDC_SCOPES", []string{"openid", "email", "profile"}),
+				GroupsClaim:     getEnv("OWEHOST_OIDC_GROUPS_CLAIM", "groups"),
+				GroupRoles:      getEnvMap("OWEHOST_OIDC_GROUP_ROLES"),
+				DefaultRole:     getEnv("OWEHOST_OIDC_DEFAULT_ROLE", ""),
+				GroupAuthzRoles: getEnvMap("OWEHOST_OIDC_GROUP_AUTHZ_ROLES"),
+				AutoProvision:   getEnvBool("OWEHOST_OIDC_AUTO_PROVISION", false),
+				AdminSSOOnly:    getEnvBool("OWEHOST_OIDC_ADMIN_SSO_ONLY", false),
+			},
+			BruteForce: BruteForceConfig{
+				Window:            time.Duration(getEnvInt("OWEHOST_BRUTEFORCE_WINDOW_MINUTES", 15)) * time.Minute,
+				DelayAfter:        getEnvInt("OWEHOST_BRUTEFORCE_DELAY_AFTER", 3),
+				BaseDelay:         time.Duration(getEnvInt("OWEHOST_BRUTEFORCE_BASE_DELAY_SECONDS", 1)) * time.Second,
+				MaxDelay:          time.Duration(getEnvInt("OWEHOST_BRUTEFORCE_MAX_DELAY_SECONDS", 30)) * time.Second,
+				UserLockThreshold: getEnvInt("OWEHOST_BRUTEFORCE_USER_LOCK_THRESHOLD", 10),
+				UserLockDuration:  time.Duration(getEnvInt("OWEHOST_BRUTEFORCE_USER_LOCK_MINUTES", 15)) * time.Minute,
+				IPLockThreshold:   getEnvInt("OWEHOST_BRUTEFORCE_IP_LOCK_THRESHOLD", 20),
+				IPLockDuration:    time.Duration(getEnvInt("OWEHOST_BRUTEFORCE_IP_LOCK_MINUTES", 15)) * time.Minute,
+				IPBanThreshold:    getEnvInt("OWEHOST_BRUTEFORCE_IP_BAN_THRESHOLD", 50),
+				IPBanDuration:     time.Duration(getEnvInt("OWEHOST_BRUTEFORCE_IP_BAN_HOURS", 24)) * time.Hour,
+				StuffingUsernames: getEnvInt("OWEHOST_BRUTEFORCE_STUFFING_USERNAMES", 10),
+				DistributedIPs:    getEnvInt("OWEHOST_BRUTEFORCE_DISTRIBUTED_IPS", 10),
+				TrustedIPs:        getEnvList("OWEHOST_BRUTEFORCE_TRUSTED_IPS", []string{"127.0.0.1", "::1"}),
+			},
 		},
 		Admin: AdminConfig{
 			Username: getEnv("OWEHOST_ADMIN_USERNAME", "admin"),
@@ -147,3 +252,29 @@ func getEnvBool(key string, defaultValue bool) bool {
 	}
 	return defaultValue
 }
+
+func getEnvList(key string, defaultValue []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// getEnvMap reads "key=value" pairs separated by commas
+func getEnvMap(key string) map[string]string {
+	m := make(map[string]string)
+	for _, pair := range getEnvList(key, nil) {
+		k, v, ok := strings.Cut(pair, "=")
+		if k, v = strings.TrimSpace(k), strings.TrimSpace(v); ok && k != "" && v != "" {
+			m[k] = v
+		}
+	}
+	return m
+}
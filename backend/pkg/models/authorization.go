@@ -48,7 +48,16 @@ type PolicyRule struct {
 	UpdatedAt   time.Time              `json:"updated_at"`
 }
 
-// TimeRestriction represents time-based restrictions for a policy
+// Policy rule effects
+const (
+	PolicyEffectAllow = "allow"
+	PolicyEffectDeny  = "deny"
+)
+
+// TimeRestriction limits a policy rule to a window of the week: the days
+// named ("mon" or "monday"), from StartTime to EndTime ("15:04") in
+// Timezone (UTC if empty). A window ending before it starts runs past
+// midnight into the next day.
 type TimeRestriction struct {
 	StartTime string   `json:"start_time"`
 	EndTime   string   `json:"end_time"`
@@ -83,16 +92,34 @@ type PolicyRuleCreateRequest struct {
 	TimeRestriction *TimeRestriction       `json:"time_restriction,omitempty"`
 }
 
-// AuthorizationCheckRequest represents an authorization check request
+// AuthorizationCheckRequest represents an authorization check request.
+// Explain asks for every rule considered, not just the one that decided.
 type AuthorizationCheckRequest struct {
 	UserID   string                 `json:"user_id" validate:"required"`
 	Resource string                 `json:"resource" validate:"required"`
 	Action   string                 `json:"action" validate:"required"`
 	Context  map[string]interface{} `json:"context,omitempty"`
+	Explain  bool                   `json:"explain,omitempty"`
 }
 
-// AuthorizationCheckResponse represents an authorization check response
+// AuthorizationCheckResponse represents an authorization check response.
+// DecidedBy is "policy" when a policy rule decided, naming it in RuleID,
+// and "rbac" when none applied and the user's roles did.
 type AuthorizationCheckResponse struct {
-	Allowed bool   `json:"allowed"`
-	Reason  string `json:"reason,omitempty"`
+	Allowed   bool              `json:"allowed"`
+	Reason    string            `json:"reason,omitempty"`
+	DecidedBy string            `json:"decided_by,omitempty"`
+	RuleID    string            `json:"rule_id,omitempty"`
+	Trace     []PolicyRuleTrace `json:"trace,omitempty"`
+}
+
+// PolicyRuleTrace is how one policy rule fared in an explained check, in
+// the order the rules were tried
+type PolicyRuleTrace struct {
+	RuleID   string `json:"rule_id"`
+	Name     string `json:"name"`
+	Effect   string `json:"effect"`
+	Priority int    `json:"priority"`
+	Matched  bool   `json:"matched"`
+	Reason   string `json:"reason"`
 }
@@ -17,6 +17,8 @@ type GitRepository struct {
 	IsPrivate     bool       `json:"is_private"`
 	AutoDeploy    bool       `json:"auto_deploy"`
 	DeployPath    *string    `json:"deploy_path,omitempty"`
+	BuildCommands []string   `json:"build_commands,omitempty"`
+	BuildTimeout  int        `json:"build_timeout,omitempty"`
 	LastPullAt    *time.Time `json:"last_pull_at,omitempty"`
 	LastDeployAt  *time.Time `json:"last_deploy_at,omitempty"`
 	CreatedAt     time.Time  `json:"created_at"`
@@ -30,6 +32,10 @@ type GitRepositoryCreateRequest struct {
 	DomainID    *string `json:"domain_id"`
 	AutoDeploy  bool    `json:"auto_deploy"`
 	DeployPath  *string `json:"deploy_path"`
+	// BuildCommands run in each new release before it goes live, e.g. "composer install"
+	BuildCommands []string `json:"build_commands"`
+	// BuildTimeout limits all build commands together, in seconds
+	BuildTimeout int `json:"build_timeout"`
 }
 
 // GitRepositoryUpdateRequest represents a request to update a repository
@@ -38,16 +44,21 @@ type GitRepositoryUpdateRequest struct {
 	AutoDeploy    *bool   `json:"auto_deploy"`
 	DeployPath    *string `json:"deploy_path"`
 	DefaultBranch *string `json:"default_branch"`
+	// BuildCommands replaces the build commands when set; an empty list clears them
+	BuildCommands []string `json:"build_commands"`
+	BuildTimeout  *int     `json:"build_timeout"`
 }
 
 // GitCloneRequest represents a request to clone a remote repository
 type GitCloneRequest struct {
-	Name       string  `json:"name" validate:"required"`
-	RemoteURL  string  `json:"remote_url" validate:"required,url"`
-	Branch     string  `json:"branch"`
-	DomainID   *string `json:"domain_id"`
-	AutoDeploy bool    `json:"auto_deploy"`
-	DeployPath *string `json:"deploy_path"`
+	Name          string   `json:"name" validate:"required"`
+	RemoteURL     string   `json:"remote_url" validate:"required,url"`
+	Branch        string   `json:"branch"`
+	DomainID      *string  `json:"domain_id"`
+	AutoDeploy    bool     `json:"auto_deploy"`
+	DeployPath    *string  `json:"deploy_path"`
+	BuildCommands []string `json:"build_commands"`
+	BuildTimeout  int      `json:"build_timeout"`
 }
 
 // DeployKey represents a deploy key for a repository
@@ -68,34 +79,66 @@ type DeployKeyCreateRequest struct {
 	ReadOnly  bool   `json:"read_only"`
 }
 
-// GitWebhook represents a webhook for a repository
+// Git webhook providers
+const (
+	GitProviderGitHub = "github"
+	GitProviderGitLab = "gitlab"
+	GitProviderGitea  = "gitea"
+)
+
+// GitWebhook represents an inbound webhook that a Git provider calls on push
 type GitWebhook struct {
-	ID           string    `json:"id"`
-	RepositoryID string    `json:"repository_id"`
-	URL          string    `json:"url"`
-	Events       []string  `json:"events"`
-	Secret       string    `json:"-"`
-	Enabled      bool      `json:"enabled"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID             string     `json:"id"`
+	RepositoryID   string     `json:"repository_id"`
+	Provider       string     `json:"provider"`
+	URL            string     `json:"url"`
+	Branch         string     `json:"branch,omitempty"`
+	Events         []string   `json:"events"`
+	Secret         string     `json:"-"`
+	Enabled        bool       `json:"enabled"`
+	LastDeliveryAt *time.Time `json:"last_delivery_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
 }
 
 // GitWebhookCreateRequest represents a request to create a webhook
 type GitWebhookCreateRequest struct {
-	URL    string   `json:"url" validate:"required,url"`
-	Events []string `json:"events" validate:"required,min=1"`
-	Secret string   `json:"secret"`
+	Provider string `json:"provider" validate:"required,oneof=github gitlab gitea"`
+	// Branch defaults to the repository's default branch
+	Branch string   `json:"branch"`
+	Events []string `json:"events"`
+	// Secret is generated when empty
+	Secret string `json:"secret"`
 }
 
+// GitWebhookCreateResponse returns the webhook secret once, at creation
+type GitWebhookCreateResponse struct {
+	*GitWebhook
+	Secret string `json:"secret"`
+}
+
+// Deployment statuses
+const (
+	DeploymentPending = "pending"
+	DeploymentRunning = "running"
+	DeploymentSuccess = "success"
+	DeploymentFailed  = "failed"
+)
+
 // DeploymentInfo represents information about a deployment
 type DeploymentInfo struct {
-	ID           string    `json:"id"`
-	RepositoryID string    `json:"repository_id"`
-	Branch       string    `json:"branch"`
-	Commit       string    `json:"commit"`
-	Status       string    `json:"status"`
-	Message      string    `json:"message,omitempty"`
-	DeployedAt   time.Time `json:"deployed_at"`
+	ID           string     `json:"id"`
+	RepositoryID string     `json:"repository_id"`
+	Branch       string     `json:"branch"`
+	Commit       string     `json:"commit"`
+	Author       string     `json:"author,omitempty"`
+	AuthorEmail  string     `json:"author_email,omitempty"`
+	Status       string     `json:"status"`
+	Trigger      string     `json:"trigger"`
+	Message      string     `json:"message,omitempty"`
+	Log          string     `json:"log,omitempty"`
+	StartedAt    time.Time  `json:"started_at"`
+	DeployedAt   *time.Time `json:"deployed_at,omitempty"`
 }
 
 // GitCommit represents a Git commit
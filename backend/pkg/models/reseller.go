@@ -13,7 +13,9 @@ type Reseller struct {
 	UpdatedAt        time.Time `json:"updated_at"`
 }
 
-// ResourcePool represents the resource allocation for a reseller
+// ResourcePool represents the resource allocation for a reseller.
+// MaxUsers caps the reseller's hosting accounts. A max of 0 leaves the
+// resource uncapped.
 type ResourcePool struct {
 	MaxUsers       int   `json:"max_users"`
 	MaxDomains     int   `json:"max_domains"`
@@ -22,6 +24,54 @@ type ResourcePool struct {
 	MaxDatabases   int   `json:"max_databases"`
 	MaxCPUQuota    int   `json:"max_cpu_quota"`
 	MaxMemoryMB    int64 `json:"max_memory_mb"`
+	Oversell       OversellRatios `json:"oversell"`
+}
+
+// OversellRatios let a reseller allocate more of a resource than its pool
+// holds, since accounts rarely use all they are given: 1.5 lets
+// allocations reach 150% of the pool. 0 means 1, no overselling.
+type OversellRatios struct {
+	Disk      float64 `json:"disk"`
+	Bandwidth float64 `json:"bandwidth"`
+	Accounts  float64 `json:"accounts"`
+	Domains   float64 `json:"domains"`
+	Databases float64 `json:"databases"`
+}
+
+// ResourceAllocation is an amount of each pooled resource. -1 stands for
+// unlimited.
+type ResourceAllocation struct {
+	Accounts    int   `json:"accounts"`
+	DiskMB      int64 `json:"disk_mb"`
+	BandwidthMB int64 `json:"bandwidth_mb"`
+	Domains     int   `json:"domains"`
+	Databases   int   `json:"databases"`
+}
+
+// ResellerUsageReport shows how much of a reseller's pool is handed out to
+// the accounts of the reseller and the resellers below it, and how much
+// those accounts use
+type ResellerUsageReport struct {
+	ResellerID  string                 `json:"reseller_id"`
+	UserID      string                 `json:"user_id"`
+	Name        string                 `json:"name"`
+	Pool        ResourcePool           `json:"pool"`
+	Allocatable ResourceAllocation     `json:"allocatable"`
+	Allocated   ResourceAllocation     `json:"allocated"`
+	Used        ResourceAllocation     `json:"used"`
+	Accounts    []ResellerAccountUsage `json:"accounts"`
+	GeneratedAt time.Time              `json:"generated_at"`
+}
+
+// ResellerAccountUsage is one account's line in a reseller usage report
+type ResellerAccountUsage struct {
+	AccountID int                `json:"account_id"`
+	Name      string             `json:"name"`
+	Owner     string             `json:"owner"`
+	Plan      string             `json:"plan"`
+	Package   string             `json:"package,omitempty"`
+	Allocated ResourceAllocation `json:"allocated"`
+	Used      ResourceAllocation `json:"used"`
 }
 
 // ResellerCreateRequest represents a request to create a reseller
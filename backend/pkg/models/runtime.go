@@ -13,23 +13,38 @@ const (
 	RuntimeTypeJava   RuntimeType = "java"
 )
 
-// PHPPool represents a PHP-FPM pool configuration
+// PHPPool represents a PHP-FPM pool configuration. One pool serves all
+// sites of an account on the same PHP version.
 type PHPPool struct {
 	ID            string    `json:"id"`
 	UserID        string    `json:"user_id"`
+	AccountID     int       `json:"account_id"`
 	Version       string    `json:"version"`
 	PoolName      string    `json:"pool_name"`
 	SocketPath    string    `json:"socket_path"`
+	PMMode        string    `json:"pm_mode"` // static, dynamic, ondemand
 	MaxChildren   int       `json:"max_children"`
 	StartServers  int       `json:"start_servers"`
 	MinSpareServers int     `json:"min_spare_servers"`
 	MaxSpareServers int     `json:"max_spare_servers"`
+	MaxRequests   int       `json:"max_requests"`
+	ProcessIdleTimeout int  `json:"process_idle_timeout"`      // seconds, ondemand only
+	RequestTerminateTimeout int `json:"request_terminate_timeout"` // seconds, 0 = unlimited
+	OpenBasedir   []string  `json:"open_basedir,omitempty"`      // Extra paths inside the account
+	DisableFunctions []string `json:"disable_functions,omitempty"` // Added to the baseline list
 	Extensions    []string  `json:"extensions"`
 	INIOverrides  map[string]string `json:"ini_overrides"`
 	CreatedAt     time.Time `json:"created_at"`
 	UpdatedAt     time.Time `json:"updated_at"`
 }
 
+// PHP-FPM process manager modes
+const (
+	PHPPMStatic   = "static"
+	PHPPMDynamic  = "dynamic"
+	PHPPMOndemand = "ondemand"
+)
+
 // NodeJSApp represents a Node.js application
 type NodeJSApp struct {
 	ID           string    `json:"id"`
@@ -41,8 +56,11 @@ type NodeJSApp struct {
 	StartFile    string    `json:"start_file"`
 	Port         int       `json:"port"`
 	Environment  map[string]string `json:"environment"`
+	AutoRestart  bool      `json:"auto_restart"`
+	MaxRestarts  int       `json:"max_restarts"`
 	Running      bool      `json:"running"`
 	PID          *int      `json:"pid,omitempty"`
+	Status       AppProcessStatus `json:"status"`
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
 }
@@ -59,11 +77,35 @@ type PythonApp struct {
 	WSGIFile     string    `json:"wsgi_file"`
 	Port         int       `json:"port"`
 	Environment  map[string]string `json:"environment"`
+	AutoRestart  bool      `json:"auto_restart"`
+	MaxRestarts  int       `json:"max_restarts"`
 	Running      bool      `json:"running"`
+	PID          *int      `json:"pid,omitempty"`
+	Status       AppProcessStatus `json:"status"`
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
 }
 
+// AppProcessStatus describes the supervised process behind an application
+type AppProcessStatus struct {
+	State       string     `json:"state"` // stopped, starting, running, backoff, failed
+	Restarts    int        `json:"restarts"`
+	Healthy     bool       `json:"healthy"`
+	LastCheckAt *time.Time `json:"last_check_at,omitempty"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	LogPath     string     `json:"log_path,omitempty"`
+}
+
+// Application process states
+const (
+	AppStateStopped  = "stopped"
+	AppStateStarting = "starting"
+	AppStateRunning  = "running"
+	AppStateBackoff  = "backoff"
+	AppStateFailed   = "failed"
+)
+
 // RuntimeVersion represents an available runtime version
 type RuntimeVersion struct {
 	Type      RuntimeType `json:"type"`
@@ -83,7 +125,16 @@ type PHPExtension struct {
 // PHPPoolCreateRequest represents a request to create a PHP pool
 type PHPPoolCreateRequest struct {
 	Version       string            `json:"version" validate:"required"`
+	PMMode        string            `json:"pm_mode,omitempty"`
 	MaxChildren   int               `json:"max_children" validate:"min=1,max=100"`
+	StartServers  int               `json:"start_servers,omitempty"`
+	MinSpareServers int             `json:"min_spare_servers,omitempty"`
+	MaxSpareServers int             `json:"max_spare_servers,omitempty"`
+	MaxRequests   *int              `json:"max_requests,omitempty"`
+	ProcessIdleTimeout int          `json:"process_idle_timeout,omitempty"`
+	RequestTerminateTimeout *int    `json:"request_terminate_timeout,omitempty"`
+	OpenBasedir   []string          `json:"open_basedir,omitempty"`
+	DisableFunctions []string       `json:"disable_functions,omitempty"`
 	Extensions    []string          `json:"extensions,omitempty"`
 	INIOverrides  map[string]string `json:"ini_overrides,omitempty"`
 }
@@ -96,6 +147,8 @@ type NodeJSAppCreateRequest struct {
 	AppRoot     string            `json:"app_root" validate:"required"`
 	StartFile   string            `json:"start_file" validate:"required"`
 	Environment map[string]string `json:"environment,omitempty"`
+	AutoRestart *bool             `json:"auto_restart,omitempty"`
+	MaxRestarts *int              `json:"max_restarts,omitempty"`
 }
 
 // PythonAppCreateRequest represents a request to create a Python app
@@ -106,4 +159,88 @@ type PythonAppCreateRequest struct {
 	AppRoot     string            `json:"app_root" validate:"required"`
 	WSGIFile    string            `json:"wsgi_file" validate:"required"`
 	Environment map[string]string `json:"environment,omitempty"`
+	AutoRestart *bool             `json:"auto_restart,omitempty"`
+	MaxRestarts *int              `json:"max_restarts,omitempty"`
+}
+
+// SiteReleaseSettingsRequest configures release-based deploys for a site
+type SiteReleaseSettingsRequest struct {
+	Enabled     bool     `json:"enabled"`
+	Keep        int      `json:"keep,omitempty"`
+	SharedPaths []string `json:"shared_paths,omitempty"`
+}
+
+// SiteRollbackRequest selects the release to roll back to; empty means the previous one
+type SiteRollbackRequest struct {
+	ReleaseID string `json:"release_id,omitempty"`
+}
+
+// SiteCacheSettingsRequest configures microcaching for a site
+type SiteCacheSettingsRequest struct {
+	Enabled       bool     `json:"enabled"`
+	TTL           int      `json:"ttl,omitempty"`
+	BypassCookies []string `json:"bypass_cookies,omitempty"`
+	BypassPaths   []string `json:"bypass_paths,omitempty"`
+}
+
+// SiteCachePurgeRequest selects the cached pages to purge; an empty path purges the whole site
+type SiteCachePurgeRequest struct {
+	Path string `json:"path,omitempty"`
+}
+
+// SiteRateLimit limits requests and connections per client IP under a path
+type SiteRateLimit struct {
+	Path        string `json:"path" validate:"required"`
+	Rate        int    `json:"rate,omitempty"` // Requests per minute
+	Burst       int    `json:"burst,omitempty"`
+	Connections int    `json:"connections,omitempty"`
+}
+
+// SiteRateLimitsRequest replaces the rate limits of a site
+type SiteRateLimitsRequest struct {
+	Limits []SiteRateLimit `json:"limits"`
+}
+
+// SiteProtectedPath is a password-protected directory of a site and its users
+type SiteProtectedPath struct {
+	Path  string   `json:"path"`
+	Realm string   `json:"realm,omitempty"`
+	Users []string `json:"users"`
+}
+
+// SiteProtectedPathRequest protects a directory of a site or changes its realm
+type SiteProtectedPathRequest struct {
+	Path  string `json:"path" validate:"required"`
+	Realm string `json:"realm,omitempty"`
+}
+
+// SiteAuthUserRequest adds a user to a protected directory or changes its password
+type SiteAuthUserRequest struct {
+	Path     string `json:"path" validate:"required"`
+	Username string `json:"username" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+// SiteAccessRule restricts a directory of a site by client IP
+type SiteAccessRule struct {
+	Path  string   `json:"path" validate:"required"`
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+}
+
+// SiteAccessRulesRequest replaces the IP access rules of a site
+type SiteAccessRulesRequest struct {
+	Rules []SiteAccessRule `json:"rules"`
+}
+
+// SiteWAFRequest configures the web application firewall of a site
+type SiteWAFRequest struct {
+	Enabled       bool   `json:"enabled"`
+	Mode          string `json:"mode,omitempty"` // detection or blocking
+	ExcludedRules []int  `json:"excluded_rules,omitempty"`
+}
+
+// SiteWAFExclusionRequest switches off one firewall rule for a site
+type SiteWAFExclusionRequest struct {
+	RuleID int `json:"rule_id" validate:"required"`
 }
@@ -98,6 +98,19 @@ type UserStatsSummary struct {
 	DatabasesLimit   int     `json:"databases_limit"`
 }
 
+// TrafficBreakdown represents where a domain's traffic came from and how
+// it was answered over a period
+type TrafficBreakdown struct {
+	DomainID         string           `json:"domain_id"`
+	StartDate        time.Time        `json:"start_date"`
+	EndDate          time.Time        `json:"end_date"`
+	TopPages         []TopPage        `json:"top_pages"`
+	TopReferrers     []TopReferrer    `json:"top_referrers"`
+	StatusCodes      map[string]int64 `json:"status_codes"`
+	Browsers         []BrowserStat    `json:"browsers"`
+	OperatingSystems []OSStat         `json:"operating_systems"`
+}
+
 // TopPage represents a top visited page
 type TopPage struct {
 	Path      string `json:"path"`
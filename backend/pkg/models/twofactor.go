@@ -41,8 +41,26 @@ type TOTPVerifyRequest struct {
 
 // TwoFactorLoginRequest represents a 2FA login verification request
 type TwoFactorLoginRequest struct {
-	Token string `json:"token"`  // temporary token from initial login
-	Code  string `json:"code"`   // TOTP code or backup code
+	Token          string `json:"token"`  // temporary token from initial login
+	Code           string `json:"code"`   // TOTP code or backup code
+	RememberDevice bool   `json:"remember_device,omitempty"`
+}
+
+// TwoFactorPolicy says who must use 2FA. Owners are tenant IDs of panel
+// users or owners of accounts, e.g. "reseller-22".
+type TwoFactorPolicy struct {
+	Roles     []UserRole `json:"roles"`
+	Owners    []string   `json:"owners"`
+	UpdatedAt time.Time  `json:"updated_at,omitempty"`
+}
+
+// TwoFactorChallenge is the answer to a login that needs a second factor
+type TwoFactorChallenge struct {
+	MFARequired   bool      `json:"mfa_required"`
+	SetupRequired bool      `json:"mfa_setup_required,omitempty"` // enforced but not set up yet
+	Methods       []string  `json:"methods,omitempty"` // second factors the user can answer with
+	Ticket        string    `json:"mfa_ticket"`
+	ExpiresAt     time.Time `json:"expires_at"`
 }
 
 // TwoFactorDisableRequest represents a request to disable 2FA
@@ -66,7 +84,9 @@ type WebAuthnCredential struct {
 	PublicKey    []byte    `json:"-"`
 	AAGUID       []byte    `json:"-"`
 	SignCount    uint32    `json:"sign_count"`
+	Transports   []string  `json:"transports,omitempty"`
 	LastUsedAt   *time.Time `json:"last_used_at,omitempty"`
+	CloneDetectedAt *time.Time `json:"clone_detected_at,omitempty"` // the counter went backwards; the credential is refused
 	CreatedAt    time.Time `json:"created_at"`
 }
 
@@ -80,6 +100,7 @@ type LoginAttempt struct {
 	Success      bool      `json:"success"`
 	FailReason   string    `json:"fail_reason,omitempty"`
 	TwoFactorUsed bool     `json:"two_factor_used"`
+	Step         string    `json:"step,omitempty"` // password, 2fa or remembered_device
 	CreatedAt    time.Time `json:"created_at"`
 }
 
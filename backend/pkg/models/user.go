@@ -21,6 +21,7 @@ const (
 	UserRoleAdmin    UserRole = "admin"
 	UserRoleReseller UserRole = "reseller"
 	UserRoleUser     UserRole = "user"
+	UserRoleAccount  UserRole = "account"
 )
 
 // User represents a user account in the system
@@ -52,6 +53,10 @@ type Session struct {
 	CreatedAt       time.Time `json:"created_at"`
 	ExpiresAt       time.Time `json:"expires_at"`
 	LastAccessedAt  time.Time `json:"last_accessed_at"`
+	RevokedAt       *time.Time `json:"revoked_at,omitempty"`
+	RevokeReason    string    `json:"revoke_reason,omitempty"`
+	// ImpersonatorID is the admin or reseller acting as the user, if any
+	ImpersonatorID  string    `json:"impersonator_id,omitempty"`
 }
 
 // APIKey represents an API key for authentication
@@ -66,6 +71,8 @@ type APIKey struct {
 	CreatedAt   time.Time `json:"created_at"`
 	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
 	LastUsedAt  *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP  string     `json:"last_used_ip,omitempty"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
 }
 
 // PasswordReset represents a password reset request
@@ -96,8 +103,9 @@ type UserUpdateRequest struct {
 
 // LoginRequest represents a login request
 type LoginRequest struct {
-	Username string `json:"username" validate:"required"`
-	Password string `json:"password" validate:"required"`
+	Username    string `json:"username" validate:"required"`
+	Password    string `json:"password" validate:"required"`
+	DeviceToken string `json:"device_token,omitempty"` // remembered device, skips 2FA
 }
 
 // LoginResponse represents a login response